@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/tracing"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
 	"github.com/spf13/pflag"
 )
 
+// validateConnectionTimeout limits how long validate-config waits for the
+// session store connection
+const validateConnectionTimeout = 10 * time.Second
+
+// tracingShutdownTimeout limits how long the remaining spans are exported
+// for after the server stopped
+const tracingShutdownTimeout = 5 * time.Second
+
 func main() {
 	logger.SetFlags(logger.Lshortfile)
 
@@ -26,6 +39,7 @@ func main() {
 	config := configFlagSet.String("config", "", "path to config file")
 	alphaConfig := configFlagSet.String("alpha-config", "", "path to alpha config file (use at your own risk - the structure in this config file may change between minor releases)")
 	convertConfig := configFlagSet.Bool("convert-config-to-alpha", false, "if true, the proxy will load configuration as normal and convert existing configuration to the alpha config structure, and print it to stdout")
+	validateConfig := configFlagSet.Bool("validate-config", false, "if true, the proxy will load and validate the configuration, including OIDC discovery and the session store connection, then exit without starting the server")
 	showVersion := configFlagSet.Bool("version", false, "print version string")
 	configFlagSet.Parse(os.Args[1:])
 
@@ -38,6 +52,10 @@ func main() {
 		logger.Fatal("cannot use alpha-config and convert-config-to-alpha together")
 	}
 
+	if *convertConfig && *validateConfig {
+		logger.Fatal("cannot use validate-config and convert-config-to-alpha together")
+	}
+
 	opts, err := loadConfiguration(*config, *alphaConfig, configFlagSet, os.Args[1:])
 	if err != nil {
 		logger.Fatalf("ERROR: %v", err)
@@ -50,10 +68,32 @@ func main() {
 		return
 	}
 
+	if *validateConfig {
+		if err := validateConfiguration(opts); err != nil {
+			logger.Fatalf("%s", err)
+		}
+		fmt.Println("configuration is valid")
+		return
+	}
+
 	if err = validation.Validate(opts); err != nil {
 		logger.Fatalf("%s", err)
 	}
 
+	if opts.TracingOTLPEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(context.Background(), opts.TracingOTLPEndpoint, opts.TracingSampleRatio)
+		if err != nil {
+			logger.Fatalf("ERROR: Failed to set up tracing: %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				logger.Errorf("error exporting the remaining traces: %v", err)
+			}
+		}()
+	}
+
 	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
 	oauthproxy, err := NewOAuthProxy(opts, validator)
 	if err != nil {
@@ -137,6 +177,41 @@ func loadOptions(config string, extraFlags *pflag.FlagSet, args []string) (*opti
 	return opts, nil
 }
 
+// validateConfiguration validates the options as when starting the proxy,
+// then checks that the providers and session store can be initialised, which
+// performs OIDC discovery and connects to the session store.
+func validateConfiguration(opts *options.Options) error {
+	if err := validation.Validate(opts); err != nil {
+		return err
+	}
+
+	var msgs []string
+	for _, providerConfig := range opts.Providers {
+		provider, err := providers.NewProvider(providerConfig)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("error initialising provider %q: %v", providerConfig.ID, err))
+			continue
+		}
+		provider.Data().StopWatching()
+	}
+
+	sessionStore, err := sessions.NewSessionStore(&opts.Session, &opts.Cookie)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("error initialising session store: %v", err))
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), validateConnectionTimeout)
+		defer cancel()
+		if err := sessionStore.VerifyConnection(ctx); err != nil {
+			msgs = append(msgs, fmt.Sprintf("error connecting to the %s session store: %v", opts.Session.Type, err))
+		}
+	}
+
+	if len(msgs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
+
 // printConvertedConfig extracts alpha options from the loaded configuration
 // and renders these to stdout in YAML format.
 func printConvertedConfig(opts *options.Options) error {
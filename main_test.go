@@ -3,10 +3,13 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -35,6 +38,12 @@ upstreamConfig:
     passHostHeader: true
     proxyWebSockets: true
     timeout: 30s
+  transport:
+    maxIdleConns: 100
+    maxIdleConnsPerHost: 2
+    maxConnsPerHost: 0
+    idleConnTimeout: 90s
+    maxResponseHeaderBytes: 10485760
 injectRequestHeaders:
 - name: Authorization
   values:
@@ -77,6 +86,7 @@ providers:
     insecureSkipNonce: true
     audienceClaims: [aud]
     extraAudiences: []
+    jwtLeeway: 10s
   loginURLParameters:
   - name: approval_prompt
     default:
@@ -95,6 +105,14 @@ redirect_url="http://localhost:4180/oauth2/callback"
 		return &b
 	}
 
+	intPtr := func(i int) *int {
+		return &i
+	}
+
+	int64Ptr := func(i int64) *int64 {
+		return &i
+	}
+
 	durationPtr := func(d time.Duration) *options.Duration {
 		du := options.Duration(d)
 		return &du
@@ -121,6 +139,13 @@ redirect_url="http://localhost:4180/oauth2/callback"
 					Timeout:         durationPtr(options.DefaultUpstreamTimeout),
 				},
 			},
+			Transport: options.UpstreamTransport{
+				MaxIdleConns:           intPtr(options.DefaultUpstreamMaxIdleConns),
+				MaxIdleConnsPerHost:    intPtr(options.DefaultUpstreamMaxIdleConnsPerHost),
+				MaxConnsPerHost:        intPtr(0),
+				IdleConnTimeout:        durationPtr(options.DefaultUpstreamIdleConnTimeout),
+				MaxResponseHeaderBytes: int64Ptr(options.DefaultUpstreamMaxResponseHeaderBytes),
+			},
 		}
 
 		authHeader := options.Header{
@@ -141,6 +166,7 @@ redirect_url="http://localhost:4180/oauth2/callback"
 		opts.InjectRequestHeaders = append([]options.Header{authHeader}, opts.InjectRequestHeaders...)
 		opts.InjectResponseHeaders = append(opts.InjectResponseHeaders, authHeader)
 
+		jwtLeeway := options.Duration(options.DefaultOIDCJWTLeeway)
 		opts.Providers = options.Providers{
 			options.Provider{
 				ID:           "google=oauth2-proxy",
@@ -157,6 +183,7 @@ redirect_url="http://localhost:4180/oauth2/callback"
 					AudienceClaims:    []string{"aud"},
 					ExtraAudiences:    []string{},
 					InsecureSkipNonce: true,
+					JWTLeeway:         &jwtLeeway,
 				},
 				LoginURLParameters: []options.LoginURLParameter{
 					{Name: "approval_prompt", Default: []string{"force"}},
@@ -256,3 +283,96 @@ redirect_url="http://localhost:4180/oauth2/callback"
 		}),
 	)
 })
+
+var _ = Describe("Configuration Validation Suite", func() {
+	const testConfig = `
+cookie_secret="OQINaROshtE9TcZkNAm-5Zs2Pv3xaWytBmc5W7sPX7w="
+email_domains="example.com"
+upstreams="http://httpbin"
+client_id="oauth2-proxy"
+client_secret="b2F1dGgyLXByb3h5LWNsaWVudC1zZWNyZXQK"
+`
+
+	var (
+		redisServer *miniredis.Miniredis
+		oidcServer  *httptest.Server
+	)
+
+	BeforeEach(func() {
+		var err error
+		redisServer, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+
+		// An issuer that fails OIDC discovery
+		oidcServer = httptest.NewServer(http.NotFoundHandler())
+	})
+
+	AfterEach(func() {
+		redisServer.Close()
+		oidcServer.Close()
+	})
+
+	type validateConfigurationTableInput struct {
+		config         func() string
+		expectedErrors []string
+	}
+
+	DescribeTable("validateConfiguration",
+		func(in validateConfigurationTableInput) {
+			configFile, err := os.CreateTemp("", "oauth2-proxy-test-config-file")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(configFile.Name())
+			_, err = configFile.WriteString(in.config())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configFile.Close()).To(Succeed())
+
+			opts, err := loadConfiguration(configFile.Name(), "", pflag.NewFlagSet("oauth2-proxy", pflag.ContinueOnError), []string{})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = validateConfiguration(opts)
+			if len(in.expectedErrors) == 0 {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid configuration:\n"))
+			for _, expected := range in.expectedErrors {
+				Expect(err.Error()).To(ContainSubstring(expected))
+			}
+		},
+		Entry("with a valid configuration", validateConfigurationTableInput{
+			config: func() string { return testConfig },
+		}),
+		Entry("with a valid redis session store", validateConfigurationTableInput{
+			config: func() string {
+				return testConfig + fmt.Sprintf("session_store_type=\"redis\"\nredis_connection_url=\"redis://%s\"\n", redisServer.Addr())
+			},
+		}),
+		Entry("with a missing cookie secret and client ID", validateConfigurationTableInput{
+			config: func() string {
+				return strings.NewReplacer(
+					`cookie_secret="OQINaROshtE9TcZkNAm-5Zs2Pv3xaWytBmc5W7sPX7w="`, "",
+					`client_id="oauth2-proxy"`, "",
+				).Replace(testConfig)
+			},
+			expectedErrors: []string{
+				"missing setting: cookie-secret",
+				"provider missing setting: client-id",
+			},
+		}),
+		Entry("with an unreachable redis session store", validateConfigurationTableInput{
+			config: func() string {
+				addr := redisServer.Addr()
+				redisServer.Close()
+				return testConfig + fmt.Sprintf("session_store_type=\"redis\"\nredis_connection_url=\"redis://%s\"\n", addr)
+			},
+			expectedErrors: []string{"unable to set a redis initialization key"},
+		}),
+		Entry("with an OIDC provider that fails discovery", validateConfigurationTableInput{
+			config: func() string {
+				return testConfig + fmt.Sprintf("provider=\"oidc\"\noidc_issuer_url=%q\n", oidcServer.URL)
+			},
+			expectedErrors: []string{"error initialising provider", "failed to discover OIDC configuration"},
+		}),
+	)
+})
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,7 +25,9 @@ import (
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/redirect"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/apikey"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/basic"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cookies"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	proxyhttp "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/http"
@@ -32,10 +36,14 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
+	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/tracing"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const (
@@ -50,6 +58,26 @@ const (
 	oauthCallbackPath = "/callback"
 	authOnlyPath      = "/auth"
 	userInfoPath      = "/userinfo"
+	deviceStartPath   = "/device/start"
+	devicePollPath    = "/device/poll"
+
+	adminInvalidateSessionsPath = "/admin/sessions/invalidate"
+
+	// tokenRevocationTimeout limits how long signing out waits for the
+	// provider to revoke the session tokens
+	tokenRevocationTimeout = 5 * time.Second
+
+	// tokenExchangeLockTimeout limits how long a refresh token grant for an
+	// upstream waits to obtain the session lock
+	tokenExchangeLockTimeout = 5 * time.Second
+
+	// tokenExchangeLockDuration is how long the session lock is held while
+	// the refresh token is used for an upstream
+	tokenExchangeLockDuration = 2 * time.Second
+
+	// tokenExchangeLockRetryPeriod is the delay between attempts to obtain
+	// the session lock
+	tokenExchangeLockRetryPeriod = 10 * time.Millisecond
 )
 
 var (
@@ -62,7 +90,8 @@ var (
 
 // allowedRoute manages method + path based allowlists
 type allowedRoute struct {
-	method    string
+	// methods the route allows, all methods are allowed when it is empty
+	methods   []string
 	negate    bool
 	pathRegex *regexp.Regexp
 }
@@ -71,6 +100,13 @@ type apiRoute struct {
 	pathRegex *regexp.Regexp
 }
 
+// ajaxHeader is a request header that marks AJAX requests. Without a value,
+// any value of the header matches.
+type ajaxHeader struct {
+	name  string
+	value string
+}
+
 // OAuthProxy is the main authentication proxy
 type OAuthProxy struct {
 	CookieOptions *options.Cookie
@@ -78,26 +114,42 @@ type OAuthProxy struct {
 
 	SignInPath string
 
-	allowedRoutes       []allowedRoute
-	apiRoutes           []apiRoute
-	redirectURL         *url.URL // the url to receive requests at
-	whitelistDomains    []string
-	provider            providers.Provider
-	sessionStore        sessionsapi.SessionStore
-	ProxyPrefix         string
-	basicAuthValidator  basic.Validator
-	basicAuthGroups     []string
-	SkipProviderButton  bool
-	skipAuthPreflight   bool
-	skipJwtBearerTokens bool
-	forceJSONErrors     bool
-	realClientIPParser  ipapi.RealClientIPParser
-	trustedIPs          *ip.NetSet
+	allowedRoutes        []allowedRoute
+	apiRoutes            []apiRoute
+	ajaxHeaders          []ajaxHeader
+	redirectURL          *url.URL // the url to receive requests at
+	whitelistDomains     []string
+	provider             providers.Provider
+	sessionStore         sessionsapi.SessionStore
+	csrfStore            sessionsapi.StateStore
+	ProxyPrefix          string
+	basicAuthValidator   basic.Validator
+	basicAuthGroups      []string
+	SkipProviderButton   bool
+	silentReauth         bool
+	defaultRootAction    string
+	defaultRootRedirect  string
+	skipAuthPreflight    bool
+	skipJwtBearerTokens  bool
+	forceJSONErrors      bool
+	ajaxUnauthorizedCode int
+	callbackMaxBodySize  int64
+	realClientIPParser   ipapi.RealClientIPParser
+	trustedIPs           *ip.NetSet
+	trustedIPUser        string
+
+	userSessionsStore  sessionsapi.UserSessionsStore
+	adminAPIToken      string
+	adminAPIAllowedIPs *ip.NetSet
 
 	sessionChain      alice.Chain
 	headersChain      alice.Chain
+	authLimitChain    alice.Chain
+	callbackChain     alice.Chain
 	preAuthChain      alice.Chain
 	pageWriter        pagewriter.Writer
+	pageHeaders       http.Header
+	accessDeniedCode  int
 	server            proxyhttp.Server
 	upstreamProxy     http.Handler
 	serveMux          *mux.Router
@@ -107,11 +159,32 @@ type OAuthProxy struct {
 
 // NewOAuthProxy creates a new instance of OAuthProxy from the options provided
 func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthProxy, error) {
-	sessionStore, err := sessions.NewSessionStore(&opts.Session, &opts.Cookie)
+	// The sessions of the users are only indexed when they can be cleared
+	sessionOpts := opts.Session
+	sessionOpts.IndexUserSessions = opts.AdminAPIToken != ""
+	sessionStore, err := sessions.NewSessionStore(&sessionOpts, &opts.Cookie)
 	if err != nil {
 		return nil, fmt.Errorf("error initialising session store: %v", err)
 	}
 
+	var csrfStore sessionsapi.StateStore
+	if opts.Cookie.CSRFServerSide {
+		var ok bool
+		csrfStore, ok = sessionStore.(sessionsapi.StateStore)
+		if !ok {
+			return nil, fmt.Errorf("the %s session store cannot store the CSRF state server-side", opts.Session.Type)
+		}
+	}
+	var userSessionsStore sessionsapi.UserSessionsStore
+	if opts.AdminAPIToken != "" {
+		var ok bool
+		userSessionsStore, ok = sessionStore.(sessionsapi.UserSessionsStore)
+		if !ok {
+			return nil, fmt.Errorf("the %s session store cannot clear the sessions of users", opts.Session.Type)
+		}
+	}
+	sessionStore = sessions.NewInstrumentedSessionStore(sessionStore, opts.Session.Type, prometheus.DefaultRegisterer)
+
 	var basicAuthValidator basic.Validator
 	if opts.HtpasswdFile != "" {
 		logger.Printf("using htpasswd file: %s", opts.HtpasswdFile)
@@ -122,6 +195,23 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		}
 	}
 
+	var apiKeyValidator apikey.Validator
+	if opts.APIKeysFile != "" {
+		logger.Printf("using API keys file: %s", opts.APIKeysFile)
+		var err error
+		apiKeyValidator, err = apikey.NewFileValidator(opts.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not validate API keys: %v", err)
+		}
+	}
+
+	internaloidc.ConfigureJWKSCache(internaloidc.JWKSCacheOptions{
+		MaxIssuers:      opts.JWKSCacheSize,
+		RefreshInterval: opts.JWKSRefreshInterval,
+		RefreshJitter:   opts.JWKSRefreshJitter,
+		RefreshCooldown: opts.JWKSRefreshCooldown,
+	})
+
 	provider, err := providers.NewProvider(opts.Providers[0])
 	if err != nil {
 		return nil, fmt.Errorf("error initialising provider: %v", err)
@@ -137,21 +227,22 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		ProviderName:     buildProviderName(provider, opts.Providers[0].Name),
 		SignInMessage:    buildSignInMessage(opts),
 		DisplayLoginForm: basicAuthValidator != nil && opts.Templates.DisplayLoginForm,
+		ResponseHeaders:  opts.GetPageResponseHeaders(),
+
+		AccessDeniedContactURL: opts.Templates.AccessDeniedContactURL,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error initialising page writer: %v", err)
 	}
 
-	upstreamProxy, err := upstream.NewProxy(opts.UpstreamServers, opts.GetSignatureData(), pageWriter)
-	if err != nil {
-		return nil, fmt.Errorf("error initialising upstream proxy: %v", err)
-	}
-
 	if opts.SkipJwtBearerTokens {
 		logger.Printf("Skipping JWT tokens from configured OIDC issuer: %q", opts.Providers[0].OIDCConfig.IssuerURL)
 		for _, issuer := range opts.ExtraJwtIssuers {
 			logger.Printf("Skipping JWT tokens from extra JWT issuer: %q", issuer)
 		}
+		for _, header := range opts.JwtBearerHeaders {
+			logger.Printf("Reading JWT bearer tokens from header: %q", header)
+		}
 	}
 	redirectURL := opts.GetRedirectURL()
 	if redirectURL.Path == "" {
@@ -164,7 +255,7 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		refresh = fmt.Sprintf("after %s", opts.Cookie.Refresh)
 	}
 
-	logger.Printf("Cookie settings: name:%s secure(https):%v httponly:%v expiry:%s domains:%s path:%s samesite:%s refresh:%s", opts.Cookie.Name, opts.Cookie.Secure, opts.Cookie.HTTPOnly, opts.Cookie.Expire, strings.Join(opts.Cookie.Domains, ","), opts.Cookie.Path, opts.Cookie.SameSite, refresh)
+	logger.Printf("Cookie settings: name:%s secure(https):%v httponly:%v expiry:%s domains:%s path:%s samesite:%s refresh:%s", opts.Cookie.PrefixedName(), opts.Cookie.Secure, opts.Cookie.HTTPOnly, opts.Cookie.Expire, strings.Join(opts.Cookie.Domains, ","), opts.Cookie.Path, opts.Cookie.SameSite, refresh)
 
 	trustedIPs := ip.NewNetSet()
 	for _, ipStr := range opts.TrustedIPs {
@@ -175,6 +266,18 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		}
 	}
 
+	var adminAPIAllowedIPs *ip.NetSet
+	if len(opts.AdminAPIAllowedIPs) > 0 {
+		adminAPIAllowedIPs = ip.NewNetSet()
+		for _, ipStr := range opts.AdminAPIAllowedIPs {
+			if ipNet := ip.ParseIPNet(ipStr); ipNet != nil {
+				adminAPIAllowedIPs.AddIPNet(*ipNet)
+			} else {
+				return nil, fmt.Errorf("could not parse IP network (%s)", ipStr)
+			}
+		}
+	}
+
 	allowedRoutes, err := buildRoutesAllowlist(opts)
 	if err != nil {
 		return nil, err
@@ -189,12 +292,21 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 	if err != nil {
 		return nil, fmt.Errorf("could not build pre-auth chain: %v", err)
 	}
-	sessionChain := buildSessionChain(opts, provider, sessionStore, basicAuthValidator)
-	headersChain, err := buildHeadersChain(opts)
+	sessionChain := buildSessionChain(opts, provider, sessionStore, basicAuthValidator, apiKeyValidator)
+	headersChain, err := buildHeadersChain(opts, pageWriter)
 	if err != nil {
 		return nil, fmt.Errorf("could not build headers chain: %v", err)
 	}
 
+	authLimitChain := alice.New()
+	if opts.RateLimitRequestsPerSecond > 0 {
+		authLimitChain = authLimitChain.Append(middleware.NewRateLimiter(opts.RateLimitRequestsPerSecond, opts.RateLimitBurst, opts.GetRealClientIPParser()))
+	}
+	callbackChain := authLimitChain
+	if opts.LoginFailureBackoff > 0 {
+		callbackChain = callbackChain.Append(middleware.NewLoginThrottle(opts.LoginFailureBackoff, opts.LoginFailureMaxBackoff, opts.GetRealClientIPParser()))
+	}
+
 	redirectValidator := redirect.NewValidator(opts.WhitelistDomains)
 	appDirector := redirect.NewAppDirector(redirect.AppDirectorOpts{
 		ProxyPrefix: opts.ProxyPrefix,
@@ -207,30 +319,50 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 
 		SignInPath: fmt.Sprintf("%s/sign_in", opts.ProxyPrefix),
 
-		ProxyPrefix:         opts.ProxyPrefix,
-		provider:            provider,
-		sessionStore:        sessionStore,
-		redirectURL:         redirectURL,
-		apiRoutes:           apiRoutes,
-		allowedRoutes:       allowedRoutes,
-		whitelistDomains:    opts.WhitelistDomains,
-		skipAuthPreflight:   opts.SkipAuthPreflight,
-		skipJwtBearerTokens: opts.SkipJwtBearerTokens,
-		realClientIPParser:  opts.GetRealClientIPParser(),
-		SkipProviderButton:  opts.SkipProviderButton,
-		forceJSONErrors:     opts.ForceJSONErrors,
-		trustedIPs:          trustedIPs,
+		ProxyPrefix:          opts.ProxyPrefix,
+		provider:             provider,
+		sessionStore:         sessionStore,
+		csrfStore:            csrfStore,
+		redirectURL:          redirectURL,
+		apiRoutes:            apiRoutes,
+		ajaxHeaders:          buildAjaxHeaders(opts),
+		allowedRoutes:        allowedRoutes,
+		whitelistDomains:     opts.WhitelistDomains,
+		skipAuthPreflight:    opts.SkipAuthPreflight,
+		skipJwtBearerTokens:  opts.SkipJwtBearerTokens,
+		realClientIPParser:   opts.GetRealClientIPParser(),
+		SkipProviderButton:   opts.SkipProviderButton,
+		silentReauth:         opts.SilentReauth,
+		defaultRootAction:    opts.DefaultRootAction,
+		defaultRootRedirect:  opts.DefaultRootRedirectURL,
+		forceJSONErrors:      opts.ForceJSONErrors,
+		ajaxUnauthorizedCode: opts.AjaxUnauthorizedCode,
+		callbackMaxBodySize:  opts.CallbackMaxBodySize,
+		trustedIPs:           trustedIPs,
+		trustedIPUser:        opts.TrustedIPUser,
+
+		userSessionsStore:  userSessionsStore,
+		adminAPIToken:      opts.AdminAPIToken,
+		adminAPIAllowedIPs: adminAPIAllowedIPs,
 
 		basicAuthValidator: basicAuthValidator,
 		basicAuthGroups:    opts.HtpasswdUserGroups,
 		sessionChain:       sessionChain,
 		headersChain:       headersChain,
+		authLimitChain:     authLimitChain,
+		callbackChain:      callbackChain,
 		preAuthChain:       preAuthChain,
 		pageWriter:         pageWriter,
-		upstreamProxy:      upstreamProxy,
+		pageHeaders:        opts.GetPageResponseHeaders(),
+		accessDeniedCode:   opts.Templates.AccessDeniedStatusCode,
 		redirectValidator:  redirectValidator,
 		appDirector:        appDirector,
 	}
+
+	p.upstreamProxy, err = upstream.NewProxy(opts.UpstreamServers, opts.GetSignatureData(), pageWriter, buildTokenExchanger(provider, sessionStore), p.stepUp, p.reauthenticate)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising upstream proxy: %v", err)
+	}
 	p.buildServeMux(opts.ProxyPrefix)
 
 	if err := p.setupServer(opts); err != nil {
@@ -256,6 +388,7 @@ func (p *OAuthProxy) Start() error {
 		<-sigint
 		cancel() // cancel the context
 	}()
+	defer p.provider.Data().StopWatching()
 
 	return p.server.Start(ctx)
 }
@@ -264,8 +397,13 @@ func (p *OAuthProxy) setupServer(opts *options.Options) error {
 	serverOpts := proxyhttp.Opts{
 		Handler:           p,
 		BindAddress:       opts.Server.BindAddress,
+		SocketFileMode:    opts.Server.SocketFileMode,
 		SecureBindAddress: opts.Server.SecureBindAddress,
 		TLS:               opts.Server.TLS,
+		EnableHTTP2:       hasHTTP2Upstream(opts.UpstreamServers),
+
+		ShutdownTimeout:       opts.Server.ShutdownTimeout.Duration(),
+		StreamShutdownTimeout: opts.Server.StreamShutdownTimeout.Duration(),
 	}
 
 	appServer, err := proxyhttp.NewServer(serverOpts)
@@ -276,8 +414,12 @@ func (p *OAuthProxy) setupServer(opts *options.Options) error {
 	metricsServer, err := proxyhttp.NewServer(proxyhttp.Opts{
 		Handler:           middleware.DefaultMetricsHandler,
 		BindAddress:       opts.MetricsServer.BindAddress,
+		SocketFileMode:    opts.MetricsServer.SocketFileMode,
 		SecureBindAddress: opts.MetricsServer.SecureBindAddress,
 		TLS:               opts.MetricsServer.TLS,
+
+		ShutdownTimeout:       opts.MetricsServer.ShutdownTimeout.Duration(),
+		StreamShutdownTimeout: opts.MetricsServer.StreamShutdownTimeout.Duration(),
 	})
 	if err != nil {
 		return fmt.Errorf("could not build metrics server: %v", err)
@@ -287,6 +429,17 @@ func (p *OAuthProxy) setupServer(opts *options.Options) error {
 	return nil
 }
 
+// hasHTTP2Upstream returns true when any upstream is proxied over HTTP/2, in
+// which case clients must also be able to reach the proxy over HTTP/2.
+func hasHTTP2Upstream(upstreams options.UpstreamConfig) bool {
+	for _, upstream := range upstreams.Upstreams {
+		if upstream.HTTP2 {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 	// Use the encoded path here so we can have the option to pass it on in the upstream mux.
 	// Otherwise something like /%2F/ would be redirected to / here already.
@@ -315,13 +468,27 @@ func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 func (p *OAuthProxy) buildProxySubrouter(s *mux.Router) {
 	s.Use(prepareNoCacheMiddleware)
 
-	s.Path(signInPath).HandlerFunc(p.SignIn)
+	// The endpoints that start or complete a login are rate limited so that
+	// clients cannot flood the provider with authentication requests
+	s.Path(signInPath).Handler(p.authLimitChain.ThenFunc(p.SignIn))
 	s.Path(signOutPath).HandlerFunc(p.SignOut)
-	s.Path(oauthStartPath).HandlerFunc(p.OAuthStart)
-	s.Path(oauthCallbackPath).HandlerFunc(p.OAuthCallback)
+	s.Path(oauthStartPath).Handler(p.authLimitChain.ThenFunc(p.OAuthStart))
+	s.Path(oauthCallbackPath).Handler(p.callbackChain.ThenFunc(p.OAuthCallback))
 
 	// The userinfo endpoint needs to load sessions before handling the request
 	s.Path(userInfoPath).Handler(p.sessionChain.ThenFunc(p.UserInfo))
+
+	// The device authorization grant is only available when the provider
+	// has a device authorization endpoint
+	if deviceURL := p.provider.Data().DeviceAuthorizationURL; deviceURL != nil && deviceURL.String() != "" {
+		s.Path(deviceStartPath).Methods(http.MethodPost).Handler(p.authLimitChain.ThenFunc(p.DeviceStart))
+		s.Path(devicePollPath).Methods(http.MethodPost).Handler(p.authLimitChain.ThenFunc(p.DevicePoll))
+	}
+
+	// The admin endpoints are only available when an admin token is configured
+	if p.adminAPIToken != "" {
+		s.Path(adminInvalidateSessionsPath).Methods(http.MethodPost).HandlerFunc(p.InvalidateUserSessions)
+	}
 }
 
 // buildPreAuthChain constructs a chain that should process every request before
@@ -346,28 +513,53 @@ func buildPreAuthChain(opts *options.Options, sessionStore sessionsapi.SessionSt
 		healthCheckUserAgents = append(healthCheckUserAgents, "GoogleHC/1.0")
 	}
 
+	readynessCheck := middleware.NewReadynessCheck(opts.ReadyPath, buildReadynessDependencies(opts, sessionStore), opts.ReadyCheckCacheTTL)
+
 	// To silence logging of health checks, register the health check handler before
 	// the logging handler
 	if opts.Logging.SilencePing {
 		chain = chain.Append(
 			middleware.NewHealthCheck(healthCheckPaths, healthCheckUserAgents),
-			middleware.NewReadynessCheck(opts.ReadyPath, sessionStore),
+			readynessCheck,
 			middleware.NewRequestLogger(),
 		)
 	} else {
 		chain = chain.Append(
 			middleware.NewRequestLogger(),
 			middleware.NewHealthCheck(healthCheckPaths, healthCheckUserAgents),
-			middleware.NewReadynessCheck(opts.ReadyPath, sessionStore),
+			readynessCheck,
 		)
 	}
 
 	chain = chain.Append(middleware.NewRequestMetricsWithDefaultRegistry())
 
+	// Health checks are not traced to keep them from flooding the traces
+	if opts.TracingOTLPEndpoint != "" {
+		chain = chain.Append(middleware.NewTracing())
+	}
+
 	return chain, nil
 }
 
-func buildSessionChain(opts *options.Options, provider providers.Provider, sessionStore sessionsapi.SessionStore, validator basic.Validator) alice.Chain {
+// buildReadynessDependencies lists the dependencies verified by the ready endpoint.
+// The OIDC discovery document is only checked when discovery is in use.
+func buildReadynessDependencies(opts *options.Options, sessionStore sessionsapi.SessionStore) []middleware.ReadynessDependency {
+	dependencies := []middleware.ReadynessDependency{
+		{Name: "session_store", Verifiable: sessionStore},
+	}
+
+	oidcConfig := opts.Providers[0].OIDCConfig
+	if oidcConfig.IssuerURL != "" && !oidcConfig.SkipDiscovery {
+		dependencies = append(dependencies, middleware.ReadynessDependency{
+			Name:       "oidc_discovery",
+			Verifiable: &internaloidc.DiscoveryCheck{IssuerURL: oidcConfig.IssuerURL},
+		})
+	}
+
+	return dependencies
+}
+
+func buildSessionChain(opts *options.Options, provider providers.Provider, sessionStore sessionsapi.SessionStore, validator basic.Validator, apiKeyValidator apikey.Validator) alice.Chain {
 	chain := alice.New()
 
 	if opts.SkipJwtBearerTokens {
@@ -380,24 +572,159 @@ func buildSessionChain(opts *options.Options, provider providers.Provider, sessi
 				middlewareapi.CreateTokenToSessionFunc(verifier.Verify))
 		}
 
-		chain = chain.Append(middleware.NewJwtSessionLoader(sessionLoaders))
+		chain = chain.Append(middleware.NewJwtSessionLoader(sessionLoaders, opts.JwtBearerHeaders))
 	}
 
 	if validator != nil {
 		chain = chain.Append(middleware.NewBasicAuthSessionLoader(validator, opts.HtpasswdUserGroups, opts.LegacyPreferEmailToUser))
 	}
 
+	if apiKeyValidator != nil {
+		chain = chain.Append(middleware.NewAPIKeySessionLoader(apiKeyValidator, opts.APIKeyHeader))
+	}
+
+	var validateEachRequest func(context.Context, *sessionsapi.SessionState) error
+	if opts.Session.ValidateEachRequest {
+		validateEachRequest = func(ctx context.Context, s *sessionsapi.SessionState) error {
+			return providers.CheckSessionActive(ctx, provider, s)
+		}
+	}
+
 	chain = chain.Append(middleware.NewStoredSessionLoader(&middleware.StoredSessionLoaderOptions{
-		SessionStore:    sessionStore,
-		RefreshPeriod:   opts.Cookie.Refresh,
-		RefreshSession:  provider.RefreshSession,
-		ValidateSession: provider.ValidateSession,
+		SessionStore:                sessionStore,
+		RefreshPeriod:               opts.Cookie.Refresh,
+		RefreshSession:              provider.RefreshSession,
+		ValidateSession:             provider.ValidateSession,
+		CookieName:                  opts.Cookie.PrefixedName(),
+		RefreshCoalesceWindow:       opts.Cookie.RefreshCoalesceWindow,
+		RefreshGracePeriod:          opts.Cookie.RefreshGracePeriod,
+		MaxLifetime:                 opts.Session.MaxLifetime,
+		IdleTimeout:                 opts.Session.IdleTimeout,
+		ValidateEachRequest:         validateEachRequest,
+		ValidateEachRequestCacheTTL: opts.Session.ValidateEachRequestCacheTTL,
 	}))
 
 	return chain
 }
 
-func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
+// buildTokenExchanger creates the func used by upstreams to exchange the
+// session access token for a token scoped to the upstream audience.
+// Exchanged tokens are cached in the session until they expire.
+// Refresh token grants are made under the session lock so that a refresh
+// token rotated by the provider is saved before it can be used again.
+// When the provider does not support the token request, non strict upstreams
+// are sent the session access token instead.
+func buildTokenExchanger(provider providers.Provider, sessionStore sessionsapi.SessionStore) upstream.TokenExchangeFunc {
+	return func(rw http.ResponseWriter, req *http.Request, exchange *options.TokenExchange) (string, error) {
+		session := middlewareapi.GetRequestScope(req).Session
+		if session == nil || session.AccessToken == "" {
+			return "", nil
+		}
+
+		// Strict upstreams must not use a fallback remembered for another
+		// upstream with the same audience
+		key := exchangedTokenKey(exchange)
+		if token, ok := session.GetExchangedToken(key); ok && !(exchange.Strict && session.ExchangedTokens[key].Fallback) {
+			return token, nil
+		}
+
+		// The refresh token may be rotated by the provider, it must not be
+		// used by another request or a session refresh at the same time
+		if exchange.Grant == options.TokenExchangeGrantRefreshToken && session.CreatedAt != nil {
+			if err := obtainSessionLock(req.Context(), session); err != nil {
+				return "", err
+			}
+			defer func() {
+				if err := session.ReleaseLock(req.Context()); err != nil {
+					logger.Errorf("unable to release lock: %v", err)
+				}
+			}()
+
+			// Reload the session in case its refresh token was rotated or the
+			// token was exchanged while waiting for the lock
+			if freshSession, err := sessionStore.Load(req); err != nil {
+				return "", fmt.Errorf("could not load session: %v", err)
+			} else if freshSession != nil {
+				lock, sessionClock := session.Lock, session.Clock
+				*session = *freshSession
+				session.Lock, session.Clock = lock, sessionClock
+			}
+			if token, ok := session.GetExchangedToken(key); ok && !(exchange.Strict && session.ExchangedTokens[key].Fallback) {
+				return token, nil
+			}
+		}
+
+		exchanged, err := provider.ExchangeToken(req.Context(), session, exchange)
+		switch {
+		case errors.Is(err, providers.ErrTokenRequestUnsupported) && !exchange.Strict:
+			logger.Printf("Using the session access token for %q: %v", key, err)
+			exchanged = &sessionsapi.ExchangedToken{
+				Fallback:  true,
+				ExpiresOn: session.ExpiresOn,
+			}
+		case err != nil:
+			return "", err
+		}
+		session.SetExchangedToken(key, *exchanged)
+
+		// Sessions loaded from bearer tokens have no creation time and are
+		// not persisted, so the exchanged token is only used for this request.
+		if session.CreatedAt != nil {
+			if err := sessionStore.Save(rw, req, session); err != nil {
+				logger.Errorf("Error saving session with exchanged token: %v", err)
+			}
+		}
+		if exchanged.Fallback {
+			return session.AccessToken, nil
+		}
+		return exchanged.AccessToken, nil
+	}
+}
+
+// obtainSessionLock waits until the session lock is obtained or
+// tokenExchangeLockTimeout passes.
+func obtainSessionLock(ctx context.Context, session *sessionsapi.SessionState) error {
+	ctx, cancel := context.WithTimeout(ctx, tokenExchangeLockTimeout)
+	defer cancel()
+
+	for {
+		err := session.ObtainLock(ctx, tokenExchangeLockDuration)
+		switch {
+		case err == nil:
+			return nil
+		case !errors.Is(err, sessionsapi.ErrLockNotObtained):
+			return fmt.Errorf("error occurred while trying to obtain lock: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("timeout obtaining session lock")
+		case <-time.After(tokenExchangeLockRetryPeriod):
+		}
+	}
+}
+
+// exchangedTokenKey identifies the tokens exchanged for the same audience,
+// resource and scopes in the session.
+// Tokens for only an audience are keyed by the audience.
+func exchangedTokenKey(exchange *options.TokenExchange) string {
+	if exchange.Resource == "" && len(exchange.Scopes) == 0 {
+		return exchange.Audience
+	}
+	return strings.Join([]string{exchange.Audience, exchange.Resource, strings.Join(exchange.Scopes, " ")}, "|")
+}
+
+func buildHeadersChain(opts *options.Options, writer pagewriter.Writer) (alice.Chain, error) {
+	chain := alice.New()
+	// Strip the configured prefixes first so that they cannot remove the
+	// headers set by the webhook or the injectors
+	if len(opts.StripRequestHeaderPrefixes) > 0 {
+		chain = chain.Append(middleware.NewStripRequestHeaders(opts.StripRequestHeaderPrefixes))
+	}
+	if opts.HeaderWebhookURL != "" {
+		chain = chain.Append(middleware.NewHeaderWebhook(opts.HeaderWebhookURL, opts.HeaderWebhookTimeout, opts.HeaderWebhookCacheTTL, opts.HeaderWebhookFailOpen, writer))
+	}
+
 	requestInjector, err := middleware.NewRequestHeaderInjector(opts.InjectRequestHeaders)
 	if err != nil {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
@@ -408,7 +735,14 @@ func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
 	}
 
-	return alice.New(requestInjector, responseInjector), nil
+	chain = chain.Append(requestInjector)
+	if len(opts.GetUpstreamRequestHeaders()) > 0 || len(opts.StripUpstreamHeaders) > 0 {
+		chain = chain.Append(middleware.NewUpstreamHeaders(opts.GetUpstreamRequestHeaders(), opts.StripUpstreamHeaders))
+	}
+	if opts.IdentityHeaderSignatureKey != "" {
+		chain = chain.Append(middleware.NewRequestHeaderSigner(opts.InjectRequestHeaders, []byte(opts.IdentityHeaderSignatureKey)))
+	}
+	return chain.Append(responseInjector), nil
 }
 
 func buildSignInMessage(opts *options.Options) string {
@@ -438,7 +772,7 @@ func buildProviderName(p providers.Provider, override string) string {
 
 // buildRoutesAllowlist builds an []allowedRoute  list from either the legacy
 // SkipAuthRegex option (paths only support) or newer SkipAuthRoutes option
-// (method=path support)
+// (method=path support, where several methods can be given as GET|HEAD=path)
 func buildRoutesAllowlist(opts *options.Options) ([]allowedRoute, error) {
 	routes := make([]allowedRoute, 0, len(opts.SkipAuthRegex)+len(opts.SkipAuthRoutes))
 
@@ -449,24 +783,24 @@ func buildRoutesAllowlist(opts *options.Options) ([]allowedRoute, error) {
 		}
 		logger.Printf("Skipping auth - Method: ALL | Path: %s", path)
 		routes = append(routes, allowedRoute{
-			method:    "",
 			pathRegex: compiledRegex,
 		})
 	}
 
 	for _, methodPath := range opts.SkipAuthRoutes {
 		var (
-			method string
-			path   string
-			negate = strings.Contains(methodPath, "!=")
+			methods []string
+			path    string
+			negate  = strings.Contains(methodPath, "!=")
 		)
 
 		parts := regexp.MustCompile("!?=").Split(methodPath, 2)
 		if len(parts) == 1 {
-			method = ""
 			path = parts[0]
 		} else {
-			method = strings.ToUpper(parts[0])
+			if parts[0] != "" {
+				methods = strings.Split(strings.ToUpper(parts[0]), "|")
+			}
 			path = parts[1]
 		}
 
@@ -474,9 +808,9 @@ func buildRoutesAllowlist(opts *options.Options) ([]allowedRoute, error) {
 		if err != nil {
 			return nil, err
 		}
-		logger.Printf("Skipping auth - Method: %s | Path: %s", method, path)
+		logger.Printf("Skipping auth - Method: %s | Path: %s", strings.Join(methods, "|"), path)
 		routes = append(routes, allowedRoute{
-			method:    method,
+			methods:   methods,
 			negate:    negate,
 			pathRegex: compiledRegex,
 		})
@@ -540,6 +874,7 @@ func (p *OAuthProxy) ErrorPage(rw http.ResponseWriter, req *http.Request, code i
 		RequestID:   scope.RequestID,
 		AppError:    appError,
 		Messages:    messages,
+		Request:     req,
 	})
 }
 
@@ -550,7 +885,15 @@ func (p *OAuthProxy) IsAllowedRequest(req *http.Request) bool {
 }
 
 func isAllowedMethod(req *http.Request, route allowedRoute) bool {
-	return route.method == "" || req.Method == route.method
+	if len(route.methods) == 0 {
+		return true
+	}
+	for _, method := range route.methods {
+		if req.Method == method {
+			return true
+		}
+	}
+	return false
 }
 
 func isAllowedPath(req *http.Request, route allowedRoute) bool {
@@ -563,7 +906,8 @@ func isAllowedPath(req *http.Request, route allowedRoute) bool {
 	return matches
 }
 
-// IsAllowedRoute is used to check if the request method & path is allowed without auth
+// IsAllowedRoute is used to check if the request method & path is allowed without auth.
+// The routes are additive, a request matching any of them is allowed.
 func (p *OAuthProxy) isAllowedRoute(req *http.Request) bool {
 	for _, route := range p.allowedRoutes {
 		if isAllowedMethod(req, route) && isAllowedPath(req, route) {
@@ -573,6 +917,20 @@ func (p *OAuthProxy) isAllowedRoute(req *http.Request) bool {
 	return false
 }
 
+// buildAjaxHeaders parses the "Name" or "Name: value" headers that mark AJAX
+// requests
+func buildAjaxHeaders(opts *options.Options) []ajaxHeader {
+	headers := make([]ajaxHeader, 0, len(opts.AjaxRequestHeaders))
+	for _, header := range opts.AjaxRequestHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		headers = append(headers, ajaxHeader{
+			name:  strings.TrimSpace(name),
+			value: strings.TrimSpace(value),
+		})
+	}
+	return headers
+}
+
 func (p *OAuthProxy) isAPIPath(req *http.Request) bool {
 	for _, route := range p.apiRoutes {
 		if route.pathRegex.MatchString(req.URL.Path) {
@@ -602,6 +960,15 @@ func (p *OAuthProxy) isTrustedIP(req *http.Request) bool {
 	return p.trustedIPs.Has(remoteAddr)
 }
 
+// trustedIPSession creates the synthetic session for requests from trusted IPs
+// and stores it in the request scope, so that the identity headers are
+// injected from it.
+func (p *OAuthProxy) trustedIPSession(req *http.Request) *sessionsapi.SessionState {
+	session := &sessionsapi.SessionState{User: p.trustedIPUser}
+	middlewareapi.GetRequestScope(req).Session = session
+	return session
+}
+
 // SignInPage writes the sign in template to the response
 func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code int) {
 	prepareNoCache(rw)
@@ -627,6 +994,31 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 	p.pageWriter.WriteSignInPage(rw, req, redirectURL, code)
 }
 
+// AccessDeniedPage writes the access denied page for an authenticated session
+// that is not authorized
+func (p *OAuthProxy) AccessDeniedPage(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) {
+	prepareNoCache(rw)
+
+	opts := pagewriter.AccessDeniedPageOpts{
+		Status:    p.accessDeniedStatus(),
+		RequestID: middlewareapi.GetRequestScope(req).RequestID,
+	}
+	if session != nil {
+		opts.Email = session.Email
+		opts.User = session.User
+	}
+	p.pageWriter.WriteAccessDeniedPage(rw, opts)
+}
+
+// accessDeniedStatus returns the status of responses to authenticated sessions
+// that are not authorized
+func (p *OAuthProxy) accessDeniedStatus() int {
+	if p.accessDeniedCode == 0 {
+		return http.StatusForbidden
+	}
+	return p.accessDeniedCode
+}
+
 // ManualSignIn handles basic auth logins to the proxy
 func (p *OAuthProxy) ManualSignIn(req *http.Request) (string, bool, int) {
 	if req.Method != "POST" || p.basicAuthValidator == nil {
@@ -675,42 +1067,95 @@ func (p *OAuthProxy) SignIn(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// UserInfo endpoint outputs session email and preferred username in JSON format
+// UserInfo endpoint outputs session email, preferred username and expiry in
+// JSON format. Tokens are never included in the response.
 func (p *OAuthProxy) UserInfo(rw http.ResponseWriter, req *http.Request) {
-	session, err := p.getAuthenticatedSession(rw, req)
+	// Skipping authentication does not give access to the user info, a valid
+	// session is always required.
+	session, err := p.authorizeSession(rw, req, middlewareapi.GetRequestScope(req).Session)
 	if err != nil {
 		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
 
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusOK)
-	if session == nil {
-		if _, err := rw.Write([]byte("{}")); err != nil {
-			logger.Printf("Error encoding empty user info: %v", err)
-			p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
-		}
-		return
-	}
-
 	userInfo := struct {
-		User              string   `json:"user"`
-		Email             string   `json:"email"`
-		Groups            []string `json:"groups,omitempty"`
-		PreferredUsername string   `json:"preferredUsername,omitempty"`
+		User              string     `json:"user"`
+		Email             string     `json:"email"`
+		Groups            []string   `json:"groups,omitempty"`
+		PreferredUsername string     `json:"preferredUsername,omitempty"`
+		ExpiresOn         *time.Time `json:"expiresOn,omitempty"`
 	}{
 		User:              session.User,
 		Email:             session.Email,
 		Groups:            session.Groups,
 		PreferredUsername: session.PreferredUsername,
+		ExpiresOn:         session.ExpiresOn,
 	}
 
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(rw).Encode(userInfo); err != nil {
 		logger.Printf("Error encoding user info: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 	}
 }
 
+// InvalidateUserSessions signs the user given in the `user` form value out
+// of all their sessions, eg when their account is compromised. The user can
+// be the user or the email of the sessions.
+// It is only available to admin clients presenting the admin API token.
+func (p *OAuthProxy) InvalidateUserSessions(rw http.ResponseWriter, req *http.Request) {
+	if code := p.checkAdminRequest(req); code != http.StatusOK {
+		p.errorJSON(rw, code)
+		return
+	}
+
+	user := req.PostFormValue("user")
+	if user == "" {
+		p.errorJSON(rw, http.StatusBadRequest)
+		return
+	}
+
+	count, err := p.userSessionsStore.ClearUserSessions(req.Context(), user)
+	if err != nil {
+		logger.Errorf("Error invalidating the sessions of %s: %v", user, err)
+		p.errorJSON(rw, http.StatusInternalServerError)
+		return
+	}
+	logger.PrintAuthf(user, req, logger.AuthSuccess, "Invalidated %d sessions by admin request", count)
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(struct {
+		User     string `json:"user"`
+		Sessions int    `json:"sessions"`
+	}{
+		User:     user,
+		Sessions: count,
+	}); err != nil {
+		logger.Errorf("Error encoding invalidated sessions: %v", err)
+	}
+}
+
+// checkAdminRequest checks the request comes from an allowed IP and has the
+// admin API token as a bearer token, and returns the status to respond with
+// otherwise
+func (p *OAuthProxy) checkAdminRequest(req *http.Request) int {
+	if p.adminAPIAllowedIPs != nil {
+		remoteAddr, err := ip.GetClientIP(p.realClientIPParser, req)
+		if err != nil || remoteAddr == nil || !p.adminAPIAllowedIPs.Has(remoteAddr) {
+			return http.StatusForbidden
+		}
+	}
+
+	auth := req.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(p.adminAPIToken)) != 1 {
+		return http.StatusUnauthorized
+	}
+	return http.StatusOK
+}
+
 // SignOut sends a response to clear the authentication cookie
 func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 	redirect, err := p.appDirector.GetRedirect(req)
@@ -719,23 +1164,84 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
+
+	// A missing or invalid session is still signed out
+	session, _ := p.sessionStore.Load(req)
+	if session != nil {
+		p.revokeSessionTokens(req.Context(), session)
+		redirect = p.getEndSessionRedirect(req, session, redirect)
+	}
+
 	err = p.ClearSessionCookie(rw, req)
 	if err != nil {
 		logger.Errorf("Error clearing session cookie: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
+	pagewriter.SetResponseHeaders(rw, p.pageHeaders)
 	http.Redirect(rw, req, redirect, http.StatusFound)
 }
 
+// revokeSessionTokens revokes the tokens of the session at the provider
+// when it supports token revocation.
+// Revocation is best effort, failures are logged and do not prevent the user
+// from signing out.
+func (p *OAuthProxy) revokeSessionTokens(ctx context.Context, session *sessionsapi.SessionState) {
+	ctx, cancel := context.WithTimeout(ctx, tokenRevocationTimeout)
+	defer cancel()
+
+	err := p.provider.RevokeToken(ctx, session)
+	if err != nil && !errors.Is(err, providers.ErrNotImplemented) {
+		logger.Errorf("Error revoking tokens for session %s: %v", session, err)
+	}
+}
+
+// getEndSessionRedirect returns the provider's end session URL so that the
+// user is also logged out at the provider (OIDC RP-initiated logout).
+// The already validated redirect is passed as the post logout redirect URI.
+// If the provider has no end session endpoint, the redirect is returned as is.
+func (p *OAuthProxy) getEndSessionRedirect(req *http.Request, session *sessionsapi.SessionState, redirect string) string {
+	postLogoutRedirectURI, err := url.Parse(redirect)
+	if err != nil {
+		return redirect
+	}
+	if postLogoutRedirectURI.Host == "" {
+		postLogoutRedirectURI.Host = requestutil.GetRequestHost(req)
+		postLogoutRedirectURI.Scheme = requestutil.GetRequestProto(req)
+		if postLogoutRedirectURI.Scheme == "" {
+			postLogoutRedirectURI.Scheme = schemeHTTP
+		}
+		if p.CookieOptions.Secure {
+			postLogoutRedirectURI.Scheme = schemeHTTPS
+		}
+	}
+
+	endSessionURL := p.provider.Data().GetEndSessionURL(session.IDToken, postLogoutRedirectURI.String())
+	if endSessionURL == "" {
+		return redirect
+	}
+	return endSessionURL
+}
+
 // OAuthStart starts the OAuth2 authentication flow
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 	// start the flow permitting login URL query parameters to be overridden from the request URL
-	p.doOAuthStart(rw, req, req.URL.Query())
+	p.doOAuthStart(rw, req, req.URL.Query(), authorization.AuthenticationRequirement{}, false)
 }
 
-func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, overrides url.Values) {
+// doOAuthStart redirects to the provider to login.
+// The requirement is requested from the provider and checked in the callback.
+// A silent login is requested with prompt=none, so that the provider does not
+// interact with the user, see silentReauthFallback.
+func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, overrides url.Values, requirement authorization.AuthenticationRequirement, silent bool) {
 	extraParams := p.provider.Data().LoginURLParams(overrides)
+	for name, values := range requirement.LoginParams() {
+		extraParams[name] = values
+	}
+	if silent {
+		extraParams.Del("approval_prompt")
+		extraParams.Set("prompt", "none")
+	}
 	prepareNoCache(rw)
 
 	var (
@@ -762,12 +1268,14 @@ func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, ove
 		extraParams.Add("code_challenge_method", codeChallengeMethod)
 	}
 
-	csrf, err := cookies.NewCSRF(p.CookieOptions, codeVerifier)
+	csrf, err := p.newCSRF(codeVerifier)
 	if err != nil {
 		logger.Errorf("Error creating CSRF nonce: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
+	csrf.SetStepUp(requirement.ACRValues, requirement.MaxAge)
+	csrf.SetSilent(silent)
 
 	appRedirect, err := p.appDirector.GetRedirect(req)
 	if err != nil {
@@ -777,12 +1285,17 @@ func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, ove
 	}
 
 	callbackRedirect := p.getOAuthRedirectURI(req)
-	loginURL := p.provider.GetLoginURL(
+	loginURL, err := p.provider.GetLoginURL(
 		callbackRedirect,
 		encodeState(csrf.HashOAuthState(), appRedirect),
 		csrf.HashOIDCNonce(),
 		extraParams,
 	)
+	if err != nil {
+		logger.Errorf("Error creating login URL: %v", err)
+		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
 
 	if _, err := csrf.SetCookie(rw, req); err != nil {
 		logger.Errorf("Error setting CSRF cookie: %v", err)
@@ -793,33 +1306,79 @@ func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, ove
 	http.Redirect(rw, req, loginURL, http.StatusFound)
 }
 
+// newCSRF creates the CSRF for a new authentication flow, it is kept in the
+// session store when the CSRF is stored server-side
+func (p *OAuthProxy) newCSRF(codeVerifier string) (cookies.CSRF, error) {
+	if p.csrfStore != nil {
+		return cookies.NewStoredCSRF(p.CookieOptions, codeVerifier, p.csrfStore)
+	}
+	return cookies.NewCSRF(p.CookieOptions, codeVerifier)
+}
+
+// loadCSRF loads the CSRF for the authentication flow from the CSRF cookie,
+// or from the session store using the ID in the OAuth state
+func (p *OAuthProxy) loadCSRF(req *http.Request) (cookies.CSRF, error) {
+	if p.csrfStore == nil {
+		return cookies.LoadCSRFCookie(req, p.CookieOptions)
+	}
+
+	id, _, err := decodeState(req)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing OAuth2 state: %v", err)
+	}
+	return cookies.LoadStoredCSRF(req, p.csrfStore, id, p.CookieOptions)
+}
+
 // OAuthCallback is the OAuth2 authentication flow callback that finishes the
 // OAuth2 authentication flow
 func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	remoteAddr := ip.GetClientString(p.realClientIPParser, req, true)
 
+	// Providers using response_mode=form_post send the authorization response
+	// in the request body, which is limited to protect the proxy from large bodies
+	if req.Method == http.MethodPost {
+		req.Body = http.MaxBytesReader(rw, req.Body, p.callbackMaxBodySize)
+	}
+
 	// finish the oauth cycle
 	err := req.ParseForm()
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logger.Errorf("Error while parsing OAuth2 callback: request body is larger than %d bytes", maxBytesErr.Limit)
+			p.ErrorPage(rw, req, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
 		logger.Errorf("Error while parsing OAuth2 callback: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
 	errorString := req.Form.Get("error")
 	if errorString != "" {
+		// The CSRF is only loaded once as loading a server-side CSRF removes it
+		csrf, err := p.loadCSRF(req)
+		if err == nil && p.silentReauthFallback(rw, req, csrf, errorString) {
+			return
+		}
 		logger.Errorf("Error while parsing OAuth2 callback: %s", errorString)
+		// The login is over, remove its CSRF rather than leaving it until it expires
+		if err == nil {
+			csrf.ClearCookie(rw, req)
+		}
 		message := fmt.Sprintf("Login Failed: The upstream identity provider returned an error: %s", errorString)
 		// Set the debug message and override the non debug message to be the same for this case
 		p.ErrorPage(rw, req, http.StatusForbidden, message, message)
 		return
 	}
 
-	csrf, err := cookies.LoadCSRFCookie(req, p.CookieOptions)
+	csrf, err := p.loadCSRF(req)
 	if err != nil {
 		logger.Println(req, logger.AuthFailure, "Invalid authentication via OAuth2: unable to obtain CSRF cookie")
 		p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: Unable to find a valid CSRF token. Please try again.")
 		return
 	}
+	// The CSRF can only be used once, whether or not the login succeeds
+	csrf.ClearCookie(rw, req)
 
 	session, err := p.redeemCode(req, csrf.GetCodeVerifier())
 	if err != nil {
@@ -834,8 +1393,10 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	csrf.ClearCookie(rw, req)
+	// The login throttle and request logger identify the user by the session
+	if scope := middlewareapi.GetRequestScope(req); scope != nil {
+		scope.Session = session
+	}
 
 	nonce, appRedirect, err := decodeState(req)
 	if err != nil {
@@ -857,6 +1418,17 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The provider may ignore the requested acr_values or max_age, the
+	// existing session is kept rather than starting another login.
+	acrValues, maxAge := csrf.GetStepUp()
+	requirement := authorization.AuthenticationRequirement{ACRValues: acrValues, MaxAge: maxAge}
+	if !requirement.SatisfiedBy(session, session.Clock.Now()) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session does not meet the authentication requirements (acr_values %q, max_age %s): %s", acrValues, maxAge, session)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Session does not meet the authentication requirements",
+			"Login Failed: The identity provider did not authenticate you with the required assurance level.")
+		return
+	}
+
 	if !p.redirectValidator.IsValidRedirect(appRedirect) {
 		appRedirect = "/"
 	}
@@ -877,19 +1449,58 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		http.Redirect(rw, req, appRedirect, http.StatusFound)
 	} else {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unauthorized")
-		p.ErrorPage(rw, req, http.StatusForbidden, "Invalid session: unauthorized")
+		p.AccessDeniedPage(rw, req, session)
 	}
 }
 
+// silentReauthErrors are the errors of a login with prompt=none when the
+// provider needs to interact with the user, see OpenID Connect Core 3.1.2.6
+var silentReauthErrors = map[string]struct{}{
+	"login_required":             {},
+	"interaction_required":       {},
+	"consent_required":           {},
+	"account_selection_required": {},
+}
+
+// silentReauthFallback starts an interactive login when the provider requires
+// the user to log in for a silent re-authentication. Returns false when the
+// callback is not of a silent re-authentication or failed with another error.
+func (p *OAuthProxy) silentReauthFallback(rw http.ResponseWriter, req *http.Request, csrf cookies.CSRF, errorString string) bool {
+	if _, ok := silentReauthErrors[errorString]; !ok {
+		return false
+	}
+	if !csrf.IsSilent() {
+		return false
+	}
+	nonce, appRedirect, err := decodeState(req)
+	if err != nil || !csrf.CheckOAuthState(nonce) {
+		return false
+	}
+	csrf.ClearCookie(rw, req)
+
+	if !p.redirectValidator.IsValidRedirect(appRedirect) {
+		appRedirect = "/"
+	}
+	logger.Printf("Silent re-authentication failed: %s. Initiating login.", errorString)
+	prepareNoCache(rw)
+	http.Redirect(rw, req, fmt.Sprintf("%s%s?rd=%s", p.ProxyPrefix, oauthStartPath, url.QueryEscape(appRedirect)), http.StatusFound)
+	return true
+}
+
 func (p *OAuthProxy) redeemCode(req *http.Request, codeVerifier string) (*sessionsapi.SessionState, error) {
 	code := req.Form.Get("code")
 	if code == "" {
 		return nil, providers.ErrMissingCode
 	}
 
+	ctx, span := tracing.Tracer().Start(req.Context(), "provider.redeem")
+	defer span.End()
+
 	redirectURI := p.getOAuthRedirectURI(req)
-	s, err := p.provider.Redeem(req.Context(), redirectURI, code, codeVerifier)
+	s, err := p.provider.Redeem(ctx, redirectURI, code, codeVerifier)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error redeeming code")
 		return nil, err
 	}
 
@@ -904,8 +1515,130 @@ func (p *OAuthProxy) redeemCode(req *http.Request, codeVerifier string) (*sessio
 	return s, nil
 }
 
-func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.SessionState) error {
-	var err error
+// DeviceStart begins the device authorization grant (RFC 8628) for a
+// headless client. The response contains the user code and verification URI
+// to show to the user, and the device code to poll with.
+func (p *OAuthProxy) DeviceStart(rw http.ResponseWriter, req *http.Request) {
+	authorization, err := p.provider.StartDeviceAuthorization(req.Context())
+	if err != nil {
+		logger.Errorf("Error starting device authorization: %v", err)
+		writeDeviceError(rw, http.StatusBadGateway, "server_error", "unable to start device authorization")
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(authorization); err != nil {
+		logger.Errorf("Error encoding device authorization: %v", err)
+	}
+}
+
+// DevicePoll redeems the `device_code` form value once the user has
+// authorized the device, and saves the resulting session.
+// While the user has not yet completed the authorization, the token endpoint
+// error (eg authorization_pending or slow_down) is returned so that the
+// client knows to keep polling.
+func (p *OAuthProxy) DevicePoll(rw http.ResponseWriter, req *http.Request) {
+	remoteAddr := ip.GetClientString(p.realClientIPParser, req, true)
+
+	if err := req.ParseForm(); err != nil {
+		writeDeviceError(rw, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	deviceCode := req.Form.Get("device_code")
+	if deviceCode == "" {
+		writeDeviceError(rw, http.StatusBadRequest, "invalid_request", providers.ErrMissingDeviceCode.Error())
+		return
+	}
+
+	session, err := p.provider.RedeemDeviceCode(req.Context(), deviceCode)
+	if err != nil {
+		var tokenErr *providers.DeviceTokenError
+		if errors.As(err, &tokenErr) {
+			status := http.StatusBadRequest
+			if tokenErr.Code == providers.DeviceAccessDenied {
+				status = http.StatusForbidden
+			}
+			writeDeviceError(rw, status, tokenErr.Code, tokenErr.Description)
+			return
+		}
+		logger.Errorf("Error redeeming device code: %v", err)
+		writeDeviceError(rw, http.StatusBadGateway, "server_error", "unable to redeem device code")
+		return
+	}
+
+	// Force setting these in case the Provider didn't
+	if session.CreatedAt == nil {
+		session.CreatedAtNow()
+	}
+	if session.ExpiresOn == nil {
+		session.ExpiresIn(p.CookieOptions.Expire)
+	}
+
+	if err := p.enrichSessionState(req.Context(), session); err != nil {
+		logger.Errorf("Error creating session during device authorization: %v", err)
+		writeDeviceError(rw, http.StatusInternalServerError, "server_error", "unable to create session")
+		return
+	}
+
+	authorized, err := p.provider.Authorize(req.Context(), session)
+	if err != nil {
+		logger.Errorf("Error with authorization: %v", err)
+	}
+	if !p.Validator(session.Email) || !authorized {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via device authorization: unauthorized")
+		writeDeviceError(rw, http.StatusForbidden, providers.DeviceAccessDenied, "unauthorized")
+		return
+	}
+
+	logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via device authorization: %s", session)
+	if err := p.SaveSession(rw, req, session); err != nil {
+		logger.Errorf("Error saving session state for %s: %v", remoteAddr, err)
+		writeDeviceError(rw, http.StatusInternalServerError, "server_error", "unable to save session")
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(struct {
+		Status    string     `json:"status"`
+		Email     string     `json:"email,omitempty"`
+		ExpiresOn *time.Time `json:"expiresOn,omitempty"`
+	}{
+		Status:    "ok",
+		Email:     session.Email,
+		ExpiresOn: session.ExpiresOn,
+	}); err != nil {
+		logger.Errorf("Error encoding device authorization result: %v", err)
+	}
+}
+
+// writeDeviceError writes an OAuth style error response as used by the
+// device authorization endpoints
+func writeDeviceError(rw http.ResponseWriter, status int, code, description string) {
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}{
+		Error:            code,
+		ErrorDescription: description,
+	}); err != nil {
+		logger.Errorf("Error encoding device authorization error: %v", err)
+	}
+}
+
+func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.SessionState) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "provider.enrich_session")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "error enriching session")
+		}
+		span.End()
+	}()
+
 	if s.Email == "" {
 		// TODO(@NickMeves): Remove once all provider are updated to implement EnrichSession
 		// nolint:staticcheck
@@ -952,28 +1685,44 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 		p.headersChain.Then(p.upstreamProxy).ServeHTTP(rw, req)
 	case ErrNeedsLogin:
 		// we need to send the user to a login screen
-		if p.forceJSONErrors || isAjax(req) || p.isAPIPath(req) {
+		if p.forceJSONErrors || p.isAjax(req) || p.isAPIPath(req) {
 			logger.Printf("No valid authentication in request. Access Denied.")
 			// no point redirecting an AJAX request
-			p.errorJSON(rw, http.StatusUnauthorized)
+			p.unauthorizedJSON(rw, req)
 			return
 		}
 
-		logger.Printf("No valid authentication in request. Initiating login.")
-		if p.SkipProviderButton {
+		if p.silentReauth && middlewareapi.GetRequestScope(req).SessionExpired {
+			logger.Printf("Session expired and could not be refreshed. Initiating silent re-authentication.")
+			p.doOAuthStart(rw, req, nil, authorization.AuthenticationRequirement{}, true)
+			return
+		}
+
+		switch p.unauthenticatedAction(req) {
+		case options.DefaultRootActionWelcome:
+			prepareNoCache(rw)
+			pagewriter.SetResponseHeaders(rw, p.pageHeaders)
+			p.pageWriter.WriteWelcomePage(rw, req)
+		case options.DefaultRootActionRedirect:
+			prepareNoCache(rw)
+			http.Redirect(rw, req, p.defaultRootRedirect, http.StatusFound)
+		case options.DefaultRootActionLogin:
+			logger.Printf("No valid authentication in request. Initiating login.")
 			// start OAuth flow, but only with the default login URL params - do not
 			// consider this request's query params as potential overrides, since
 			// the user did not explicitly start the login flow
-			p.doOAuthStart(rw, req, nil)
-		} else {
+			p.doOAuthStart(rw, req, nil, authorization.AuthenticationRequirement{}, false)
+		default:
+			logger.Printf("No valid authentication in request. Initiating login.")
 			p.SignInPage(rw, req, http.StatusForbidden)
 		}
 
 	case ErrAccessDenied:
-		if p.forceJSONErrors {
-			p.errorJSON(rw, http.StatusForbidden)
+		// the session is still in the request scope after it has been cleared
+		if p.forceJSONErrors || p.isAjax(req) || p.isAPIPath(req) {
+			p.errorJSON(rw, p.accessDeniedStatus())
 		} else {
-			p.ErrorPage(rw, req, http.StatusForbidden, "The session failed authorization checks")
+			p.AccessDeniedPage(rw, req, middlewareapi.GetRequestScope(req).Session)
 		}
 
 	default:
@@ -983,6 +1732,76 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// unauthenticatedAction returns how an unauthenticated request that is not
+// an AJAX or API request is handled. Only the exact root path follows the
+// default root action, every other path shows the sign in page or starts the
+// login when the provider button is skipped.
+func (p *OAuthProxy) unauthenticatedAction(req *http.Request) string {
+	action := options.DefaultRootActionSignIn
+	if req.URL.Path == "/" && p.defaultRootAction != "" {
+		action = p.defaultRootAction
+	}
+	if action == options.DefaultRootActionSignIn && p.SkipProviderButton {
+		return options.DefaultRootActionLogin
+	}
+	return action
+}
+
+// stepUp checks the session meets the authentication requirements of the
+// upstream. Sessions that do not are sent to login again, requesting the
+// acr_values and max_age from the provider.
+func (p *OAuthProxy) stepUp(rw http.ResponseWriter, req *http.Request, acrValues []string, maxAge time.Duration) bool {
+	session := middlewareapi.GetRequestScope(req).Session
+	if session == nil {
+		// Only allowed requests reach the upstreams without a session
+		return true
+	}
+
+	requirement := authorization.AuthenticationRequirement{ACRValues: acrValues, MaxAge: maxAge}
+	if requirement.SatisfiedBy(session, session.Clock.Now()) {
+		return true
+	}
+
+	if p.forceJSONErrors || p.isAjax(req) || p.isAPIPath(req) {
+		logger.Printf("Session does not meet the authentication requirements of the upstream. Access Denied.")
+		p.errorJSON(rw, http.StatusUnauthorized)
+		return false
+	}
+
+	logger.Printf("Session does not meet the authentication requirements of the upstream. Initiating login.")
+	p.doOAuthStart(rw, req, nil, requirement, false)
+	return false
+}
+
+// reauthenticateMinSessionAge is the age a session must have reached before
+// an upstream rejecting it sends the user to login again. Newer sessions come
+// from a login the upstream has already rejected and would loop.
+const reauthenticateMinSessionAge = time.Minute
+
+// reauthenticate clears the session of a request the upstream rejected and
+// starts a new login. Returns false when the request has no session or the
+// session is too new to login again, so that the upstream status is returned.
+func (p *OAuthProxy) reauthenticate(rw http.ResponseWriter, req *http.Request) bool {
+	session := middlewareapi.GetRequestScope(req).Session
+	if session == nil || session.Age() < reauthenticateMinSessionAge {
+		return false
+	}
+
+	if err := p.ClearSessionCookie(rw, req); err != nil {
+		logger.Errorf("Error clearing session cookie: %v", err)
+	}
+
+	if p.forceJSONErrors || p.isAjax(req) || p.isAPIPath(req) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session rejected by the upstream. Access Denied.")
+		p.unauthorizedJSON(rw, req)
+		return true
+	}
+
+	logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session rejected by the upstream. Initiating login.")
+	p.doOAuthStart(rw, req, nil, authorization.AuthenticationRequirement{}, false)
+	return true
+}
+
 // See https://developers.google.com/web/fundamentals/performance/optimizing-content-efficiency/http-caching?hl=en
 var noCacheHeaders = map[string]string{
 	"Expires":         time.Unix(0, 0).Format(time.RFC1123),
@@ -1042,9 +1861,18 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 
 	// Check this after loading the session so that if a valid session exists, we can add headers from it
 	if p.IsAllowedRequest(req) {
+		if session == nil && p.trustedIPUser != "" && p.isTrustedIP(req) {
+			session = p.trustedIPSession(req)
+		}
 		return session, nil
 	}
 
+	return p.authorizeSession(rw, req, session)
+}
+
+// authorizeSession checks that the session exists and is authorized.
+// Unauthorized sessions are cleared.
+func (p *OAuthProxy) authorizeSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) (*sessionsapi.SessionState, error) {
 	if session == nil {
 		return nil, ErrNeedsLogin
 	}
@@ -1176,19 +2004,28 @@ func checkAllowedEmails(req *http.Request, s *sessionsapi.SessionState) bool {
 }
 
 // encodedState builds the OAuth state param out of our nonce and
-// original application redirect
+// original application redirect.
+// The redirect is base64url encoded so that its query string and any
+// reserved characters survive providers that do not re-encode the state.
 func encodeState(nonce string, redirect string) string {
-	return fmt.Sprintf("%v:%v", nonce, redirect)
+	return fmt.Sprintf("%v:%v", nonce, base64.RawURLEncoding.EncodeToString([]byte(redirect)))
 }
 
 // decodeState splits the reflected OAuth state response back into
-// the nonce and original application redirect
+// the nonce and original application redirect.
+// Redirects that are not base64url encoded are returned as they are, so that
+// states issued before the redirect was encoded can still be completed.
+// Callers must validate the redirect before using it.
 func decodeState(req *http.Request) (string, string, error) {
 	state := strings.SplitN(req.Form.Get("state"), ":", 2)
 	if len(state) != 2 {
 		return "", "", errors.New("invalid length")
 	}
-	return state[0], state[1], nil
+	redirect, err := base64.RawURLEncoding.DecodeString(state[1])
+	if err != nil {
+		return state[0], state[1], nil
+	}
+	return state[0], string(redirect), nil
 }
 
 // addHeadersForProxying adds the appropriate headers the request / response for proxying
@@ -1203,8 +2040,15 @@ func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, session *sess
 	}
 }
 
-// isAjax checks if a request is an ajax request
-func isAjax(req *http.Request) bool {
+// isAjax checks if a request is an ajax request, either because it accepts
+// JSON responses or because it has one of the configured AJAX headers
+func (p *OAuthProxy) isAjax(req *http.Request) bool {
+	for _, header := range p.ajaxHeaders {
+		if hasAjaxHeader(req, header) {
+			return true
+		}
+	}
+
 	acceptValues := req.Header.Values("Accept")
 	const ajaxReq = applicationJSON
 	// Iterate over multiple Accept headers, i.e.
@@ -1223,6 +2067,51 @@ func isAjax(req *http.Request) bool {
 	return false
 }
 
+// hasAjaxHeader checks if the request has the header, with the header value as
+// one of its comma separated values when set
+func hasAjaxHeader(req *http.Request, header ajaxHeader) bool {
+	values := req.Header.Values(header.name)
+	if header.value == "" {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), header.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unauthorizedJSON tells AJAX requests without a valid session to login
+// again. The body links to the start of the login with the redirect back to
+// the request, so that clients can navigate there instead of following the
+// redirect the request would get otherwise.
+func (p *OAuthProxy) unauthorizedJSON(rw http.ResponseWriter, req *http.Request) {
+	redirect, err := p.appDirector.GetRedirect(req)
+	if err != nil {
+		logger.Errorf("Error obtaining redirect: %v", err)
+		redirect = "/"
+	}
+
+	body, err := json.Marshal(struct {
+		Error    string `json:"error"`
+		LoginURL string `json:"loginURL"`
+	}{
+		Error:    "unauthorized",
+		LoginURL: fmt.Sprintf("%s%s?rd=%s", p.ProxyPrefix, oauthStartPath, url.QueryEscape(redirect)),
+	})
+	if err != nil {
+		p.errorJSON(rw, p.ajaxUnauthorizedCode)
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(p.ajaxUnauthorizedCode)
+	_, _ = rw.Write(body)
+}
+
 // errorJSON returns the error code with an application/json mime type
 func (p *OAuthProxy) errorJSON(rw http.ResponseWriter, code int) {
 	rw.Header().Set("Content-Type", applicationJSON)
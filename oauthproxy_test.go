@@ -4,20 +4,27 @@ import (
 	"context"
 	"crypto"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/mbland/hmacauth"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cookies"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
@@ -570,6 +577,734 @@ func TestSessionValidationFailure(t *testing.T) {
 	assert.Equal(t, "", cookie)
 }
 
+func TestCSRFServerSide(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	t.Cleanup(providerServer.Close)
+
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.Cookie.CSRFServerSide = true
+	opts.Session.Type = options.RedisSessionStoreType
+	opts.Session.Redis.ConnectionURL = "redis://" + mr.Addr()
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	providerURL, _ := url.Parse(providerServer.URL)
+	testProvider := NewTestProvider(providerURL, "michael.bland@gsa.gov")
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	// start begins a login and returns the state and the binding cookie
+	start := func() (string, *http.Cookie) {
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fapp", nil))
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		// Only a small cookie binding the state to the browser is set
+		cookies := rw.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Less(t, len(cookies[0].Value), 150)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		return loginURL.Query().Get("state"), cookies[0]
+	}
+	callback := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?"+query, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	state, cookie := start()
+	stateKey := "_oauth2_proxy-state-" + strings.SplitN(state, ":", 2)[0]
+	query := "code=callback_code&state=" + url.QueryEscape(state)
+
+	// The state cannot be used by another browser, which would sign that
+	// browser in to the account that started the login
+	rw := callback(query, nil)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.True(t, mr.Exists(stateKey))
+
+	rw = callback(query, cookie)
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/app", rw.Header().Get("Location"))
+
+	// The state can only be used once
+	rw = callback(query, cookie)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+
+	// The state of an abandoned login is removed when the provider returns
+	// an error, rather than being left in the store until it expires
+	state, cookie = start()
+	stateKey = "_oauth2_proxy-state-" + strings.SplitN(state, ":", 2)[0]
+	require.True(t, mr.Exists(stateKey))
+
+	rw = callback("error=access_denied&state="+url.QueryEscape(state), cookie)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.False(t, mr.Exists(stateKey))
+
+	// An error that would restart a silent re-authentication removes the
+	// state and its cookie when the login was not silent
+	state, cookie = start()
+	stateKey = "_oauth2_proxy-state-" + strings.SplitN(state, ":", 2)[0]
+	rw = callback("error=login_required&state="+url.QueryEscape(state), cookie)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.False(t, mr.Exists(stateKey))
+	cleared := rw.Result().Cookies()
+	require.Len(t, cleared, 1)
+	assert.Equal(t, cookie.Name, cleared[0].Name)
+	assert.Empty(t, cleared[0].Value)
+}
+
+func TestOAuthCallbackResponseModes(t *testing.T) {
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	t.Cleanup(providerServer.Close)
+
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.CallbackMaxBodySize = 1024
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	providerURL, _ := url.Parse(providerServer.URL)
+	testProvider := NewTestProvider(providerURL, "michael.bland@gsa.gov")
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	startLogin := func(t *testing.T) (string, *http.Cookie) {
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fapp", nil))
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		return loginURL.Query().Get("state"), rw.Result().Cookies()[0]
+	}
+
+	testCases := map[string]struct {
+		newRequest       func(state string) *http.Request
+		expectedCode     int
+		expectedLocation string
+	}{
+		"query response mode": {
+			newRequest: func(state string) *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+			},
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/app",
+		},
+		"form_post response mode": {
+			newRequest: func(state string) *http.Request {
+				form := url.Values{"code": {"callback_code"}, "state": {state}}
+				req := httptest.NewRequest(http.MethodPost, "/oauth2/callback", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/app",
+		},
+		"form_post response mode with an oversized body": {
+			newRequest: func(state string) *http.Request {
+				form := url.Values{"code": {"callback_code"}, "state": {state}, "padding": {strings.Repeat("a", 1024)}}
+				req := httptest.NewRequest(http.MethodPost, "/oauth2/callback", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			state, csrfCookie := startLogin(t)
+
+			req := tc.newRequest(state)
+			req.AddCookie(csrfCookie)
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedLocation != "" {
+				assert.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestOAuthStartLoginParams(t *testing.T) {
+	anyValue := ".*"
+	opts := baseTestOptions()
+	opts.Providers[0].LoginURLParameters = []options.LoginURLParameter{
+		{Name: "prompt", Allow: []options.URLParameterRule{{Pattern: &anyValue}}},
+		{Name: "login_hint", Allow: []options.URLParameterRule{{Pattern: &anyValue}}},
+	}
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet,
+		"/oauth2/start?login_hint=user%2Bspa%40example.com%26prompt%3Dnone&prompt=login&redirect_uri=https%3A%2F%2Fevil.example.com&organization=other", nil))
+	require.Equal(t, http.StatusFound, rw.Code)
+
+	loginURL, err := url.Parse(rw.Header().Get("Location"))
+	require.NoError(t, err)
+	params := loginURL.Query()
+	assert.Equal(t, []string{"user+spa@example.com&prompt=none"}, params["login_hint"])
+	assert.Equal(t, []string{"login"}, params["prompt"])
+	assert.NotContains(t, params, "organization")
+	assert.Len(t, params["redirect_uri"], 1)
+	assert.NotEqual(t, "https://evil.example.com", params.Get("redirect_uri"))
+}
+
+type loginURLErrorProvider struct {
+	*TestProvider
+}
+
+func (p *loginURLErrorProvider) GetLoginURL(_, _, _ string, _ url.Values) (string, error) {
+	return "", errors.New("error signing request object")
+}
+
+func TestOAuthStartLoginURLError(t *testing.T) {
+	opts := baseTestOptions()
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	providerURL, _ := url.Parse("http://localhost/")
+	proxy.provider = &loginURLErrorProvider{TestProvider: NewTestProvider(providerURL, "michael.bland@gsa.gov")}
+
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fapp", nil))
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.Empty(t, rw.Header().Get("Location"))
+	assert.Empty(t, rw.Header().Values("Set-Cookie"))
+}
+
+func TestOAuthCallbackClearsCSRFCookie(t *testing.T) {
+	redeemFails := false
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if redeemFails {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	t.Cleanup(providerServer.Close)
+
+	opts := baseTestOptions()
+	opts.Cookie.CSRFExpire = 5 * time.Minute
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	providerURL, _ := url.Parse(providerServer.URL)
+	testProvider := NewTestProvider(providerURL, "michael.bland@gsa.gov")
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	startLogin := func(t *testing.T) (string, *http.Cookie) {
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fapp", nil))
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		csrfCookie := rw.Result().Cookies()[0]
+		require.Equal(t, "_oauth2_proxy_csrf", csrfCookie.Name)
+		return loginURL.Query().Get("state"), csrfCookie
+	}
+
+	t.Run("expires the CSRF cookie after the CSRF expiry", func(t *testing.T) {
+		before := time.Now()
+		_, csrfCookie := startLogin(t)
+		assert.WithinDuration(t, before.Add(5*time.Minute), csrfCookie.Expires, 2*time.Second)
+	})
+
+	testCases := map[string]struct {
+		query        string
+		redeemFails  bool
+		expectedCode int
+	}{
+		"after a successful login": {
+			query:        "code=callback_code",
+			expectedCode: http.StatusFound,
+		},
+		"after the provider returned an error": {
+			query:        "error=access_denied",
+			expectedCode: http.StatusForbidden,
+		},
+		"after a failed code redemption": {
+			query:        "code=callback_code",
+			redeemFails:  true,
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run("clears the CSRF cookie "+name, func(t *testing.T) {
+			redeemFails = tc.redeemFails
+			state, csrfCookie := startLogin(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?"+tc.query+"&state="+url.QueryEscape(state), nil)
+			req.AddCookie(csrfCookie)
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+
+			var cleared *http.Cookie
+			for _, c := range rw.Result().Cookies() {
+				if c.Name == csrfCookie.Name {
+					cleared = c
+				}
+			}
+			require.NotNil(t, cleared)
+			assert.Equal(t, "", cleared.Value)
+			assert.True(t, cleared.Expires.Before(time.Now()))
+		})
+	}
+}
+
+func TestRedirectRoundTrip(t *testing.T) {
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	t.Cleanup(providerServer.Close)
+
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.WhitelistDomains = []string{"example.com"}
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	providerURL, _ := url.Parse(providerServer.URL)
+	testProvider := NewTestProvider(providerURL, "michael.bland@gsa.gov")
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	startLogin := func(t *testing.T, startPath string) (string, *http.Cookie) {
+		rw := get(startPath)
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		return loginURL.Query().Get("state"), rw.Result().Cookies()[0]
+	}
+
+	callback := func(t *testing.T, state string, csrfCookie *http.Cookie) string {
+		rw := get("/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), csrfCookie)
+		require.Equal(t, http.StatusFound, rw.Code)
+		return rw.Header().Get("Location")
+	}
+
+	testCases := map[string]struct {
+		redirect         string
+		expectedLocation string
+	}{
+		"multiple query parameters": {
+			redirect:         "/app/page?tab=settings&view=a%2Fb&tab=profile",
+			expectedLocation: "/app/page?tab=settings&view=a%2Fb&tab=profile",
+		},
+		"encoded path": {
+			redirect:         "/app/caf%C3%A9/a%20b%2Fc?next=%2Fother%3Fx%3D1&q=%23hash",
+			expectedLocation: "/app/caf%C3%A9/a%20b%2Fc?next=%2Fother%3Fx%3D1&q=%23hash",
+		},
+		"fragment": {
+			redirect:         "/app/page?tab=settings#section-2",
+			expectedLocation: "/app/page?tab=settings#section-2",
+		},
+		"absolute URL": {
+			redirect:         "https://example.com/app?tab=settings&view=1",
+			expectedLocation: "https://example.com/app?tab=settings&view=1",
+		},
+		"open redirect": {
+			redirect:         "/\\evil.example.com/app?tab=settings",
+			expectedLocation: "/",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			state, csrfCookie := startLogin(t, "/oauth2/start?rd="+url.QueryEscape(tc.redirect))
+			assert.Equal(t, tc.expectedLocation, callback(t, state, csrfCookie))
+		})
+	}
+
+	t.Run("unauthenticated request", func(t *testing.T) {
+		proxy.SkipProviderButton = true
+		defer func() { proxy.SkipProviderButton = false }()
+
+		state, csrfCookie := startLogin(t, "/app/caf%C3%A9?tab=settings&view=a%2Fb")
+		assert.Equal(t, "/app/caf%C3%A9?tab=settings&view=a%2Fb", callback(t, state, csrfCookie))
+	})
+
+	t.Run("tampered state", func(t *testing.T) {
+		state, csrfCookie := startLogin(t, "/oauth2/start?rd=%2Fapp")
+		nonce := strings.SplitN(state, ":", 2)[0]
+		state = encodeState(nonce, "https://evil.example.com/app?tab=settings")
+		assert.Equal(t, "/", callback(t, state, csrfCookie))
+	})
+
+	t.Run("unencoded state", func(t *testing.T) {
+		state, csrfCookie := startLogin(t, "/oauth2/start?rd=%2Fapp")
+		nonce := strings.SplitN(state, ":", 2)[0]
+		state = nonce + ":/app/page?tab=settings&view=a%2Fb"
+		assert.Equal(t, "/app/page?tab=settings&view=a%2Fb", callback(t, state, csrfCookie))
+	})
+}
+
+// stepUpTestProvider redeems codes for sessions with an ID token that has
+// the acr claim
+type stepUpTestProvider struct {
+	*TestProvider
+	acr string
+}
+
+func (p *stepUpTestProvider) Redeem(_ context.Context, _, _, _ string) (*sessions.SessionState, error) {
+	return newStepUpTestSession(p.acr), nil
+}
+
+func newStepUpTestSession(acr string) *sessions.SessionState {
+	payload, _ := json.Marshal(map[string]interface{}{"acr": acr})
+	session := &sessions.SessionState{
+		Email:       "michael.bland@gsa.gov",
+		AccessToken: "my_auth_token",
+		IDToken:     "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".signature",
+	}
+	session.CreatedAtNow()
+	return session
+}
+
+func TestStepUpAuthentication(t *testing.T) {
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:     "default",
+				Path:   "/",
+				Static: true,
+			},
+			{
+				ID:        "admin",
+				Path:      "/admin/",
+				Static:    true,
+				ACRValues: []string{"urn:example:mfa", "urn:example:hwk"},
+			},
+		},
+	}
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	testProvider := &stepUpTestProvider{
+		TestProvider: NewTestProvider(&url.URL{Host: "localhost"}, "michael.bland@gsa.gov"),
+	}
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	rw := httptest.NewRecorder()
+	require.NoError(t, proxy.SaveSession(rw, httptest.NewRequest(http.MethodGet, "/", nil), newStepUpTestSession("urn:example:pwd")))
+	sessionCookie := rw.Result().Cookies()[0]
+
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// Upstreams without requirements accept the session
+	rw = get("/", sessionCookie)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	// Upstreams with requirements send the session to login again
+	startLogin := func() (string, *http.Cookie) {
+		rw := get("/admin/users", sessionCookie)
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/oauth/authorize", loginURL.Path)
+		assert.Equal(t, "urn:example:mfa urn:example:hwk", loginURL.Query().Get("acr_values"))
+		assert.Empty(t, loginURL.Query().Get("max_age"))
+		return loginURL.Query().Get("state"), rw.Result().Cookies()[0]
+	}
+
+	// The provider ignores the acr_values and authenticates with the same acr
+	testProvider.acr = "urn:example:pwd"
+	state, csrfCookie := startLogin()
+	rw = get("/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), csrfCookie)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Empty(t, rw.Header().Get("Location"))
+
+	// The provider authenticates with one of the acr_values
+	testProvider.acr = "urn:example:hwk"
+	state, csrfCookie = startLogin()
+	rw = get("/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), csrfCookie)
+	require.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/admin/users", rw.Header().Get("Location"))
+
+	var steppedUpCookie *http.Cookie
+	for _, cookie := range rw.Result().Cookies() {
+		if cookie.Name == opts.Cookie.Name {
+			steppedUpCookie = cookie
+		}
+	}
+	require.NotNil(t, steppedUpCookie)
+
+	rw = get("/admin/users", steppedUpCookie)
+	assert.Equal(t, http.StatusOK, rw.Code)
+}
+
+func TestStepUpMaxAgeUsesSessionClock(t *testing.T) {
+	opts := baseTestOptions()
+	require.NoError(t, validation.Validate(opts))
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	login := time.Unix(1700000000, 0)
+	session := &sessions.SessionState{Email: "michael.bland@gsa.gov", CreatedAt: &login}
+	stepUp := func(age time.Duration) (bool, int) {
+		session.Clock.Set(login.Add(age))
+		req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+		rw := httptest.NewRecorder()
+		return proxy.stepUp(rw, req, nil, 10*time.Minute), rw.Code
+	}
+
+	ok, _ := stepUp(5 * time.Minute)
+	assert.True(t, ok)
+
+	ok, code := stepUp(15 * time.Minute)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusFound, code)
+}
+
+func TestUpstreamStatusActionReauthenticate(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   "rejecting",
+				Path: "/",
+				URI:  upstreamServer.URL,
+				StatusActions: []options.UpstreamStatusAction{
+					{Status: http.StatusUnauthorized, Action: options.UpstreamStatusActionReauthenticate},
+				},
+			},
+		},
+	}
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	testProvider := NewTestProvider(&url.URL{Host: "localhost"}, "michael.bland@gsa.gov")
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	sessionCookie := func(age time.Duration) *http.Cookie {
+		createdAt := time.Now().Add(-age)
+		session := &sessions.SessionState{
+			Email:       "michael.bland@gsa.gov",
+			AccessToken: "my_auth_token",
+			CreatedAt:   &createdAt,
+		}
+		rw := httptest.NewRecorder()
+		require.NoError(t, proxy.SaveSession(rw, httptest.NewRequest(http.MethodGet, "/", nil), session))
+		return rw.Result().Cookies()[0]
+	}
+
+	get := func(cookie *http.Cookie, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+		req.AddCookie(cookie)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	t.Run("clears the session and starts a new login", func(t *testing.T) {
+		rw := get(sessionCookie(5*time.Minute), nil)
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/oauth/authorize", loginURL.Path)
+
+		var cleared bool
+		for _, cookie := range rw.Result().Cookies() {
+			if cookie.Name == opts.Cookie.Name {
+				cleared = cookie.Value == "" && cookie.Expires.Before(time.Now())
+			}
+		}
+		assert.True(t, cleared)
+	})
+
+	t.Run("responds with a 401 to AJAX requests", func(t *testing.T) {
+		rw := get(sessionCookie(5*time.Minute), map[string]string{"Accept": "application/json"})
+		assert.Equal(t, http.StatusUnauthorized, rw.Code)
+		assert.Empty(t, rw.Header().Get("Location"))
+	})
+
+	t.Run("does not loop when a new session is rejected", func(t *testing.T) {
+		rw := get(sessionCookie(0), nil)
+		assert.Equal(t, http.StatusUnauthorized, rw.Code)
+		assert.Empty(t, rw.Header().Get("Location"))
+	})
+}
+
+func TestSilentReauthentication(t *testing.T) {
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.Cookie.Refresh = time.Minute
+	opts.SilentReauth = true
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	testProvider := NewTestProvider(&url.URL{Host: "localhost"}, "michael.bland@gsa.gov")
+	testProvider.ValidToken = true
+	proxy.provider = testProvider
+
+	sessionCookie := func(refreshToken string) *http.Cookie {
+		createdAt := time.Now().Add(-5 * time.Minute)
+		expiresOn := time.Now().Add(-time.Minute)
+		session := &sessions.SessionState{
+			Email:        "michael.bland@gsa.gov",
+			AccessToken:  "my_auth_token",
+			RefreshToken: refreshToken,
+			CreatedAt:    &createdAt,
+			ExpiresOn:    &expiresOn,
+		}
+		rw := httptest.NewRecorder()
+		require.NoError(t, proxy.SaveSession(rw, httptest.NewRequest(http.MethodGet, "/", nil), session))
+		return rw.Result().Cookies()[0]
+	}
+
+	get := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	csrfCookieOf := func(rw *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rw.Result().Cookies() {
+			if cookie.Name == "_oauth2_proxy_csrf" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	startSilentLogin := func(t *testing.T) (string, *http.Cookie) {
+		rw := get("/reports", sessionCookie(""))
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/oauth/authorize", loginURL.Path)
+		assert.Equal(t, "none", loginURL.Query().Get("prompt"))
+		assert.Empty(t, loginURL.Query().Get("approval_prompt"))
+
+		csrfCookie := csrfCookieOf(rw)
+		require.NotNil(t, csrfCookie)
+		return loginURL.Query().Get("state"), csrfCookie
+	}
+
+	t.Run("starts a silent login for an expired session without a refresh token", func(t *testing.T) {
+		startSilentLogin(t)
+	})
+
+	t.Run("does not start a silent login for an expired session with a refresh token", func(t *testing.T) {
+		rw := get("/reports", sessionCookie("my_refresh_token"))
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Empty(t, rw.Header().Get("Location"))
+	})
+
+	t.Run("does not start a silent login without a session", func(t *testing.T) {
+		rw := get("/reports", nil)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Empty(t, rw.Header().Get("Location"))
+	})
+
+	for _, errorString := range []string{"login_required", "interaction_required", "consent_required", "account_selection_required"} {
+		t.Run("falls back to an interactive login on "+errorString, func(t *testing.T) {
+			state, csrfCookie := startSilentLogin(t)
+			rw := get("/oauth2/callback?error="+errorString+"&state="+url.QueryEscape(state), csrfCookie)
+			require.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, "/oauth2/start?rd=%2Freports", rw.Header().Get("Location"))
+
+			cleared := csrfCookieOf(rw)
+			require.NotNil(t, cleared)
+			assert.Empty(t, cleared.Value)
+
+			rw = get(rw.Header().Get("Location"), nil)
+			require.Equal(t, http.StatusFound, rw.Code)
+			loginURL, err := url.Parse(rw.Header().Get("Location"))
+			require.NoError(t, err)
+			assert.Empty(t, loginURL.Query().Get("prompt"))
+		})
+	}
+
+	t.Run("fails on other errors of a silent login", func(t *testing.T) {
+		state, csrfCookie := startSilentLogin(t)
+		rw := get("/oauth2/callback?error=access_denied&state="+url.QueryEscape(state), csrfCookie)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+	})
+
+	t.Run("fails on login_required for an interactive login", func(t *testing.T) {
+		rw := get("/oauth2/start?rd=%2Freports", nil)
+		require.Equal(t, http.StatusFound, rw.Code)
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+
+		rw = get("/oauth2/callback?error=login_required&state="+url.QueryEscape(loginURL.Query().Get("state")), csrfCookieOf(rw))
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+	})
+}
+
 type SignInPageTest struct {
 	opts                 *options.Options
 	proxy                *OAuthProxy
@@ -985,6 +1720,7 @@ func NewUserInfoEndpointTest() (*ProcessCookieTest, error) {
 }
 
 func TestUserInfoEndpointAccepted(t *testing.T) {
+	userInfoExpiresOn := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
 	testCases := []struct {
 		name             string
 		session          *sessions.SessionState
@@ -1029,6 +1765,17 @@ func TestUserInfoEndpointAccepted(t *testing.T) {
 			},
 			expectedResponse: "{\"user\":\"john.doe\",\"email\":\"john.doe@example.com\",\"groups\":[\"example\",\"groups\"],\"preferredUsername\":\"john\"}\n",
 		},
+		{
+			name: "With expiry",
+			session: &sessions.SessionState{
+				User:         "john.doe",
+				Email:        "john.doe@example.com",
+				AccessToken:  "my_access_token",
+				RefreshToken: "my_refresh_token",
+				ExpiresOn:    &userInfoExpiresOn,
+			},
+			expectedResponse: "{\"user\":\"john.doe\",\"email\":\"john.doe@example.com\",\"expiresOn\":\"2030-01-02T03:04:05Z\"}\n",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -1048,6 +1795,315 @@ func TestUserInfoEndpointAccepted(t *testing.T) {
 	}
 }
 
+func TestDeviceAuthorizationEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rw.Header().Set("Content-Type", applicationJSON)
+		if req.URL.Path == "/device" {
+			_, _ = rw.Write([]byte(`{"device_code":"device","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":600}`))
+			return
+		}
+		switch req.PostForm.Get("device_code") {
+		case "pending":
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"authorization_pending"}`))
+		case "denied":
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"access_denied"}`))
+		default:
+			_, _ = rw.Write([]byte(`{"access_token":"access","token_type":"Bearer","expires_in":300}`))
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	testCases := []struct {
+		name           string
+		path           string
+		deviceCode     string
+		validateUser   bool
+		expectedStatus int
+		expectedBody   string
+		expectCookie   bool
+	}{
+		{
+			name:           "Start returns the device and user codes",
+			path:           "/device/start",
+			validateUser:   true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "{\"device_code\":\"device\",\"user_code\":\"ABCD-EFGH\",\"verification_uri\":\"https://example.com/device\",\"expires_in\":600}\n",
+		},
+		{
+			name:           "Poll without a device code",
+			path:           "/device/poll",
+			validateUser:   true,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "{\"error\":\"invalid_request\",\"error_description\":\"missing device code\"}\n",
+		},
+		{
+			name:           "Poll while the authorization is pending",
+			path:           "/device/poll",
+			deviceCode:     "pending",
+			validateUser:   true,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "{\"error\":\"authorization_pending\"}\n",
+		},
+		{
+			name:           "Poll after the user denied the authorization",
+			path:           "/device/poll",
+			deviceCode:     "denied",
+			validateUser:   true,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "{\"error\":\"access_denied\"}\n",
+		},
+		{
+			name:           "Poll for an unauthorized user",
+			path:           "/device/poll",
+			deviceCode:     "authorized",
+			validateUser:   false,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "{\"error\":\"access_denied\",\"error_description\":\"unauthorized\"}\n",
+		},
+		{
+			name:           "Poll once the user authorized the device",
+			path:           "/device/poll",
+			deviceCode:     "authorized",
+			validateUser:   true,
+			expectedStatus: http.StatusOK,
+			expectCookie:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+				opts.Providers[0].DeviceAuthorizationURL = server.URL + "/device"
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider := NewTestProvider(serverURL, "john.doe@example.com")
+			provider.DeviceAuthorizationURL = &url.URL{Scheme: "http", Host: serverURL.Host, Path: "/device"}
+			test.proxy.provider = provider
+			test.validateUser = tc.validateUser
+
+			form := url.Values{}
+			if tc.deviceCode != "" {
+				form.Set("device_code", tc.deviceCode)
+			}
+			test.req, _ = http.NewRequest(http.MethodPost, test.opts.ProxyPrefix+tc.path, strings.NewReader(form.Encode()))
+			test.req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedStatus, test.rw.Code)
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, test.rw.Body.String())
+			}
+			assert.Equal(t, tc.expectCookie, len(test.rw.Result().Cookies()) > 0)
+		})
+	}
+}
+
+func TestDeviceAuthorizationEndpointsDisabled(t *testing.T) {
+	test, err := NewProcessCookieTestWithDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.req, _ = http.NewRequest(http.MethodPost, test.opts.ProxyPrefix+"/device/start", nil)
+
+	// Without a device authorization URL the request is handled by the
+	// normal authentication flow
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.NotEqual(t, http.StatusOK, test.rw.Code)
+	assert.NotEqual(t, applicationJSON, test.rw.Header().Get("Content-Type"))
+}
+
+func TestSignOutRevokesTokens(t *testing.T) {
+	var revoked []string
+	var revocationStatus int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		revoked = append(revoked, req.PostForm.Get("token"))
+		rw.WriteHeader(revocationStatus)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	testCases := []struct {
+		name             string
+		revocationURL    *url.URL
+		revocationStatus int
+		expectedRevoked  []string
+	}{
+		{
+			name:             "Tokens are revoked",
+			revocationURL:    &url.URL{Scheme: "http", Host: serverURL.Host, Path: "/revoke"},
+			revocationStatus: http.StatusOK,
+			expectedRevoked:  []string{"my_refresh_token", "my_access_token"},
+		},
+		{
+			name:             "Sign out completes when revocation fails",
+			revocationURL:    &url.URL{Scheme: "http", Host: serverURL.Host, Path: "/revoke"},
+			revocationStatus: http.StatusServiceUnavailable,
+			expectedRevoked:  []string{"my_refresh_token", "my_access_token"},
+		},
+		{
+			name:            "Tokens are not revoked without a revocation URL",
+			expectedRevoked: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			revoked = nil
+			revocationStatus = tc.revocationStatus
+
+			test, err := NewProcessCookieTestWithDefaults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider := NewTestProvider(serverURL, "john.doe@example.com")
+			provider.RevocationURL = tc.revocationURL
+			test.proxy.provider = provider
+
+			created := time.Now()
+			assert.NoError(t, test.SaveSession(&sessions.SessionState{
+				Email:        "john.doe@example.com",
+				AccessToken:  "my_access_token",
+				RefreshToken: "my_refresh_token",
+				CreatedAt:    &created,
+			}))
+
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, test.opts.ProxyPrefix+signOutPath, nil)
+			for _, cookie := range test.req.Cookies() {
+				req.AddCookie(cookie)
+			}
+			test.proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, tc.expectedRevoked, revoked)
+
+			cookies := rw.Result().Cookies()
+			assert.NotEmpty(t, cookies)
+			for _, cookie := range cookies {
+				assert.Equal(t, "", cookie.Value)
+			}
+		})
+	}
+}
+
+func TestSignOutEndSession(t *testing.T) {
+	endSessionURL := &url.URL{Scheme: "https", Host: "provider.example.com", Path: "/logout"}
+
+	testCases := []struct {
+		name             string
+		endSessionURL    *url.URL
+		withSession      bool
+		rd               string
+		expectedLocation string
+	}{
+		{
+			name:             "Redirects to the end session endpoint",
+			endSessionURL:    endSessionURL,
+			withSession:      true,
+			rd:               "/app",
+			expectedLocation: "https://provider.example.com/logout?client_id=client&id_token_hint=my_id_token&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2Fapp",
+		},
+		{
+			name:             "Does not pass an invalid redirect to the end session endpoint",
+			endSessionURL:    endSessionURL,
+			withSession:      true,
+			rd:               "https://evil.example.org/",
+			expectedLocation: "https://provider.example.com/logout?client_id=client&id_token_hint=my_id_token&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F",
+		},
+		{
+			name:             "Redirects locally without an end session endpoint",
+			withSession:      true,
+			rd:               "/app",
+			expectedLocation: "/app",
+		},
+		{
+			name:             "Redirects locally without a session",
+			endSessionURL:    endSessionURL,
+			rd:               "/app",
+			expectedLocation: "/app",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test, err := NewProcessCookieTestWithDefaults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider := NewTestProvider(&url.URL{Host: "provider.example.com"}, "john.doe@example.com")
+			provider.ClientID = "client"
+			provider.EndSessionURL = tc.endSessionURL
+			test.proxy.provider = provider
+
+			if tc.withSession {
+				created := time.Now()
+				assert.NoError(t, test.SaveSession(&sessions.SessionState{
+					Email:       "john.doe@example.com",
+					AccessToken: "my_access_token",
+					IDToken:     "my_id_token",
+					CreatedAt:   &created,
+				}))
+			}
+
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com"+test.opts.ProxyPrefix+signOutPath+"?rd="+url.QueryEscape(tc.rd), nil)
+			for _, cookie := range test.req.Cookies() {
+				req.AddCookie(cookie)
+			}
+			test.proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+		})
+	}
+}
+
+func TestAuthEndpointsRateLimited(t *testing.T) {
+	test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.RateLimitRequestsPerSecond = 1
+		opts.RateLimitBurst = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.proxy.provider.Data().LoginURL = &url.URL{Scheme: "https", Host: "provider.example.com", Path: "/login"}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		test.proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	assert.Equal(t, http.StatusFound, serve(test.opts.ProxyPrefix+oauthStartPath).Code)
+	rw := serve(test.opts.ProxyPrefix + oauthStartPath)
+	assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+	assert.NotEmpty(t, rw.Header().Get("Retry-After"))
+
+	// The limit is shared between the auth endpoints
+	assert.Equal(t, http.StatusTooManyRequests, serve(test.opts.ProxyPrefix+oauthCallbackPath).Code)
+
+	// Other requests are not limited
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, http.StatusTooManyRequests, serve("/upstream").Code)
+		assert.NotEqual(t, http.StatusTooManyRequests, serve(test.opts.ProxyPrefix+signOutPath).Code)
+	}
+}
+
 func TestUserInfoEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
 	test, err := NewUserInfoEndpointTest()
 	if err != nil {
@@ -1058,6 +2114,63 @@ func TestUserInfoEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, test.rw.Code)
 }
 
+func TestUserInfoEndpointUnauthenticatedNeverSeesClaims(t *testing.T) {
+	session := &sessions.SessionState{
+		User:         "john.doe",
+		Email:        "john.doe@example.com",
+		Groups:       []string{"example"},
+		AccessToken:  "my_access_token",
+		RefreshToken: "my_refresh_token",
+	}
+
+	testCases := []struct {
+		name         string
+		modifiers    []OptionsModifier
+		saveSession  bool
+		validateUser bool
+	}{
+		{
+			name:         "No session with skip auth",
+			modifiers:    []OptionsModifier{func(opts *options.Options) { opts.SkipAuthRegex = []string{".*"} }},
+			validateUser: true,
+		},
+		{
+			name:         "Unauthorized session",
+			saveSession:  true,
+			validateUser: false,
+		},
+		{
+			name:         "Unauthorized session with skip auth",
+			modifiers:    []OptionsModifier{func(opts *options.Options) { opts.SkipAuthRegex = []string{".*"} }},
+			saveSession:  true,
+			validateUser: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test, err := NewProcessCookieTestWithOptionsModifiers(tc.modifiers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.req, _ = http.NewRequest("GET", test.opts.ProxyPrefix+"/userinfo", nil)
+			if tc.saveSession {
+				assert.NoError(t, test.SaveSession(session))
+			}
+			test.validateUser = tc.validateUser
+			test.rw = httptest.NewRecorder()
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, http.StatusUnauthorized, test.rw.Code)
+
+			body := test.rw.Body.String()
+			for _, value := range []string{session.User, session.Email, session.AccessToken, session.RefreshToken} {
+				assert.NotContains(t, body, value)
+			}
+		})
+	}
+}
+
 func TestEncodedUrlsStayEncoded(t *testing.T) {
 	encodeTest, err := NewSignInPageTest(false)
 	if err != nil {
@@ -1401,8 +2514,134 @@ func TestAuthOnlyEndpointSetBasicAuthFalseRequestHeaders(t *testing.T) {
 	assert.Equal(t, 0, len(pcTest.rw.Header().Values("Authorization")), "should not have Authorization header entries")
 }
 
-func TestAuthSkippedForPreflightRequests(t *testing.T) {
+func TestAuthSkippedForPreflightRequests(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, err := w.Write([]byte("response"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   upstreamServer.URL,
+				Path: "/",
+				URI:  upstreamServer.URL,
+			},
+		},
+	}
+	opts.SkipAuthPreflight = true
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	upstreamURL, _ := url.Parse(upstreamServer.URL)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.provider = NewTestProvider(upstreamURL, "")
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "/preflight-request", nil)
+	proxy.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "response", rw.Body.String())
+}
+
+func TestAccessDeniedPage(t *testing.T) {
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	t.Cleanup(providerServer.Close)
+
+	opts := baseTestOptions()
+	opts.Cookie.Secure = false
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:     "default",
+				Path:   "/",
+				Static: true,
+			},
+		},
+	}
+	opts.Templates.AccessDeniedStatusCode = http.StatusNotFound
+	opts.Templates.AccessDeniedContactURL = "https://help.example.com/access"
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(email string) bool { return email == "allowed@example.com" })
+	require.NoError(t, err)
+	providerURL, _ := url.Parse(providerServer.URL)
+	proxy.provider = NewTestProvider(providerURL, "denied@example.com")
+	proxy.provider.(*TestProvider).ValidToken = true
+
+	rw := httptest.NewRecorder()
+	session := &sessions.SessionState{Email: "denied@example.com", AccessToken: "my_auth_token"}
+	require.NoError(t, proxy.SaveSession(rw, httptest.NewRequest(http.MethodGet, "/", nil), session))
+	sessionCookie := rw.Result().Cookies()[0]
+
+	get := func(path string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	assertAccessDeniedPage := func(t *testing.T, rw *httptest.ResponseRecorder) {
+		assert.Equal(t, http.StatusNotFound, rw.Code)
+		assert.Empty(t, rw.Header().Get("Location"))
+		assert.Contains(t, rw.Body.String(), "You are signed in as <strong>denied@example.com</strong>")
+		assert.Contains(t, rw.Body.String(), `<a href="https://help.example.com/access">`)
+	}
+
+	t.Run("authenticated but unauthorized session", func(t *testing.T) {
+		rw := get("/app", nil, sessionCookie)
+		assertAccessDeniedPage(t, rw)
+
+		// The session is cleared
+		require.Len(t, rw.Result().Cookies(), 1)
+		assert.Equal(t, opts.Cookie.Name, rw.Result().Cookies()[0].Name)
+		assert.Empty(t, rw.Result().Cookies()[0].Value)
+	})
+
+	t.Run("authenticated but unauthorized ajax request", func(t *testing.T) {
+		rw := get("/app", http.Header{"Accept": []string{"application/json"}}, sessionCookie)
+		assert.Equal(t, http.StatusNotFound, rw.Code)
+		assert.Equal(t, "{}", rw.Body.String())
+	})
+
+	t.Run("unauthorized login", func(t *testing.T) {
+		rw := get("/oauth2/start?rd=%2Fapp", nil)
+		require.Equal(t, http.StatusFound, rw.Code)
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		csrfCookie := rw.Result().Cookies()[0]
+
+		rw = get("/oauth2/callback?code=callback_code&state="+url.QueryEscape(loginURL.Query().Get("state")), nil, csrfCookie)
+		assertAccessDeniedPage(t, rw)
+	})
+
+	t.Run("unauthenticated request", func(t *testing.T) {
+		rw := get("/app", nil)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.NotContains(t, rw.Body.String(), "You are signed in as")
+	})
+}
+
+func TestPageResponseHeaders(t *testing.T) {
 	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
 		w.WriteHeader(200)
 		_, err := w.Write([]byte("response"))
 		if err != nil {
@@ -1421,23 +2660,67 @@ func TestAuthSkippedForPreflightRequests(t *testing.T) {
 			},
 		},
 	}
-	opts.SkipAuthPreflight = true
+	opts.SkipAuthRoutes = []string{"GET=^/public"}
+	opts.Templates.ResponseHeaders = []string{
+		"Content-Security-Policy: default-src 'self'",
+		"Cache-Control: no-store",
+	}
 	err := validation.Validate(opts)
 	assert.NoError(t, err)
 
-	upstreamURL, _ := url.Parse(upstreamServer.URL)
-
 	proxy, err := NewOAuthProxy(opts, func(string) bool { return false })
 	if err != nil {
 		t.Fatal(err)
 	}
-	proxy.provider = NewTestProvider(upstreamURL, "")
-	rw := httptest.NewRecorder()
-	req, _ := http.NewRequest("OPTIONS", "/preflight-request", nil)
-	proxy.ServeHTTP(rw, req)
 
-	assert.Equal(t, 200, rw.Code)
-	assert.Equal(t, "response", rw.Body.String())
+	testCases := []struct {
+		name                string
+		path                string
+		expectedCode        int
+		expectedCSP         string
+		expectedCacheHeader string
+	}{
+		{
+			name:                "sign in page",
+			path:                "/oauth2/sign_in",
+			expectedCode:        http.StatusOK,
+			expectedCSP:         "default-src 'self'",
+			expectedCacheHeader: "no-store",
+		},
+		{
+			name:                "error page",
+			path:                "/oauth2/callback?error=access_denied",
+			expectedCode:        http.StatusForbidden,
+			expectedCSP:         "default-src 'self'",
+			expectedCacheHeader: "no-store",
+		},
+		{
+			name:                "sign out",
+			path:                "/oauth2/sign_out",
+			expectedCode:        http.StatusFound,
+			expectedCSP:         "default-src 'self'",
+			expectedCacheHeader: "no-store",
+		},
+		{
+			name:                "upstream response",
+			path:                "/public",
+			expectedCode:        http.StatusOK,
+			expectedCSP:         "",
+			expectedCacheHeader: "public, max-age=60",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			assert.Equal(t, tc.expectedCSP, rw.Header().Get("Content-Security-Policy"))
+			assert.Equal(t, []string{tc.expectedCacheHeader}, rw.Header().Values("Cache-Control"))
+		})
+	}
 }
 
 type SignatureAuthenticator struct {
@@ -1672,7 +2955,7 @@ func testAjaxUnauthorizedRequest(t *testing.T, header http.Header, forceJSONErro
 	assert.Equal(t, http.StatusUnauthorized, code)
 	mime := rh.Get("Content-Type")
 	assert.Equal(t, applicationJSON, mime)
-	assert.Equal(t, []byte("{}"), body)
+	assert.JSONEq(t, `{"error": "unauthorized", "loginURL": "/oauth2/start?rd=%2Ftest"}`, string(body))
 }
 func TestAjaxUnauthorizedRequest1(t *testing.T) {
 	header := make(http.Header)
@@ -1713,6 +2996,172 @@ func TestAjaxForbiddendRequest(t *testing.T) {
 	assert.NotEqual(t, applicationJSON, mime)
 }
 
+func TestAjaxRequestHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		header       http.Header
+		expectedCode int
+		expectedBody string
+	}{
+		"browser navigation": {
+			header:       http.Header{"Sec-Fetch-Mode": []string{"navigate"}},
+			expectedCode: http.StatusForbidden,
+		},
+		"header with a value": {
+			header:       http.Header{"X-Requested-With": []string{"XMLHttpRequest"}},
+			expectedCode: http.StatusPreconditionFailed,
+			expectedBody: `{"error": "unauthorized", "loginURL": "/oauth2/start?rd=%2Fapi%2Fitems%3Fpage%3D2"}`,
+		},
+		"header with another value": {
+			header:       http.Header{"X-Requested-With": []string{"com.example.app"}},
+			expectedCode: http.StatusForbidden,
+		},
+		"header without a value": {
+			header:       http.Header{"X-Fetch": []string{"1"}},
+			expectedCode: http.StatusPreconditionFailed,
+			expectedBody: `{"error": "unauthorized", "loginURL": "/oauth2/start?rd=%2Fapi%2Fitems%3Fpage%3D2"}`,
+		},
+		"accepting JSON": {
+			header:       http.Header{"Accept": []string{applicationJSON}},
+			expectedCode: http.StatusPreconditionFailed,
+			expectedBody: `{"error": "unauthorized", "loginURL": "/oauth2/start?rd=%2Fapi%2Fitems%3Fpage%3D2"}`,
+		},
+		"with the page to return to": {
+			header: http.Header{
+				"X-Requested-With":        []string{"xmlhttprequest"},
+				"X-Auth-Request-Redirect": []string{"/app/items"},
+			},
+			expectedCode: http.StatusPreconditionFailed,
+			expectedBody: `{"error": "unauthorized", "loginURL": "/oauth2/start?rd=%2Fapp%2Fitems"}`,
+		},
+	}
+
+	opts := baseTestOptions()
+	opts.AjaxRequestHeaders = []string{"X-Requested-With: XMLHttpRequest", "X-Fetch"}
+	opts.AjaxUnauthorizedCode = http.StatusPreconditionFailed
+	require.NoError(t, validation.Validate(opts))
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/items?page=2", nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedBody == "" {
+				assert.NotEqual(t, applicationJSON, rw.Header().Get("Content-Type"))
+				return
+			}
+			assert.Equal(t, applicationJSON, rw.Header().Get("Content-Type"))
+			assert.JSONEq(t, tc.expectedBody, rw.Body.String())
+		})
+	}
+}
+
+func TestDefaultRootAction(t *testing.T) {
+	testCases := map[string]struct {
+		action             string
+		skipProviderButton bool
+		path               string
+		header             http.Header
+		expectedCode       int
+		expectedLocation   string
+		expectedBody       string
+	}{
+		"sign in at the root": {
+			action:       options.DefaultRootActionSignIn,
+			path:         "/",
+			expectedCode: http.StatusForbidden,
+			expectedBody: "Sign in with",
+		},
+		"sign in skipping the provider button at the root": {
+			action:             options.DefaultRootActionSignIn,
+			skipProviderButton: true,
+			path:               "/",
+			expectedCode:       http.StatusFound,
+			expectedLocation:   "https://accounts.google.com/o/oauth2/auth?",
+		},
+		"welcome at the root": {
+			action:       options.DefaultRootActionWelcome,
+			path:         "/",
+			expectedCode: http.StatusOK,
+			expectedBody: "<title>Welcome</title>",
+		},
+		"welcome at another path": {
+			action:       options.DefaultRootActionWelcome,
+			path:         "/welcome",
+			expectedCode: http.StatusForbidden,
+			expectedBody: "Sign in with",
+		},
+		"welcome for an AJAX request at the root": {
+			action:       options.DefaultRootActionWelcome,
+			path:         "/",
+			header:       http.Header{"Accept": []string{applicationJSON}},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":"unauthorized","loginURL":"/oauth2/start?rd=%2F"}`,
+		},
+		"redirect at the root": {
+			action:           options.DefaultRootActionRedirect,
+			path:             "/",
+			expectedCode:     http.StatusFound,
+			expectedLocation: "https://www.example.com/landing",
+		},
+		"redirect at the root with a query": {
+			action:           options.DefaultRootActionRedirect,
+			path:             "/?foo=bar",
+			expectedCode:     http.StatusFound,
+			expectedLocation: "https://www.example.com/landing",
+		},
+		"redirect at another path": {
+			action:       options.DefaultRootActionRedirect,
+			path:         "/foo/",
+			expectedCode: http.StatusForbidden,
+			expectedBody: "Sign in with",
+		},
+		"login at the root": {
+			action:           options.DefaultRootActionLogin,
+			path:             "/",
+			expectedCode:     http.StatusFound,
+			expectedLocation: "https://accounts.google.com/o/oauth2/auth?",
+		},
+		"login at another path": {
+			action:       options.DefaultRootActionLogin,
+			path:         "/foo",
+			expectedCode: http.StatusForbidden,
+			expectedBody: "Sign in with",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.DefaultRootAction = tc.action
+			opts.DefaultRootRedirectURL = "https://www.example.com/landing"
+			opts.SkipProviderButton = tc.skipProviderButton
+			require.NoError(t, validation.Validate(opts))
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedLocation != "" {
+				assert.True(t, strings.HasPrefix(rw.Header().Get("Location"), tc.expectedLocation), rw.Header().Get("Location"))
+			}
+			assert.Contains(t, rw.Body.String(), tc.expectedBody)
+		})
+	}
+}
+
 func TestClearSplitCookie(t *testing.T) {
 	opts := baseTestOptions()
 	opts.Cookie.Secret = base64CookieSecret
@@ -1934,6 +3383,161 @@ func Test_prepareNoCache(t *testing.T) {
 	}
 }
 
+func TestAPIKeySession(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(r.Header.Get("X-Forwarded-User") + " " + r.Header.Get("X-API-Key")))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	apiKeysFile := filepath.Join(t.TempDir(), "api-keys.yaml")
+	require.NoError(t, os.WriteFile(apiKeysFile, []byte("- key: ci-0123456789abcdef\n  user: ci-bot\n"), 0600))
+
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   upstreamServer.URL,
+				Path: "/",
+				URI:  upstreamServer.URL,
+			},
+		},
+	}
+	opts.APIKeysFile = apiKeysFile
+	require.NoError(t, validation.Validate(opts))
+	proxy, err := NewOAuthProxy(opts, func(_ string) bool { return true })
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		apiKey       string
+		expectedCode int
+		expectedBody string
+	}{
+		"with a valid key": {
+			apiKey:       "ci-0123456789abcdef",
+			expectedCode: http.StatusOK,
+			expectedBody: "ci-bot ",
+		},
+		"with an invalid key": {
+			apiKey:       "ci-0123456789abcdeX",
+			expectedCode: http.StatusForbidden,
+		},
+		"without a key": {
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.apiKey != "" {
+				req.Header.Set("X-API-Key", tc.apiKey)
+			}
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, rw.Body.String())
+			}
+			// The session only lives for the request
+			assert.Empty(t, rw.Header().Values("Set-Cookie"))
+		})
+	}
+}
+
+func TestInvalidateUserSessions(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(r.Header.Get("X-Forwarded-User")))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   upstreamServer.URL,
+				Path: "/",
+				URI:  upstreamServer.URL,
+			},
+		},
+	}
+	opts.Cookie.Secure = false
+	opts.Session.Type = options.RedisSessionStoreType
+	opts.Session.Redis.ConnectionURL = "redis://" + mr.Addr()
+	opts.AdminAPIToken = "admin-token"
+	opts.AdminAPIAllowedIPs = []string{"192.0.2.0/24"}
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	login := func(user, email string) *http.Cookie {
+		rw := httptest.NewRecorder()
+		session := &sessions.SessionState{User: user, Email: email, AccessToken: "access_token"}
+		session.CreatedAtNow()
+		require.NoError(t, proxy.SaveSession(rw, httptest.NewRequest(http.MethodGet, "/", nil), session))
+		return rw.Result().Cookies()[0]
+	}
+	get := func(cookie *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	invalidate := func(remoteAddr, token, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/oauth2/admin/sessions/invalidate", strings.NewReader(url.Values{"user": {user}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	laptop := login("john", "john@example.com")
+	phone := login("john", "john@example.com")
+	other := login("jane", "jane@example.com")
+	for _, cookie := range []*http.Cookie{laptop, phone, other} {
+		require.Equal(t, http.StatusOK, get(cookie))
+	}
+
+	// Only admin clients from the allowed IPs can invalidate sessions
+	assert.Equal(t, http.StatusForbidden, invalidate("198.51.100.1:1234", "admin-token", "john").Code)
+	assert.Equal(t, http.StatusUnauthorized, invalidate("192.0.2.1:1234", "", "john").Code)
+	assert.Equal(t, http.StatusUnauthorized, invalidate("192.0.2.1:1234", "wrong-token", "john").Code)
+	assert.Equal(t, http.StatusBadRequest, invalidate("192.0.2.1:1234", "admin-token", "").Code)
+	assert.Equal(t, http.StatusOK, get(laptop))
+
+	// The sessions can be found by the email of the user
+	rw := invalidate("192.0.2.1:1234", "admin-token", "john@example.com")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, applicationJSON, rw.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"user":"john@example.com","sessions":2}`, rw.Body.String())
+
+	// The invalidated sessions are rejected, other users are not affected
+	assert.Equal(t, http.StatusForbidden, get(laptop))
+	assert.Equal(t, http.StatusForbidden, get(phone))
+	assert.Equal(t, http.StatusOK, get(other))
+
+	// The sessions are not counted again through the index of the user
+	rw = invalidate("192.0.2.1:1234", "admin-token", "john")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.JSONEq(t, `{"user":"john","sessions":0}`, rw.Body.String())
+}
+
 func Test_noCacheHeaders(t *testing.T) {
 	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte("upstream"))
@@ -2077,6 +3681,7 @@ func TestTrustedIPs(t *testing.T) {
 	tests := []struct {
 		name               string
 		trustedIPs         []string
+		trustedProxyCIDRs  []string
 		reverseProxy       bool
 		realClientIPHeader string
 		req                *http.Request
@@ -2198,56 +3803,246 @@ func TestTrustedIPs(t *testing.T) {
 			}(),
 			expectTrusted: false,
 		},
-		// Check doesn't trust if garbage is provided (no reverse-proxy).
+		// Check trusts the client IP added by the trusted proxies.
+		{
+			name:               "TrustsClientBehindTrustedProxies",
+			trustedIPs:         []string{"127.0.0.0/8", "::1"},
+			trustedProxyCIDRs:  []string{"10.0.0.0/8"},
+			reverseProxy:       true,
+			realClientIPHeader: "X-Forwarded-For",
+			req: func() *http.Request {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "10.0.0.1:43670"
+				req.Header.Add("X-Forwarded-For", "12.34.56.78, 127.0.0.1, 10.0.0.2, 10.0.0.1")
+				return req
+			}(),
+			expectTrusted: true,
+		},
+		// Check doesn't trust a trusted IP spoofed by the client in front of the trusted proxies.
+		{
+			name:               "DoesNotTrustSpoofedIPBehindTrustedProxies",
+			trustedIPs:         []string{"127.0.0.0/8", "::1"},
+			trustedProxyCIDRs:  []string{"10.0.0.0/8"},
+			reverseProxy:       true,
+			realClientIPHeader: "X-Forwarded-For",
+			req: func() *http.Request {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "10.0.0.1:43670"
+				req.Header.Add("X-Forwarded-For", "127.0.0.1, 12.34.56.78, 10.0.0.1")
+				return req
+			}(),
+			expectTrusted: false,
+		},
+		// Check doesn't trust a trusted IP spoofed by a client connecting directly.
+		{
+			name:               "DoesNotTrustSpoofedIPFromUntrustedPeer",
+			trustedIPs:         []string{"127.0.0.0/8", "::1"},
+			trustedProxyCIDRs:  []string{"10.0.0.0/8"},
+			reverseProxy:       true,
+			realClientIPHeader: "X-Forwarded-For",
+			req: func() *http.Request {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "12.34.56.78:43670"
+				req.Header.Add("X-Forwarded-For", "127.0.0.1")
+				return req
+			}(),
+			expectTrusted: false,
+		},
+		// Check doesn't trust a trusted IP spoofed in a separate header.
+		{
+			name:               "DoesNotTrustSpoofedHeaderBehindTrustedProxies",
+			trustedIPs:         []string{"127.0.0.0/8", "::1"},
+			trustedProxyCIDRs:  []string{"10.0.0.0/8"},
+			reverseProxy:       true,
+			realClientIPHeader: "X-Forwarded-For",
+			req: func() *http.Request {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "10.0.0.1:43670"
+				req.Header.Add("X-Forwarded-For", "127.0.0.1")
+				req.Header.Add("X-Forwarded-For", "12.34.56.78, 10.0.0.1")
+				return req
+			}(),
+			expectTrusted: false,
+		},
+		// Check doesn't trust if garbage is provided (no reverse-proxy).
+		{
+			name:               "DoesNotTrustGarbage",
+			trustedIPs:         []string{"127.0.0.0/8", "::1"},
+			reverseProxy:       false,
+			realClientIPHeader: "X-Real-IP",
+			req: func() *http.Request {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "adsfljk29242as!!"
+				return req
+			}(),
+			expectTrusted: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.UpstreamServers = options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:     "static",
+						Path:   "/",
+						Static: true,
+					},
+				},
+			}
+			opts.TrustedIPs = tt.trustedIPs
+			opts.TrustedProxyCIDRs = tt.trustedProxyCIDRs
+			opts.ReverseProxy = tt.reverseProxy
+			opts.RealClientIPHeader = tt.realClientIPHeader
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			assert.NoError(t, err)
+			rw := httptest.NewRecorder()
+
+			proxy.ServeHTTP(rw, tt.req)
+			if tt.expectTrusted {
+				assert.Equal(t, 200, rw.Code)
+			} else {
+				assert.Equal(t, 403, rw.Code)
+			}
+		})
+	}
+}
+
+func TestTrustedIPIdentityHeaders(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, err := w.Write([]byte(r.Header.Get("X-Forwarded-User") + "|" + r.Header.Get("X-Forwarded-Email")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	testCases := []struct {
+		name              string
+		trustedIPUser     string
+		trustedProxyCIDRs []string
+		remoteAddr        string
+		forwardedFor      string
+		expectedCode      int
+		expectedBody      string
+	}{
+		{
+			name:         "trusted IP without identity",
+			remoteAddr:   "10.1.2.3:43670",
+			expectedCode: http.StatusOK,
+			expectedBody: "|",
+		},
+		{
+			name:          "trusted IP with synthetic identity",
+			trustedIPUser: "monitoring",
+			remoteAddr:    "10.1.2.3:43670",
+			expectedCode:  http.StatusOK,
+			expectedBody:  "monitoring|",
+		},
+		{
+			name:          "untrusted IP",
+			trustedIPUser: "monitoring",
+			remoteAddr:    "12.34.56.78:43670",
+			expectedCode:  http.StatusForbidden,
+		},
 		{
-			name:               "DoesNotTrustGarbage",
-			trustedIPs:         []string{"127.0.0.0/8", "::1"},
-			reverseProxy:       false,
-			realClientIPHeader: "X-Real-IP",
-			req: func() *http.Request {
-				req, _ := http.NewRequest("GET", "/", nil)
-				req.RemoteAddr = "adsfljk29242as!!"
-				return req
-			}(),
-			expectTrusted: false,
+			name:              "trusted IP behind a trusted proxy",
+			trustedIPUser:     "monitoring",
+			trustedProxyCIDRs: []string{"192.168.0.0/16"},
+			remoteAddr:        "192.168.1.1:43670",
+			forwardedFor:      "10.1.2.3",
+			expectedCode:      http.StatusOK,
+			expectedBody:      "monitoring|",
+		},
+		{
+			name:              "trusted IP spoofed by an untrusted peer",
+			trustedIPUser:     "monitoring",
+			trustedProxyCIDRs: []string{"192.168.0.0/16"},
+			remoteAddr:        "12.34.56.78:43670",
+			forwardedFor:      "10.1.2.3",
+			expectedCode:      http.StatusForbidden,
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
 			opts := baseTestOptions()
 			opts.UpstreamServers = options.UpstreamConfig{
 				Upstreams: []options.Upstream{
 					{
-						ID:     "static",
-						Path:   "/",
-						Static: true,
+						ID:   upstreamServer.URL,
+						Path: "/",
+						URI:  upstreamServer.URL,
 					},
 				},
 			}
-			opts.TrustedIPs = tt.trustedIPs
-			opts.ReverseProxy = tt.reverseProxy
-			opts.RealClientIPHeader = tt.realClientIPHeader
+			opts.TrustedIPs = []string{"10.0.0.0/8"}
+			opts.TrustedIPUser = tc.trustedIPUser
+			if len(tc.trustedProxyCIDRs) > 0 {
+				opts.ReverseProxy = true
+				opts.RealClientIPHeader = "X-Forwarded-For"
+				opts.TrustedProxyCIDRs = tc.trustedProxyCIDRs
+			}
 			err := validation.Validate(opts)
 			assert.NoError(t, err)
 
 			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
 			assert.NoError(t, err)
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			// Identity headers sent by the client must not reach the upstream
+			req.Header.Set("X-Forwarded-User", "admin")
+			req.Header.Set("X-Forwarded-Email", "admin@example.com")
+
 			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
 
-			proxy.ServeHTTP(rw, tt.req)
-			if tt.expectTrusted {
-				assert.Equal(t, 200, rw.Code)
-			} else {
-				assert.Equal(t, 403, rw.Code)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, tc.expectedBody, rw.Body.String())
 			}
 		})
 	}
 }
 
+func TestUpstreamHeadersAreAppliedAfterIdentityHeaders(t *testing.T) {
+	opts := baseTestOptions()
+	opts.SetUpstreamHeaders = []string{"X-Forwarded-User: static", "X-Env: prod"}
+	opts.StripUpstreamHeaders = []string{"Cookie"}
+	assert.NoError(t, validation.Validate(opts))
+
+	chain, err := buildHeadersChain(opts, &pagewriter.WriterFuncs{})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "_oauth2_proxy=session")
+	req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+		Session: &sessions.SessionState{User: "jdoe", Email: "jdoe@example.com"},
+	})
+
+	var gotHeaders http.Header
+	chain.ThenFunc(func(_ http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header
+	}).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "static", gotHeaders.Get("X-Forwarded-User"))
+	assert.Equal(t, "jdoe@example.com", gotHeaders.Get("X-Forwarded-Email"))
+	assert.Equal(t, "prod", gotHeaders.Get("X-Env"))
+	assert.Empty(t, gotHeaders.Values("Cookie"))
+}
+
 func Test_buildRoutesAllowlist(t *testing.T) {
 	type expectedAllowedRoute struct {
-		method      string
+		methods     []string
 		negate      bool
 		regexString string
 	}
@@ -2275,12 +4070,10 @@ func Test_buildRoutesAllowlist(t *testing.T) {
 			skipAuthRoutes: []string{},
 			expectedRoutes: []expectedAllowedRoute{
 				{
-					method:      "",
 					negate:      false,
 					regexString: "^/foo/bar",
 				},
 				{
-					method:      "",
 					negate:      false,
 					regexString: "^/baz/[0-9]+/thing",
 				},
@@ -2301,37 +4094,56 @@ func Test_buildRoutesAllowlist(t *testing.T) {
 			},
 			expectedRoutes: []expectedAllowedRoute{
 				{
-					method:      "GET",
+					methods:     []string{"GET"},
 					negate:      false,
 					regexString: "^/foo/bar",
 				},
 				{
-					method:      "POST",
+					methods:     []string{"POST"},
 					negate:      false,
 					regexString: "^/baz/[0-9]+/thing",
 				},
 				{
-					method:      "",
 					negate:      false,
 					regexString: "^/all/methods$",
 				},
 				{
-					method:      "WEIRD",
+					methods:     []string{"WEIRD"},
 					negate:      false,
 					regexString: "^/methods/are/allowed",
 				},
 				{
-					method:      "PATCH",
+					methods:     []string{"PATCH"},
 					negate:      false,
 					regexString: "/second/equals?are=handled&just=fine",
 				},
 				{
-					method:      "",
 					negate:      true,
 					regexString: "^/api",
 				},
 				{
-					method:      "METHOD",
+					methods:     []string{"METHOD"},
+					negate:      true,
+					regexString: "^/api",
+				},
+			},
+			shouldError: false,
+		},
+		{
+			name:          "skipAuthRoutes with several methods configured",
+			skipAuthRegex: []string{},
+			skipAuthRoutes: []string{
+				"GET|HEAD=^/healthz$",
+				"get|post!=^/api",
+			},
+			expectedRoutes: []expectedAllowedRoute{
+				{
+					methods:     []string{"GET", "HEAD"},
+					negate:      false,
+					regexString: "^/healthz$",
+				},
+				{
+					methods:     []string{"GET", "POST"},
 					negate:      true,
 					regexString: "^/api",
 				},
@@ -2351,23 +4163,20 @@ func Test_buildRoutesAllowlist(t *testing.T) {
 			},
 			expectedRoutes: []expectedAllowedRoute{
 				{
-					method:      "",
 					regexString: "^/foo/bar/regex",
 				},
 				{
-					method:      "",
 					regexString: "^/baz/[0-9]+/thing/regex",
 				},
 				{
-					method:      "GET",
+					methods:     []string{"GET"},
 					regexString: "^/foo/bar",
 				},
 				{
-					method:      "POST",
+					methods:     []string{"POST"},
 					regexString: "^/baz/[0-9]+/thing",
 				},
 				{
-					method:      "",
 					regexString: "^/all/methods$",
 				},
 			},
@@ -2413,7 +4222,7 @@ func Test_buildRoutesAllowlist(t *testing.T) {
 
 			for i, route := range routes {
 				assert.Greater(t, len(tc.expectedRoutes), i)
-				assert.Equal(t, route.method, tc.expectedRoutes[i].method)
+				assert.Equal(t, route.methods, tc.expectedRoutes[i].methods)
 				assert.Equal(t, route.negate, tc.expectedRoutes[i].negate)
 				assert.Equal(t, route.pathRegex.String(), tc.expectedRoutes[i].regexString)
 			}
@@ -2556,6 +4365,11 @@ func TestAllowedRequest(t *testing.T) {
 	}
 	opts.SkipAuthRoutes = []string{
 		"GET=^/skip/auth/routes/get",
+		"GET|PUT=^/skip/auth/routes/methods$",
+		"GET|PUT=^/skip/auth/routes/overlap",
+		"POST=^/skip/auth/routes/overlap/post$",
+		"GET=^/skip/auth/routes/all$",
+		"^/skip/auth/routes/all$",
 	}
 	err := validation.Validate(opts)
 	assert.NoError(t, err)
@@ -2612,6 +4426,48 @@ func TestAllowedRequest(t *testing.T) {
 			url:     "/skip/auth/routes/wrong/path",
 			allowed: false,
 		},
+		{
+			name:    "Route with methods allowed with the first method",
+			method:  "GET",
+			url:     "/skip/auth/routes/methods",
+			allowed: true,
+		},
+		{
+			name:    "Route with methods allowed with the second method",
+			method:  "PUT",
+			url:     "/skip/auth/routes/methods",
+			allowed: true,
+		},
+		{
+			name:    "Route with methods denied with another method",
+			method:  "POST",
+			url:     "/skip/auth/routes/methods",
+			allowed: false,
+		},
+		{
+			name:    "Overlapping routes allowed with the method of the first route",
+			method:  "PUT",
+			url:     "/skip/auth/routes/overlap/post",
+			allowed: true,
+		},
+		{
+			name:    "Overlapping routes allowed with the method of the second route",
+			method:  "POST",
+			url:     "/skip/auth/routes/overlap/post",
+			allowed: true,
+		},
+		{
+			name:    "Overlapping routes denied with a method of neither route",
+			method:  "DELETE",
+			url:     "/skip/auth/routes/overlap/post",
+			allowed: false,
+		},
+		{
+			name:    "Route for all methods allowed alongside a method route",
+			method:  "POST",
+			url:     "/skip/auth/routes/all",
+			allowed: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -2633,6 +4489,136 @@ func TestAllowedRequest(t *testing.T) {
 	}
 }
 
+func TestAllowedRequestWithSkipAuthPreflight(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, err := w.Write([]byte("Allowed Request"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   upstreamServer.URL,
+				Path: "/",
+				URI:  upstreamServer.URL,
+			},
+		},
+	}
+	opts.SkipAuthPreflight = true
+	opts.SkipAuthRegex = []string{
+		"^/skip/auth/regex$",
+	}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+	proxy, err := NewOAuthProxy(opts, func(_ string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		method  string
+		url     string
+		allowed bool
+	}{
+		{
+			name:    "Preflight allowed",
+			method:  "OPTIONS",
+			url:     "/api/resource",
+			allowed: true,
+		},
+		{
+			name:    "Preflight allowed on skipped path",
+			method:  "OPTIONS",
+			url:     "/skip/auth/regex",
+			allowed: true,
+		},
+		{
+			name:    "Regex GET allowed",
+			method:  "GET",
+			url:     "/skip/auth/regex",
+			allowed: true,
+		},
+		{
+			name:    "GET denied",
+			method:  "GET",
+			url:     "/api/resource",
+			allowed: false,
+		},
+		{
+			name:    "POST denied",
+			method:  "POST",
+			url:     "/api/resource",
+			allowed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.allowed, proxy.IsAllowedRequest(req))
+
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			if tc.allowed {
+				assert.Equal(t, 200, rw.Code)
+				assert.Equal(t, "Allowed Request", rw.Body.String())
+			} else {
+				assert.Equal(t, 403, rw.Code)
+			}
+		})
+	}
+}
+
+func TestSpoofedHeadersAreStrippedForUnauthenticatedRequests(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, err := w.Write([]byte(r.Header.Get("X-Forwarded-Email") + r.Header.Get("X_Forwarded_Email") + r.Header.Get("X-Auth-Request-User")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   upstreamServer.URL,
+				Path: "/",
+				URI:  upstreamServer.URL,
+			},
+		},
+	}
+	opts.SkipAuthRoutes = []string{"GET=^/public"}
+	opts.StripRequestHeaderPrefixes = []string{"X-Auth-Request-"}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+	proxy, err := NewOAuthProxy(opts, func(_ string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/public", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Forwarded-Email", "admin@example.com")
+	req.Header["X_Forwarded_Email"] = []string{"admin@example.com"}
+	req.Header.Set("X-Auth-Request-User", "admin")
+
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "", rw.Body.String())
+}
+
 func TestAllowedRequestNegateWithoutMethod(t *testing.T) {
 	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
@@ -3257,3 +5243,177 @@ func TestAuthOnlyAllowedEmails(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenExchanger(t *testing.T) {
+	exchanges := 0
+	providerServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		exchanges++
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token":"exchanged_token","expires_in":300}`))
+	}))
+	defer providerServer.Close()
+
+	providerURL, err := url.Parse(providerServer.URL)
+	require.NoError(t, err)
+	exchangeToken := buildTokenExchanger(NewTestProvider(providerURL, ""), nil)
+
+	session := &sessions.SessionState{AccessToken: "oauth_token"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+
+	downstream := &options.TokenExchange{Audience: "downstream"}
+	token, err := exchangeToken(httptest.NewRecorder(), req, downstream)
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged_token", token)
+
+	// The exchanged token is cached in the session until it expires
+	token, err = exchangeToken(httptest.NewRecorder(), req, downstream)
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged_token", token)
+	assert.Equal(t, 1, exchanges)
+
+	noSessionReq := middlewareapi.AddRequestScope(httptest.NewRequest(http.MethodGet, "/", nil), &middlewareapi.RequestScope{})
+	token, err = exchangeToken(httptest.NewRecorder(), noSessionReq, downstream)
+	assert.NoError(t, err)
+	assert.Equal(t, "", token)
+	assert.Equal(t, 1, exchanges)
+}
+
+func TestTokenExchangerPerUpstream(t *testing.T) {
+	var requested []string
+	providerServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		resource := req.PostForm.Get("resource")
+		requested = append(requested, resource+" "+req.PostForm.Get("scope"))
+		if resource == "https://legacy.example.com" {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"invalid_target"}`))
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"access_token":"token for %s","expires_in":300}`, req.PostForm.Get("scope"))
+	}))
+	defer providerServer.Close()
+
+	providerURL, err := url.Parse(providerServer.URL)
+	require.NoError(t, err)
+	exchangeToken := buildTokenExchanger(NewTestProvider(providerURL, ""), nil)
+
+	session := &sessions.SessionState{AccessToken: "oauth_token"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+
+	read := &options.TokenExchange{Resource: "https://api.example.com", Scopes: []string{"read"}}
+	write := &options.TokenExchange{Resource: "https://api.example.com", Scopes: []string{"write"}}
+	legacy := &options.TokenExchange{Resource: "https://legacy.example.com"}
+	strictLegacy := &options.TokenExchange{Resource: "https://legacy.example.com", Strict: true}
+
+	// Tokens are cached separately for each resource and set of scopes
+	for i := 0; i < 2; i++ {
+		token, err := exchangeToken(httptest.NewRecorder(), req, read)
+		assert.NoError(t, err)
+		assert.Equal(t, "token for read", token)
+
+		token, err = exchangeToken(httptest.NewRecorder(), req, write)
+		assert.NoError(t, err)
+		assert.Equal(t, "token for write", token)
+	}
+	assert.Equal(t, []string{"https://api.example.com read", "https://api.example.com write"}, requested)
+
+	// Strict upstreams do not fall back to the session access token
+	_, err = exchangeToken(httptest.NewRecorder(), req, strictLegacy)
+	assert.ErrorIs(t, err, providers.ErrTokenRequestUnsupported)
+
+	// Providers that do not support the resource fall back to the session
+	// access token, which is remembered for the session
+	requested = nil
+	for i := 0; i < 2; i++ {
+		token, err := exchangeToken(httptest.NewRecorder(), req, legacy)
+		assert.NoError(t, err)
+		assert.Equal(t, "oauth_token", token)
+	}
+	assert.Len(t, requested, 1)
+
+	_, err = exchangeToken(httptest.NewRecorder(), req, strictLegacy)
+	assert.ErrorIs(t, err, providers.ErrTokenRequestUnsupported)
+}
+
+// exchangeSessionStore is a session store holding a single session
+type exchangeSessionStore struct {
+	session *sessions.SessionState
+}
+
+func (s *exchangeSessionStore) Save(_ http.ResponseWriter, _ *http.Request, ss *sessions.SessionState) error {
+	saved := *ss
+	s.session = &saved
+	return nil
+}
+
+func (s *exchangeSessionStore) Load(_ *http.Request) (*sessions.SessionState, error) {
+	loaded := *s.session
+	return &loaded, nil
+}
+
+func (s *exchangeSessionStore) Clear(_ http.ResponseWriter, _ *http.Request) error {
+	s.session = nil
+	return nil
+}
+
+func (s *exchangeSessionStore) VerifyConnection(_ context.Context) error {
+	return nil
+}
+
+// exchangeLock records whether it is held
+type exchangeLock struct {
+	sessions.NoOpLock
+	held bool
+}
+
+func (l *exchangeLock) Obtain(_ context.Context, _ time.Duration) error {
+	if l.held {
+		return sessions.ErrLockNotObtained
+	}
+	l.held = true
+	return nil
+}
+
+func (l *exchangeLock) Release(_ context.Context) error {
+	l.held = false
+	return nil
+}
+
+func TestTokenExchangerRotatesRefreshTokenUnderLock(t *testing.T) {
+	lock := &exchangeLock{}
+	var refreshTokens []string
+	providerServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		assert.True(t, lock.held, "the refresh token must be used under the session lock")
+		refreshTokens = append(refreshTokens, req.PostForm.Get("refresh_token"))
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token":"exchanged_token","refresh_token":"refresh-3","expires_in":300}`))
+	}))
+	defer providerServer.Close()
+
+	providerURL, err := url.Parse(providerServer.URL)
+	require.NoError(t, err)
+
+	// The refresh token was rotated by another request since this request
+	// loaded the session
+	created := time.Now()
+	store := &exchangeSessionStore{session: &sessions.SessionState{AccessToken: "oauth_token", RefreshToken: "refresh-2", CreatedAt: &created}}
+	exchangeToken := buildTokenExchanger(NewTestProvider(providerURL, ""), store)
+
+	session := &sessions.SessionState{AccessToken: "oauth_token", RefreshToken: "refresh-1", CreatedAt: &created, Lock: lock}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+
+	token, err := exchangeToken(httptest.NewRecorder(), req, &options.TokenExchange{Audience: "downstream", Grant: options.TokenExchangeGrantRefreshToken})
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged_token", token)
+
+	assert.Equal(t, []string{"refresh-2"}, refreshTokens)
+	assert.Equal(t, "refresh-3", session.RefreshToken)
+	assert.Equal(t, "refresh-3", store.session.RefreshToken)
+	assert.Same(t, lock, session.Lock)
+	assert.False(t, lock.held)
+}
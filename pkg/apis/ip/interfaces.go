@@ -7,5 +7,5 @@ import (
 
 // RealClientIPParser is an interface for a getting the client's real IP to be used for logging.
 type RealClientIPParser interface {
-	GetRealClientIP(http.Header) (net.IP, error)
+	GetRealClientIP(*http.Request) (net.IP, error)
 }
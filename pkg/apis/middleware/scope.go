@@ -39,6 +39,10 @@ type RequestScope struct {
 	// it was loaded or not.
 	SessionRevalidated bool
 
+	// SessionExpired indicates that the session was removed because it expired
+	// and could not be refreshed as it has no refresh token.
+	SessionExpired bool
+
 	// Upstream tracks which upstream was used for this request
 	Upstream string
 }
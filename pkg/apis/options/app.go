@@ -1,14 +1,18 @@
 package options
 
-import "github.com/spf13/pflag"
+import (
+	"net/http"
+
+	"github.com/spf13/pflag"
+)
 
 // Templates includes options for configuring the sign in and error pages
 // appearance.
 type Templates struct {
-	// Path is the path to a folder containing a sign_in.html and an error.html
-	// template.
+	// Path is the path to a folder containing a sign_in.html, an error.html
+	// and an access_denied.html template.
 	// These files will be used instead of the default templates if present.
-	// If either file is missing, the default will be used instead.
+	// If any file is missing, the default will be used instead.
 	Path string `flag:"custom-templates-dir" cfg:"custom_templates_dir"`
 
 	// CustomLogo is the path or a URL to a logo that should replace the default logo
@@ -35,6 +39,21 @@ type Templates struct {
 	// information.
 	// Use only for diagnosing backend errors.
 	Debug bool `flag:"show-debug-on-error" cfg:"show_debug_on_error"`
+
+	// AccessDeniedContactURL is a link shown on the access denied page, which
+	// is rendered for users that are authenticated but not authorized, so
+	// that they can request access.
+	AccessDeniedContactURL string `flag:"access-denied-contact-url" cfg:"access_denied_contact_url"`
+
+	// AccessDeniedStatusCode is the HTTP status code of the access denied page.
+	// It must be a 4xx status other than 401, which is used for users that
+	// are not authenticated.
+	AccessDeniedStatusCode int `flag:"access-denied-status-code" cfg:"access_denied_status_code"`
+
+	// ResponseHeaders are extra headers, in the form "Name: value", set on the
+	// pages rendered by OAuth2 Proxy (sign-in, error and sign-out).
+	// They are not set on responses from the upstreams.
+	ResponseHeaders []string `flag:"page-response-header" cfg:"page_response_headers"`
 }
 
 func templatesFlagSet() *pflag.FlagSet {
@@ -46,6 +65,9 @@ func templatesFlagSet() *pflag.FlagSet {
 	flagSet.String("footer", "", "custom footer string. Use \"-\" to disable default footer.")
 	flagSet.Bool("display-htpasswd-form", true, "display username / password login form if an htpasswd file is provided")
 	flagSet.Bool("show-debug-on-error", false, "show detailed error information on error pages (WARNING: this may contain sensitive information - do not use in production)")
+	flagSet.String("access-denied-contact-url", "", "link shown on the access denied page for authenticated users that are not authorized, so that they can request access")
+	flagSet.Int("access-denied-status-code", http.StatusForbidden, "HTTP status code of the access denied page, a 4xx status other than 401")
+	flagSet.StringSlice("page-response-header", []string{}, "extra response header, in the form \"Name: value\", set on the sign-in, error and sign-out pages but not on upstream responses (may be given multiple times)")
 
 	return flagSet
 }
@@ -53,6 +75,7 @@ func templatesFlagSet() *pflag.FlagSet {
 // templatesDefaults creates a Templates and populates it with any default values
 func templatesDefaults() Templates {
 	return Templates{
-		DisplayLoginForm: true,
+		DisplayLoginForm:       true,
+		AccessDeniedStatusCode: http.StatusForbidden,
 	}
 }
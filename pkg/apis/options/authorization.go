@@ -0,0 +1,31 @@
+package options
+
+// AuthorizationRuleAllow is used to indicate an AuthorizationRule allows
+// access when its expression matches.
+var AuthorizationRuleAllow = "allow"
+
+// AuthorizationRuleDeny is used to indicate an AuthorizationRule denies
+// access when its expression matches.
+var AuthorizationRuleDeny = "deny"
+
+// AuthorizationRule allows or denies access to sessions whose claims match
+// the expression.
+// Rules are evaluated in order after the session has been validated, the
+// first rule that matches decides whether the session is authorized.
+// When rules are configured, sessions that do not match any rule are denied.
+//
+// Expressions compare claims to values and may be combined with `&&`, `||`,
+// `!` and parentheses:
+// - `department == engineering` matches a claim with exactly this value
+// - `department != engineering` matches when the claim does not equal the value
+// - `groups contains admins` matches when any value of the claim equals the value
+// Values containing spaces or operators must be double quoted.
+// Eg: `department == engineering || groups contains admins`
+type AuthorizationRule struct {
+	// Action is either `allow` or `deny`.
+	Action string `json:"action,omitempty"`
+
+	// Expression is the boolean expression over the session claims that
+	// must match for the rule to apply.
+	Expression string `json:"expression,omitempty"`
+}
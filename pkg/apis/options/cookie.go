@@ -3,54 +3,114 @@ package options
 import (
 	"time"
 
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	"github.com/spf13/pflag"
 )
 
 // Cookie contains configuration options relating to Cookie configuration
 type Cookie struct {
-	Name           string        `flag:"cookie-name" cfg:"cookie_name"`
-	Secret         string        `flag:"cookie-secret" cfg:"cookie_secret"`
-	Domains        []string      `flag:"cookie-domain" cfg:"cookie_domains"`
-	Path           string        `flag:"cookie-path" cfg:"cookie_path"`
-	Expire         time.Duration `flag:"cookie-expire" cfg:"cookie_expire"`
-	Refresh        time.Duration `flag:"cookie-refresh" cfg:"cookie_refresh"`
-	Secure         bool          `flag:"cookie-secure" cfg:"cookie_secure"`
-	HTTPOnly       bool          `flag:"cookie-httponly" cfg:"cookie_httponly"`
-	SameSite       string        `flag:"cookie-samesite" cfg:"cookie_samesite"`
-	CSRFPerRequest bool          `flag:"cookie-csrf-per-request" cfg:"cookie_csrf_per_request"`
-	CSRFExpire     time.Duration `flag:"cookie-csrf-expire" cfg:"cookie_csrf_expire"`
+	Name                  string        `flag:"cookie-name" cfg:"cookie_name"`
+	NamePrefix            string        `flag:"cookie-name-prefix" cfg:"cookie_name_prefix"`
+	Secret                string        `flag:"cookie-secret" cfg:"cookie_secret"`
+	SecondarySecrets      []string      `flag:"cookie-secondary-secret" cfg:"cookie_secondary_secrets"`
+	EncryptionAlgorithm   string        `flag:"cookie-encryption-algorithm" cfg:"cookie_encryption_algorithm"`
+	Domains               []string      `flag:"cookie-domain" cfg:"cookie_domains"`
+	Path                  string        `flag:"cookie-path" cfg:"cookie_path"`
+	Expire                time.Duration `flag:"cookie-expire" cfg:"cookie_expire"`
+	Refresh               time.Duration `flag:"cookie-refresh" cfg:"cookie_refresh"`
+	RefreshCoalesceWindow time.Duration `flag:"cookie-refresh-coalesce-window" cfg:"cookie_refresh_coalesce_window"`
+	RefreshGracePeriod    time.Duration `flag:"cookie-refresh-grace-period" cfg:"cookie_refresh_grace_period"`
+	Secure                bool          `flag:"cookie-secure" cfg:"cookie_secure"`
+	HTTPOnly              bool          `flag:"cookie-httponly" cfg:"cookie_httponly"`
+	SameSite              string        `flag:"cookie-samesite" cfg:"cookie_samesite"`
+	SessionSameSite       string        `flag:"cookie-session-samesite" cfg:"cookie_session_samesite"`
+	CSRFSameSite          string        `flag:"cookie-csrf-samesite" cfg:"cookie_csrf_samesite"`
+	Partitioned           bool          `flag:"cookie-partitioned" cfg:"cookie_partitioned"`
+	CSRFPerRequest        bool          `flag:"cookie-csrf-per-request" cfg:"cookie_csrf_per_request"`
+	CSRFExpire            time.Duration `flag:"cookie-csrf-expire" cfg:"cookie_csrf_expire"`
+	CSRFServerSide        bool          `flag:"cookie-csrf-server-side" cfg:"cookie_csrf_server_side"`
 }
 
 func cookieFlagSet() *pflag.FlagSet {
 	flagSet := pflag.NewFlagSet("cookie", pflag.ExitOnError)
 
 	flagSet.String("cookie-name", "_oauth2_proxy", "the name of the cookie that the oauth_proxy creates")
+	flagSet.String("cookie-name-prefix", "", "a prefix added to the names of all cookies that the oauth_proxy creates, including the split session, CSRF cookies (eg: `tenant_a`)")
 	flagSet.String("cookie-secret", "", "the seed string for secure cookies (optionally base64 encoded)")
-	flagSet.StringSlice("cookie-domain", []string{}, "Optional cookie domains to force cookies to (ie: `.yourcompany.com`). The longest domain matching the request's host will be used (or the shortest cookie domain if there is no match).")
+	flagSet.StringSlice("cookie-secondary-secret", []string{}, "a previous cookie secret that cookies are still accepted with while rotating the cookie secret, sessions are saved again with the cookie-secret when they are used (may be given multiple times)")
+	flagSet.String("cookie-encryption-algorithm", encryption.CipherAESCFB, "the cipher cookie values are encrypted with (ie: \"aes-cfb\", \"aes-256-gcm\" or \"chacha20-poly1305\"), cookies encrypted with any of them are accepted")
+	flagSet.StringSlice("cookie-domain", []string{}, "Optional cookie domains to force cookies to (ie: `.yourcompany.com`). The most specific domain matching the request's host will be used (or a host-only cookie if there is no match).")
 	flagSet.String("cookie-path", "/", "an optional cookie path to force cookies to (ie: /poc/)*")
 	flagSet.Duration("cookie-expire", time.Duration(168)*time.Hour, "expire timeframe for cookie")
 	flagSet.Duration("cookie-refresh", time.Duration(0), "refresh the cookie after this duration; 0 to disable")
+	flagSet.Duration("cookie-refresh-coalesce-window", time.Duration(0), "reuse the result of a cookie refresh for other requests with the same cookie for this duration; 0 to only share refreshes that are in progress")
+	flagSet.Duration("cookie-refresh-grace-period", time.Duration(0), "keep using a session for this duration after it was due to be refreshed when the refresh fails because the provider is unavailable; 0 to disable")
 	flagSet.Bool("cookie-secure", true, "set secure (HTTPS) cookie flag")
 	flagSet.Bool("cookie-httponly", true, "set HttpOnly cookie flag")
 	flagSet.String("cookie-samesite", "", "set SameSite cookie attribute (ie: \"lax\", \"strict\", \"none\", or \"\"). ")
+	flagSet.String("cookie-session-samesite", "", "set SameSite cookie attribute of the session cookies, overriding cookie-samesite (ie: \"lax\", \"strict\", \"none\")")
+	flagSet.String("cookie-csrf-samesite", "", "set SameSite cookie attribute of the CSRF cookies, overriding cookie-samesite (ie: \"lax\", \"strict\", \"none\"), eg \"none\" for cross-site form_post callbacks")
+	flagSet.Bool("cookie-partitioned", false, "set Partitioned cookie attribute (CHIPS) so that cookies can be used in third party contexts; requires cookie-secure")
 	flagSet.Bool("cookie-csrf-per-request", false, "When this property is set to true, then the CSRF cookie name is built based on the state and varies per request. If property is set to false, then CSRF cookie has the same name for all requests.")
 	flagSet.Duration("cookie-csrf-expire", time.Duration(15)*time.Minute, "expire timeframe for CSRF cookie")
+	flagSet.Bool("cookie-csrf-server-side", false, "store the OAuth state, OIDC nonce and PKCE code verifier in the session store instead of the CSRF cookie, only a small cookie binding the state to the browser is set; requires a redis or memcached session store")
 	return flagSet
 }
 
+// PrefixedName returns the name of the session cookie with the NamePrefix.
+// The names of all other cookies, such as the CSRF cookies and the parts of
+// a split session cookie, are derived from this name.
+func (c Cookie) PrefixedName() string {
+	return c.NamePrefix + c.Name
+}
+
+// Secrets returns the cookie secret followed by the secondary secrets.
+// Cookies are always signed and encrypted with the first secret, cookies
+// signed with any of them are accepted.
+func (c Cookie) Secrets() []string {
+	return append([]string{c.Secret}, c.SecondarySecrets...)
+}
+
+// SessionCookieSameSite returns the SameSite attribute of the session
+// cookies, the SessionSameSite when it is set and the SameSite otherwise.
+func (c Cookie) SessionCookieSameSite() string {
+	if c.SessionSameSite != "" {
+		return c.SessionSameSite
+	}
+	return c.SameSite
+}
+
+// CSRFCookieSameSite returns the SameSite attribute of the CSRF cookies,
+// the CSRFSameSite when it is set and the SameSite otherwise.
+func (c Cookie) CSRFCookieSameSite() string {
+	if c.CSRFSameSite != "" {
+		return c.CSRFSameSite
+	}
+	return c.SameSite
+}
+
 // cookieDefaults creates a Cookie populating each field with its default value
 func cookieDefaults() Cookie {
 	return Cookie{
-		Name:           "_oauth2_proxy",
-		Secret:         "",
-		Domains:        nil,
-		Path:           "/",
-		Expire:         time.Duration(168) * time.Hour,
-		Refresh:        time.Duration(0),
-		Secure:         true,
-		HTTPOnly:       true,
-		SameSite:       "",
-		CSRFPerRequest: false,
-		CSRFExpire:     time.Duration(15) * time.Minute,
+		Name:                  "_oauth2_proxy",
+		NamePrefix:            "",
+		Secret:                "",
+		SecondarySecrets:      nil,
+		EncryptionAlgorithm:   encryption.CipherAESCFB,
+		Domains:               nil,
+		Path:                  "/",
+		Expire:                time.Duration(168) * time.Hour,
+		Refresh:               time.Duration(0),
+		RefreshCoalesceWindow: time.Duration(0),
+		RefreshGracePeriod:    time.Duration(0),
+		Secure:                true,
+		HTTPOnly:              true,
+		SameSite:              "",
+		SessionSameSite:       "",
+		CSRFSameSite:          "",
+		Partitioned:           false,
+		CSRFPerRequest:        false,
+		CSRFExpire:            time.Duration(15) * time.Minute,
+		CSRFServerSide:        false,
 	}
 }
@@ -0,0 +1,56 @@
+package options
+
+// GroupsTransform changes the groups of a session before they are used for
+// authorization and passed to the upstreams, eg in the X-Forwarded-Groups
+// header.
+// Transforms are applied in order, each to the result of the previous one,
+// and exactly one of the fields must be set on each transform.
+// Groups that are empty after a transform are removed.
+type GroupsTransform struct {
+	// StripPrefix removes the prefix from groups that start with it,
+	// eg `/` for Keycloak group paths.
+	StripPrefix string `json:"stripPrefix,omitempty"`
+
+	// Lowercase converts the groups to lower case.
+	Lowercase bool `json:"lowercase,omitempty"`
+
+	// Mapping replaces the groups that are keys of the lookup table with their
+	// value, eg to replace Azure group object IDs with names.
+	// Groups that are not in the table are kept unchanged.
+	Mapping map[string]string `json:"mapping,omitempty"`
+}
+
+const (
+	// GroupsAPIAuthBearer sends the access token of the session as a bearer token.
+	GroupsAPIAuthBearer = "bearer"
+
+	// GroupsAPIAuthBasic sends the client ID and secret with basic authentication.
+	GroupsAPIAuthBasic = "basic"
+
+	// GroupsAPIAuthNone sends no credentials.
+	GroupsAPIAuthNone = "none"
+)
+
+// GroupsAPI fetches the groups of users from an endpoint of the provider
+// or another service, eg the OCS API of a self-hosted Nextcloud, when the
+// groups are not part of the tokens or profile.
+// The groups are fetched once when the session is created, and again when a
+// refresh of the session changes its groups, and are added to the groups of
+// the session before the groups transforms.
+type GroupsAPI struct {
+	// URL is the endpoint that returns the groups of the user.
+	// It is a Go template with the `.User`, `.Email` and `.PreferredUsername`
+	// of the session, eg
+	// `https://cloud.example.com/ocs/v2.php/cloud/users/{{ urlquery .User }}/groups?format=json`.
+	URL string `json:"url,omitempty"`
+
+	// AuthMethod is how the request to the endpoint is authenticated, one of
+	// `bearer`, `basic` or `none`.
+	// Defaults to `bearer`, the access token of the session.
+	AuthMethod string `json:"authMethod,omitempty"`
+
+	// GroupsPath is the dot separated path of the list of groups in the JSON
+	// response, eg `ocs.data.groups`.
+	// Defaults to `groups`.
+	GroupsPath string `json:"groupsPath,omitempty"`
+}
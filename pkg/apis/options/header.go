@@ -1,5 +1,19 @@
 package options
 
+const (
+	// ClaimTimeFormatRFC3339 formats time claims as RFC 3339 timestamps,
+	// eg 2006-01-02T15:04:05Z
+	ClaimTimeFormatRFC3339 = "rfc3339"
+
+	// ClaimTimeFormatUnix formats time claims as the number of seconds since
+	// the Unix epoch
+	ClaimTimeFormatUnix = "unix"
+
+	// ClaimIDTokenClaims loads all the claims of the ID token as a single
+	// base64 encoded JSON object
+	ClaimIDTokenClaims = "id_token_claims"
+)
+
 // Header represents an individual header that will be added to a request or
 // response header.
 type Header struct {
@@ -31,8 +45,35 @@ type HeaderValue struct {
 type ClaimSource struct {
 	// Claim is the name of the claim in the session that the value should be
 	// loaded from.
+	// Claims that are not part of the session (such as user, email or groups)
+	// are loaded from the ID token.
+	// The `id_token_claims` claim loads all the claims of the ID token, as
+	// base64 encoded JSON.
 	Claim string `json:"claim,omitempty"`
 
+	// Template is an optional Go template used to build the value from the
+	// claims in the session, eg `{{.given_name}} {{.family_name}}`.
+	// Claim is ignored when a template is set.
+	// The value is treated as missing if the template refers to a claim that
+	// does not exist.
+	Template string `json:"template,omitempty"`
+
+	// Separator joins multi-valued claims into a single header value.
+	// When empty, a separate header value is added for each value of the claim.
+	Separator string `json:"separator,omitempty"`
+
+	// EmitEmpty adds the header with an empty value when the claim is missing
+	// or empty.
+	// Defaults to false (the header is skipped).
+	EmitEmpty bool `json:"emitEmpty,omitempty"`
+
+	// TimeFormat formats the time claims held in the session, `created_at`
+	// and `expires_on` (the expiry of the access token), either as `rfc3339`
+	// or `unix` seconds.
+	// It cannot be used with other claims or with a template.
+	// When empty, the times are formatted as by Go's time.Time String.
+	TimeFormat string `json:"timeFormat,omitempty"`
+
 	// Prefix is an optional prefix that will be prepended to the value of the
 	// claim if it is non-empty.
 	Prefix string `json:"prefix,omitempty"`
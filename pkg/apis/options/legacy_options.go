@@ -34,12 +34,19 @@ func NewLegacyOptions() *LegacyOptions {
 			ProxyWebSockets: true,
 			FlushInterval:   DefaultUpstreamFlushInterval,
 			Timeout:         DefaultUpstreamTimeout,
+
+			MaxIdleConns:        DefaultUpstreamMaxIdleConns,
+			MaxIdleConnsPerHost: DefaultUpstreamMaxIdleConnsPerHost,
+			IdleConnTimeout:     DefaultUpstreamIdleConnTimeout,
+
+			MaxResponseHeaderBytes: DefaultUpstreamMaxResponseHeaderBytes,
 		},
 
 		LegacyHeaders: LegacyHeaders{
-			PassBasicAuth:        true,
-			PassUserHeaders:      true,
-			SkipAuthStripHeaders: true,
+			PassBasicAuth:           true,
+			PassUserHeaders:         true,
+			SkipAuthStripHeaders:    true,
+			AccessTokenExpiryFormat: ClaimTimeFormatRFC3339,
 		},
 
 		LegacyServer: LegacyServer{
@@ -48,15 +55,18 @@ func NewLegacyOptions() *LegacyOptions {
 		},
 
 		LegacyProvider: LegacyProvider{
-			ProviderType:          "google",
-			AzureTenant:           "common",
-			ApprovalPrompt:        "force",
-			UserIDClaim:           "email",
-			OIDCEmailClaim:        "email",
-			OIDCGroupsClaim:       "groups",
-			OIDCAudienceClaims:    []string{"aud"},
-			OIDCExtraAudiences:    []string{},
-			InsecureOIDCSkipNonce: true,
+			ProviderType:              "google",
+			AzureTenant:               "common",
+			ApprovalPrompt:            "force",
+			UserIDClaim:               "email",
+			OIDCEmailClaim:            "email",
+			OIDCGroupsClaim:           "groups",
+			OIDCAudienceClaims:        []string{"aud"},
+			OIDCExtraAudiences:        []string{},
+			RequestObjectSigningAlg:   RequestObjectSigningRS256,
+			ClientAssertionSigningAlg: RequestObjectSigningRS256,
+			InsecureOIDCSkipNonce:     true,
+			OIDCJWTLeeway:             DefaultOIDCJWTLeeway,
 		},
 
 		Options: *NewOptions(),
@@ -103,6 +113,13 @@ type LegacyUpstreams struct {
 	SSLUpstreamInsecureSkipVerify bool          `flag:"ssl-upstream-insecure-skip-verify" cfg:"ssl_upstream_insecure_skip_verify"`
 	Upstreams                     []string      `flag:"upstream" cfg:"upstreams"`
 	Timeout                       time.Duration `flag:"upstream-timeout" cfg:"upstream_timeout"`
+
+	MaxIdleConns        int           `flag:"upstream-max-idle-conns" cfg:"upstream_max_idle_conns"`
+	MaxIdleConnsPerHost int           `flag:"upstream-max-idle-conns-per-host" cfg:"upstream_max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `flag:"upstream-max-conns-per-host" cfg:"upstream_max_conns_per_host"`
+	IdleConnTimeout     time.Duration `flag:"upstream-idle-conn-timeout" cfg:"upstream_idle_conn_timeout"`
+
+	MaxResponseHeaderBytes int64 `flag:"upstream-max-response-header-bytes" cfg:"upstream_max_response_header_bytes"`
 }
 
 func legacyUpstreamsFlagSet() *pflag.FlagSet {
@@ -114,12 +131,30 @@ func legacyUpstreamsFlagSet() *pflag.FlagSet {
 	flagSet.Bool("ssl-upstream-insecure-skip-verify", false, "skip validation of certificates presented when using HTTPS upstreams")
 	flagSet.StringSlice("upstream", []string{}, "the http url(s) of the upstream endpoint, file:// paths for static files or static://<status_code> for static response. Routing is based on the path")
 	flagSet.Duration("upstream-timeout", DefaultUpstreamTimeout, "maximum amount of time the server will wait for a response from the upstream")
+	flagSet.Int("upstream-max-idle-conns", DefaultUpstreamMaxIdleConns, "maximum number of idle connections kept open by each upstream (0 for no limit)")
+	flagSet.Int("upstream-max-idle-conns-per-host", DefaultUpstreamMaxIdleConnsPerHost, "maximum number of idle connections kept open to each upstream host")
+	flagSet.Int("upstream-max-conns-per-host", 0, "maximum number of connections, including those in use, to each upstream host (0 for no limit)")
+	flagSet.Duration("upstream-idle-conn-timeout", DefaultUpstreamIdleConnTimeout, "how long idle connections to upstreams are kept open before they are closed")
+	flagSet.Int64("upstream-max-response-header-bytes", DefaultUpstreamMaxResponseHeaderBytes, "maximum total size of the response headers of the upstreams, responses with larger headers are rejected with a 502")
 
 	return flagSet
 }
 
 func (l *LegacyUpstreams) convert() (UpstreamConfig, error) {
-	upstreams := UpstreamConfig{}
+	maxIdleConns := l.MaxIdleConns
+	maxIdleConnsPerHost := l.MaxIdleConnsPerHost
+	maxConnsPerHost := l.MaxConnsPerHost
+	idleConnTimeout := Duration(l.IdleConnTimeout)
+	maxResponseHeaderBytes := l.MaxResponseHeaderBytes
+	upstreams := UpstreamConfig{
+		Transport: UpstreamTransport{
+			MaxIdleConns:           &maxIdleConns,
+			MaxIdleConnsPerHost:    &maxIdleConnsPerHost,
+			MaxConnsPerHost:        &maxConnsPerHost,
+			IdleConnTimeout:        &idleConnTimeout,
+			MaxResponseHeaderBytes: &maxResponseHeaderBytes,
+		},
+	}
 
 	for _, upstreamString := range l.Upstreams {
 		u, err := url.Parse(upstreamString)
@@ -195,6 +230,11 @@ type LegacyHeaders struct {
 	PreferEmailToUser    bool   `flag:"prefer-email-to-user" cfg:"prefer_email_to_user"`
 	BasicAuthPassword    string `flag:"basic-auth-password" cfg:"basic_auth_password"`
 	SkipAuthStripHeaders bool   `flag:"skip-auth-strip-headers" cfg:"skip_auth_strip_headers"`
+
+	AccessTokenExpiryHeader string `flag:"access-token-expiry-header" cfg:"access_token_expiry_header"`
+	AccessTokenExpiryFormat string `flag:"access-token-expiry-format" cfg:"access_token_expiry_format"`
+
+	PassClaimsHeader string `flag:"pass-claims-header" cfg:"pass_claims_header"`
 }
 
 func legacyHeadersFlagSet() *pflag.FlagSet {
@@ -203,7 +243,7 @@ func legacyHeadersFlagSet() *pflag.FlagSet {
 	flagSet.Bool("pass-basic-auth", true, "pass HTTP Basic Auth, X-Forwarded-User and X-Forwarded-Email information to upstream")
 	flagSet.Bool("pass-access-token", false, "pass OAuth access_token to upstream via X-Forwarded-Access-Token header")
 	flagSet.Bool("pass-user-headers", true, "pass X-Forwarded-User and X-Forwarded-Email information to upstream")
-	flagSet.Bool("pass-authorization-header", false, "pass the Authorization Header to upstream")
+	flagSet.Bool("pass-authorization-header", false, "pass the OIDC ID token to upstream as a Bearer token in the Authorization header")
 
 	flagSet.Bool("set-basic-auth", false, "set HTTP Basic Auth information in response (useful in Nginx auth_request mode)")
 	flagSet.Bool("set-xauthrequest", false, "set X-Auth-Request-User and X-Auth-Request-Email response headers (useful in Nginx auth_request mode)")
@@ -212,6 +252,9 @@ func legacyHeadersFlagSet() *pflag.FlagSet {
 	flagSet.Bool("prefer-email-to-user", false, "Prefer to use the Email address as the Username when passing information to upstream. Will only use Username if Email is unavailable, eg. htaccess authentication. Used in conjunction with -pass-basic-auth and -pass-user-headers")
 	flagSet.String("basic-auth-password", "", "the password to set when passing the HTTP Basic Auth header")
 	flagSet.Bool("skip-auth-strip-headers", true, "strips X-Forwarded-* style authentication headers & Authorization header if they would be set by oauth2-proxy")
+	flagSet.String("access-token-expiry-header", "", "header carrying the expiry of the access token, set alongside the access token by -pass-access-token (e.g. X-Auth-Request-Access-Token-Expiry)")
+	flagSet.String("access-token-expiry-format", ClaimTimeFormatRFC3339, "format of the access token expiry header (either \"rfc3339\" or \"unix\")")
+	flagSet.String("pass-claims-header", "", "header to pass all the ID token claims to upstream in, as base64 encoded JSON (e.g. X-Forwarded-Claims)")
 
 	return flagSet
 }
@@ -237,6 +280,9 @@ func (l *LegacyHeaders) getRequestHeaders() []Header {
 
 	if l.PassAccessToken {
 		requestHeaders = append(requestHeaders, getPassAccessTokenHeader())
+		if l.AccessTokenExpiryHeader != "" {
+			requestHeaders = append(requestHeaders, getAccessTokenExpiryHeader(l.AccessTokenExpiryHeader, l.AccessTokenExpiryFormat))
+		}
 	}
 
 	if l.PassAuthorization {
@@ -247,6 +293,12 @@ func (l *LegacyHeaders) getRequestHeaders() []Header {
 		requestHeaders[i].PreserveRequestValue = !l.SkipAuthStripHeaders
 	}
 
+	// The claims header is always stripped from requests, as upstreams use
+	// it to make authorization decisions
+	if l.PassClaimsHeader != "" {
+		requestHeaders = append(requestHeaders, getPassClaimsHeader(l.PassClaimsHeader))
+	}
+
 	return requestHeaders
 }
 
@@ -257,6 +309,9 @@ func (l *LegacyHeaders) getResponseHeaders() []Header {
 		responseHeaders = append(responseHeaders, getXAuthRequestHeaders()...)
 		if l.PassAccessToken {
 			responseHeaders = append(responseHeaders, getXAuthRequestAccessTokenHeader())
+			if l.AccessTokenExpiryHeader != "" {
+				responseHeaders = append(responseHeaders, getAccessTokenExpiryHeader(l.AccessTokenExpiryHeader, l.AccessTokenExpiryFormat))
+			}
 		}
 	}
 
@@ -359,6 +414,33 @@ func getPassAccessTokenHeader() Header {
 	}
 }
 
+func getAccessTokenExpiryHeader(name, format string) Header {
+	return Header{
+		Name: name,
+		Values: []HeaderValue{
+			{
+				ClaimSource: &ClaimSource{
+					Claim:      "expires_on",
+					TimeFormat: format,
+				},
+			},
+		},
+	}
+}
+
+func getPassClaimsHeader(name string) Header {
+	return Header{
+		Name: name,
+		Values: []HeaderValue{
+			{
+				ClaimSource: &ClaimSource{
+					Claim: ClaimIDTokenClaims,
+				},
+			},
+		},
+	}
+}
+
 func getAuthorizationHeader() Header {
 	return Header{
 		Name: "Authorization",
@@ -457,6 +539,12 @@ type LegacyServer struct {
 	TLSKeyFile           string   `flag:"tls-key-file" cfg:"tls_key_file"`
 	TLSMinVersion        string   `flag:"tls-min-version" cfg:"tls_min_version"`
 	TLSCipherSuites      []string `flag:"tls-cipher-suite" cfg:"tls_cipher_suites"`
+
+	SocketFileMode        string `flag:"socket-file-mode" cfg:"socket_file_mode"`
+	MetricsSocketFileMode string `flag:"metrics-socket-file-mode" cfg:"metrics_socket_file_mode"`
+
+	ShutdownDrainTimeout  time.Duration `flag:"shutdown-drain-timeout" cfg:"shutdown_drain_timeout"`
+	ShutdownStreamTimeout time.Duration `flag:"shutdown-stream-timeout" cfg:"shutdown_stream_timeout"`
 }
 
 func legacyServerFlagset() *pflag.FlagSet {
@@ -472,22 +560,30 @@ func legacyServerFlagset() *pflag.FlagSet {
 	flagSet.String("tls-key-file", "", "path to private key file")
 	flagSet.String("tls-min-version", "", "minimal TLS version for HTTPS clients (either \"TLS1.2\" or \"TLS1.3\")")
 	flagSet.StringSlice("tls-cipher-suite", []string{}, "restricts TLS cipher suites to those listed (e.g. TLS_RSA_WITH_RC4_128_SHA) (may be given multiple times)")
+	flagSet.String("socket-file-mode", "", "octal file mode of the unix socket the http-address listens on (e.g. \"0660\")")
+	flagSet.String("metrics-socket-file-mode", "", "octal file mode of the unix socket the metrics-address listens on (e.g. \"0660\")")
+	flagSet.Duration("shutdown-drain-timeout", time.Duration(0), "how long in-flight requests may take to finish on shutdown before their connections are closed (0 to wait for all requests)")
+	flagSet.Duration("shutdown-stream-timeout", time.Duration(0), "how long WebSocket connections are kept open on shutdown before they are closed (0 to close them once the in-flight requests have finished)")
 
 	return flagSet
 }
 
 type LegacyProvider struct {
-	ClientID         string `flag:"client-id" cfg:"client_id"`
-	ClientSecret     string `flag:"client-secret" cfg:"client_secret"`
-	ClientSecretFile string `flag:"client-secret-file" cfg:"client_secret_file"`
+	ClientID                     string        `flag:"client-id" cfg:"client_id"`
+	ClientSecret                 string        `flag:"client-secret" cfg:"client_secret"`
+	ClientSecretFile             string        `flag:"client-secret-file" cfg:"client_secret_file"`
+	ClientSecretFilePollInterval time.Duration `flag:"client-secret-file-poll-interval" cfg:"client_secret_file_poll_interval"`
 
 	KeycloakGroups           []string `flag:"keycloak-group" cfg:"keycloak_groups"`
 	AzureTenant              string   `flag:"azure-tenant" cfg:"azure_tenant"`
 	AzureGraphGroupField     string   `flag:"azure-graph-group-field" cfg:"azure_graph_group_field"`
+	AzureGraphURL            string   `flag:"azure-graph-url" cfg:"azure_graph_url"`
 	BitbucketTeam            string   `flag:"bitbucket-team" cfg:"bitbucket_team"`
 	BitbucketRepository      string   `flag:"bitbucket-repository" cfg:"bitbucket_repository"`
+	BitbucketPermissions     []string `flag:"bitbucket-repository-permission" cfg:"bitbucket_repository_permissions"`
 	GitHubOrg                string   `flag:"github-org" cfg:"github_org"`
 	GitHubTeam               string   `flag:"github-team" cfg:"github_team"`
+	GitHubRequireAll         bool     `flag:"github-require-all-orgs-and-teams" cfg:"github_require_all_orgs_and_teams"`
 	GitHubRepo               string   `flag:"github-repo" cfg:"github_repo"`
 	GitHubToken              string   `flag:"github-token" cfg:"github_token"`
 	GitHubUsers              []string `flag:"github-user" cfg:"github_users"`
@@ -499,30 +595,44 @@ type LegacyProvider struct {
 
 	// These options allow for other providers besides Google, with
 	// potential overrides.
-	ProviderType                       string   `flag:"provider" cfg:"provider"`
-	ProviderName                       string   `flag:"provider-display-name" cfg:"provider_display_name"`
-	ProviderCAFiles                    []string `flag:"provider-ca-file" cfg:"provider_ca_files"`
-	OIDCIssuerURL                      string   `flag:"oidc-issuer-url" cfg:"oidc_issuer_url"`
-	InsecureOIDCAllowUnverifiedEmail   bool     `flag:"insecure-oidc-allow-unverified-email" cfg:"insecure_oidc_allow_unverified_email"`
-	InsecureOIDCSkipIssuerVerification bool     `flag:"insecure-oidc-skip-issuer-verification" cfg:"insecure_oidc_skip_issuer_verification"`
-	InsecureOIDCSkipNonce              bool     `flag:"insecure-oidc-skip-nonce" cfg:"insecure_oidc_skip_nonce"`
-	SkipOIDCDiscovery                  bool     `flag:"skip-oidc-discovery" cfg:"skip_oidc_discovery"`
-	OIDCJwksURL                        string   `flag:"oidc-jwks-url" cfg:"oidc_jwks_url"`
-	OIDCEmailClaim                     string   `flag:"oidc-email-claim" cfg:"oidc_email_claim"`
-	OIDCGroupsClaim                    string   `flag:"oidc-groups-claim" cfg:"oidc_groups_claim"`
-	OIDCAudienceClaims                 []string `flag:"oidc-audience-claim" cfg:"oidc_audience_claims"`
-	OIDCExtraAudiences                 []string `flag:"oidc-extra-audience" cfg:"oidc_extra_audiences"`
-	LoginURL                           string   `flag:"login-url" cfg:"login_url"`
-	RedeemURL                          string   `flag:"redeem-url" cfg:"redeem_url"`
-	ProfileURL                         string   `flag:"profile-url" cfg:"profile_url"`
-	ProtectedResource                  string   `flag:"resource" cfg:"resource"`
-	ValidateURL                        string   `flag:"validate-url" cfg:"validate_url"`
-	Scope                              string   `flag:"scope" cfg:"scope"`
-	Prompt                             string   `flag:"prompt" cfg:"prompt"`
-	ApprovalPrompt                     string   `flag:"approval-prompt" cfg:"approval_prompt"` // Deprecated by OIDC 1.0
-	UserIDClaim                        string   `flag:"user-id-claim" cfg:"user_id_claim"`
-	AllowedGroups                      []string `flag:"allowed-group" cfg:"allowed_groups"`
-	AllowedRoles                       []string `flag:"allowed-role" cfg:"allowed_roles"`
+	ProviderType                       string        `flag:"provider" cfg:"provider"`
+	ProviderName                       string        `flag:"provider-display-name" cfg:"provider_display_name"`
+	ProviderCAFiles                    []string      `flag:"provider-ca-file" cfg:"provider_ca_files"`
+	ProviderCAOnly                     bool          `flag:"provider-ca-only" cfg:"provider_ca_only"`
+	OIDCIssuerURL                      string        `flag:"oidc-issuer-url" cfg:"oidc_issuer_url"`
+	InsecureOIDCAllowUnverifiedEmail   bool          `flag:"insecure-oidc-allow-unverified-email" cfg:"insecure_oidc_allow_unverified_email"`
+	InsecureOIDCSkipIssuerVerification bool          `flag:"insecure-oidc-skip-issuer-verification" cfg:"insecure_oidc_skip_issuer_verification"`
+	InsecureOIDCSkipNonce              bool          `flag:"insecure-oidc-skip-nonce" cfg:"insecure_oidc_skip_nonce"`
+	SkipOIDCDiscovery                  bool          `flag:"skip-oidc-discovery" cfg:"skip_oidc_discovery"`
+	SkipOIDCEndSessionOnLogout         bool          `flag:"skip-oidc-end-session-on-logout" cfg:"skip_oidc_end_session_on_logout"`
+	OIDCJwksURL                        string        `flag:"oidc-jwks-url" cfg:"oidc_jwks_url"`
+	OIDCEmailClaim                     string        `flag:"oidc-email-claim" cfg:"oidc_email_claim"`
+	OIDCGroupsClaim                    string        `flag:"oidc-groups-claim" cfg:"oidc_groups_claim"`
+	OIDCUsernameClaims                 []string      `flag:"oidc-username-claim" cfg:"oidc_username_claims"`
+	OIDCAudienceClaims                 []string      `flag:"oidc-audience-claim" cfg:"oidc_audience_claims"`
+	OIDCExtraAudiences                 []string      `flag:"oidc-extra-audience" cfg:"oidc_extra_audiences"`
+	OIDCExtraIssuerURLs                []string      `flag:"oidc-extra-issuer-url" cfg:"oidc_extra_issuer_urls"`
+	OIDCDiscoveryTimeout               time.Duration `flag:"oidc-discovery-timeout" cfg:"oidc_discovery_timeout"`
+	OIDCDiscoveryRetryBackoff          time.Duration `flag:"oidc-discovery-retry-backoff" cfg:"oidc_discovery_retry_backoff"`
+	OIDCJWTLeeway                      time.Duration `flag:"oidc-jwt-leeway" cfg:"oidc_jwt_leeway"`
+	LoginURL                           string        `flag:"login-url" cfg:"login_url"`
+	RedeemURL                          string        `flag:"redeem-url" cfg:"redeem_url"`
+	RedeemRetries                      int           `flag:"redeem-retries" cfg:"redeem_retries"`
+	RedeemRetryDelay                   time.Duration `flag:"redeem-retry-delay" cfg:"redeem_retry_delay"`
+	DeviceAuthorizationURL             string        `flag:"device-authorization-url" cfg:"device_authorization_url"`
+	RevocationURL                      string        `flag:"revocation-url" cfg:"revocation_url"`
+	ProfileURL                         string        `flag:"profile-url" cfg:"profile_url"`
+	ProtectedResource                  string        `flag:"resource" cfg:"resource"`
+	ValidateURL                        string        `flag:"validate-url" cfg:"validate_url"`
+	Scope                              string        `flag:"scope" cfg:"scope"`
+	Prompt                             string        `flag:"prompt" cfg:"prompt"`
+	ApprovalPrompt                     string        `flag:"approval-prompt" cfg:"approval_prompt"` // Deprecated by OIDC 1.0
+	AllowedLoginParams                 []string      `flag:"allowed-login-param" cfg:"allowed_login_params"`
+	UserIDClaim                        string        `flag:"user-id-claim" cfg:"user_id_claim"`
+	AllowedGroups                      []string      `flag:"allowed-group" cfg:"allowed_groups"`
+	AllowedGroupsFile                  string        `flag:"allowed-groups-file" cfg:"allowed_groups_file"`
+	AllowedRoles                       []string      `flag:"allowed-role" cfg:"allowed_roles"`
+	RequiredRoles                      []string      `flag:"required-role" cfg:"required_roles"`
 
 	AcrValues  string `flag:"acr-values" cfg:"acr_values"`
 	JWTKey     string `flag:"jwt-key" cfg:"jwt_key"`
@@ -532,6 +642,14 @@ type LegacyProvider struct {
 	CodeChallengeMethod string `flag:"code-challenge-method" cfg:"code_challenge_method"`
 	// Provided for legacy reasons, to be dropped in newer version see #1667
 	ForceCodeChallengeMethod string `flag:"force-code-challenge-method" cfg:"force_code_challenge_method"`
+
+	RequestObjectSigningKeyFile string `flag:"request-object-signing-key-file" cfg:"request_object_signing_key_file"`
+	RequestObjectSigningAlg     string `flag:"request-object-signing-alg" cfg:"request_object_signing_alg"`
+	RequestObjectKeyID          string `flag:"request-object-key-id" cfg:"request_object_key_id"`
+
+	ClientAssertionSigningKeyFile string `flag:"client-assertion-signing-key-file" cfg:"client_assertion_signing_key_file"`
+	ClientAssertionSigningAlg     string `flag:"client-assertion-signing-alg" cfg:"client_assertion_signing_alg"`
+	ClientAssertionKeyID          string `flag:"client-assertion-key-id" cfg:"client_assertion_key_id"`
 }
 
 func legacyProviderFlagSet() *pflag.FlagSet {
@@ -540,45 +658,67 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("keycloak-group", []string{}, "restrict logins to members of these groups (may be given multiple times)")
 	flagSet.String("azure-tenant", "common", "go to a tenant-specific or common (tenant-independent) endpoint.")
 	flagSet.String("azure-graph-group-field", "", "configures the group field to be used when building the groups list(`id` or `displayName`. Default is `id`) from Microsoft Graph(available only for v2.0 oidc url). Based on this value, the `allowed-group` config values should be adjusted accordingly. If using `id` as group field, `allowed-group` should contains groups IDs, if using `displayName` as group field, `allowed-group` should contains groups name")
+	flagSet.String("azure-graph-url", "", "the base URL of Microsoft Graph used to query the user's groups and profile, for national clouds (default https://graph.microsoft.com)")
 	flagSet.String("bitbucket-team", "", "restrict logins to members of this team")
 	flagSet.String("bitbucket-repository", "", "restrict logins to user with access to this repository")
+	flagSet.StringSlice("bitbucket-repository-permission", []string{}, "restrict logins to users with at least this permission on a repository, as workspace/repository:permission where the permission is read, write or admin (may be given multiple times)")
 	flagSet.String("github-org", "", "restrict logins to members of this organisation")
 	flagSet.String("github-team", "", "restrict logins to members of this team")
+	flagSet.Bool("github-require-all-orgs-and-teams", false, "require users to be members of the github-org and all github-team teams instead of any one of them")
 	flagSet.String("github-repo", "", "restrict logins to collaborators of this repository")
 	flagSet.String("github-token", "", "the token to use when verifying repository collaborators (must have push access to the repository)")
 	flagSet.StringSlice("github-user", []string{}, "allow users with these usernames to login even if they do not belong to the specified org and team or collaborators (may be given multiple times)")
 	flagSet.StringSlice("gitlab-group", []string{}, "restrict logins to members of this group (may be given multiple times)")
-	flagSet.StringSlice("gitlab-project", []string{}, "restrict logins to members of this project (may be given multiple times) (eg `group/project=accesslevel`). Access level should be a value matching Gitlab access levels (see https://docs.gitlab.com/ee/api/members.html#valid-access-levels), defaulted to 20 if absent")
+	flagSet.StringSlice("gitlab-project", []string{}, "restrict logins to members of this project (may be given multiple times) (eg `group/project=accesslevel` or `group/project:accesslevel`). Access level should be a value matching Gitlab access levels (see https://docs.gitlab.com/ee/api/members.html#valid-access-levels), defaulted to 20 if absent")
 	flagSet.StringSlice("google-group", []string{}, "restrict logins to members of this google group (may be given multiple times).")
 	flagSet.String("google-admin-email", "", "the google admin to impersonate for api calls")
 	flagSet.String("google-service-account-json", "", "the path to the service account json credentials")
 	flagSet.String("client-id", "", "the OAuth Client ID: ie: \"123456.apps.googleusercontent.com\"")
 	flagSet.String("client-secret", "", "the OAuth Client Secret")
 	flagSet.String("client-secret-file", "", "the file with OAuth Client Secret")
+	flagSet.Duration("client-secret-file-poll-interval", 0, "how often to poll the client-secret-file for a rotated secret, for filesystems where watching the file is not supported; 0 to disable")
 
 	flagSet.String("provider", "google", "OAuth provider")
 	flagSet.String("provider-display-name", "", "Provider display name")
-	flagSet.StringSlice("provider-ca-file", []string{}, "One or more paths to CA certificates that should be used when connecting to the provider.  If not specified, the default Go trust sources are used instead.")
+	flagSet.StringSlice("provider-ca-file", []string{}, "One or more paths to CA certificates that should be used when connecting to the provider, in addition to the system trust store.  If not specified, the default Go trust sources are used instead.")
+	flagSet.Bool("provider-ca-only", false, "Only trust the certificates from provider-ca-file when connecting to the provider, rather than adding them to the system trust store")
 	flagSet.String("oidc-issuer-url", "", "OpenID Connect issuer URL (ie: https://accounts.google.com)")
 	flagSet.Bool("insecure-oidc-allow-unverified-email", false, "Don't fail if an email address in an id_token is not verified")
 	flagSet.Bool("insecure-oidc-skip-issuer-verification", false, "Do not verify if issuer matches OIDC discovery URL")
 	flagSet.Bool("insecure-oidc-skip-nonce", true, "skip verifying the OIDC ID Token's nonce claim")
 	flagSet.Bool("skip-oidc-discovery", false, "Skip OIDC discovery and use manually supplied Endpoints")
+	flagSet.Bool("skip-oidc-end-session-on-logout", false, "Do not redirect to the discovered OIDC end_session_endpoint on sign out, leaving the user logged in at the provider")
 	flagSet.String("oidc-jwks-url", "", "OpenID Connect JWKS URL (ie: https://www.googleapis.com/oauth2/v3/certs)")
 	flagSet.String("oidc-groups-claim", OIDCGroupsClaim, "which OIDC claim contains the user groups")
+	flagSet.StringSlice("oidc-username-claim", []string{}, "which OIDC claim contains the user, the first claim with a value is used (may be given multiple times, e.g. preferred_username then email then sub)")
 	flagSet.String("oidc-email-claim", OIDCEmailClaim, "which OIDC claim contains the user's email")
 	flagSet.StringSlice("oidc-audience-claim", OIDCAudienceClaims, "which OIDC claims are used as audience to verify against client id")
 	flagSet.StringSlice("oidc-extra-audience", []string{}, "additional audiences allowed to pass audience verification")
+	flagSet.StringSlice("oidc-extra-issuer-url", []string{}, "additional OpenID Connect issuer URLs whose ID tokens are accepted, verified with the keys discovered from each issuer")
+	flagSet.Duration("oidc-discovery-timeout", 0, "how long to retry the OIDC discovery at startup while the issuer cannot be reached (0 to fail immediately)")
+	flagSet.Duration("oidc-discovery-retry-backoff", 0, "delay before the first retry of the OIDC discovery, doubled for each retry up to 30s (default 1s)")
+	flagSet.Duration("oidc-jwt-leeway", DefaultOIDCJWTLeeway, "clock skew with the OIDC issuer tolerated when checking the exp, iat and nbf claims of ID tokens")
 	flagSet.String("login-url", "", "Authentication endpoint")
 	flagSet.String("redeem-url", "", "Token redemption endpoint")
+	flagSet.Int("redeem-retries", 0, "number of times to retry calls to the token redemption endpoint to refresh tokens after a network error or a 502, 503 or 504 response. Code redemptions are only retried when no connection could be made")
+	flagSet.Duration("redeem-retry-delay", 0, "delay before the first retry of a call to the token redemption endpoint, doubled for each retry (default 100ms)")
+	flagSet.String("device-authorization-url", "", "Device Authorization URL to enable the RFC 8628 device login endpoints (discovered when using OIDC discovery)")
+	flagSet.String("revocation-url", "", "Token revocation endpoint used to revoke the session tokens on sign out (discovered when using OIDC discovery)")
 	flagSet.String("profile-url", "", "Profile access endpoint")
 	flagSet.String("resource", "", "The resource that is protected (Azure AD only)")
 	flagSet.String("validate-url", "", "Access token validation endpoint")
 	flagSet.String("scope", "", "OAuth scope specification")
 	flagSet.String("prompt", "", "OIDC prompt")
 	flagSet.String("approval-prompt", "force", "OAuth approval_prompt")
+	flagSet.StringSlice("allowed-login-param", []string{}, "query parameter of the /oauth2/start request that is forwarded to the provider login URL, e.g. login_hint (may be given multiple times)")
 	flagSet.String("code-challenge-method", "", "use PKCE code challenges with the specified method. Either 'plain' or 'S256'")
 	flagSet.String("force-code-challenge-method", "", "Deprecated - use --code-challenge-method")
+	flagSet.String("request-object-signing-key-file", "", "path to a private key file in PEM format used to sign the authorization request as a JWT request object (RFC 9101). Request objects are only sent when this is set")
+	flagSet.String("request-object-signing-alg", RequestObjectSigningRS256, "the algorithm used to sign request objects: RS256 or ES256")
+	flagSet.String("request-object-key-id", "", "the key ID (kid) set in the header of request objects")
+	flagSet.String("client-assertion-signing-key-file", "", "path to a private key file in PEM format used to sign a JWT client assertion (private_key_jwt) that authenticates calls to the token endpoint instead of the client secret")
+	flagSet.String("client-assertion-signing-alg", RequestObjectSigningRS256, "the algorithm used to sign client assertions: RS256 or ES256")
+	flagSet.String("client-assertion-key-id", "", "the key ID (kid) set in the header of client assertions")
 
 	flagSet.String("acr-values", "", "acr values string:  optional")
 	flagSet.String("jwt-key", "", "private key in PEM format used to sign JWT, so that you can say something like -jwt-key=\"${OAUTH2_PROXY_JWT_KEY}\": required by login.gov")
@@ -587,7 +727,9 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 
 	flagSet.String("user-id-claim", OIDCEmailClaim, "(DEPRECATED for `oidc-email-claim`) which claim contains the user ID")
 	flagSet.StringSlice("allowed-group", []string{}, "restrict logins to members of this group (may be given multiple times)")
+	flagSet.String("allowed-groups-file", "", "restrict logins to members of the groups listed in this file, one per line or as a JSON array; the file is reloaded when it changes")
 	flagSet.StringSlice("allowed-role", []string{}, "(keycloak-oidc) restrict logins to members of these roles (may be given multiple times)")
+	flagSet.StringSlice("required-role", []string{}, "(keycloak-oidc) restrict logins to users with all of these roles, client roles are given as client:role (may be given multiple times)")
 
 	return flagSet
 }
@@ -596,6 +738,10 @@ func (l LegacyServer) convert() (Server, Server) {
 	appServer := Server{
 		BindAddress:       l.HTTPAddress,
 		SecureBindAddress: l.HTTPSAddress,
+		SocketFileMode:    l.SocketFileMode,
+
+		ShutdownTimeout:       Duration(l.ShutdownDrainTimeout),
+		StreamShutdownTimeout: Duration(l.ShutdownStreamTimeout),
 	}
 	if l.TLSKeyFile != "" || l.TLSCertFile != "" {
 		appServer.TLS = &TLS{
@@ -621,6 +767,10 @@ func (l LegacyServer) convert() (Server, Server) {
 	metricsServer := Server{
 		BindAddress:       l.MetricsAddress,
 		SecureBindAddress: l.MetricsSecureAddress,
+		SocketFileMode:    l.MetricsSocketFileMode,
+
+		ShutdownTimeout:       Duration(l.ShutdownDrainTimeout),
+		StreamShutdownTimeout: Duration(l.ShutdownStreamTimeout),
 	}
 	if l.MetricsTLSKeyFile != "" || l.MetricsTLSCertFile != "" {
 		metricsServer.TLS = &TLS{
@@ -640,34 +790,48 @@ func (l *LegacyProvider) convert() (Providers, error) {
 	providers := Providers{}
 
 	provider := Provider{
-		ClientID:            l.ClientID,
-		ClientSecret:        l.ClientSecret,
-		ClientSecretFile:    l.ClientSecretFile,
-		Type:                ProviderType(l.ProviderType),
-		CAFiles:             l.ProviderCAFiles,
-		LoginURL:            l.LoginURL,
-		RedeemURL:           l.RedeemURL,
-		ProfileURL:          l.ProfileURL,
-		ProtectedResource:   l.ProtectedResource,
-		ValidateURL:         l.ValidateURL,
-		Scope:               l.Scope,
-		AllowedGroups:       l.AllowedGroups,
-		CodeChallengeMethod: l.CodeChallengeMethod,
+		ClientID:                     l.ClientID,
+		ClientSecret:                 l.ClientSecret,
+		ClientSecretFile:             l.ClientSecretFile,
+		ClientSecretFilePollInterval: Duration(l.ClientSecretFilePollInterval),
+		Type:                         ProviderType(l.ProviderType),
+		CAFiles:                      l.ProviderCAFiles,
+		CAFilesOnly:                  l.ProviderCAOnly,
+		LoginURL:                     l.LoginURL,
+		RedeemURL:                    l.RedeemURL,
+		RedeemRetries:                l.RedeemRetries,
+		RedeemRetryDelay:             Duration(l.RedeemRetryDelay),
+		DeviceAuthorizationURL:       l.DeviceAuthorizationURL,
+		RevocationURL:                l.RevocationURL,
+		ProfileURL:                   l.ProfileURL,
+		ProtectedResource:            l.ProtectedResource,
+		ValidateURL:                  l.ValidateURL,
+		Scope:                        l.Scope,
+		AllowedGroups:                l.AllowedGroups,
+		AllowedGroupsFile:            l.AllowedGroupsFile,
+		CodeChallengeMethod:          l.CodeChallengeMethod,
 	}
 
 	// This part is out of the switch section for all providers that support OIDC
+	jwtLeeway := Duration(l.OIDCJWTLeeway)
 	provider.OIDCConfig = OIDCOptions{
 		IssuerURL:                      l.OIDCIssuerURL,
 		InsecureAllowUnverifiedEmail:   l.InsecureOIDCAllowUnverifiedEmail,
 		InsecureSkipIssuerVerification: l.InsecureOIDCSkipIssuerVerification,
 		InsecureSkipNonce:              l.InsecureOIDCSkipNonce,
 		SkipDiscovery:                  l.SkipOIDCDiscovery,
+		SkipEndSessionOnLogout:         l.SkipOIDCEndSessionOnLogout,
 		JwksURL:                        l.OIDCJwksURL,
 		UserIDClaim:                    l.UserIDClaim,
 		EmailClaim:                     l.OIDCEmailClaim,
 		GroupsClaim:                    l.OIDCGroupsClaim,
+		UsernameClaims:                 l.OIDCUsernameClaims,
 		AudienceClaims:                 l.OIDCAudienceClaims,
 		ExtraAudiences:                 l.OIDCExtraAudiences,
+		ExtraIssuerURLs:                l.OIDCExtraIssuerURLs,
+		DiscoveryTimeout:               Duration(l.OIDCDiscoveryTimeout),
+		DiscoveryRetryBackoff:          Duration(l.OIDCDiscoveryRetryBackoff),
+		JWTLeeway:                      &jwtLeeway,
 	}
 
 	// Support for legacy configuration option
@@ -675,26 +839,45 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		provider.CodeChallengeMethod = l.ForceCodeChallengeMethod
 	}
 
+	if l.RequestObjectSigningKeyFile != "" {
+		provider.RequestObject = &RequestObjectOptions{
+			SigningAlgorithm: l.RequestObjectSigningAlg,
+			SigningKeyFile:   l.RequestObjectSigningKeyFile,
+			KeyID:            l.RequestObjectKeyID,
+		}
+	}
+
+	if l.ClientAssertionSigningKeyFile != "" {
+		provider.ClientAssertion = &ClientAssertionOptions{
+			SigningAlgorithm: l.ClientAssertionSigningAlg,
+			SigningKeyFile:   l.ClientAssertionSigningKeyFile,
+			KeyID:            l.ClientAssertionKeyID,
+		}
+	}
+
 	// This part is out of the switch section because azure has a default tenant
 	// that needs to be added from legacy options
 	provider.AzureConfig = AzureOptions{
 		Tenant:          l.AzureTenant,
 		GraphGroupField: l.AzureGraphGroupField,
+		GraphURL:        l.AzureGraphURL,
 	}
 
 	switch provider.Type {
 	case "github":
 		provider.GitHubConfig = GitHubOptions{
-			Org:   l.GitHubOrg,
-			Team:  l.GitHubTeam,
-			Repo:  l.GitHubRepo,
-			Token: l.GitHubToken,
-			Users: l.GitHubUsers,
+			Org:                    l.GitHubOrg,
+			Team:                   l.GitHubTeam,
+			RequireAllOrgsAndTeams: l.GitHubRequireAll,
+			Repo:                   l.GitHubRepo,
+			Token:                  l.GitHubToken,
+			Users:                  l.GitHubUsers,
 		}
 	case "keycloak-oidc":
 		provider.KeycloakConfig = KeycloakOptions{
-			Groups: l.KeycloakGroups,
-			Roles:  l.AllowedRoles,
+			Groups:        l.KeycloakGroups,
+			Roles:         l.AllowedRoles,
+			RequiredRoles: l.RequiredRoles,
 		}
 	case "keycloak":
 		provider.KeycloakConfig = KeycloakOptions{
@@ -713,8 +896,9 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		}
 	case "bitbucket":
 		provider.BitbucketConfig = BitbucketOptions{
-			Team:       l.BitbucketTeam,
-			Repository: l.BitbucketRepository,
+			Team:                  l.BitbucketTeam,
+			Repository:            l.BitbucketRepository,
+			RepositoryPermissions: l.BitbucketPermissions,
 		}
 	case "google":
 		provider.GoogleConfig = GoogleOptions{
@@ -746,6 +930,9 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		// specified, use approval_prompt=force
 		urlParams = append(urlParams, LoginURLParameter{Name: "approval_prompt", Default: []string{"force"}})
 	}
+	for _, name := range l.AllowedLoginParams {
+		urlParams = allowLoginURLParameter(urlParams, name)
+	}
 
 	provider.LoginURLParameters = urlParams
 
@@ -753,3 +940,17 @@ func (l *LegacyProvider) convert() (Providers, error) {
 
 	return providers, nil
 }
+
+// allowLoginURLParameter allows any value of the named parameter to be passed
+// from the start URL, keeping any default already configured for it
+func allowLoginURLParameter(params []LoginURLParameter, name string) []LoginURLParameter {
+	anyValue := ".*"
+	rule := URLParameterRule{Pattern: &anyValue}
+	for i := range params {
+		if params[i].Name == name {
+			params[i].Allow = append(params[i].Allow, rule)
+			return params
+		}
+	}
+	return append(params, LoginURLParameter{Name: name, Allow: []URLParameterRule{rule}})
+}
@@ -28,7 +28,19 @@ var _ = Describe("Legacy Options", func() {
 
 			truth := true
 			staticCode := 204
+			maxIdleConns := DefaultUpstreamMaxIdleConns
+			maxIdleConnsPerHost := DefaultUpstreamMaxIdleConnsPerHost
+			maxConnsPerHost := 0
+			idleConnTimeout := Duration(DefaultUpstreamIdleConnTimeout)
+			maxResponseHeaderBytes := int64(DefaultUpstreamMaxResponseHeaderBytes)
 			opts.UpstreamServers = UpstreamConfig{
+				Transport: UpstreamTransport{
+					MaxIdleConns:           &maxIdleConns,
+					MaxIdleConnsPerHost:    &maxIdleConnsPerHost,
+					MaxConnsPerHost:        &maxConnsPerHost,
+					IdleConnTimeout:        &idleConnTimeout,
+					MaxResponseHeaderBytes: &maxResponseHeaderBytes,
+				},
 				Upstreams: []Upstream{
 					{
 						ID:                    "/baz",
@@ -123,6 +135,8 @@ var _ = Describe("Legacy Options", func() {
 			opts.Providers[0].OIDCConfig.InsecureSkipNonce = true
 			opts.Providers[0].OIDCConfig.AudienceClaims = []string{"aud"}
 			opts.Providers[0].OIDCConfig.ExtraAudiences = []string{}
+			jwtLeeway := Duration(DefaultOIDCJWTLeeway)
+			opts.Providers[0].OIDCConfig.JWTLeeway = &jwtLeeway
 			opts.Providers[0].LoginURLParameters = []LoginURLParameter{
 				{Name: "approval_prompt", Default: []string{"force"}},
 			}
@@ -466,6 +480,19 @@ var _ = Describe("Legacy Options", func() {
 			},
 		}
 
+		accessTokenExpiry := Header{
+			Name:                 "X-Auth-Request-Access-Token-Expiry",
+			PreserveRequestValue: false,
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim:      "expires_on",
+						TimeFormat: ClaimTimeFormatUnix,
+					},
+				},
+			},
+		}
+
 		authorizationHeader := Header{
 			Name:                 "Authorization",
 			PreserveRequestValue: false,
@@ -479,6 +506,18 @@ var _ = Describe("Legacy Options", func() {
 			},
 		}
 
+		claimsHeader := Header{
+			Name:                 "X-Forwarded-Claims",
+			PreserveRequestValue: false,
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim: ClaimIDTokenClaims,
+					},
+				},
+			},
+		}
+
 		DescribeTable("should convert to injectRequestHeaders",
 			func(in legacyHeadersTableInput) {
 				requestHeaders, responseHeaders := in.legacyHeaders.convert()
@@ -721,6 +760,58 @@ var _ = Describe("Legacy Options", func() {
 					xAuthRequestAccessToken,
 				},
 			}),
+			Entry("with passAccessToken and an accessTokenExpiryHeader", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassBasicAuth:     false,
+					PassAccessToken:   true,
+					PassUserHeaders:   false,
+					PassAuthorization: false,
+
+					SetBasicAuth:     false,
+					SetXAuthRequest:  true,
+					SetAuthorization: false,
+
+					PreferEmailToUser:    false,
+					BasicAuthPassword:    "",
+					SkipAuthStripHeaders: true,
+
+					AccessTokenExpiryHeader: "X-Auth-Request-Access-Token-Expiry",
+					AccessTokenExpiryFormat: ClaimTimeFormatUnix,
+				},
+				expectedRequestHeaders: []Header{
+					xForwardedAccessToken,
+					accessTokenExpiry,
+				},
+				expectedResponseHeaders: []Header{
+					xAuthRequestUser,
+					xAuthRequestEmail,
+					xAuthRequestGroups,
+					xAuthRequestPreferredUsername,
+					xAuthRequestAccessToken,
+					accessTokenExpiry,
+				},
+			}),
+			Entry("with an accessTokenExpiryHeader but without passAccessToken", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassBasicAuth:     false,
+					PassAccessToken:   false,
+					PassUserHeaders:   false,
+					PassAuthorization: false,
+
+					SetBasicAuth:     false,
+					SetXAuthRequest:  false,
+					SetAuthorization: false,
+
+					PreferEmailToUser:    false,
+					BasicAuthPassword:    "",
+					SkipAuthStripHeaders: true,
+
+					AccessTokenExpiryHeader: "X-Auth-Request-Access-Token-Expiry",
+					AccessTokenExpiryFormat: ClaimTimeFormatUnix,
+				},
+				expectedRequestHeaders:  []Header{},
+				expectedResponseHeaders: []Header{},
+			}),
 			Entry("with passAcessToken and SkipAuthStripHeaders disabled", legacyHeadersTableInput{
 				legacyHeaders: &LegacyHeaders{
 					PassBasicAuth:     false,
@@ -785,6 +876,28 @@ var _ = Describe("Legacy Options", func() {
 					authorizationHeader,
 				},
 			}),
+			Entry("with passClaimsHeader", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassClaimsHeader:     "X-Forwarded-Claims",
+					SkipAuthStripHeaders: true,
+				},
+				expectedRequestHeaders: []Header{
+					claimsHeader,
+				},
+				expectedResponseHeaders: []Header{},
+			}),
+			Entry("with passClaimsHeader and SkipAuthStripHeaders disabled", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassAccessToken:      true,
+					PassClaimsHeader:     "X-Forwarded-Claims",
+					SkipAuthStripHeaders: false,
+				},
+				expectedRequestHeaders: []Header{
+					withPreserveRequestValue(xForwardedAccessToken, true),
+					claimsHeader,
+				},
+				expectedResponseHeaders: []Header{},
+			}),
 		)
 	})
 
@@ -916,6 +1029,42 @@ var _ = Describe("Legacy Options", func() {
 					TLS:               tlsConfig,
 				},
 			}),
+			Entry("with app and metrics unix sockets", legacyServersTableInput{
+				legacyServer: LegacyServer{
+					HTTPAddress:           "unix:///run/oauth2-proxy.sock",
+					HTTPSAddress:          secureAddr,
+					SocketFileMode:        "0660",
+					MetricsAddress:        "unix:///run/oauth2-proxy-metrics.sock",
+					MetricsSocketFileMode: "0600",
+				},
+				expectedAppServer: Server{
+					BindAddress:    "unix:///run/oauth2-proxy.sock",
+					SocketFileMode: "0660",
+				},
+				expectedMetricsServer: Server{
+					BindAddress:    "unix:///run/oauth2-proxy-metrics.sock",
+					SocketFileMode: "0600",
+				},
+			}),
+			Entry("with shutdown timeouts", legacyServersTableInput{
+				legacyServer: LegacyServer{
+					HTTPAddress:           insecureAddr,
+					HTTPSAddress:          secureAddr,
+					MetricsAddress:        insecureMetricsAddr,
+					ShutdownDrainTimeout:  30 * time.Second,
+					ShutdownStreamTimeout: 5 * time.Second,
+				},
+				expectedAppServer: Server{
+					BindAddress:           insecureAddr,
+					ShutdownTimeout:       Duration(30 * time.Second),
+					StreamShutdownTimeout: Duration(5 * time.Second),
+				},
+				expectedMetricsServer: Server{
+					BindAddress:           insecureMetricsAddr,
+					ShutdownTimeout:       Duration(30 * time.Second),
+					StreamShutdownTimeout: Duration(5 * time.Second),
+				},
+			}),
 		)
 	})
 
@@ -928,6 +1077,7 @@ var _ = Describe("Legacy Options", func() {
 
 		// Non defaults for these options
 		clientID := "abcd"
+		noJWTLeeway := Duration(0)
 
 		defaultURLParams := []LoginURLParameter{
 			{Name: "approval_prompt", Default: []string{"force"}},
@@ -938,6 +1088,7 @@ var _ = Describe("Legacy Options", func() {
 			ClientID:           clientID,
 			Type:               "google",
 			LoginURLParameters: defaultURLParams,
+			OIDCConfig:         OIDCOptions{JWTLeeway: &noJWTLeeway},
 		}
 		defaultLegacyProvider := LegacyProvider{
 			ClientID:     clientID,
@@ -951,6 +1102,7 @@ var _ = Describe("Legacy Options", func() {
 			LoginURLParameters: []LoginURLParameter{
 				{Name: "prompt", Default: []string{"switch_user"}},
 			},
+			OIDCConfig: OIDCOptions{JWTLeeway: &noJWTLeeway},
 		}
 		defaultLegacyProviderWithPrompt := LegacyProvider{
 			ClientID:     clientID,
@@ -958,12 +1110,31 @@ var _ = Describe("Legacy Options", func() {
 			Prompt:       "switch_user",
 		}
 
+		anyValue := ".*"
+		allowedLoginParamsProvider := Provider{
+			ID:       "google=" + clientID,
+			ClientID: clientID,
+			Type:     "google",
+			LoginURLParameters: []LoginURLParameter{
+				{Name: "prompt", Default: []string{"switch_user"}, Allow: []URLParameterRule{{Pattern: &anyValue}}},
+				{Name: "login_hint", Allow: []URLParameterRule{{Pattern: &anyValue}}},
+			},
+			OIDCConfig: OIDCOptions{JWTLeeway: &noJWTLeeway},
+		}
+		allowedLoginParamsLegacyProvider := LegacyProvider{
+			ClientID:           clientID,
+			ProviderType:       "google",
+			Prompt:             "switch_user",
+			AllowedLoginParams: []string{"prompt", "login_hint"},
+		}
+
 		displayNameProvider := Provider{
 			ID:                 "displayName",
 			Name:               "displayName",
 			ClientID:           clientID,
 			Type:               "google",
 			LoginURLParameters: defaultURLParams,
+			OIDCConfig:         OIDCOptions{JWTLeeway: &noJWTLeeway},
 		}
 
 		displayNameLegacyProvider := LegacyProvider{
@@ -982,6 +1153,7 @@ var _ = Describe("Legacy Options", func() {
 				Groups:             []string{"1", "2"},
 			},
 			LoginURLParameters: defaultURLParams,
+			OIDCConfig:         OIDCOptions{JWTLeeway: &noJWTLeeway},
 		}
 
 		internalConfigLegacyProvider := LegacyProvider{
@@ -1014,6 +1186,11 @@ var _ = Describe("Legacy Options", func() {
 				expectedProviders: Providers{defaultProviderWithPrompt},
 				errMsg:            "",
 			}),
+			Entry("with allowed login params", &convertProvidersTableInput{
+				legacyProvider:    allowedLoginParamsLegacyProvider,
+				expectedProviders: Providers{allowedLoginParamsProvider},
+				errMsg:            "",
+			}),
 			Entry("with provider display name", &convertProvidersTableInput{
 				legacyProvider:    displayNameLegacyProvider,
 				expectedProviders: Providers{displayNameProvider},
@@ -111,6 +111,13 @@ func registerFlags(v *viper.Viper, prefix string, flagSet *pflag.FlagSet, option
 		if f == nil {
 			return fmt.Errorf("field %q does not have a registered flag", flagName)
 		}
+		if f.Value.Type() == "stringArray" {
+			err := bindStringArrayFlag(v, cfgName, f)
+			if err != nil {
+				return fmt.Errorf("error binding flag for field %q: %w", fieldName, err)
+			}
+			continue
+		}
 		err := v.BindPFlag(cfgName, f)
 		if err != nil {
 			return fmt.Errorf("error binding flag for field %q: %w", fieldName, err)
@@ -120,6 +127,20 @@ func registerFlags(v *viper.Viper, prefix string, flagSet *pflag.FlagSet, option
 	return nil
 }
 
+// bindStringArrayFlag associates a stringArray flag to the config option.
+// Viper reads these flags back as a single string, which would then be split
+// on commas, so the values of the flag are set on the config directly instead.
+// The flag still takes precedence over the config file and the environment.
+func bindStringArrayFlag(v *viper.Viper, cfgName string, f *pflag.Flag) error {
+	values := f.Value.(pflag.SliceValue).GetSlice()
+	if f.Changed {
+		v.Set(cfgName, values)
+	} else if len(values) > 0 {
+		v.SetDefault(cfgName, values)
+	}
+	return v.BindEnv(cfgName)
+}
+
 // decodeFromCfgTag sets the Viper decoder to read the names from the `cfg` tag
 // on each struct entry.
 func decodeFromCfgTag(c *mapstructure.DecoderConfig) {
@@ -3,6 +3,7 @@ package options
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
@@ -22,12 +23,19 @@ var _ = Describe("Load", func() {
 			ProxyWebSockets: true,
 			FlushInterval:   DefaultUpstreamFlushInterval,
 			Timeout:         DefaultUpstreamTimeout,
+
+			MaxIdleConns:        DefaultUpstreamMaxIdleConns,
+			MaxIdleConnsPerHost: DefaultUpstreamMaxIdleConnsPerHost,
+			IdleConnTimeout:     DefaultUpstreamIdleConnTimeout,
+
+			MaxResponseHeaderBytes: DefaultUpstreamMaxResponseHeaderBytes,
 		},
 
 		LegacyHeaders: LegacyHeaders{
-			PassBasicAuth:        true,
-			PassUserHeaders:      true,
-			SkipAuthStripHeaders: true,
+			PassBasicAuth:           true,
+			PassUserHeaders:         true,
+			SkipAuthStripHeaders:    true,
+			AccessTokenExpiryFormat: ClaimTimeFormatRFC3339,
 		},
 
 		LegacyServer: LegacyServer{
@@ -36,20 +44,24 @@ var _ = Describe("Load", func() {
 		},
 
 		LegacyProvider: LegacyProvider{
-			ProviderType:          "google",
-			AzureTenant:           "common",
-			ApprovalPrompt:        "force",
-			UserIDClaim:           "email",
-			OIDCEmailClaim:        "email",
-			OIDCGroupsClaim:       "groups",
-			OIDCAudienceClaims:    []string{"aud"},
-			InsecureOIDCSkipNonce: true,
+			ProviderType:              "google",
+			AzureTenant:               "common",
+			ApprovalPrompt:            "force",
+			UserIDClaim:               "email",
+			OIDCEmailClaim:            "email",
+			OIDCGroupsClaim:           "groups",
+			OIDCAudienceClaims:        []string{"aud"},
+			RequestObjectSigningAlg:   RequestObjectSigningRS256,
+			ClientAssertionSigningAlg: RequestObjectSigningRS256,
+			InsecureOIDCSkipNonce:     true,
+			OIDCJWTLeeway:             DefaultOIDCJWTLeeway,
 		},
 
 		Options: Options{
 			ProxyPrefix:        "/oauth2",
 			PingPath:           "/ping",
 			ReadyPath:          "/ready",
+			ReadyCheckCacheTTL: 5 * time.Second,
 			RealClientIPHeader: "X-Real-IP",
 			ForceHTTPS:         false,
 			Cookie:             cookieDefaults(),
@@ -57,12 +69,32 @@ var _ = Describe("Load", func() {
 			Templates:          templatesDefaults(),
 			SkipAuthPreflight:  false,
 			Logging:            loggingDefaults(),
+
+			APIKeyHeader: "X-API-Key",
+
+			AjaxUnauthorizedCode: http.StatusUnauthorized,
+			DefaultRootAction:    "sign_in",
+
+			LoginFailureMaxBackoff: time.Minute,
+
+			TracingSampleRatio: 1,
+
+			HeaderWebhookTimeout:  time.Second,
+			HeaderWebhookCacheTTL: time.Minute,
+
+			JWKSCacheSize:       10,
+			JWKSRefreshInterval: time.Hour,
+			JWKSRefreshJitter:   5 * time.Minute,
+			JWKSRefreshCooldown: time.Minute,
+
+			CallbackMaxBodySize: 1 << 20,
 		},
 	}
 
 	Context("with a testOptions structure", func() {
 		type TestOptionSubStruct struct {
 			StringSliceOption []string `flag:"string-slice-option" cfg:"string_slice_option"`
+			StringArrayOption []string `flag:"string-array-option" cfg:"string_array_option"`
 		}
 
 		type TestOptions struct {
@@ -110,6 +142,7 @@ var _ = Describe("Load", func() {
 			testOptionsFlagSet = pflag.NewFlagSet("testFlagSet", pflag.ExitOnError)
 			testOptionsFlagSet.String("string-option", "default", "")
 			testOptionsFlagSet.StringSlice("string-slice-option", []string{"a", "b"}, "")
+			testOptionsFlagSet.StringArray("string-array-option", []string{}, "")
 		})
 
 		DescribeTable("Load",
@@ -222,6 +255,52 @@ var _ = Describe("Load", func() {
 					},
 				},
 			}),
+			Entry("when setting string array flags with commas", &testOptionsTableInput{
+				args: []string{
+					"--string-array-option", "x: a,b",
+					"--string-array-option", "y",
+				},
+				flagSet: func() *pflag.FlagSet { return testOptionsFlagSet },
+				expectedOutput: &TestOptions{
+					StringOption: "default",
+					Sub: TestOptionSubStruct{
+						StringSliceOption: []string{"a", "b"},
+						StringArrayOption: []string{"x: a,b", "y"},
+					},
+				},
+			}),
+			Entry("when setting string array options in the config file and env variables", &testOptionsTableInput{
+				configFile: []byte(`
+					string_array_option=["x: a,b", "y"]
+				`),
+				env: map[string]string{
+					"OAUTH2_PROXY_STRING_ARRAY_OPTION": "z",
+				},
+				flagSet: func() *pflag.FlagSet { return testOptionsFlagSet },
+				expectedOutput: &TestOptions{
+					StringOption: "default",
+					Sub: TestOptionSubStruct{
+						StringSliceOption: []string{"a", "b"},
+						StringArrayOption: []string{"z"},
+					},
+				},
+			}),
+			Entry("when setting string array options in the config file and flags", &testOptionsTableInput{
+				configFile: []byte(`
+					string_array_option=["x: a,b", "y"]
+				`),
+				args: []string{
+					"--string-array-option", "z",
+				},
+				flagSet: func() *pflag.FlagSet { return testOptionsFlagSet },
+				expectedOutput: &TestOptions{
+					StringOption: "default",
+					Sub: TestOptionSubStruct{
+						StringSliceOption: []string{"a", "b"},
+						StringArrayOption: []string{"z"},
+					},
+				},
+			}),
 			Entry("when setting env variables without a config file", &testOptionsTableInput{
 				env: map[string]string{
 					"OAUTH2_PROXY_STRING_OPTION":       "bar",
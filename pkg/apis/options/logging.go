@@ -7,18 +7,20 @@ import (
 
 // Logging contains all options required for configuring the logging
 type Logging struct {
-	AuthEnabled     bool           `flag:"auth-logging" cfg:"auth_logging"`
-	AuthFormat      string         `flag:"auth-logging-format" cfg:"auth_logging_format"`
-	RequestEnabled  bool           `flag:"request-logging" cfg:"request_logging"`
-	RequestFormat   string         `flag:"request-logging-format" cfg:"request_logging_format"`
-	StandardEnabled bool           `flag:"standard-logging" cfg:"standard_logging"`
-	StandardFormat  string         `flag:"standard-logging-format" cfg:"standard_logging_format"`
-	ErrToInfo       bool           `flag:"errors-to-info-log" cfg:"errors_to_info_log"`
-	ExcludePaths    []string       `flag:"exclude-logging-path" cfg:"exclude_logging_paths"`
-	LocalTime       bool           `flag:"logging-local-time" cfg:"logging_local_time"`
-	SilencePing     bool           `flag:"silence-ping-logging" cfg:"silence_ping_logging"`
-	RequestIDHeader string         `flag:"request-id-header" cfg:"request_id_header"`
-	File            LogFileOptions `cfg:",squash"`
+	AuthEnabled       bool           `flag:"auth-logging" cfg:"auth_logging"`
+	AuthFormat        string         `flag:"auth-logging-format" cfg:"auth_logging_format"`
+	RequestEnabled    bool           `flag:"request-logging" cfg:"request_logging"`
+	RequestFormat     string         `flag:"request-logging-format" cfg:"request_logging_format"`
+	RequestFormatType string         `flag:"request-logging-format-type" cfg:"request_logging_format_type"`
+	StandardEnabled   bool           `flag:"standard-logging" cfg:"standard_logging"`
+	StandardFormat    string         `flag:"standard-logging-format" cfg:"standard_logging_format"`
+	ErrToInfo         bool           `flag:"errors-to-info-log" cfg:"errors_to_info_log"`
+	ExcludePaths      []string       `flag:"exclude-logging-path" cfg:"exclude_logging_paths"`
+	RedactQueryParams []string       `flag:"redact-logging-query-param" cfg:"redact_logging_query_params"`
+	LocalTime         bool           `flag:"logging-local-time" cfg:"logging_local_time"`
+	SilencePing       bool           `flag:"silence-ping-logging" cfg:"silence_ping_logging"`
+	RequestIDHeader   string         `flag:"request-id-header" cfg:"request_id_header"`
+	File              LogFileOptions `cfg:",squash"`
 }
 
 // LogFileOptions contains options for configuring logging to a file
@@ -39,9 +41,11 @@ func loggingFlagSet() *pflag.FlagSet {
 	flagSet.String("standard-logging-format", logger.DefaultStandardLoggingFormat, "Template for standard log lines")
 	flagSet.Bool("request-logging", true, "Log HTTP requests")
 	flagSet.String("request-logging-format", logger.DefaultRequestLoggingFormat, "Template for HTTP request log lines")
+	flagSet.String("request-logging-format-type", logger.RequestLoggingFormatTypeText, "Format of HTTP request log lines: text (uses request-logging-format) or json")
 	flagSet.Bool("errors-to-info-log", false, "Log errors to the standard logging channel instead of stderr")
 
 	flagSet.StringSlice("exclude-logging-path", []string{}, "Exclude logging requests to paths (eg: '/path1,/path2,/path3')")
+	flagSet.StringSlice("redact-logging-query-param", logger.DefaultRedactedQueryParams, "Query parameters whose values are redacted in the logged request URI (may be given multiple times)")
 	flagSet.Bool("logging-local-time", true, "If the time in log files and backup filenames are local or UTC time")
 	flagSet.Bool("silence-ping-logging", false, "Disable logging of requests to ping & ready endpoints")
 	flagSet.String("request-id-header", "X-Request-Id", "Request header to use as the request ID")
@@ -58,17 +62,19 @@ func loggingFlagSet() *pflag.FlagSet {
 // loggingDefaults creates a Logging structure, populating each field with its default value
 func loggingDefaults() Logging {
 	return Logging{
-		ExcludePaths:    nil,
-		LocalTime:       true,
-		SilencePing:     false,
-		RequestIDHeader: "X-Request-Id",
-		AuthEnabled:     true,
-		AuthFormat:      logger.DefaultAuthLoggingFormat,
-		RequestEnabled:  true,
-		RequestFormat:   logger.DefaultRequestLoggingFormat,
-		StandardEnabled: true,
-		StandardFormat:  logger.DefaultStandardLoggingFormat,
-		ErrToInfo:       false,
+		ExcludePaths:      nil,
+		RedactQueryParams: logger.DefaultRedactedQueryParams,
+		LocalTime:         true,
+		SilencePing:       false,
+		RequestIDHeader:   "X-Request-Id",
+		AuthEnabled:       true,
+		AuthFormat:        logger.DefaultAuthLoggingFormat,
+		RequestEnabled:    true,
+		RequestFormat:     logger.DefaultRequestLoggingFormat,
+		RequestFormatType: logger.RequestLoggingFormatTypeText,
+		StandardEnabled:   true,
+		StandardFormat:    logger.DefaultStandardLoggingFormat,
+		ErrToInfo:         false,
 		File: LogFileOptions{
 			Filename:   "",
 			MaxSize:    100,
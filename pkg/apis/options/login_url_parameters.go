@@ -82,6 +82,8 @@ type LoginURLParameter struct {
 	// overridden via the query string to `/oauth2/start`.  Only
 	// values that match one or more of the allow rules will be
 	// forwarded to the IdP.
+	// Parameters set by OAuth2 Proxy itself, such as `redirect_uri`, `scope`
+	// and `state`, cannot have allow rules.
 	//+optional
 	Allow []URLParameterRule `json:"allow,omitempty"`
 }
@@ -2,7 +2,9 @@ package options
 
 import (
 	"crypto"
+	"net/http"
 	"net/url"
+	"time"
 
 	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
@@ -15,18 +17,36 @@ type SignatureData struct {
 	Key  string
 }
 
+const (
+	// DefaultRootActionSignIn shows the sign in page, or starts the login
+	// when the provider button is skipped, as for any other path.
+	DefaultRootActionSignIn = "sign_in"
+
+	// DefaultRootActionWelcome serves the static welcome page.
+	DefaultRootActionWelcome = "welcome"
+
+	// DefaultRootActionRedirect redirects to the default root redirect URL.
+	DefaultRootActionRedirect = "redirect"
+
+	// DefaultRootActionLogin starts the login with the provider.
+	DefaultRootActionLogin = "login"
+)
+
 // Options holds Configuration Options that can be set by Command Line Flag,
 // or Config File
 type Options struct {
-	ProxyPrefix        string   `flag:"proxy-prefix" cfg:"proxy_prefix"`
-	PingPath           string   `flag:"ping-path" cfg:"ping_path"`
-	PingUserAgent      string   `flag:"ping-user-agent" cfg:"ping_user_agent"`
-	ReadyPath          string   `flag:"ready-path" cfg:"ready_path"`
-	ReverseProxy       bool     `flag:"reverse-proxy" cfg:"reverse_proxy"`
-	RealClientIPHeader string   `flag:"real-client-ip-header" cfg:"real_client_ip_header"`
-	TrustedIPs         []string `flag:"trusted-ip" cfg:"trusted_ips"`
-	ForceHTTPS         bool     `flag:"force-https" cfg:"force_https"`
-	RawRedirectURL     string   `flag:"redirect-url" cfg:"redirect_url"`
+	ProxyPrefix        string        `flag:"proxy-prefix" cfg:"proxy_prefix"`
+	PingPath           string        `flag:"ping-path" cfg:"ping_path"`
+	PingUserAgent      string        `flag:"ping-user-agent" cfg:"ping_user_agent"`
+	ReadyPath          string        `flag:"ready-path" cfg:"ready_path"`
+	ReadyCheckCacheTTL time.Duration `flag:"ready-check-cache-ttl" cfg:"ready_check_cache_ttl"`
+	ReverseProxy       bool          `flag:"reverse-proxy" cfg:"reverse_proxy"`
+	RealClientIPHeader string        `flag:"real-client-ip-header" cfg:"real_client_ip_header"`
+	TrustedIPs         []string      `flag:"trusted-ip" cfg:"trusted_ips"`
+	TrustedIPUser      string        `flag:"trusted-ip-user" cfg:"trusted_ip_user"`
+	TrustedProxyCIDRs  []string      `flag:"trusted-proxy-cidr" cfg:"trusted_proxy_cidrs"`
+	ForceHTTPS         bool          `flag:"force-https" cfg:"force_https"`
+	RawRedirectURL     string        `flag:"redirect-url" cfg:"redirect_url"`
 
 	AuthenticatedEmailsFile string   `flag:"authenticated-emails-file" cfg:"authenticated_emails_file"`
 	EmailDomains            []string `flag:"email-domain" cfg:"email_domains"`
@@ -34,6 +54,12 @@ type Options struct {
 	HtpasswdFile            string   `flag:"htpasswd-file" cfg:"htpasswd_file"`
 	HtpasswdUserGroups      []string `flag:"htpasswd-user-group" cfg:"htpasswd_user_groups"`
 
+	APIKeysFile  string `flag:"api-keys-file" cfg:"api_keys_file"`
+	APIKeyHeader string `flag:"api-key-header" cfg:"api_key_header"`
+
+	AdminAPIToken      string   `flag:"admin-api-token" cfg:"admin_api_token"`
+	AdminAPIAllowedIPs []string `flag:"admin-api-allowed-ip" cfg:"admin_api_allowed_ips"`
+
 	Cookie    Cookie         `cfg:",squash"`
 	Session   SessionOptions `cfg:",squash"`
 	Logging   Logging        `cfg:",squash"`
@@ -56,10 +82,43 @@ type Options struct {
 	SkipAuthRoutes        []string `flag:"skip-auth-route" cfg:"skip_auth_routes"`
 	SkipJwtBearerTokens   bool     `flag:"skip-jwt-bearer-tokens" cfg:"skip_jwt_bearer_tokens"`
 	ExtraJwtIssuers       []string `flag:"extra-jwt-issuers" cfg:"extra_jwt_issuers"`
+	JwtBearerHeaders      []string `flag:"jwt-bearer-header" cfg:"jwt_bearer_headers"`
 	SkipProviderButton    bool     `flag:"skip-provider-button" cfg:"skip_provider_button"`
+	SilentReauth          bool     `flag:"silent-reauth" cfg:"silent_reauth"`
 	SSLInsecureSkipVerify bool     `flag:"ssl-insecure-skip-verify" cfg:"ssl_insecure_skip_verify"`
 	SkipAuthPreflight     bool     `flag:"skip-auth-preflight" cfg:"skip_auth_preflight"`
 	ForceJSONErrors       bool     `flag:"force-json-errors" cfg:"force_json_errors"`
+	AjaxRequestHeaders    []string `flag:"ajax-request-header" cfg:"ajax_request_headers"`
+	AjaxUnauthorizedCode  int      `flag:"ajax-unauthorized-status-code" cfg:"ajax_unauthorized_status_code"`
+
+	DefaultRootAction      string `flag:"default-root-action" cfg:"default_root_action"`
+	DefaultRootRedirectURL string `flag:"default-root-redirect-url" cfg:"default_root_redirect_url"`
+
+	RateLimitRequestsPerSecond float64 `flag:"rate-limit-requests-per-second" cfg:"rate_limit_requests_per_second"`
+	RateLimitBurst             int     `flag:"rate-limit-burst" cfg:"rate_limit_burst"`
+
+	LoginFailureBackoff    time.Duration `flag:"login-failure-backoff" cfg:"login_failure_backoff"`
+	LoginFailureMaxBackoff time.Duration `flag:"login-failure-max-backoff" cfg:"login_failure_max_backoff"`
+
+	TracingOTLPEndpoint string  `flag:"tracing-otlp-endpoint" cfg:"tracing_otlp_endpoint"`
+	TracingSampleRatio  float64 `flag:"tracing-sample-ratio" cfg:"tracing_sample_ratio"`
+
+	StripRequestHeaderPrefixes []string `flag:"strip-request-header-prefix" cfg:"strip_request_header_prefixes"`
+	SetUpstreamHeaders         []string `flag:"set-upstream-header" cfg:"set_upstream_headers"`
+	StripUpstreamHeaders       []string `flag:"strip-upstream-header" cfg:"strip_upstream_headers"`
+	IdentityHeaderSignatureKey string   `flag:"identity-header-signature-key" cfg:"identity_header_signature_key"`
+
+	HeaderWebhookURL      string        `flag:"header-webhook-url" cfg:"header_webhook_url"`
+	HeaderWebhookTimeout  time.Duration `flag:"header-webhook-timeout" cfg:"header_webhook_timeout"`
+	HeaderWebhookCacheTTL time.Duration `flag:"header-webhook-cache-ttl" cfg:"header_webhook_cache_ttl"`
+	HeaderWebhookFailOpen bool          `flag:"header-webhook-fail-open" cfg:"header_webhook_fail_open"`
+
+	JWKSCacheSize       int           `flag:"jwks-cache-size" cfg:"jwks_cache_size"`
+	JWKSRefreshInterval time.Duration `flag:"jwks-refresh-interval" cfg:"jwks_refresh_interval"`
+	JWKSRefreshJitter   time.Duration `flag:"jwks-refresh-jitter" cfg:"jwks_refresh_jitter"`
+	JWKSRefreshCooldown time.Duration `flag:"jwks-refresh-cooldown" cfg:"jwks_refresh_cooldown"`
+
+	CallbackMaxBodySize int64 `flag:"callback-max-body-size" cfg:"callback_max_body_size"`
 
 	SignatureKey    string `flag:"signature-key" cfg:"signature_key"`
 	GCPHealthChecks bool   `flag:"gcp-healthchecks" cfg:"gcp_healthchecks"`
@@ -73,6 +132,9 @@ type Options struct {
 	oidcVerifier       internaloidc.IDTokenVerifier
 	jwtBearerVerifiers []internaloidc.IDTokenVerifier
 	realClientIPParser ipapi.RealClientIPParser
+
+	pageResponseHeaders    http.Header
+	upstreamRequestHeaders http.Header
 }
 
 // Options for Getting internal values
@@ -83,6 +145,8 @@ func (o *Options) GetJWTBearerVerifiers() []internaloidc.IDTokenVerifier {
 	return o.jwtBearerVerifiers
 }
 func (o *Options) GetRealClientIPParser() ipapi.RealClientIPParser { return o.realClientIPParser }
+func (o *Options) GetPageResponseHeaders() http.Header             { return o.pageResponseHeaders }
+func (o *Options) GetUpstreamRequestHeaders() http.Header          { return o.upstreamRequestHeaders }
 
 // Options for Setting internal values
 func (o *Options) SetRedirectURL(s *url.URL)                              { o.redirectURL = s }
@@ -90,6 +154,8 @@ func (o *Options) SetSignatureData(s *SignatureData)                      { o.si
 func (o *Options) SetOIDCVerifier(s internaloidc.IDTokenVerifier)         { o.oidcVerifier = s }
 func (o *Options) SetJWTBearerVerifiers(s []internaloidc.IDTokenVerifier) { o.jwtBearerVerifiers = s }
 func (o *Options) SetRealClientIPParser(s ipapi.RealClientIPParser)       { o.realClientIPParser = s }
+func (o *Options) SetPageResponseHeaders(s http.Header)                   { o.pageResponseHeaders = s }
+func (o *Options) SetUpstreamRequestHeaders(s http.Header)                { o.upstreamRequestHeaders = s }
 
 // NewOptions constructs a new Options with defaulted values
 func NewOptions() *Options {
@@ -98,6 +164,7 @@ func NewOptions() *Options {
 		Providers:          providerDefaults(),
 		PingPath:           "/ping",
 		ReadyPath:          "/ready",
+		ReadyCheckCacheTTL: 5 * time.Second,
 		RealClientIPHeader: "X-Real-IP",
 		ForceHTTPS:         false,
 		Cookie:             cookieDefaults(),
@@ -105,6 +172,25 @@ func NewOptions() *Options {
 		Templates:          templatesDefaults(),
 		SkipAuthPreflight:  false,
 		Logging:            loggingDefaults(),
+
+		APIKeyHeader: "X-API-Key",
+
+		AjaxUnauthorizedCode: http.StatusUnauthorized,
+		DefaultRootAction:    DefaultRootActionSignIn,
+
+		LoginFailureMaxBackoff: time.Minute,
+
+		TracingSampleRatio: 1,
+
+		HeaderWebhookTimeout:  time.Second,
+		HeaderWebhookCacheTTL: time.Minute,
+
+		JWKSCacheSize:       10,
+		JWKSRefreshInterval: time.Hour,
+		JWKSRefreshJitter:   5 * time.Minute,
+		JWKSRefreshCooldown: time.Minute,
+
+		CallbackMaxBodySize: 1 << 20,
 	}
 }
 
@@ -114,30 +200,69 @@ func NewFlagSet() *pflag.FlagSet {
 
 	flagSet.Bool("reverse-proxy", false, "are we running behind a reverse proxy, controls whether headers like X-Real-Ip are accepted")
 	flagSet.String("real-client-ip-header", "X-Real-IP", "Header used to determine the real IP of the client (one of: X-Forwarded-For, X-Real-IP, or X-ProxyUser-IP)")
+	flagSet.String("trusted-ip-user", "", "the user to pass to the upstreams in the identity headers for requests from trusted IPs without a session. When empty, no identity headers are passed for these requests")
+	flagSet.StringSlice("trusted-proxy-cidr", []string{}, "list of IPs or CIDR ranges of proxies trusted to append to the real client IP header. When set, the header is only read from trusted proxies and the client IP is the right-most address in the header that is not a trusted proxy")
 	flagSet.StringSlice("trusted-ip", []string{}, "list of IPs or CIDR ranges to allow to bypass authentication. WARNING: trusting by IP has inherent security flaws, read the configuration documentation for more information.")
 	flagSet.Bool("force-https", false, "force HTTPS redirect for HTTP requests")
 	flagSet.String("redirect-url", "", "the OAuth Redirect URL. ie: \"https://internalapp.yourcompany.com/oauth2/callback\"")
 	flagSet.StringSlice("skip-auth-regex", []string{}, "(DEPRECATED for --skip-auth-route) bypass authentication for requests path's that match (may be given multiple times)")
-	flagSet.StringSlice("skip-auth-route", []string{}, "bypass authentication for requests that match the method & path. Format: method=path_regex OR method!=path_regex. For all methods: path_regex OR !=path_regex")
+	flagSet.StringSlice("skip-auth-route", []string{}, "bypass authentication for requests that match the method & path. Format: method=path_regex OR method!=path_regex, where several methods are separated by |, e.g. GET|HEAD=path_regex. For all methods: path_regex OR !=path_regex")
 	flagSet.StringSlice("api-route", []string{}, "return HTTP 401 instead of redirecting to authentication server if token is not valid. Format: path_regex")
 	flagSet.Bool("skip-provider-button", false, "will skip sign-in-page to directly reach the next step: oauth/start")
+	flagSet.Bool("silent-reauth", false, "when a session expires and cannot be refreshed as it has no refresh token, log the user in again with prompt=none and fall back to the interactive login when the provider requires it; requires cookie-refresh")
+	flagSet.String("default-root-action", DefaultRootActionSignIn, "what unauthenticated requests for the root path receive: \"sign_in\" as for any other path, the static \"welcome\" page, a \"redirect\" to the default-root-redirect-url or the \"login\" with the provider")
+	flagSet.String("default-root-redirect-url", "", "the URL unauthenticated requests for the root path are redirected to when default-root-action is \"redirect\"")
 	flagSet.Bool("skip-auth-preflight", false, "will skip authentication for OPTIONS requests")
 	flagSet.Bool("ssl-insecure-skip-verify", false, "skip validation of certificates presented when using HTTPS providers")
 	flagSet.Bool("skip-jwt-bearer-tokens", false, "will skip requests that have verified JWT bearer tokens (default false)")
 	flagSet.Bool("force-json-errors", false, "will force JSON errors instead of HTTP error pages or redirects")
+	flagSet.StringArray("ajax-request-header", []string{}, "a request header that marks AJAX requests, given as \"Name\" to match any value or \"Name: value\". Requests accepting application/json are always AJAX requests (may be given multiple times)")
+	flagSet.Int("ajax-unauthorized-status-code", http.StatusUnauthorized, "the status returned instead of redirecting AJAX and API requests without a valid session")
+	flagSet.Float64("rate-limit-requests-per-second", 0, "the number of requests per second each client IP can make to the sign in and OAuth endpoints (0 to disable)")
+	flagSet.Int("rate-limit-burst", 0, "the number of requests each client IP can make at once to the sign in and OAuth endpoints (defaults to rate-limit-requests-per-second rounded up)")
+	flagSet.Duration("login-failure-backoff", 0, "the delay of a repeated failed login callback from the same user or client IP, doubled for each further failure (0 to disable)")
+	flagSet.Duration("login-failure-max-backoff", time.Minute, "the maximum delay of repeated failed login callbacks")
+	flagSet.String("tracing-otlp-endpoint", "", "URL of the OTLP/HTTP collector the request traces are exported to, e.g. http://localhost:4318 (disabled when empty)")
+	flagSet.Float64("tracing-sample-ratio", 1, "the ratio of traces that are sampled, between 0 and 1. Requests continuing a trace are sampled by their trace ID, ignoring the sampling decision of the caller")
+	flagSet.StringSlice("strip-request-header-prefix", []string{}, "remove request headers starting with this prefix before requests are proxied to the upstreams, e.g. X-Auth-Request- (may be given multiple times)")
+	flagSet.StringArray("set-upstream-header", []string{}, "a static header to set on every request proxied to the upstreams, as \"Name: value\". Replaces the identity header of the same name (may be given multiple times)")
+	flagSet.StringArray("strip-upstream-header", []string{}, "a header to remove from every request proxied to the upstreams, e.g. Cookie (may be given multiple times)")
+	flagSet.String("identity-header-signature-key", "", "shared secret used to sign the headers injected into requests to the upstreams, the signature is set in the X-Identity-Signature header")
+	flagSet.String("header-webhook-url", "", "URL of a webhook called with the authenticated user and claims, the headers in its JSON response are added to requests to the upstreams")
+	flagSet.Duration("header-webhook-timeout", time.Second, "the timeout for requests to the header webhook")
+	flagSet.Duration("header-webhook-cache-ttl", time.Minute, "how long the header webhook response is cached for each user (0 to disable)")
+	flagSet.Bool("header-webhook-fail-open", false, "proxy requests without the webhook headers when the header webhook fails, instead of rejecting them")
 	flagSet.StringSlice("extra-jwt-issuers", []string{}, "if skip-jwt-bearer-tokens is set, a list of extra JWT issuer=audience pairs (where the issuer URL has a .well-known/openid-configuration or a .well-known/jwks.json)")
+	flagSet.Int("jwks-cache-size", 10, "the number of issuers whose JSON Web Key Sets are cached, the least recently used are evicted")
+	flagSet.Duration("jwks-refresh-interval", time.Hour, "how often the cached JSON Web Key Sets are refreshed in the background")
+	flagSet.Duration("jwks-refresh-jitter", 5*time.Minute, "the maximum random duration taken off each JSON Web Key Set refresh interval")
+	flagSet.Duration("jwks-refresh-cooldown", time.Minute, "the minimum time between JSON Web Key Set refreshes caused by tokens signed with an unknown key ID")
+	flagSet.StringSlice("jwt-bearer-header", []string{}, "if skip-jwt-bearer-tokens is set, a request header to read JWT bearer tokens from in addition to the Authorization header (may be given multiple times)")
 
 	flagSet.StringSlice("email-domain", []string{}, "authenticate emails with the specified domain (may be given multiple times). Use * to authenticate any email")
-	flagSet.StringSlice("whitelist-domain", []string{}, "allowed domains for redirection after authentication. Prefix domain with a . or a *. to allow subdomains (eg .example.com, *.example.com)")
+	flagSet.StringSlice("whitelist-domain", []string{}, "allowed domains for redirection after authentication. Prefix domain with a . or a *. to allow subdomains (eg .example.com, *.example.com). Prefix with a scheme to only allow that scheme (eg https://example.com)")
 	flagSet.String("authenticated-emails-file", "", "authenticate against emails via file (one per line)")
 	flagSet.String("htpasswd-file", "", "additionally authenticate against a htpasswd file. Entries must be created with \"htpasswd -B\" for bcrypt encryption")
 	flagSet.StringSlice("htpasswd-user-group", []string{}, "the groups to be set on sessions for htpasswd users (may be given multiple times)")
+	flagSet.String("api-keys-file", "", "additionally authenticate machine clients with the API keys in this YAML file, each mapped to a user, email and groups. The file is reloaded when it changes")
+	flagSet.String("api-key-header", "X-API-Key", "the request header machine clients present their API key in")
+	flagSet.String("admin-api-token", "", "enables the admin endpoints, such as invalidating the sessions of a user, for clients presenting this bearer token. Requires a redis session store")
+	flagSet.StringSlice("admin-api-allowed-ip", []string{}, "list of IPs or CIDR ranges the admin endpoints may be called from, in addition to the admin-api-token. Any IP when empty")
 	flagSet.String("proxy-prefix", "/oauth2", "the url root path that this proxy should be nested under (e.g. /<oauth2>/sign_in)")
 	flagSet.String("ping-path", "/ping", "the ping endpoint that can be used for basic health checks")
 	flagSet.String("ping-user-agent", "", "special User-Agent that will be used for basic health checks")
 	flagSet.String("ready-path", "/ready", "the ready endpoint that can be used for deep health checks")
+	flagSet.Duration("ready-check-cache-ttl", 5*time.Second, "how long the results of the ready endpoint dependency checks are cached for")
 	flagSet.String("session-store-type", "cookie", "the session storage provider to use")
+	flagSet.String("session-store-compression", SessionStoreCompressionNone, "compress sessions before they are persisted: none or gzip (redis and memcached session stores only)")
+	flagSet.Duration("session-max-lifetime", time.Duration(0), "the maximum time since login before a session is removed, even if it can still be refreshed (0 to disable)")
+	flagSet.Duration("session-idle-timeout", time.Duration(0), "how long a session may go unused before it is removed, even if its tokens are still valid (0 to disable). Every request updates the stored session")
+	flagSet.Bool("validate-each-request", false, "check with the provider on every request that the access token of the session is still active, eg that it was not revoked, by calling the userinfo endpoint. The session is removed when the provider rejects the token")
+	flagSet.Duration("validate-each-request-cache-ttl", 10*time.Second, "how long a successful --validate-each-request check is reused for requests with the same access token (0 to check every request)")
 	flagSet.Bool("session-cookie-minimal", false, "strip OAuth tokens from cookie session stores if they aren't needed (cookie session store only)")
+	flagSet.Bool("session-cookie-readable-claims", false, "store the user, email and groups unencrypted in the session cookie, only encrypting the OAuth tokens (cookie session store only)")
+	flagSet.Int("session-cookie-chunk-size", DefaultSessionCookieChunkSize, "the maximum length of each cookie, including its name and attributes, when the session is split across several cookies (cookie session store only)")
+	flagSet.Int("session-cookie-max-chunks", 0, "the maximum number of cookies a session may be split across, sessions needing more fail to save (0 to disable, cookie session store only)")
 	flagSet.String("redis-connection-url", "", "URL of redis server for redis session storage (eg: redis://HOST[:PORT])")
 	flagSet.String("redis-password", "", "Redis password. Applicable for all Redis configurations. Will override any password set in `--redis-connection-url`")
 	flagSet.Bool("redis-use-sentinel", false, "Connect to redis via sentinels. Must set --redis-sentinel-master-name and --redis-sentinel-connection-urls to use this feature")
@@ -149,7 +274,10 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.Bool("redis-use-cluster", false, "Connect to redis cluster. Must set --redis-cluster-connection-urls to use this feature")
 	flagSet.StringSlice("redis-cluster-connection-urls", []string{}, "List of Redis cluster connection URLs (eg redis://HOST[:PORT]). Used in conjunction with --redis-use-cluster")
 	flagSet.Int("redis-connection-idle-timeout", 0, "Redis connection idle timeout seconds, if Redis timeout option is non-zero, the --redis-connection-idle-timeout must be less then Redis timeout option")
+	flagSet.String("redis-key-prefix", "", "prefix applied to every session and lock key in redis, to isolate deployments sharing a redis")
+	flagSet.StringSlice("memcached-server", []string{}, "address of a memcached server for memcached session storage (eg: HOST:PORT). May be given multiple times to shard sessions across servers")
 	flagSet.String("signature-key", "", "GAP-Signature request signature key (algorithm:secretkey)")
+	flagSet.Int64("callback-max-body-size", 1<<20, "the maximum size in bytes of the OAuth callback request body sent by providers using response_mode=form_post")
 	flagSet.Bool("gcp-healthchecks", false, "Enable GCP/GKE healthcheck endpoints")
 
 	flagSet.AddFlagSet(cookieFlagSet())
@@ -1,11 +1,16 @@
 package options
 
+import "time"
+
 const (
 	// OIDCEmailClaim is the generic email claim used by the OIDC provider.
 	OIDCEmailClaim = "email"
 
 	// OIDCGroupsClaim is the generic groups claim used by the OIDC provider.
 	OIDCGroupsClaim = "groups"
+
+	// DefaultOIDCJWTLeeway is the default value for the OIDCOptions JWTLeeway.
+	DefaultOIDCJWTLeeway = 10 * time.Second
 )
 
 // OIDCAudienceClaims is the generic audience claim list used by the OIDC provider.
@@ -25,6 +30,11 @@ type Provider struct {
 	// ClientSecretFile is the name of the file
 	// containing the OAuth Client Secret, it will be used if ClientSecret is not set.
 	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+	// ClientSecretFilePollInterval is how often the ClientSecretFile is
+	// polled for a rotated secret, in addition to watching the file for
+	// changes. This is needed for filesystems that do not support watching.
+	// Defaults to 0, the file is not polled.
+	ClientSecretFilePollInterval Duration `json:"clientSecretFilePollInterval,omitempty"`
 
 	// KeycloakConfig holds all configurations for Keycloak provider.
 	KeycloakConfig KeycloakOptions `json:"keycloakConfig,omitempty"`
@@ -57,8 +67,12 @@ type Provider struct {
 	// if set, it will be shown to the users in the login page.
 	Name string `json:"name,omitempty"`
 	// CAFiles is a list of paths to CA certificates that should be used when connecting to the provider.
+	// The certificates are added to the system trust store unless CAFilesOnly is set.
 	// If not specified, the default Go trust sources are used instead
 	CAFiles []string `json:"caFiles,omitempty"`
+	// CAFilesOnly only trusts the CAFiles when connecting to the provider,
+	// rather than adding them to the system trust store
+	CAFilesOnly bool `json:"caFilesOnly,omitempty"`
 
 	// LoginURL is the authentication endpoint
 	LoginURL string `json:"loginURL,omitempty"`
@@ -66,6 +80,25 @@ type Provider struct {
 	LoginURLParameters []LoginURLParameter `json:"loginURLParameters,omitempty"`
 	// RedeemURL is the token redemption endpoint
 	RedeemURL string `json:"redeemURL,omitempty"`
+	// RedeemRetries is the number of times a call to the token redemption
+	// endpoint to refresh tokens is retried after a network error or a 502,
+	// 503 or 504 response. Calls to redeem single use codes are only retried
+	// when no connection could be made to the endpoint.
+	// Defaults to 0, calls are not retried.
+	RedeemRetries int `json:"redeemRetries,omitempty"`
+	// RedeemRetryDelay is the delay before the first retry of a call to the
+	// token redemption endpoint. The delay is doubled for each subsequent
+	// retry and randomised to spread out retries.
+	// Defaults to 100ms.
+	RedeemRetryDelay Duration `json:"redeemRetryDelay,omitempty"`
+	// DeviceAuthorizationURL is the RFC 8628 device authorization endpoint.
+	// When set, the device authorization endpoints are enabled so that
+	// headless clients can log in. Discovered when using OIDC discovery.
+	DeviceAuthorizationURL string `json:"deviceAuthorizationURL,omitempty"`
+	// RevocationURL is the RFC 7009 token revocation endpoint.
+	// When set, the session tokens are revoked when the user signs out.
+	// Discovered when using OIDC discovery.
+	RevocationURL string `json:"revocationURL,omitempty"`
 	// ProfileURL is the profile access endpoint
 	ProfileURL string `json:"profileURL,omitempty"`
 	// ProtectedResource is the resource that is protected (Azure AD and ADFS only)
@@ -76,8 +109,36 @@ type Provider struct {
 	Scope string `json:"scope,omitempty"`
 	// AllowedGroups is a list of restrict logins to members of this group
 	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// AllowedGroupsFile is the path to a file listing further groups to
+	// restrict logins to, either one group per line or as a JSON array.
+	// The file is reloaded when it changes, without restarting the proxy.
+	// When set, logins are restricted to the listed groups even if the file
+	// is empty.
+	AllowedGroupsFile string `json:"allowedGroupsFile,omitempty"`
+	// AuthorizationRules is an ordered list of rules that allow or deny access
+	// based on the session claims. The first matching rule wins and sessions
+	// that match no rule are denied. These apply in addition to AllowedGroups.
+	AuthorizationRules []AuthorizationRule `json:"authorizationRules,omitempty"`
+	// GroupsTransforms are applied in order to the groups of sessions before
+	// they are used for authorization, including AllowedGroups, and passed
+	// to the upstreams.
+	GroupsTransforms []GroupsTransform `json:"groupsTransforms,omitempty"`
+	// GroupsAPI fetches further groups of the users from an API endpoint.
+	// Optional, the groups are only taken from the tokens and profile by
+	// default.
+	GroupsAPI *GroupsAPI `json:"groupsAPI,omitempty"`
 	// The code challenge method
 	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	// RequestObject enables sending the authorization request parameters as
+	// a signed JWT request object (RFC 9101) in the `request` parameter of
+	// the login URL, rather than as query parameters.
+	// Optional, disabled by default.
+	RequestObject *RequestObjectOptions `json:"requestObject,omitempty"`
+	// ClientAssertion authenticates the client at the token endpoint with a
+	// signed JWT client assertion (private_key_jwt) instead of the client
+	// secret. The assertion is used for the code redemption and refreshes.
+	// Optional, the client secret is used by default.
+	ClientAssertion *ClientAssertionOptions `json:"clientAssertion,omitempty"`
 }
 
 // ProviderType is used to enumerate the different provider type options
@@ -136,6 +197,13 @@ type KeycloakOptions struct {
 
 	// Role enables to restrict login to users with role (only available when using the keycloak-oidc provider)
 	Roles []string `json:"roles,omitempty"`
+
+	// RequiredRoles restricts login to users with all of these roles, in
+	// addition to any groups or roles they are allowed by.
+	// Realm roles are given by name and client roles from the resource_access
+	// claim as `client:role`, e.g. `myclient:editor`
+	// (only available when using the keycloak-oidc provider)
+	RequiredRoles []string `json:"requiredRoles,omitempty"`
 }
 
 type AzureOptions struct {
@@ -145,6 +213,10 @@ type AzureOptions struct {
 	// GraphGroupField configures the group field to be used when building the groups list from Microsoft Graph
 	// Default value is 'id'
 	GraphGroupField string `json:"graphGroupField,omitempty"`
+	// GraphURL is the base URL of Microsoft Graph, used to query the groups
+	// and profile of the user. Set this for national clouds
+	// Default value is 'https://graph.microsoft.com'
+	GraphURL string `json:"graphURL,omitempty"`
 }
 
 type ADFSOptions struct {
@@ -158,13 +230,26 @@ type BitbucketOptions struct {
 	Team string `json:"team,omitempty"`
 	// Repository sets restrict logins to user with access to this repository
 	Repository string `json:"repository,omitempty"`
+	// RepositoryPermissions restricts logins to users with at least the given
+	// permission on each of the repositories, given as
+	// `workspace/repository:permission` where the permission is one of read,
+	// write or admin.
+	// The permissions are added to the groups of the session.
+	RepositoryPermissions []string `json:"repositoryPermissions,omitempty"`
 }
 
 type GitHubOptions struct {
 	// Org sets restrict logins to members of this organisation
 	Org string `json:"org,omitempty"`
-	// Team sets restrict logins to members of this team
+	// Team sets restrict logins to members of this team.
+	// Multiple teams may be given separated by commas. Teams are given as
+	// `slug` for a team within the Org or `org:slug` for a team in any
+	// organization.
 	Team string `json:"team,omitempty"`
+	// RequireAllOrgsAndTeams requires users to be a member of the Org and
+	// every team, instead of any one of them.
+	// Defaults to false.
+	RequireAllOrgsAndTeams bool `json:"requireAllOrgsAndTeams,omitempty"`
 	// Repo sets restrict logins to collaborators of this repository
 	Repo string `json:"repo,omitempty"`
 	// Token is the token to use when verifying repository collaborators
@@ -178,7 +263,8 @@ type GitHubOptions struct {
 type GitLabOptions struct {
 	// Group sets restrict logins to members of this group
 	Group []string `json:"group,omitempty"`
-	// Projects restricts logins to members of any of these projects
+	// Projects restricts logins to members of any of these projects.
+	// Projects may be suffixed with the minimum access level, eg `group/project:30`
 	Projects []string `json:"projects,omitempty"`
 }
 
@@ -210,24 +296,60 @@ type OIDCOptions struct {
 	// SkipDiscovery allows to skip OIDC discovery and use manually supplied Endpoints
 	// default set to 'false'
 	SkipDiscovery bool `json:"skipDiscovery,omitempty"`
+	// DiscoveryTimeout is the total time the OIDC discovery at startup is
+	// retried for while the issuer cannot be reached, so that the proxy waits
+	// for the identity provider to come up instead of failing to start
+	// default set to '0', the discovery is not retried
+	DiscoveryTimeout Duration `json:"discoveryTimeout,omitempty"`
+	// DiscoveryRetryBackoff is the delay before the first retry of the OIDC
+	// discovery, it is doubled for each further retry up to 30s
+	// default set to '1s'
+	DiscoveryRetryBackoff Duration `json:"discoveryRetryBackoff,omitempty"`
+	// JWTLeeway is the clock skew with the issuer that is tolerated when
+	// checking the exp, iat and nbf claims of ID tokens, so that tokens are
+	// not rejected when the clocks of the proxy and the provider differ by
+	// a few seconds
+	// default set to '10s'
+	JWTLeeway *Duration `json:"jwtLeeway,omitempty"`
+	// SkipEndSessionOnLogout disables redirecting the user to the discovered
+	// end_session_endpoint when they sign out, so that they stay logged in at
+	// the provider
+	// default set to 'false'
+	SkipEndSessionOnLogout bool `json:"skipEndSessionOnLogout,omitempty"`
 	// JwksURL is the OpenID Connect JWKS URL
 	// eg: https://www.googleapis.com/oauth2/v3/certs
 	JwksURL string `json:"jwksURL,omitempty"`
-	// EmailClaim indicates which claim contains the user email,
+	// EmailClaim indicates which claim contains the user email.
+	// Nested claims can be given as a dotted path, eg
+	// 'https://myapp.example.com/claims.email', or a JSON pointer, eg
+	// '/https:~1~1myapp.example.com~1claims/email'
 	// default set to 'email'
 	EmailClaim string `json:"emailClaim,omitempty"`
-	// GroupsClaim indicates which claim contains the user groups
+	// GroupsClaim indicates which claim contains the user groups,
+	// as a claim name, dotted path or JSON pointer like the EmailClaim.
+	// Paths into arrays of objects collect the claim from each object.
 	// default set to 'groups'
 	GroupsClaim string `json:"groupsClaim,omitempty"`
 	// UserIDClaim indicates which claim contains the user ID
 	// default set to 'email'
 	UserIDClaim string `json:"userIDClaim,omitempty"`
+	// UsernameClaims is the list of claims used for the user of the session,
+	// which is passed as the X-Forwarded-User and X-Auth-Request-User headers.
+	// The first claim with a non-empty value is used, eg preferred_username
+	// then email then sub. Claims must be strings, and may be nested like the
+	// EmailClaim.
+	// default set to 'sub'
+	UsernameClaims []string `json:"usernameClaims,omitempty"`
 	// AudienceClaim allows to define any claim that is verified against the client id
 	// By default `aud` claim is used for verification.
 	AudienceClaims []string `json:"audienceClaims,omitempty"`
 	// ExtraAudiences is a list of additional audiences that are allowed
 	// to pass verification in addition to the client id.
 	ExtraAudiences []string `json:"extraAudiences,omitempty"`
+	// ExtraIssuerURLs is a list of additional OpenID Connect issuer URLs whose
+	// ID tokens are accepted, eg while migrating between issuers.
+	// Tokens are verified with the keys discovered from their own issuer.
+	ExtraIssuerURLs []string `json:"extraIssuerURLs,omitempty"`
 }
 
 type LoginGovOptions struct {
@@ -239,6 +361,47 @@ type LoginGovOptions struct {
 	PubJWKURL string `json:"pubjwkURL,omitempty"`
 }
 
+const (
+	// RequestObjectSigningRS256 signs request objects with RSASSA-PKCS1-v1_5 using SHA-256
+	RequestObjectSigningRS256 = "RS256"
+
+	// RequestObjectSigningES256 signs request objects with ECDSA using P-256 and SHA-256
+	RequestObjectSigningES256 = "ES256"
+)
+
+type RequestObjectOptions struct {
+	// SigningAlgorithm is the JWS algorithm used to sign the request object,
+	// one of RS256 or ES256.
+	// Default value is 'RS256'
+	SigningAlgorithm string `json:"signingAlgorithm,omitempty"`
+	// SigningKey is a private key in PEM format used to sign the request object
+	SigningKey string `json:"signingKey,omitempty"`
+	// SigningKeyFile is a path to the private key file in PEM format used to
+	// sign the request object
+	SigningKeyFile string `json:"signingKeyFile,omitempty"`
+	// KeyID is set as the `kid` header of the request object so that the
+	// provider can select the public key to verify it with. Optional.
+	KeyID string `json:"keyID,omitempty"`
+	// Audience is the `aud` claim of the request object.
+	// Defaults to the OIDC issuer URL, or the login URL when there is no issuer.
+	Audience string `json:"audience,omitempty"`
+}
+
+type ClientAssertionOptions struct {
+	// SigningAlgorithm is the JWS algorithm used to sign the client
+	// assertion, one of RS256 or ES256.
+	// Default value is 'RS256'
+	SigningAlgorithm string `json:"signingAlgorithm,omitempty"`
+	// SigningKey is a private key in PEM format used to sign the client assertion
+	SigningKey string `json:"signingKey,omitempty"`
+	// SigningKeyFile is a path to the private key file in PEM format used to
+	// sign the client assertion
+	SigningKeyFile string `json:"signingKeyFile,omitempty"`
+	// KeyID is set as the `kid` header of the client assertion so that the
+	// provider can select the public key to verify it with. Optional.
+	KeyID string `json:"keyID,omitempty"`
+}
+
 func providerDefaults() Providers {
 	providers := Providers{
 		{
@@ -3,9 +3,15 @@ package options
 // Server represents the configuration for an HTTP(S) server
 type Server struct {
 	// BindAddress is the address on which to serve traffic.
+	// Use unix://<path> to serve traffic on a Unix domain socket.
 	// Leave blank or set to "-" to disable.
 	BindAddress string
 
+	// SocketFileMode is the octal file mode, eg 0660, of the Unix domain
+	// socket the BindAddress listens on.
+	// When empty, the mode follows the umask of the process.
+	SocketFileMode string
+
 	// SecureBindAddress is the address on which to serve secure traffic.
 	// Leave blank or set to "-" to disable.
 	SecureBindAddress string
@@ -13,6 +19,19 @@ type Server struct {
 	// TLS contains the information for loading the certificate and key for the
 	// secure traffic and further configuration for the TLS server.
 	TLS *TLS
+
+	// ShutdownTimeout is how long in-flight requests may take to finish when
+	// the server shuts down. The server stops accepting new connections and
+	// closes the connections that are still in use after the timeout.
+	// Defaults to 0, the server waits for all in-flight requests.
+	ShutdownTimeout Duration
+
+	// StreamShutdownTimeout is how long WebSocket connections are kept open
+	// when the server shuts down, so that they can be shorter lived than the
+	// ShutdownTimeout of the in-flight requests.
+	// Defaults to 0, the WebSocket connections are closed once the in-flight
+	// requests have finished.
+	StreamShutdownTimeout Duration
 }
 
 // TLS contains the information for loading a TLS certificate and key
@@ -1,10 +1,22 @@
 package options
 
+import "time"
+
 // SessionOptions contains configuration options for the SessionStore providers.
 type SessionOptions struct {
-	Type   string             `flag:"session-store-type" cfg:"session_store_type"`
-	Cookie CookieStoreOptions `cfg:",squash"`
-	Redis  RedisStoreOptions  `cfg:",squash"`
+	Type                        string                `flag:"session-store-type" cfg:"session_store_type"`
+	Compression                 string                `flag:"session-store-compression" cfg:"session_store_compression"`
+	MaxLifetime                 time.Duration         `flag:"session-max-lifetime" cfg:"session_max_lifetime"`
+	IdleTimeout                 time.Duration         `flag:"session-idle-timeout" cfg:"session_idle_timeout"`
+	ValidateEachRequest         bool                  `flag:"validate-each-request" cfg:"validate_each_request"`
+	ValidateEachRequestCacheTTL time.Duration         `flag:"validate-each-request-cache-ttl" cfg:"validate_each_request_cache_ttl"`
+	Cookie                      CookieStoreOptions    `cfg:",squash"`
+	Redis                       RedisStoreOptions     `cfg:",squash"`
+	Memcached                   MemcachedStoreOptions `cfg:",squash"`
+
+	// IndexUserSessions is set when the sessions of the users must be indexed
+	// so that they can be cleared through the admin API.
+	IndexUserSessions bool `cfg:",internal"`
 }
 
 // CookieSessionStoreType is used to indicate the CookieSessionStore should be
@@ -15,11 +27,31 @@ var CookieSessionStoreType = "cookie"
 // used for storing sessions.
 var RedisSessionStoreType = "redis"
 
+// MemcachedSessionStoreType is used to indicate the MemcachedSessionStore
+// should be used for storing sessions.
+var MemcachedSessionStoreType = "memcached"
+
+// SessionStoreCompressionNone is used to indicate sessions should not be
+// compressed before they are persisted.
+var SessionStoreCompressionNone = "none"
+
+// SessionStoreCompressionGzip is used to indicate sessions should be gzip
+// compressed before they are persisted.
+var SessionStoreCompressionGzip = "gzip"
+
 // CookieStoreOptions contains configuration options for the CookieSessionStore.
 type CookieStoreOptions struct {
-	Minimal bool `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
+	Minimal        bool `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
+	ReadableClaims bool `flag:"session-cookie-readable-claims" cfg:"session_cookie_readable_claims"`
+	ChunkSize      int  `flag:"session-cookie-chunk-size" cfg:"session_cookie_chunk_size"`
+	MaxChunks      int  `flag:"session-cookie-max-chunks" cfg:"session_cookie_max_chunks"`
 }
 
+// DefaultSessionCookieChunkSize is the default maximum length of each session
+// cookie, including its name and attributes.
+// Most browsers' max is 4096 -- but we give ourselves some leeway
+const DefaultSessionCookieChunkSize = 4000
+
 // RedisStoreOptions contains configuration options for the RedisSessionStore.
 type RedisStoreOptions struct {
 	ConnectionURL          string   `flag:"redis-connection-url" cfg:"redis_connection_url"`
@@ -33,13 +65,24 @@ type RedisStoreOptions struct {
 	CAPath                 string   `flag:"redis-ca-path" cfg:"redis_ca_path"`
 	InsecureSkipTLSVerify  bool     `flag:"redis-insecure-skip-tls-verify" cfg:"redis_insecure_skip_tls_verify"`
 	IdleTimeout            int      `flag:"redis-connection-idle-timeout" cfg:"redis_connection_idle_timeout"`
+	KeyPrefix              string   `flag:"redis-key-prefix" cfg:"redis_key_prefix"`
+}
+
+// MemcachedStoreOptions contains configuration options for the MemcachedSessionStore.
+type MemcachedStoreOptions struct {
+	Servers []string `flag:"memcached-server" cfg:"memcached_servers"`
 }
 
 func sessionOptionsDefaults() SessionOptions {
 	return SessionOptions{
-		Type: CookieSessionStoreType,
+		Type:                        CookieSessionStoreType,
+		Compression:                 SessionStoreCompressionNone,
+		ValidateEachRequestCacheTTL: 10 * time.Second,
 		Cookie: CookieStoreOptions{
-			Minimal: false,
+			Minimal:        false,
+			ReadableClaims: false,
+			ChunkSize:      DefaultSessionCookieChunkSize,
+			MaxChunks:      0,
 		},
 	}
 }
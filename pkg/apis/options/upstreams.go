@@ -8,6 +8,27 @@ const (
 
 	// DefaultUpstreamTimeout is the maximum duration a network dial to a upstream server for a response.
 	DefaultUpstreamTimeout = 30 * time.Second
+
+	// DefaultUpstreamRetryBackoff is the default value for the Upstream RetryBackoff.
+	DefaultUpstreamRetryBackoff = 100 * time.Millisecond
+
+	// DefaultUpstreamMaxIdleConns is the default value for the UpstreamTransport MaxIdleConns.
+	DefaultUpstreamMaxIdleConns = 100
+
+	// DefaultUpstreamMaxIdleConnsPerHost is the default value for the UpstreamTransport MaxIdleConnsPerHost.
+	DefaultUpstreamMaxIdleConnsPerHost = 2
+
+	// DefaultUpstreamIdleConnTimeout is the default value for the UpstreamTransport IdleConnTimeout.
+	DefaultUpstreamIdleConnTimeout = 90 * time.Second
+
+	// DefaultUpstreamMaxResponseHeaderBytes is the default value for the UpstreamTransport MaxResponseHeaderBytes.
+	DefaultUpstreamMaxResponseHeaderBytes = 10 << 20
+
+	// DefaultUpstreamMaxFailures is the default value for the UpstreamLoadBalancing MaxFailures.
+	DefaultUpstreamMaxFailures = 3
+
+	// DefaultUpstreamFailureCooldown is the default value for the UpstreamLoadBalancing FailureCooldown.
+	DefaultUpstreamFailureCooldown = 30 * time.Second
 )
 
 // UpstreamConfig is a collection of definitions for upstream servers.
@@ -19,6 +40,42 @@ type UpstreamConfig struct {
 	// Upstreams represents the configuration for the upstream servers.
 	// Requests will be proxied to this upstream if the path matches the request path.
 	Upstreams []Upstream `json:"upstreams,omitempty"`
+
+	// Transport configures the connection pooling of the HTTP(S) upstreams.
+	// Each upstream has its own transport, so that connections are only reused
+	// with the same TLS configuration, and these limits apply to each of them.
+	Transport UpstreamTransport `json:"transport,omitempty"`
+}
+
+// UpstreamTransport configures the connection pool of the transport used to
+// proxy requests to an upstream server.
+type UpstreamTransport struct {
+	// MaxIdleConns is the maximum number of idle connections kept open.
+	// Zero means no limit.
+	// Defaults to 100.
+	MaxIdleConns *int `json:"maxIdleConns,omitempty"`
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept open
+	// to each upstream host.
+	// Defaults to 2.
+	MaxIdleConnsPerHost *int `json:"maxIdleConnsPerHost,omitempty"`
+
+	// MaxConnsPerHost limits the number of connections to each upstream host,
+	// including connections in use. Requests wait for a connection once the
+	// limit is reached.
+	// Defaults to 0, no limit.
+	MaxConnsPerHost *int `json:"maxConnsPerHost,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection is kept open before it is
+	// closed.
+	// Defaults to 90 seconds.
+	IdleConnTimeout *Duration `json:"idleConnTimeout,omitempty"`
+
+	// MaxResponseHeaderBytes limits the total size of the response headers of
+	// the upstreams. Responses with larger headers, eg a huge Set-Cookie, are
+	// not sent to the client, it receives a 502 error page instead.
+	// Defaults to 10MiB.
+	MaxResponseHeaderBytes *int64 `json:"maxResponseHeaderBytes,omitempty"`
 }
 
 // Upstream represents the configuration for an upstream server.
@@ -44,8 +101,22 @@ type Upstream struct {
 	// Eg: With a Path of `^/baz/(.*)`, a RewriteTarget of `/foo/$1` would rewrite
 	// the request `/baz/abc/123` to `/foo/abc/123` before proxying to the
 	// upstream server.
+	// The original request URI is passed to the upstream server in the
+	// X-Forwarded-Uri header.
 	RewriteTarget string `json:"rewriteTarget,omitempty"`
 
+	// StripPrefix removes the Path from the start of the request path before
+	// it is sent to the upstream server.
+	// Eg: With a Path of `/api/`, the request `/api/users` would be proxied to
+	// the upstream server as `/users`.
+	// Location headers returned by the upstream server that point at the proxy
+	// have the Path added back so that redirects still work for the client.
+	// The original request URI is passed to the upstream server in the
+	// X-Forwarded-Uri header.
+	// This option cannot be combined with RewriteTarget and is only supported
+	// for HTTP(S) upstreams.
+	StripPrefix bool `json:"stripPrefix,omitempty"`
+
 	// The URI of the upstream server. This may be an HTTP(S) server of a File
 	// based URL. It may include a path, in which case all requests will be served
 	// under that path.
@@ -58,15 +129,43 @@ type Upstream struct {
 	// the upstream request will be for "/base/dir".
 	URI string `json:"uri,omitempty"`
 
+	// URIs are the HTTP(S) servers that requests to this upstream are
+	// balanced across, eg the replicas of a service that are not behind a
+	// load balancer, instead of the single URI.
+	// All URIs must have the same scheme and only their hosts are used, the
+	// request path is sent to each of them unchanged.
+	// Retried requests, see Retries, are sent to the URI chosen next.
+	// This option cannot be combined with URI.
+	URIs []UpstreamURI `json:"uris,omitempty"`
+
+	// LoadBalancing configures how requests are balanced across the URIs and
+	// when failing URIs are skipped.
+	// This option can only be used with URIs.
+	LoadBalancing *UpstreamLoadBalancing `json:"loadBalancing,omitempty"`
+
 	// InsecureSkipTLSVerify will skip TLS verification of upstream HTTPS hosts.
 	// This option is insecure and will allow potential Man-In-The-Middle attacks
 	// betweem OAuth2 Proxy and the usptream server.
 	// Defaults to false.
 	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
 
+	// TLSClientCertFile is the path to a PEM encoded client certificate that
+	// will be presented to HTTPS upstream servers that require mutual TLS.
+	// TLSClientKeyFile must also be set when this option is used.
+	TLSClientCertFile string `json:"tlsClientCertFile,omitempty"`
+
+	// TLSClientKeyFile is the path to the PEM encoded private key for the
+	// TLSClientCertFile.
+	TLSClientKeyFile string `json:"tlsClientKeyFile,omitempty"`
+
+	// TLSCAFile is the path to a PEM encoded CA certificate bundle used to
+	// verify the upstream server certificate.
+	// When not set, the system root CAs are used.
+	TLSCAFile string `json:"tlsCAFile,omitempty"`
+
 	// Static will make all requests to this upstream have a static response.
 	// The response will have a body of "Authenticated" and a response code
-	// matching StaticCode.
+	// matching StaticCode, unless StaticBody or StaticBodyFile are set.
 	// If StaticCode is not set, the response will return a 200 response.
 	Static bool `json:"static,omitempty"`
 
@@ -74,6 +173,29 @@ type Upstream struct {
 	// This option can only be used with Static enabled.
 	StaticCode *int `json:"staticCode,omitempty"`
 
+	// StaticBody is a Go template rendered as the body of the Static response.
+	// The template can reference the authenticated user with `{{.User}}`,
+	// `{{.Email}}`, `{{.PreferredUsername}}` and `{{.Groups}}`, and the
+	// request path with `{{.Path}}`.
+	// When the path skips authentication the user fields are empty.
+	// This option can only be used with Static enabled and cannot be combined
+	// with StaticBodyFile.
+	StaticBody string `json:"staticBody,omitempty"`
+
+	// StaticBodyFile is the path to a file containing a Go template rendered
+	// as the body of the Static response, as described for StaticBody.
+	// The file is read and the template is checked when the proxy starts.
+	// This option can only be used with Static enabled and cannot be combined
+	// with StaticBody.
+	StaticBodyFile string `json:"staticBodyFile,omitempty"`
+
+	// StaticContentType is the Content-Type of the Static response.
+	// When the content type is `text/html` the template is rendered with HTML
+	// escaping of the user values.
+	// This option can only be used with StaticBody or StaticBodyFile.
+	// Defaults to "text/plain; charset=utf-8".
+	StaticContentType string `json:"staticContentType,omitempty"`
+
 	// FlushInterval is the period between flushing the response buffer when
 	// streaming response from the upstream.
 	// Defaults to 1 second.
@@ -88,7 +210,200 @@ type Upstream struct {
 	// Defaults to true.
 	ProxyWebSockets *bool `json:"proxyWebSockets,omitempty"`
 
+	// HTTP2 proxies requests to the upstream server over HTTP/2, as required
+	// by gRPC services.
+	// Upstreams with an http URI are sent cleartext HTTP/2 (h2c) with prior
+	// knowledge, and upstreams with an https URI must negotiate HTTP/2 during
+	// the TLS handshake.
+	// When any upstream enables HTTP/2, the proxy also accepts HTTP/2 requests
+	// from clients, over TLS and cleartext (h2c), so that streaming requests
+	// are not downgraded to HTTP/1.1.
+	// The transport connection pool options do not apply to HTTP/2 upstreams.
+	// This option is only supported for HTTP(S) upstreams.
+	// Defaults to false.
+	HTTP2 bool `json:"http2,omitempty"`
+
 	// Timeout is the maximum duration the server will wait for a response from the upstream server.
+	// Requests exceeding the timeout are answered with a 504 Gateway Timeout error page.
+	// WebSocket connections are not subject to the timeout.
 	// Defaults to 30 seconds.
 	Timeout *Duration `json:"timeout,omitempty"`
+
+	// Retries is the number of times GET and HEAD requests without a body are
+	// sent to the upstream server again when the connection to it fails, eg
+	// because it was reset or refused.
+	// Failed TLS handshakes are not retried, nor are requests once the upstream has responded, or after the
+	// upstream timeout.
+	// This option is only supported for HTTP(S) upstreams.
+	// Defaults to 0, requests are not retried.
+	Retries int `json:"retries,omitempty"`
+
+	// RetryBackoff is the delay before the first retry, each further retry
+	// waits twice as long as the previous one.
+	// This option can only be used with Retries.
+	// Defaults to 100 milliseconds.
+	RetryBackoff *Duration `json:"retryBackoff,omitempty"`
+
+	// TokenExchange enables an RFC 8693 token exchange of the user's access
+	// token, or a request for a token with the upstream's resource and
+	// scopes, before the request is proxied to the upstream server.
+	// The exchanged token is passed to the upstream as a Bearer token in the
+	// Authorization header and is cached in the session until it expires.
+	// This option is only supported for HTTP(S) upstreams.
+	TokenExchange *TokenExchange `json:"tokenExchange,omitempty"`
+
+	// ACRValues are the authentication context class references accepted for
+	// requests to this upstream.
+	// When the acr claim of the session's ID token is not one of these values,
+	// the user is sent to re-authenticate with the provider, requesting these
+	// acr_values in order of preference.
+	// List every value that is strong enough, not only the preferred one.
+	ACRValues []string `json:"acrValues,omitempty"`
+
+	// MaxAge is the maximum time since the user last authenticated with the
+	// provider for requests to this upstream.
+	// Older sessions are sent to re-authenticate with the provider, requesting
+	// this max_age.
+	// The auth_time claim of the ID token is used when it is present,
+	// otherwise the time the session was created.
+	MaxAge *Duration `json:"maxAge,omitempty"`
+
+	// AllowedMethods are the request methods that are proxied to this upstream.
+	// Requests with any other method are answered with a 405 Method Not
+	// Allowed response, with the allowed methods in the Allow header.
+	// HEAD requests are allowed when GET is allowed. CORS preflight OPTIONS
+	// requests are allowed when the method they request is allowed, other
+	// OPTIONS requests only when OPTIONS is allowed.
+	// Defaults to allowing all methods.
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+
+	// AllowedWebSocketOrigins are the origins, eg `https://app.example.com`,
+	// that WebSocket connections to this upstream may be opened from.
+	// WebSocket upgrade requests from any other origin, or without an Origin
+	// header, are answered with a 403 Forbidden response before the upgrade.
+	// Other requests are not affected.
+	// Defaults to allowing all origins.
+	AllowedWebSocketOrigins []string `json:"allowedWebSocketOrigins,omitempty"`
+
+	// StatusActions change how responses from the upstream with the given
+	// status codes are handled, eg to send users to login again when the
+	// upstream rejects their session with a 401.
+	// Responses with other status codes are passed through to the client.
+	// This option is only supported for HTTP(S) upstreams.
+	StatusActions []UpstreamStatusAction `json:"statusActions,omitempty"`
+}
+
+// UpstreamURI is one of the servers that requests to an upstream are
+// balanced across.
+type UpstreamURI struct {
+	// URI of the HTTP(S) server, eg http://10.0.0.1:8080
+	URI string `json:"uri,omitempty"`
+
+	// Weight is the share of the requests sent to this URI relative to the
+	// weights of the other URIs, eg a URI with a weight of 2 receives twice
+	// as many requests as a URI with a weight of 1.
+	// Defaults to 1.
+	Weight int `json:"weight,omitempty"`
+}
+
+const (
+	// UpstreamLoadBalancingRoundRobin sends requests to the URIs in turn,
+	// each as often as its weight.
+	UpstreamLoadBalancingRoundRobin = "roundRobin"
+
+	// UpstreamLoadBalancingRandom sends each request to a random URI, chosen
+	// with a probability proportional to its weight.
+	UpstreamLoadBalancingRandom = "random"
+)
+
+// UpstreamLoadBalancing configures how requests are balanced across the URIs
+// of an upstream.
+type UpstreamLoadBalancing struct {
+	// Strategy selects the URI of each request, either "roundRobin" or
+	// "random".
+	// Defaults to "roundRobin".
+	Strategy string `json:"strategy,omitempty"`
+
+	// MaxFailures is the number of consecutive requests to a URI that fail
+	// to connect or to receive a response after which the URI is skipped for the
+	// FailureCooldown. Requests that receive a response, whatever its status,
+	// reset the count.
+	// When all URIs are skipped, requests are balanced across all of them.
+	// Zero disables skipping failing URIs.
+	// Defaults to 3.
+	MaxFailures *int `json:"maxFailures,omitempty"`
+
+	// FailureCooldown is how long a failing URI is skipped before requests
+	// are sent to it again.
+	// Defaults to 30 seconds.
+	FailureCooldown *Duration `json:"failureCooldown,omitempty"`
+}
+
+const (
+	// UpstreamStatusActionPassThrough passes the upstream response through
+	// to the client unchanged.
+	UpstreamStatusActionPassThrough = "passThrough"
+
+	// UpstreamStatusActionReauthenticate clears the session and sends the
+	// user to login again. API and AJAX requests receive a 401 response.
+	// Sessions that were created or refreshed within the last minute receive
+	// an error page with the upstream status instead, so that an upstream
+	// that rejects every session cannot cause a redirect loop.
+	UpstreamStatusActionReauthenticate = "reauthenticate"
+
+	// UpstreamStatusActionErrorPage renders the proxy error page with the
+	// upstream status instead of the upstream response.
+	UpstreamStatusActionErrorPage = "errorPage"
+)
+
+// UpstreamStatusAction maps a status code of upstream responses to the action
+// the proxy takes for them.
+type UpstreamStatusAction struct {
+	// Status is the status code of the upstream response, eg 401.
+	Status int `json:"status,omitempty"`
+
+	// Action is one of "passThrough", "reauthenticate" or "errorPage".
+	Action string `json:"action,omitempty"`
+}
+
+const (
+	// TokenExchangeGrantTokenExchange exchanges the access token of the
+	// session with an RFC 8693 token exchange.
+	TokenExchangeGrantTokenExchange = "tokenExchange"
+
+	// TokenExchangeGrantRefreshToken requests a token with the refresh token
+	// of the session and the resource and scopes of the upstream.
+	TokenExchangeGrantRefreshToken = "refreshToken"
+)
+
+// TokenExchange configures how the token for an upstream is obtained.
+type TokenExchange struct {
+	// Audience is the logical name of the upstream service the exchanged
+	// token is intended for.
+	// Either the audience or the resource is required when token exchange
+	// is configured.
+	Audience string `json:"audience,omitempty"`
+
+	// Resource is the RFC 8707 resource indicator of the upstream, the
+	// absolute URI of the API the token is requested for.
+	Resource string `json:"resource,omitempty"`
+
+	// Scopes are requested for the upstream token, eg a subset of the scopes
+	// granted at login.
+	// If empty, the scopes of the token are left to the provider.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Grant is how the upstream token is requested, either "tokenExchange"
+	// for an RFC 8693 token exchange of the session access token, or
+	// "refreshToken" to use the session refresh token, for providers that
+	// accept resource indicators on refresh but not token exchange.
+	// Defaults to "tokenExchange".
+	Grant string `json:"grant,omitempty"`
+
+	// Strict determines whether a failed token exchange should fail the request.
+	// When false, the request is proxied without the exchanged token, or with
+	// the session access token when the provider does not support the
+	// requested grant or resource.
+	// Defaults to false.
+	Strict bool `json:"strict,omitempty"`
 }
@@ -15,6 +15,28 @@ type SessionStore interface {
 	VerifyConnection(ctx context.Context) error
 }
 
+// StateStore is implemented by session stores that can hold the state of an
+// authentication flow server-side, instead of in the CSRF cookie
+type StateStore interface {
+	// SaveState stores the state under the ID until it expires
+	SaveState(ctx context.Context, id string, value []byte, exp time.Duration) error
+	// LoadAndClearState loads the state and removes it from the store so that
+	// it can only be used once
+	LoadAndClearState(ctx context.Context, id string) ([]byte, error)
+}
+
+// UserSessionsStore is implemented by session stores that can clear all the
+// sessions of a user, eg to sign out a compromised account everywhere
+type UserSessionsStore interface {
+	// ClearUserSessions removes all sessions with the user or email and
+	// returns how many sessions the user had
+	ClearUserSessions(ctx context.Context, user string) (int, error)
+}
+
+// ErrUserSessionsNotSupported is returned by ClearUserSessions when the
+// backing store does not index the sessions of users
+var ErrUserSessionsNotSupported = errors.New("the session store does not support clearing the sessions of a user")
+
 var ErrLockNotObtained = errors.New("lock: not obtained")
 var ErrNotLocked = errors.New("tried to release not existing lock")
 
@@ -3,8 +3,11 @@ package sessions
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
@@ -18,20 +21,52 @@ type SessionState struct {
 	CreatedAt *time.Time `msgpack:"ca,omitempty"`
 	ExpiresOn *time.Time `msgpack:"eo,omitempty"`
 
+	// AuthenticatedAt is when the user logged in. Unlike CreatedAt it is
+	// not reset when the session is refreshed.
+	AuthenticatedAt *time.Time `msgpack:"aa,omitempty"`
+
+	// LastActivityAt is when the session was last used to authenticate a
+	// request. It is only recorded when an idle timeout is configured.
+	LastActivityAt *time.Time `msgpack:"la,omitempty"`
+
 	AccessToken  string `msgpack:"at,omitempty"`
 	IDToken      string `msgpack:"it,omitempty"`
 	RefreshToken string `msgpack:"rt,omitempty"`
 
 	Nonce []byte `msgpack:"n,omitempty"`
 
+	// DeviceGrant is set when the session was created by the device
+	// authorization grant, which has no nonce to check the ID token against
+	DeviceGrant bool `msgpack:"dg,omitempty"`
+
 	Email             string   `msgpack:"e,omitempty"`
 	User              string   `msgpack:"u,omitempty"`
 	Groups            []string `msgpack:"g,omitempty"`
 	PreferredUsername string   `msgpack:"pu,omitempty"`
 
+	// ExchangedTokens caches tokens obtained via token exchange, keyed by the
+	// audience they were requested for.
+	ExchangedTokens map[string]ExchangedToken `msgpack:"xt,omitempty"`
+
 	// Internal helpers, not serialized
 	Clock clock.Clock `msgpack:"-"`
 	Lock  Lock        `msgpack:"-"`
+
+	// SecretRotated is set when the session was loaded from a cookie signed
+	// with a secondary cookie secret. Saving the session signs and encrypts
+	// it with the current secret again.
+	SecretRotated bool `msgpack:"-"`
+}
+
+// ExchangedToken is a token obtained by exchanging the session's access
+// token for a token intended for a specific audience.
+type ExchangedToken struct {
+	AccessToken string     `msgpack:"at,omitempty"`
+	ExpiresOn   *time.Time `msgpack:"eo,omitempty"`
+
+	// Fallback is set when the provider does not support the token request,
+	// the access token of the session is used for the audience instead.
+	Fallback bool `msgpack:"f,omitempty"`
 }
 
 func (s *SessionState) ObtainLock(ctx context.Context, expiration time.Duration) error {
@@ -99,6 +134,69 @@ func (s *SessionState) Age() time.Duration {
 	return 0
 }
 
+// RecordAuthenticatedAt sets AuthenticatedAt to CreatedAt if it is unset.
+// This must be called before the session is first refreshed so that the
+// time of the login is kept.
+func (s *SessionState) RecordAuthenticatedAt() {
+	if s.AuthenticatedAt == nil && s.CreatedAt != nil {
+		authenticatedAt := *s.CreatedAt
+		s.AuthenticatedAt = &authenticatedAt
+	}
+}
+
+// AuthenticatedAge returns how long ago the user logged in.
+// Sessions that have not been refreshed use their CreatedAt time.
+func (s *SessionState) AuthenticatedAge() time.Duration {
+	if s.AuthenticatedAt != nil && !s.AuthenticatedAt.IsZero() {
+		return s.Clock.Now().Truncate(time.Second).Sub(*s.AuthenticatedAt)
+	}
+	return s.Age()
+}
+
+// RecordActivity sets LastActivityAt to now, truncated to the second.
+// It returns false if the activity was already recorded this second.
+func (s *SessionState) RecordActivity() bool {
+	now := s.Clock.Now().Truncate(time.Second)
+	if s.LastActivityAt != nil && s.LastActivityAt.Equal(now) {
+		return false
+	}
+	s.LastActivityAt = &now
+	return true
+}
+
+// IdleTime returns how long ago the session was last used.
+// Sessions without any recorded activity use their CreatedAt time.
+func (s *SessionState) IdleTime() time.Duration {
+	if s.LastActivityAt != nil && !s.LastActivityAt.IsZero() {
+		return s.Clock.Now().Truncate(time.Second).Sub(*s.LastActivityAt)
+	}
+	return s.Age()
+}
+
+// GetExchangedToken returns the cached exchanged token for the audience if it
+// exists and has not expired.
+func (s *SessionState) GetExchangedToken(audience string) (string, bool) {
+	token, ok := s.ExchangedTokens[audience]
+	if !ok || (token.AccessToken == "" && !token.Fallback) {
+		return "", false
+	}
+	if token.ExpiresOn != nil && !token.ExpiresOn.IsZero() && !token.ExpiresOn.After(s.Clock.Now()) {
+		return "", false
+	}
+	if token.Fallback {
+		return s.AccessToken, s.AccessToken != ""
+	}
+	return token.AccessToken, true
+}
+
+// SetExchangedToken caches an exchanged token for the audience
+func (s *SessionState) SetExchangedToken(audience string, token ExchangedToken) {
+	if s.ExchangedTokens == nil {
+		s.ExchangedTokens = make(map[string]ExchangedToken)
+	}
+	s.ExchangedTokens[audience] = token
+}
+
 // String constructs a summary of the session state
 func (s *SessionState) String() string {
 	o := fmt.Sprintf("Session{email:%s user:%s PreferredUsername:%s", s.Email, s.User, s.PreferredUsername)
@@ -149,7 +247,59 @@ func (s *SessionState) GetClaim(claim string) []string {
 	case "preferred_username":
 		return []string{s.PreferredUsername}
 	default:
+		return claimToStrings(s.IDTokenClaims()[claim])
+	}
+}
+
+// IDTokenClaims returns the claims from the payload of the IDToken.
+// The IDToken is verified when the session is created, so the claims are
+// not verified again here.
+// An empty map is returned if there is no IDToken or it cannot be decoded.
+func (s *SessionState) IDTokenClaims() map[string]interface{} {
+	claims := map[string]interface{}{}
+	if s == nil || s.IDToken == "" {
+		return claims
+	}
+
+	parts := strings.Split(s.IDToken, ".")
+	if len(parts) != 3 {
+		return claims
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return claims
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return map[string]interface{}{}
+	}
+	return claims
+}
+
+// claimToStrings converts a decoded JSON claim into its string values.
+// Arrays are flattened into one value per element.
+func claimToStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case nil:
 		return []string{}
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, claimToStrings(item)...)
+		}
+		return values
+	case map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return []string{}
+		}
+		return []string{string(data)}
+	default:
+		return []string{fmt.Sprintf("%v", v)}
 	}
 }
 
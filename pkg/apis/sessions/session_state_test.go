@@ -2,6 +2,7 @@ package sessions
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"testing"
@@ -137,25 +138,118 @@ func TestString(t *testing.T) {
 }
 
 func TestIsExpired(t *testing.T) {
-	s := &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(-1) * time.Minute))}
-	assert.Equal(t, true, s.IsExpired())
+	now := time.Unix(1234567890, 0)
 
-	s = &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(1) * time.Minute))}
+	s := &SessionState{ExpiresOn: timePtr(now.Add(time.Duration(1) * time.Minute))}
+	s.Clock.Set(now)
 	assert.Equal(t, false, s.IsExpired())
 
+	require.NoError(t, s.Clock.Add(2*time.Minute))
+	assert.Equal(t, true, s.IsExpired())
+
 	s = &SessionState{}
 	assert.Equal(t, false, s.IsExpired())
 }
 
 func TestAge(t *testing.T) {
 	ss := &SessionState{}
+	ss.Clock.Set(time.Unix(1234567890, 0))
 
 	// Created at unset so should be 0
 	assert.Equal(t, time.Duration(0), ss.Age())
 
-	// Set CreatedAt to 1 hour ago
-	ss.CreatedAt = timePtr(time.Now().Add(-1 * time.Hour))
-	assert.Equal(t, time.Hour, ss.Age().Round(time.Minute))
+	ss.CreatedAtNow()
+	assert.Equal(t, time.Duration(0), ss.Age())
+
+	// Move the clock forward 1 hour
+	require.NoError(t, ss.Clock.Add(1*time.Hour))
+	assert.Equal(t, time.Hour, ss.Age())
+}
+
+func TestAuthenticatedAge(t *testing.T) {
+	ss := &SessionState{}
+	ss.Clock.Set(time.Unix(1234567890, 0))
+
+	// Unset so should be 0
+	assert.Equal(t, time.Duration(0), ss.AuthenticatedAge())
+
+	// Falls back to CreatedAt before it is recorded
+	ss.CreatedAtNow()
+	require.NoError(t, ss.Clock.Add(1*time.Hour))
+	assert.Equal(t, time.Hour, ss.AuthenticatedAge())
+
+	// Not reset when the session is refreshed
+	ss.RecordAuthenticatedAt()
+	ss.CreatedAtNow()
+	require.NoError(t, ss.Clock.Add(1*time.Hour))
+	assert.Equal(t, time.Hour, ss.Age())
+	assert.Equal(t, 2*time.Hour, ss.AuthenticatedAge())
+
+	// Recording again keeps the original time
+	ss.RecordAuthenticatedAt()
+	assert.Equal(t, 2*time.Hour, ss.AuthenticatedAge())
+}
+
+func TestIdleTime(t *testing.T) {
+	ss := &SessionState{}
+	ss.Clock.Set(time.Unix(1234567890, 0))
+
+	// Falls back to CreatedAt before any activity is recorded
+	ss.CreatedAtNow()
+	require.NoError(t, ss.Clock.Add(10*time.Minute))
+	assert.Equal(t, 10*time.Minute, ss.IdleTime())
+
+	// Reset by recording activity
+	assert.True(t, ss.RecordActivity())
+	assert.Equal(t, time.Duration(0), ss.IdleTime())
+	require.NoError(t, ss.Clock.Add(5*time.Minute))
+	assert.Equal(t, 5*time.Minute, ss.IdleTime())
+	assert.Equal(t, 15*time.Minute, ss.Age())
+
+	// Activity within the same second is only recorded once
+	assert.True(t, ss.RecordActivity())
+	require.NoError(t, ss.Clock.Add(500*time.Millisecond))
+	assert.False(t, ss.RecordActivity())
+}
+
+func TestExchangedToken(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	ss := &SessionState{}
+	ss.Clock.Set(now)
+
+	_, ok := ss.GetExchangedToken("downstream")
+	assert.False(t, ok)
+
+	ss.SetExchangedToken("downstream", ExchangedToken{
+		AccessToken: "exchanged",
+		ExpiresOn:   timePtr(now.Add(5 * time.Minute)),
+	})
+	ss.SetExchangedToken("no-expiry", ExchangedToken{AccessToken: "forever"})
+
+	token, ok := ss.GetExchangedToken("downstream")
+	assert.True(t, ok)
+	assert.Equal(t, "exchanged", token)
+
+	_, ok = ss.GetExchangedToken("other")
+	assert.False(t, ok)
+
+	require.NoError(t, ss.Clock.Add(5*time.Minute))
+	_, ok = ss.GetExchangedToken("downstream")
+	assert.False(t, ok)
+
+	token, ok = ss.GetExchangedToken("no-expiry")
+	assert.True(t, ok)
+	assert.Equal(t, "forever", token)
+
+	// Fallbacks use the current access token of the session
+	ss.SetExchangedToken("fallback", ExchangedToken{Fallback: true})
+	_, ok = ss.GetExchangedToken("fallback")
+	assert.False(t, ok)
+
+	ss.AccessToken = "access"
+	token, ok = ss.GetExchangedToken("fallback")
+	assert.True(t, ok)
+	assert.Equal(t, "access", token)
 }
 
 // TestEncodeAndDecodeSessionState encodes & decodes various session states
@@ -225,6 +319,17 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
 			Groups:            []string{"group-a", "group-b"},
 		},
+		"With exchanged tokens": {
+			Email:       "username@example.com",
+			User:        "username",
+			AccessToken: "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:   &created,
+			ExpiresOn:   &expires,
+			ExchangedTokens: map[string]ExchangedToken{
+				"downstream-a": {AccessToken: "ExchangedToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7"},
+				"downstream-b": {AccessToken: "ExchangedToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd8"},
+			},
+		},
 	}
 
 	for _, secretSize := range []int{16, 24, 32} {
@@ -292,3 +397,27 @@ func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionS
 	act.ExpiresOn = nil
 	assert.Equal(t, exp, act)
 }
+
+func TestGetClaimFromIDToken(t *testing.T) {
+	encode := base64.RawURLEncoding.EncodeToString
+	idToken := encode([]byte(`{"alg":"none"}`)) + "." +
+		encode([]byte(`{"tenant_id":"tenant-123","roles":["admin","dev"],"level":3,"verified":true,"address":{"country":"NZ"}}`)) +
+		".signature"
+
+	ss := &SessionState{
+		Email:   "user@example.com",
+		IDToken: idToken,
+	}
+
+	assert.Equal(t, []string{"user@example.com"}, ss.GetClaim("email"))
+	assert.Equal(t, []string{"tenant-123"}, ss.GetClaim("tenant_id"))
+	assert.Equal(t, []string{"admin", "dev"}, ss.GetClaim("roles"))
+	assert.Equal(t, []string{"3"}, ss.GetClaim("level"))
+	assert.Equal(t, []string{"true"}, ss.GetClaim("verified"))
+	assert.Equal(t, []string{`{"country":"NZ"}`}, ss.GetClaim("address"))
+	assert.Equal(t, []string{}, ss.GetClaim("missing"))
+
+	invalid := &SessionState{IDToken: "not-a-jwt"}
+	assert.Equal(t, []string{}, invalid.GetClaim("tenant_id"))
+	assert.Equal(t, map[string]interface{}{}, invalid.IDTokenClaims())
+}
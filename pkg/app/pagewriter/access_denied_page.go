@@ -0,0 +1,83 @@
+package pagewriter
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// accessDeniedPageWriter is used to render the page shown to users that are
+// authenticated but not authorized to access a resource.
+type accessDeniedPageWriter struct {
+	// template is the access denied page HTML template.
+	template *template.Template
+
+	// proxyPrefix is the prefix under which OAuth2 Proxy pages are served.
+	proxyPrefix string
+
+	// contactURL is the link given to users to request access.
+	// If not set, no link is displayed.
+	contactURL string
+
+	// footer is the footer to be displayed at the bottom of the page.
+	// If not set, a default footer will be used.
+	footer string
+
+	// version is the OAuth2 Proxy version to be used in the default footer.
+	version string
+
+	// headers are extra headers set on the access denied page.
+	headers http.Header
+}
+
+// AccessDeniedPageOpts bundles up all the content needed to write the Access
+// Denied Page
+type AccessDeniedPageOpts struct {
+	// HTTP status code
+	Status int
+	// Email of the user that was denied access
+	Email string
+	// User of the user that was denied access
+	User string
+	// The UUID of the request
+	RequestID string
+}
+
+// WriteAccessDeniedPage writes the access denied page to the given response
+// writer.
+// It does not offer to go back to the original request, as the user would only
+// be denied again.
+func (a *accessDeniedPageWriter) WriteAccessDeniedPage(rw http.ResponseWriter, opts AccessDeniedPageOpts) {
+	SetResponseHeaders(rw, a.headers)
+	rw.WriteHeader(opts.Status)
+
+	// We allow unescaped template.HTML since it is user configured options
+	/* #nosec G203 */
+	data := struct {
+		Title       string
+		ProxyPrefix string
+		StatusCode  int
+		Email       string
+		User        string
+		ContactURL  string
+		RequestID   string
+		Footer      template.HTML
+		Version     string
+	}{
+		Title:       "Access Denied",
+		ProxyPrefix: a.proxyPrefix,
+		StatusCode:  opts.Status,
+		Email:       opts.Email,
+		User:        opts.User,
+		ContactURL:  a.contactURL,
+		RequestID:   opts.RequestID,
+		Footer:      template.HTML(a.footer),
+		Version:     a.version,
+	}
+
+	if err := a.template.Execute(rw, data); err != nil {
+		logger.Printf("Error rendering access denied template: %v", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,70 @@
+package pagewriter
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Access Denied Page Writer", func() {
+	var accessDeniedPage *accessDeniedPageWriter
+
+	BeforeEach(func() {
+		tmpl, err := template.New("").Parse("{{.Title}} {{.ProxyPrefix}} {{.StatusCode}} {{.Email}} {{.User}} {{.ContactURL}} {{.RequestID}} {{.Footer}} {{.Version}}")
+		Expect(err).ToNot(HaveOccurred())
+
+		accessDeniedPage = &accessDeniedPageWriter{
+			template:    tmpl,
+			proxyPrefix: "/prefix/",
+			contactURL:  "https://help.example.com/access",
+			footer:      "Custom Footer Text",
+			version:     "v0.0.0-test",
+		}
+	})
+
+	Context("WriteAccessDeniedPage", func() {
+		It("Writes the template to the response writer", func() {
+			recorder := httptest.NewRecorder()
+			accessDeniedPage.WriteAccessDeniedPage(recorder, AccessDeniedPageOpts{
+				Status:    http.StatusForbidden,
+				Email:     "user@example.com",
+				User:      "user",
+				RequestID: testRequestID,
+			})
+
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("Access Denied /prefix/ 403 user@example.com user https://help.example.com/access 11111111-2222-4333-8444-555555555555 Custom Footer Text v0.0.0-test"))
+		})
+
+		It("With a different status, writes the status", func() {
+			recorder := httptest.NewRecorder()
+			accessDeniedPage.WriteAccessDeniedPage(recorder, AccessDeniedPageOpts{
+				Status: http.StatusNotFound,
+				Email:  "user@example.com",
+			})
+
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(HavePrefix("Access Denied /prefix/ 404 user@example.com"))
+		})
+
+		It("Sanitizes the user details", func() {
+			recorder := httptest.NewRecorder()
+			accessDeniedPage.WriteAccessDeniedPage(recorder, AccessDeniedPageOpts{
+				Status: http.StatusForbidden,
+				Email:  "<script>alert(1)</script>@example.com",
+			})
+
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(ContainSubstring("&lt;script&gt;alert(1)&lt;/script&gt;@example.com"))
+		})
+	})
+})
@@ -1,8 +1,11 @@
 package pagewriter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
@@ -16,6 +19,7 @@ var errorMessages = map[int]string{
 	http.StatusNotFound:            "We could not find the resource you were looking for.",
 	http.StatusForbidden:           "You do not have permission to access this resource.",
 	http.StatusUnauthorized:        "You need to be logged in to access this resource.",
+	http.StatusGatewayTimeout:      "The upstream server took too long to respond.",
 }
 
 // errorPageWriter is used to render error pages.
@@ -36,6 +40,9 @@ type errorPageWriter struct {
 	// debug determines whether errors pages should be rendered with detailed
 	// errors.
 	debug bool
+
+	// headers are extra headers set on the error page.
+	headers http.Header
 }
 
 // ErrorPageOpts bundles up all the content needed to write the Error Page
@@ -50,12 +57,17 @@ type ErrorPageOpts struct {
 	AppError string
 	// Generic error messages shown in non-debug mode
 	Messages []interface{}
+	// The request that failed, available to custom templates
+	Request *http.Request
 }
 
 // WriteErrorPage writes an error page to the given response writer.
 // It uses the passed redirectURL to give users the option to go back to where
 // they originally came from or try signing in again.
+// The error template for the status is used when one was loaded, otherwise
+// the error template.
 func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageOpts) {
+	SetResponseHeaders(rw, e.headers)
 	rw.WriteHeader(opts.Status)
 
 	// We allow unescaped template.HTML since it is user configured options
@@ -69,6 +81,8 @@ func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageO
 		RequestID   string
 		Footer      template.HTML
 		Version     string
+		AppError    string
+		Request     *http.Request
 	}{
 		Title:       http.StatusText(opts.Status),
 		Message:     e.getMessage(opts.Status, opts.AppError, opts.Messages...),
@@ -78,9 +92,15 @@ func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageO
 		RequestID:   opts.RequestID,
 		Footer:      template.HTML(e.footer),
 		Version:     e.version,
+		AppError:    opts.AppError,
+		Request:     opts.Request,
 	}
 
-	if err := e.template.Execute(rw, data); err != nil {
+	t := e.template
+	if statusTemplate := t.Lookup(errorStatusTemplateName(opts.Status)); statusTemplate != nil {
+		t = statusTemplate
+	}
+	if err := t.Execute(rw, data); err != nil {
 		logger.Printf("Error rendering error template: %v", err)
 		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
@@ -88,19 +108,39 @@ func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageO
 
 // ProxyErrorHandler is used by the upstream ReverseProxy to render error pages
 // when there are issues with upstream servers.
-// It is expected to always render a bad gateway error.
+// It renders a gateway timeout error when the upstream timed out and a bad
+// gateway error otherwise.
 func (e *errorPageWriter) ProxyErrorHandler(rw http.ResponseWriter, req *http.Request, proxyErr error) {
 	logger.Errorf("Error proxying to upstream server: %v", proxyErr)
 	scope := middlewareapi.GetRequestScope(req)
+
+	status := http.StatusBadGateway
+	message := "There was a problem connecting to the upstream server."
+	if isTimeout(proxyErr) {
+		status = http.StatusGatewayTimeout
+		message = errorMessages[http.StatusGatewayTimeout]
+	}
+
 	e.WriteErrorPage(rw, ErrorPageOpts{
-		Status:      http.StatusBadGateway,
+		Status:      status,
 		RedirectURL: "", // The user is already logged in and has hit an upstream error. Makes no sense to redirect in this case.
 		RequestID:   scope.RequestID,
 		AppError:    proxyErr.Error(),
-		Messages:    []interface{}{"There was a problem connecting to the upstream server."},
+		Messages:    []interface{}{message},
+		Request:     req,
 	})
 }
 
+// isTimeout determines whether the error was caused by the upstream
+// exceeding its timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // getMessage creates the message for the template parameters.
 // If the errorPagewriter.Debug is enabled, the application error takes precedence.
 // Otherwise, any messages will be used.
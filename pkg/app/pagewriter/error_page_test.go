@@ -1,9 +1,12 @@
 package pagewriter
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
+	"net/http"
 	"net/http/httptest"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
@@ -88,6 +91,56 @@ var _ = Describe("Error Page Writer", func() {
 		})
 	})
 
+	Context("With error templates for status codes", func() {
+		BeforeEach(func() {
+			tmpl, err := template.New("").Parse("{{.Title}} {{.Message}}")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tmpl.New("error_403.html").Parse("Branded {{.StatusCode}} for {{.Request.URL.Path}}: {{.AppError}}")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tmpl.New("error_502.html").Parse("Upstream {{.StatusCode}} {{.Message}}")
+			Expect(err).ToNot(HaveOccurred())
+			errorPage.template = tmpl
+		})
+
+		It("Uses the template for the status", func() {
+			recorder := httptest.NewRecorder()
+			errorPage.WriteErrorPage(recorder, ErrorPageOpts{
+				Status:   403,
+				AppError: "Access Denied",
+				Request:  httptest.NewRequest(http.MethodGet, "/admin", nil),
+			})
+
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("Branded 403 for /admin: Access Denied"))
+		})
+
+		It("Uses the template for the status of proxy errors", func() {
+			req := httptest.NewRequest("", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+
+			recorder := httptest.NewRecorder()
+			errorPage.ProxyErrorHandler(recorder, req, errors.New("some upstream error"))
+
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("Upstream 502 There was a problem connecting to the upstream server."))
+		})
+
+		It("Uses the error template for other statuses", func() {
+			recorder := httptest.NewRecorder()
+			errorPage.WriteErrorPage(recorder, ErrorPageOpts{
+				Status:   500,
+				AppError: "Access Denied",
+			})
+
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recorder.Code).To(Equal(500))
+			Expect(string(body)).To(Equal("Internal Server Error Oops! Something went wrong. For more information contact your server administrator."))
+		})
+	})
+
 	Context("ProxyErrorHandler", func() {
 		It("Writes a bad gateway error the response writer", func() {
 			req := httptest.NewRequest("", "/bad-gateway", nil)
@@ -101,6 +154,20 @@ var _ = Describe("Error Page Writer", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(string(body)).To(Equal("Bad Gateway There was a problem connecting to the upstream server. /prefix/ 502  11111111-2222-4333-8444-555555555555 Custom Footer Text v0.0.0-test"))
 		})
+
+		It("Writes a gateway timeout error when the upstream timed out", func() {
+			req := httptest.NewRequest("", "/gateway-timeout", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+				RequestID: testRequestID,
+			})
+			recorder := httptest.NewRecorder()
+			errorPage.ProxyErrorHandler(recorder, req, fmt.Errorf("upstream did not respond: %w", context.DeadlineExceeded))
+
+			Expect(recorder.Result().StatusCode).To(Equal(http.StatusGatewayTimeout))
+			body, err := io.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("Gateway Timeout The upstream server took too long to respond. /prefix/ 504  11111111-2222-4333-8444-555555555555 Custom Footer Text v0.0.0-test"))
+		})
 	})
 
 	Context("With Debug enabled", func() {
@@ -12,14 +12,17 @@ import (
 type Writer interface {
 	WriteSignInPage(rw http.ResponseWriter, req *http.Request, redirectURL string, statusCode int)
 	WriteErrorPage(rw http.ResponseWriter, opts ErrorPageOpts)
+	WriteAccessDeniedPage(rw http.ResponseWriter, opts AccessDeniedPageOpts)
 	ProxyErrorHandler(rw http.ResponseWriter, req *http.Request, proxyErr error)
 	WriteRobotsTxt(rw http.ResponseWriter, req *http.Request)
+	WriteWelcomePage(rw http.ResponseWriter, req *http.Request)
 }
 
 // pageWriter implements the Writer interface
 type pageWriter struct {
 	*errorPageWriter
 	*signInPageWriter
+	*accessDeniedPageWriter
 	*staticPageWriter
 }
 
@@ -56,6 +59,13 @@ type Opts struct {
 	// The logo can be either PNG, JPG/JPEG or SVG.
 	// If a URL is used, image support depends on the browser.
 	CustomLogo string
+
+	// AccessDeniedContactURL is the link given to authenticated users that are
+	// denied access, to request access.
+	AccessDeniedContactURL string
+
+	// ResponseHeaders are extra headers set on the sign-in and error pages.
+	ResponseHeaders http.Header
 }
 
 // NewWriter constructs a Writer from the options given to allow
@@ -77,6 +87,7 @@ func NewWriter(opts Opts) (Writer, error) {
 		footer:      opts.Footer,
 		version:     opts.Version,
 		debug:       opts.Debug,
+		headers:     opts.ResponseHeaders,
 	}
 
 	signInPage := &signInPageWriter{
@@ -89,6 +100,16 @@ func NewWriter(opts Opts) (Writer, error) {
 		version:          opts.Version,
 		displayLoginForm: opts.DisplayLoginForm,
 		logoData:         logoData,
+		headers:          opts.ResponseHeaders,
+	}
+
+	accessDeniedPage := &accessDeniedPageWriter{
+		template:    templates.Lookup("access_denied.html"),
+		proxyPrefix: opts.ProxyPrefix,
+		contactURL:  opts.AccessDeniedContactURL,
+		footer:      opts.Footer,
+		version:     opts.Version,
+		headers:     opts.ResponseHeaders,
 	}
 
 	staticPages, err := newStaticPageWriter(opts.TemplatesPath, errorPage)
@@ -97,21 +118,33 @@ func NewWriter(opts Opts) (Writer, error) {
 	}
 
 	return &pageWriter{
-		errorPageWriter:  errorPage,
-		signInPageWriter: signInPage,
-		staticPageWriter: staticPages,
+		errorPageWriter:        errorPage,
+		signInPageWriter:       signInPage,
+		accessDeniedPageWriter: accessDeniedPage,
+		staticPageWriter:       staticPages,
 	}, nil
 }
 
+// SetResponseHeaders sets the configured page response headers on the
+// response, replacing any existing values.
+// It must be called before the response status is written.
+func SetResponseHeaders(rw http.ResponseWriter, headers http.Header) {
+	for name, values := range headers {
+		rw.Header()[name] = append([]string(nil), values...)
+	}
+}
+
 // WriterFuncs is an implementation of the PageWriter interface based
 // on override functions.
 // If any of the funcs are not provided, a default implementation will be used.
 // This is primarily for us in testing.
 type WriterFuncs struct {
-	SignInPageFunc func(rw http.ResponseWriter, req *http.Request, redirectURL string, statusCode int)
-	ErrorPageFunc  func(rw http.ResponseWriter, opts ErrorPageOpts)
-	ProxyErrorFunc func(rw http.ResponseWriter, req *http.Request, proxyErr error)
-	RobotsTxtfunc  func(rw http.ResponseWriter, req *http.Request)
+	SignInPageFunc       func(rw http.ResponseWriter, req *http.Request, redirectURL string, statusCode int)
+	ErrorPageFunc        func(rw http.ResponseWriter, opts ErrorPageOpts)
+	AccessDeniedPageFunc func(rw http.ResponseWriter, opts AccessDeniedPageOpts)
+	ProxyErrorFunc       func(rw http.ResponseWriter, req *http.Request, proxyErr error)
+	RobotsTxtfunc        func(rw http.ResponseWriter, req *http.Request)
+	WelcomePageFunc      func(rw http.ResponseWriter, req *http.Request)
 }
 
 // WriteSignInPage implements the Writer interface.
@@ -144,6 +177,22 @@ func (w *WriterFuncs) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageOpts)
 	}
 }
 
+// WriteAccessDeniedPage implements the Writer interface.
+// If the AccessDeniedPageFunc is provided, this will be used, else a default
+// implementation will be used.
+func (w *WriterFuncs) WriteAccessDeniedPage(rw http.ResponseWriter, opts AccessDeniedPageOpts) {
+	if w.AccessDeniedPageFunc != nil {
+		w.AccessDeniedPageFunc(rw, opts)
+		return
+	}
+
+	rw.WriteHeader(opts.Status)
+	errMsg := fmt.Sprintf("%d - Access Denied", opts.Status)
+	if _, err := rw.Write([]byte(errMsg)); err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 // ProxyErrorHandler implements the Writer interface.
 // If the ProxyErrorFunc is provided, this will be used, else a default
 // implementation will be used.
@@ -156,6 +205,7 @@ func (w *WriterFuncs) ProxyErrorHandler(rw http.ResponseWriter, req *http.Reques
 	w.WriteErrorPage(rw, ErrorPageOpts{
 		Status:   http.StatusBadGateway,
 		AppError: proxyErr.Error(),
+		Request:  req,
 	})
 }
 
@@ -172,3 +222,17 @@ func (w *WriterFuncs) WriteRobotsTxt(rw http.ResponseWriter, req *http.Request)
 		rw.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+// WriteWelcomePage implements the Writer interface.
+// If the WelcomePageFunc is provided, this will be used, else a default
+// implementation will be used.
+func (w *WriterFuncs) WriteWelcomePage(rw http.ResponseWriter, req *http.Request) {
+	if w.WelcomePageFunc != nil {
+		w.WelcomePageFunc(rw, req)
+		return
+	}
+
+	if _, err := rw.Write([]byte("Welcome")); err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -63,6 +64,78 @@ var _ = Describe("Writer", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(string(body)).To(HavePrefix("\n<!DOCTYPE html>"))
 			})
+
+			It("Writes the default access denied template", func() {
+				recorder := httptest.NewRecorder()
+				writer.WriteAccessDeniedPage(recorder, AccessDeniedPageOpts{
+					Status: http.StatusForbidden,
+					Email:  "user@example.com",
+				})
+
+				Expect(recorder.Code).To(Equal(http.StatusForbidden))
+				body, err := io.ReadAll(recorder.Result().Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(HavePrefix("\n<!DOCTYPE html>"))
+				Expect(string(body)).To(ContainSubstring("You are signed in as <strong>user@example.com</strong>"))
+				Expect(string(body)).To(ContainSubstring(`action="/prefix/sign_in"`))
+			})
+		})
+
+		Context("With response headers", func() {
+			BeforeEach(func() {
+				opts.ResponseHeaders = http.Header{
+					"Content-Security-Policy": []string{"default-src 'self'"},
+					"Cache-Control":           []string{"no-store", "max-age=0"},
+				}
+
+				var err error
+				writer, err = NewWriter(opts)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("Sets the headers on the error page", func() {
+				recorder := httptest.NewRecorder()
+				recorder.Header().Set("Cache-Control", "public")
+				writer.WriteErrorPage(recorder, ErrorPageOpts{
+					Status:   http.StatusForbidden,
+					AppError: "Some debug error",
+				})
+
+				Expect(recorder.Code).To(Equal(http.StatusForbidden))
+				Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+				Expect(recorder.Header().Values("Cache-Control")).To(Equal([]string{"no-store", "max-age=0"}))
+			})
+
+			It("Sets the headers on the sign in page", func() {
+				recorder := httptest.NewRecorder()
+				writer.WriteSignInPage(recorder, request, "/redirect", http.StatusOK)
+
+				Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+				Expect(recorder.Header().Values("Cache-Control")).To(Equal([]string{"no-store", "max-age=0"}))
+			})
+
+			It("Sets the headers on the access denied page", func() {
+				recorder := httptest.NewRecorder()
+				writer.WriteAccessDeniedPage(recorder, AccessDeniedPageOpts{Status: http.StatusForbidden})
+
+				Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+			})
+
+			It("Sets the headers on upstream error pages", func() {
+				req := middlewareapi.AddRequestScope(request, &middlewareapi.RequestScope{})
+				recorder := httptest.NewRecorder()
+				writer.ProxyErrorHandler(recorder, req, errors.New("connection refused"))
+
+				Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+				Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+			})
+
+			It("Does not set the headers on robots.txt", func() {
+				recorder := httptest.NewRecorder()
+				writer.WriteRobotsTxt(recorder, request)
+
+				Expect(recorder.Header().Get("Content-Security-Policy")).To(BeEmpty())
+			})
 		})
 
 		Context("With custom templates", func() {
@@ -176,6 +249,38 @@ var _ = Describe("Writer", func() {
 			}),
 		)
 
+		DescribeTable("WriteAccessDeniedPage",
+			func(in writerFuncsTableInput) {
+				rw := httptest.NewRecorder()
+				in.writer.WriteAccessDeniedPage(rw, AccessDeniedPageOpts{
+					Status:    http.StatusForbidden,
+					Email:     "user@example.com",
+					RequestID: "12345",
+				})
+
+				Expect(rw.Result().StatusCode).To(Equal(in.expectedStatus))
+
+				body, err := io.ReadAll(rw.Result().Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal(in.expectedBody))
+			},
+			Entry("With no override", writerFuncsTableInput{
+				writer:         &WriterFuncs{},
+				expectedStatus: 403,
+				expectedBody:   "403 - Access Denied",
+			}),
+			Entry("With an override function", writerFuncsTableInput{
+				writer: &WriterFuncs{
+					AccessDeniedPageFunc: func(rw http.ResponseWriter, opts AccessDeniedPageOpts) {
+						rw.WriteHeader(451)
+						rw.Write([]byte(fmt.Sprintf("%s %s", opts.RequestID, opts.Email)))
+					},
+				},
+				expectedStatus: 451,
+				expectedBody:   "12345 user@example.com",
+			}),
+		)
+
 		DescribeTable("WriteErrorPage",
 			func(in writerFuncsTableInput) {
 				rw := httptest.NewRecorder()
@@ -277,5 +382,34 @@ var _ = Describe("Writer", func() {
 				expectedBody:   "Disallow: *",
 			}),
 		)
+
+		DescribeTable("WriteWelcomePage",
+			func(in writerFuncsTableInput) {
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest("", "/", nil)
+				in.writer.WriteWelcomePage(rw, req)
+
+				Expect(rw.Result().StatusCode).To(Equal(in.expectedStatus))
+
+				body, err := io.ReadAll(rw.Result().Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal(in.expectedBody))
+			},
+			Entry("With no override", writerFuncsTableInput{
+				writer:         &WriterFuncs{},
+				expectedStatus: 200,
+				expectedBody:   "Welcome",
+			}),
+			Entry("With an override function", writerFuncsTableInput{
+				writer: &WriterFuncs{
+					WelcomePageFunc: func(rw http.ResponseWriter, req *http.Request) {
+						rw.WriteHeader(202)
+						rw.Write([]byte("Hello"))
+					},
+				},
+				expectedStatus: 202,
+				expectedBody:   "Hello",
+			}),
+		)
 	})
 })
@@ -50,11 +50,16 @@ type signInPageWriter struct {
 	// LogoData is the logo to render in the template.
 	// This should contain valid html.
 	logoData string
+
+	// headers are extra headers set on the sign-in page.
+	headers http.Header
 }
 
 // WriteSignInPage writes the sign-in page to the given response writer.
 // It uses the redirectURL to be able to set the final destination for the user post login.
 func (s *signInPageWriter) WriteSignInPage(rw http.ResponseWriter, req *http.Request, redirectURL string, statusCode int) {
+	SetResponseHeaders(rw, s.headers)
+
 	// We allow unescaped template.HTML since it is user configured options
 	/* #nosec G203 */
 	t := struct {
@@ -88,6 +93,7 @@ func (s *signInPageWriter) WriteSignInPage(rw http.ResponseWriter, req *http.Req
 			RedirectURL: redirectURL,
 			RequestID:   scope.RequestID,
 			AppError:    err.Error(),
+			Request:     req,
 		})
 	}
 }
@@ -13,12 +13,16 @@ import (
 )
 
 const (
-	robotsTxtName = "robots.txt"
+	robotsTxtName   = "robots.txt"
+	welcomePageName = "welcome.html"
 )
 
 //go:embed robots.txt
 var defaultRobotsTxt []byte
 
+//go:embed welcome.html
+var defaultWelcomePage []byte
+
 // staticPageWriter is used to write static pages.
 type staticPageWriter struct {
 	pageGetter      *pageGetter
@@ -30,6 +34,12 @@ func (s *staticPageWriter) WriteRobotsTxt(rw http.ResponseWriter, req *http.Requ
 	s.writePage(rw, req, robotsTxtName)
 }
 
+// WriteWelcomePage writes the welcome page content to the response writer.
+func (s *staticPageWriter) WriteWelcomePage(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.writePage(rw, req, welcomePageName)
+}
+
 // writePage writes the content of the page to the response writer.
 func (s *staticPageWriter) writePage(rw http.ResponseWriter, req *http.Request, pageName string) {
 	_, err := rw.Write(s.pageGetter.getPage(pageName))
@@ -40,6 +50,7 @@ func (s *staticPageWriter) writePage(rw http.ResponseWriter, req *http.Request,
 			Status:    http.StatusInternalServerError,
 			RequestID: scope.RequestID,
 			AppError:  err.Error(),
+			Request:   req,
 		})
 		return
 	}
@@ -62,12 +73,16 @@ func newStaticPageWriter(customDir string, errorWriter *errorPageWriter) (*stati
 // instead.
 // Statis files include:
 // - robots.txt
+// - welcome.html
 func loadStaticPages(customDir string) (*pageGetter, error) {
 	pages := newPageGetter(customDir)
 
 	if err := pages.addPage(robotsTxtName, defaultRobotsTxt); err != nil {
 		return nil, fmt.Errorf("could not add robots.txt: %v", err)
 	}
+	if err := pages.addPage(welcomePageName, defaultWelcomePage); err != nil {
+		return nil, fmt.Errorf("could not add welcome.html: %v", err)
+	}
 
 	return pages, nil
 }
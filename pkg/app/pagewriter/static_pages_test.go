@@ -17,6 +17,7 @@ import (
 var _ = Describe("Static Pages", func() {
 	var customDir string
 	const customRobots = "User-agent: *\nAllow: /\n"
+	const customWelcome = "<html><body>Hello</body></html>\n"
 	var errorPage *errorPageWriter
 	var request *http.Request
 
@@ -33,6 +34,9 @@ var _ = Describe("Static Pages", func() {
 		robotsTxtFile := filepath.Join(customDir, robotsTxtName)
 		Expect(os.WriteFile(robotsTxtFile, []byte(customRobots), 0400)).To(Succeed())
 
+		welcomePageFile := filepath.Join(customDir, welcomePageName)
+		Expect(os.WriteFile(welcomePageFile, []byte(customWelcome), 0400)).To(Succeed())
+
 		request = httptest.NewRequest("", "http://127.0.0.1/", nil)
 		request = middlewareapi.AddRequestScope(request, &middlewareapi.RequestScope{
 			RequestID: testRequestID,
@@ -65,6 +69,20 @@ var _ = Describe("Static Pages", func() {
 					Expect(recorder.Result().StatusCode).To(Equal(http.StatusOK))
 				})
 			})
+
+			Context("WriteWelcomePage", func() {
+				It("Should write the custom welcome page", func() {
+					recorder := httptest.NewRecorder()
+					pageWriter.WriteWelcomePage(recorder, request)
+
+					body, err := io.ReadAll(recorder.Result().Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(body)).To(Equal(customWelcome))
+
+					Expect(recorder.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(recorder.Result().Header.Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+				})
+			})
 		})
 
 		Context("Without custom content", func() {
@@ -88,6 +106,17 @@ var _ = Describe("Static Pages", func() {
 					Expect(recorder.Result().StatusCode).To(Equal(http.StatusOK))
 				})
 
+				It("Should write the default welcome page", func() {
+					recorder := httptest.NewRecorder()
+					pageWriter.WriteWelcomePage(recorder, request)
+
+					body, err := io.ReadAll(recorder.Result().Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(body)).To(Equal(string(defaultWelcomePage)))
+
+					Expect(recorder.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+
 				It("Should serve an error if it cannot write the page", func() {
 					recorder := &testBadResponseWriter{
 						ResponseRecorder: httptest.NewRecorder(),
@@ -110,8 +139,9 @@ var _ = Describe("Static Pages", func() {
 				It("Loads the custom content", func() {
 					pages, err := loadStaticPages(customDir)
 					Expect(err).ToNot(HaveOccurred())
-					Expect(pages.pages).To(HaveLen(1))
+					Expect(pages.pages).To(HaveLen(2))
 					Expect(pages.getPage(robotsTxtName)).To(BeEquivalentTo(customRobots))
+					Expect(pages.getPage(welcomePageName)).To(BeEquivalentTo(customWelcome))
 				})
 			})
 
@@ -122,7 +152,7 @@ var _ = Describe("Static Pages", func() {
 
 					pages, err := loadStaticPages(customDir)
 					Expect(err).ToNot(HaveOccurred())
-					Expect(pages.pages).To(HaveLen(1))
+					Expect(pages.pages).To(HaveLen(2))
 					Expect(pages.getPage(robotsTxtName)).To(BeEquivalentTo(defaultRobotsTxt))
 				})
 			})
@@ -132,7 +162,7 @@ var _ = Describe("Static Pages", func() {
 			It("Loads the default content", func() {
 				pages, err := loadStaticPages("")
 				Expect(err).ToNot(HaveOccurred())
-				Expect(pages.pages).To(HaveLen(1))
+				Expect(pages.pages).To(HaveLen(2))
 				Expect(pages.getPage(robotsTxtName)).To(BeEquivalentTo(defaultRobotsTxt))
 			})
 		})
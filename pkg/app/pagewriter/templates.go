@@ -8,23 +8,33 @@ import (
 	"html/template"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
 const (
-	errorTemplateName  = "error.html"
-	signInTemplateName = "sign_in.html"
+	errorTemplateName        = "error.html"
+	signInTemplateName       = "sign_in.html"
+	accessDeniedTemplateName = "access_denied.html"
 )
 
+// errorStatusTemplatePattern matches the names of the error templates for a
+// single status code, eg error_403.html
+var errorStatusTemplatePattern = regexp.MustCompile(`^error_([0-9]+)\.html$`)
+
 //go:embed error.html
 var defaultErrorTemplate string
 
 //go:embed sign_in.html
 var defaultSignInTemplate string
 
-// loadTemplates adds the Sign In and Error templates from the custom template
+//go:embed access_denied.html
+var defaultAccessDeniedTemplate string
+
+// loadTemplates adds the Sign In, Error and Access Denied templates from the custom template
 // directory, or uses the defaults if they do not exist or the custom directory
 // is not provided.
 func loadTemplates(customDir string) (*template.Template, error) {
@@ -41,7 +51,51 @@ func loadTemplates(customDir string) (*template.Template, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not add Error template: %v", err)
 	}
+	t, err = addTemplate(t, customDir, accessDeniedTemplateName, defaultAccessDeniedTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("could not add Access Denied template: %v", err)
+	}
+	t, err = addErrorStatusTemplates(t, customDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not add Error template: %v", err)
+	}
+
+	return t, nil
+}
+
+// errorStatusTemplateName returns the name of the error template for the status
+func errorStatusTemplateName(status int) string {
+	return fmt.Sprintf("error_%d.html", status)
+}
 
+// addErrorStatusTemplates adds the error templates for single status codes
+// from the custom directory, which are used instead of the error template
+// for their status.
+func addErrorStatusTemplates(t *template.Template, customDir string) (*template.Template, error) {
+	if customDir == "" {
+		return t, nil
+	}
+	filePaths, err := filepath.Glob(filepath.Join(customDir, "error_*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filePath := range filePaths {
+		fileName := filepath.Base(filePath)
+		match := errorStatusTemplatePattern.FindStringSubmatch(fileName)
+		if match == nil {
+			continue
+		}
+		if status, _ := strconv.Atoi(match[1]); status < 400 || status > 599 {
+			return nil, fmt.Errorf("template %s is not for an error status code", filePath)
+		}
+		if !isFile(filePath) {
+			continue
+		}
+		if t, err = t.ParseFiles(filePath); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %v", filePath, err)
+		}
+	}
 	return t, nil
 }
 
@@ -171,6 +171,44 @@ var _ = Describe("Templates", func() {
 				})
 			})
 
+			Context("With error templates for status codes", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(customDir, "error_403.html"), []byte("Forbidden {{.StatusCode}}"), 0600)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(customDir, "error_502.html"), []byte("Bad Gateway {{.TestString}}"), 0600)).To(Succeed())
+				})
+
+				It("Adds the templates for each status", func() {
+					t, err := loadTemplates(customDir)
+					Expect(err).ToNot(HaveOccurred())
+
+					buf := bytes.NewBuffer([]byte{})
+					Expect(t.ExecuteTemplate(buf, errorStatusTemplateName(403), data)).To(Succeed())
+					Expect(buf.String()).To(Equal("Forbidden 404"))
+
+					buf.Reset()
+					Expect(t.ExecuteTemplate(buf, errorStatusTemplateName(502), data)).To(Succeed())
+					Expect(buf.String()).To(Equal("Bad Gateway Testing"))
+
+					Expect(t.Lookup(errorStatusTemplateName(500))).To(BeNil())
+				})
+
+				It("Should return an error when a template is invalid", func() {
+					Expect(os.WriteFile(filepath.Join(customDir, "error_502.html"), []byte("{{"), 0600)).To(Succeed())
+
+					t, err := loadTemplates(customDir)
+					Expect(err).To(MatchError(HavePrefix("could not add Error template: failed to parse template " + filepath.Join(customDir, "error_502.html"))))
+					Expect(t).To(BeNil())
+				})
+
+				It("Should return an error when a template is not for an error status", func() {
+					Expect(os.WriteFile(filepath.Join(customDir, "error_200.html"), []byte("OK"), 0600)).To(Succeed())
+
+					t, err := loadTemplates(customDir)
+					Expect(err).To(MatchError("could not add Error template: template " + filepath.Join(customDir, "error_200.html") + " is not for an error status code"))
+					Expect(t).To(BeNil())
+				})
+			})
+
 			Context("With an invalid error template", func() {
 				BeforeEach(func() {
 					errorFile := filepath.Join(customDir, errorTemplateName)
@@ -55,6 +55,31 @@ var _ = Describe("Director Suite", func() {
 			validator:        testValidator(true),
 			expectedRedirect: "/foo?bar",
 		}),
+		Entry("Request with encoded path and multiple query parameters, preserves the request URI", getRedirectTableInput{
+			requestURL:       "/foo/caf%C3%A9/a%2Fb?tab=settings&view=a%2Fb&tab=profile",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        testValidator(true),
+			expectedRedirect: "/foo/caf%C3%A9/a%2Fb?tab=settings&view=a%2Fb&tab=profile",
+		}),
+		Entry("Proxied request with encoded URI and multiple query parameters, preserves the forwarded URI", getRedirectTableInput{
+			requestURL: "https://oauth.example.com/foo/bar",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "a-service.example.com",
+				"X-Forwarded-Uri":   "/foo/caf%C3%A9?tab=settings&next=%2Fbar%3Fx%3D1",
+			},
+			reverseProxy:     true,
+			validator:        testValidator(true),
+			expectedRedirect: "https://a-service.example.com/foo/caf%C3%A9?tab=settings&next=%2Fbar%3Fx%3D1",
+		}),
+		Entry("Request with encoded RD parameter, preserves the query of the redirect", getRedirectTableInput{
+			requestURL:       "https://oauth.example.com/oauth2/start?rd=%2Ffoo%2Fcaf%25C3%25A9%3Ftab%3Dsettings%26view%3Da%252Fb",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        testValidator(true),
+			expectedRedirect: "/foo/caf%C3%A9?tab=settings&view=a%2Fb",
+		}),
 		Entry("Request under the proxy prefix, redirects to root", getRedirectTableInput{
 			requestURL:       testProxyPrefix + "/foo/bar",
 			headers:          nil,
@@ -25,16 +25,33 @@ type Validator interface {
 }
 
 // NewValidator constructs a new redirect validator.
+// Allowed domains may be prefixed with a scheme (eg https://example.com)
+// to only allow redirects to that domain using the given scheme.
 func NewValidator(allowedDomains []string) Validator {
-	return &validator{
-		allowedDomains: allowedDomains,
+	v := &validator{
+		schemeDomains: make(map[string][]string),
 	}
+
+	for _, domain := range allowedDomains {
+		scheme, host, found := strings.Cut(domain, "://")
+		if !found {
+			v.allowedDomains = append(v.allowedDomains, domain)
+			continue
+		}
+		scheme = strings.ToLower(scheme)
+		v.schemeDomains[scheme] = append(v.schemeDomains[scheme], host)
+	}
+
+	return v
 }
 
 // validator implements the Validator interface to allow validation
 // of redirect URLs.
 type validator struct {
+	// allowedDomains are allowed for redirects using any scheme
 	allowedDomains []string
+	// schemeDomains are only allowed for redirects using the keyed scheme
+	schemeDomains map[string][]string
 }
 
 // IsValidRedirect checks whether the redirect URL is safe and allowed.
@@ -53,7 +70,13 @@ func (v *validator) IsValidRedirect(redirect string) bool {
 			return false
 		}
 
-		if util.IsEndpointAllowed(redirectURL, v.allowedDomains) {
+		if redirectURL.User != nil {
+			logger.Printf("Rejecting invalid redirect %q: userinfo is not allowed", redirect)
+			return false
+		}
+
+		if util.IsEndpointAllowed(redirectURL, v.allowedDomains) ||
+			util.IsEndpointAllowed(redirectURL, v.schemeDomains[strings.ToLower(redirectURL.Scheme)]) {
 			return true
 		}
 
@@ -0,0 +1,143 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/watcher"
+)
+
+// minKeyLength is the minimum length of the API keys, shorter keys could be
+// guessed by brute force
+const minKeyLength = 16
+
+// Validator is a minimal interface for something that can find the identity
+// an API key belongs to.
+type Validator interface {
+	Validate(key string) (*Identity, bool)
+}
+
+// Identity is the user authenticated by an API key.
+type Identity struct {
+	User              string   `json:"user"`
+	Email             string   `json:"email,omitempty"`
+	PreferredUsername string   `json:"preferredUsername,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// fileEntry is an API key and its identity in the API keys file
+type fileEntry struct {
+	Key string `json:"key"`
+	Identity
+}
+
+// hashedKey holds the SHA-256 digest of an API key so that every key can be
+// compared in constant time regardless of its length
+type hashedKey struct {
+	digest   [sha256.Size]byte
+	identity Identity
+}
+
+// keyMap holds the API keys of the API keys file.
+type keyMap struct {
+	keys []hashedKey
+	rwm  sync.RWMutex
+}
+
+// NewFileValidator constructs an API key validator from the YAML file at the
+// path given. The file is a list of entries with a key and the user, email,
+// preferredUsername and groups of the identity the key authenticates.
+// The file is reloaded when it changes, so that keys can be rotated without
+// a restart.
+func NewFileValidator(path string) (Validator, error) {
+	k := &keyMap{}
+
+	if err := k.loadFile(path); err != nil {
+		return nil, fmt.Errorf("could not load API keys file: %v", err)
+	}
+
+	if err := watcher.WatchFileForUpdates(path, nil, func() {
+		err := k.loadFile(path)
+		if err != nil {
+			logger.Errorf("%v: no changes were made to the current API keys", err)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("could not watch API keys file: %v", err)
+	}
+
+	return k, nil
+}
+
+// loadFile replaces the API keys with the keys in the file
+func (k *keyMap) loadFile(filename string) error {
+	// We allow the API keys file location via config options
+	content, err := os.ReadFile(filename) // #nosec G304
+	if err != nil {
+		return fmt.Errorf("could not read API keys file: %v", err)
+	}
+
+	var entries []fileEntry
+	if err := yaml.Unmarshal(content, &entries); err != nil {
+		return fmt.Errorf("could not parse API keys file: %v", err)
+	}
+
+	keys, err := hashKeys(entries)
+	if err != nil {
+		return fmt.Errorf("API keys file entries error: %v", err)
+	}
+
+	k.rwm.Lock()
+	k.keys = keys
+	k.rwm.Unlock()
+
+	return nil
+}
+
+// hashKeys checks every entry has a long enough, unique key and a user and
+// hashes the keys
+func hashKeys(entries []fileEntry) ([]hashedKey, error) {
+	keys := make([]hashedKey, 0, len(entries))
+	seen := make(map[[sha256.Size]byte]struct{}, len(entries))
+	for i, entry := range entries {
+		switch {
+		case len(entry.Key) < minKeyLength:
+			return nil, fmt.Errorf("entry %d: key must be at least %d characters", i, minKeyLength)
+		case entry.User == "":
+			return nil, fmt.Errorf("entry %d: user is required", i)
+		}
+
+		digest := sha256.Sum256([]byte(entry.Key))
+		if _, ok := seen[digest]; ok {
+			return nil, fmt.Errorf("entry %d: the key of user %q is already used by another entry", i, entry.User)
+		}
+		seen[digest] = struct{}{}
+
+		keys = append(keys, hashedKey{digest: digest, identity: entry.Identity})
+	}
+	return keys, nil
+}
+
+// Validate returns the identity of the API key.
+// The key is compared to every key in constant time, so that the time taken
+// does not reveal how much of a key was guessed or which key matched.
+func (k *keyMap) Validate(key string) (*Identity, bool) {
+	digest := sha256.Sum256([]byte(key))
+
+	k.rwm.RLock()
+	defer k.rwm.RUnlock()
+
+	var identity *Identity
+	for i := range k.keys {
+		if subtle.ConstantTimeCompare(k.keys[i].digest[:], digest[:]) == 1 && identity == nil {
+			found := k.keys[i].identity
+			found.Groups = append([]string(nil), found.Groups...)
+			identity = &found
+		}
+	}
+	return identity, identity != nil
+}
@@ -0,0 +1,132 @@
+package apikey
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	ciKey     = "ci-0123456789abcdef"
+	backupKey = "backup-fedcba9876543210"
+)
+
+var _ = Describe("API Key Suite", func() {
+	Context("with a file validator", func() {
+		var validator Validator
+
+		BeforeEach(func() {
+			var err error
+			validator, err = NewFileValidator("./test/api-keys.yaml")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns the identity of a valid key", func() {
+			identity, ok := validator.Validate(ciKey)
+			Expect(ok).To(BeTrue())
+			Expect(identity).To(Equal(&Identity{
+				User:   "ci-bot",
+				Email:  "ci-bot@example.com",
+				Groups: []string{"deployers", "readers"},
+			}))
+
+			identity, ok = validator.Validate(backupKey)
+			Expect(ok).To(BeTrue())
+			Expect(identity).To(Equal(&Identity{
+				User:              "backup",
+				PreferredUsername: "backup-job",
+			}))
+		})
+
+		It("rejects invalid keys", func() {
+			for _, key := range []string{"", "ci-0123456789abcde", "ci-0123456789abcdeF", ciKey + " ", "CI-0123456789ABCDEF"} {
+				identity, ok := validator.Validate(key)
+				Expect(ok).To(BeFalse(), key)
+				Expect(identity).To(BeNil())
+			}
+		})
+
+		It("returns a copy of the identity", func() {
+			identity, _ := validator.Validate(ciKey)
+			identity.Groups[0] = "admins"
+
+			identity, _ = validator.Validate(ciKey)
+			Expect(identity.Groups).To(Equal([]string{"deployers", "readers"}))
+		})
+	})
+
+	Context("with a rotated key", func() {
+		var dir, path string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "oauth2-proxy-api-keys-test")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "api-keys.yaml")
+			Expect(os.WriteFile(path, []byte("- key: "+ciKey+"\n  user: ci-bot\n"), 0600)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("reloads the keys when the file changes", func() {
+			validator, err := NewFileValidator(path)
+			Expect(err).ToNot(HaveOccurred())
+			_, ok := validator.Validate(ciKey)
+			Expect(ok).To(BeTrue())
+
+			const rotatedKey = "ci-rotated-0123456789"
+			Expect(os.WriteFile(path, []byte("- key: "+rotatedKey+"\n  user: ci-bot\n"), 0600)).To(Succeed())
+
+			Eventually(func() bool {
+				_, ok := validator.Validate(rotatedKey)
+				return ok
+			}, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+			_, ok = validator.Validate(ciKey)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("keeps the current keys when the changed file is invalid", func() {
+			validator, err := NewFileValidator(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.WriteFile(path, []byte("- key: short\n  user: ci-bot\n"), 0600)).To(Succeed())
+
+			Consistently(func() bool {
+				_, ok := validator.Validate(ciKey)
+				return ok
+			}, 500*time.Millisecond, 10*time.Millisecond).Should(BeTrue())
+		})
+	})
+
+	Context("hashKeys", func() {
+		DescribeTable("rejects invalid entries",
+			func(entries []fileEntry, expectedErr string) {
+				_, err := hashKeys(entries)
+				Expect(err).To(MatchError(expectedErr))
+			},
+			Entry("with a short key", []fileEntry{
+				{Key: "0123456789", Identity: Identity{User: "short"}},
+			}, "entry 0: key must be at least 16 characters"),
+			Entry("without a user", []fileEntry{
+				{Key: ciKey},
+			}, "entry 0: user is required"),
+			Entry("with a duplicate key", []fileEntry{
+				{Key: ciKey, Identity: Identity{User: "ci-bot"}},
+				{Key: ciKey, Identity: Identity{User: "other"}},
+			}, "entry 1: the key of user \"other\" is already used by another entry"),
+		)
+	})
+
+	Context("with a non existent file", func() {
+		It("returns an error", func() {
+			_, err := NewFileValidator("./test/missing.yaml")
+			Expect(err).To(MatchError(ContainSubstring("could not load API keys file: could not read API keys file")))
+		})
+	})
+})
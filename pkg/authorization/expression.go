@@ -0,0 +1,345 @@
+package authorization
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpressionDepth limits the nesting of expressions so that a deeply
+// nested expression cannot exhaust the stack when it is parsed
+const maxExpressionDepth = 32
+
+// ClaimGetter returns the values of a claim, or an empty list if the claim
+// is not set
+type ClaimGetter func(claim string) []string
+
+// Expression is a boolean expression over the claims of a session
+type Expression interface {
+	Evaluate(claims ClaimGetter) bool
+	// Claims returns the names of the claims the expression reads
+	Claims() []string
+}
+
+// ParseExpression parses a boolean expression over claims.
+//
+// Comparisons take the form `<claim> <operator> <value>`:
+//   - `==` matches when the claim has exactly one value equal to the value
+//   - `!=` matches when `==` does not
+//   - `contains` matches when any of the claim values equal the value
+//
+// Values may be bare words (eg `engineering`, `true`) or double quoted strings.
+// Comparisons can be combined with `&&` (or `and`), `||` (or `or`) and negated
+// with `!` (or `not`). `!` binds tighter than `&&`, which binds tighter than
+// `||`. Parentheses may be used for grouping.
+func ParseExpression(input string) (Expression, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+	return expr, nil
+}
+
+type orExpression struct {
+	left, right Expression
+}
+
+func (e orExpression) Evaluate(claims ClaimGetter) bool {
+	return e.left.Evaluate(claims) || e.right.Evaluate(claims)
+}
+
+func (e orExpression) Claims() []string {
+	return append(e.left.Claims(), e.right.Claims()...)
+}
+
+type andExpression struct {
+	left, right Expression
+}
+
+func (e andExpression) Evaluate(claims ClaimGetter) bool {
+	return e.left.Evaluate(claims) && e.right.Evaluate(claims)
+}
+
+func (e andExpression) Claims() []string {
+	return append(e.left.Claims(), e.right.Claims()...)
+}
+
+type notExpression struct {
+	expr Expression
+}
+
+func (e notExpression) Evaluate(claims ClaimGetter) bool {
+	return !e.expr.Evaluate(claims)
+}
+
+func (e notExpression) Claims() []string {
+	return e.expr.Claims()
+}
+
+// comparison operators
+const (
+	opEquals    = "=="
+	opNotEquals = "!="
+	opContains  = "contains"
+)
+
+type comparisonExpression struct {
+	claim    string
+	operator string
+	value    string
+}
+
+func (e comparisonExpression) Evaluate(claims ClaimGetter) bool {
+	values := claims(e.claim)
+	switch e.operator {
+	case opEquals:
+		return len(values) == 1 && values[0] == e.value
+	case opNotEquals:
+		return len(values) != 1 || values[0] != e.value
+	case opContains:
+		for _, value := range values {
+			if value == e.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e comparisonExpression) Claims() []string {
+	return []string{e.claim}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenNot
+	tokenAnd
+	tokenOr
+	tokenEquals
+	tokenNotEquals
+	tokenContains
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// tokenize splits the input into tokens.
+// Keywords are case insensitive.
+func tokenize(input string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, value: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, value: ")", pos: i})
+			i++
+		case strings.HasPrefix(input[i:], "=="):
+			tokens = append(tokens, token{kind: tokenEquals, value: opEquals, pos: i})
+			i += 2
+		case strings.HasPrefix(input[i:], "!="):
+			tokens = append(tokens, token{kind: tokenNotEquals, value: opNotEquals, pos: i})
+			i += 2
+		case strings.HasPrefix(input[i:], "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, value: "&&", pos: i})
+			i += 2
+		case strings.HasPrefix(input[i:], "||"):
+			tokens = append(tokens, token{kind: tokenOr, value: "||", pos: i})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, token{kind: tokenNot, value: "!", pos: i})
+			i++
+		case c == '"':
+			value, n, err := readString(input[i:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %v", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value, pos: i})
+			i += n
+		case isWordChar(c):
+			start := i
+			for i < len(input) && isWordChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, wordToken(input[start:i], start))
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+// wordToken returns the keyword token for the word, or a plain word
+func wordToken(word string, pos int) token {
+	kinds := map[string]tokenKind{
+		"and":      tokenAnd,
+		"or":       tokenOr,
+		"not":      tokenNot,
+		"contains": tokenContains,
+	}
+	if kind, ok := kinds[strings.ToLower(word)]; ok {
+		return token{kind: kind, value: strings.ToLower(word), pos: pos}
+	}
+	return token{kind: tokenWord, value: word, pos: pos}
+}
+
+// readString reads a double quoted string from the start of the input and
+// returns the unquoted value and the number of bytes read
+func readString(input string) (string, int, error) {
+	for i := 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(input[:i+1])
+			return value, i + 1, err
+		}
+	}
+	return "", 0, fmt.Errorf("missing closing quote")
+}
+
+func isWordChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("_-.:/@+*", c) >= 0
+	}
+}
+
+// parser is a recursive descent parser over the tokens
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr(depth int) (Expression, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = orExpression{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (Expression, error) {
+	left, err := p.parseNot(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = andExpression{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot(depth int) (Expression, error) {
+	if depth > maxExpressionDepth {
+		return nil, fmt.Errorf("expression is nested more than %d levels deep", maxExpressionDepth)
+	}
+	if p.peek().kind == tokenNot {
+		p.next()
+		expr, err := p.parseNot(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return notExpression{expr: expr}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *parser) parsePrimary(depth int) (Expression, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenLParen:
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" at position %d, got %s", closing.pos, closing)
+		}
+		return expr, nil
+	case tokenWord, tokenString:
+		return p.parseComparison(tok)
+	default:
+		return nil, fmt.Errorf("expected a claim at position %d, got %s", tok.pos, tok)
+	}
+}
+
+func (p *parser) parseComparison(claim token) (Expression, error) {
+	op := p.next()
+	switch op.kind {
+	case tokenEquals, tokenNotEquals, tokenContains:
+	default:
+		return nil, fmt.Errorf("expected an operator (==, != or contains) after claim %s at position %d, got %s", claim, op.pos, op)
+	}
+
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, fmt.Errorf("expected a value after %s at position %d, got %s", op, value.pos, value)
+	}
+
+	return comparisonExpression{
+		claim:    claim.value,
+		operator: op.value,
+		value:    value.value,
+	}, nil
+}
@@ -0,0 +1,183 @@
+package authorization
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expression Tests", func() {
+	claims := map[string][]string{
+		"department": {"engineering"},
+		"suspended":  {"false"},
+		"groups":     {"admins", "developers"},
+		"email":      {"alice@example.com"},
+		"name":       {"Alice Smith"},
+		"a":          {"1"},
+		"b":          {"2"},
+		"c":          {"3"},
+	}
+	getClaim := func(claim string) []string {
+		return claims[claim]
+	}
+
+	type evaluateTableInput struct {
+		expression string
+		expected   bool
+	}
+
+	DescribeTable("Evaluate",
+		func(in evaluateTableInput) {
+			expr, err := ParseExpression(in.expression)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(expr.Evaluate(getClaim)).To(Equal(in.expected))
+		},
+		Entry("with a matching equals", evaluateTableInput{
+			expression: "department == engineering",
+			expected:   true,
+		}),
+		Entry("with a non matching equals", evaluateTableInput{
+			expression: "department == sales",
+			expected:   false,
+		}),
+		Entry("with a matching not equals", evaluateTableInput{
+			expression: "department != sales",
+			expected:   true,
+		}),
+		Entry("with a non matching not equals", evaluateTableInput{
+			expression: "department != engineering",
+			expected:   false,
+		}),
+		Entry("with equals on a multi valued claim", evaluateTableInput{
+			expression: "groups == admins",
+			expected:   false,
+		}),
+		Entry("with not equals on a multi valued claim", evaluateTableInput{
+			expression: "groups != admins",
+			expected:   true,
+		}),
+		Entry("with contains on a multi valued claim", evaluateTableInput{
+			expression: "groups contains developers",
+			expected:   true,
+		}),
+		Entry("with contains a missing value", evaluateTableInput{
+			expression: "groups contains sales",
+			expected:   false,
+		}),
+		Entry("with contains on a single valued claim", evaluateTableInput{
+			expression: "department contains engineering",
+			expected:   true,
+		}),
+		Entry("with equals on a missing claim", evaluateTableInput{
+			expression: "missing == engineering",
+			expected:   false,
+		}),
+		Entry("with not equals on a missing claim", evaluateTableInput{
+			expression: "missing != engineering",
+			expected:   true,
+		}),
+		Entry("with contains on a missing claim", evaluateTableInput{
+			expression: "missing contains engineering",
+			expected:   false,
+		}),
+		Entry("with a quoted value", evaluateTableInput{
+			expression: `name == "Alice Smith"`,
+			expected:   true,
+		}),
+		Entry("with an escaped quote", evaluateTableInput{
+			expression: `name == "Alice \"Smith\""`,
+			expected:   false,
+		}),
+		Entry("with a quoted claim", evaluateTableInput{
+			expression: `"email" == alice@example.com`,
+			expected:   true,
+		}),
+		Entry("with values that are case sensitive", evaluateTableInput{
+			expression: "department == Engineering",
+			expected:   false,
+		}),
+		Entry("with an or", evaluateTableInput{
+			expression: "department == sales || groups contains admins",
+			expected:   true,
+		}),
+		Entry("with an and", evaluateTableInput{
+			expression: "department == engineering && suspended == true",
+			expected:   false,
+		}),
+		Entry("with a not", evaluateTableInput{
+			expression: "!suspended == true",
+			expected:   true,
+		}),
+		Entry("with a double not", evaluateTableInput{
+			expression: "!!suspended == true",
+			expected:   false,
+		}),
+		Entry("with keywords", evaluateTableInput{
+			expression: "department == engineering AND not suspended == true Or department == sales",
+			expected:   true,
+		}),
+		Entry("with and binding tighter than or", evaluateTableInput{
+			expression: "a == 1 || b == 0 && c == 0",
+			expected:   true,
+		}),
+		Entry("with and binding tighter than a leading or", evaluateTableInput{
+			expression: "a == 0 && b == 2 || c == 3",
+			expected:   true,
+		}),
+		Entry("with parentheses overriding precedence", evaluateTableInput{
+			expression: "(a == 1 || b == 0) && c == 0",
+			expected:   false,
+		}),
+		Entry("with not binding tighter than and", evaluateTableInput{
+			expression: "!a == 0 && b == 2",
+			expected:   true,
+		}),
+		Entry("with not applied to a group", evaluateTableInput{
+			expression: "!(a == 1 && b == 2)",
+			expected:   false,
+		}),
+		Entry("with nested parentheses", evaluateTableInput{
+			expression: "((a == 1) && ((b == 2) || c == 0))",
+			expected:   true,
+		}),
+	)
+
+	DescribeTable("ParseExpression errors",
+		func(expression string, expectedError string) {
+			_, err := ParseExpression(expression)
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("with an empty expression", "",
+			"expected a claim at position 0, got end of expression"),
+		Entry("with a missing operator", "department engineering",
+			"expected an operator (==, != or contains) after claim \"department\" at position 11, got \"engineering\""),
+		Entry("with a missing value", "department ==",
+			"expected a value after \"==\" at position 13, got end of expression"),
+		Entry("with a missing right hand side", "department == engineering &&",
+			"expected a claim at position 28, got end of expression"),
+		Entry("with an unclosed parenthesis", "(department == engineering",
+			"expected \")\" at position 26, got end of expression"),
+		Entry("with an unopened parenthesis", "department == engineering)",
+			"unexpected \")\" at position 25"),
+		Entry("with an unclosed string", `name == "Alice`,
+			"invalid string at position 8: missing closing quote"),
+		Entry("with an invalid character", "department = engineering",
+			"unexpected character '=' at position 11"),
+		Entry("with a single ampersand", "a == 1 & b == 2",
+			"unexpected character '&' at position 7"),
+		Entry("with a keyword as a value", "department == and",
+			"expected a value after \"==\" at position 14, got \"and\""),
+		Entry("with deeply nested parentheses",
+			strings.Repeat("(", maxExpressionDepth+1)+"a == 1"+strings.Repeat(")", maxExpressionDepth+1),
+			"expression is nested more than 32 levels deep"),
+		Entry("with deeply nested negations", strings.Repeat("!", maxExpressionDepth+1)+"a == 1",
+			"expression is nested more than 32 levels deep"),
+	)
+
+	It("allows expressions nested up to the maximum depth", func() {
+		_, err := ParseExpression(strings.Repeat("(", maxExpressionDepth) + "a == 1" + strings.Repeat(")", maxExpressionDepth))
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
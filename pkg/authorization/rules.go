@@ -0,0 +1,61 @@
+package authorization
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// Rules is an ordered list of allow and deny rules.
+// The first rule with a matching expression decides whether a session is
+// authorized, sessions that match no rule are denied.
+type Rules []rule
+
+type rule struct {
+	allow      bool
+	expression Expression
+}
+
+// NewRules parses the expressions of the configured rules
+func NewRules(ruleOpts []options.AuthorizationRule) (Rules, error) {
+	rules := make(Rules, 0, len(ruleOpts))
+	for i, ruleOpt := range ruleOpts {
+		var allow bool
+		switch ruleOpt.Action {
+		case options.AuthorizationRuleAllow:
+			allow = true
+		case options.AuthorizationRuleDeny:
+			allow = false
+		default:
+			return nil, fmt.Errorf("authorization rule %d has invalid action %q: must be one of %q or %q",
+				i, ruleOpt.Action, options.AuthorizationRuleAllow, options.AuthorizationRuleDeny)
+		}
+
+		expression, err := ParseExpression(ruleOpt.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("authorization rule %d has invalid expression %q: %v", i, ruleOpt.Expression, err)
+		}
+		rules = append(rules, rule{allow: allow, expression: expression})
+	}
+	return rules, nil
+}
+
+// Authorize evaluates the rules against the claims of the session.
+func (r Rules) Authorize(s *sessions.SessionState) bool {
+	for _, rule := range r {
+		if rule.expression.Evaluate(s.GetClaim) {
+			return rule.allow
+		}
+	}
+	return false
+}
+
+// Claims returns the names of the claims read by any of the rules
+func (r Rules) Claims() []string {
+	claims := []string{}
+	for _, rule := range r {
+		claims = append(claims, rule.expression.Claims()...)
+	}
+	return claims
+}
@@ -0,0 +1,154 @@
+package authorization
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules Tests", func() {
+	// idToken builds an unsigned ID token, session claims are not verified
+	// when they are read from the session
+	idToken := func(claims map[string]interface{}) string {
+		payload, err := json.Marshal(claims)
+		Expect(err).ToNot(HaveOccurred())
+		return "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+	}
+
+	exampleRules := []options.AuthorizationRule{
+		{
+			Action:     options.AuthorizationRuleDeny,
+			Expression: "suspended == true",
+		},
+		{
+			Action:     options.AuthorizationRuleAllow,
+			Expression: "department == engineering || groups contains admins",
+		},
+	}
+
+	type authorizeTableInput struct {
+		rules    []options.AuthorizationRule
+		session  func() *sessions.SessionState
+		expected bool
+	}
+
+	DescribeTable("Authorize",
+		func(in authorizeTableInput) {
+			rules, err := NewRules(in.rules)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rules.Authorize(in.session())).To(Equal(in.expected))
+		},
+		Entry("with a claim matching an allow rule", authorizeTableInput{
+			rules: exampleRules,
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					IDToken: idToken(map[string]interface{}{"department": "engineering"}),
+				}
+			},
+			expected: true,
+		}),
+		Entry("with a group matching an allow rule", authorizeTableInput{
+			rules: exampleRules,
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					Groups: []string{"developers", "admins"},
+				}
+			},
+			expected: true,
+		}),
+		Entry("with a boolean claim matching an earlier deny rule", authorizeTableInput{
+			rules: exampleRules,
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					IDToken: idToken(map[string]interface{}{"department": "engineering", "suspended": true}),
+				}
+			},
+			expected: false,
+		}),
+		Entry("with a later deny rule that also matches", authorizeTableInput{
+			rules: []options.AuthorizationRule{
+				{
+					Action:     options.AuthorizationRuleAllow,
+					Expression: "groups contains admins",
+				},
+				{
+					Action:     options.AuthorizationRuleDeny,
+					Expression: "groups contains admins",
+				},
+			},
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					Groups: []string{"admins"},
+				}
+			},
+			expected: true,
+		}),
+		Entry("with no matching rules", authorizeTableInput{
+			rules: exampleRules,
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					IDToken: idToken(map[string]interface{}{"department": "sales"}),
+					Groups:  []string{"developers"},
+				}
+			},
+			expected: false,
+		}),
+		Entry("with a multi valued claim from the ID token", authorizeTableInput{
+			rules: []options.AuthorizationRule{
+				{
+					Action:     options.AuthorizationRuleAllow,
+					Expression: "roles contains reader",
+				},
+			},
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					IDToken: idToken(map[string]interface{}{"roles": []string{"writer", "reader"}}),
+				}
+			},
+			expected: true,
+		}),
+		Entry("with no rules", authorizeTableInput{
+			rules: []options.AuthorizationRule{},
+			session: func() *sessions.SessionState {
+				return &sessions.SessionState{
+					Email: "alice@example.com",
+				}
+			},
+			expected: false,
+		}),
+	)
+
+	DescribeTable("NewRules errors",
+		func(ruleOpts []options.AuthorizationRule, expectedError string) {
+			_, err := NewRules(ruleOpts)
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("with an invalid action",
+			[]options.AuthorizationRule{
+				{Action: options.AuthorizationRuleAllow, Expression: "department == engineering"},
+				{Action: "permit", Expression: "department == engineering"},
+			},
+			"authorization rule 1 has invalid action \"permit\": must be one of \"allow\" or \"deny\"",
+		),
+		Entry("with an invalid expression",
+			[]options.AuthorizationRule{
+				{Action: options.AuthorizationRuleDeny, Expression: "department = engineering"},
+			},
+			"authorization rule 0 has invalid expression \"department = engineering\": unexpected character '=' at position 11",
+		),
+	)
+
+	It("Claims returns the claims read by the rules", func() {
+		rules, err := NewRules(append(exampleRules, options.AuthorizationRule{
+			Action:     options.AuthorizationRuleDeny,
+			Expression: "!(email == \"bob@example.com\" and not roles contains writer)",
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rules.Claims()).To(Equal([]string{"suspended", "department", "groups", "email", "roles"}))
+	})
+})
@@ -0,0 +1,88 @@
+package authorization
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// AuthenticationRequirement is the level of authentication a session must
+// have had with the provider, eg to access an upstream
+type AuthenticationRequirement struct {
+	// ACRValues are the accepted values of the acr claim, in order of
+	// preference. Any acr is accepted when empty.
+	ACRValues []string
+	// MaxAge is the maximum time since the user authenticated, there is
+	// no limit when zero.
+	MaxAge time.Duration
+}
+
+// IsZero returns true when there is no requirement
+func (r AuthenticationRequirement) IsZero() bool {
+	return len(r.ACRValues) == 0 && r.MaxAge == 0
+}
+
+// SatisfiedBy checks the session was authenticated with one of the accepted
+// acr values within the max age
+func (r AuthenticationRequirement) SatisfiedBy(s *sessions.SessionState, now time.Time) bool {
+	if r.IsZero() {
+		return true
+	}
+	if s == nil {
+		return false
+	}
+
+	if len(r.ACRValues) > 0 && !r.acceptsACR(s.GetClaim("acr")) {
+		return false
+	}
+
+	if r.MaxAge > 0 {
+		authTime, ok := sessionAuthTime(s)
+		if !ok || now.Sub(authTime) > r.MaxAge {
+			return false
+		}
+	}
+	return true
+}
+
+func (r AuthenticationRequirement) acceptsACR(acr []string) bool {
+	if len(acr) != 1 {
+		return false
+	}
+	for _, value := range r.ACRValues {
+		if value == acr[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginParams returns the login URL parameters that request the provider
+// authenticates the user to meet the requirement
+func (r AuthenticationRequirement) LoginParams() url.Values {
+	params := url.Values{}
+	if len(r.ACRValues) > 0 {
+		params.Set("acr_values", strings.Join(r.ACRValues, " "))
+	}
+	if r.MaxAge > 0 {
+		params.Set("max_age", strconv.FormatInt(int64(r.MaxAge.Seconds()), 10))
+	}
+	return params
+}
+
+// sessionAuthTime returns the auth_time claim of the ID token, falling back
+// to the time the session was created
+func sessionAuthTime(s *sessions.SessionState) (time.Time, bool) {
+	if claim := s.GetClaim("auth_time"); len(claim) == 1 {
+		if authTime, err := strconv.ParseInt(claim[0], 10, 64); err == nil {
+			return time.Unix(authTime, 0), true
+		}
+	}
+	if s.CreatedAt != nil && !s.CreatedAt.IsZero() {
+		return *s.CreatedAt, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,110 @@
+package authorization
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Step Up Tests", func() {
+	now := time.Unix(1700000000, 0)
+	createdAt := now.Add(-time.Hour)
+
+	session := func(claims map[string]interface{}) *sessions.SessionState {
+		payload, err := json.Marshal(claims)
+		Expect(err).ToNot(HaveOccurred())
+		return &sessions.SessionState{
+			CreatedAt: &createdAt,
+			IDToken:   "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".signature",
+		}
+	}
+
+	mfa := AuthenticationRequirement{ACRValues: []string{"urn:example:mfa", "urn:example:hwk"}}
+
+	type satisfiedByTableInput struct {
+		requirement AuthenticationRequirement
+		session     *sessions.SessionState
+		expected    bool
+	}
+
+	DescribeTable("SatisfiedBy",
+		func(in satisfiedByTableInput) {
+			Expect(in.requirement.SatisfiedBy(in.session, now)).To(Equal(in.expected))
+		},
+		Entry("with no requirement", satisfiedByTableInput{
+			requirement: AuthenticationRequirement{},
+			session:     nil,
+			expected:    true,
+		}),
+		Entry("with the preferred acr", satisfiedByTableInput{
+			requirement: mfa,
+			session:     session(map[string]interface{}{"acr": "urn:example:mfa"}),
+			expected:    true,
+		}),
+		Entry("with another accepted acr", satisfiedByTableInput{
+			requirement: mfa,
+			session:     session(map[string]interface{}{"acr": "urn:example:hwk"}),
+			expected:    true,
+		}),
+		Entry("with a weaker acr", satisfiedByTableInput{
+			requirement: mfa,
+			session:     session(map[string]interface{}{"acr": "urn:example:pwd"}),
+			expected:    false,
+		}),
+		Entry("without an acr", satisfiedByTableInput{
+			requirement: mfa,
+			session:     session(map[string]interface{}{}),
+			expected:    false,
+		}),
+		Entry("without a session", satisfiedByTableInput{
+			requirement: mfa,
+			session:     nil,
+			expected:    false,
+		}),
+		Entry("with a recent auth_time", satisfiedByTableInput{
+			requirement: AuthenticationRequirement{MaxAge: 5 * time.Minute},
+			session:     session(map[string]interface{}{"auth_time": now.Add(-time.Minute).Unix()}),
+			expected:    true,
+		}),
+		Entry("with an old auth_time", satisfiedByTableInput{
+			requirement: AuthenticationRequirement{MaxAge: 5 * time.Minute},
+			session:     session(map[string]interface{}{"auth_time": now.Add(-10 * time.Minute).Unix()}),
+			expected:    false,
+		}),
+		Entry("with an old session and no auth_time", satisfiedByTableInput{
+			requirement: AuthenticationRequirement{MaxAge: 5 * time.Minute},
+			session:     session(map[string]interface{}{}),
+			expected:    false,
+		}),
+		Entry("with a recent session and no auth_time", satisfiedByTableInput{
+			requirement: AuthenticationRequirement{MaxAge: 2 * time.Hour},
+			session:     session(map[string]interface{}{}),
+			expected:    true,
+		}),
+		Entry("with an accepted acr and an old auth_time", satisfiedByTableInput{
+			requirement: AuthenticationRequirement{ACRValues: mfa.ACRValues, MaxAge: 5 * time.Minute},
+			session:     session(map[string]interface{}{"acr": "urn:example:mfa", "auth_time": now.Add(-10 * time.Minute).Unix()}),
+			expected:    false,
+		}),
+	)
+
+	DescribeTable("LoginParams",
+		func(requirement AuthenticationRequirement, expected url.Values) {
+			Expect(requirement.LoginParams()).To(Equal(expected))
+		},
+		Entry("with no requirement", AuthenticationRequirement{}, url.Values{}),
+		Entry("with acr values", mfa, url.Values{
+			"acr_values": []string{"urn:example:mfa urn:example:hwk"},
+		}),
+		Entry("with a max age", AuthenticationRequirement{ACRValues: []string{"urn:example:mfa"}, MaxAge: 5 * time.Minute}, url.Values{
+			"acr_values": []string{"urn:example:mfa"},
+			"max_age":    []string{"300"},
+		}),
+	)
+})
@@ -1,6 +1,7 @@
 package clock
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -99,6 +100,25 @@ func (c *Clock) After(d time.Duration) <-chan time.Time {
 	return m.After(d)
 }
 
+// AfterContext waits for the duration to elapse and then sends the current
+// time on the returned channel. If the context is done before the duration
+// elapses, the underlying timer is stopped and the returned channel is closed
+// without a value being sent.
+func (c *Clock) AfterContext(ctx context.Context, d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	timer := c.Timer(d)
+	go func() {
+		defer timer.Stop()
+		select {
+		case t := <-timer.C:
+			ch <- t
+		case <-ctx.Done():
+			close(ch)
+		}
+	}()
+	return ch
+}
+
 func (c *Clock) AfterFunc(d time.Duration, f func()) *clockapi.Timer {
 	m := c.mock
 	if m == nil {
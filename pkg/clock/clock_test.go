@@ -1,6 +1,7 @@
 package clock_test
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -84,6 +85,19 @@ var _ = Describe("Clock suite", func() {
 				Expect(atomic.LoadInt32(&tolerance)).To(Equal(outsideTolerance))
 			})
 
+			It("uses time.After for AfterContext", func() {
+				ch := testClock.AfterContext(context.Background(), 20*time.Millisecond)
+				Consistently(ch, 10*time.Millisecond).ShouldNot(Receive())
+				Eventually(ch).Should(Receive())
+			})
+
+			It("closes the AfterContext channel when the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				ch := testClock.AfterContext(ctx, time.Hour)
+				cancel()
+				Eventually(ch).Should(BeClosed())
+			})
+
 			It("uses time.Now for Now", func() {
 				a := time.Now()
 				b := testClock.Now()
@@ -191,6 +205,30 @@ var _ = Describe("Clock suite", func() {
 				Expect(atomic.LoadInt32(&after)).To(Equal(int32(1)))
 			})
 
+			It("mocks AfterContext", func() {
+				ch := testClock.AfterContext(context.Background(), 10*time.Second)
+
+				err := testClock.Add(9 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Consistently(ch, 10*time.Millisecond).ShouldNot(Receive())
+
+				err = testClock.Add(1 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(ch).Should(Receive(Equal(now.Add(10 * time.Second))))
+			})
+
+			It("closes the AfterContext channel when the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				ch := testClock.AfterContext(ctx, 10*time.Second)
+
+				err := testClock.Add(9 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Consistently(ch, 10*time.Millisecond).ShouldNot(Receive())
+
+				cancel()
+				Eventually(ch).Should(BeClosed())
+			})
+
 			It("mocks AfterFunc", func() {
 				var after int32
 				testClock.AfterFunc(10*time.Second, func() {
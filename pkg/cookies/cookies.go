@@ -12,17 +12,28 @@ import (
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 )
 
-// MakeCookieFromOptions constructs a cookie based on the given *options.CookieOptions,
+// MakeCookieFromOptions constructs a session cookie based on the given *options.CookieOptions,
 // value and creation time
 func MakeCookieFromOptions(req *http.Request, name string, value string, opts *options.Cookie, expiration time.Duration, now time.Time) *http.Cookie {
+	return makeCookie(req, name, value, opts, opts.SessionCookieSameSite(), expiration, now)
+}
+
+// MakeCSRFCookieFromOptions constructs a CSRF cookie based on the given
+// *options.CookieOptions, value and creation time. It differs from the session
+// cookies only in its SameSite attribute.
+func MakeCSRFCookieFromOptions(req *http.Request, name string, value string, opts *options.Cookie, expiration time.Duration, now time.Time) *http.Cookie {
+	return makeCookie(req, name, value, opts, opts.CSRFCookieSameSite(), expiration, now)
+}
+
+func makeCookie(req *http.Request, name string, value string, opts *options.Cookie, sameSite string, expiration time.Duration, now time.Time) *http.Cookie {
 	domain := GetCookieDomain(req, opts.Domains)
-	// If nothing matches, create the cookie with the shortest domain
+	// If nothing matches, create a host-only cookie, as browsers reject
+	// cookies for a domain that does not match the request host
 	if domain == "" && len(opts.Domains) > 0 {
-		logger.Errorf("Warning: request host %q did not match any of the specific cookie domains of %q",
+		logger.Errorf("Warning: request host %q did not match any of the specific cookie domains of %q, using a host-only cookie",
 			requestutil.GetRequestHost(req),
 			strings.Join(opts.Domains, ","),
 		)
-		domain = opts.Domains[len(opts.Domains)-1]
 	}
 
 	c := &http.Cookie{
@@ -33,7 +44,7 @@ func MakeCookieFromOptions(req *http.Request, name string, value string, opts *o
 		Expires:  now.Add(expiration),
 		HttpOnly: opts.HTTPOnly,
 		Secure:   opts.Secure,
-		SameSite: ParseSameSite(opts.SameSite),
+		SameSite: ParseSameSite(sameSite),
 	}
 
 	warnInvalidDomain(c, req)
@@ -41,16 +52,52 @@ func MakeCookieFromOptions(req *http.Request, name string, value string, opts *o
 	return c
 }
 
-// GetCookieDomain returns the correct cookie domain given a list of domains
-// by checking the X-Fowarded-Host and host header of an an http request
+// SetCookie adds a Set-Cookie header for the cookie to the response.
+// When opts.Partitioned is set, the Partitioned attribute is appended to the
+// header, as http.Cookie does not support it on all supported Go versions.
+func SetCookie(rw http.ResponseWriter, c *http.Cookie, opts *options.Cookie) {
+	v := c.String()
+	if v == "" {
+		return
+	}
+	if opts.Partitioned && !strings.Contains(v, "; Partitioned") {
+		v += "; Partitioned"
+	}
+	rw.Header().Add("Set-Cookie", v)
+}
+
+// GetCookieDomain returns the most specific of the cookie domains that
+// matches the X-Forwarded-Host or host header of an http request, or an
+// empty string if none of them match
 func GetCookieDomain(req *http.Request, cookieDomains []string) string {
-	host := requestutil.GetRequestHost(req)
+	host := requestHostname(req)
+	match := ""
 	for _, domain := range cookieDomains {
-		if strings.HasSuffix(host, domain) {
-			return domain
+		if cookieDomainMatches(host, domain) && len(strings.TrimPrefix(domain, ".")) > len(strings.TrimPrefix(match, ".")) {
+			match = domain
 		}
 	}
-	return ""
+	return match
+}
+
+// cookieDomainMatches checks whether a cookie for the domain would be sent
+// for the host, that is the host is the domain or one of its subdomains.
+// A leading dot on the domain is ignored, as browsers do.
+func cookieDomainMatches(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" {
+		return false
+	}
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// requestHostname returns the lower case request host without any port
+func requestHostname(req *http.Request) string {
+	host := requestutil.GetRequestHost(req)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
 }
 
 // Parse a valid http.SameSite value from a user supplied string for use of making cookies.
@@ -76,11 +123,8 @@ func warnInvalidDomain(c *http.Cookie, req *http.Request) {
 		return
 	}
 
-	host := requestutil.GetRequestHost(req)
-	if h, _, err := net.SplitHostPort(host); err == nil {
-		host = h
-	}
-	if !strings.HasSuffix(host, c.Domain) {
+	host := requestHostname(req)
+	if !cookieDomainMatches(host, c.Domain) {
 		logger.Errorf("Warning: request host is %q but using configured cookie domain of %q", host, c.Domain)
 	}
 }
@@ -3,8 +3,11 @@ package cookies
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"time"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -74,6 +77,161 @@ var _ = Describe("Cookie Tests", func() {
 				cookieDomains:  []string{".cookies.wrong", ".cookies.false"},
 				expectedOutput: "",
 			}),
+			Entry("the most specific of overlapping domains is used", getCookieDomainTableInput{
+				host:           "app.eu.cookies.test",
+				cookieDomains:  []string{".cookies.test", ".eu.cookies.test", ".brand.test"},
+				expectedOutput: ".eu.cookies.test",
+			}),
+			Entry("the matching domain of another brand is used", getCookieDomainTableInput{
+				host:           "login.brand.test",
+				cookieDomains:  []string{".cookies.test", ".eu.cookies.test", ".brand.test"},
+				expectedOutput: ".brand.test",
+			}),
+			Entry("a domain matches its apex host", getCookieDomainTableInput{
+				host:           "cookies.test",
+				cookieDomains:  []string{".cookies.test"},
+				expectedOutput: ".cookies.test",
+			}),
+			Entry("the port of the host is ignored", getCookieDomainTableInput{
+				host:           "www.cookies.test:4180",
+				cookieDomains:  []string{".cookies.test"},
+				expectedOutput: ".cookies.test",
+			}),
+			Entry("a domain does not match a host that only ends with it", getCookieDomainTableInput{
+				host:           "www.evilcookies.test",
+				cookieDomains:  []string{"cookies.test"},
+				expectedOutput: "",
+			}),
+		)
+	})
+
+	Context("MakeCookieFromOptions", func() {
+		type makeCookieTableInput struct {
+			host           string
+			cookieDomains  []string
+			expectedDomain string
+		}
+
+		DescribeTable("should set the cookie domain for the request host",
+			func(in makeCookieTableInput) {
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/", in.host), nil)
+				opts := &options.Cookie{
+					Domains: in.cookieDomains,
+					Path:    "/",
+				}
+
+				c := MakeCookieFromOptions(req, "_oauth2_proxy", "value", opts, time.Hour, time.Now())
+				Expect(c.Domain).To(Equal(in.expectedDomain))
+			},
+			Entry("without cookie domains", makeCookieTableInput{
+				host:           "www.cookies.test",
+				expectedDomain: "",
+			}),
+			Entry("with a matching cookie domain", makeCookieTableInput{
+				host:           "www.cookies.test",
+				cookieDomains:  []string{".brand.test", ".cookies.test"},
+				expectedDomain: ".cookies.test",
+			}),
+			Entry("with a request to an unlisted host", makeCookieTableInput{
+				host:           "www.unlisted.test",
+				cookieDomains:  []string{".brand.test", ".cookies.test"},
+				expectedDomain: "",
+			}),
 		)
 	})
+
+	Context("SameSite", func() {
+		type sameSiteTableInput struct {
+			opts                  *options.Cookie
+			expectedSessionHeader string
+			expectedCSRFHeader    string
+		}
+
+		DescribeTable("should set the SameSite attribute of each cookie type",
+			func(in sameSiteTableInput) {
+				req := httptest.NewRequest(http.MethodGet, "https://www.cookies.test/", nil)
+				in.opts.Path = "/"
+				in.opts.Secure = true
+				in.opts.HTTPOnly = true
+
+				rw := httptest.NewRecorder()
+				SetCookie(rw, MakeCookieFromOptions(req, "_oauth2_proxy", "session", in.opts, 0, time.Time{}), in.opts)
+				SetCookie(rw, MakeCSRFCookieFromOptions(req, "_oauth2_proxy_csrf", "csrf", in.opts, 0, time.Time{}), in.opts)
+
+				Expect(rw.Header().Values("Set-Cookie")).To(Equal([]string{
+					in.expectedSessionHeader,
+					in.expectedCSRFHeader,
+				}))
+			},
+			Entry("with a single SameSite", sameSiteTableInput{
+				opts:                  &options.Cookie{SameSite: "strict"},
+				expectedSessionHeader: "_oauth2_proxy=session; Path=/; HttpOnly; Secure; SameSite=Strict",
+				expectedCSRFHeader:    "_oauth2_proxy_csrf=csrf; Path=/; HttpOnly; Secure; SameSite=Strict",
+			}),
+			Entry("with a CSRF SameSite", sameSiteTableInput{
+				opts:                  &options.Cookie{SameSite: "lax", CSRFSameSite: "none"},
+				expectedSessionHeader: "_oauth2_proxy=session; Path=/; HttpOnly; Secure; SameSite=Lax",
+				expectedCSRFHeader:    "_oauth2_proxy_csrf=csrf; Path=/; HttpOnly; Secure; SameSite=None",
+			}),
+			Entry("with a session SameSite", sameSiteTableInput{
+				opts:                  &options.Cookie{SessionSameSite: "lax"},
+				expectedSessionHeader: "_oauth2_proxy=session; Path=/; HttpOnly; Secure; SameSite=Lax",
+				expectedCSRFHeader:    "_oauth2_proxy_csrf=csrf; Path=/; HttpOnly; Secure",
+			}),
+			Entry("with both SameSites", sameSiteTableInput{
+				opts:                  &options.Cookie{SameSite: "strict", SessionSameSite: "lax", CSRFSameSite: "none"},
+				expectedSessionHeader: "_oauth2_proxy=session; Path=/; HttpOnly; Secure; SameSite=Lax",
+				expectedCSRFHeader:    "_oauth2_proxy_csrf=csrf; Path=/; HttpOnly; Secure; SameSite=None",
+			}),
+		)
+	})
+
+	Context("SetCookie", func() {
+		type setCookieTableInput struct {
+			cookie         *http.Cookie
+			opts           *options.Cookie
+			expectedHeader []string
+		}
+
+		DescribeTable("should write the raw Set-Cookie header",
+			func(in setCookieTableInput) {
+				rw := httptest.NewRecorder()
+				SetCookie(rw, in.cookie, in.opts)
+
+				Expect(rw.Header().Values("Set-Cookie")).To(Equal(in.expectedHeader))
+			},
+			Entry("without partitioned", setCookieTableInput{
+				cookie:         &http.Cookie{Name: "_oauth2_proxy", Value: "value", Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteNoneMode},
+				opts:           &options.Cookie{},
+				expectedHeader: []string{"_oauth2_proxy=value; Path=/; HttpOnly; Secure; SameSite=None"},
+			}),
+			Entry("with partitioned", setCookieTableInput{
+				cookie:         &http.Cookie{Name: "_oauth2_proxy", Value: "value", Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteNoneMode},
+				opts:           &options.Cookie{Partitioned: true},
+				expectedHeader: []string{"_oauth2_proxy=value; Path=/; HttpOnly; Secure; SameSite=None; Partitioned"},
+			}),
+			Entry("with partitioned and an expiry", setCookieTableInput{
+				cookie:         &http.Cookie{Name: "_oauth2_proxy_csrf", Value: "", Path: "/", Expires: time.Unix(0, 0), Secure: true},
+				opts:           &options.Cookie{Partitioned: true},
+				expectedHeader: []string{"_oauth2_proxy_csrf=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Secure; Partitioned"},
+			}),
+			Entry("with an invalid cookie name", setCookieTableInput{
+				cookie:         &http.Cookie{Name: "_oauth2;proxy", Value: "value"},
+				opts:           &options.Cookie{Partitioned: true},
+				expectedHeader: nil,
+			}),
+		)
+
+		It("should add a header for each cookie", func() {
+			rw := httptest.NewRecorder()
+			opts := &options.Cookie{Partitioned: true}
+			SetCookie(rw, &http.Cookie{Name: "_oauth2_proxy_0", Value: "a"}, opts)
+			SetCookie(rw, &http.Cookie{Name: "_oauth2_proxy_1", Value: "b"}, opts)
+
+			Expect(rw.Header().Values("Set-Cookie")).To(Equal([]string{
+				"_oauth2_proxy_0=a; Partitioned",
+				"_oauth2_proxy_1=b; Partitioned",
+			}))
+		})
+	})
 })
@@ -21,6 +21,11 @@ type CSRF interface {
 	CheckOAuthState(string) bool
 	CheckOIDCNonce(string) bool
 	GetCodeVerifier() string
+	GetStepUp() ([]string, time.Duration)
+	IsSilent() bool
+
+	SetStepUp(acrValues []string, maxAge time.Duration)
+	SetSilent(silent bool)
 
 	SetSessionNonce(s *sessions.SessionState)
 
@@ -44,6 +49,16 @@ type csrf struct {
 	// authentication code.
 	CodeVerifier string `msgpack:"cv,omitempty"`
 
+	// ACRValues and MaxAge hold the authentication requirements requested
+	// from the IdP when stepping up the authentication of a session, the
+	// callback checks the new session meets them.
+	ACRValues []string      `msgpack:"acr,omitempty"`
+	MaxAge    time.Duration `msgpack:"ma,omitempty"`
+
+	// Silent marks a silent re-authentication with prompt=none, the callback
+	// starts an interactive login when the IdP requires the user to log in.
+	Silent bool `msgpack:"si,omitempty"`
+
 	cookieOpts *options.Cookie
 	time       clock.Clock
 }
@@ -100,6 +115,27 @@ func (c *csrf) GetCodeVerifier() string {
 	return c.CodeVerifier
 }
 
+// GetStepUp returns the authentication requirements requested from the IdP
+func (c *csrf) GetStepUp() ([]string, time.Duration) {
+	return c.ACRValues, c.MaxAge
+}
+
+// SetStepUp sets the authentication requirements requested from the IdP
+func (c *csrf) SetStepUp(acrValues []string, maxAge time.Duration) {
+	c.ACRValues = acrValues
+	c.MaxAge = maxAge
+}
+
+// IsSilent returns whether the authentication was requested with prompt=none
+func (c *csrf) IsSilent() bool {
+	return c.Silent
+}
+
+// SetSilent sets whether the authentication is requested with prompt=none
+func (c *csrf) SetSilent(silent bool) {
+	c.Silent = silent
+}
+
 // HashOAuthState returns the hash of the OAuth state nonce
 func (c *csrf) HashOAuthState() string {
 	return encryption.HashNonce(c.OAuthState)
@@ -133,7 +169,7 @@ func (c *csrf) SetCookie(rw http.ResponseWriter, req *http.Request) (*http.Cooki
 		return nil, err
 	}
 
-	cookie := MakeCookieFromOptions(
+	cookie := MakeCSRFCookieFromOptions(
 		req,
 		c.cookieName(),
 		encoded,
@@ -141,21 +177,21 @@ func (c *csrf) SetCookie(rw http.ResponseWriter, req *http.Request) (*http.Cooki
 		c.cookieOpts.CSRFExpire,
 		c.time.Now(),
 	)
-	http.SetCookie(rw, cookie)
+	SetCookie(rw, cookie, c.cookieOpts)
 
 	return cookie, nil
 }
 
 // ClearCookie removes the CSRF cookie
 func (c *csrf) ClearCookie(rw http.ResponseWriter, req *http.Request) {
-	http.SetCookie(rw, MakeCookieFromOptions(
+	SetCookie(rw, MakeCSRFCookieFromOptions(
 		req,
 		c.cookieName(),
 		"",
 		c.cookieOpts,
 		time.Hour*-1,
 		c.time.Now(),
-	))
+	), c.cookieOpts)
 }
 
 // encodeCookie MessagePack encodes and encrypts the CSRF and then creates a
@@ -175,14 +211,17 @@ func (c *csrf) encodeCookie() (string, error) {
 }
 
 // decodeCSRFCookie validates the signature then decrypts and decodes a CSRF
-// cookie into a CSRF struct
+// cookie into a CSRF struct.
+// CSRF cookies older than the CSRF expiry are rejected, even if the browser
+// kept sending them.
 func decodeCSRFCookie(cookie *http.Cookie, opts *options.Cookie) (*csrf, error) {
-	val, _, ok := encryption.Validate(cookie, opts.Secret, opts.Expire)
+	secrets := opts.Secrets()
+	val, secret, ok := encryption.ValidateWithSecrets(cookie, secrets, opts.CSRFExpire)
 	if !ok {
 		return nil, errors.New("CSRF cookie failed validation")
 	}
 
-	decrypted, err := decrypt(val, opts)
+	decrypted, err := decrypt(val, secrets[secret], opts.EncryptionAlgorithm)
 	if err != nil {
 		return nil, err
 	}
@@ -208,9 +247,9 @@ func (c *csrf) cookieName() string {
 
 func csrfCookieName(opts *options.Cookie, stateSubstring string) string {
 	if stateSubstring == "" {
-		return fmt.Sprintf("%v_csrf", opts.Name)
+		return fmt.Sprintf("%v_csrf", opts.PrefixedName())
 	}
-	return fmt.Sprintf("%v_csrf_%v", opts.Name, stateSubstring)
+	return fmt.Sprintf("%v_csrf_%v", opts.PrefixedName(), stateSubstring)
 }
 
 // ExtractStateSubstring extract the initial state characters, to add it to the CSRF cookie name
@@ -229,21 +268,21 @@ func ExtractStateSubstring(req *http.Request) string {
 }
 
 func encrypt(data []byte, opts *options.Cookie) ([]byte, error) {
-	cipher, err := makeCipher(opts)
+	cipher, err := makeCipher(opts.Secret, opts.EncryptionAlgorithm)
 	if err != nil {
 		return nil, err
 	}
 	return cipher.Encrypt(data)
 }
 
-func decrypt(data []byte, opts *options.Cookie) ([]byte, error) {
-	cipher, err := makeCipher(opts)
+func decrypt(data []byte, secret, algorithm string) ([]byte, error) {
+	cipher, err := makeCipher(secret, algorithm)
 	if err != nil {
 		return nil, err
 	}
 	return cipher.Decrypt(data)
 }
 
-func makeCipher(opts *options.Cookie) (encryption.Cipher, error) {
-	return encryption.NewCFBCipher(encryption.SecretBytes(opts.Secret))
+func makeCipher(secret, algorithm string) (encryption.Cipher, error) {
+	return encryption.NewCookieCipher(algorithm, encryption.SecretBytes(secret))
 }
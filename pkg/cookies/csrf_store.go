@@ -0,0 +1,168 @@
+package cookies
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// storedCSRF is a CSRF that is kept in the session store instead of a cookie.
+// The OAuth state carries an opaque ID that the CSRF is stored under, only a
+// small cookie with the hash of the ID is set to bind it to the browser.
+type storedCSRF struct {
+	*csrf
+
+	id    string
+	store sessions.StateStore
+}
+
+// NewStoredCSRF creates a CSRF with random nonces that is saved to the store
+// by SetCookie instead of being set in the CSRF cookie
+func NewStoredCSRF(opts *options.Cookie, codeVerifier string, store sessions.StateStore) (CSRF, error) {
+	c, err := NewCSRF(opts, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := encryption.Nonce(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedCSRF{
+		csrf:  c.(*csrf),
+		id:    base64.RawURLEncoding.EncodeToString(id),
+		store: store,
+	}, nil
+}
+
+// LoadStoredCSRF loads the CSRF stored under the ID from the OAuth state.
+// The request must carry the binding cookie set when the CSRF was saved, so
+// that the state can only be used by the browser that started the login.
+// The CSRF is removed from the store so that it can only be used once.
+func LoadStoredCSRF(req *http.Request, store sessions.StateStore, id string, opts *options.Cookie) (CSRF, error) {
+	if err := checkStoredCSRFBinding(req, id, opts); err != nil {
+		return nil, err
+	}
+
+	stored, err := store.LoadAndClearState(req.Context(), id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading CSRF state: %v", err)
+	}
+
+	// The stored CSRF is signed like a cookie named after its ID, so that
+	// the secret it was encrypted with is known
+	secrets := opts.Secrets()
+	encrypted, secret, ok := encryption.ValidateWithSecrets(&http.Cookie{Name: id, Value: string(stored)}, secrets, opts.CSRFExpire)
+	if !ok {
+		return nil, errors.New("CSRF state failed validation")
+	}
+
+	decrypted, err := decrypt(encrypted, secrets[secret], opts.EncryptionAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &csrf{cookieOpts: opts}
+	if err := msgpack.Unmarshal(decrypted, c); err != nil {
+		return nil, fmt.Errorf("error unmarshalling data to CSRF: %v", err)
+	}
+
+	return &storedCSRF{
+		csrf:  c,
+		id:    id,
+		store: store,
+	}, nil
+}
+
+// checkStoredCSRFBinding checks the request carries a binding cookie signed
+// with one of the cookie secrets for the hash of the ID
+func checkStoredCSRFBinding(req *http.Request, id string, opts *options.Cookie) error {
+	cookie, err := req.Cookie(storedCSRFCookieName(opts, id))
+	if err != nil {
+		return fmt.Errorf("error loading CSRF binding cookie: %v", err)
+	}
+
+	hash, _, ok := encryption.ValidateWithSecrets(cookie, opts.Secrets(), opts.CSRFExpire)
+	if !ok || subtle.ConstantTimeCompare(hash, []byte(encryption.HashNonce([]byte(id)))) != 1 {
+		return errors.New("CSRF binding cookie failed validation")
+	}
+	return nil
+}
+
+// storedCSRFCookieName is the name of the cookie binding the stored CSRF to
+// the browser. Like the CSRF cookie, it only includes part of the hash of the
+// ID when a CSRF cookie is used per request.
+func storedCSRFCookieName(opts *options.Cookie, id string) string {
+	stateSubstring := ""
+	if opts.CSRFPerRequest {
+		stateSubstring = encryption.HashNonce([]byte(id))[0 : csrfStateLength-1]
+	}
+	return csrfCookieName(opts, stateSubstring)
+}
+
+// HashOAuthState returns the ID the CSRF is stored under to be sent in the
+// OAuth state
+func (c *storedCSRF) HashOAuthState() string {
+	return c.id
+}
+
+// CheckOAuthState compares the ID the CSRF is stored under against the OAuth
+// state
+func (c *storedCSRF) CheckOAuthState(id string) bool {
+	return subtle.ConstantTimeCompare([]byte(c.id), []byte(id)) == 1
+}
+
+// SetCookie saves the CSRF to the store until the CSRF expiry and sets a
+// small signed cookie with the hash of the ID, which binds the state to the
+// browser.
+func (c *storedCSRF) SetCookie(rw http.ResponseWriter, req *http.Request) (*http.Cookie, error) {
+	packed, err := msgpack.Marshal(c.csrf)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling CSRF to msgpack: %v", err)
+	}
+
+	encrypted, err := encrypt(packed, c.cookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := encryption.SignedValue(c.cookieOpts.Secret, c.id, encrypted, c.time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	name := storedCSRFCookieName(c.cookieOpts, c.id)
+	binding, err := encryption.SignedValue(c.cookieOpts.Secret, name, []byte(encryption.HashNonce([]byte(c.id))), c.time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.store.SaveState(req.Context(), c.id, []byte(signed), c.cookieOpts.CSRFExpire); err != nil {
+		return nil, fmt.Errorf("error saving CSRF state: %v", err)
+	}
+
+	cookie := MakeCSRFCookieFromOptions(req, name, binding, c.cookieOpts, c.cookieOpts.CSRFExpire, c.time.Now())
+	SetCookie(rw, cookie, c.cookieOpts)
+	return cookie, nil
+}
+
+// ClearCookie removes the binding cookie, the CSRF was removed from the store
+// when it was loaded
+func (c *storedCSRF) ClearCookie(rw http.ResponseWriter, req *http.Request) {
+	SetCookie(rw, MakeCSRFCookieFromOptions(
+		req,
+		storedCSRFCookieName(c.cookieOpts, c.id),
+		"",
+		c.cookieOpts,
+		time.Hour*-1,
+		c.time.Now(),
+	), c.cookieOpts)
+}
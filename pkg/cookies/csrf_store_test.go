@@ -0,0 +1,165 @@
+package cookies
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeStateStore is an in-memory sessions.StateStore
+type fakeStateStore struct {
+	states map[string][]byte
+	exp    time.Duration
+}
+
+func (s *fakeStateStore) SaveState(_ context.Context, id string, value []byte, exp time.Duration) error {
+	s.states[id] = value
+	s.exp = exp
+	return nil
+}
+
+func (s *fakeStateStore) LoadAndClearState(_ context.Context, id string) ([]byte, error) {
+	value, ok := s.states[id]
+	if !ok {
+		return nil, errors.New("state not found")
+	}
+	delete(s.states, id)
+	return value, nil
+}
+
+var _ = Describe("Stored CSRF Tests", func() {
+	var (
+		cookieOpts *options.Cookie
+		store      *fakeStateStore
+		publicCSRF CSRF
+	)
+
+	BeforeEach(func() {
+		cookieOpts = &options.Cookie{
+			Name:       cookieName,
+			Secret:     cookieSecret,
+			Expire:     time.Hour,
+			CSRFExpire: 15 * time.Minute,
+		}
+		store = &fakeStateStore{states: map[string][]byte{}}
+
+		var err error
+		publicCSRF, err = NewStoredCSRF(cookieOpts, "verifier", store)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("uses a short opaque ID as the OAuth state", func() {
+		id := publicCSRF.HashOAuthState()
+		Expect(id).To(HaveLen(22))
+		Expect(publicCSRF.CheckOAuthState(id)).To(BeTrue())
+		Expect(publicCSRF.CheckOAuthState(publicCSRF.(*storedCSRF).csrf.HashOAuthState())).To(BeFalse())
+		Expect(publicCSRF.CheckOAuthState("")).To(BeFalse())
+
+		other, err := NewStoredCSRF(cookieOpts, "verifier", store)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.HashOAuthState()).ToNot(Equal(id))
+	})
+
+	// callbackRequest creates a callback request with the cookies set by
+	// the response
+	callbackRequest := func(rw *httptest.ResponseRecorder) *http.Request {
+		req := httptest.NewRequest("GET", "/oauth2/callback", nil)
+		for _, cookie := range rw.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	It("saves the CSRF to the store with a binding cookie", func() {
+		rw := httptest.NewRecorder()
+		cookie, err := publicCSRF.SetCookie(rw, httptest.NewRequest("GET", "/", nil))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cookie).ToNot(BeNil())
+		Expect(cookie.Name).To(Equal(cookieName + "_csrf"))
+		Expect(rw.Header().Values("Set-Cookie")).To(HaveLen(1))
+
+		// The cookie only holds the signed hash of the ID
+		id := publicCSRF.HashOAuthState()
+		Expect(cookie.Value).ToNot(ContainSubstring(id))
+		Expect(len(cookie.Value)).To(BeNumerically("<", 150))
+
+		Expect(store.states).To(HaveKey(id))
+		Expect(store.exp).To(Equal(15 * time.Minute))
+	})
+
+	It("loads the CSRF from the store only once", func() {
+		rw := httptest.NewRecorder()
+		_, err := publicCSRF.SetCookie(rw, httptest.NewRequest("GET", "/", nil))
+		Expect(err).ToNot(HaveOccurred())
+		id := publicCSRF.HashOAuthState()
+
+		loaded, err := LoadStoredCSRF(callbackRequest(rw), store, id, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.CheckOAuthState(id)).To(BeTrue())
+		Expect(loaded.HashOIDCNonce()).To(Equal(publicCSRF.HashOIDCNonce()))
+		Expect(loaded.GetCodeVerifier()).To(Equal("verifier"))
+
+		session := &sessions.SessionState{}
+		loaded.SetSessionNonce(session)
+		Expect(session.Nonce).To(Equal(publicCSRF.(*storedCSRF).OIDCNonce))
+
+		_, err = LoadStoredCSRF(callbackRequest(rw), store, id, cookieOpts)
+		Expect(err).To(MatchError("error loading CSRF state: state not found"))
+	})
+
+	It("only loads the CSRF in the browser that started the login", func() {
+		rw := httptest.NewRecorder()
+		_, err := publicCSRF.SetCookie(rw, httptest.NewRequest("GET", "/", nil))
+		Expect(err).ToNot(HaveOccurred())
+		id := publicCSRF.HashOAuthState()
+
+		// Another browser without the binding cookie
+		_, err = LoadStoredCSRF(httptest.NewRequest("GET", "/oauth2/callback", nil), store, id, cookieOpts)
+		Expect(err).To(MatchError("error loading CSRF binding cookie: http: named cookie not present"))
+
+		// Another browser with the binding cookie of its own login
+		other, err := NewStoredCSRF(cookieOpts, "verifier", store)
+		Expect(err).ToNot(HaveOccurred())
+		otherRW := httptest.NewRecorder()
+		_, err = other.SetCookie(otherRW, httptest.NewRequest("GET", "/", nil))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = LoadStoredCSRF(callbackRequest(otherRW), store, id, cookieOpts)
+		Expect(err).To(MatchError("CSRF binding cookie failed validation"))
+
+		// The state is kept for the browser that started the login
+		Expect(store.states).To(HaveKey(id))
+		_, err = LoadStoredCSRF(callbackRequest(rw), store, id, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("clears the binding cookie", func() {
+		rw := httptest.NewRecorder()
+		publicCSRF.ClearCookie(rw, httptest.NewRequest("GET", "/", nil))
+		cookies := rw.Result().Cookies()
+		Expect(cookies).To(HaveLen(1))
+		Expect(cookies[0].Name).To(Equal(cookieName + "_csrf"))
+		Expect(cookies[0].Value).To(BeEmpty())
+	})
+
+	It("loads CSRFs saved with a secondary secret", func() {
+		rw := httptest.NewRecorder()
+		_, err := publicCSRF.SetCookie(rw, httptest.NewRequest("GET", "/", nil))
+		Expect(err).ToNot(HaveOccurred())
+		id := publicCSRF.HashOAuthState()
+
+		rotatedOpts := *cookieOpts
+		rotatedOpts.Secret = "0123456789abcdef"
+		rotatedOpts.SecondarySecrets = []string{cookieSecret}
+		loaded, err := LoadStoredCSRF(callbackRequest(rw), store, id, &rotatedOpts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.HashOIDCNonce()).To(Equal(publicCSRF.HashOIDCNonce()))
+		Expect(loaded.GetCodeVerifier()).To(Equal("verifier"))
+	})
+})
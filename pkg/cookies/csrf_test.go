@@ -31,6 +31,7 @@ var _ = Describe("CSRF Cookie Tests", func() {
 			Secure:         true,
 			HTTPOnly:       true,
 			CSRFPerRequest: false,
+			CSRFExpire:     15 * time.Minute,
 		}
 
 		var err error
@@ -105,6 +106,51 @@ var _ = Describe("CSRF Cookie Tests", func() {
 			Expect(decoded).ToNot(BeNil())
 			Expect(decoded.OAuthState).To(Equal([]byte(csrfState)))
 			Expect(decoded.OIDCNonce).To(Equal([]byte(csrfNonce)))
+			Expect(decoded.IsSilent()).To(BeFalse())
+		})
+
+		It("encodes and decodes a silent authentication", func() {
+			privateCSRF.SetSilent(true)
+
+			encoded, err := privateCSRF.encodeCookie()
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded, err := decodeCSRFCookie(&http.Cookie{Name: privateCSRF.cookieName(), Value: encoded}, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded.IsSilent()).To(BeTrue())
+		})
+
+		It("does not decode cookies older than the CSRF expiry", func() {
+			cookieOpts.Expire = 24 * time.Hour
+			decode := func(created time.Time) error {
+				privateCSRF.time.Set(created)
+				defer privateCSRF.time.Reset()
+
+				encoded, err := privateCSRF.encodeCookie()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = decodeCSRFCookie(&http.Cookie{Name: privateCSRF.cookieName(), Value: encoded}, cookieOpts)
+				return err
+			}
+
+			Expect(decode(time.Now().Add(-14 * time.Minute))).To(Succeed())
+			Expect(decode(time.Now().Add(-16 * time.Minute))).To(MatchError("CSRF cookie failed validation"))
+		})
+
+		It("decodes cookies encoded with a secondary secret", func() {
+			encoded, err := privateCSRF.encodeCookie()
+			Expect(err).ToNot(HaveOccurred())
+			cookie := &http.Cookie{Name: privateCSRF.cookieName(), Value: encoded}
+
+			rotatedOpts := *cookieOpts
+			rotatedOpts.Secret = "0123456789abcdef"
+			rotatedOpts.SecondarySecrets = []string{cookieSecret}
+			decoded, err := decodeCSRFCookie(cookie, &rotatedOpts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded.OAuthState).To(Equal(privateCSRF.OAuthState))
+
+			rotatedOpts.SecondarySecrets = nil
+			_, err = decodeCSRFCookie(cookie, &rotatedOpts)
+			Expect(err).To(MatchError("CSRF cookie failed validation"))
 		})
 
 		It("signs the encoded cookie value", func() {
@@ -165,6 +211,42 @@ var _ = Describe("CSRF Cookie Tests", func() {
 					),
 				))
 			})
+
+			It("uses the CSRF SameSite attribute when configured", func() {
+				cookieOpts.SameSite = "lax"
+				cookieOpts.CSRFSameSite = "none"
+				rw := httptest.NewRecorder()
+
+				_, err := publicCSRF.SetCookie(rw, req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(rw.Header().Get("Set-Cookie")).To(HaveSuffix(
+					fmt.Sprintf(
+						"; Path=%s; Domain=%s; Expires=%s; HttpOnly; Secure; SameSite=None",
+						cookiePath,
+						cookieDomain,
+						testCookieExpires(testNow.Add(cookieOpts.CSRFExpire)),
+					),
+				))
+			})
+
+			It("adds the Partitioned attribute when configured", func() {
+				cookieOpts.SameSite = "none"
+				cookieOpts.Partitioned = true
+				rw := httptest.NewRecorder()
+
+				_, err := publicCSRF.SetCookie(rw, req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(rw.Header().Get("Set-Cookie")).To(HaveSuffix(
+					fmt.Sprintf(
+						"; Path=%s; Domain=%s; Expires=%s; HttpOnly; Secure; SameSite=None; Partitioned",
+						cookiePath,
+						cookieDomain,
+						testCookieExpires(testNow.Add(cookieOpts.CSRFExpire)),
+					),
+				))
+			})
 		})
 
 		Context("ClearCookie", func() {
@@ -189,6 +271,38 @@ var _ = Describe("CSRF Cookie Tests", func() {
 			It("has the cookie options name as a base", func() {
 				Expect(privateCSRF.cookieName()).To(ContainSubstring(cookieName))
 			})
+
+			It("starts with the cookie name prefix", func() {
+				cookieOpts.NamePrefix = "tenant_a"
+				Expect(privateCSRF.cookieName()).To(Equal(fmt.Sprintf("tenant_a%s_csrf", cookieName)))
+
+				cookieOpts.CSRFPerRequest = true
+				Expect(privateCSRF.cookieName()).To(HavePrefix(fmt.Sprintf("tenant_a%s_csrf_", cookieName)))
+			})
+		})
+
+		Context("LoadCSRFCookie", func() {
+			It("does not load the CSRF cookie of another cookie name prefix", func() {
+				// The cookie signature is validated against the current time
+				privateCSRF.time.Reset()
+				cookieOpts.NamePrefix = "tenant_a"
+				rw := httptest.NewRecorder()
+				_, err := publicCSRF.SetCookie(rw, req)
+				Expect(err).ToNot(HaveOccurred())
+				req.Header = http.Header{}
+				for _, c := range rw.Result().Cookies() {
+					req.AddCookie(c)
+				}
+
+				loaded, err := LoadCSRFCookie(req, cookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(loaded.(*csrf).OAuthState).To(Equal(privateCSRF.OAuthState))
+
+				otherOpts := *cookieOpts
+				otherOpts.NamePrefix = "tenant_b"
+				_, err = LoadCSRFCookie(req, &otherOpts)
+				Expect(err).To(Equal(http.ErrNoCookie))
+			})
 		})
 	})
 })
@@ -7,6 +7,28 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// CipherAESCFB encrypts cookie values with AES CFB, using AES-128, 192 or
+	// 256 depending on the size of the secret
+	CipherAESCFB = "aes-cfb"
+
+	// CipherAES256GCM encrypts cookie values with AES-256 GCM
+	CipherAES256GCM = "aes-256-gcm"
+
+	// CipherChaCha20Poly1305 encrypts cookie values with ChaCha20-Poly1305
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+// The version bytes prefixed to the cookie values encrypted with the AEAD
+// ciphers. AES CFB values have no version byte, so that the values encrypted
+// before the cipher could be selected can still be decrypted.
+const (
+	cipherVersionAES256GCM        byte = 1
+	cipherVersionChaCha20Poly1305 byte = 2
 )
 
 // Cipher provides methods to encrypt and decrypt
@@ -122,6 +144,9 @@ func (c *gcmCipher) Decrypt(ciphertext []byte) ([]byte, error) {
 	}
 
 	nonceSize := gcm.NonceSize()
+	if len(ciphertext) < nonceSize+gcm.Overhead() {
+		return nil, fmt.Errorf("encrypted value should be at least %d bytes, but is only %d bytes", nonceSize+gcm.Overhead(), len(ciphertext))
+	}
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
@@ -130,3 +155,127 @@ func (c *gcmCipher) Decrypt(ciphertext []byte) ([]byte, error) {
 	}
 	return plaintext, nil
 }
+
+type chaCha20Poly1305Cipher struct {
+	cipher.AEAD
+}
+
+func newAES256GCMCipher(secret []byte) (Cipher, error) {
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("%s requires a 32 byte secret, but it is %d bytes", CipherAES256GCM, len(secret))
+	}
+	return NewGCMCipher(secret)
+}
+
+func newChaCha20Poly1305Cipher(secret []byte) (Cipher, error) {
+	if len(secret) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("%s requires a %d byte secret, but it is %d bytes", CipherChaCha20Poly1305, chacha20poly1305.KeySize, len(secret))
+	}
+	aead, err := chacha20poly1305.New(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &chaCha20Poly1305Cipher{AEAD: aead}, nil
+}
+
+// Encrypt with ChaCha20-Poly1305 on raw bytes, prefixing the nonce
+func (c *chaCha20Poly1305Cipher) Encrypt(value []byte) ([]byte, error) {
+	nonce := make([]byte, c.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.Seal(nonce, nonce, value, nil), nil
+}
+
+// Decrypt a ChaCha20-Poly1305 ciphertext
+func (c *chaCha20Poly1305Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := c.NonceSize()
+	if len(ciphertext) < nonceSize+c.Overhead() {
+		return nil, fmt.Errorf("encrypted value should be at least %d bytes, but is only %d bytes", nonceSize+c.Overhead(), len(ciphertext))
+	}
+
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return c.Open(nil, nonce, ciphertext, nil)
+}
+
+// cookieCipher encrypts cookie values with the selected cipher and decrypts
+// the values encrypted with any of the ciphers, so that the cipher can be
+// changed without invalidating the existing cookies
+type cookieCipher struct {
+	version byte
+	cfb     Cipher
+	aeads   map[byte]Cipher
+}
+
+// NewCookieCipher returns a new Cipher for cookie values that encrypts with
+// the algorithm, AES CFB when it is empty.
+// Values encrypted with the AEAD ciphers are prefixed with a version byte
+// identifying the cipher. These ciphers require a 32 byte secret, they
+// can only decrypt values when the secret has this size.
+func NewCookieCipher(algorithm string, secret []byte) (Cipher, error) {
+	cfb, err := NewCFBCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	c := &cookieCipher{cfb: cfb, aeads: map[byte]Cipher{}}
+
+	inits := map[byte]func([]byte) (Cipher, error){
+		cipherVersionAES256GCM:        newAES256GCMCipher,
+		cipherVersionChaCha20Poly1305: newChaCha20Poly1305Cipher,
+	}
+	switch algorithm {
+	case "", CipherAESCFB:
+	case CipherAES256GCM:
+		c.version = cipherVersionAES256GCM
+	case CipherChaCha20Poly1305:
+		c.version = cipherVersionChaCha20Poly1305
+	default:
+		return nil, fmt.Errorf("unknown cipher algorithm %q", algorithm)
+	}
+
+	for version, init := range inits {
+		aead, err := init(secret)
+		if err != nil {
+			if version == c.version {
+				return nil, err
+			}
+			continue
+		}
+		c.aeads[version] = aead
+	}
+	return c, nil
+}
+
+// Encrypt with the selected cipher, prefixing the version byte of AEAD ciphers.
+// AES CFB values are encrypted again until their IV does not start with a
+// version byte, so that they are never mistaken for AEAD values.
+func (c *cookieCipher) Encrypt(value []byte) ([]byte, error) {
+	if c.version == 0 {
+		for {
+			ciphertext, err := c.cfb.Encrypt(value)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := c.aeads[ciphertext[0]]; !ok {
+				return ciphertext, nil
+			}
+		}
+	}
+
+	ciphertext, err := c.aeads[c.version].Encrypt(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.version}, ciphertext...), nil
+}
+
+// Decrypt a value with the cipher identified by its version byte.
+// Values without a version byte are AES CFB values.
+func (c *cookieCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) > 0 {
+		if aead, ok := c.aeads[ciphertext[0]]; ok {
+			return aead.Decrypt(ciphertext[1:])
+		}
+	}
+	return c.cfb.Decrypt(ciphertext)
+}
@@ -229,3 +229,163 @@ func TestCFBtoGCMErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestCookieCipherEncryptAndDecrypt(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, secret)
+	assert.Equal(t, nil, err)
+
+	for _, algorithm := range []string{CipherAESCFB, CipherAES256GCM, CipherChaCha20Poly1305} {
+		t.Run(algorithm, func(t *testing.T) {
+			c, err := NewCookieCipher(algorithm, secret)
+			assert.Equal(t, nil, err)
+
+			// Test various sizes sessions might be
+			for _, dataSize := range []int{10, 100, 1000, 5000, 10000} {
+				t.Run(fmt.Sprintf("%d", dataSize), func(t *testing.T) {
+					runEncryptAndDecrypt(t, c, dataSize)
+				})
+			}
+		})
+	}
+}
+
+func TestCookieCipherVersionByte(t *testing.T) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	data := []byte("f3928pufm982374dj02y485dsl34890u2t9nd4028s94dm58y2394087dhmsyt29h8df")
+
+	testCases := map[string]struct {
+		algorithm string
+		version   byte
+		size      int
+	}{
+		"AES-256-GCM": {
+			algorithm: CipherAES256GCM,
+			version:   cipherVersionAES256GCM,
+			size:      1 + 12 + len(data) + 16,
+		},
+		"ChaCha20-Poly1305": {
+			algorithm: CipherChaCha20Poly1305,
+			version:   cipherVersionChaCha20Poly1305,
+			size:      1 + 12 + len(data) + 16,
+		},
+		"AES-CFB without a version byte": {
+			algorithm: CipherAESCFB,
+			size:      16 + len(data),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewCookieCipher(tc.algorithm, secret)
+			assert.Equal(t, nil, err)
+
+			encrypted, err := c.Encrypt(data)
+			assert.Equal(t, nil, err)
+			assert.Len(t, encrypted, tc.size)
+			if tc.version != 0 {
+				assert.Equal(t, tc.version, encrypted[0])
+			}
+		})
+	}
+}
+
+// Values encrypted with any algorithm are decrypted by the cookie ciphers of
+// the other algorithms, so that the algorithm can be changed in rolling
+// upgrades
+func TestCookieCipherCrossAlgorithmDecrypt(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, secret)
+	assert.Equal(t, nil, err)
+
+	algorithms := []string{CipherAESCFB, CipherAES256GCM, CipherChaCha20Poly1305}
+	for _, encryptWith := range algorithms {
+		for _, decryptWith := range algorithms {
+			t.Run(fmt.Sprintf("%s to %s", encryptWith, decryptWith), func(t *testing.T) {
+				encrypter, err := NewCookieCipher(encryptWith, secret)
+				assert.Equal(t, nil, err)
+				decrypter, err := NewCookieCipher(decryptWith, secret)
+				assert.Equal(t, nil, err)
+
+				// Many values, so that CFB values whose IV starts with a
+				// version byte are decrypted too
+				for i := 0; i < 1000; i++ {
+					data := []byte(fmt.Sprintf("session data %d", i))
+					encrypted, err := encrypter.Encrypt(data)
+					assert.Equal(t, nil, err)
+
+					decrypted, err := decrypter.Decrypt(encrypted)
+					assert.Equal(t, nil, err)
+					assert.Equal(t, data, decrypted)
+				}
+			})
+		}
+	}
+}
+
+// Values encrypted with the CFB cipher before the algorithm could be selected
+// are still decrypted, unless their IV starts with a version byte
+func TestCookieCipherDecryptLegacyCFB(t *testing.T) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	data := []byte("f3928pufm982374dj02y485dsl34890u2t9nd4028s94dm58y2394087dhmsyt29h8df")
+
+	cfb, err := NewCFBCipher(secret)
+	assert.Equal(t, nil, err)
+	c, err := NewCookieCipher(CipherChaCha20Poly1305, secret)
+	assert.Equal(t, nil, err)
+
+	for i := 0; i < 1000; i++ {
+		encrypted, err := cfb.Encrypt(data)
+		assert.Equal(t, nil, err)
+
+		decrypted, err := c.Decrypt(encrypted)
+		if encrypted[0] == cipherVersionAES256GCM || encrypted[0] == cipherVersionChaCha20Poly1305 {
+			assert.Error(t, err)
+			continue
+		}
+		assert.Equal(t, nil, err)
+		assert.Equal(t, data, decrypted)
+	}
+}
+
+// Tampered or truncated AEAD values fail to decrypt rather than being
+// decrypted as AES CFB values
+func TestCookieCipherDecryptTamperedAEAD(t *testing.T) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	data := []byte("session data")
+
+	expectedErrs := map[string]string{
+		CipherAES256GCM:        "cipher: message authentication failed",
+		CipherChaCha20Poly1305: "chacha20poly1305: message authentication failed",
+	}
+	for algorithm, expectedErr := range expectedErrs {
+		t.Run(algorithm, func(t *testing.T) {
+			c, err := NewCookieCipher(algorithm, secret)
+			assert.Equal(t, nil, err)
+			encrypted, err := c.Encrypt(data)
+			assert.Equal(t, nil, err)
+
+			tampered := append([]byte{}, encrypted...)
+			tampered[len(tampered)-1] ^= 0xff
+			_, err = c.Decrypt(tampered)
+			assert.EqualError(t, err, expectedErr)
+
+			_, err = c.Decrypt(encrypted[:10])
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewCookieCipherErrors(t *testing.T) {
+	_, err := NewCookieCipher("aes-128-cbc", []byte("0123456789abcdefghijklmnopqrstuv"))
+	assert.EqualError(t, err, "unknown cipher algorithm \"aes-128-cbc\"")
+
+	_, err = NewCookieCipher(CipherAES256GCM, []byte("0123456789abcdef"))
+	assert.EqualError(t, err, "aes-256-gcm requires a 32 byte secret, but it is 16 bytes")
+
+	_, err = NewCookieCipher(CipherChaCha20Poly1305, []byte("0123456789abcdef"))
+	assert.EqualError(t, err, "chacha20-poly1305 requires a 32 byte secret, but it is 16 bytes")
+
+	// AES CFB supports all AES key sizes
+	_, err = NewCookieCipher(CipherAESCFB, []byte("0123456789abcdef"))
+	assert.Equal(t, nil, err)
+}
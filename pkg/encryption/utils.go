@@ -71,6 +71,18 @@ func Validate(cookie *http.Cookie, seed string, expiration time.Duration) (value
 	return
 }
 
+// ValidateWithSecrets ensures a cookie is properly signed with one of the
+// seeds, which are tried in order. The index of the seed the cookie was signed
+// with is returned, so that cookies signed with an old seed can be signed again.
+func ValidateWithSecrets(cookie *http.Cookie, seeds []string, expiration time.Duration) (value []byte, seed int, ok bool) {
+	for i, s := range seeds {
+		if value, _, ok = Validate(cookie, s, expiration); ok {
+			return value, i, true
+		}
+	}
+	return nil, 0, false
+}
+
 // SignedValue returns a cookie that is signed and can later be checked with Validate
 func SignedValue(seed string, key string, value []byte, now time.Time) (string, error) {
 	encodedValue := base64.URLEncoding.EncodeToString(value)
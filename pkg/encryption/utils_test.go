@@ -7,8 +7,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 	"unicode"
 
 	"github.com/stretchr/testify/assert"
@@ -103,6 +105,20 @@ func TestSignAndValidate(t *testing.T) {
 	assert.False(t, checkSignature(sha1sig, seed, key, "tampered", epoch))
 }
 
+func TestValidateWithSecrets(t *testing.T) {
+	signed, err := SignedValue("old-secret", "cookie-name", []byte("value"), time.Now())
+	assert.NoError(t, err)
+	cookie := &http.Cookie{Name: "cookie-name", Value: signed}
+
+	value, seed, ok := ValidateWithSecrets(cookie, []string{"new-secret", "old-secret"}, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, 1, seed)
+	assert.Equal(t, []byte("value"), value)
+
+	_, _, ok = ValidateWithSecrets(cookie, []string{"new-secret"}, time.Hour)
+	assert.False(t, ok)
+}
+
 func TestGenerateRandomASCIIString(t *testing.T) {
 	randomString, err := GenerateRandomASCIIString(96)
 	assert.NoError(t, err)
@@ -119,3 +135,19 @@ func TestGenerateRandomASCIIString(t *testing.T) {
 	}, randomString)
 	assert.Equal(t, removedChars, randomString)
 }
+
+func TestGenerateCodeChallenge(t *testing.T) {
+	// Code verifier and challenge from RFC 7636 Appendix B
+	codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	challenge, err := GenerateCodeChallenge(CodeChallengeMethodS256, codeVerifier)
+	assert.NoError(t, err)
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", challenge)
+
+	challenge, err = GenerateCodeChallenge(CodeChallengeMethodPlain, codeVerifier)
+	assert.NoError(t, err)
+	assert.Equal(t, codeVerifier, challenge)
+
+	_, err = GenerateCodeChallenge("S512", codeVerifier)
+	assert.Error(t, err)
+}
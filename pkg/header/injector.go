@@ -2,14 +2,26 @@ package header
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options/util"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
+// idTokenClaimsWarnSize is the size of the encoded ID token claims above which
+// a warning is logged, as many servers limit the size of each header to 4KB
+// or of all headers to 8KB
+const idTokenClaimsWarnSize = 4096
+
 type Injector interface {
 	Inject(http.Header, *sessionsapi.SessionState)
 }
@@ -78,41 +90,158 @@ func newSecretInjector(name string, source *options.SecretSource) (valueInjector
 }
 
 func newClaimInjector(name string, source *options.ClaimSource) (valueInjector, error) {
-	switch {
-	case source.BasicAuthPassword != nil:
+	getValues, err := newClaimValuesFunc(name, source)
+	if err != nil {
+		return nil, err
+	}
+
+	formatValue := func(value string) string {
+		return source.Prefix + value
+	}
+	if source.BasicAuthPassword != nil {
 		password, err := util.GetSecretValue(source.BasicAuthPassword)
 		if err != nil {
 			return nil, fmt.Errorf("error loading basicAuthPassword: %v", err)
 		}
-		return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
-			claimValues := session.GetClaim(source.Claim)
-			for _, claim := range claimValues {
-				if claim == "" {
-					continue
-				}
-				auth := claim + ":" + string(password)
-				header.Add(name, "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+		formatValue = func(value string) string {
+			auth := value + ":" + string(password)
+			return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+		}
+	}
+
+	return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
+		values := []string{}
+		for _, value := range getValues(session) {
+			if value == "" {
+				continue
 			}
-		}), nil
-	case source.Prefix != "":
-		return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
-			claimValues := session.GetClaim(source.Claim)
-			for _, claim := range claimValues {
-				if claim == "" {
-					continue
-				}
-				header.Add(name, source.Prefix+claim)
+			values = append(values, value)
+		}
+
+		if len(values) == 0 {
+			if source.EmitEmpty {
+				header.Add(name, "")
 			}
-		}), nil
+			return
+		}
+
+		if source.Separator != "" {
+			values = []string{strings.Join(values, source.Separator)}
+		}
+		for _, value := range values {
+			header.Add(name, formatValue(value))
+		}
+	}), nil
+}
+
+// newClaimValuesFunc returns a func that loads the values for the claim
+// source from the session, either from the claim or by rendering the template.
+func newClaimValuesFunc(name string, source *options.ClaimSource) (func(*sessionsapi.SessionState) []string, error) {
+	if source.TimeFormat != "" {
+		return newTimeClaimValuesFunc(source)
+	}
+
+	if source.Template == "" && source.Claim == options.ClaimIDTokenClaims {
+		return newIDTokenClaimsValuesFunc(name), nil
+	}
+
+	if source.Template == "" {
+		return func(session *sessionsapi.SessionState) []string {
+			return session.GetClaim(source.Claim)
+		}, nil
+	}
+
+	tmpl, err := template.New("claim").Option("missingkey=error").Parse(source.Template)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %v", err)
+	}
+
+	return func(session *sessionsapi.SessionState) []string {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, templateClaims(session)); err != nil {
+			return []string{}
+		}
+		return []string{value.String()}
+	}, nil
+}
+
+// newTimeClaimValuesFunc returns a func that loads a time claim from the
+// session and formats it with the time format of the claim source.
+// Sessions without the time, eg tokens that do not expire, have no value.
+func newTimeClaimValuesFunc(source *options.ClaimSource) (func(*sessionsapi.SessionState) []string, error) {
+	var formatTime func(time.Time) string
+	switch source.TimeFormat {
+	case options.ClaimTimeFormatRFC3339:
+		formatTime = func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	case options.ClaimTimeFormatUnix:
+		formatTime = func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }
 	default:
-		return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
-			claimValues := session.GetClaim(source.Claim)
-			for _, claim := range claimValues {
-				if claim == "" {
-					continue
-				}
-				header.Add(name, claim)
-			}
-		}), nil
+		return nil, fmt.Errorf("unknown time format %q", source.TimeFormat)
+	}
+
+	var getTime func(*sessionsapi.SessionState) *time.Time
+	switch source.Claim {
+	case "created_at":
+		getTime = func(session *sessionsapi.SessionState) *time.Time { return session.CreatedAt }
+	case "expires_on":
+		getTime = func(session *sessionsapi.SessionState) *time.Time { return session.ExpiresOn }
+	default:
+		return nil, fmt.Errorf("claim %q is not a time claim", source.Claim)
+	}
+
+	return func(session *sessionsapi.SessionState) []string {
+		if session == nil {
+			return []string{}
+		}
+		t := getTime(session)
+		if t == nil || t.IsZero() {
+			return []string{}
+		}
+		return []string{formatTime(*t)}
+	}, nil
+}
+
+// newIDTokenClaimsValuesFunc returns a func that loads all the claims of the
+// ID token from the session as base64 encoded JSON.
+// A warning is logged the first time the claims exceed idTokenClaimsWarnSize,
+// as the upstream may reject requests with large headers.
+func newIDTokenClaimsValuesFunc(name string) func(*sessionsapi.SessionState) []string {
+	var warnOnce sync.Once
+	return func(session *sessionsapi.SessionState) []string {
+		claims := session.IDTokenClaims()
+		if len(claims) == 0 {
+			return []string{}
+		}
+		data, err := json.Marshal(claims)
+		if err != nil {
+			return []string{}
+		}
+
+		value := base64.StdEncoding.EncodeToString(data)
+		if len(value) > idTokenClaimsWarnSize {
+			warnOnce.Do(func() {
+				logger.Printf("WARNING: the ID token claims passed in header %q are %d bytes, which may exceed the header size limit of the upstream", name, len(value))
+			})
+		}
+		return []string{value}
+	}
+}
+
+// templateClaims builds the claims available to templates. These are the
+// claims of the ID token, with the claims held in the session taking precedence.
+func templateClaims(session *sessionsapi.SessionState) map[string]interface{} {
+	claims := session.IDTokenClaims()
+	if session == nil {
+		return claims
+	}
+
+	for _, claim := range []string{"user", "email", "preferred_username"} {
+		if values := session.GetClaim(claim); len(values) == 1 && values[0] != "" {
+			claims[claim] = values[0]
+		}
+	}
+	if len(session.Groups) > 0 {
+		claims["groups"] = session.GetClaim("groups")
 	}
+	return claims
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -12,7 +13,16 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// testIDToken builds an unsigned ID token carrying the given claims
+func testIDToken(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + ".signature"
+}
+
 var _ = Describe("Injector Suite", func() {
+	idToken := testIDToken(`{"tenant_id":"tenant-123","given_name":"Jane","family_name":"Doe","roles":["admin","dev"],"level":3}`)
+	expiresOn := time.Date(2023, 4, 5, 6, 7, 8, 0, time.FixedZone("CEST", 2*60*60))
+
 	Context("NewInjector", func() {
 		type newInjectorTableInput struct {
 			headers         []options.Header
@@ -340,6 +350,305 @@ var _ = Describe("Injector Suite", func() {
 				expectedHeaders: nil,
 				expectedErr:     errors.New("error building injector for header \"X-Auth-Request-Authorization\": error loading basicAuthPassword: secret source is invalid: exactly one entry required, specify either value, fromEnv or fromFile"),
 			}),
+			Entry("with an ID token claim valued header", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Tenant-Id",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "tenant_id",
+								},
+							},
+						},
+					},
+					{
+						Name: "X-Level",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "level",
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					IDToken: idToken,
+				},
+				expectedHeaders: http.Header{
+					"X-Tenant-Id": []string{"tenant-123"},
+					"X-Level":     []string{"3"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with an ID token claims header", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Forwarded-Claims",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: options.ClaimIDTokenClaims,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					IDToken: idToken,
+				},
+				expectedHeaders: http.Header{
+					"X-Forwarded-Claims": []string{base64.StdEncoding.EncodeToString([]byte(`{"family_name":"Doe","given_name":"Jane","level":3,"roles":["admin","dev"],"tenant_id":"tenant-123"}`))},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with an ID token claims header and a session without an ID token", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Forwarded-Claims",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: options.ClaimIDTokenClaims,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					AccessToken: "access-token",
+				},
+				expectedHeaders: http.Header{},
+				expectedErr:     nil,
+			}),
+			Entry("with a multi-valued claim header", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Roles",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "roles",
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					IDToken: idToken,
+				},
+				expectedHeaders: http.Header{
+					"X-Roles": []string{"admin", "dev"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a multi-valued claim header and a separator", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Roles",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:     "roles",
+									Separator: ",",
+									Prefix:    "roles=",
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					IDToken: idToken,
+				},
+				expectedHeaders: http.Header{
+					"X-Roles": []string{"roles=admin,dev"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a templated claim header", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Full-Name",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template: "{{.given_name}} {{.family_name}} <{{.email}}>",
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					Email:   "jane@example.com",
+					IDToken: idToken,
+				},
+				expectedHeaders: http.Header{
+					"X-Full-Name": []string{"Jane Doe <jane@example.com>"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a templated claim header missing a claim", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Nickname",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template: "{{.nickname}}",
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					IDToken: idToken,
+				},
+				expectedHeaders: http.Header{},
+				expectedErr:     nil,
+			}),
+			Entry("with missing claims emitted as empty values", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Nickname",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template:  "{{.nickname}}",
+									EmitEmpty: true,
+								},
+							},
+						},
+					},
+					{
+						Name: "X-Department",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:     "department",
+									EmitEmpty: true,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session:        nil,
+				expectedHeaders: http.Header{
+					"X-Nickname":   []string{""},
+					"X-Department": []string{""},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with an invalid template", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Full-Name",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template: "{{.given_name",
+								},
+							},
+						},
+					},
+				},
+				initialHeaders:  http.Header{},
+				session:         &sessionsapi.SessionState{},
+				expectedHeaders: nil,
+				expectedErr:     errors.New("error building injector for header \"X-Full-Name\": error parsing template: template: claim:1: unclosed action"),
+			}),
+			Entry("with an RFC 3339 formatted time claim", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Auth-Request-Access-Token-Expiry",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:      "expires_on",
+									TimeFormat: options.ClaimTimeFormatRFC3339,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					ExpiresOn: &expiresOn,
+				},
+				expectedHeaders: http.Header{
+					"X-Auth-Request-Access-Token-Expiry": []string{"2023-04-05T04:07:08Z"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a unix formatted time claim", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Auth-Request-Access-Token-Expiry",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:      "expires_on",
+									TimeFormat: options.ClaimTimeFormatUnix,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{},
+				session: &sessionsapi.SessionState{
+					ExpiresOn: &expiresOn,
+				},
+				expectedHeaders: http.Header{
+					"X-Auth-Request-Access-Token-Expiry": []string{"1680667628"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a formatted time claim missing from the session", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Auth-Request-Access-Token-Expiry",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:      "expires_on",
+									TimeFormat: options.ClaimTimeFormatUnix,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders:  http.Header{},
+				session:         &sessionsapi.SessionState{},
+				expectedHeaders: http.Header{},
+				expectedErr:     nil,
+			}),
+			Entry("with a formatted time claim that is not a time", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "X-Email",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:      "email",
+									TimeFormat: options.ClaimTimeFormatUnix,
+								},
+							},
+						},
+					},
+				},
+				initialHeaders:  http.Header{},
+				session:         &sessionsapi.SessionState{},
+				expectedHeaders: nil,
+				expectedErr:     errors.New("error building injector for header \"X-Email\": claim \"email\" is not a time claim"),
+			}),
 			Entry("with a mix of configured headers", newInjectorTableInput{
 				headers: []options.Header{
 					{
@@ -0,0 +1,162 @@
+package header
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IdentitySignatureHeader is the request header that holds the signature of
+// the identity headers injected by the proxy.
+//
+// The value has the form `t=<timestamp>,h=<headers>,s=<signature>` where:
+//   - timestamp is the time the request was signed in seconds since the Unix epoch
+//   - headers is the `;` separated list of signed header names, in lower case and sorted
+//   - signature is the unpadded base64url encoded HMAC-SHA256 of the canonical string
+//
+// The canonical string is the timestamp, the method, host and request URI of
+// the request and then a line for each signed header, in the order of the
+// header list, each ending in a newline:
+//
+//	<timestamp>\n
+//	<method>\n
+//	<host>\n
+//	<request URI>\n
+//	<header name>:<length>:<value>[,<length>:<value>...]\n
+//
+// Each header value is prefixed with its length in bytes, so that values
+// containing "," cannot be confused with multiple values.
+// Headers that are not present are signed with no values, so that an
+// upstream can tell a header was removed.
+const IdentitySignatureHeader = "X-Identity-Signature"
+
+// SignIdentityHeaders sets the IdentitySignatureHeader of the request with the
+// signature of the named headers at the given time.
+func SignIdentityHeaders(req *http.Request, names []string, key []byte, now time.Time) {
+	names = canonicalHeaderNames(names)
+	timestamp := now.Unix()
+	signature := identitySignature(req, names, key, timestamp)
+
+	req.Header.Set(IdentitySignatureHeader, fmt.Sprintf("t=%d,h=%s,s=%s", timestamp, strings.Join(names, ";"), signature))
+}
+
+// VerifyIdentityHeaders checks the IdentitySignatureHeader against the request
+// and returns the names of the headers that were signed.
+// The signature must have been created within maxAge of now.
+// Only the returned headers should be trusted by the caller.
+func VerifyIdentityHeaders(req *http.Request, key []byte, maxAge time.Duration, now time.Time) ([]string, error) {
+	value := req.Header.Get(IdentitySignatureHeader)
+	if value == "" {
+		return nil, fmt.Errorf("missing %s header", IdentitySignatureHeader)
+	}
+
+	timestamp, names, signature, err := parseIdentitySignature(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %v", IdentitySignatureHeader, err)
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age < -maxAge || age > maxAge {
+		return nil, fmt.Errorf("signature timestamp %d is outside of the allowed window of %s", timestamp, maxAge)
+	}
+
+	expected := identitySignature(req, names, key, timestamp)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, errors.New("signature does not match the request")
+	}
+	return names, nil
+}
+
+func parseIdentitySignature(value string) (int64, []string, string, error) {
+	var timestamp int64
+	var names []string
+	var signature string
+	var hasTimestamp, hasNames bool
+
+	for _, part := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return 0, nil, "", fmt.Errorf("malformed part %q", part)
+		}
+
+		switch key {
+		case "t":
+			var err error
+			timestamp, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return 0, nil, "", fmt.Errorf("invalid timestamp %q", val)
+			}
+			hasTimestamp = true
+		case "h":
+			if val != "" {
+				names = strings.Split(val, ";")
+			}
+			hasNames = true
+		case "s":
+			signature = val
+		}
+	}
+
+	if !hasTimestamp || !hasNames || signature == "" {
+		return 0, nil, "", errors.New("t, h and s are required")
+	}
+	return timestamp, names, signature, nil
+}
+
+func identitySignature(req *http.Request, names []string, key []byte, timestamp int64) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(canonicalIdentityHeaders(req, names, timestamp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalIdentityHeaders builds the string that is signed for the request.
+// The names must already be canonical.
+func canonicalIdentityHeaders(req *http.Request, names []string, timestamp int64) []byte {
+	var b strings.Builder
+	b.WriteString(strconv.FormatInt(timestamp, 10))
+	b.WriteByte('\n')
+	b.WriteString(req.Method)
+	b.WriteByte('\n')
+	b.WriteString(req.Host)
+	b.WriteByte('\n')
+	b.WriteString(req.URL.RequestURI())
+	b.WriteByte('\n')
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(':')
+		for i, value := range req.Header.Values(name) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Itoa(len(value)))
+			b.WriteByte(':')
+			b.WriteString(value)
+		}
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// canonicalHeaderNames returns the header names in lower case, sorted and
+// without duplicates.
+func canonicalHeaderNames(names []string) []string {
+	seen := make(map[string]struct{}, len(names))
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		canonical = append(canonical, name)
+	}
+	sort.Strings(canonical)
+	return canonical
+}
@@ -0,0 +1,207 @@
+package header
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Identity Signature Suite", func() {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	signedAt := time.Unix(1700000000, 0)
+
+	Context("canonicalIdentityHeaders", func() {
+		It("sorts, lower cases and deduplicates the names", func() {
+			Expect(canonicalHeaderNames([]string{"X-Forwarded-User", "x-forwarded-email", "X-FORWARDED-USER"})).To(Equal([]string{"x-forwarded-email", "x-forwarded-user"}))
+		})
+
+		It("length prefixes the values and signs missing headers as empty", func() {
+			req := httptest.NewRequest("POST", "https://app.example.com/api/items?page=2", nil)
+			req.Header = http.Header{
+				"X-Forwarded-Groups": []string{"admins", "devs"},
+				"X-Forwarded-User":   []string{"jdoe"},
+				"X-Forwarded-Email":  []string{""},
+			}
+			names := canonicalHeaderNames([]string{"X-Forwarded-User", "X-Forwarded-Groups", "X-Forwarded-Email", "X-Forwarded-Preferred-Username"})
+
+			Expect(string(canonicalIdentityHeaders(req, names, signedAt.Unix()))).To(Equal(
+				"1700000000\n" +
+					"POST\n" +
+					"app.example.com\n" +
+					"/api/items?page=2\n" +
+					"x-forwarded-email:0:\n" +
+					"x-forwarded-groups:6:admins,4:devs\n" +
+					"x-forwarded-preferred-username:\n" +
+					"x-forwarded-user:4:jdoe\n",
+			))
+		})
+
+		It("distinguishes a value containing a comma from multiple values", func() {
+			names := []string{"x-forwarded-groups"}
+			single := httptest.NewRequest("GET", "/", nil)
+			single.Header.Set("X-Forwarded-Groups", "a,b")
+			multiple := httptest.NewRequest("GET", "/", nil)
+			multiple.Header["X-Forwarded-Groups"] = []string{"a", "b"}
+
+			Expect(canonicalIdentityHeaders(single, names, signedAt.Unix())).ToNot(Equal(canonicalIdentityHeaders(multiple, names, signedAt.Unix())))
+		})
+	})
+
+	Context("SignIdentityHeaders", func() {
+		It("sets the signature header as documented", func() {
+			req := httptest.NewRequest("GET", "https://app.example.com/profile", nil)
+			req.Header = http.Header{
+				"X-Forwarded-Email": []string{"jdoe@example.com"},
+				"X-Forwarded-User":  []string{"jdoe"},
+			}
+			SignIdentityHeaders(req, []string{"X-Forwarded-User", "X-Forwarded-Email"}, key, signedAt)
+
+			mac := hmac.New(sha256.New, key)
+			_, _ = mac.Write([]byte("1700000000\nGET\napp.example.com\n/profile\nx-forwarded-email:16:jdoe@example.com\nx-forwarded-user:4:jdoe\n"))
+			expectedSignature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+			Expect(req.Header.Get(IdentitySignatureHeader)).To(Equal("t=1700000000,h=x-forwarded-email;x-forwarded-user,s=" + expectedSignature))
+		})
+
+		It("replaces an existing signature header", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(IdentitySignatureHeader, "t=1,h=,s=spoofed")
+			SignIdentityHeaders(req, []string{"X-Forwarded-User"}, key, signedAt)
+
+			Expect(req.Header.Values(IdentitySignatureHeader)).To(HaveLen(1))
+			Expect(req.Header.Get(IdentitySignatureHeader)).ToNot(ContainSubstring("spoofed"))
+		})
+	})
+
+	Context("VerifyIdentityHeaders", func() {
+		signedRequest := func() *http.Request {
+			req := httptest.NewRequest("GET", "https://app.example.com/profile", nil)
+			req.Header = http.Header{
+				"X-Forwarded-Email": []string{"jdoe@example.com"},
+				"X-Forwarded-User":  []string{"jdoe"},
+			}
+			SignIdentityHeaders(req, []string{"X-Forwarded-User", "X-Forwarded-Email"}, key, signedAt)
+			return req
+		}
+
+		type verifyTableInput struct {
+			modify      func(*http.Request)
+			key         []byte
+			now         time.Time
+			expectedErr string
+		}
+
+		DescribeTable("verifies the signature",
+			func(in verifyTableInput) {
+				req := signedRequest()
+				if in.modify != nil {
+					in.modify(req)
+				}
+				verifyKey := key
+				if in.key != nil {
+					verifyKey = in.key
+				}
+
+				names, err := VerifyIdentityHeaders(req, verifyKey, time.Minute, in.now)
+				if in.expectedErr != "" {
+					Expect(err).To(MatchError(ContainSubstring(in.expectedErr)))
+					return
+				}
+				Expect(err).ToNot(HaveOccurred())
+				Expect(names).To(Equal([]string{"x-forwarded-email", "x-forwarded-user"}))
+			},
+			Entry("when the headers are unchanged", verifyTableInput{
+				now: signedAt,
+			}),
+			Entry("when the signature is within the window", verifyTableInput{
+				now: signedAt.Add(time.Minute),
+			}),
+			Entry("when the upstream clock is slightly behind", verifyTableInput{
+				now: signedAt.Add(-30 * time.Second),
+			}),
+			Entry("when the signature is too old", verifyTableInput{
+				now:         signedAt.Add(time.Minute + time.Second),
+				expectedErr: "outside of the allowed window of 1m0s",
+			}),
+			Entry("when the signature is from the future", verifyTableInput{
+				now:         signedAt.Add(-2 * time.Minute),
+				expectedErr: "outside of the allowed window",
+			}),
+			Entry("when a signed header is changed", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Header.Set("X-Forwarded-Email", "admin@example.com")
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("when a signed header is removed", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Header.Del("X-Forwarded-Email")
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("when the headers are replayed with another method", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Method = "DELETE"
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("when the headers are replayed to another host", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Host = "admin.example.com"
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("when the headers are replayed to another path", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.URL.Path = "/admin"
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("when the query is changed", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.URL.RawQuery = "delete=true"
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("when the timestamp is changed", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Header.Set(IdentitySignatureHeader, "t=1700000030"+req.Header.Get(IdentitySignatureHeader)[len("t=1700000000"):])
+				},
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("with a different key", verifyTableInput{
+				key:         []byte("fedcba9876543210fedcba9876543210"),
+				now:         signedAt,
+				expectedErr: "signature does not match the request",
+			}),
+			Entry("without a signature header", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Header.Del(IdentitySignatureHeader)
+				},
+				now:         signedAt,
+				expectedErr: "missing X-Identity-Signature header",
+			}),
+			Entry("with a malformed signature header", verifyTableInput{
+				modify: func(req *http.Request) {
+					req.Header.Set(IdentitySignatureHeader, "t=1700000000,s=abc")
+				},
+				now:         signedAt,
+				expectedErr: "t, h and s are required",
+			}),
+		)
+	})
+})
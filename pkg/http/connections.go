@@ -0,0 +1,140 @@
+package http
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connTracker tracks the open connections accepted by its listener.
+// The http.Server forgets about connections once they are hijacked, eg for
+// WebSockets, so they have to be tracked to be closed when the server shuts
+// down.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[*trackedConn]struct{}
+	hijacked map[*trackedConn]struct{}
+	closed   chan struct{}
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns:    map[*trackedConn]struct{}{},
+		hijacked: map[*trackedConn]struct{}{},
+		closed:   make(chan struct{}, 1),
+	}
+}
+
+// listener wraps the listener so that the connections it accepts are tracked.
+// TLS listeners must wrap the tracking listener, so that the http.Server
+// still sees the TLS connections.
+func (t *connTracker) listener(l net.Listener) net.Listener {
+	return &trackingListener{Listener: l, tracker: t}
+}
+
+// connState is the http.Server ConnState hook, it records the connections
+// that are hijacked
+func (t *connTracker) connState(conn net.Conn, state http.ConnState) {
+	if state != http.StateHijacked {
+		return
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tracked, ok := conn.(*trackedConn)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, open := t.conns[tracked]; open {
+		t.hijacked[tracked] = struct{}{}
+	}
+}
+
+// hijackedCount returns the number of hijacked connections that are open
+func (t *connTracker) hijackedCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.hijacked)
+}
+
+// closeHijacked closes the hijacked connections that are still open and
+// returns how many were closed
+func (t *connTracker) closeHijacked() int {
+	t.mu.Lock()
+	conns := make([]*trackedConn, 0, len(t.hijacked))
+	for conn := range t.hijacked {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	return len(conns)
+}
+
+func (t *connTracker) add(conn *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[conn] = struct{}{}
+}
+
+func (t *connTracker) remove(conn *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+	if _, ok := t.hijacked[conn]; ok {
+		delete(t.hijacked, conn)
+		// Wake up a shutdown waiting for the hijacked connections to close
+		select {
+		case t.closed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trackingListener adds the connections it accepts to its tracker
+type trackingListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+// Accept waits for and returns the next connection to the listener
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tracked := &trackedConn{Conn: conn, tracker: l.tracker}
+	l.tracker.add(tracked)
+	return tracked, nil
+}
+
+// trackedConn removes itself from its tracker when it is closed
+type trackedConn struct {
+	net.Conn
+	tracker   *connTracker
+	closeOnce sync.Once
+}
+
+// CloseWrite shuts down the writing side of the connection, the http.Server
+// uses it to close connections gracefully
+func (c *trackedConn) CloseWrite() error {
+	if closeWriter, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closeWriter.CloseWrite()
+	}
+	return nil
+}
+
+// Close closes the connection
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.tracker.remove(c)
+	})
+	return err
+}
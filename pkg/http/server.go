@@ -7,12 +7,16 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options/util"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -28,19 +32,48 @@ type Opts struct {
 	Handler http.Handler
 
 	// BindAddress is the address the HTTP server should listen on.
+	// Addresses with the unix:// scheme listen on a Unix domain socket.
 	BindAddress string
 
+	// SocketFileMode is the octal file mode of the Unix domain socket the
+	// HTTP server listens on. When empty, the umask of the process applies.
+	SocketFileMode string
+
 	// SecureBindAddress is the address the HTTPS server should listen on.
 	SecureBindAddress string
 
 	// TLS is the TLS configuration for the server.
 	TLS *options.TLS
+
+	// EnableHTTP2 allows clients to use HTTP/2, negotiated during the TLS
+	// handshake or sent in cleartext (h2c) to the HTTP server.
+	EnableHTTP2 bool
+
+	// ShutdownTimeout is how long in-flight requests may take to finish once
+	// the server is shutting down, before their connections are closed.
+	// If zero, the server waits for all in-flight requests.
+	ShutdownTimeout time.Duration
+
+	// StreamShutdownTimeout is how long hijacked connections, eg WebSockets,
+	// are kept open once the server is shutting down, before they are closed.
+	// If zero, they are closed once the in-flight requests have finished.
+	StreamShutdownTimeout time.Duration
 }
 
 // NewServer creates a new Server from the options given.
 func NewServer(opts Opts) (Server, error) {
 	s := &server{
-		handler: opts.Handler,
+		handler:               opts.Handler,
+		tlsHandler:            opts.Handler,
+		conns:                 newConnTracker(),
+		tlsConns:              newConnTracker(),
+		shutdownTimeout:       opts.ShutdownTimeout,
+		streamShutdownTimeout: opts.StreamShutdownTimeout,
+	}
+	if opts.EnableHTTP2 {
+		// HTTP/2 over TLS is served by the http.Server once negotiated,
+		// only the cleartext listener needs to recognise h2c requests.
+		s.handler = h2c.NewHandler(opts.Handler, &http2.Server{})
 	}
 	if err := s.setupListener(opts); err != nil {
 		return nil, fmt.Errorf("error setting up listener: %v", err)
@@ -54,10 +87,16 @@ func NewServer(opts Opts) (Server, error) {
 
 // server is an implementation of the Server interface.
 type server struct {
-	handler http.Handler
+	handler    http.Handler
+	tlsHandler http.Handler
 
 	listener    net.Listener
 	tlsListener net.Listener
+
+	conns                 *connTracker
+	tlsConns              *connTracker
+	shutdownTimeout       time.Duration
+	streamShutdownTimeout time.Duration
 }
 
 // setupListener sets the server listener if the HTTP server is enabled.
@@ -72,15 +111,76 @@ func (s *server) setupListener(opts Opts) error {
 	networkType := getNetworkScheme(opts.BindAddress)
 	listenAddr := getListenAddress(opts.BindAddress)
 
+	if networkType == "unix" {
+		listener, err := listenUnixSocket(listenAddr, opts.SocketFileMode)
+		if err != nil {
+			return fmt.Errorf("listen (%s, %s) failed: %v", networkType, listenAddr, err)
+		}
+		s.listener = s.conns.listener(listener)
+		return nil
+	}
+
 	listener, err := net.Listen(networkType, listenAddr)
 	if err != nil {
 		return fmt.Errorf("listen (%s, %s) failed: %v", networkType, listenAddr, err)
 	}
-	s.listener = listener
+	s.listener = s.conns.listener(listener)
 
 	return nil
 }
 
+// listenUnixSocket listens on the Unix domain socket at the path and sets the
+// file mode of the socket.
+// A socket file left behind by a server that was not shut down cleanly is
+// removed first. The socket file is removed when the listener is closed.
+func listenUnixSocket(path string, fileMode string) (net.Listener, error) {
+	var mode os.FileMode
+	if fileMode != "" {
+		parsed, err := strconv.ParseUint(fileMode, 8, 32)
+		if err != nil || parsed > 0o777 {
+			return nil, fmt.Errorf("invalid socket file mode %q", fileMode)
+		}
+		mode = os.FileMode(parsed)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if fileMode != "" {
+		if err := os.Chmod(path, mode); err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("could not set socket file mode: %v", err)
+		}
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket file at the path if no server is
+// listening on it anymore. Files that are not sockets are never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s already exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
 func parseCipherSuites(names []string) ([]uint16, error) {
 	cipherNameMap := make(map[string]uint16)
 
@@ -116,6 +216,9 @@ func (s *server) setupTLSListener(opts Opts) error {
 		MaxVersion: tls.VersionTLS13,
 		NextProtos: []string{"http/1.1"},
 	}
+	if opts.EnableHTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
 	if opts.TLS == nil {
 		return errors.New("no TLS config provided")
 	}
@@ -151,7 +254,7 @@ func (s *server) setupTLSListener(opts Opts) error {
 		return fmt.Errorf("listen (%s) failed: %v", listenAddr, err)
 	}
 
-	s.tlsListener = tls.NewListener(tcpKeepAliveListener{listener.(*net.TCPListener)}, config)
+	s.tlsListener = tls.NewListener(s.tlsConns.listener(tcpKeepAliveListener{listener.(*net.TCPListener)}), config)
 	return nil
 }
 
@@ -163,7 +266,7 @@ func (s *server) Start(ctx context.Context) error {
 
 	if s.listener != nil {
 		g.Go(func() error {
-			if err := s.startServer(groupCtx, s.listener); err != nil {
+			if err := s.startServer(groupCtx, s.listener, s.conns, s.handler); err != nil {
 				return fmt.Errorf("error starting insecure server: %v", err)
 			}
 			return nil
@@ -172,7 +275,7 @@ func (s *server) Start(ctx context.Context) error {
 
 	if s.tlsListener != nil {
 		g.Go(func() error {
-			if err := s.startServer(groupCtx, s.tlsListener); err != nil {
+			if err := s.startServer(groupCtx, s.tlsListener, s.tlsConns, s.tlsHandler); err != nil {
 				return fmt.Errorf("error starting secure server: %v", err)
 			}
 			return nil
@@ -182,20 +285,17 @@ func (s *server) Start(ctx context.Context) error {
 	return g.Wait()
 }
 
-// startServer creates and starts a new server with the given listener.
+// startServer creates and starts a new server with the given listener, whose
+// connections are tracked by conns.
 // When the given context is cancelled the server will be shutdown.
 // If any errors occur, only the first error will be returned.
-func (s *server) startServer(ctx context.Context, listener net.Listener) error {
-	srv := &http.Server{Handler: s.handler, ReadHeaderTimeout: time.Minute}
+func (s *server) startServer(ctx context.Context, listener net.Listener, conns *connTracker, handler http.Handler) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: time.Minute, ConnState: conns.connState}
 	g, groupCtx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		<-groupCtx.Done()
-
-		if err := srv.Shutdown(context.Background()); err != nil {
-			return fmt.Errorf("error shutting down server: %v", err)
-		}
-		return nil
+		return s.shutdown(srv, conns)
 	})
 
 	g.Go(func() error {
@@ -208,6 +308,66 @@ func (s *server) startServer(ctx context.Context, listener net.Listener) error {
 	return g.Wait()
 }
 
+// shutdown stops the server from accepting new connections and lets the
+// in-flight requests finish within the shutdown timeout.
+// Hijacked connections are closed after the stream shutdown timeout, or once
+// the in-flight requests have finished when there is no stream shutdown timeout.
+func (s *server) shutdown(srv *http.Server, conns *connTracker) error {
+	var streamDeadline <-chan time.Time
+	if s.streamShutdownTimeout > 0 {
+		streamTimer := time.NewTimer(s.streamShutdownTimeout)
+		defer streamTimer.Stop()
+		streamDeadline = streamTimer.C
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.drain(srv)
+	}()
+
+	var err error
+	for drained != nil || (streamDeadline != nil && conns.hijackedCount() > 0) {
+		select {
+		case err = <-drained:
+			drained = nil
+		case <-conns.closed:
+		case <-streamDeadline:
+			streamDeadline = nil
+			closeHijacked(conns)
+		}
+	}
+	closeHijacked(conns)
+	return err
+}
+
+// drain shuts down the server, closing the connections that are still in use
+// after the shutdown timeout
+func (s *server) drain(srv *http.Server) error {
+	ctx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.Printf("Closing the connections of requests still in flight after the shutdown timeout of %s", s.shutdownTimeout)
+		err = srv.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error shutting down server: %v", err)
+	}
+	return nil
+}
+
+// closeHijacked closes the hijacked connections that are still open
+func closeHijacked(conns *connTracker) {
+	if closed := conns.closeHijacked(); closed > 0 {
+		logger.Printf("Closed %d hijacked connections, e.g. WebSockets, on shutdown", closed)
+	}
+}
+
 // getNetworkScheme gets the scheme for the HTTP server.
 func getNetworkScheme(addr string) string {
 	var scheme string
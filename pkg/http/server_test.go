@@ -1,16 +1,23 @@
 package http
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
 )
 
 const hello = "Hello World!"
@@ -655,6 +662,18 @@ var _ = Describe("Server", func() {
 				Expect(resp.TLS.VerifiedChains[0]).Should(HaveLen(1))
 				Expect(resp.TLS.VerifiedChains[0][0].Raw).Should(Equal(ipv4CertData))
 			})
+
+			It("Does not negotiate HTTP/2", func() {
+				go func() {
+					defer GinkgoRecover()
+					Expect(srv.Start(ctx)).To(Succeed())
+				}()
+
+				resp, err := client.Get(secureListenAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.ProtoMajor).To(Equal(1))
+			})
 		})
 
 		Context("with both an ipv4 http and an ipv4 https server", func() {
@@ -734,6 +753,85 @@ var _ = Describe("Server", func() {
 			})
 		})
 
+		Context("with an ipv4 http and an ipv4 https server with HTTP/2 enabled", func() {
+			var listenAddr, secureListenAddr string
+
+			BeforeEach(func() {
+				var err error
+				srv, err = NewServer(Opts{
+					Handler:           handler,
+					BindAddress:       "127.0.0.1:0",
+					SecureBindAddress: "127.0.0.1:0",
+					TLS: &options.TLS{
+						Key:  &ipv4KeyDataSource,
+						Cert: &ipv4CertDataSource,
+					},
+					EnableHTTP2: true,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				s, ok := srv.(*server)
+				Expect(ok).To(BeTrue())
+
+				listenAddr = fmt.Sprintf("http://%s/", s.listener.Addr().String())
+				secureListenAddr = fmt.Sprintf("https://%s/", s.tlsListener.Addr().String())
+			})
+
+			It("Serves HTTP/2 with prior knowledge on http", func() {
+				go func() {
+					defer GinkgoRecover()
+					Expect(srv.Start(ctx)).To(Succeed())
+				}()
+
+				h2cClient := &http.Client{
+					Transport: &http2.Transport{
+						AllowHTTP: true,
+						DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+							var dialer net.Dialer
+							return dialer.DialContext(ctx, network, addr)
+						},
+					},
+				}
+
+				resp, err := h2cClient.Get(listenAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.ProtoMajor).To(Equal(2))
+
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal(hello))
+			})
+
+			It("Still serves HTTP/1.1 on http", func() {
+				go func() {
+					defer GinkgoRecover()
+					Expect(srv.Start(ctx)).To(Succeed())
+				}()
+
+				resp, err := client.Get(listenAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.ProtoMajor).To(Equal(1))
+			})
+
+			It("Negotiates HTTP/2 on https", func() {
+				go func() {
+					defer GinkgoRecover()
+					Expect(srv.Start(ctx)).To(Succeed())
+				}()
+
+				resp, err := client.Get(secureListenAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.ProtoMajor).To(Equal(2))
+
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal(hello))
+			})
+		})
+
 		Context("with an ipv6 http server", func() {
 			var listenAddr string
 
@@ -931,6 +1029,306 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	Context("with unix sockets", func() {
+		var dir string
+		var ctx context.Context
+		var cancel context.CancelFunc
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "oauth2-proxy-socket")
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		// unixClient sends all requests to the socket at the path
+		unixClient := func(path string) *http.Client {
+			return &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						return (&net.Dialer{}).DialContext(ctx, "unix", path)
+					},
+				},
+			}
+		}
+
+		It("Serves the handler on the socket and removes it on shutdown", func() {
+			path := filepath.Join(dir, "proxy.sock")
+			srv, err := NewServer(Opts{
+				Handler:        handler,
+				BindAddress:    "unix://" + path,
+				SocketFileMode: "0660",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			info, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode() & os.ModeSocket).ToNot(BeZero())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0o660)))
+
+			done := make(chan error)
+			go func() {
+				done <- srv.Start(ctx)
+			}()
+
+			resp, err := unixClient(path).Get("http://unix/")
+			Expect(err).ToNot(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal(hello))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			_, err = os.Stat(path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("Serves the app and metrics servers on separate sockets", func() {
+			appPath := filepath.Join(dir, "proxy.sock")
+			metricsPath := filepath.Join(dir, "metrics.sock")
+			app, err := NewServer(Opts{Handler: handler, BindAddress: "unix://" + appPath})
+			Expect(err).ToNot(HaveOccurred())
+			metrics, err := NewServer(Opts{
+				Handler: http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+					rw.WriteHeader(http.StatusNoContent)
+				}),
+				BindAddress: "unix://" + metricsPath,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			done := make(chan error)
+			go func() {
+				done <- NewServerGroup(app, metrics).Start(ctx)
+			}()
+
+			resp, err := unixClient(appPath).Get("http://unix/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			resp, err = unixClient(metricsPath).Get("http://unix/metrics")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			for _, path := range []string{appPath, metricsPath} {
+				_, err = os.Stat(path)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			}
+		})
+
+		It("Replaces a stale socket file", func() {
+			path := filepath.Join(dir, "proxy.sock")
+			stale, err := net.Listen("unix", path)
+			Expect(err).ToNot(HaveOccurred())
+			// Leave the socket file behind, as after a crash
+			stale.(*net.UnixListener).SetUnlinkOnClose(false)
+			Expect(stale.Close()).To(Succeed())
+
+			srv, err := NewServer(Opts{Handler: handler, BindAddress: "unix://" + path})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(srv.(*server).listener.Close()).To(Succeed())
+		})
+
+		It("Does not take over a socket that is in use", func() {
+			path := filepath.Join(dir, "proxy.sock")
+			inUse, err := net.Listen("unix", path)
+			Expect(err).ToNot(HaveOccurred())
+			defer inUse.Close()
+
+			_, err = NewServer(Opts{Handler: handler, BindAddress: "unix://" + path})
+			Expect(err).To(MatchError(fmt.Sprintf("error setting up listener: listen (unix, %s) failed: %s is already in use", path, path)))
+		})
+
+		It("Does not remove files that are not sockets", func() {
+			path := filepath.Join(dir, "proxy.sock")
+			Expect(os.WriteFile(path, []byte("data"), 0o600)).To(Succeed())
+
+			_, err := NewServer(Opts{Handler: handler, BindAddress: "unix://" + path})
+			Expect(err).To(MatchError(fmt.Sprintf("error setting up listener: listen (unix, %s) failed: %s already exists and is not a socket", path, path)))
+			Expect(path).To(BeAnExistingFile())
+		})
+
+		It("Rejects an invalid socket file mode", func() {
+			path := filepath.Join(dir, "proxy.sock")
+			_, err := NewServer(Opts{Handler: handler, BindAddress: "unix://" + path, SocketFileMode: "rw-rw----"})
+			Expect(err).To(MatchError(fmt.Sprintf("error setting up listener: listen (unix, %s) failed: invalid socket file mode \"rw-rw----\"", path)))
+		})
+	})
+
+	Context("Shutdown", func() {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		var release chan struct{}
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			release = make(chan struct{})
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		// startSlowServer starts a server whose requests to /slow take the
+		// delay, or until released, and whose requests to /stream hijack
+		// the connection
+		startSlowServer := func(opts Opts, delay time.Duration) (string, chan error) {
+			release := release
+			opts.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case "/slow":
+					select {
+					case <-time.After(delay):
+					case <-release:
+					}
+					rw.Write([]byte(hello))
+				case "/stream":
+					conn, buf, err := rw.(http.Hijacker).Hijack()
+					Expect(err).ToNot(HaveOccurred())
+					buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+					Expect(buf.Flush()).To(Succeed())
+					go func() {
+						// Hold the stream open until the client or the server closes it
+						_, _ = io.Copy(io.Discard, conn)
+						conn.Close()
+					}()
+				}
+			})
+			opts.BindAddress = "127.0.0.1:0"
+			srv, err := NewServer(opts)
+			Expect(err).ToNot(HaveOccurred())
+
+			done := make(chan error, 1)
+			go func() {
+				done <- srv.Start(ctx)
+			}()
+			return srv.(*server).listener.Addr().String(), done
+		}
+
+		// slowRequest sends a request to /slow and returns the body or error
+		slowRequest := func(addr string) chan error {
+			result := make(chan error, 1)
+			go func() {
+				resp, err := http.Get(fmt.Sprintf("http://%s/slow", addr))
+				if err != nil {
+					result <- err
+					return
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				if err == nil && string(body) != hello {
+					err = fmt.Errorf("unexpected body %q", body)
+				}
+				result <- err
+			}()
+			return result
+		}
+
+		// openStream opens a hijacked connection to /stream
+		openStream := func(addr string) net.Conn {
+			conn, err := net.Dial("tcp", addr)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+			return conn
+		}
+
+		// waitForActive waits until the server has a request in flight
+		waitForActive := func(addr string) {
+			Eventually(func() error {
+				conn, err := net.Dial("tcp", addr)
+				if err == nil {
+					conn.Close()
+				}
+				return err
+			}).Should(Succeed())
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		It("Lets in-flight requests finish within the shutdown timeout", func() {
+			addr, done := startSlowServer(Opts{ShutdownTimeout: 5 * time.Second}, 300*time.Millisecond)
+			result := slowRequest(addr)
+			waitForActive(addr)
+
+			cancel()
+			Eventually(result, 2*time.Second).Should(Receive(BeNil()))
+			Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+
+			// No new connections are accepted
+			_, err := net.Dial("tcp", addr)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Closes the connections of requests still in flight after the shutdown timeout", func() {
+			addr, done := startSlowServer(Opts{ShutdownTimeout: 100 * time.Millisecond}, time.Minute)
+			defer close(release)
+			result := slowRequest(addr)
+			waitForActive(addr)
+
+			start := time.Now()
+			cancel()
+			Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Eventually(result, 2*time.Second).Should(Receive(HaveOccurred()))
+		})
+
+		It("Closes streams after the stream shutdown timeout", func() {
+			addr, done := startSlowServer(Opts{
+				ShutdownTimeout:       5 * time.Second,
+				StreamShutdownTimeout: 200 * time.Millisecond,
+			}, 500*time.Millisecond)
+			stream := openStream(addr)
+			defer stream.Close()
+			result := slowRequest(addr)
+			waitForActive(addr)
+
+			start := time.Now()
+			cancel()
+
+			// The stream is closed by the server before the slow request finishes
+			stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err := stream.Read(make([]byte, 1))
+			Expect(err).To(MatchError(io.EOF))
+			Expect(time.Since(start)).To(And(
+				BeNumerically(">=", 200*time.Millisecond),
+				BeNumerically("<", 500*time.Millisecond),
+			))
+
+			Eventually(result, 2*time.Second).Should(Receive(BeNil()))
+			Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+		})
+
+		It("Returns once the streams close before the stream shutdown timeout", func() {
+			addr, done := startSlowServer(Opts{StreamShutdownTimeout: time.Minute}, 0)
+			stream := openStream(addr)
+
+			cancel()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(stream.Close()).To(Succeed())
+			Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+		})
+
+		It("Closes streams once the in-flight requests finish without a stream shutdown timeout", func() {
+			addr, done := startSlowServer(Opts{}, 0)
+			stream := openStream(addr)
+			defer stream.Close()
+
+			cancel()
+			Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+			stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err := stream.Read(make([]byte, 1))
+			Expect(err).To(MatchError(io.EOF))
+		})
+	})
+
 	Context("getNetworkScheme", func() {
 		DescribeTable("should return the scheme", func(in, expected string) {
 			Expect(getNetworkScheme(in)).To(Equal(expected))
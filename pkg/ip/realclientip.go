@@ -9,12 +9,28 @@ import (
 	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
 )
 
-func GetRealClientIPParser(headerKey string) (ipapi.RealClientIPParser, error) {
+// GetRealClientIPParser returns the parser for the client IP in the given header.
+// When trustedProxies are given, the header is only read when the request comes
+// from a trusted proxy. It is read from right to left and the first address
+// that is not a trusted proxy is taken as the client IP.
+func GetRealClientIPParser(headerKey string, trustedProxies []string) (ipapi.RealClientIPParser, error) {
 	headerKey = http.CanonicalHeaderKey(headerKey)
 
+	var trusted *NetSet
+	if len(trustedProxies) > 0 {
+		trusted = NewNetSet()
+		for _, cidr := range trustedProxies {
+			ipNet := ParseIPNet(cidr)
+			if ipNet == nil {
+				return nil, fmt.Errorf("could not parse trusted proxy (%s)", cidr)
+			}
+			trusted.AddIPNet(*ipNet)
+		}
+	}
+
 	switch headerKey {
 	case http.CanonicalHeaderKey("X-Forwarded-For"), http.CanonicalHeaderKey("X-Real-IP"), http.CanonicalHeaderKey("X-ProxyUser-IP"):
-		return &xForwardedForClientIPParser{header: headerKey}, nil
+		return &xForwardedForClientIPParser{header: headerKey, trustedProxies: trusted}, nil
 	}
 
 	// TODO: implement the more standardized but more complex `Forwarded` header.
@@ -22,7 +38,8 @@ func GetRealClientIPParser(headerKey string) (ipapi.RealClientIPParser, error) {
 }
 
 type xForwardedForClientIPParser struct {
-	header string
+	header         string
+	trustedProxies *NetSet
 }
 
 // GetRealClientIP obtain the IP address of the end-user (not proxy).
@@ -31,9 +48,15 @@ type xForwardedForClientIPParser struct {
 // Returns the `<client>` portion specified in the above document.
 // Additionally, is capable of parsing IPs with the port included, for v4 in the format "<ip>:<port>" and for v6 in the
 // format "[<ip>]:<port>".  With-port and without-port formats are seamlessly supported concurrently.
-func (p xForwardedForClientIPParser) GetRealClientIP(h http.Header) (net.IP, error) {
+// When trusted proxies are configured, the `<client>` portion can be spoofed by the
+// client, so the chain is walked from right to left skipping trusted proxies instead.
+func (p xForwardedForClientIPParser) GetRealClientIP(req *http.Request) (net.IP, error) {
+	if p.trustedProxies != nil {
+		return p.getTrustedClientIP(req)
+	}
+
 	var ipStr string
-	if realIP := h.Get(p.header); realIP != "" {
+	if realIP := req.Header.Get(p.header); realIP != "" {
 		ipStr = realIP
 	} else {
 		return nil, nil
@@ -46,6 +69,50 @@ func (p xForwardedForClientIPParser) GetRealClientIP(h http.Header) (net.IP, err
 	}
 	ipStr = strings.TrimSpace(ipStr)
 
+	return p.parseIP(ipStr)
+}
+
+// getTrustedClientIP returns the right-most address in the header that is not
+// a trusted proxy. Every proxy appends the address it received the request
+// from, so entries to the left of the first untrusted address could have been
+// set by the client. If every address is trusted the left-most one is used.
+// Multiple headers are treated as a single comma separated list.
+// The header is ignored when the request was not sent by a trusted proxy, as
+// the whole header could then have been set by the client.
+func (p xForwardedForClientIPParser) getTrustedClientIP(req *http.Request) (net.IP, error) {
+	remoteIP, err := getRemoteIP(req)
+	if err != nil {
+		return nil, err
+	}
+	if !p.trustedProxies.Has(remoteIP) {
+		return remoteIP, nil
+	}
+
+	var entries []string
+	for _, value := range req.Header.Values(p.header) {
+		entries = append(entries, strings.Split(value, ",")...)
+	}
+
+	var ip net.IP
+	for i := len(entries) - 1; i >= 0; i-- {
+		ipStr := strings.TrimSpace(entries[i])
+		if ipStr == "" {
+			continue
+		}
+
+		var err error
+		ip, err = p.parseIP(ipStr)
+		if err != nil {
+			return nil, err
+		}
+		if !p.trustedProxies.Has(ip) {
+			return ip, nil
+		}
+	}
+	return ip, nil
+}
+
+func (p xForwardedForClientIPParser) parseIP(ipStr string) (net.IP, error) {
 	if ipHost, _, err := net.SplitHostPort(ipStr); err == nil {
 		ipStr = ipHost
 	}
@@ -61,7 +128,7 @@ func (p xForwardedForClientIPParser) GetRealClientIP(h http.Header) (net.IP, err
 // GetClientIP obtains the perceived end-user IP address from headers if p != nil else from req.RemoteAddr.
 func GetClientIP(p ipapi.RealClientIPParser, req *http.Request) (net.IP, error) {
 	if p != nil {
-		return p.GetRealClientIP(req.Header)
+		return p.GetRealClientIP(req)
 	}
 	return getRemoteIP(req)
 }
@@ -81,7 +148,7 @@ func getRemoteIP(req *http.Request) (net.IP, error) {
 func GetClientString(p ipapi.RealClientIPParser, req *http.Request, full bool) (s string) {
 	var realClientIPStr string
 	if p != nil {
-		if realClientIP, err := p.GetRealClientIP(req.Header); err == nil && realClientIP != nil {
+		if realClientIP, err := p.GetRealClientIP(req); err == nil && realClientIP != nil {
 			realClientIPStr = realClientIP.String()
 		}
 	}
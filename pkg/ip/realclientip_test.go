@@ -27,7 +27,7 @@ func TestGetRealClientIPParser(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		p, err := GetRealClientIPParser(test.header)
+		p, err := GetRealClientIPParser(test.header, nil)
 
 		if test.errString == "" {
 			assert.Nil(t, err)
@@ -69,10 +69,75 @@ func TestXForwardedForClientIPParser(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		h := http.Header{}
-		h.Add("X-Forwarded-For", test.headerValue)
+		req := &http.Request{Header: http.Header{}}
+		req.Header.Add("X-Forwarded-For", test.headerValue)
+
+		ip, err := p.GetRealClientIP(req)
+
+		if test.errString == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.NotNil(t, err)
+			assert.Equal(t, test.errString, err.Error())
+		}
+
+		if test.expectedIP == nil {
+			assert.Nil(t, ip)
+		} else {
+			assert.NotNil(t, ip)
+			assert.Equal(t, test.expectedIP, ip)
+		}
+	}
+}
+
+func TestGetRealClientIPParserInvalidTrustedProxy(t *testing.T) {
+	p, err := GetRealClientIPParser("X-Forwarded-For", []string{"10.0.0.0/8", "10.0.0.0/abc"})
+	assert.Nil(t, p)
+	assert.NotNil(t, err)
+	assert.Equal(t, "could not parse trusted proxy (10.0.0.0/abc)", err.Error())
+}
+
+func TestXForwardedForClientIPParserWithTrustedProxies(t *testing.T) {
+	p, err := GetRealClientIPParser("X-Forwarded-For", []string{"10.0.0.0/8", "192.168.0.1", "fd00::/8"})
+	assert.Nil(t, err)
+
+	const trustedPeer = "10.0.0.9:43670"
+
+	tests := []struct {
+		remoteAddr   string
+		headerValues []string
+		errString    string
+		expectedIP   net.IP
+	}{
+		{trustedPeer, nil, "", nil},
+		{trustedPeer, []string{"1.2.3.4"}, "", net.ParseIP("1.2.3.4")},
+		{trustedPeer, []string{"1.2.3.4, 10.0.0.1"}, "", net.ParseIP("1.2.3.4")},
+		{trustedPeer, []string{"1.2.3.4, 10.0.0.1, 192.168.0.1"}, "", net.ParseIP("1.2.3.4")},
+		// Entries left of the first untrusted address may be spoofed by the client
+		{trustedPeer, []string{"10.0.0.5, 6.6.6.6, 1.2.3.4, 10.0.0.1"}, "", net.ParseIP("1.2.3.4")},
+		{trustedPeer, []string{"5.6.7.8, 1.2.3.4:4321, 10.0.0.1:1234"}, "", net.ParseIP("1.2.3.4")},
+		{trustedPeer, []string{"spoofed, 1.2.3.4, 10.0.0.1"}, "", net.ParseIP("1.2.3.4")},
+		{trustedPeer, []string{"1.2.3.4, 192.168.0.2, 10.0.0.1"}, "", net.ParseIP("192.168.0.2")},
+		{"[fd00::2]:443", []string{"2001:db8::1, [fd00::1]:443"}, "", net.ParseIP("2001:db8::1")},
+		// When every hop is trusted the left-most address is the client
+		{trustedPeer, []string{"10.0.0.3, 10.0.0.2, 10.0.0.1"}, "", net.ParseIP("10.0.0.3")},
+		// Multiple headers are a single list
+		{trustedPeer, []string{"5.6.7.8, 1.2.3.4", "10.0.0.1"}, "", net.ParseIP("1.2.3.4")},
+		{trustedPeer, []string{"1.2.3.4, nil, 10.0.0.1"}, "unable to parse ip (nil) from X-Forwarded-For header", nil},
+		// The header is ignored when the request is not sent by a trusted proxy
+		{"6.6.6.6:43670", nil, "", net.ParseIP("6.6.6.6")},
+		{"6.6.6.6:43670", []string{"1.2.3.4, 10.0.0.1"}, "", net.ParseIP("6.6.6.6")},
+		{"6.6.6.6:43670", []string{"10.0.0.3"}, "", net.ParseIP("6.6.6.6")},
+		{"", []string{"1.2.3.4"}, "unable to get ip and port from http.RemoteAddr ()", nil},
+	}
+
+	for _, test := range tests {
+		req := &http.Request{RemoteAddr: test.remoteAddr, Header: http.Header{}}
+		for _, value := range test.headerValues {
+			req.Header.Add("X-Forwarded-For", value)
+		}
 
-		ip, err := p.GetRealClientIP(h)
+		ip, err := p.GetRealClientIP(req)
 
 		if test.errString == "" {
 			assert.Nil(t, err)
@@ -93,12 +158,12 @@ func TestXForwardedForClientIPParser(t *testing.T) {
 func TestXForwardedForClientIPParserIgnoresOthers(t *testing.T) {
 	p := &xForwardedForClientIPParser{header: http.CanonicalHeaderKey("X-Forwarded-For")}
 
-	h := http.Header{}
+	req := &http.Request{Header: http.Header{}}
 	expectedIPString := "192.168.10.50"
-	h.Add("X-Real-IP", "10.0.0.1")
-	h.Add("X-ProxyUser-IP", "10.0.0.1")
-	h.Add("X-Forwarded-For", expectedIPString)
-	ip, err := p.GetRealClientIP(h)
+	req.Header.Add("X-Real-IP", "10.0.0.1")
+	req.Header.Add("X-ProxyUser-IP", "10.0.0.1")
+	req.Header.Add("X-Forwarded-For", expectedIPString)
+	ip, err := p.GetRealClientIP(req)
 	assert.Nil(t, err)
 	assert.NotNil(t, ip)
 	assert.Equal(t, ip, net.ParseIP(expectedIPString))
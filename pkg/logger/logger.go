@@ -2,12 +2,14 @@ package logger
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
@@ -30,6 +32,15 @@ const (
 	// DefaultRequestLoggingFormat defines the default request log format
 	DefaultRequestLoggingFormat = "{{.Client}} - {{.RequestID}} - {{.Username}} [{{.Timestamp}}] {{.Host}} {{.RequestMethod}} {{.Upstream}} {{.RequestURI}} {{.Protocol}} {{.UserAgent}} {{.StatusCode}} {{.ResponseSize}} {{.RequestDuration}}"
 
+	// RequestLoggingFormatTypeText renders request logs with the request logging format template
+	RequestLoggingFormatTypeText = "text"
+	// RequestLoggingFormatTypeJSON renders request logs as one JSON object per line
+	RequestLoggingFormatTypeJSON = "json"
+
+	// RedactedQueryValue replaces the values of redacted query parameters in
+	// the logged request URI
+	RedactedQueryValue = "REDACTED"
+
 	// AuthSuccess indicates that an auth attempt has succeeded explicitly
 	AuthSuccess AuthStatus = "AuthSuccess"
 	// AuthFailure indicates that an auth attempt has failed explicitly
@@ -52,6 +63,10 @@ const (
 	ERROR
 )
 
+// DefaultRedactedQueryParams are the query parameters redacted from the logged
+// request URI by default, as they carry authorization codes and tokens
+var DefaultRedactedQueryParams = []string{"code", "state", "access_token"}
+
 // These are the containers for all values that are available as variables in the logging formats.
 // All values are pre-formatted strings so it is easy to use them in the format string.
 type stdLogMessageData struct {
@@ -89,6 +104,25 @@ type reqLogMessageData struct {
 	Username string
 }
 
+// reqLogJSONData is the structure of request log lines when logging in JSON.
+// Every field is always present so that parsers see a consistent shape, even
+// when the value is empty.
+type reqLogJSONData struct {
+	Timestamp         string  `json:"timestamp"`
+	ClientIP          string  `json:"client_ip"`
+	RequestID         string  `json:"request_id"`
+	User              string  `json:"user"`
+	Host              string  `json:"host"`
+	Method            string  `json:"method"`
+	Upstream          string  `json:"upstream"`
+	URI               string  `json:"uri"`
+	Protocol          string  `json:"protocol"`
+	UserAgent         string  `json:"user_agent"`
+	Status            int     `json:"status"`
+	ResponseBytes     int     `json:"response_bytes"`
+	RequestDurationMS float64 `json:"request_duration_ms"`
+}
+
 // Returns the apparent "real client IP" as a string.
 type GetClientFunc = func(r *http.Request) string
 
@@ -107,9 +141,11 @@ type Logger struct {
 	reqEnabled     bool
 	getClientFunc  GetClientFunc
 	excludePaths   map[string]struct{}
+	redactParams   map[string]struct{}
 	stdLogTemplate *template.Template
 	authTemplate   *template.Template
 	reqTemplate    *template.Template
+	reqFormatType  string
 }
 
 // New creates a new Standarderr Logger.
@@ -123,9 +159,11 @@ func New(flag int) *Logger {
 		reqEnabled:     true,
 		getClientFunc:  func(r *http.Request) string { return r.RemoteAddr },
 		excludePaths:   nil,
+		redactParams:   toSet(DefaultRedactedQueryParams),
 		stdLogTemplate: template.Must(template.New("std-log").Parse(DefaultStandardLoggingFormat)),
 		authTemplate:   template.Must(template.New("auth-log").Parse(DefaultAuthLoggingFormat)),
 		reqTemplate:    template.Must(template.New("req-log").Parse(DefaultRequestLoggingFormat)),
+		reqFormatType:  RequestLoggingFormatTypeText,
 	}
 }
 
@@ -233,20 +271,11 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 		return
 	}
 
-	duration := float64(time.Since(ts)) / float64(time.Second)
-
-	if username == "" {
-		username = "-"
-	}
-
-	if upstream == "" {
-		upstream = "-"
-	}
+	elapsed := time.Since(ts)
+	duration := float64(elapsed) / float64(time.Second)
 
-	if url.User != nil && username == "-" {
-		if name := url.User.Username(); name != "" {
-			username = name
-		}
+	if url.User != nil && username == "" {
+		username = url.User.Username()
 	}
 
 	client := l.getClientFunc(req)
@@ -255,6 +284,35 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 	defer l.mu.Unlock()
 
 	scope := middlewareapi.GetRequestScope(req)
+	redactedURL := l.redactQuery(url)
+	uri := redactedURL.RequestURI()
+	if l.reqFormatType == RequestLoggingFormatTypeJSON {
+		l.printReqJSON(reqLogJSONData{
+			Timestamp:         l.formatJSONTimestamp(ts),
+			ClientIP:          client,
+			RequestID:         scope.RequestID,
+			User:              username,
+			Host:              requestutil.GetRequestHost(req),
+			Method:            req.Method,
+			Upstream:          upstream,
+			URI:               uri,
+			Protocol:          req.Proto,
+			UserAgent:         req.UserAgent(),
+			Status:            status,
+			ResponseBytes:     size,
+			RequestDurationMS: float64(elapsed.Microseconds()) / 1000,
+		})
+		return
+	}
+
+	if username == "" {
+		username = "-"
+	}
+
+	if upstream == "" {
+		upstream = "-"
+	}
+
 	err := l.reqTemplate.Execute(l.writer, reqLogMessageData{
 		Client:          client,
 		Host:            requestutil.GetRequestHost(req),
@@ -262,7 +320,7 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 		RequestID:       scope.RequestID,
 		RequestDuration: fmt.Sprintf("%0.3f", duration),
 		RequestMethod:   req.Method,
-		RequestURI:      fmt.Sprintf("%q", url.RequestURI()),
+		RequestURI:      fmt.Sprintf("%q", uri),
 		ResponseSize:    fmt.Sprintf("%d", size),
 		StatusCode:      fmt.Sprintf("%d", status),
 		Timestamp:       FormatTimestamp(ts),
@@ -280,6 +338,55 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 	}
 }
 
+// redactQuery replaces the values of the redacted query parameters of the url.
+// The other parameters and their order are kept as they were requested.
+// The caller must hold the lock.
+func (l *Logger) redactQuery(u url.URL) url.URL {
+	if u.RawQuery == "" || len(l.redactParams) == 0 {
+		return u
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		rawKey, _, hasValue := strings.Cut(param, "=")
+		if !hasValue {
+			continue
+		}
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if _, ok := l.redactParams[key]; ok {
+			params[i] = rawKey + "=" + RedactedQueryValue
+		}
+	}
+	u.RawQuery = strings.Join(params, "&")
+	return u
+}
+
+// printReqJSON writes the request log data as a single line of JSON.
+// The caller must hold the lock.
+func (l *Logger) printReqJSON(data reqLogJSONData) {
+	line, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = l.writer.Write(append(line, '\n'))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// formatJSONTimestamp returns an RFC 3339 timestamp for JSON log lines.
+func (l *Logger) formatJSONTimestamp(ts time.Time) string {
+	if l.flag&LUTC != 0 {
+		ts = ts.UTC()
+	}
+
+	return ts.Format(time.RFC3339Nano)
+}
+
 // GetFileLineString will find the caller file and line number
 // taking in to account the calldepth to iterate up the stack
 // to find the non-logging call location.
@@ -374,10 +481,24 @@ func (l *Logger) SetGetClientFunc(f GetClientFunc) {
 func (l *Logger) SetExcludePaths(s []string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.excludePaths = make(map[string]struct{})
-	for _, p := range s {
-		l.excludePaths[p] = struct{}{}
+	l.excludePaths = toSet(s)
+}
+
+// SetRedactQueryParams sets the query parameters whose values are redacted
+// from the logged request URI.
+func (l *Logger) SetRedactQueryParams(s []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redactParams = toSet(s)
+}
+
+// toSet returns the set of the strings
+func toSet(s []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(s))
+	for _, v := range s {
+		set[v] = struct{}{}
 	}
+	return set
 }
 
 // SetStandardTemplate sets the template for standard logging.
@@ -401,6 +522,14 @@ func (l *Logger) SetReqTemplate(t string) {
 	l.reqTemplate = template.Must(template.New("req-log").Parse(t))
 }
 
+// SetReqFormatType sets the format type for request logging.
+// Request logs use the request template unless the type is json.
+func (l *Logger) SetReqFormatType(t string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reqFormatType = t
+}
+
 // These functions utilize the standard logger.
 
 // FormatTimestamp returns a formatted timestamp for the standard logger.
@@ -467,6 +596,12 @@ func SetExcludePaths(s []string) {
 	std.SetExcludePaths(s)
 }
 
+// SetRedactQueryParams sets the query parameters redacted from the logged
+// request URI, eg: authorization codes
+func SetRedactQueryParams(s []string) {
+	std.SetRedactQueryParams(s)
+}
+
 // SetStandardTemplate sets the template for standard logging for
 // the standard logger.
 func SetStandardTemplate(t string) {
@@ -485,6 +620,11 @@ func SetReqTemplate(t string) {
 	std.SetReqTemplate(t)
 }
 
+// SetReqFormatType sets the format type for request logging for the standard logger.
+func SetReqFormatType(t string) {
+	std.SetReqFormatType(t)
+}
+
 // Print calls Output to print to the standard logger.
 // Arguments are handled in the manner of fmt.Print.
 func Print(v ...interface{}) {
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/apikey"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// NewAPIKeySessionLoader creates a new handler that loads a session for the
+// identity of the API key in the given request header, so that machine
+// clients can authenticate without the OAuth flow.
+func NewAPIKeySessionLoader(validator apikey.Validator, header string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return loadAPIKeySession(validator, header, next)
+	}
+}
+
+// loadAPIKeySession attempts to load a session from the API key in the
+// header of the request.
+// The session only lives for the request, it is never saved to the session
+// store. The header is removed from requests with a valid key so that the key
+// is not sent to the upstreams.
+// If no key is found, or the key is invalid, no session will be loaded and
+// the request will be passed to the next handler.
+// If a session was loaded by a previous handler, it will not be replaced.
+func loadAPIKeySession(validator apikey.Validator, header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middlewareapi.GetRequestScope(req)
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		if scope.Session != nil {
+			// The session was already loaded, pass to the next handler
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		key := req.Header.Get(header)
+		if key == "" {
+			// No API key provided, so don't attempt to load a session
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		identity, ok := validator.Validate(key)
+		if !ok {
+			logger.PrintAuthf("", req, logger.AuthFailure, "Invalid authentication via API key: not in API keys file")
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		logger.PrintAuthf(identity.User, req, logger.AuthSuccess, "Authenticated via API key")
+		req.Header.Del(header)
+		scope.Session = &sessionsapi.SessionState{
+			User:              identity.User,
+			Email:             identity.Email,
+			PreferredUsername: identity.PreferredUsername,
+			Groups:            identity.Groups,
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
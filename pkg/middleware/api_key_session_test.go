@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/apikey"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API Key Session Suite", func() {
+	Context("APIKeySessionLoader", func() {
+		const apiKeyHeader = "X-API-Key"
+
+		type apiKeySessionLoaderTableInput struct {
+			apiKey             string
+			existingSession    *sessionsapi.SessionState
+			expectedSession    *sessionsapi.SessionState
+			expectedHeaderSent bool
+		}
+
+		DescribeTable("with an API key header",
+			func(in apiKeySessionLoaderTableInput) {
+				scope := &middlewareapi.RequestScope{
+					Session: in.existingSession,
+				}
+
+				// Set up the request with the API key header and a request scope
+				req := httptest.NewRequest("", "/", nil)
+				if in.apiKey != "" {
+					req.Header.Set(apiKeyHeader, in.apiKey)
+				}
+				req = middlewareapi.AddRequestScope(req, scope)
+
+				rw := httptest.NewRecorder()
+
+				validator := fakeAPIKeyValidator{
+					"ci-0123456789abcdef":     {User: "ci-bot", Email: "ci-bot@example.com", Groups: []string{"deployers"}},
+					"backup-fedcba9876543210": {User: "backup", PreferredUsername: "backup-job"},
+				}
+
+				// Create the handler with a next handler that will capture the session
+				// from the scope and whether the key is sent on
+				var gotSession *sessionsapi.SessionState
+				var gotHeader bool
+				handler := NewAPIKeySessionLoader(validator, apiKeyHeader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotSession = middlewareapi.GetRequestScope(r).Session
+					gotHeader = r.Header.Get(apiKeyHeader) != ""
+				}))
+				handler.ServeHTTP(rw, req)
+
+				Expect(gotSession).To(Equal(in.expectedSession))
+				Expect(gotHeader).To(Equal(in.expectedHeaderSent))
+			},
+			Entry("without a key", apiKeySessionLoaderTableInput{
+				apiKey:          "",
+				existingSession: nil,
+				expectedSession: nil,
+			}),
+			Entry("with a valid key", apiKeySessionLoaderTableInput{
+				apiKey:          "ci-0123456789abcdef",
+				existingSession: nil,
+				expectedSession: &sessionsapi.SessionState{
+					User:   "ci-bot",
+					Email:  "ci-bot@example.com",
+					Groups: []string{"deployers"},
+				},
+				expectedHeaderSent: false,
+			}),
+			Entry("with another valid key", apiKeySessionLoaderTableInput{
+				apiKey:          "backup-fedcba9876543210",
+				existingSession: nil,
+				expectedSession: &sessionsapi.SessionState{
+					User:              "backup",
+					PreferredUsername: "backup-job",
+				},
+				expectedHeaderSent: false,
+			}),
+			Entry("with an invalid key", apiKeySessionLoaderTableInput{
+				apiKey:             "ci-0123456789abcdeX",
+				existingSession:    nil,
+				expectedSession:    nil,
+				expectedHeaderSent: true,
+			}),
+			Entry("with a valid key (with existing session)", apiKeySessionLoaderTableInput{
+				apiKey:             "ci-0123456789abcdef",
+				existingSession:    &sessionsapi.SessionState{User: "user"},
+				expectedSession:    &sessionsapi.SessionState{User: "user"},
+				expectedHeaderSent: true,
+			}),
+		)
+	})
+})
+
+type fakeAPIKeyValidator map[string]apikey.Identity
+
+func (f fakeAPIKeyValidator) Validate(key string) (*apikey.Identity, bool) {
+	identity, ok := f[key]
+	if !ok {
+		return nil, false
+	}
+	return &identity, true
+}
@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// headerWebhookSweepInterval is how often expired responses are removed from
+// the cache so that it does not grow with every user that has been seen
+const headerWebhookSweepInterval = time.Minute
+
+// NewHeaderWebhook returns a middleware that calls the webhook with the
+// authenticated user and sets the headers from its JSON response on the
+// request before it is proxied upstream.
+// Responses are cached per user for the cacheTTL.
+// When the webhook fails, requests are rejected with an error page written by
+// the writer unless failOpen is set, in which case they continue without the
+// additional headers.
+func NewHeaderWebhook(webhookURL string, timeout, cacheTTL time.Duration, failOpen bool, writer pagewriter.Writer) alice.Constructor {
+	webhook := &headerWebhook{
+		url:      webhookURL,
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+		failOpen: failOpen,
+		writer:   writer,
+		cache:    make(map[string]cachedHeaders),
+	}
+	return webhook.handler
+}
+
+// headerWebhook holds the cached webhook responses for each user
+type headerWebhook struct {
+	url      string
+	timeout  time.Duration
+	cacheTTL time.Duration
+	failOpen bool
+	writer   pagewriter.Writer
+	clock    clock.Clock
+
+	mu        sync.Mutex
+	cache     map[string]cachedHeaders
+	lastSweep time.Time
+}
+
+// cachedHeaders are the headers returned by the webhook for a user
+type cachedHeaders struct {
+	header  http.Header
+	expires time.Time
+}
+
+// headerWebhookRequest is the body sent to the webhook
+type headerWebhookRequest struct {
+	User              string                 `json:"user"`
+	Email             string                 `json:"email"`
+	Groups            []string               `json:"groups"`
+	PreferredUsername string                 `json:"preferred_username"`
+	Claims            map[string]interface{} `json:"claims"`
+}
+
+func (h *headerWebhook) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middlewareapi.GetRequestScope(req)
+		if scope == nil || scope.Session == nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		header, err := h.getHeaders(req.Context(), scope.Session)
+		if err != nil {
+			logger.Errorf("Error calling header webhook for user %q: %v", scope.Session.Email, err)
+			if !h.failOpen {
+				h.writer.WriteErrorPage(rw, pagewriter.ErrorPageOpts{
+					Status:    http.StatusInternalServerError,
+					RequestID: scope.RequestID,
+					AppError:  fmt.Sprintf("Error calling the header webhook: %v", err),
+					Request:   req,
+				})
+				return
+			}
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		// The values are copied so that requests cannot modify the cache
+		for name, values := range header {
+			req.Header[name] = append([]string(nil), values...)
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// getHeaders returns the cached headers for the user or calls the webhook
+func (h *headerWebhook) getHeaders(ctx context.Context, session *sessionsapi.SessionState) (http.Header, error) {
+	key := session.User + "\x00" + session.Email
+	if header, ok := h.getCached(key); ok {
+		return header, nil
+	}
+
+	header, err := h.callWebhook(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	h.setCached(key, header)
+	return header, nil
+}
+
+func (h *headerWebhook) getCached(key string) (http.Header, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cached, ok := h.cache[key]
+	if !ok || !h.clock.Now().Before(cached.expires) {
+		return nil, false
+	}
+	return cached.header, true
+}
+
+func (h *headerWebhook) setCached(key string, header http.Header) {
+	if h.cacheTTL <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+	if now.Sub(h.lastSweep) >= headerWebhookSweepInterval {
+		for k, cached := range h.cache {
+			if !now.Before(cached.expires) {
+				delete(h.cache, k)
+			}
+		}
+		h.lastSweep = now
+	}
+
+	h.cache[key] = cachedHeaders{
+		header:  header,
+		expires: now.Add(h.cacheTTL),
+	}
+}
+
+// callWebhook posts the user and claims to the webhook and parses the
+// headers from the response
+func (h *headerWebhook) callWebhook(ctx context.Context, session *sessionsapi.SessionState) (http.Header, error) {
+	body, err := json.Marshal(headerWebhookRequest{
+		User:              session.User,
+		Email:             session.Email,
+		Groups:            session.Groups,
+		PreferredUsername: session.PreferredUsername,
+		Claims:            session.IDTokenClaims(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %v", err)
+	}
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	var response map[string]interface{}
+	err = requests.New(h.url).
+		WithContext(ctx).
+		WithMethod("POST").
+		WithBody(bytes.NewReader(body)).
+		SetHeader("Content-Type", "application/json").
+		Do().
+		UnmarshalInto(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseWebhookHeaders(response)
+}
+
+// parseWebhookHeaders converts the webhook response into headers.
+// Each value must be a string or a list of strings.
+func parseWebhookHeaders(response map[string]interface{}) (http.Header, error) {
+	header := make(http.Header, len(response))
+	for name, value := range response {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+
+		var values []string
+		switch v := value.(type) {
+		case string:
+			values = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("header %q has a non string value: %v", name, item)
+				}
+				values = append(values, s)
+			}
+		default:
+			return nil, fmt.Errorf("header %q must be a string or a list of strings, got %v", name, value)
+		}
+
+		for _, s := range values {
+			if strings.ContainsAny(s, "\r\n\x00") {
+				return nil, fmt.Errorf("header %q has an invalid value", name)
+			}
+		}
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+	return header, nil
+}
+
+// validHeaderName checks the name only contains the token characters allowed
+// by RFC 7230
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
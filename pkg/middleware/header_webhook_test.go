@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Header Webhook Suite", func() {
+	var server *httptest.Server
+	var calls int32
+	var lastRequest headerWebhookRequest
+	var response string
+	var status int
+	var delay time.Duration
+
+	var webhook *headerWebhook
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&calls, 0)
+		lastRequest = headerWebhookRequest{}
+		response = `{"X-Entitlements": "read,write", "x-tenant": ["a", "b"]}`
+		status = http.StatusOK
+		delay = 0
+
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			Expect(req.Method).To(Equal("POST"))
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(req.Body).Decode(&lastRequest)).To(Succeed())
+
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-req.Context().Done():
+				}
+			}
+			rw.WriteHeader(status)
+			rw.Write([]byte(response))
+		}))
+
+		webhook = &headerWebhook{
+			url:      server.URL,
+			timeout:  time.Second,
+			cacheTTL: time.Minute,
+			writer: &pagewriter.WriterFuncs{
+				ErrorPageFunc: func(rw http.ResponseWriter, opts pagewriter.ErrorPageOpts) {
+					rw.WriteHeader(opts.Status)
+					rw.Write([]byte("error page: " + opts.AppError))
+				},
+			},
+			cache: make(map[string]cachedHeaders),
+		}
+		webhook.clock.Set(time.Unix(1234567890, 0))
+	})
+
+	AfterEach(func() {
+		webhook.clock.Reset()
+		server.Close()
+	})
+
+	// requestWith serves the request, calling the upstream func with the
+	// request that reaches the upstream
+	requestWith := func(session *sessionsapi.SessionState, initialHeaders http.Header, upstream func(*http.Request)) (*httptest.ResponseRecorder, http.Header) {
+		req := httptest.NewRequest("", "/", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+		for name, values := range initialHeaders {
+			req.Header[name] = values
+		}
+
+		var gotHeaders http.Header
+		handler := webhook.handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			gotHeaders = r.Header.Clone()
+			if upstream != nil {
+				upstream(r)
+			}
+			rw.WriteHeader(http.StatusOK)
+		}))
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw, gotHeaders
+	}
+	request := func(session *sessionsapi.SessionState, initialHeaders http.Header) (*httptest.ResponseRecorder, http.Header) {
+		return requestWith(session, initialHeaders, nil)
+	}
+
+	alice := &sessionsapi.SessionState{
+		User:              "alice",
+		Email:             "alice@example.com",
+		Groups:            []string{"admins"},
+		PreferredUsername: "Alice",
+	}
+	bob := &sessionsapi.SessionState{
+		User:  "bob",
+		Email: "bob@example.com",
+	}
+
+	It("adds the headers from the webhook to the request", func() {
+		rw, headers := request(alice, http.Header{
+			"X-Entitlements": []string{"admin"},
+			"X-Other":        []string{"value"},
+		})
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(headers).To(Equal(http.Header{
+			"X-Entitlements": []string{"read,write"},
+			"X-Tenant":       []string{"a", "b"},
+			"X-Other":        []string{"value"},
+		}))
+
+		Expect(lastRequest).To(Equal(headerWebhookRequest{
+			User:              "alice",
+			Email:             "alice@example.com",
+			Groups:            []string{"admins"},
+			PreferredUsername: "Alice",
+			Claims:            map[string]interface{}{},
+		}))
+	})
+
+	It("caches the response for each user", func() {
+		request(alice, nil)
+		request(alice, nil)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+
+		request(bob, nil)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+		Expect(lastRequest.User).To(Equal("bob"))
+
+		Expect(webhook.clock.Add(time.Minute)).To(Succeed())
+		_, headers := request(alice, nil)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(3)))
+		Expect(headers.Get("X-Entitlements")).To(Equal("read,write"))
+	})
+
+	It("does not share the cached header values between requests", func() {
+		requestWith(alice, nil, func(r *http.Request) {
+			r.Header["X-Tenant"][0] = "modified"
+		})
+
+		_, headers := request(alice, nil)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		Expect(headers["X-Tenant"]).To(Equal([]string{"a", "b"}))
+	})
+
+	It("removes expired responses from the cache", func() {
+		request(alice, nil)
+		Expect(webhook.cache).To(HaveLen(1))
+
+		Expect(webhook.clock.Add(2 * headerWebhookSweepInterval)).To(Succeed())
+		request(bob, nil)
+		Expect(webhook.cache).To(HaveLen(1))
+		Expect(webhook.cache).To(HaveKey("bob\x00bob@example.com"))
+	})
+
+	It("does not cache responses when the cache TTL is 0", func() {
+		webhook.cacheTTL = 0
+		request(alice, nil)
+		request(alice, nil)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	It("does not call the webhook without a session", func() {
+		rw, _ := request(nil, nil)
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(0)))
+	})
+
+	type failureTableInput struct {
+		response string
+		status   int
+		delay    time.Duration
+	}
+
+	failures := []TableEntry{
+		Entry("with an error response", failureTableInput{
+			response: `{"error": "unavailable"}`,
+			status:   http.StatusServiceUnavailable,
+		}),
+		Entry("with a response that is not JSON", failureTableInput{
+			response: `unavailable`,
+			status:   http.StatusOK,
+		}),
+		Entry("with a response that is not a JSON object", failureTableInput{
+			response: `["X-Entitlements"]`,
+			status:   http.StatusOK,
+		}),
+		Entry("with a non string header value", failureTableInput{
+			response: `{"X-Entitlements": 1}`,
+			status:   http.StatusOK,
+		}),
+		Entry("with a non string header list value", failureTableInput{
+			response: `{"X-Entitlements": ["read", 1]}`,
+			status:   http.StatusOK,
+		}),
+		Entry("with an invalid header name", failureTableInput{
+			response: `{"X Entitlements": "read"}`,
+			status:   http.StatusOK,
+		}),
+		Entry("with a line break in a header value", failureTableInput{
+			response: `{"X-Entitlements": "read\r\nX-Admin: true"}`,
+			status:   http.StatusOK,
+		}),
+		Entry("with a slow webhook", failureTableInput{
+			response: `{"X-Entitlements": "read"}`,
+			status:   http.StatusOK,
+			delay:    time.Second,
+		}),
+	}
+
+	DescribeTable("when the webhook fails closed",
+		func(in failureTableInput) {
+			response, status, delay = in.response, in.status, in.delay
+			webhook.timeout = 100 * time.Millisecond
+
+			rw, headers := request(alice, nil)
+			Expect(rw.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rw.Body.String()).To(HavePrefix("error page: Error calling the header webhook: "))
+			Expect(headers).To(BeNil())
+
+			// Failures are not cached
+			request(alice, nil)
+			Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+		},
+		failures...,
+	)
+
+	DescribeTable("when the webhook fails open",
+		func(in failureTableInput) {
+			response, status, delay = in.response, in.status, in.delay
+			webhook.timeout = 100 * time.Millisecond
+			webhook.failOpen = true
+
+			rw, headers := request(alice, http.Header{"X-Other": []string{"value"}})
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(headers).To(Equal(http.Header{"X-Other": []string{"value"}}))
+		},
+		failures...,
+	)
+})
@@ -8,6 +8,7 @@ import (
 	"github.com/justinas/alice"
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/header"
 )
 
@@ -24,6 +25,34 @@ func NewRequestHeaderInjector(headers []options.Header) (alice.Constructor, erro
 	return headerInjector, nil
 }
 
+// NewStripRequestHeaders returns a middleware that removes any request
+// headers starting with one of the given prefixes, so that clients cannot
+// pass them to the upstream servers.
+func NewStripRequestHeaders(prefixes []string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return stripHeaders(nil, prefixes, next)
+	}
+}
+
+// NewUpstreamHeaders returns a middleware that removes the strip headers
+// from requests and then sets the static headers, replacing any headers of
+// the same name. It runs after the identity headers are injected so that the
+// static headers take precedence.
+func NewUpstreamHeaders(set http.Header, strip []string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		setHeaders := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			for name, values := range set {
+				req.Header[name] = append([]string(nil), values...)
+			}
+			next.ServeHTTP(rw, req)
+		})
+		if len(strip) == 0 {
+			return setHeaders
+		}
+		return stripHeaders(strip, nil, setHeaders)
+	}
+}
+
 func newStripHeaders(headers []options.Header) alice.Constructor {
 	headersToStrip := []string{}
 	for _, header := range headers {
@@ -37,7 +66,7 @@ func newStripHeaders(headers []options.Header) alice.Constructor {
 	}
 
 	return func(next http.Handler) http.Handler {
-		return stripHeaders(headersToStrip, next)
+		return stripHeaders(headersToStrip, nil, next)
 	}
 }
 
@@ -50,15 +79,47 @@ func flattenHeaders(headers http.Header) {
 	}
 }
 
-func stripHeaders(headers []string, next http.Handler) http.Handler {
+// stripHeaders removes the request headers matching any of the names or
+// starting with any of the prefixes before calling the next handler.
+// Names are compared with underscores treated as dashes, as many upstream
+// servers (e.g. CGI and WSGI) do not tell X_Forwarded_Email and
+// X-Forwarded-Email apart.
+func stripHeaders(names, prefixes []string, next http.Handler) http.Handler {
+	namesToStrip := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		namesToStrip[normalizeHeaderName(name)] = struct{}{}
+	}
+	prefixesToStrip := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefixesToStrip = append(prefixesToStrip, normalizeHeaderName(prefix))
+	}
+
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		for _, header := range headers {
-			req.Header.Del(header)
+		for name := range req.Header {
+			if shouldStripHeader(normalizeHeaderName(name), namesToStrip, prefixesToStrip) {
+				delete(req.Header, name)
+			}
 		}
 		next.ServeHTTP(rw, req)
 	})
 }
 
+func shouldStripHeader(name string, names map[string]struct{}, prefixes []string) bool {
+	if _, ok := names[name]; ok {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHeaderName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
 func newRequestHeaderInjector(headers []options.Header) (alice.Constructor, error) {
 	injector, err := header.NewInjector(headers)
 	if err != nil {
@@ -82,6 +143,36 @@ func injectRequestHeaders(injector header.Injector, next http.Handler) http.Hand
 	})
 }
 
+// NewRequestHeaderSigner returns a middleware that signs the injected request
+// headers with the key, so that upstreams can verify that they were set by
+// the proxy. See header.IdentitySignatureHeader for the signature scheme.
+func NewRequestHeaderSigner(headers []options.Header, key []byte) alice.Constructor {
+	names := make([]string, 0, len(headers))
+	for _, h := range headers {
+		names = append(names, h.Name)
+	}
+
+	signer := &requestHeaderSigner{
+		names: names,
+		key:   key,
+	}
+	return signer.handler
+}
+
+// requestHeaderSigner signs the named request headers
+type requestHeaderSigner struct {
+	names []string
+	key   []byte
+	clock clock.Clock
+}
+
+func (s *requestHeaderSigner) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		header.SignIdentityHeaders(req, s.names, s.key, s.clock.Now())
+		next.ServeHTTP(rw, req)
+	})
+}
+
 func NewResponseHeaderInjector(headers []options.Header) (alice.Constructor, error) {
 	headerInjector, err := newResponseHeaderInjector(headers)
 	if err != nil {
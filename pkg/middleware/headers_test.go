@@ -1,13 +1,17 @@
 package middleware
 
 import (
+	"context"
 	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"time"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/header"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -180,6 +184,56 @@ var _ = Describe("Headers Suite", func() {
 			},
 			expectedErr: "",
 		}),
+		Entry("with a spoofed header from an unauthenticated user", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "X-Forwarded-Email",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "email",
+							},
+						},
+					},
+				},
+			},
+			initialHeaders: http.Header{
+				"X-Forwarded-Email": []string{"admin@example.com"},
+				"X_forwarded_email": []string{"admin@example.com"},
+				"Foo":               []string{"bar"},
+			},
+			session: nil,
+			expectedHeaders: http.Header{
+				"Foo": []string{"bar"},
+			},
+			expectedErr: "",
+		}),
+		Entry("with a spoofed ID token claims header", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "X-Forwarded-Claims",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: options.ClaimIDTokenClaims,
+							},
+						},
+					},
+				},
+			},
+			initialHeaders: http.Header{
+				"X-Forwarded-Claims": []string{base64.StdEncoding.EncodeToString([]byte(`{"sub":"admin","groups":["admins"]}`))},
+				"Foo":                []string{"bar"},
+			},
+			session: &sessionsapi.SessionState{
+				IDToken: "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"jane","groups":["devs"]}`)) + ".signature",
+			},
+			expectedHeaders: http.Header{
+				"X-Forwarded-Claims": []string{base64.StdEncoding.EncodeToString([]byte(`{"groups":["devs"],"sub":"jane"}`))},
+				"Foo":                []string{"bar"},
+			},
+			expectedErr: "",
+		}),
 		Entry("with an invalid basicAuthPassword claim valued header", headersTableInput{
 			headers: []options.Header{
 				{
@@ -208,6 +262,229 @@ var _ = Describe("Headers Suite", func() {
 		}),
 	)
 
+	type stripHeadersTableInput struct {
+		prefixes        []string
+		initialHeaders  http.Header
+		expectedHeaders http.Header
+	}
+
+	DescribeTable("the request header stripper",
+		func(in stripHeadersTableInput) {
+			req := httptest.NewRequest("", "/", nil)
+			req.Header = in.initialHeaders.Clone()
+
+			var gotHeaders http.Header
+			handler := NewStripRequestHeaders(in.prefixes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotHeaders = r.Header.Clone()
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(gotHeaders).To(Equal(in.expectedHeaders))
+		},
+		Entry("with no prefixes", stripHeadersTableInput{
+			prefixes: nil,
+			initialHeaders: http.Header{
+				"X-Auth-Request-User": []string{"admin"},
+			},
+			expectedHeaders: http.Header{
+				"X-Auth-Request-User": []string{"admin"},
+			},
+		}),
+		Entry("with matching headers", stripHeadersTableInput{
+			prefixes: []string{"X-Auth-Request-", "x-forwarded-"},
+			initialHeaders: http.Header{
+				"X-Auth-Request-User":  []string{"admin"},
+				"X-Auth-Request-Email": []string{"admin@example.com"},
+				"X_auth_request_user":  []string{"admin"},
+				"X-Forwarded-Groups":   []string{"admins"},
+				"X-Auth-Token":         []string{"token"},
+				"Foo":                  []string{"bar"},
+			},
+			expectedHeaders: http.Header{
+				"X-Auth-Token": []string{"token"},
+				"Foo":          []string{"bar"},
+			},
+		}),
+	)
+
+	type upstreamHeadersTableInput struct {
+		set             http.Header
+		strip           []string
+		initialHeaders  http.Header
+		expectedHeaders http.Header
+	}
+
+	DescribeTable("the upstream headers",
+		func(in upstreamHeadersTableInput) {
+			req := httptest.NewRequest("", "/", nil)
+			req.Header = in.initialHeaders.Clone()
+
+			var gotHeaders http.Header
+			handler := NewUpstreamHeaders(in.set, in.strip)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotHeaders = r.Header.Clone()
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(gotHeaders).To(Equal(in.expectedHeaders))
+		},
+		Entry("sets static headers", upstreamHeadersTableInput{
+			set: http.Header{
+				"X-Env":    []string{"prod"},
+				"X-Source": []string{"oauth2-proxy"},
+			},
+			initialHeaders: http.Header{
+				"Foo": []string{"bar"},
+			},
+			expectedHeaders: http.Header{
+				"Foo":      []string{"bar"},
+				"X-Env":    []string{"prod"},
+				"X-Source": []string{"oauth2-proxy"},
+			},
+		}),
+		Entry("overrides existing headers", upstreamHeadersTableInput{
+			set: http.Header{
+				"X-Forwarded-User": []string{"service"},
+				"X-Env":            []string{"prod", "eu"},
+			},
+			initialHeaders: http.Header{
+				"X-Forwarded-User": []string{"jdoe"},
+				"X-Env":            []string{"dev"},
+			},
+			expectedHeaders: http.Header{
+				"X-Forwarded-User": []string{"service"},
+				"X-Env":            []string{"prod", "eu"},
+			},
+		}),
+		Entry("strips headers", upstreamHeadersTableInput{
+			strip: []string{"Cookie", "x-internal-token"},
+			initialHeaders: http.Header{
+				"Cookie":           []string{"_oauth2_proxy=session"},
+				"X-Internal-Token": []string{"secret"},
+				"X_internal_token": []string{"secret"},
+				"Foo":              []string{"bar"},
+			},
+			expectedHeaders: http.Header{
+				"Foo": []string{"bar"},
+			},
+		}),
+		Entry("sets static headers after stripping", upstreamHeadersTableInput{
+			set: http.Header{
+				"Cookie": []string{"upstream=1"},
+			},
+			strip: []string{"Cookie"},
+			initialHeaders: http.Header{
+				"Cookie": []string{"_oauth2_proxy=session"},
+			},
+			expectedHeaders: http.Header{
+				"Cookie": []string{"upstream=1"},
+			},
+		}),
+	)
+
+	Context("the request header signer", func() {
+		It("signs the injected headers so that upstreams can verify them", func() {
+			key := []byte("0123456789abcdef0123456789abcdef")
+			headers := []options.Header{
+				{
+					Name: "X-Forwarded-User",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "user",
+							},
+						},
+					},
+				},
+			}
+
+			injector, err := NewRequestHeaderInjector(headers)
+			Expect(err).ToNot(HaveOccurred())
+
+			signer := &requestHeaderSigner{
+				names: []string{"X-Forwarded-User"},
+				key:   key,
+			}
+			signer.clock.Set(time.Unix(1700000000, 0))
+			defer signer.clock.Reset()
+
+			req := httptest.NewRequest("", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+				Session: &sessionsapi.SessionState{User: "jdoe"},
+			})
+			req.Header.Set("X-Forwarded-User", "admin")
+			req.Header.Set(header.IdentitySignatureHeader, "t=1700000000,h=,s=spoofed")
+
+			var gotReq *http.Request
+			handler := injector(signer.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotReq = r.Clone(r.Context())
+			})))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(gotReq.Header.Get("X-Forwarded-User")).To(Equal("jdoe"))
+			names, err := header.VerifyIdentityHeaders(gotReq, key, time.Minute, time.Unix(1700000000, 0))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names).To(Equal([]string{"x-forwarded-user"}))
+		})
+	})
+
+	Context("the access token expiry header", func() {
+		It("carries the expiry of the refreshed access token", func() {
+			headers := []options.Header{
+				{
+					Name: "X-Auth-Request-Access-Token-Expiry",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim:      "expires_on",
+								TimeFormat: options.ClaimTimeFormatUnix,
+							},
+						},
+					},
+				},
+			}
+			injector, err := NewRequestHeaderInjector(headers)
+			Expect(err).ToNot(HaveOccurred())
+
+			createdAt := time.Now().Add(-time.Hour)
+			expiresOn := time.Now().Add(time.Minute).Truncate(time.Second)
+			refreshedExpiresOn := expiresOn.Add(time.Hour)
+			store := &fakeSessionStore{
+				LoadFunc: func(*http.Request) (*sessionsapi.SessionState, error) {
+					return &sessionsapi.SessionState{
+						AccessToken:  "stale",
+						RefreshToken: "refresh",
+						CreatedAt:    &createdAt,
+						ExpiresOn:    &expiresOn,
+					}, nil
+				},
+			}
+			loader := NewStoredSessionLoader(&StoredSessionLoaderOptions{
+				SessionStore:  store,
+				RefreshPeriod: time.Minute,
+				RefreshSession: func(_ context.Context, s *sessionsapi.SessionState) (bool, error) {
+					s.AccessToken = "fresh"
+					s.ExpiresOn = &refreshedExpiresOn
+					return true, nil
+				},
+				ValidateSession: func(context.Context, *sessionsapi.SessionState) bool {
+					return true
+				},
+			})
+
+			req := httptest.NewRequest("", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			req.Header.Set("X-Auth-Request-Access-Token-Expiry", strconv.FormatInt(expiresOn.Unix(), 10))
+
+			var gotHeaders http.Header
+			handler := loader(injector(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotHeaders = r.Header.Clone()
+			})))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(gotHeaders.Values("X-Auth-Request-Access-Token-Expiry")).To(Equal([]string{strconv.FormatInt(refreshedExpiresOn.Unix(), 10)}))
+		})
+	})
+
 	DescribeTable("the response header injector",
 		func(in headersTableInput) {
 			scope := &middlewareapi.RequestScope{
@@ -1,10 +1,12 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 
 	"github.com/justinas/alice"
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
@@ -15,25 +17,32 @@ import (
 
 const jwtRegexFormat = `^ey[IJ][a-zA-Z0-9_-]*\.ey[IJ][a-zA-Z0-9_-]*\.[a-zA-Z0-9_-]+$`
 
-func NewJwtSessionLoader(sessionLoaders []middlewareapi.TokenToSessionFunc) alice.Constructor {
+// NewJwtSessionLoader creates a middleware that loads sessions from JWTs in
+// the Authorization header or in any of the given bearerHeaders.
+func NewJwtSessionLoader(sessionLoaders []middlewareapi.TokenToSessionFunc, bearerHeaders []string) alice.Constructor {
 	js := &jwtSessionLoader{
 		jwtRegex:       regexp.MustCompile(jwtRegexFormat),
 		sessionLoaders: sessionLoaders,
+		bearerHeaders:  bearerHeaders,
 	}
 	return js.loadSession
 }
 
 // jwtSessionLoader is responsible for loading sessions from JWTs in
-// Authorization headers.
+// Authorization headers or custom bearer headers.
 type jwtSessionLoader struct {
 	jwtRegex       *regexp.Regexp
 	sessionLoaders []middlewareapi.TokenToSessionFunc
+	bearerHeaders  []string
 }
 
 // loadSession attempts to load a session from a JWT stored in an Authorization
 // header within the request.
 // If no authorization header is found, or the header is invalid, no session
 // will be loaded and the request will be passed to the next handler.
+// When there is no session from the Authorization header, the configured
+// bearer headers are checked. A token in one of those headers that cannot be
+// verified is rejected with a 401 as it can only be a bearer token.
 // If a session was loaded by a previous handler, it will not be replaced.
 func (j *jwtSessionLoader) loadSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -51,6 +60,16 @@ func (j *jwtSessionLoader) loadSession(next http.Handler) http.Handler {
 			logger.Errorf("Error retrieving session from token in Authorization header: %v", err)
 		}
 
+		if session == nil {
+			var header string
+			session, header, err = j.getBearerHeaderSession(req)
+			if err != nil {
+				logger.Errorf("Error retrieving session from token in %s header: %v", header, err)
+				http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+
 		// Add the session to the scope if it was found
 		scope.Session = session
 		next.ServeHTTP(rw, req)
@@ -71,10 +90,38 @@ func (j *jwtSessionLoader) getJwtSession(req *http.Request) (*sessionsapi.Sessio
 		return nil, err
 	}
 
+	return j.createSession(req.Context(), token)
+}
+
+// getBearerHeaderSession loads a session based on a JWT in the first of the
+// configured bearer headers that is present in the request.
+// The token may optionally be prefixed with `Bearer `.
+// The name of the header is returned so that errors can be attributed to it.
+func (j *jwtSessionLoader) getBearerHeaderSession(req *http.Request) (*sessionsapi.SessionState, string, error) {
+	for _, header := range j.bearerHeaders {
+		value := req.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		token := strings.TrimPrefix(value, "Bearer ")
+		if !j.jwtRegex.MatchString(token) {
+			return nil, header, fmt.Errorf("no valid bearer token found in %s header", header)
+		}
+
+		session, err := j.createSession(req.Context(), token)
+		return session, header, err
+	}
+	return nil, "", nil
+}
+
+// createSession verifies the token with each of the session loaders and
+// returns the session from the first that succeeds.
+func (j *jwtSessionLoader) createSession(ctx context.Context, token string) (*sessionsapi.SessionState, error) {
 	// This leading error message only occurs if all session loaders fail
 	errs := []error{errors.New("unable to verify bearer token")}
 	for _, loader := range j.sessionLoaders {
-		session, err := loader(req.Context(), token)
+		session, err := loader(ctx, token)
 		if err != nil {
 			errs = append(errs, err)
 			continue
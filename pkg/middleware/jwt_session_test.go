@@ -114,7 +114,7 @@ Nnc3a3lGVWFCNUMxQnNJcnJMTWxka1dFaHluYmI4Ongtb2F1dGgtYmFzaWM=`
 				// Create the handler with a next handler that will capture the session
 				// from the scope
 				var gotSession *sessionsapi.SessionState
-				handler := NewJwtSessionLoader(sessionLoaders)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler := NewJwtSessionLoader(sessionLoaders, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					gotSession = middlewareapi.GetRequestScope(r).Session
 				}))
 				handler.ServeHTTP(rw, req)
@@ -163,6 +163,72 @@ Nnc3a3lGVWFCNUMxQnNJcnJMTWxka1dFaHluYmI4Ongtb2F1dGgtYmFzaWM=`
 			}),
 		)
 
+		type bearerHeaderTableInput struct {
+			authorizationHeader string
+			bearerHeader        string
+			expectedSession     *sessionsapi.SessionState
+			expectedCode        int
+		}
+
+		DescribeTable("with a bearer header",
+			func(in bearerHeaderTableInput) {
+				scope := &middlewareapi.RequestScope{}
+
+				req := httptest.NewRequest("", "/", nil)
+				if in.authorizationHeader != "" {
+					req.Header.Set("Authorization", in.authorizationHeader)
+				}
+				if in.bearerHeader != "" {
+					req.Header.Set("X-Auth-Token", in.bearerHeader)
+				}
+				req = middlewareapi.AddRequestScope(req, scope)
+
+				rw := httptest.NewRecorder()
+
+				sessionLoaders := []middlewareapi.TokenToSessionFunc{
+					middlewareapi.CreateTokenToSessionFunc(verifier),
+				}
+
+				var gotSession *sessionsapi.SessionState
+				handler := NewJwtSessionLoader(sessionLoaders, []string{"X-Auth-Token"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotSession = middlewareapi.GetRequestScope(r).Session
+				}))
+				handler.ServeHTTP(rw, req)
+
+				Expect(gotSession).To(Equal(in.expectedSession))
+				Expect(rw.Code).To(Equal(in.expectedCode))
+			},
+			Entry("<no value>", bearerHeaderTableInput{
+				expectedSession: nil,
+				expectedCode:    http.StatusOK,
+			}),
+			Entry("<verifiedToken>", bearerHeaderTableInput{
+				bearerHeader:    verifiedToken,
+				expectedSession: verifiedSession,
+				expectedCode:    http.StatusOK,
+			}),
+			Entry("Bearer <verifiedToken>", bearerHeaderTableInput{
+				bearerHeader:    fmt.Sprintf("Bearer %s", verifiedToken),
+				expectedSession: verifiedSession,
+				expectedCode:    http.StatusOK,
+			}),
+			Entry("<nonVerifiedToken>", bearerHeaderTableInput{
+				bearerHeader:    nonVerifiedToken,
+				expectedSession: nil,
+				expectedCode:    http.StatusUnauthorized,
+			}),
+			Entry("abcdef", bearerHeaderTableInput{
+				bearerHeader:    "abcdef",
+				expectedSession: nil,
+				expectedCode:    http.StatusUnauthorized,
+			}),
+			Entry("<nonVerifiedToken> with a verified Authorization header", bearerHeaderTableInput{
+				authorizationHeader: fmt.Sprintf("Bearer %s", verifiedToken),
+				bearerHeader:        nonVerifiedToken,
+				expectedSession:     verifiedSession,
+				expectedCode:        http.StatusOK,
+			}),
+		)
 	})
 
 	Context("getJWTSession", func() {
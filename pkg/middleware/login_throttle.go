@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// loginThrottleSweepInterval is how often the failures that have expired are
+// removed so that the throttle does not grow with every client it has seen
+const loginThrottleSweepInterval = time.Minute
+
+// NewLoginThrottle returns a middleware that delays the failed responses of
+// the OAuth callback for clients that failed to login before.
+// The first failure is not delayed, each further failure waits twice as long
+// as the one before, starting at backoff and up to maxBackoff.
+// Successful logins are never delayed and forget the previous failures, as do
+// clients that did not fail for twice the maxBackoff.
+// Failures are counted for the user of the session the callback created, for
+// callbacks that fail before a session is created the client IP is used,
+// determined with the realClientIPParser when it is set.
+func NewLoginThrottle(backoff, maxBackoff time.Duration, realClientIPParser ipapi.RealClientIPParser) alice.Constructor {
+	throttle := &loginThrottle{
+		backoff:            backoff,
+		maxBackoff:         maxBackoff,
+		realClientIPParser: realClientIPParser,
+		failures:           make(map[string]*loginFailures),
+	}
+	return throttle.handler
+}
+
+// loginThrottle counts the recent login failures of each user or client IP
+type loginThrottle struct {
+	backoff            time.Duration
+	maxBackoff         time.Duration
+	realClientIPParser ipapi.RealClientIPParser
+	clock              clock.Clock
+
+	mu        sync.Mutex
+	failures  map[string]*loginFailures
+	lastSweep time.Time
+}
+
+// loginFailures is the number of consecutive failed logins and the time of
+// the last one
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func (t *loginThrottle) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(&throttledResponse{ResponseWriter: rw, throttle: t, req: req}, req)
+	})
+}
+
+// throttledResponse delays writing the status of a failed login
+type throttledResponse struct {
+	http.ResponseWriter
+	throttle    *loginThrottle
+	req         *http.Request
+	wroteHeader bool
+}
+
+// WriteHeader records the outcome of the login, waiting for the backoff
+// before failures are written
+func (r *throttledResponse) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.throttle.observe(r.req, status)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the response body, the status is OK unless it was written
+// before
+func (r *throttledResponse) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// observe counts the failed logins and waits for the backoff of repeated
+// failures, or until the client gives up on the request.
+func (t *loginThrottle) observe(req *http.Request, status int) {
+	key := t.key(req)
+	if status < http.StatusBadRequest {
+		t.succeed(key)
+		return
+	}
+
+	delay := t.fail(key)
+	if delay <= 0 {
+		return
+	}
+	logger.Errorf("Delaying the failed login of %s by %s after repeated failures", key, delay)
+	<-t.clock.AfterContext(req.Context(), delay)
+}
+
+// key identifies the user of the callback's session, or the client IP when
+// the callback failed before creating a session
+func (t *loginThrottle) key(req *http.Request) string {
+	if scope := middlewareapi.GetRequestScope(req); scope != nil && scope.Session != nil {
+		if scope.Session.Email != "" {
+			return "user " + scope.Session.Email
+		}
+		if scope.Session.User != "" {
+			return "user " + scope.Session.User
+		}
+	}
+	return "client " + ip.GetClientString(t.realClientIPParser, req, false)
+}
+
+// fail records a failed login and returns how long to delay it for
+func (t *loginThrottle) fail(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	t.sweep(now)
+
+	failures, ok := t.failures[key]
+	if !ok || t.expired(failures, now) {
+		failures = &loginFailures{}
+		t.failures[key] = failures
+	}
+	delay := t.delay(failures.count)
+	failures.count++
+	failures.last = now
+	return delay
+}
+
+// succeed forgets the failed logins
+func (t *loginThrottle) succeed(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+// delay returns the backoff after the given number of failures
+func (t *loginThrottle) delay(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	delay := t.backoff
+	for i := 1; i < failures && delay < t.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > t.maxBackoff {
+		return t.maxBackoff
+	}
+	return delay
+}
+
+// expired returns whether the client has not failed recently enough for the
+// failures to count
+func (t *loginThrottle) expired(failures *loginFailures, now time.Time) bool {
+	return now.Sub(failures.last) > 2*t.maxBackoff
+}
+
+// sweep removes the failures that have expired.
+// The lock must be held when calling sweep.
+func (t *loginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < loginThrottleSweepInterval {
+		return
+	}
+	t.lastSweep = now
+
+	for key, failures := range t.failures {
+		if t.expired(failures, now) {
+			delete(t.failures, key)
+		}
+	}
+}
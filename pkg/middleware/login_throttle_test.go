@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoginThrottle suite", func() {
+	var throttle *loginThrottle
+	var handler http.Handler
+
+	BeforeEach(func() {
+		throttle = &loginThrottle{
+			backoff:    time.Second,
+			maxBackoff: 5 * time.Second,
+			failures:   make(map[string]*loginFailures),
+		}
+		throttle.clock.Set(time.Unix(1234567890, 0))
+
+		// Responds with the status in the "status" query parameter, setting
+		// the session of the "email" query parameter as the callback would
+		handler = throttle.handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if email := req.URL.Query().Get("email"); email != "" {
+				middlewareapi.GetRequestScope(req).Session = &sessionsapi.SessionState{Email: email}
+			}
+			switch req.URL.Query().Get("status") {
+			case "found":
+				http.Redirect(rw, req, "/", http.StatusFound)
+			default:
+				http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		throttle.clock.Reset()
+	})
+
+	// callback serves a callback and returns the mock time it took
+	callback := func(remoteAddr, query string) (int, time.Duration) {
+		req := httptest.NewRequest("", "http://example.com/oauth2/callback?"+query, nil)
+		req.RemoteAddr = remoteAddr
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+		rw := httptest.NewRecorder()
+
+		start := throttle.clock.Now()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.ServeHTTP(rw, req)
+		}()
+
+		Eventually(func() bool {
+			select {
+			case <-done:
+				return true
+			default:
+				Expect(throttle.clock.Add(100 * time.Millisecond)).To(Succeed())
+				return false
+			}
+		}, 10*time.Second, time.Millisecond).Should(BeTrue())
+		return rw.Code, throttle.clock.Now().Sub(start)
+	}
+
+	It("does not delay the first failure", func() {
+		code, elapsed := callback("10.0.0.1:1234", "status=forbidden")
+		Expect(code).To(Equal(http.StatusForbidden))
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+	})
+
+	It("does not delay successful logins", func() {
+		for i := 0; i < 3; i++ {
+			code, elapsed := callback("10.0.0.1:1234", "status=found")
+			Expect(code).To(Equal(http.StatusFound))
+			Expect(elapsed).To(BeNumerically("<", time.Second))
+		}
+	})
+
+	It("doubles the delay of each repeated failure up to the max backoff", func() {
+		_, elapsed := callback("10.0.0.1:1234", "status=forbidden")
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+
+		for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+			code, elapsed := callback("10.0.0.1:1234", "status=forbidden")
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(elapsed).To(BeNumerically(">=", expected))
+			Expect(elapsed).To(BeNumerically("<", expected+time.Second))
+		}
+	})
+
+	It("forgets the failures after a successful login", func() {
+		callback("10.0.0.1:1234", "status=forbidden")
+		_, elapsed := callback("10.0.0.1:1234", "status=forbidden")
+		Expect(elapsed).To(BeNumerically(">=", time.Second))
+
+		callback("10.0.0.1:1234", "status=found")
+		_, elapsed = callback("10.0.0.1:1234", "status=forbidden")
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+	})
+
+	It("forgets the failures after twice the max backoff", func() {
+		callback("10.0.0.1:1234", "status=forbidden")
+		callback("10.0.0.1:1234", "status=forbidden")
+
+		Expect(throttle.clock.Add(10*time.Second + time.Millisecond)).To(Succeed())
+		_, elapsed := callback("10.0.0.1:1234", "status=forbidden")
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+	})
+
+	It("counts the failures of each client IP separately", func() {
+		callback("10.0.0.1:1234", "status=forbidden")
+		_, elapsed := callback("10.0.0.2:1234", "status=forbidden")
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+	})
+
+	It("counts the failures of the session's user across client IPs", func() {
+		callback("10.0.0.1:1234", "status=forbidden&email=denied@example.com")
+		_, elapsed := callback("10.0.0.2:1234", "status=forbidden&email=denied@example.com")
+		Expect(elapsed).To(BeNumerically(">=", time.Second))
+
+		_, elapsed = callback("10.0.0.2:1234", "status=forbidden&email=other@example.com")
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+	})
+
+	It("removes expired failures when sweeping", func() {
+		callback("10.0.0.1:1234", "status=forbidden")
+		Expect(throttle.failures).To(HaveLen(1))
+
+		Expect(throttle.clock.Add(loginThrottleSweepInterval)).To(Succeed())
+		callback("10.0.0.2:1234", "status=forbidden")
+		Expect(throttle.failures).To(HaveLen(1))
+		Expect(throttle.failures).To(HaveKey("client 10.0.0.2"))
+	})
+})
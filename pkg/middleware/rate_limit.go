@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// rateLimitSweepInterval is how often buckets that have refilled are removed
+// so that the limiter does not grow with every client it has seen
+const rateLimitSweepInterval = time.Minute
+
+// NewRateLimiter returns a middleware that limits the number of requests
+// each client IP can make using a token bucket.
+// Each bucket holds up to burst tokens and is refilled at requestsPerSecond.
+// Requests from a client with an empty bucket receive a 429 response with a
+// Retry-After header.
+// The client IP is determined with the realClientIPParser when it is set.
+func NewRateLimiter(requestsPerSecond float64, burst int, realClientIPParser ipapi.RealClientIPParser) alice.Constructor {
+	if burst < 1 {
+		burst = int(math.Ceil(requestsPerSecond))
+	}
+	limiter := &rateLimiter{
+		rate:               requestsPerSecond,
+		burst:              float64(burst),
+		realClientIPParser: realClientIPParser,
+		buckets:            make(map[string]*tokenBucket),
+	}
+	return limiter.handler
+}
+
+// rateLimiter holds a token bucket for each client IP
+type rateLimiter struct {
+	rate               float64
+	burst              float64
+	realClientIPParser ipapi.RealClientIPParser
+	clock              clock.Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is the number of tokens left for a client at the time it was
+// last updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func (r *rateLimiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		client := ip.GetClientString(r.realClientIPParser, req, false)
+
+		allowed, retryAfter := r.allow(client)
+		if !allowed {
+			logger.Errorf("Rate limit exceeded for client %s on %s", client, req.URL.Path)
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// allow takes a token from the client's bucket.
+// When the bucket is empty, the time until the next token is available is
+// returned.
+func (r *rateLimiter) allow(client string) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	r.sweep(now)
+
+	bucket, ok := r.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: r.burst, updated: now}
+		r.buckets[client] = bucket
+	}
+	bucket.tokens = r.refill(bucket, now)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / r.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// refill returns the tokens in the bucket at the given time, up to the burst
+func (r *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	if elapsed <= 0 {
+		return bucket.tokens
+	}
+	return math.Min(r.burst, bucket.tokens+elapsed*r.rate)
+}
+
+// sweep removes the buckets that are full again, these behave the same as a
+// new bucket.
+// The lock must be held when calling sweep.
+func (r *rateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	r.lastSweep = now
+
+	for client, bucket := range r.buckets {
+		if r.refill(bucket, now) >= r.burst {
+			delete(r.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter suite", func() {
+	var limiter *rateLimiter
+	var handler http.Handler
+
+	BeforeEach(func() {
+		limiter = &rateLimiter{
+			rate:    1,
+			burst:   2,
+			buckets: make(map[string]*tokenBucket),
+		}
+		limiter.clock.Set(time.Unix(1234567890, 0))
+		handler = limiter.handler(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		limiter.clock.Reset()
+	})
+
+	request := func(remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("", "http://example.com/oauth2/start", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	It("allows requests up to the burst", func() {
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+
+		rw := request("10.0.0.1:1234", nil)
+		Expect(rw.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rw.Header().Get("Retry-After")).To(Equal("1"))
+	})
+
+	It("refills the bucket over time", func() {
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusTooManyRequests))
+
+		Expect(limiter.clock.Add(500 * time.Millisecond)).To(Succeed())
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusTooManyRequests))
+
+		Expect(limiter.clock.Add(500 * time.Millisecond)).To(Succeed())
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("limits each client separately", func() {
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusTooManyRequests))
+
+		Expect(request("10.0.0.2:1234", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	It("uses the real client IP when a parser is configured", func() {
+		parser, err := ip.GetRealClientIPParser("X-Real-IP", nil)
+		Expect(err).ToNot(HaveOccurred())
+		limiter.realClientIPParser = parser
+
+		Expect(request("10.0.0.1:1234", map[string]string{"X-Real-IP": "192.168.0.1"}).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", map[string]string{"X-Real-IP": "192.168.0.1"}).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.1:1234", map[string]string{"X-Real-IP": "192.168.0.1"}).Code).To(Equal(http.StatusTooManyRequests))
+
+		// A different client behind the same reverse proxy
+		Expect(request("10.0.0.1:1234", map[string]string{"X-Real-IP": "192.168.0.2"}).Code).To(Equal(http.StatusOK))
+	})
+
+	It("removes buckets that have refilled", func() {
+		Expect(request("10.0.0.1:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(request("10.0.0.2:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(limiter.buckets).To(HaveLen(2))
+
+		Expect(limiter.clock.Add(rateLimitSweepInterval)).To(Succeed())
+		Expect(request("10.0.0.3:1234", nil).Code).To(Equal(http.StatusOK))
+		Expect(limiter.buckets).To(HaveLen(1))
+		Expect(limiter.buckets).To(HaveKey("10.0.0.3"))
+	})
+})
@@ -2,10 +2,19 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	readynessStatusOK    = "ok"
+	readynessStatusError = "error"
 )
 
 // Verifiable an interface for an object that has a connection to external
@@ -14,27 +23,103 @@ type Verifiable interface {
 	VerifyConnection(context.Context) error
 }
 
+// ReadynessDependency is a named dependency that must be reachable for the
+// proxy to be considered ready
+type ReadynessDependency struct {
+	Name       string
+	Verifiable Verifiable
+}
+
+// readynessResponse is the JSON body returned from the readyness check
+type readynessResponse struct {
+	Status       string                               `json:"status"`
+	Dependencies map[string]readynessDependencyStatus `json:"dependencies"`
+}
+
+// readynessDependencyStatus is the result of verifying a single dependency
+type readynessDependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
 // NewReadynessCheck returns a middleware that performs deep health checks
-// (verifies the connection to any underlying store) on a specific `path`
-func NewReadynessCheck(path string, verifiable Verifiable) alice.Constructor {
+// (verifies the connection to each dependency) on a specific `path`.
+// Results are cached for the cacheTTL so that frequent probes do not
+// overload the dependencies.
+func NewReadynessCheck(path string, dependencies []ReadynessDependency, cacheTTL time.Duration) alice.Constructor {
+	check := &readynessCheck{
+		path:         path,
+		dependencies: dependencies,
+		cacheTTL:     cacheTTL,
+	}
 	return func(next http.Handler) http.Handler {
-		return readynessCheck(path, verifiable, next)
+		return check.handler(next)
 	}
 }
 
-func readynessCheck(path string, verifiable Verifiable, next http.Handler) http.Handler {
+// readynessCheck holds the dependencies to verify and the last
+// cached results
+type readynessCheck struct {
+	path         string
+	dependencies []ReadynessDependency
+	cacheTTL     time.Duration
+	clock        clock.Clock
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	cached    *readynessResponse
+}
+
+func (r *readynessCheck) handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		if path != "" && req.URL.EscapedPath() == path {
-			if err := verifiable.VerifyConnection(req.Context()); err != nil {
+		if r.path != "" && req.URL.EscapedPath() == r.path {
+			resp := r.check(req.Context())
+
+			rw.Header().Set("Content-Type", "application/json")
+			if resp.Status == readynessStatusOK {
+				rw.WriteHeader(http.StatusOK)
+			} else {
 				rw.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(rw, "error: %v", err)
-				return
 			}
-			rw.WriteHeader(http.StatusOK)
-			fmt.Fprintf(rw, "OK")
+			if err := json.NewEncoder(rw).Encode(resp); err != nil {
+				logger.Errorf("Error encoding readyness response: %v", err)
+			}
 			return
 		}
 
 		next.ServeHTTP(rw, req)
 	})
 }
+
+// check verifies each dependency, reusing the previous results if they
+// are younger than the cacheTTL.
+// The lock is held while verifying so that concurrent probes share a
+// single round of checks.
+func (r *readynessCheck) check(ctx context.Context) *readynessResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cached != nil && r.clock.Since(r.checkedAt) < r.cacheTTL {
+		return r.cached
+	}
+
+	resp := &readynessResponse{
+		Status:       readynessStatusOK,
+		Dependencies: make(map[string]readynessDependencyStatus, len(r.dependencies)),
+	}
+	for _, dep := range r.dependencies {
+		if err := dep.Verifiable.VerifyConnection(ctx); err != nil {
+			resp.Status = readynessStatusError
+			resp.Dependencies[dep.Name] = readynessDependencyStatus{
+				Status: readynessStatusError,
+				Error:  err.Error(),
+			}
+			continue
+		}
+		resp.Dependencies[dep.Name] = readynessDependencyStatus{Status: readynessStatusOK}
+	}
+
+	r.cached = resp
+	r.checkedAt = r.clock.Now()
+	return resp
+}
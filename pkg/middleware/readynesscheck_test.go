@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -13,11 +14,11 @@ import (
 
 var _ = Describe("ReadynessCheck suite", func() {
 	type requestTableInput struct {
-		readyPath        string
-		healthVerifiable Verifiable
-		requestString    string
-		expectedStatus   int
-		expectedBody     string
+		readyPath      string
+		dependencies   []ReadynessDependency
+		requestString  string
+		expectedStatus int
+		expectedBody   string
 	}
 
 	DescribeTable("when serving a request",
@@ -26,48 +27,102 @@ var _ = Describe("ReadynessCheck suite", func() {
 
 			rw := httptest.NewRecorder()
 
-			handler := NewReadynessCheck(in.readyPath, in.healthVerifiable)(http.NotFoundHandler())
+			handler := NewReadynessCheck(in.readyPath, in.dependencies, 0)(http.NotFoundHandler())
 			handler.ServeHTTP(rw, req)
 
 			Expect(rw.Code).To(Equal(in.expectedStatus))
 			Expect(rw.Body.String()).To(Equal(in.expectedBody))
 		},
 		Entry("when requesting the readyness check path", &requestTableInput{
-			readyPath:        "/ready",
-			healthVerifiable: &fakeVerifiable{nil},
-			requestString:    "http://example.com/ready",
-			expectedStatus:   200,
-			expectedBody:     "OK",
+			readyPath:      "/ready",
+			dependencies:   []ReadynessDependency{{Name: "session_store", Verifiable: &fakeVerifiable{nil}}},
+			requestString:  "http://example.com/ready",
+			expectedStatus: 200,
+			expectedBody:   `{"status":"ok","dependencies":{"session_store":{"status":"ok"}}}` + "\n",
 		}),
 		Entry("when requesting a different path", &requestTableInput{
-			readyPath:        "/ready",
-			healthVerifiable: &fakeVerifiable{nil},
-			requestString:    "http://example.com/different",
-			expectedStatus:   404,
-			expectedBody:     "404 page not found\n",
+			readyPath:      "/ready",
+			dependencies:   []ReadynessDependency{{Name: "session_store", Verifiable: &fakeVerifiable{nil}}},
+			requestString:  "http://example.com/different",
+			expectedStatus: 404,
+			expectedBody:   "404 page not found\n",
 		}),
 		Entry("when a blank string is configured as a readyness check path and the request has no specific path", &requestTableInput{
-			readyPath:        "",
-			healthVerifiable: &fakeVerifiable{nil},
-			requestString:    "http://example.com",
-			expectedStatus:   404,
-			expectedBody:     "404 page not found\n",
+			readyPath:      "",
+			dependencies:   []ReadynessDependency{{Name: "session_store", Verifiable: &fakeVerifiable{nil}}},
+			requestString:  "http://example.com",
+			expectedStatus: 404,
+			expectedBody:   "404 page not found\n",
 		}),
 		Entry("with full health check and without an underlying error", &requestTableInput{
-			readyPath:        "/ready",
-			healthVerifiable: &fakeVerifiable{nil},
-			requestString:    "http://example.com/ready",
-			expectedStatus:   200,
-			expectedBody:     "OK",
+			readyPath:      "/ready",
+			dependencies:   []ReadynessDependency{{Name: "session_store", Verifiable: &fakeVerifiable{nil}}},
+			requestString:  "http://example.com/ready",
+			expectedStatus: 200,
+			expectedBody:   `{"status":"ok","dependencies":{"session_store":{"status":"ok"}}}` + "\n",
 		}),
 		Entry("with full health check and with an underlying error", &requestTableInput{
-			readyPath:        "/ready",
-			healthVerifiable: &fakeVerifiable{func(ctx context.Context) error { return errors.New("failed to check") }},
-			requestString:    "http://example.com/ready",
-			expectedStatus:   500,
-			expectedBody:     "error: failed to check",
+			readyPath:      "/ready",
+			dependencies:   []ReadynessDependency{{Name: "session_store", Verifiable: &fakeVerifiable{func(ctx context.Context) error { return errors.New("failed to check") }}}},
+			requestString:  "http://example.com/ready",
+			expectedStatus: 500,
+			expectedBody:   `{"status":"error","dependencies":{"session_store":{"status":"error","error":"failed to check"}}}` + "\n",
+		}),
+		Entry("with multiple dependencies and one failing", &requestTableInput{
+			readyPath: "/ready",
+			dependencies: []ReadynessDependency{
+				{Name: "session_store", Verifiable: &fakeVerifiable{nil}},
+				{Name: "oidc_discovery", Verifiable: &fakeVerifiable{func(ctx context.Context) error { return errors.New("connection refused") }}},
+			},
+			requestString:  "http://example.com/ready",
+			expectedStatus: 500,
+			expectedBody:   `{"status":"error","dependencies":{"oidc_discovery":{"status":"error","error":"connection refused"},"session_store":{"status":"ok"}}}` + "\n",
 		}),
 	)
+
+	Context("with a cache TTL", func() {
+		var calls int
+		var check *readynessCheck
+		var handler http.Handler
+
+		BeforeEach(func() {
+			calls = 0
+			check = &readynessCheck{
+				path: "/ready",
+				dependencies: []ReadynessDependency{{Name: "session_store", Verifiable: &fakeVerifiable{func(ctx context.Context) error {
+					calls++
+					return nil
+				}}}},
+				cacheTTL: time.Minute,
+			}
+			check.clock.Set(time.Now())
+			handler = check.handler(http.NotFoundHandler())
+		})
+
+		AfterEach(func() {
+			check.clock.Reset()
+		})
+
+		serve := func() int {
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest("", "http://example.com/ready", nil))
+			return rw.Code
+		}
+
+		It("reuses the result within the TTL", func() {
+			Expect(serve()).To(Equal(http.StatusOK))
+			Expect(check.clock.Add(30 * time.Second)).To(Succeed())
+			Expect(serve()).To(Equal(http.StatusOK))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("verifies the dependencies again once the TTL has passed", func() {
+			Expect(serve()).To(Equal(http.StatusOK))
+			Expect(check.clock.Add(2 * time.Minute)).To(Succeed())
+			Expect(serve()).To(Equal(http.StatusOK))
+			Expect(calls).To(Equal(2))
+		})
+	})
 })
 
 type fakeVerifiable struct {
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 
@@ -121,4 +122,125 @@ var _ = Describe("Request logger suite", func() {
 			ExcludePaths:       []string{"/ping"},
 		}),
 	)
+
+	DescribeTable("when logging requests as JSON",
+		func(session *sessions.SessionState, upstream string, expected map[string]interface{}) {
+			buf := bytes.NewBuffer(nil)
+			logger.SetOutput(buf)
+			logger.SetExcludePaths([]string{})
+			logger.SetReqFormatType(logger.RequestLoggingFormatTypeJSON)
+			defer logger.SetReqFormatType(logger.RequestLoggingFormatTypeText)
+
+			req, err := http.NewRequest("GET", "/foo/bar?baz=1", nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.RemoteAddr = "127.0.0.1"
+			req.Host = "test-server"
+			req.Header.Set("User-Agent", "test \"agent\"")
+
+			scope := &middlewareapi.RequestScope{
+				RequestID: "11111111-2222-4333-8444-555555555555",
+				Session:   session,
+			}
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			handler := NewRequestLogger()(testUpstreamHandler(upstream))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(buf.String()).To(HaveSuffix("\n"))
+			Expect(bytes.Count(buf.Bytes(), []byte("\n"))).To(Equal(1))
+
+			entry := map[string]interface{}{}
+			Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+			Expect(entry).To(HaveKey("timestamp"))
+			Expect(entry).To(HaveKey("request_duration_ms"))
+			delete(entry, "timestamp")
+			delete(entry, "request_duration_ms")
+			Expect(entry).To(Equal(expected))
+		},
+		Entry("with an authenticated user", &sessions.SessionState{User: "standard.user"}, "standard", map[string]interface{}{
+			"client_ip":      "127.0.0.1",
+			"request_id":     "11111111-2222-4333-8444-555555555555",
+			"user":           "standard.user",
+			"host":           "test-server",
+			"method":         "GET",
+			"upstream":       "standard",
+			"uri":            "/foo/bar?baz=1",
+			"protocol":       "HTTP/1.1",
+			"user_agent":     "test \"agent\"",
+			"status":         float64(200),
+			"response_bytes": float64(4),
+		}),
+		Entry("without an authenticated user", nil, "", map[string]interface{}{
+			"client_ip":      "127.0.0.1",
+			"request_id":     "11111111-2222-4333-8444-555555555555",
+			"user":           "",
+			"host":           "test-server",
+			"method":         "GET",
+			"upstream":       "",
+			"uri":            "/foo/bar?baz=1",
+			"protocol":       "HTTP/1.1",
+			"user_agent":     "test \"agent\"",
+			"status":         float64(200),
+			"response_bytes": float64(4),
+		}),
+	)
+
+	DescribeTable("when redacting query parameters",
+		func(path string, redactParams []string, expectedURI string) {
+			buf := bytes.NewBuffer(nil)
+			logger.SetOutput(buf)
+			logger.SetReqTemplate("{{.RequestURI}}")
+			logger.SetExcludePaths([]string{})
+			if redactParams != nil {
+				logger.SetRedactQueryParams(redactParams)
+				defer logger.SetRedactQueryParams(logger.DefaultRedactedQueryParams)
+			}
+
+			req, err := http.NewRequest("GET", path, nil)
+			Expect(err).ToNot(HaveOccurred())
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+
+			handler := NewRequestLogger()(testUpstreamHandler(""))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(buf.String()).To(Equal(expectedURI + "\n"))
+		},
+		Entry("redacts the code and state of the callback by default",
+			"/oauth2/callback?code=4/P7q7W91&state=abc123:/app", nil,
+			"\"/oauth2/callback?code=REDACTED&state=REDACTED\""),
+		Entry("redacts access tokens by default and preserves other parameters",
+			"/api?page=2&access_token=eyJhbGciOi&sort=name&access_token=second", nil,
+			"\"/api?page=2&access_token=REDACTED&sort=name&access_token=REDACTED\""),
+		Entry("matches escaped parameter names",
+			"/api?access%5Ftoken=eyJhbGciOi&q=a%20b", nil,
+			"\"/api?access%5Ftoken=REDACTED&q=a%20b\""),
+		Entry("keeps parameters without values",
+			"/oauth2/callback?code&state=", nil,
+			"\"/oauth2/callback?code&state=REDACTED\""),
+		Entry("redacts the configured parameters",
+			"/oauth2/callback?code=4/P7q7W91&api_key=secret&state=abc", []string{"api_key"},
+			"\"/oauth2/callback?code=4/P7q7W91&api_key=REDACTED&state=abc\""),
+		Entry("redacts nothing when no parameters are configured",
+			"/oauth2/callback?code=4/P7q7W91", []string{},
+			"\"/oauth2/callback?code=4/P7q7W91\""),
+	)
+
+	It("redacts the uri of requests logged as JSON", func() {
+		buf := bytes.NewBuffer(nil)
+		logger.SetOutput(buf)
+		logger.SetExcludePaths([]string{})
+		logger.SetReqFormatType(logger.RequestLoggingFormatTypeJSON)
+		defer logger.SetReqFormatType(logger.RequestLoggingFormatTypeText)
+
+		req, err := http.NewRequest("GET", "/oauth2/callback?code=4/P7q7W91&state=abc&foo=bar", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+
+		handler := NewRequestLogger()(testUpstreamHandler(""))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("uri", "/oauth2/callback?code=REDACTED&state=REDACTED&foo=bar"))
+	})
 })
@@ -2,16 +2,24 @@ package middleware
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/justinas/alice"
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/tracing"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const (
@@ -31,6 +39,10 @@ const (
 	sessionRefreshRetryPeriod = 10 * time.Millisecond
 )
 
+// errSessionExpired is returned for sessions that are still expired after
+// any refresh
+var errSessionExpired = errors.New("session is expired")
+
 // StoredSessionLoaderOptions contains all of the requirements to construct
 // a stored session loader.
 // All options must be provided.
@@ -48,6 +60,48 @@ type StoredSessionLoaderOptions struct {
 	// If the sesssion is older than `RefreshPeriod` but the provider doesn't
 	// refresh it, we must re-validate using this validation.
 	ValidateSession func(context.Context, *sessionsapi.SessionState) bool
+
+	// Name of the session cookie.
+	// Concurrent refreshes of sessions loaded from the same cookie are
+	// coalesced so that only one request refreshes with the provider.
+	// If empty, refreshes are not coalesced.
+	CookieName string
+
+	// How long the result of a successful refresh is reused by other requests
+	// presenting the same session cookie. Requests that are already in flight
+	// are always coalesced.
+	RefreshCoalesceWindow time.Duration
+
+	// How long a session that is due to be refreshed remains usable when the
+	// refresh fails because the provider is unavailable.
+	// Subsequent requests retry the refresh until it succeeds or the grace
+	// period ends. Rejected refreshes are never given a grace period.
+	// If zero, failed refreshes fall back to validating the session.
+	RefreshGracePeriod time.Duration
+
+	// The maximum time since the user logged in before the session is
+	// removed, regardless of whether it can still be refreshed.
+	// If zero, sessions live for as long as they can be refreshed.
+	MaxLifetime time.Duration
+
+	// How long a session may go unused before it is removed, regardless of
+	// whether its tokens are still valid.
+	// The time of the last use is saved with the session on each request.
+	// If zero, the activity of sessions is not tracked.
+	IdleTimeout time.Duration
+
+	// Provider based check that the session is still active, eg that its
+	// access token has not been revoked, made on every request.
+	// Sessions are removed when it returns providers.ErrSessionRevoked, other
+	// errors keep the session so that requests do not fail while the
+	// provider is unavailable.
+	// If nil, sessions are only validated after a refresh.
+	ValidateEachRequest func(context.Context, *sessionsapi.SessionState) error
+
+	// How long a successful ValidateEachRequest check is reused for requests
+	// with the same access token, so that the provider is not called for
+	// every request.
+	ValidateEachRequestCacheTTL time.Duration
 }
 
 // NewStoredSessionLoader creates a new storedSessionLoader which loads
@@ -60,6 +114,13 @@ func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor
 		refreshPeriod:    opts.RefreshPeriod,
 		sessionRefresher: opts.RefreshSession,
 		sessionValidator: opts.ValidateSession,
+		cookieName:       opts.CookieName,
+		refreshGroup:     &refreshGroup{window: opts.RefreshCoalesceWindow},
+		gracePeriod:      opts.RefreshGracePeriod,
+		maxLifetime:      opts.MaxLifetime,
+		idleTimeout:      opts.IdleTimeout,
+		activeChecker:    opts.ValidateEachRequest,
+		activeCache:      &activeSessionCache{ttl: opts.ValidateEachRequestCacheTTL},
 	}
 	return ss.loadSession
 }
@@ -71,6 +132,17 @@ type storedSessionLoader struct {
 	refreshPeriod    time.Duration
 	sessionRefresher func(context.Context, *sessionsapi.SessionState) (bool, error)
 	sessionValidator func(context.Context, *sessionsapi.SessionState) bool
+	cookieName       string
+	refreshGroup     *refreshGroup
+	gracePeriod      time.Duration
+	maxLifetime      time.Duration
+	idleTimeout      time.Duration
+	activeChecker    func(context.Context, *sessionsapi.SessionState) error
+	activeCache      *activeSessionCache
+
+	// clock is passed to every loaded session so that expiry and refresh
+	// timing can be stubbed per loader instance.
+	clock clock.Clock
 }
 
 // loadSession attempts to load a session as identified by the request cookies.
@@ -112,15 +184,87 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 		// No session was found in the storage or error occurred, nothing more to do
 		return nil, err
 	}
+	session.Clock = s.clock
+
+	// Sessions past their maximum lifetime must not be refreshed, the user
+	// has to log in again
+	if s.maxLifetime > 0 && session.AuthenticatedAge() > s.maxLifetime {
+		return nil, fmt.Errorf("session (%s) has exceeded the maximum lifetime of %s", session, s.maxLifetime)
+	}
+	if s.idleTimeout > 0 && session.IdleTime() > s.idleTimeout {
+		return nil, fmt.Errorf("session (%s) has been idle for longer than %s", session, s.idleTimeout)
+	}
 
 	err = s.refreshSessionIfNeeded(rw, req, session)
 	if err != nil {
+		// Sessions without a refresh token cannot be renewed by a refresh
+		middlewareapi.GetRequestScope(req).SessionExpired = errors.Is(err, errSessionExpired) && session.RefreshToken == ""
 		return nil, fmt.Errorf("error refreshing access token for session (%s): %v", session, err)
 	}
 
+	if s.activeChecker != nil {
+		if err := s.checkSessionActive(req.Context(), session); err != nil {
+			return nil, fmt.Errorf("session (%s) is no longer active: %v", session, err)
+		}
+	}
+
+	if s.idleTimeout > 0 {
+		s.recordActivity(rw, req, session)
+	}
+	if session.SecretRotated {
+		s.saveRotatedSession(rw, req, session)
+	}
+
 	return session, nil
 }
 
+// saveRotatedSession saves a session that was loaded with a secondary cookie
+// secret, so that it is signed and encrypted with the current secret before
+// the secondary secret is removed.
+// Saving clears SecretRotated, so sessions that were saved since they were
+// loaded, eg by a refresh, are not saved again.
+func (s *storedSessionLoader) saveRotatedSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) {
+	if err := s.store.Save(rw, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session with the current cookie secret: %v", err)
+	}
+}
+
+// recordActivity saves the time of this request with the session so that
+// the idle timeout starts again.
+// Failing to save it does not fail the request, the session is then only
+// kept for the idle timeout since the last recorded activity.
+func (s *storedSessionLoader) recordActivity(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) {
+	if !session.RecordActivity() {
+		return
+	}
+	if err := s.store.Save(rw, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session activity: %v", err)
+	}
+}
+
+// checkSessionActive checks with the provider that the session is still
+// active unless it was checked within the cache TTL.
+// Only a rejection by the provider is returned as an error.
+func (s *storedSessionLoader) checkSessionActive(ctx context.Context, session *sessionsapi.SessionState) error {
+	sum := sha256.Sum256([]byte(session.AccessToken))
+	key := hex.EncodeToString(sum[:])
+	if s.activeCache.has(key, s.clock.Now()) {
+		return nil
+	}
+
+	err := s.activeChecker(ctx, session)
+	if errors.Is(err, providers.ErrSessionRevoked) {
+		return err
+	}
+	if err != nil {
+		logger.Errorf("Unable to check the session is still active, keeping the session: %v", err)
+		return nil
+	}
+
+	s.activeCache.add(key, s.clock.Now())
+	return nil
+}
+
 // refreshSessionIfNeeded will attempt to refresh a session if the session
 // is older than the refresh period.
 // Success or fail, we will then validate the session.
@@ -130,6 +274,31 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 		return nil
 	}
 
+	key := s.refreshKey(req)
+	if key == "" || s.refreshGroup == nil {
+		return s.refreshSessionWithLock(rw, req, session)
+	}
+
+	refreshed, shared, err := s.refreshGroup.do(key, s.clock, func() (*sessionsapi.SessionState, error) {
+		err := s.refreshSessionWithLock(rw, req, session)
+		return copySession(session), err
+	})
+	if err != nil || !shared {
+		return err
+	}
+
+	// Another request refreshed this session for us, it has already been
+	// saved and validated so we only need to take on its state.
+	restoreSession(session, refreshed)
+	if session.IsExpired() && !s.inGracePeriod(session) {
+		return errSessionExpired
+	}
+	return nil
+}
+
+// refreshSessionWithLock obtains the session lock and then refreshes and
+// validates the session if it still needs refreshing.
+func (s *storedSessionLoader) refreshSessionWithLock(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
 	var lockObtained bool
 	ctx, cancel := context.WithTimeout(context.Background(), sessionRefreshObtainTimeout)
 	defer cancel()
@@ -172,12 +341,7 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 	}
 	// Restore the state of the fresh session into the original pointer.
 	// This is important so that changes are passed up the to the parent scope.
-	lock := session.Lock
-	*session = *freshSession
-
-	// Ensure we maintain the session lock after we have refreshed the session.
-	// Loading from the session store creates a new lock in the session.
-	session.Lock = lock
+	restoreSession(session, freshSession)
 
 	if !needsRefresh(s.refreshPeriod, session) {
 		// The session must have already been refreshed while we were waiting to
@@ -185,18 +349,202 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 		return nil
 	}
 
-	// We are holding the lock and the session needs a refresh
+	// We are holding the lock and the session needs a refresh.
+	// Keep the time of the login as refreshing resets CreatedAt.
+	if s.maxLifetime > 0 {
+		session.RecordAuthenticatedAt()
+	}
 	logger.Printf("Refreshing session - User: %s; SessionAge: %s", session.User, session.Age())
 	if err := s.refreshSession(rw, req, session); err != nil {
 		// If a preemptive refresh fails, we still keep the session
 		// if validateSession succeeds.
 		logger.Errorf("Unable to refresh session: %v", err)
+
+		// The provider may be unavailable to validate the session too, keep
+		// the session as it was until the refresh can be retried.
+		if providers.IsTransientRefreshError(err) && s.inGracePeriod(session) {
+			logger.Printf("Keeping session within the refresh grace period - User: %s; SessionAge: %s", session.User, session.Age())
+			return nil
+		}
 	}
 
 	// Validate all sessions after any Redeem/Refresh operation (fail or success)
 	return s.validateSession(req.Context(), session)
 }
 
+// restoreSession copies the state of from into session.
+// The lock and clock of session are maintained as loading from the session
+// store creates a new lock in the session.
+func restoreSession(session, from *sessionsapi.SessionState) {
+	lock := session.Lock
+	sessionClock := session.Clock
+	*session = *from
+	session.Lock = lock
+	session.Clock = sessionClock
+}
+
+// copySession returns a copy of the session that can be shared with other
+// requests without sharing mutable state.
+func copySession(session *sessionsapi.SessionState) *sessionsapi.SessionState {
+	c := *session
+	if session.ExchangedTokens != nil {
+		c.ExchangedTokens = make(map[string]sessionsapi.ExchangedToken, len(session.ExchangedTokens))
+		for audience, token := range session.ExchangedTokens {
+			c.ExchangedTokens[audience] = token
+		}
+	}
+	return &c
+}
+
+// refreshKey identifies the session presented by the request using the
+// values of the session cookie, including any split cookie parts.
+// An empty key means the refresh should not be coalesced.
+func (s *storedSessionLoader) refreshKey(req *http.Request) string {
+	if s.cookieName == "" {
+		return ""
+	}
+
+	var values []string
+	for _, c := range req.Cookies() {
+		if c.Name == s.cookieName || isSplitCookieName(c.Name, s.cookieName) {
+			values = append(values, c.Name+"="+c.Value)
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(values, ";")))
+	return hex.EncodeToString(sum[:])
+}
+
+// isSplitCookieName checks whether the name is of a cookie split from the
+// named cookie, eg `_oauth2_proxy_1`.
+func isSplitCookieName(name, cookieName string) bool {
+	suffix := strings.TrimPrefix(name, cookieName+"_")
+	if suffix == name {
+		return false
+	}
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+// refreshGroup coalesces concurrent session refreshes by key so that only one
+// refresh runs at a time and the other callers wait for its result.
+// Successful results are reused for the window, failed results are never
+// reused so that they do not affect subsequent refresh attempts.
+type refreshGroup struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*refreshCall
+}
+
+// refreshCall is an in flight or recently completed refresh.
+type refreshCall struct {
+	done    chan struct{}
+	session *sessionsapi.SessionState
+	err     error
+}
+
+// do runs the refresh unless a refresh for the key is already in flight or
+// completed within the window, in which case its result is returned instead.
+// When the refresh of another caller fails, e.g. because its request was
+// cancelled, the waiting callers run their own refresh rather than sharing
+// its error.
+// shared reports whether the result came from another caller.
+func (g *refreshGroup) do(key string, c clock.Clock, refresh func() (*sessionsapi.SessionState, error)) (*sessionsapi.SessionState, bool, error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = make(map[string]*refreshCall)
+		}
+		call, ok := g.calls[key]
+		if !ok {
+			break
+		}
+		g.mu.Unlock()
+		<-call.done
+		if call.err == nil {
+			return copySession(call.session), true, nil
+		}
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.session, call.err = refresh()
+
+	// A failed call is forgotten before the waiters are released so that they
+	// do not find it again when they retry.
+	if call.err != nil || g.window <= 0 {
+		g.forget(key, call)
+	} else {
+		c.AfterFunc(g.window, func() { g.forget(key, call) })
+	}
+	close(call.done)
+	return call.session, false, call.err
+}
+
+// forget removes the call for the key if it has not already been replaced.
+func (g *refreshGroup) forget(key string, call *refreshCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// activeSessionCache holds the access tokens that were recently checked to
+// still be active, until the ttl has passed.
+type activeSessionCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+func (c *activeSessionCache) has(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.expires[key]
+	return ok && now.Before(expires)
+}
+
+func (c *activeSessionCache) add(key string, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.expires == nil {
+		c.expires = make(map[string]time.Time)
+	}
+	// Remove expired tokens so that the cache does not keep every token
+	// that has been seen
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for k, expires := range c.expires {
+			if !now.Before(expires) {
+				delete(c.expires, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.expires[key] = now.Add(c.ttl)
+}
+
+// inGracePeriod determines whether the session was due to be refreshed less
+// than the grace period ago.
+// Failed refreshes do not reset CreatedAt, so retries within the grace period
+// do not extend it.
+func (s *storedSessionLoader) inGracePeriod(session *sessionsapi.SessionState) bool {
+	return s.gracePeriod > 0 && session.Age() <= s.refreshPeriod+s.gracePeriod
+}
+
 // needsRefresh determines whether we should attempt to refresh a session or not.
 func needsRefresh(refreshPeriod time.Duration, session *sessionsapi.SessionState) bool {
 	return refreshPeriod > time.Duration(0) && session.Age() > refreshPeriod
@@ -205,9 +553,20 @@ func needsRefresh(refreshPeriod time.Duration, session *sessionsapi.SessionState
 // refreshSession attempts to refresh the session with the provider
 // and will save the session if it was updated.
 func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
-	refreshed, err := s.sessionRefresher(req.Context(), session)
+	ctx, span := tracing.Tracer().Start(req.Context(), "session.refresh")
+	defer span.End()
+	req = req.WithContext(ctx)
+
+	refreshed, err := s.sessionRefresher(ctx, session)
 	if err != nil && !errors.Is(err, providers.ErrNotImplemented) {
-		return fmt.Errorf("error refreshing tokens: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error refreshing tokens")
+		return fmt.Errorf("error refreshing tokens: %w", err)
+	}
+
+	// Tokens exchanged for the previous access token must be exchanged again
+	if refreshed {
+		session.ExchangedTokens = nil
 	}
 
 	// HACK:
@@ -233,6 +592,8 @@ func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.R
 	err = s.store.Save(rw, req, session)
 	if err != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error saving session")
 		return fmt.Errorf("error saving session: %v", err)
 	}
 	return nil
@@ -243,10 +604,14 @@ func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.R
 // An error implies the session is not longer valid.
 func (s *storedSessionLoader) validateSession(ctx context.Context, session *sessionsapi.SessionState) error {
 	if session.IsExpired() {
-		return errors.New("session is expired")
+		return errSessionExpired
 	}
 
+	ctx, span := tracing.Tracer().Start(ctx, "session.validate")
+	defer span.End()
+
 	if !s.sessionValidator(ctx, session) {
+		span.SetStatus(codes.Error, "session is invalid")
 		return errors.New("session is invalid")
 	}
 
@@ -4,18 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"golang.org/x/oauth2"
 )
 
 type testLock struct {
@@ -135,6 +139,11 @@ var _ = Describe("Stored Session Suite", func() {
 						CreatedAt:    &createdPast,
 						ExpiresOn:    &createdPast,
 					}, nil
+				case "_oauth2_proxy=ExpiredWithoutRefreshTokenSession":
+					return &sessionsapi.SessionState{
+						CreatedAt: &createdPast,
+						ExpiresOn: &createdPast,
+					}, nil
 				case "_oauth2_proxy=RefreshSession":
 					return &sessionsapi.SessionState{
 						RefreshToken: refresh,
@@ -167,6 +176,7 @@ var _ = Describe("Stored Session Suite", func() {
 			requestHeaders  http.Header
 			existingSession *sessionsapi.SessionState
 			expectedSession *sessionsapi.SessionState
+			expectedExpired bool
 			store           sessionsapi.SessionStore
 			refreshPeriod   time.Duration
 			refreshSession  func(context.Context, *sessionsapi.SessionState) (bool, error)
@@ -196,12 +206,15 @@ var _ = Describe("Stored Session Suite", func() {
 				// Create the handler with a next handler that will capture the session
 				// from the scope
 				var gotSession *sessionsapi.SessionState
+				var gotExpired bool
 				handler := NewStoredSessionLoader(opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					gotSession = middlewareapi.GetRequestScope(r).Session
+					gotExpired = middlewareapi.GetRequestScope(r).SessionExpired
 				}))
 				handler.ServeHTTP(rw, req)
 
 				Expect(gotSession).To(Equal(in.expectedSession))
+				Expect(gotExpired).To(Equal(in.expectedExpired))
 			},
 			Entry("with no cookie", storedSessionLoaderTableInput{
 				requestHeaders:  http.Header{},
@@ -265,6 +278,18 @@ var _ = Describe("Stored Session Suite", func() {
 				refreshSession:  defaultRefreshFunc,
 				validateSession: defaultValidateFunc,
 			}),
+			Entry("with a session without a refresh token that has expired", storedSessionLoaderTableInput{
+				requestHeaders: http.Header{
+					"Cookie": []string{"_oauth2_proxy=ExpiredWithoutRefreshTokenSession"},
+				},
+				existingSession: nil,
+				expectedSession: nil,
+				expectedExpired: true,
+				store:           defaultSessionStore,
+				refreshPeriod:   1 * time.Minute,
+				refreshSession:  defaultRefreshFunc,
+				validateSession: defaultValidateFunc,
+			}),
 			Entry("with a session that can refresh, but is younger than refresh period", storedSessionLoaderTableInput{
 				requestHeaders: http.Header{
 					"Cookie": []string{"_oauth2_proxy=RefreshSession"},
@@ -436,6 +461,672 @@ var _ = Describe("Stored Session Suite", func() {
 		)
 	})
 
+	Context("with coalesced refreshes", func() {
+		const cookieName = "_oauth2_proxy"
+
+		now := time.Unix(1234567890, 0)
+		createdPast := now.Add(-5 * time.Minute)
+
+		var refreshCount int32
+		var refreshErr error
+		var releaseRefresh chan struct{}
+		var loader *storedSessionLoader
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&refreshCount, 0)
+			refreshErr = nil
+			releaseRefresh = nil
+
+			store := &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					// The stored session is never updated so that every request
+					// would refresh the session if it were not coalesced.
+					return &sessionsapi.SessionState{
+						AccessToken:  "AccessToken",
+						RefreshToken: refresh,
+						CreatedAt:    &createdPast,
+					}, nil
+				},
+				SaveFunc: func(http.ResponseWriter, *http.Request, *sessionsapi.SessionState) error {
+					return nil
+				},
+				ClearFunc: func(http.ResponseWriter, *http.Request) error {
+					return nil
+				},
+			}
+
+			loader = &storedSessionLoader{
+				store:         store,
+				refreshPeriod: time.Minute,
+				cookieName:    cookieName,
+				refreshGroup:  &refreshGroup{window: time.Minute},
+				sessionRefresher: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					atomic.AddInt32(&refreshCount, 1)
+					if releaseRefresh != nil {
+						<-releaseRefresh
+					}
+					if refreshErr != nil {
+						return false, refreshErr
+					}
+					ss.AccessToken = refreshed
+					return true, nil
+				},
+				sessionValidator: func(_ context.Context, ss *sessionsapi.SessionState) bool {
+					return ss.AccessToken == refreshed
+				},
+			}
+			loader.clock.Set(now)
+		})
+
+		loadSessionWithContext := func(ctx context.Context, cookieValue string) *sessionsapi.SessionState {
+			scope := &middlewareapi.RequestScope{}
+			req := httptest.NewRequest("", "/", nil).WithContext(ctx)
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue})
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			handler := loader.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return scope.Session
+		}
+		loadSession := func(cookieValue string) *sessionsapi.SessionState {
+			return loadSessionWithContext(context.Background(), cookieValue)
+		}
+
+		It("only refreshes once for concurrent requests with the same cookie", func() {
+			releaseRefresh = make(chan struct{})
+			const numConcReqs = 5
+
+			sessions := make(chan *sessionsapi.SessionState, numConcReqs)
+			for i := 0; i < numConcReqs; i++ {
+				go func() {
+					defer GinkgoRecover()
+					sessions <- loadSession("ticket")
+				}()
+			}
+
+			Eventually(func() int32 { return atomic.LoadInt32(&refreshCount) }).Should(Equal(int32(1)))
+			// Give the other requests time to join the in flight refresh
+			time.Sleep(50 * time.Millisecond)
+			close(releaseRefresh)
+
+			for i := 0; i < numConcReqs; i++ {
+				session := <-sessions
+				Expect(session).ToNot(BeNil())
+				Expect(session.AccessToken).To(Equal(refreshed))
+			}
+			Expect(atomic.LoadInt32(&refreshCount)).To(Equal(int32(1)))
+		})
+
+		It("reuses a successful refresh within the window", func() {
+			Expect(loadSession("ticket").AccessToken).To(Equal(refreshed))
+			Expect(loadSession("ticket").AccessToken).To(Equal(refreshed))
+			Expect(atomic.LoadInt32(&refreshCount)).To(Equal(int32(1)))
+		})
+
+		It("refreshes again once the window has passed", func() {
+			Expect(loadSession("ticket").AccessToken).To(Equal(refreshed))
+			Expect(loader.clock.Add(2 * time.Minute)).To(Succeed())
+
+			Eventually(func() int32 {
+				loadSession("ticket")
+				return atomic.LoadInt32(&refreshCount)
+			}).Should(BeNumerically(">=", 2))
+		})
+
+		It("refreshes separately for different cookies", func() {
+			Expect(loadSession("ticket").AccessToken).To(Equal(refreshed))
+			Expect(loadSession("other").AccessToken).To(Equal(refreshed))
+			Expect(atomic.LoadInt32(&refreshCount)).To(Equal(int32(2)))
+		})
+
+		It("does not reuse a failed refresh", func() {
+			refreshErr = errors.New("error refreshing session")
+			Expect(loadSession("ticket")).To(BeNil())
+
+			refreshErr = nil
+			Expect(loadSession("ticket").AccessToken).To(Equal(refreshed))
+			Expect(atomic.LoadInt32(&refreshCount)).To(Equal(int32(2)))
+		})
+
+		It("refreshes again for the waiting requests when the refreshing request is cancelled", func() {
+			loader.sessionRefresher = func(ctx context.Context, ss *sessionsapi.SessionState) (bool, error) {
+				if atomic.AddInt32(&refreshCount, 1) == 1 {
+					<-ctx.Done()
+					return false, ctx.Err()
+				}
+				ss.AccessToken = refreshed
+				return true, nil
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancelled := make(chan *sessionsapi.SessionState, 1)
+			go func() {
+				defer GinkgoRecover()
+				cancelled <- loadSessionWithContext(ctx, "ticket")
+			}()
+			Eventually(func() int32 { return atomic.LoadInt32(&refreshCount) }).Should(Equal(int32(1)))
+
+			waiting := make(chan *sessionsapi.SessionState, 1)
+			go func() {
+				defer GinkgoRecover()
+				waiting <- loadSession("ticket")
+			}()
+			// Give the other request time to join the in flight refresh
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+
+			Expect(<-cancelled).To(BeNil())
+			session := <-waiting
+			Expect(session).ToNot(BeNil())
+			Expect(session.AccessToken).To(Equal(refreshed))
+			Expect(atomic.LoadInt32(&refreshCount)).To(Equal(int32(2)))
+		})
+	})
+
+	Context("with a maximum lifetime", func() {
+		const cookieName = "_oauth2_proxy"
+
+		login := time.Unix(1234567890, 0)
+
+		var stored *sessionsapi.SessionState
+		var refreshCount int
+		var cleared bool
+		var loader *storedSessionLoader
+
+		BeforeEach(func() {
+			stored = &sessionsapi.SessionState{
+				AccessToken:  "AccessToken",
+				RefreshToken: refresh,
+				CreatedAt:    &login,
+			}
+			refreshCount = 0
+			cleared = false
+
+			store := &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					if stored == nil {
+						return nil, http.ErrNoCookie
+					}
+					ss := *stored
+					return &ss, nil
+				},
+				SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+					saved := *ss
+					stored = &saved
+					return nil
+				},
+				ClearFunc: func(http.ResponseWriter, *http.Request) error {
+					cleared = true
+					stored = nil
+					return nil
+				},
+			}
+
+			loader = &storedSessionLoader{
+				store:         store,
+				refreshPeriod: time.Hour,
+				maxLifetime:   12 * time.Hour,
+				cookieName:    cookieName,
+				refreshGroup:  &refreshGroup{},
+				sessionRefresher: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					refreshCount++
+					ss.AccessToken = refreshed
+					return true, nil
+				},
+				sessionValidator: func(context.Context, *sessionsapi.SessionState) bool {
+					return true
+				},
+			}
+			loader.clock.Set(login)
+		})
+
+		loadSession := func() *sessionsapi.SessionState {
+			scope := &middlewareapi.RequestScope{}
+			req := httptest.NewRequest("", "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: "ticket"})
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			handler := loader.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return scope.Session
+		}
+
+		It("keeps refreshing the session until the maximum lifetime", func() {
+			for i := 1; i <= 11; i++ {
+				Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+
+				session := loadSession()
+				Expect(session).ToNot(BeNil())
+				Expect(session.AccessToken).To(Equal(refreshed))
+				Expect(session.AuthenticatedAt).ToNot(BeNil())
+				Expect(*session.AuthenticatedAt).To(Equal(login))
+			}
+			Expect(refreshCount).To(Equal(11))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("removes the session once the maximum lifetime has passed", func() {
+			for i := 1; i <= 11; i++ {
+				Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+				Expect(loadSession()).ToNot(BeNil())
+			}
+			Expect(loader.clock.Add(time.Hour)).To(Succeed())
+
+			Expect(loadSession()).To(BeNil())
+			Expect(refreshCount).To(Equal(11))
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("removes a session that was never refreshed", func() {
+			loader.refreshPeriod = 0
+			Expect(loader.clock.Add(12*time.Hour + time.Minute)).To(Succeed())
+
+			Expect(loadSession()).To(BeNil())
+			Expect(refreshCount).To(Equal(0))
+			Expect(cleared).To(BeTrue())
+		})
+	})
+
+	Context("with an idle timeout", func() {
+		login := time.Unix(1234567890, 0)
+
+		var stored *sessionsapi.SessionState
+		var saveCount int
+		var cleared bool
+		var loader *storedSessionLoader
+
+		BeforeEach(func() {
+			stored = &sessionsapi.SessionState{
+				AccessToken: "AccessToken",
+				CreatedAt:   &login,
+			}
+			saveCount = 0
+			cleared = false
+
+			store := &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					if stored == nil {
+						return nil, http.ErrNoCookie
+					}
+					ss := *stored
+					return &ss, nil
+				},
+				SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+					saveCount++
+					saved := *ss
+					stored = &saved
+					return nil
+				},
+				ClearFunc: func(http.ResponseWriter, *http.Request) error {
+					cleared = true
+					stored = nil
+					return nil
+				},
+			}
+
+			loader = &storedSessionLoader{
+				store:       store,
+				idleTimeout: 30 * time.Minute,
+			}
+			loader.clock.Set(login)
+		})
+
+		loadSession := func() *sessionsapi.SessionState {
+			scope := &middlewareapi.RequestScope{}
+			req := httptest.NewRequest("", "/", nil)
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			handler := loader.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return scope.Session
+		}
+
+		It("keeps a session that is used within the idle timeout", func() {
+			for i := 1; i <= 5; i++ {
+				Expect(loader.clock.Add(29 * time.Minute)).To(Succeed())
+
+				Expect(loadSession()).ToNot(BeNil())
+				Expect(stored.LastActivityAt).ToNot(BeNil())
+				Expect(*stored.LastActivityAt).To(Equal(loader.clock.Now()))
+			}
+			Expect(saveCount).To(Equal(5))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("removes the session once it has been idle for longer than the idle timeout", func() {
+			Expect(loader.clock.Add(10 * time.Minute)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+
+			Expect(loader.clock.Add(30*time.Minute + time.Second)).To(Succeed())
+			Expect(loadSession()).To(BeNil())
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("removes a session without any activity once it is older than the idle timeout", func() {
+			Expect(loader.clock.Add(31 * time.Minute)).To(Succeed())
+
+			Expect(loadSession()).To(BeNil())
+			Expect(saveCount).To(Equal(0))
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("only saves the session once per second", func() {
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(saveCount).To(Equal(1))
+
+			Expect(loader.clock.Add(time.Second)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(saveCount).To(Equal(2))
+		})
+
+		It("does not track activity without an idle timeout", func() {
+			loader.idleTimeout = 0
+			Expect(loader.clock.Add(time.Hour)).To(Succeed())
+
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(saveCount).To(Equal(0))
+			Expect(stored.LastActivityAt).To(BeNil())
+		})
+	})
+
+	Context("with a session loaded with a secondary cookie secret", func() {
+		It("saves the session with the current secret once", func() {
+			stored := &sessionsapi.SessionState{AccessToken: "AccessToken", SecretRotated: true}
+			saveCount := 0
+			store := &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					ss := *stored
+					return &ss, nil
+				},
+				SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+					saveCount++
+					// Saving signs the session with the current secret
+					ss.SecretRotated = false
+					saved := *ss
+					stored = &saved
+					return nil
+				},
+			}
+			loader := &storedSessionLoader{store: store}
+
+			for i := 0; i < 2; i++ {
+				scope := &middlewareapi.RequestScope{}
+				req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), scope)
+				loader.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+				Expect(scope.Session).ToNot(BeNil())
+				Expect(scope.Session.AccessToken).To(Equal("AccessToken"))
+			}
+			Expect(saveCount).To(Equal(1))
+			Expect(stored.SecretRotated).To(BeFalse())
+		})
+	})
+
+	Context("with a refresh grace period", func() {
+		const cookieName = "_oauth2_proxy"
+
+		login := time.Unix(1234567890, 0)
+		expires := login.Add(time.Hour)
+
+		unavailable := &oauth2.RetrieveError{
+			Response: &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"},
+		}
+		invalidGrant := &oauth2.RetrieveError{
+			Response: &http.Response{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"},
+			Body:     []byte(`{"error":"invalid_grant"}`),
+		}
+
+		var stored *sessionsapi.SessionState
+		var refreshErr error
+		var refreshCount int
+		var cleared bool
+		var loader *storedSessionLoader
+
+		BeforeEach(func() {
+			stored = &sessionsapi.SessionState{
+				AccessToken:  "AccessToken",
+				RefreshToken: refresh,
+				CreatedAt:    &login,
+				ExpiresOn:    &expires,
+			}
+			refreshErr = unavailable
+			refreshCount = 0
+			cleared = false
+
+			store := &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					if stored == nil {
+						return nil, http.ErrNoCookie
+					}
+					ss := *stored
+					return &ss, nil
+				},
+				SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+					saved := *ss
+					stored = &saved
+					return nil
+				},
+				ClearFunc: func(http.ResponseWriter, *http.Request) error {
+					cleared = true
+					stored = nil
+					return nil
+				},
+			}
+
+			loader = &storedSessionLoader{
+				store:         store,
+				refreshPeriod: time.Hour,
+				gracePeriod:   15 * time.Minute,
+				cookieName:    cookieName,
+				refreshGroup:  &refreshGroup{},
+				sessionRefresher: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					refreshCount++
+					if refreshErr != nil {
+						return false, fmt.Errorf("unable to redeem refresh token: %w", refreshErr)
+					}
+					ss.AccessToken = refreshed
+					newExpires := loader.clock.Now().Add(time.Hour)
+					ss.ExpiresOn = &newExpires
+					return true, nil
+				},
+				// The provider cannot validate the session while it is unavailable
+				sessionValidator: func(context.Context, *sessionsapi.SessionState) bool {
+					return refreshErr == nil
+				},
+			}
+			loader.clock.Set(login)
+		})
+
+		loadSession := func() *sessionsapi.SessionState {
+			scope := &middlewareapi.RequestScope{}
+			req := httptest.NewRequest("", "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: "ticket"})
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			handler := loader.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return scope.Session
+		}
+
+		It("keeps the session and retries the refresh while the provider is unavailable", func() {
+			Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+			session := loadSession()
+			Expect(session).ToNot(BeNil())
+			Expect(session.AccessToken).To(Equal("AccessToken"))
+
+			Expect(loader.clock.Add(10 * time.Minute)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+
+			Expect(refreshCount).To(Equal(2))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("keeps the session when the provider cannot be reached", func() {
+			refreshErr = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+			Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("removes the session once the grace period has ended", func() {
+			Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+
+			Expect(loader.clock.Add(15 * time.Minute)).To(Succeed())
+			Expect(loadSession()).To(BeNil())
+			Expect(refreshCount).To(Equal(2))
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("removes the session immediately when the refresh token is rejected", func() {
+			refreshErr = invalidGrant
+
+			Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+			Expect(loadSession()).To(BeNil())
+			Expect(refreshCount).To(Equal(1))
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("uses the refreshed session once the provider is available again", func() {
+			Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+
+			refreshErr = nil
+			Expect(loader.clock.Add(5 * time.Minute)).To(Succeed())
+			session := loadSession()
+			Expect(session).ToNot(BeNil())
+			Expect(session.AccessToken).To(Equal(refreshed))
+
+			// The refresh resets the grace period
+			Expect(loader.clock.Add(30 * time.Minute)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(refreshCount).To(Equal(2))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("removes the session when there is no grace period", func() {
+			loader.gracePeriod = 0
+
+			Expect(loader.clock.Add(time.Hour + time.Minute)).To(Succeed())
+			Expect(loadSession()).To(BeNil())
+			Expect(cleared).To(BeTrue())
+		})
+	})
+
+	Context("with validation on each request", func() {
+		const cookieName = "_oauth2_proxy"
+
+		login := time.Unix(1234567890, 0)
+
+		var stored *sessionsapi.SessionState
+		var checkErr error
+		var checkCount int
+		var cleared bool
+		var loader *storedSessionLoader
+
+		BeforeEach(func() {
+			stored = &sessionsapi.SessionState{
+				AccessToken: "AccessToken",
+				CreatedAt:   &login,
+			}
+			checkErr = nil
+			checkCount = 0
+			cleared = false
+
+			store := &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					if stored == nil {
+						return nil, http.ErrNoCookie
+					}
+					ss := *stored
+					return &ss, nil
+				},
+				ClearFunc: func(http.ResponseWriter, *http.Request) error {
+					cleared = true
+					stored = nil
+					return nil
+				},
+			}
+
+			loader = &storedSessionLoader{
+				store:        store,
+				cookieName:   cookieName,
+				refreshGroup: &refreshGroup{},
+				activeChecker: func(context.Context, *sessionsapi.SessionState) error {
+					checkCount++
+					return checkErr
+				},
+				activeCache: &activeSessionCache{ttl: 10 * time.Second},
+			}
+			loader.clock.Set(login)
+		})
+
+		loadSession := func() *sessionsapi.SessionState {
+			scope := &middlewareapi.RequestScope{}
+			req := httptest.NewRequest("", "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: "ticket"})
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			handler := loader.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return scope.Session
+		}
+
+		It("reuses a successful check within the cache TTL", func() {
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(loader.clock.Add(5 * time.Second)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(checkCount).To(Equal(1))
+
+			Expect(loader.clock.Add(5 * time.Second)).To(Succeed())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(checkCount).To(Equal(2))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("checks each access token separately", func() {
+			Expect(loadSession()).ToNot(BeNil())
+			stored.AccessToken = "OtherAccessToken"
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(checkCount).To(Equal(2))
+		})
+
+		It("removes the session once the token is revoked", func() {
+			Expect(loadSession()).ToNot(BeNil())
+
+			checkErr = fmt.Errorf("%w: status 401", providers.ErrSessionRevoked)
+			Expect(loader.clock.Add(11 * time.Second)).To(Succeed())
+			Expect(loadSession()).To(BeNil())
+			Expect(checkCount).To(Equal(2))
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("does not cache a revoked token", func() {
+			checkErr = providers.ErrSessionRevoked
+			Expect(loadSession()).To(BeNil())
+
+			stored = &sessionsapi.SessionState{AccessToken: "AccessToken", CreatedAt: &login}
+			Expect(loadSession()).To(BeNil())
+			Expect(checkCount).To(Equal(2))
+		})
+
+		It("keeps the session when the provider is unavailable", func() {
+			checkErr = &requests.UnexpectedStatusError{StatusCode: http.StatusServiceUnavailable}
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(checkCount).To(Equal(2))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("checks every request without a cache TTL", func() {
+			loader.activeCache = &activeSessionCache{}
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(loadSession()).ToNot(BeNil())
+			Expect(checkCount).To(Equal(2))
+		})
+	})
+
 	Context("refreshSessionIfNeeded", func() {
 		type refreshSessionIfNeededTableInput struct {
 			refreshPeriod            time.Duration
@@ -447,14 +1138,16 @@ var _ = Describe("Stored Session Suite", func() {
 			expectedLockObtained     bool
 		}
 
-		createdPast := time.Now().Add(-5 * time.Minute)
-		createdFuture := time.Now().Add(5 * time.Minute)
+		now := time.Unix(1234567890, 0)
+		createdPast := now.Add(-5 * time.Minute)
+		createdFuture := now.Add(5 * time.Minute)
 
 		DescribeTable("with a session",
 			func(in refreshSessionIfNeededTableInput) {
 				refreshed := false
 				validated := false
 
+				in.session.Clock.Set(now)
 				session := &sessionsapi.SessionState{}
 				*session = *in.session
 				if in.concurrentSessionRefresh {
@@ -477,6 +1170,7 @@ var _ = Describe("Stored Session Suite", func() {
 				s := &storedSessionLoader{
 					refreshPeriod: in.refreshPeriod,
 					store:         store,
+					clock:         in.session.Clock,
 					sessionRefresher: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
 						refreshed = true
 						switch ss.RefreshToken {
@@ -634,9 +1328,10 @@ var _ = Describe("Stored Session Suite", func() {
 
 	Context("refreshSession", func() {
 		type refreshSessionWithProviderTableInput struct {
-			session     *sessionsapi.SessionState
-			expectedErr error
-			expectSaved bool
+			session               *sessionsapi.SessionState
+			expectedErr           error
+			expectSaved           bool
+			expectExchangedTokens bool
 		}
 
 		now := time.Now()
@@ -673,32 +1368,39 @@ var _ = Describe("Stored Session Suite", func() {
 				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
 				err := s.refreshSession(nil, req, in.session)
 				if in.expectedErr != nil {
-					Expect(err).To(MatchError(in.expectedErr))
+					Expect(err).To(MatchError(in.expectedErr.Error()))
 				} else {
 					Expect(err).ToNot(HaveOccurred())
 				}
 				Expect(saved).To(Equal(in.expectSaved))
+				Expect(in.session.ExchangedTokens != nil).To(Equal(in.expectExchangedTokens))
 			},
 			Entry("when the provider does not refresh the session", refreshSessionWithProviderTableInput{
 				session: &sessionsapi.SessionState{
-					RefreshToken: noRefresh,
+					RefreshToken:    noRefresh,
+					ExchangedTokens: map[string]sessionsapi.ExchangedToken{"api": {AccessToken: "exchanged"}},
 				},
-				expectedErr: nil,
-				expectSaved: false,
+				expectedErr:           nil,
+				expectSaved:           false,
+				expectExchangedTokens: true,
 			}),
 			Entry("when the provider refreshes the session", refreshSessionWithProviderTableInput{
 				session: &sessionsapi.SessionState{
-					RefreshToken: refresh,
+					RefreshToken:    refresh,
+					ExchangedTokens: map[string]sessionsapi.ExchangedToken{"api": {AccessToken: "exchanged"}},
 				},
-				expectedErr: nil,
-				expectSaved: true,
+				expectedErr:           nil,
+				expectSaved:           true,
+				expectExchangedTokens: false,
 			}),
 			Entry("when the provider doesn't implement refresh", refreshSessionWithProviderTableInput{
 				session: &sessionsapi.SessionState{
-					RefreshToken: notImplemented,
+					RefreshToken:    notImplemented,
+					ExchangedTokens: map[string]sessionsapi.ExchangedToken{"api": {AccessToken: "exchanged"}},
 				},
-				expectedErr: nil,
-				expectSaved: true,
+				expectedErr:           nil,
+				expectSaved:           true,
+				expectExchangedTokens: true,
 			}),
 			Entry("when the provider returns an error", refreshSessionWithProviderTableInput{
 				session: &sessionsapi.SessionState{
@@ -722,6 +1424,7 @@ var _ = Describe("Stored Session Suite", func() {
 
 	Context("validateSession", func() {
 		var s *storedSessionLoader
+		now := time.Unix(1234567890, 0)
 
 		BeforeEach(func() {
 			s = &storedSessionLoader{
@@ -733,35 +1436,41 @@ var _ = Describe("Stored Session Suite", func() {
 
 		Context("with a valid session", func() {
 			It("does not return an error", func() {
-				expires := time.Now().Add(1 * time.Minute)
+				expires := now.Add(1 * time.Minute)
 				session := &sessionsapi.SessionState{
 					AccessToken: "Valid",
 					ExpiresOn:   &expires,
 				}
+				session.Clock.Set(now)
 				Expect(s.validateSession(ctx, session)).To(Succeed())
 			})
 		})
 
 		Context("with an expired session", func() {
 			It("returns an error", func() {
-				created := time.Now().Add(-5 * time.Minute)
-				expires := time.Now().Add(-1 * time.Minute)
+				created := now.Add(-5 * time.Minute)
+				expires := now.Add(1 * time.Minute)
 				session := &sessionsapi.SessionState{
 					AccessToken: "Valid",
 					CreatedAt:   &created,
 					ExpiresOn:   &expires,
 				}
+				session.Clock.Set(now)
+				Expect(s.validateSession(ctx, session)).To(Succeed())
+
+				Expect(session.Clock.Add(2 * time.Minute)).To(Succeed())
 				Expect(s.validateSession(ctx, session)).To(MatchError("session is expired"))
 			})
 		})
 
 		Context("with an invalid session", func() {
 			It("returns an error", func() {
-				expires := time.Now().Add(1 * time.Minute)
+				expires := now.Add(1 * time.Minute)
 				session := &sessionsapi.SessionState{
 					AccessToken: "Invalid",
 					ExpiresOn:   &expires,
 				}
+				session.Clock.Set(now)
 				Expect(s.validateSession(ctx, session)).To(MatchError("session is invalid"))
 			})
 		})
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("Tracing Suite", func() {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var recorder *tracetest.SpanRecorder
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	spanNames := func() []string {
+		names := []string{}
+		for _, span := range recorder.Ended() {
+			names = append(names, span.Name())
+		}
+		return names
+	}
+
+	It("records a server span with the status of the response", func() {
+		handler := NewTracing()(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			Expect(trace.SpanContextFromContext(req.Context()).IsValid()).To(BeTrue())
+			rw.WriteHeader(http.StatusBadGateway)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/foo", nil))
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name()).To(Equal("HTTP GET"))
+		Expect(spans[0].SpanKind()).To(Equal(trace.SpanKindServer))
+		Expect(spans[0].Parent().IsValid()).To(BeFalse())
+		Expect(spans[0].Attributes()).To(ContainElements(
+			HaveField("Key", BeEquivalentTo("http.target")),
+			HaveField("Key", BeEquivalentTo("http.status_code")),
+		))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+	})
+
+	It("continues the trace of the traceparent header", func() {
+		handler := NewTracing()(testHandler())
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set("traceparent", traceparent)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0].Parent().SpanID().String()).To(Equal("00f067aa0ba902b7"))
+		Expect(spans[0].Parent().IsRemote()).To(BeTrue())
+		Expect(spans[0].Status().Code).To(Equal(codes.Unset))
+	})
+
+	It("records the session refresh as a child of the server span", func() {
+		createdAt := time.Now().Add(-2 * time.Hour)
+		loader := &storedSessionLoader{
+			store: &fakeSessionStore{
+				LoadFunc: func(*http.Request) (*sessionsapi.SessionState, error) {
+					return &sessionsapi.SessionState{RefreshToken: "RefreshToken", CreatedAt: &createdAt}, nil
+				},
+			},
+			refreshPeriod: time.Minute,
+			sessionRefresher: func(ctx context.Context, _ *sessionsapi.SessionState) (bool, error) {
+				Expect(trace.SpanContextFromContext(ctx).IsValid()).To(BeTrue())
+				return true, nil
+			},
+			sessionValidator: func(context.Context, *sessionsapi.SessionState) bool {
+				return true
+			},
+		}
+
+		handler := NewTracing()(loader.loadSession(testHandler()))
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(spanNames()).To(Equal([]string{"session.refresh", "session.validate", "HTTP GET"}))
+		spans := recorder.Ended()
+		server := spans[2].SpanContext()
+		Expect(spans[0].Parent().SpanID()).To(Equal(server.SpanID()))
+		Expect(spans[1].Parent().SpanID()).To(Equal(server.SpanID()))
+		Expect(spans[0].SpanContext().TraceID()).To(Equal(server.TraceID()))
+	})
+})
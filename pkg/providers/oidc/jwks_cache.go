@@ -0,0 +1,327 @@
+package oidc
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	clockapi "github.com/benbjohnson/clock"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"golang.org/x/sync/singleflight"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// JWKSCacheOptions configures the cache of the JSON Web Key Sets used to
+// verify tokens
+type JWKSCacheOptions struct {
+	// MaxIssuers is the number of issuers whose keys are cached, the least
+	// recently used issuers are evicted
+	MaxIssuers int
+
+	// RefreshInterval is how often the keys are refreshed in the background
+	RefreshInterval time.Duration
+
+	// RefreshJitter is the maximum random duration taken off each refresh
+	// interval, so that refreshes are spread out
+	RefreshJitter time.Duration
+
+	// RefreshCooldown is the minimum time between refreshes forced by a token
+	// signed with an unknown key ID
+	RefreshCooldown time.Duration
+}
+
+// DefaultJWKSCacheOptions are the JWKSCacheOptions used until the cache is
+// configured
+var DefaultJWKSCacheOptions = JWKSCacheOptions{
+	MaxIssuers:      10,
+	RefreshInterval: time.Hour,
+	RefreshJitter:   5 * time.Minute,
+	RefreshCooldown: time.Minute,
+}
+
+var defaultJWKSCache = NewJWKSCache(DefaultJWKSCacheOptions)
+
+// ConfigureJWKSCache reconfigures the cache used by all ProviderVerifiers,
+// including those created before it is called
+func ConfigureJWKSCache(opts JWKSCacheOptions) {
+	getDefaultJWKSCache().Configure(opts)
+}
+
+func getDefaultJWKSCache() *JWKSCache {
+	return defaultJWKSCache
+}
+
+// JWKSCache is an LRU cache of the JSON Web Key Sets of each issuer.
+// Keys are refreshed in the background, and concurrent fetches of the same
+// issuer's keys are combined into a single request.
+type JWKSCache struct {
+	opts  JWKSCacheOptions
+	clock clock.Clock
+
+	fetches singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// jwksEntry holds the cached keys of an issuer
+type jwksEntry struct {
+	issuer  string
+	jwksURL string
+
+	keys         []jose.JSONWebKey
+	lastForced   time.Time
+	refreshTimer *clockapi.Timer
+}
+
+// NewJWKSCache creates an empty JWKSCache
+func NewJWKSCache(opts JWKSCacheOptions) *JWKSCache {
+	return &JWKSCache{
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// KeySet returns an oidc.KeySet that verifies tokens with the cached keys of
+// the issuer, fetched from the jwksURL
+func (c *JWKSCache) KeySet(issuer, jwksURL string) oidc.KeySet {
+	return &cachedKeySet{
+		cache:   c,
+		issuer:  issuer,
+		jwksURL: jwksURL,
+	}
+}
+
+// Configure changes the options of the cache. Issuers over the MaxIssuers
+// are evicted and the background refreshes are rescheduled.
+func (c *JWKSCache) Configure(opts JWKSCacheOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.opts = opts
+	c.evict()
+	for _, element := range c.entries {
+		entry := element.Value.(*jwksEntry)
+		stopRefresh(entry)
+		c.scheduleRefresh(entry, c.refreshInterval())
+	}
+}
+
+// Stop stops the background refreshes of all issuers
+func (c *JWKSCache) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, element := range c.entries {
+		stopRefresh(element.Value.(*jwksEntry))
+	}
+}
+
+// getKeys returns the cached keys of the issuer, fetching them when they are
+// not cached
+func (c *JWKSCache) getKeys(ctx context.Context, issuer, jwksURL string) ([]jose.JSONWebKey, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[issuer]; ok && element.Value.(*jwksEntry).jwksURL == jwksURL {
+		c.lru.MoveToFront(element)
+		keys := element.Value.(*jwksEntry).keys
+		c.mu.Unlock()
+		return keys, nil
+	}
+	c.mu.Unlock()
+
+	return c.refresh(ctx, issuer, jwksURL)
+}
+
+// forceRefresh fetches the keys of the issuer after a token was signed with
+// an unknown key. It returns false without fetching the keys when the last
+// forced refresh was within the cooldown.
+func (c *JWKSCache) forceRefresh(ctx context.Context, issuer, jwksURL string) ([]jose.JSONWebKey, bool, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[issuer]; ok {
+		entry := element.Value.(*jwksEntry)
+		now := c.clock.Now()
+		if !entry.lastForced.IsZero() && now.Sub(entry.lastForced) < c.opts.RefreshCooldown {
+			c.mu.Unlock()
+			return nil, false, nil
+		}
+		entry.lastForced = now
+	}
+	c.mu.Unlock()
+
+	keys, err := c.refresh(ctx, issuer, jwksURL)
+	return keys, true, err
+}
+
+// refresh fetches the keys of the issuer and stores them in the cache.
+// Concurrent refreshes of an issuer share a single request.
+func (c *JWKSCache) refresh(ctx context.Context, issuer, jwksURL string) ([]jose.JSONWebKey, error) {
+	result := c.fetches.DoChan(issuer+"\x00"+jwksURL, func() (interface{}, error) {
+		// The fetch is shared, so it must not be cancelled with the context of
+		// the request that started it
+		keys, err := fetchJWKS(context.Background(), jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		c.store(issuer, jwksURL, keys)
+		return keys, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]jose.JSONWebKey), nil
+	}
+}
+
+// store caches the keys of the issuer, evicting the least recently used
+// issuer when the cache is full, and schedules the next background refresh
+func (c *JWKSCache) store(issuer, jwksURL string, keys []jose.JSONWebKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &jwksEntry{issuer: issuer}
+	if element, ok := c.entries[issuer]; ok {
+		entry = element.Value.(*jwksEntry)
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[issuer] = c.lru.PushFront(entry)
+	}
+	entry.jwksURL = jwksURL
+	entry.keys = keys
+
+	c.evict()
+	c.scheduleRefresh(entry, c.refreshInterval())
+}
+
+// evict removes the least recently used issuers over the MaxIssuers
+func (c *JWKSCache) evict() {
+	for c.opts.MaxIssuers > 0 && c.lru.Len() > c.opts.MaxIssuers {
+		oldest := c.lru.Back()
+		evicted := oldest.Value.(*jwksEntry)
+		stopRefresh(evicted)
+		c.lru.Remove(oldest)
+		delete(c.entries, evicted.issuer)
+	}
+}
+
+// scheduleRefresh replaces any scheduled refresh of the entry.
+// Failed background refreshes keep the cached keys and are retried after
+// the cooldown.
+func (c *JWKSCache) scheduleRefresh(entry *jwksEntry, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	stopRefresh(entry)
+
+	entry.refreshTimer = c.clock.AfterFunc(interval, func() {
+		c.mu.Lock()
+		element, ok := c.entries[entry.issuer]
+		c.mu.Unlock()
+		if !ok || element.Value.(*jwksEntry) != entry {
+			// The issuer was evicted
+			return
+		}
+
+		if _, err := c.refresh(context.Background(), entry.issuer, entry.jwksURL); err != nil {
+			logger.Errorf("Error refreshing the JWKS of issuer %q: %v", entry.issuer, err)
+			c.mu.Lock()
+			c.scheduleRefresh(entry, c.opts.RefreshCooldown)
+			c.mu.Unlock()
+		}
+	})
+}
+
+// refreshInterval returns the RefreshInterval minus a random jitter
+func (c *JWKSCache) refreshInterval() time.Duration {
+	interval := c.opts.RefreshInterval
+	if c.opts.RefreshJitter > 0 && c.opts.RefreshJitter < interval {
+		/* #nosec G404 */
+		interval -= time.Duration(rand.Int63n(int64(c.opts.RefreshJitter)))
+	}
+	return interval
+}
+
+func stopRefresh(entry *jwksEntry) {
+	if entry.refreshTimer != nil {
+		entry.refreshTimer.Stop()
+		entry.refreshTimer = nil
+	}
+}
+
+// fetchJWKS requests the JSON Web Key Set from the jwksURL
+func fetchJWKS(ctx context.Context, jwksURL string) ([]jose.JSONWebKey, error) {
+	var keySet jose.JSONWebKeySet
+	err := requests.New(jwksURL).
+		WithContext(ctx).
+		Do().
+		UnmarshalInto(&keySet)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS: %v", err)
+	}
+	return keySet.Keys, nil
+}
+
+// cachedKeySet verifies tokens with the keys of an issuer in the JWKSCache
+type cachedKeySet struct {
+	cache   *JWKSCache
+	issuer  string
+	jwksURL string
+}
+
+// VerifySignature verifies the token is signed by one of the issuer's keys.
+// When the token is signed with an unknown key, the keys are refreshed at
+// most once per cooldown in case the issuer has rotated its keys.
+func (k *cachedKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %v", err)
+	}
+	keyID := ""
+	if len(jws.Signatures) > 0 {
+		keyID = jws.Signatures[0].Header.KeyID
+	}
+
+	keys, err := k.cache.getKeys(ctx, k.issuer, k.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	if payload, ok := verifyWithKeys(jws, keyID, keys); ok {
+		return payload, nil
+	}
+
+	keys, refreshed, err := k.cache.forceRefresh(ctx, k.issuer, k.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	if !refreshed {
+		return nil, errors.New("failed to verify token signature: no matching key and the keys were refreshed recently")
+	}
+	if payload, ok := verifyWithKeys(jws, keyID, keys); ok {
+		return payload, nil
+	}
+	return nil, errors.New("failed to verify token signature")
+}
+
+func verifyWithKeys(jws *jose.JSONWebSignature, keyID string, keys []jose.JSONWebKey) ([]byte, bool) {
+	for _, key := range keys {
+		if keyID != "" && key.KeyID != keyID {
+			continue
+		}
+		if payload, err := jws.Verify(&key); err == nil {
+			return payload, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,251 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// jwksServer serves the public keys of its signing keys and counts the
+// requests to each path
+type jwksServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	failing  bool
+	requests map[string]int
+	fetches  int32
+	release  chan struct{}
+}
+
+func newJWKSServer() *jwksServer {
+	s := &jwksServer{
+		keys:     map[string]*rsa.PrivateKey{},
+		requests: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		if s.release != nil {
+			<-s.release
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests[req.URL.Path]++
+		if s.failing {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		keySet := jose.JSONWebKeySet{}
+		for keyID, key := range s.keys {
+			keySet.Keys = append(keySet.Keys, jose.JSONWebKey{Key: key.Public(), KeyID: keyID, Algorithm: string(jose.RS256), Use: "sig"})
+		}
+		Expect(json.NewEncoder(rw).Encode(keySet)).To(Succeed())
+	}))
+	return s
+}
+
+func (s *jwksServer) addKey(keyID string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = key
+}
+
+func (s *jwksServer) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *jwksServer) requestCount(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// sign creates a token signed by the key, the key does not need to be
+// served by the server
+func (s *jwksServer) sign(keyID string) string {
+	s.mu.Lock()
+	key, ok := s.keys[keyID]
+	s.mu.Unlock()
+	if !ok {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: keyID},
+	}, nil)
+	Expect(err).ToNot(HaveOccurred())
+	jws, err := signer.Sign([]byte(`{"sub":"subject"}`))
+	Expect(err).ToNot(HaveOccurred())
+	token, err := jws.CompactSerialize()
+	Expect(err).ToNot(HaveOccurred())
+	return token
+}
+
+var _ = Describe("JWKS Cache", func() {
+	const issuer = "https://issuer.example.com"
+
+	var (
+		server  *jwksServer
+		cache   *JWKSCache
+		jwksURL string
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		server = newJWKSServer()
+		server.addKey("key1")
+		jwksURL = server.URL + "/jwks.json"
+		ctx = context.Background()
+
+		cache = NewJWKSCache(JWKSCacheOptions{
+			MaxIssuers:      2,
+			RefreshInterval: time.Hour,
+			RefreshCooldown: time.Minute,
+		})
+		cache.clock.Set(time.Now())
+	})
+
+	AfterEach(func() {
+		cache.Stop()
+		server.Close()
+	})
+
+	It("fetches the keys once for concurrent verifications", func() {
+		server.release = make(chan struct{})
+		keySet := cache.KeySet(issuer, jwksURL)
+		token := server.sign("key1")
+
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				_, err := keySet.VerifySignature(ctx, token)
+				errs <- err
+			}()
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&server.fetches) }).Should(BeNumerically(">=", 1))
+		close(server.release)
+		for i := 0; i < 10; i++ {
+			Expect(<-errs).ToNot(HaveOccurred())
+		}
+		Expect(server.requestCount("/jwks.json")).To(Equal(1))
+
+		payload, err := keySet.VerifySignature(ctx, token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payload).To(MatchJSON(`{"sub":"subject"}`))
+		Expect(server.requestCount("/jwks.json")).To(Equal(1))
+	})
+
+	It("refreshes the keys once per cooldown for unknown key IDs", func() {
+		keySet := cache.KeySet(issuer, jwksURL)
+		_, err := keySet.VerifySignature(ctx, server.sign("key1"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = keySet.VerifySignature(ctx, server.sign("unknown"))
+		Expect(err).To(MatchError("failed to verify token signature"))
+		Expect(server.requestCount("/jwks.json")).To(Equal(2))
+
+		_, err = keySet.VerifySignature(ctx, server.sign("unknown"))
+		Expect(err).To(MatchError("failed to verify token signature: no matching key and the keys were refreshed recently"))
+		Expect(server.requestCount("/jwks.json")).To(Equal(2))
+
+		Expect(cache.clock.Add(time.Minute)).To(Succeed())
+		server.addKey("key2")
+		_, err = keySet.VerifySignature(ctx, server.sign("key2"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.requestCount("/jwks.json")).To(Equal(3))
+	})
+
+	It("refreshes the keys in the background", func() {
+		keySet := cache.KeySet(issuer, jwksURL)
+		_, err := keySet.VerifySignature(ctx, server.sign("key1"))
+		Expect(err).ToNot(HaveOccurred())
+
+		server.addKey("key2")
+		Expect(cache.clock.Add(time.Hour)).To(Succeed())
+		Expect(server.requestCount("/jwks.json")).To(Equal(2))
+
+		_, err = keySet.VerifySignature(ctx, server.sign("key2"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.requestCount("/jwks.json")).To(Equal(2))
+	})
+
+	It("keeps the keys and retries after the cooldown when a background refresh fails", func() {
+		keySet := cache.KeySet(issuer, jwksURL)
+		token := server.sign("key1")
+		_, err := keySet.VerifySignature(ctx, token)
+		Expect(err).ToNot(HaveOccurred())
+
+		server.setFailing(true)
+		Expect(cache.clock.Add(time.Hour)).To(Succeed())
+		Expect(server.requestCount("/jwks.json")).To(Equal(2))
+
+		_, err = keySet.VerifySignature(ctx, token)
+		Expect(err).ToNot(HaveOccurred())
+
+		server.setFailing(false)
+		Expect(cache.clock.Add(time.Minute)).To(Succeed())
+		Expect(server.requestCount("/jwks.json")).To(Equal(3))
+	})
+
+	It("evicts the least recently used issuer", func() {
+		token := server.sign("key1")
+		for _, path := range []string{"/a", "/b", "/a", "/c", "/a", "/b"} {
+			_, err := cache.KeySet(path, server.URL+path).VerifySignature(ctx, token)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(server.requestCount("/a")).To(Equal(1))
+		Expect(server.requestCount("/b")).To(Equal(2))
+		Expect(server.requestCount("/c")).To(Equal(1))
+		Expect(cache.lru.Len()).To(Equal(2))
+	})
+
+	It("evicts issuers and reschedules refreshes when it is reconfigured", func() {
+		token := server.sign("key1")
+		for _, path := range []string{"/a", "/b"} {
+			_, err := cache.KeySet(path, server.URL+path).VerifySignature(ctx, token)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		cache.Configure(JWKSCacheOptions{
+			MaxIssuers:      1,
+			RefreshInterval: 10 * time.Minute,
+			RefreshCooldown: time.Minute,
+		})
+		Expect(cache.lru.Len()).To(Equal(1))
+
+		Expect(cache.clock.Add(10 * time.Minute)).To(Succeed())
+		Expect(server.requestCount("/a")).To(Equal(1))
+		Expect(server.requestCount("/b")).To(Equal(2))
+	})
+
+	It("jitters the refresh interval", func() {
+		cache.opts.RefreshJitter = 5 * time.Minute
+		for i := 0; i < 20; i++ {
+			interval := cache.refreshInterval()
+			Expect(interval).To(BeNumerically(">", 55*time.Minute))
+			Expect(interval).To(BeNumerically("<=", time.Hour))
+		}
+	})
+})
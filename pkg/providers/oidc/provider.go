@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
 )
@@ -16,6 +18,9 @@ type providerJSON struct {
 	TokenURL             string   `json:"token_endpoint"`
 	JWKsURL              string   `json:"jwks_uri"`
 	UserInfoURL          string   `json:"userinfo_endpoint"`
+	DeviceAuthURL        string   `json:"device_authorization_endpoint"`
+	RevocationURL        string   `json:"revocation_endpoint"`
+	EndSessionURL        string   `json:"end_session_endpoint"`
 	CodeChallengeAlgs    []string `json:"code_challenge_methods_supported"`
 	SupportedSigningAlgs []string `json:"id_token_signing_alg_values_supported"`
 }
@@ -23,10 +28,13 @@ type providerJSON struct {
 // Endpoints represents the endpoints discovered as part of the OIDC discovery process
 // that will be used by the authentication providers.
 type Endpoints struct {
-	AuthURL     string
-	TokenURL    string
-	JWKsURL     string
-	UserInfoURL string
+	AuthURL       string
+	TokenURL      string
+	JWKsURL       string
+	UserInfoURL   string
+	DeviceAuthURL string
+	RevocationURL string
+	EndSessionURL string
 }
 
 // PKCE holds information relevant to the PKCE (code challenge) support of the
@@ -43,21 +51,42 @@ type DiscoveryProvider interface {
 	SupportedSigningAlgs() []string
 }
 
+const (
+	// defaultDiscoveryRetryBackoff is the delay before the first retry of the
+	// discovery when no backoff is configured
+	defaultDiscoveryRetryBackoff = time.Second
+	// maxDiscoveryRetryBackoff caps the doubling delay between retries
+	maxDiscoveryRetryBackoff = 30 * time.Second
+)
+
+// DiscoveryRetry configures retrying the OIDC discovery while the identity
+// provider cannot be reached, eg while it restarts together with the proxy.
+type DiscoveryRetry struct {
+	// Timeout is the total time the discovery is retried for.
+	// The discovery is not retried when it is 0.
+	Timeout time.Duration
+
+	// Backoff is the delay before the first retry, it is doubled for each
+	// further retry up to 30s. Defaults to 1s.
+	Backoff time.Duration
+
+	clock clock.Clock
+}
+
 // NewProvider allows a user to perform an OIDC discovery and returns the DiscoveryProvider.
 // We implement this here as opposed to using oidc.Provider so that we can override the Issuer verification check.
 // As we have our own verifier and fetch the userinfo separately, the rest of the oidc.Provider implementation is not
 // useful to us.
-func NewProvider(ctx context.Context, issuerURL string, skipIssuerVerification bool) (DiscoveryProvider, error) {
+func NewProvider(ctx context.Context, issuerURL string, skipIssuerVerification bool, retry DiscoveryRetry) (DiscoveryProvider, error) {
 	// go-oidc doesn't let us pass bypass the issuer check this in the oidc.NewProvider call
 	// (which uses discovery to get the URLs), so we'll do a quick check ourselves and if
 	// we get the URLs, we'll just use the non-discovery path.
 
 	logger.Printf("Performing OIDC Discovery...")
 
-	var p providerJSON
-	requestURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
-	if err := requests.New(requestURL).WithContext(ctx).Do().UnmarshalInto(&p); err != nil {
-		return nil, fmt.Errorf("failed to discover OIDC configuration: %v", err)
+	p, err := retry.fetchDiscoveryDocument(ctx, issuerURL)
+	if err != nil {
+		return nil, err
 	}
 
 	if !skipIssuerVerification && p.Issuer != issuerURL {
@@ -69,17 +98,91 @@ func NewProvider(ctx context.Context, issuerURL string, skipIssuerVerification b
 		tokenURL:             p.TokenURL,
 		jwksURL:              p.JWKsURL,
 		userInfoURL:          p.UserInfoURL,
+		deviceAuthURL:        p.DeviceAuthURL,
+		revocationURL:        p.RevocationURL,
+		endSessionURL:        p.EndSessionURL,
 		codeChallengeAlgs:    p.CodeChallengeAlgs,
 		supportedSigningAlgs: p.SupportedSigningAlgs,
 	}, nil
 }
 
+// fetchDiscoveryDocument retrieves the OIDC discovery document for the issuer,
+// retrying until it succeeds or the timeout is exceeded.
+// Errors in the document itself, such as a mismatched issuer, are not retried.
+func (r *DiscoveryRetry) fetchDiscoveryDocument(ctx context.Context, issuerURL string) (providerJSON, error) {
+	p, err := fetchDiscoveryDocument(ctx, issuerURL)
+	if err == nil || r.Timeout <= 0 {
+		return p, err
+	}
+
+	deadline := r.clock.Now().Add(r.Timeout)
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = defaultDiscoveryRetryBackoff
+	}
+	for {
+		remaining := deadline.Sub(r.clock.Now())
+		if remaining <= 0 {
+			return providerJSON{}, fmt.Errorf("giving up after retrying for %s: %v", r.Timeout, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+
+		logger.Errorf("OIDC discovery for issuer %q failed, retrying in %s: %v", issuerURL, backoff, err)
+		if _, ok := <-r.clock.AfterContext(ctx, backoff); !ok {
+			return providerJSON{}, err
+		}
+
+		p, err = fetchDiscoveryDocument(ctx, issuerURL)
+		if err == nil {
+			logger.Printf("OIDC discovery for issuer %q succeeded after retrying", issuerURL)
+			return p, nil
+		}
+		if backoff *= 2; backoff > maxDiscoveryRetryBackoff {
+			backoff = maxDiscoveryRetryBackoff
+		}
+	}
+}
+
+// fetchDiscoveryDocument retrieves the OIDC discovery document for the issuer.
+func fetchDiscoveryDocument(ctx context.Context, issuerURL string) (providerJSON, error) {
+	var p providerJSON
+	requestURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+	if err := requests.New(requestURL).WithContext(ctx).Do().UnmarshalInto(&p); err != nil {
+		return providerJSON{}, fmt.Errorf("failed to discover OIDC configuration: %v", err)
+	}
+	return p, nil
+}
+
+// DiscoveryCheck verifies that the OIDC discovery document can be fetched
+// from the identity provider.
+type DiscoveryCheck struct {
+	IssuerURL string
+}
+
+// VerifyConnection fetches the discovery document and checks that it
+// contains a token endpoint.
+func (c *DiscoveryCheck) VerifyConnection(ctx context.Context) error {
+	p, err := fetchDiscoveryDocument(ctx, c.IssuerURL)
+	if err != nil {
+		return err
+	}
+	if p.TokenURL == "" {
+		return fmt.Errorf("discovery document for %q is missing a token endpoint", c.IssuerURL)
+	}
+	return nil
+}
+
 // discoveryProvider holds the discovered endpoints
 type discoveryProvider struct {
 	authURL              string
 	tokenURL             string
 	jwksURL              string
 	userInfoURL          string
+	deviceAuthURL        string
+	revocationURL        string
+	endSessionURL        string
 	codeChallengeAlgs    []string
 	supportedSigningAlgs []string
 }
@@ -87,10 +190,13 @@ type discoveryProvider struct {
 // Endpoints returns the discovered endpoints needed for an authentication provider.
 func (p *discoveryProvider) Endpoints() Endpoints {
 	return Endpoints{
-		AuthURL:     p.authURL,
-		TokenURL:    p.tokenURL,
-		JWKsURL:     p.jwksURL,
-		UserInfoURL: p.userInfoURL,
+		AuthURL:       p.authURL,
+		TokenURL:      p.tokenURL,
+		JWKsURL:       p.jwksURL,
+		UserInfoURL:   p.userInfoURL,
+		DeviceAuthURL: p.deviceAuthURL,
+		RevocationURL: p.revocationURL,
+		EndSessionURL: p.endSessionURL,
 	}
 }
 
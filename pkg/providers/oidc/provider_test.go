@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/oauth2-proxy/mockoidc"
 	. "github.com/onsi/ginkgo"
@@ -38,7 +40,7 @@ var _ = Describe("Provider", func() {
 			Expect(m.Shutdown()).To(Succeed())
 		}()
 
-		provider, err := NewProvider(context.Background(), m.Issuer(), in.skipIssuerVerification)
+		provider, err := NewProvider(context.Background(), m.Issuer(), in.skipIssuerVerification, DiscoveryRetry{})
 		if in.expectedError != "" {
 			Expect(err).To(MatchError(HavePrefix(in.expectedError)))
 			return
@@ -98,7 +100,7 @@ var _ = Describe("Provider", func() {
 			Expect(m.Shutdown()).To(Succeed())
 		}()
 
-		provider, err := NewProvider(context.Background(), m.Issuer(), false)
+		provider, err := NewProvider(context.Background(), m.Issuer(), false, DiscoveryRetry{})
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(provider.PKCE().CodeChallengeAlgs).To(ConsistOf("S256", "plain"))
@@ -117,11 +119,137 @@ var _ = Describe("Provider", func() {
 			Expect(m.Shutdown()).To(Succeed())
 		}()
 
-		provider, err := NewProvider(context.Background(), m.Issuer(), false)
+		provider, err := NewProvider(context.Background(), m.Issuer(), false, DiscoveryRetry{})
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(provider.SupportedSigningAlgs()).To(ConsistOf("RS256", "HS256"))
 	})
+
+	Context("DiscoveryCheck", func() {
+		var m *mockoidc.MockOIDC
+
+		BeforeEach(func() {
+			var err error
+			m, err = mockoidc.NewServer(nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		start := func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.Start(ln, nil)).To(Succeed())
+		}
+
+		AfterEach(func() {
+			Expect(m.Shutdown()).To(Succeed())
+		})
+
+		It("succeeds when the discovery document can be fetched", func() {
+			start()
+
+			check := &DiscoveryCheck{IssuerURL: m.Issuer()}
+			Expect(check.VerifyConnection(context.Background())).To(Succeed())
+		})
+
+		It("fails when the discovery document cannot be fetched", func() {
+			m.AddMiddleware(newBadRequestMiddleware())
+			start()
+
+			check := &DiscoveryCheck{IssuerURL: m.Issuer()}
+			Expect(check.VerifyConnection(context.Background())).To(MatchError(HavePrefix("failed to discover OIDC configuration: unexpected status \"400\"")))
+		})
+	})
+
+	Context("with discovery retry", func() {
+		var m *mockoidc.MockOIDC
+		var requests int32
+		var retry DiscoveryRetry
+
+		BeforeEach(func() {
+			var err error
+			m, err = mockoidc.NewServer(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			atomic.StoreInt32(&requests, 0)
+			retry = DiscoveryRetry{Timeout: time.Minute, Backoff: time.Second}
+			retry.clock.Set(time.Now())
+		})
+
+		start := func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.Start(ln, nil)).To(Succeed())
+		}
+
+		AfterEach(func() {
+			Expect(m.Shutdown()).To(Succeed())
+		})
+
+		// discover performs the discovery while moving the mocked clock
+		// forward, so that the backoff between the retries elapses
+		discover := func() (DiscoveryProvider, error) {
+			type result struct {
+				provider DiscoveryProvider
+				err      error
+			}
+			done := make(chan result, 1)
+			go func() {
+				defer GinkgoRecover()
+				provider, err := NewProvider(context.Background(), m.Issuer(), false, retry)
+				done <- result{provider, err}
+			}()
+
+			var r result
+			Eventually(func() bool {
+				select {
+				case r = <-done:
+					return true
+				default:
+					Expect(retry.clock.Add(time.Second)).To(Succeed())
+					return false
+				}
+			}, 5*time.Second, 5*time.Millisecond).Should(BeTrue())
+			return r.provider, r.err
+		}
+
+		It("retries until the issuer is available", func() {
+			m.AddMiddleware(newUnavailableMiddleware(&requests, 2))
+			start()
+
+			provider, err := discover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.Endpoints().TokenURL).To(Equal(m.TokenEndpoint()))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(3))
+		})
+
+		It("fails after the timeout is exceeded", func() {
+			retry.Timeout = 10 * time.Second
+			m.AddMiddleware(newUnavailableMiddleware(&requests, -1))
+			start()
+
+			_, err := discover()
+			Expect(err).To(MatchError(HavePrefix("giving up after retrying for 10s: failed to discover OIDC configuration: unexpected status \"503\"")))
+			Expect(atomic.LoadInt32(&requests)).To(BeNumerically(">", 1))
+		})
+
+		It("does not retry an invalid issuer", func() {
+			m.AddMiddleware(newInvalidIssuerMiddleware(m))
+			start()
+
+			_, err := NewProvider(context.Background(), m.Issuer(), false, retry)
+			Expect(err).To(MatchError(HavePrefix("oidc: issuer did not match the issuer returned by provider")))
+		})
+
+		It("does not retry without a timeout", func() {
+			retry.Timeout = 0
+			m.AddMiddleware(newUnavailableMiddleware(&requests, 2))
+			start()
+
+			_, err := NewProvider(context.Background(), m.Issuer(), false, retry)
+			Expect(err).To(MatchError(HavePrefix("failed to discover OIDC configuration: unexpected status \"503\"")))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+		})
+	})
 })
 
 func newInvalidIssuerMiddleware(m *mockoidc.MockOIDC) func(http.Handler) http.Handler {
@@ -190,3 +318,17 @@ func newBadRequestMiddleware() func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// newUnavailableMiddleware responds 503 to the first requests, or to all
+// requests when failures is negative, and counts the requests
+func newUnavailableMiddleware(requests *int32, failures int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if n := atomic.AddInt32(requests, 1); failures < 0 || n <= failures {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	k8serrors "k8s.io/apimachinery/pkg/util/errors"
@@ -33,6 +34,10 @@ type ProviderVerifierOptions struct {
 	// eg: https://accounts.google.com
 	IssuerURL string
 
+	// ExtraIssuerURLs are additional OpenID Connect issuer URLs whose tokens
+	// are accepted. Their keys are always discovered from the issuer.
+	ExtraIssuerURLs []string
+
 	// JWKsURL is the OpenID Connect JWKS URL
 	// eg: https://www.googleapis.com/oauth2/v3/certs
 	JWKsURL string
@@ -40,6 +45,10 @@ type ProviderVerifierOptions struct {
 	// SkipDiscovery allows to skip OIDC discovery and use manually supplied Endpoints
 	SkipDiscovery bool
 
+	// DiscoveryRetry configures retrying the discovery of the issuers while
+	// they cannot be reached
+	DiscoveryRetry DiscoveryRetry
+
 	// SkipIssuerVerification skips verification of ID token issuers.
 	// When false, ID Token Issuers must match the OIDC discovery URL.
 	SkipIssuerVerification bool
@@ -47,6 +56,10 @@ type ProviderVerifierOptions struct {
 	// SupportedSigningAlgs is the list of signature algorithms supported by the
 	// provider.
 	SupportedSigningAlgs []string
+
+	// JWTLeeway is the clock skew with the issuer tolerated when checking the
+	// exp, iat and nbf claims of ID tokens.
+	JWTLeeway time.Duration
 }
 
 // validate checks that the required options are present before attempting to create
@@ -62,6 +75,23 @@ func (p ProviderVerifierOptions) validate() error {
 		errs = append(errs, errors.New("missing required setting: jwks-url"))
 	}
 
+	if len(p.ExtraIssuerURLs) > 0 && p.SkipIssuerVerification {
+		// The issuer claim selects the keys, so it must be verified
+		errs = append(errs, errors.New("extra issuer URLs cannot be used when skipping issuer verification"))
+	}
+
+	seen := map[string]bool{p.IssuerURL: true}
+	for _, issuerURL := range p.ExtraIssuerURLs {
+		if issuerURL == "" {
+			errs = append(errs, errors.New("extra issuer URLs must not be empty"))
+			continue
+		}
+		if seen[issuerURL] {
+			errs = append(errs, fmt.Errorf("duplicate issuer URL %q", issuerURL))
+		}
+		seen[issuerURL] = true
+	}
+
 	if len(errs) > 0 {
 		return k8serrors.NewAggregate(errs)
 	}
@@ -70,19 +100,24 @@ func (p ProviderVerifierOptions) validate() error {
 
 // toVerificationOptions returns an IDTokenVerificationOptions based on the configured options.
 func (p ProviderVerifierOptions) toVerificationOptions() IDTokenVerificationOptions {
+	leeway := p.JWTLeeway
 	return IDTokenVerificationOptions{
 		AudienceClaims: p.AudienceClaims,
 		ClientID:       p.ClientID,
 		ExtraAudiences: p.ExtraAudiences,
+		Leeway:         &leeway,
 	}
 }
 
 // toOIDCConfig returns an oidc.Config based on the configured options.
+// The expiry check is skipped as the ID token verifier checks the times of
+// the token with the leeway.
 func (p ProviderVerifierOptions) toOIDCConfig() *oidc.Config {
 	return &oidc.Config{
 		ClientID:             p.ClientID,
 		SkipIssuerCheck:      p.SkipIssuerVerification,
 		SkipClientIDCheck:    true,
+		SkipExpiryCheck:      true,
 		SupportedSigningAlgs: p.SupportedSigningAlgs,
 	}
 }
@@ -99,6 +134,13 @@ func NewProviderVerifier(ctx context.Context, opts ProviderVerifierOptions) (Pro
 	}
 	verifier := NewVerifier(verifierBuilder(opts.toOIDCConfig()), opts.toVerificationOptions())
 
+	if len(opts.ExtraIssuerURLs) > 0 {
+		verifier, err = newExtraIssuersVerifier(ctx, opts, verifier)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if provider == nil {
 		// To avoid the possibility of nil pointers, always return an empty provider if discovery didn't occur.
 		// Users are expected to check whether discovery was enabled before using the provider.
@@ -112,25 +154,41 @@ func NewProviderVerifier(ctx context.Context, opts ProviderVerifierOptions) (Pro
 	}, nil
 }
 
+// newExtraIssuersVerifier returns a verifier that verifies each token with
+// the verifier of the issuer in its iss claim
+func newExtraIssuersVerifier(ctx context.Context, opts ProviderVerifierOptions, verifier IDTokenVerifier) (IDTokenVerifier, error) {
+	verifiers := map[string]IDTokenVerifier{opts.IssuerURL: verifier}
+	for _, issuerURL := range opts.ExtraIssuerURLs {
+		provider, err := NewProvider(ctx, issuerURL, false, opts.DiscoveryRetry)
+		if err != nil {
+			return nil, fmt.Errorf("error while discovery OIDC configuration of issuer %q: %v", issuerURL, err)
+		}
+		verifierBuilder := newVerifierBuilder(issuerURL, provider.Endpoints().JWKsURL, provider.SupportedSigningAlgs())
+		verifiers[issuerURL] = NewVerifier(verifierBuilder(opts.toOIDCConfig()), opts.toVerificationOptions())
+	}
+	return newMultiIssuerVerifier(verifiers), nil
+}
+
 type verifierBuilder func(*oidc.Config) *oidc.IDTokenVerifier
 
 func getVerifierBuilder(ctx context.Context, opts ProviderVerifierOptions) (verifierBuilder, DiscoveryProvider, error) {
 	if opts.SkipDiscovery {
 		// Instead of discovering the JWKs URK, it needs to be specified in the opts already
-		return newVerifierBuilder(ctx, opts.IssuerURL, opts.JWKsURL, opts.SupportedSigningAlgs), nil, nil
+		return newVerifierBuilder(opts.IssuerURL, opts.JWKsURL, opts.SupportedSigningAlgs), nil, nil
 	}
 
-	provider, err := NewProvider(ctx, opts.IssuerURL, opts.SkipIssuerVerification)
+	provider, err := NewProvider(ctx, opts.IssuerURL, opts.SkipIssuerVerification, opts.DiscoveryRetry)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error while discovery OIDC configuration: %v", err)
 	}
-	verifierBuilder := newVerifierBuilder(ctx, opts.IssuerURL, provider.Endpoints().JWKsURL, provider.SupportedSigningAlgs())
+	verifierBuilder := newVerifierBuilder(opts.IssuerURL, provider.Endpoints().JWKsURL, provider.SupportedSigningAlgs())
 	return verifierBuilder, provider, nil
 }
 
 // newVerifierBuilder returns a function to create a IDToken verifier from an OIDC config.
-func newVerifierBuilder(ctx context.Context, issuerURL, jwksURL string, supportedSigningAlgs []string) verifierBuilder {
-	keySet := oidc.NewRemoteKeySet(ctx, jwksURL)
+// The keys are shared with other verifiers of the issuer through the JWKS cache.
+func newVerifierBuilder(issuerURL, jwksURL string, supportedSigningAlgs []string) verifierBuilder {
+	keySet := getDefaultJWKSCache().KeySet(issuerURL, jwksURL)
 	return func(oidcConfig *oidc.Config) *oidc.IDTokenVerifier {
 		if len(supportedSigningAlgs) > 0 {
 			oidcConfig.SupportedSigningAlgs = supportedSigningAlgs
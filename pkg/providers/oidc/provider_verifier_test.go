@@ -2,10 +2,18 @@ package oidc
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/oauth2-proxy/mockoidc"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -170,4 +178,255 @@ var _ = Describe("ProviderVerifier", func() {
 			expectedError: "failed to verify token: oidc: token is expired",
 		}),
 	)
+
+	type jwtLeewayTableInput struct {
+		leeway        time.Duration
+		modifyClaims  func(now time.Time, j *jwt.StandardClaims)
+		expectedError string
+	}
+
+	DescribeTable("when verifying the times of tokens with a JWT leeway", func(in *jwtLeewayTableInput) {
+		pv, err := NewProviderVerifier(context.Background(), ProviderVerifierOptions{
+			AudienceClaims: []string{"aud"},
+			ClientID:       m.Config().ClientID,
+			IssuerURL:      m.Issuer(),
+			JWTLeeway:      in.leeway,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		now := time.Now().Truncate(time.Second)
+		clock.Set(now)
+		defer clock.Reset()
+
+		claims := jwt.StandardClaims{
+			Audience:  m.Config().ClientID,
+			Issuer:    m.Issuer(),
+			ExpiresAt: now.Add(1 * time.Hour).Unix(),
+			IssuedAt:  now.Unix(),
+			Subject:   "user",
+		}
+		in.modifyClaims(now, &claims)
+
+		rawIDToken, err := m.Keypair.SignJWT(claims)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = pv.Verifier().Verify(context.Background(), rawIDToken)
+		if in.expectedError != "" {
+			Expect(err).To(MatchError(HavePrefix(in.expectedError)))
+			return
+		}
+		Expect(err).ToNot(HaveOccurred())
+	},
+		Entry("when the token expired within the leeway", &jwtLeewayTableInput{
+			leeway: 10 * time.Second,
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.ExpiresAt = now.Add(-5 * time.Second).Unix()
+			},
+		}),
+		Entry("when the token expired before the leeway", &jwtLeewayTableInput{
+			leeway: 10 * time.Second,
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.ExpiresAt = now.Add(-15 * time.Second).Unix()
+			},
+			expectedError: "failed to verify token: oidc: token is expired",
+		}),
+		Entry("when the token was issued in the future within the leeway", &jwtLeewayTableInput{
+			leeway: 10 * time.Second,
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.IssuedAt = now.Add(5 * time.Second).Unix()
+			},
+		}),
+		Entry("when the token was issued in the future after the leeway", &jwtLeewayTableInput{
+			leeway: 10 * time.Second,
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.IssuedAt = now.Add(15 * time.Second).Unix()
+			},
+			expectedError: "failed to verify token: oidc: current time",
+		}),
+		Entry("when the token is not valid before a time within the leeway", &jwtLeewayTableInput{
+			leeway: 10 * time.Second,
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.NotBefore = now.Add(5 * time.Second).Unix()
+			},
+		}),
+		Entry("when the token is not valid before a time after the leeway", &jwtLeewayTableInput{
+			leeway: 10 * time.Second,
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.NotBefore = now.Add(15 * time.Second).Unix()
+			},
+			expectedError: "failed to verify token: oidc: current time",
+		}),
+		Entry("when the token has just expired without a leeway", &jwtLeewayTableInput{
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.ExpiresAt = now.Add(-1 * time.Second).Unix()
+			},
+			expectedError: "failed to verify token: oidc: token is expired",
+		}),
+		Entry("when the token is not valid yet without a leeway", &jwtLeewayTableInput{
+			modifyClaims: func(now time.Time, j *jwt.StandardClaims) {
+				j.NotBefore = now.Add(1 * time.Second).Unix()
+			},
+			expectedError: "failed to verify token: oidc: current time",
+		}),
+	)
+
+	Context("with extra issuers", func() {
+		var newIssuer *mockoidc.MockOIDC
+
+		BeforeEach(func() {
+			keypair, err := mockoidc.RandomKeypair(2048)
+			Expect(err).ToNot(HaveOccurred())
+			newIssuer, err = mockoidc.NewServer(keypair.PrivateKey)
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(newIssuer.Start(ln, nil)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(newIssuer.Shutdown()).To(Succeed())
+		})
+
+		type extraIssuersTableInput struct {
+			newSigner     bool
+			issuer        func() string
+			audience      string
+			expectedError string
+		}
+
+		DescribeTable("verifying tokens", func(in *extraIssuersTableInput) {
+			pv, err := NewProviderVerifier(context.Background(), ProviderVerifierOptions{
+				AudienceClaims:  []string{"aud"},
+				ClientID:        "client",
+				ExtraAudiences:  []string{"new-client"},
+				IssuerURL:       m.Issuer(),
+				ExtraIssuerURLs: []string{newIssuer.Issuer()},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			signer := m
+			if in.newSigner {
+				signer = newIssuer
+			}
+			now := time.Now()
+			rawIDToken, err := signer.Keypair.SignJWT(jwt.StandardClaims{
+				Audience:  in.audience,
+				Issuer:    in.issuer(),
+				ExpiresAt: now.Add(1 * time.Hour).Unix(),
+				IssuedAt:  now.Unix(),
+				Subject:   "user",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			idToken, err := pv.Verifier().Verify(context.Background(), rawIDToken)
+			if in.expectedError != "" {
+				Expect(err).To(MatchError(HavePrefix(in.expectedError)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(idToken.Issuer).To(Equal(in.issuer()))
+		},
+			Entry("with a token from the issuer", &extraIssuersTableInput{
+				issuer:   func() string { return m.Issuer() },
+				audience: "client",
+			}),
+			Entry("with a token from the extra issuer", &extraIssuersTableInput{
+				newSigner: true,
+				issuer:    func() string { return newIssuer.Issuer() },
+				audience:  "new-client",
+			}),
+			Entry("with a token from an unknown issuer", &extraIssuersTableInput{
+				issuer:        func() string { return "https://other.example.com" },
+				audience:      "client",
+				expectedError: "failed to verify token: issuer \"https://other.example.com\" is not an accepted issuer",
+			}),
+			Entry("with a token claiming the extra issuer signed by the issuer", &extraIssuersTableInput{
+				issuer:        func() string { return newIssuer.Issuer() },
+				audience:      "client",
+				expectedError: "failed to verify token: failed to verify signature",
+			}),
+			Entry("with a token from the extra issuer with a partial audience", &extraIssuersTableInput{
+				newSigner:     true,
+				issuer:        func() string { return newIssuer.Issuer() },
+				audience:      "new-client-2",
+				expectedError: "audience from claim aud with value [new-client-2] does not match with any of allowed audiences",
+			}),
+		)
+
+		It("rejects extra issuers when skipping issuer verification", func() {
+			_, err := NewProviderVerifier(context.Background(), ProviderVerifierOptions{
+				ClientID:               "client",
+				IssuerURL:              m.Issuer(),
+				ExtraIssuerURLs:        []string{newIssuer.Issuer()},
+				SkipIssuerVerification: true,
+			})
+			Expect(err).To(MatchError("invalid provider verifier options: extra issuer URLs cannot be used when skipping issuer verification"))
+		})
+
+		It("rejects duplicate issuers", func() {
+			_, err := NewProviderVerifier(context.Background(), ProviderVerifierOptions{
+				ClientID:        "client",
+				IssuerURL:       m.Issuer(),
+				ExtraIssuerURLs: []string{m.Issuer()},
+			})
+			Expect(err).To(MatchError(fmt.Sprintf("invalid provider verifier options: duplicate issuer URL %q", m.Issuer())))
+		})
+	})
+
+	Context("when the provider uses a private CA", func() {
+		var tlsProvider *mockoidc.MockOIDC
+		var defaultClient *http.Client
+
+		BeforeEach(func() {
+			certBytes, keyBytes, err := util.GenerateCert("127.0.0.1")
+			Expect(err).ToNot(HaveOccurred())
+			certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			Expect(err).ToNot(HaveOccurred())
+
+			tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+			tlsProvider, err = mockoidc.NewServer(nil)
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tlsProvider.Start(tls.NewListener(ln, tlsConfig), tlsConfig)).To(Succeed())
+
+			// The provider client is configured by replacing the default client
+			pool := x509.NewCertPool()
+			Expect(pool.AppendCertsFromPEM(certPEM)).To(BeTrue())
+			defaultClient = http.DefaultClient
+			http.DefaultClient = &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			}}
+		})
+
+		AfterEach(func() {
+			http.DefaultClient = defaultClient
+			Expect(tlsProvider.Shutdown()).To(Succeed())
+		})
+
+		It("uses the provider client for discovery and fetching the JWKs", func() {
+			pv, err := NewProviderVerifier(context.Background(), ProviderVerifierOptions{
+				AudienceClaims: []string{"aud"},
+				ClientID:       tlsProvider.Config().ClientID,
+				IssuerURL:      tlsProvider.Issuer(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			now := time.Now()
+			rawIDToken, err := tlsProvider.Keypair.SignJWT(jwt.StandardClaims{
+				Audience:  tlsProvider.Config().ClientID,
+				Issuer:    tlsProvider.Issuer(),
+				ExpiresAt: now.Add(1 * time.Hour).Unix(),
+				IssuedAt:  now.Unix(),
+				Subject:   "user",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			idToken, err := pv.Verifier().Verify(context.Background(), rawIDToken)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(idToken.Subject).To(Equal("user"))
+		})
+	})
 })
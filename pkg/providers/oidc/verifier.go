@@ -2,10 +2,16 @@ package oidc
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
 )
 
 // idTokenVerifier allows an ID Token to be verified against the issue and provided keys.
@@ -18,6 +24,7 @@ type idTokenVerifier struct {
 	verifier            *oidc.IDTokenVerifier
 	verificationOptions IDTokenVerificationOptions
 	allowedAudiences    map[string]struct{}
+	clock               clock.Clock
 }
 
 // IDTokenVerificationOptions options for the oidc.idTokenVerifier that are required to verify an ID Token
@@ -25,6 +32,11 @@ type IDTokenVerificationOptions struct {
 	AudienceClaims []string
 	ClientID       string
 	ExtraAudiences []string
+
+	// Leeway is the clock skew with the issuer tolerated when checking the
+	// exp, iat and nbf claims. When nil, the claims are left to the expiry
+	// check of the oidc.IDTokenVerifier.
+	Leeway *time.Duration
 }
 
 // NewVerifier constructs a new idTokenVerifier
@@ -53,6 +65,12 @@ func (v *idTokenVerifier) Verify(ctx context.Context, rawIDToken string) (*oidc.
 		return nil, fmt.Errorf("failed to parse default id_token claims: %v", err)
 	}
 
+	if v.verificationOptions.Leeway != nil {
+		if err := v.verifyTimes(token, claims, *v.verificationOptions.Leeway); err != nil {
+			return nil, fmt.Errorf("failed to verify token: %v", err)
+		}
+	}
+
 	if isValidAudience, err := v.verifyAudience(token, claims); !isValidAudience {
 		return nil, err
 	}
@@ -60,6 +78,27 @@ func (v *idTokenVerifier) Verify(ctx context.Context, rawIDToken string) (*oidc.
 	return token, err
 }
 
+// verifyTimes checks that the token has not expired and that it was not
+// issued or is not valid only in the future, allowing for the clock skew of
+// the leeway
+func (v *idTokenVerifier) verifyTimes(token *oidc.IDToken, claims map[string]interface{}, leeway time.Duration) error {
+	now := v.clock.Now()
+
+	if now.Add(-leeway).After(token.Expiry) {
+		return &oidc.TokenExpiredError{Expiry: token.Expiry}
+	}
+	if now.Add(leeway).Before(token.IssuedAt) {
+		return fmt.Errorf("oidc: current time %v before the iat (issued at) time: %v", now, token.IssuedAt)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		notBefore := time.Unix(int64(nbf), 0)
+		if now.Add(leeway).Before(notBefore) {
+			return fmt.Errorf("oidc: current time %v before the nbf (not before) time: %v", now, notBefore)
+		}
+	}
+	return nil
+}
+
 func (v *idTokenVerifier) verifyAudience(token *oidc.IDToken, claims map[string]interface{}) (bool, error) {
 	for _, audienceClaim := range v.verificationOptions.AudienceClaims {
 		if audienceClaimValue, audienceClaimExists := claims[audienceClaim]; audienceClaimExists {
@@ -107,3 +146,49 @@ func (v *idTokenVerifier) interfaceSliceToString(slice interface{}) []string {
 	}
 	return strings
 }
+
+// multiIssuerVerifier verifies ID Tokens from several issuers
+type multiIssuerVerifier struct {
+	verifiers map[string]IDTokenVerifier
+}
+
+// newMultiIssuerVerifier constructs a verifier that verifies each ID Token
+// with the verifier of the issuer in its iss claim, so that the token is
+// only checked against the keys of the issuer it claims to be from
+func newMultiIssuerVerifier(verifiers map[string]IDTokenVerifier) IDTokenVerifier {
+	return &multiIssuerVerifier{verifiers: verifiers}
+}
+
+// Verify verifies incoming ID Token against the verifier of its issuer
+func (v *multiIssuerVerifier) Verify(ctx context.Context, rawIDToken string) (*oidc.IDToken, error) {
+	issuer, err := unverifiedIssuer(rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %v", err)
+	}
+
+	verifier, ok := v.verifiers[issuer]
+	if !ok {
+		return nil, fmt.Errorf("failed to verify token: issuer %q is not an accepted issuer", issuer)
+	}
+	return verifier.Verify(ctx, rawIDToken)
+}
+
+// unverifiedIssuer returns the iss claim of the token without verifying it
+func unverifiedIssuer(rawIDToken string) (string, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed jwt, expected 3 parts")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwt payload: %v", err)
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("failed to unmarshal claims: %v", err)
+	}
+	return claims.Issuer, nil
+}
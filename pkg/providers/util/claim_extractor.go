@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/bitly/go-simplejson"
@@ -139,11 +140,78 @@ func parseJWT(p string) ([]byte, error) {
 }
 
 // getClaimFrom gets a claim from a Json object.
-// It can accept either a single claim name or a json path.
-// Paths with indexes are not supported.
+// It can accept either a single claim name, a dotted path or a JSON pointer
+// (RFC 6901) starting with a "/".
+// Keys in dotted paths may contain dots themselves, eg namespaced claims such
+// as "https://example.com/claims.groups".
+// Path segments that are numbers index into arrays, other segments are looked
+// up in each of the objects in an array and the values are collected.
+// Missing keys and indexes return nil.
 func getClaimFrom(claim string, src *simplejson.Json) interface{} {
-	claimParts := strings.Split(claim, ".")
-	return src.GetPath(claimParts...).Interface()
+	if strings.HasPrefix(claim, "/") {
+		return lookupClaim(src.Interface(), parseJSONPointer(claim), false)
+	}
+	return lookupClaim(src.Interface(), strings.Split(claim, "."), true)
+}
+
+// parseJSONPointer returns the unescaped reference tokens of a JSON pointer
+func parseJSONPointer(pointer string) []string {
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// lookupClaim follows the path into the value.
+// When joinKeys is set, consecutive path segments are joined with dots to
+// match object keys containing dots, preferring the longest key.
+func lookupClaim(value interface{}, path []string, joinKeys bool) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		segments := 1
+		if joinKeys {
+			segments = len(path)
+		}
+		for i := segments; i > 0; i-- {
+			child, ok := v[strings.Join(path[:i], ".")]
+			if !ok {
+				continue
+			}
+			if found := lookupClaim(child, path[i:], joinKeys); found != nil {
+				return found
+			}
+		}
+		return nil
+	case []interface{}:
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if index < 0 || index >= len(v) {
+				return nil
+			}
+			return lookupClaim(v[index], path[1:], joinKeys)
+		}
+
+		values := []interface{}{}
+		for _, entry := range v {
+			switch found := lookupClaim(entry, path, joinKeys).(type) {
+			case nil:
+			case []interface{}:
+				values = append(values, found...)
+			default:
+				values = append(values, found)
+			}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		return values
+	default:
+		return nil
+	}
 }
 
 // coerceClaim tries to convert the value into the destination interface type.
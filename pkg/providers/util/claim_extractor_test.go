@@ -41,6 +41,31 @@ const (
           "username": "nestedUser"
         }
       }
+    }`
+	namespacedClaimPayload = `{
+      "https://myapp.example.com/claims": {
+        "profile": {
+          "identity": {
+            "username": "namespacedUser",
+            "emails": ["first@example.com", "second@example.com"]
+          }
+        },
+        "groups": ["admins", "developers"],
+        "memberships": [
+          {
+            "org": "org1",
+            "teams": [{"name": "team1"}, {"name": "team2"}]
+          },
+          {
+            "org": "org2",
+            "teams": [{"name": "team3"}]
+          }
+        ],
+        "nothing": null
+      },
+      "a/b~c": {
+        "user": "pointerUser"
+      }
     }`
 	complexGroupsPayload = `{
       "groups": [
@@ -224,6 +249,146 @@ var _ = Describe("Claim Extractor Suite", func() {
 				expectedValue: "nestedUser",
 				expectedError: nil,
 			}),
+			Entry("retrieves a deeply nested claim from a namespaced dotted path", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.profile.identity.username",
+				expectExists:  true,
+				expectedValue: "namespacedUser",
+				expectedError: nil,
+			}),
+			Entry("retrieves a slice claim from a namespaced dotted path", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.groups",
+				expectExists:  true,
+				expectedValue: []interface{}{"admins", "developers"},
+				expectedError: nil,
+			}),
+			Entry("retrieves an array entry by its index", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.profile.identity.emails.1",
+				expectExists:  true,
+				expectedValue: "second@example.com",
+				expectedError: nil,
+			}),
+			Entry("collects a claim from each of the objects in an array", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.memberships.org",
+				expectExists:  true,
+				expectedValue: []interface{}{"org1", "org2"},
+				expectedError: nil,
+			}),
+			Entry("collects a claim from nested arrays of objects", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.memberships.teams.name",
+				expectExists:  true,
+				expectedValue: []interface{}{"team1", "team2", "team3"},
+				expectedError: nil,
+			}),
+			Entry("retrieves a deeply nested claim from a JSON pointer", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "/https:~1~1myapp.example.com~1claims/profile/identity/username",
+				expectExists:  true,
+				expectedValue: "namespacedUser",
+				expectedError: nil,
+			}),
+			Entry("retrieves an array entry from a JSON pointer", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "/https:~1~1myapp.example.com~1claims/memberships/1/org",
+				expectExists:  true,
+				expectedValue: "org2",
+				expectedError: nil,
+			}),
+			Entry("unescapes the keys of a JSON pointer", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "/a~1b~0c/user",
+				expectExists:  true,
+				expectedValue: "pointerUser",
+				expectedError: nil,
+			}),
+			Entry("when an intermediate key of a dotted path is missing", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.missing.username",
+				expectExists:  false,
+				expectedValue: nil,
+				expectedError: nil,
+			}),
+			Entry("when an intermediate key of a JSON pointer is missing", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "/https:~1~1myapp.example.com~1claims/missing/username",
+				expectExists:  false,
+				expectedValue: nil,
+				expectedError: nil,
+			}),
+			Entry("when a dotted path continues past a string", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.profile.identity.username.first",
+				expectExists:  false,
+				expectedValue: nil,
+				expectedError: nil,
+			}),
+			Entry("when a dotted path continues past a null", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.nothing.username",
+				expectExists:  false,
+				expectedValue: nil,
+				expectedError: nil,
+			}),
+			Entry("when an array index is out of range", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.groups.2",
+				expectExists:  false,
+				expectedValue: nil,
+				expectedError: nil,
+			}),
+			Entry("when none of the objects in an array have the claim", getClaimTableInput{
+				testClaimExtractorOpts: testClaimExtractorOpts{
+					idTokenPayload:        namespacedClaimPayload,
+					profileRequestHeaders: newAuthorizedHeader(),
+				},
+				claim:         "https://myapp.example.com/claims.memberships.missing",
+				expectExists:  false,
+				expectedValue: nil,
+				expectedError: nil,
+			}),
 		)
 	})
 
@@ -13,6 +13,7 @@ import (
 // unmarshal the body into an interface, or into a simplejson.Json.
 type Builder interface {
 	WithContext(context.Context) Builder
+	WithClient(*http.Client) Builder
 	WithBody(io.Reader) Builder
 	WithMethod(string) Builder
 	WithHeaders(http.Header) Builder
@@ -22,6 +23,7 @@ type Builder interface {
 
 type builder struct {
 	context  context.Context
+	client   *http.Client
 	method   string
 	endpoint string
 	body     io.Reader
@@ -44,6 +46,13 @@ func (r *builder) WithContext(ctx context.Context) Builder {
 	return r
 }
 
+// WithClient sets the client used to perform the request.
+// If no client is provided, http.DefaultClient is used instead.
+func (r *builder) WithClient(client *http.Client) Builder {
+	r.client = client
+	return r
+}
+
 // WithBody adds a body to the request.
 func (r *builder) WithBody(body io.Reader) Builder {
 	r.body = body
@@ -89,7 +98,7 @@ func (r *builder) Do() Result {
 	return r.do()
 }
 
-// do creates the request, executes it with the default client and extracts the
+// do creates the request, executes it with the client and extracts the
 // the body into the response
 func (r *builder) do() Result {
 	req, err := http.NewRequestWithContext(r.context, r.method, r.endpoint, r.body)
@@ -99,9 +108,14 @@ func (r *builder) do() Result {
 	}
 	req.Header = r.header
 
-	resp, err := http.DefaultClient.Do(req)
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
-		r.result = &result{err: fmt.Errorf("error performing request: %v", err)}
+		r.result = &result{err: fmt.Errorf("error performing request: %w", err)}
 		return r.result
 	}
 
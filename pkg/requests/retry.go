@@ -0,0 +1,186 @@
+package requests
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// DefaultRetryBaseDelay is the delay before the first retry when no base delay
+// is configured.
+const DefaultRetryBaseDelay = 100 * time.Millisecond
+
+// RetryTransport is an http.RoundTripper that retries requests which fail
+// with a network error or a 502, 503 or 504 response, unless Retryable is set.
+// Retries are delayed with exponential backoff and jitter and are never made
+// once the request context is done or the delay would pass its deadline.
+type RetryTransport struct {
+	// Transport performs the requests.
+	// If nil, the transport of the http.DefaultClient is used.
+	Transport http.RoundTripper
+
+	// Retries is the maximum number of times a request is retried.
+	Retries int
+
+	// BaseDelay is the delay before the first retry, it is doubled for each
+	// subsequent retry. If zero, DefaultRetryBaseDelay is used.
+	BaseDelay time.Duration
+
+	// Clock is used to wait between retries and to tell the time left before
+	// the deadline of the request context.
+	Clock clock.Clock
+
+	// Retryable determines whether the result of a request should be retried.
+	// If nil, IsRetryable is used.
+	Retryable func(req *http.Request, resp *http.Response, err error) bool
+}
+
+// NewRetryClient creates an http.Client that retries requests using a
+// RetryTransport.
+func NewRetryClient(retries int, baseDelay time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &RetryTransport{
+			Retries:   retries,
+			BaseDelay: baseDelay,
+		},
+	}
+}
+
+// RoundTrip performs the request, retrying it if it fails with a retryable
+// error.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.transport().RoundTrip(req)
+		if attempt >= t.Retries || ctx.Err() != nil || !t.retryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(t.Clock.Now()) < delay {
+			return resp, err
+		}
+
+		next, bodyErr := rewindRequest(req)
+		if bodyErr != nil {
+			// The request cannot be replayed, return the last result
+			return resp, err
+		}
+		if resp != nil {
+			// Drain the body so that the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		logger.Printf("Retrying request to %s in %s after attempt %d failed: %s", req.URL.Redacted(), delay, attempt+1, retryReason(resp, err))
+		if _, ok := <-t.Clock.AfterContext(ctx, delay); !ok {
+			return nil, ctx.Err()
+		}
+		req = next
+	}
+}
+
+// transport returns the configured transport or the transport of the
+// http.DefaultClient.
+func (t *RetryTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	if http.DefaultClient.Transport != nil {
+		return http.DefaultClient.Transport
+	}
+	return http.DefaultTransport
+}
+
+// retryable determines whether the result of a request should be retried
+// using the configured Retryable func or IsRetryable.
+func (t *RetryTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if t.Retryable != nil {
+		return t.Retryable(req, resp, err)
+	}
+	return IsRetryable(resp, err)
+}
+
+// backoff returns the delay before the retry following the given attempt.
+// The delay doubles for each attempt, half of it is randomised to spread out
+// retries from concurrent requests.
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	base := t.BaseDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+
+	delay := base << attempt
+	if delay <= 0 {
+		// The shift overflowed
+		delay = base
+	}
+	half := delay / 2
+	/* #nosec G404 */
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// rewindRequest creates a copy of the request with a fresh body so that it
+// can be sent again.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return next, nil
+}
+
+// IsRetryable determines whether the result of a request is a transient
+// failure that should be retried.
+func IsRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return IsTransientStatus(resp.StatusCode)
+}
+
+// IsConnectionError determines whether a request failed before it was sent
+// because no connection could be made to the server.
+func IsConnectionError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// IsTransientStatus determines whether a response status code means the
+// server is temporarily unable to handle the request.
+func IsTransientStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryReason describes why a request is being retried.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
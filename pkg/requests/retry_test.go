@@ -0,0 +1,247 @@
+package requests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// scriptedTransport returns the scripted status codes, or an error for a 0
+// status code, for each attempt and records the request bodies.
+type scriptedTransport struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body := []byte{}
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	s.bodies = append(s.bodies, string(body))
+
+	status := s.statuses[0]
+	if len(s.statuses) > 1 {
+		s.statuses = s.statuses[1:]
+	}
+	if status == 0 {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(bytes.NewBufferString("")),
+	}, nil
+}
+
+func (s *scriptedTransport) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+var _ = Describe("RetryTransport suite", func() {
+	const baseDelay = time.Second
+
+	var scripted *scriptedTransport
+	var transport *RetryTransport
+
+	BeforeEach(func() {
+		scripted = &scriptedTransport{}
+		transport = &RetryTransport{
+			Transport: scripted,
+			Retries:   3,
+			BaseDelay: baseDelay,
+		}
+		transport.Clock.Set(time.Unix(1234567890, 0))
+	})
+
+	type roundTripResult struct {
+		resp *http.Response
+		err  error
+	}
+
+	// roundTrip performs the request, advancing the clock until the request
+	// completes so that no real time is spent waiting between retries.
+	roundTrip := func(req *http.Request) (*http.Response, error) {
+		done := make(chan roundTripResult, 1)
+		go func() {
+			resp, err := transport.RoundTrip(req)
+			done <- roundTripResult{resp: resp, err: err}
+		}()
+
+		for {
+			select {
+			case result := <-done:
+				return result.resp, result.err
+			case <-time.After(time.Millisecond):
+				Expect(transport.Clock.Add(baseDelay)).To(Succeed())
+			}
+		}
+	}
+
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("POST", "http://idp.example.com/token", bytes.NewBufferString("grant_type=refresh_token"))
+		Expect(err).ToNot(HaveOccurred())
+		return req
+	}
+
+	type retryTableInput struct {
+		statuses         []int
+		expectedStatus   int
+		expectedErr      string
+		expectedAttempts int
+	}
+
+	DescribeTable("RoundTrip",
+		func(in retryTableInput) {
+			scripted.statuses = in.statuses
+
+			resp, err := roundTrip(newRequest())
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(in.expectedStatus))
+			}
+			Expect(scripted.attempts()).To(Equal(in.expectedAttempts))
+
+			// The body should be replayed for every attempt
+			for _, body := range scripted.bodies {
+				Expect(body).To(Equal("grant_type=refresh_token"))
+			}
+		},
+		Entry("with a successful response", retryTableInput{
+			statuses:         []int{200},
+			expectedStatus:   200,
+			expectedAttempts: 1,
+		}),
+		Entry("with a 502 then a successful response", retryTableInput{
+			statuses:         []int{502, 200},
+			expectedStatus:   200,
+			expectedAttempts: 2,
+		}),
+		Entry("with a 503 and 504 then a successful response", retryTableInput{
+			statuses:         []int{503, 504, 200},
+			expectedStatus:   200,
+			expectedAttempts: 3,
+		}),
+		Entry("with a network error then a successful response", retryTableInput{
+			statuses:         []int{0, 200},
+			expectedStatus:   200,
+			expectedAttempts: 2,
+		}),
+		Entry("with a 500 response", retryTableInput{
+			statuses:         []int{500, 200},
+			expectedStatus:   500,
+			expectedAttempts: 1,
+		}),
+		Entry("with a 400 response", retryTableInput{
+			statuses:         []int{400, 200},
+			expectedStatus:   400,
+			expectedAttempts: 1,
+		}),
+		Entry("with only 503 responses", retryTableInput{
+			statuses:         []int{503},
+			expectedStatus:   503,
+			expectedAttempts: 4,
+		}),
+		Entry("with only network errors", retryTableInput{
+			statuses:         []int{0},
+			expectedErr:      "connection reset by peer",
+			expectedAttempts: 4,
+		}),
+	)
+
+	It("does not retry when the delay would pass the context deadline", func() {
+		scripted.statuses = []int{503, 200}
+
+		deadline := time.Now().Add(time.Hour)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		// The time left before the deadline is measured with the clock
+		transport.Clock.Set(deadline.Add(-baseDelay / 10))
+
+		resp, err := transport.RoundTrip(newRequest().WithContext(ctx))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(503))
+		Expect(scripted.attempts()).To(Equal(1))
+	})
+
+	It("stops waiting to retry when the context is cancelled", func() {
+		scripted.statuses = []int{503, 200}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := transport.RoundTrip(newRequest().WithContext(ctx))
+			done <- err
+		}()
+
+		Eventually(scripted.attempts).Should(Equal(1))
+		cancel()
+		Eventually(done).Should(Receive(MatchError(context.Canceled)))
+		Expect(scripted.attempts()).To(Equal(1))
+	})
+
+	It("does not retry without retries configured", func() {
+		scripted.statuses = []int{503, 200}
+		transport.Retries = 0
+
+		resp, err := transport.RoundTrip(newRequest())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(503))
+		Expect(scripted.attempts()).To(Equal(1))
+	})
+
+	It("only retries results accepted by Retryable", func() {
+		scripted.statuses = []int{503, 502, 200}
+		transport.Retryable = func(_ *http.Request, resp *http.Response, err error) bool {
+			return err == nil && resp.StatusCode == 503
+		}
+
+		resp, err := roundTrip(newRequest())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(502))
+		Expect(scripted.attempts()).To(Equal(2))
+	})
+
+	DescribeTable("IsConnectionError",
+		func(err error, expected bool) {
+			Expect(IsConnectionError(err)).To(Equal(expected))
+		},
+		Entry("with a dial error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true),
+		Entry("with a wrapped dial error", fmt.Errorf("wrapped: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true),
+		Entry("with a DNS error", &net.DNSError{Err: "no such host", Name: "idp.example.com"}, true),
+		Entry("with a read error", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, false),
+		Entry("with another error", errors.New("connection reset by peer"), false),
+		Entry("without an error", nil, false),
+	)
+
+	DescribeTable("backoff doubles the delay with jitter",
+		func(attempt int, min, max time.Duration) {
+			for i := 0; i < 100; i++ {
+				delay := transport.backoff(attempt)
+				Expect(delay).To(BeNumerically(">=", min))
+				Expect(delay).To(BeNumerically("<=", max))
+			}
+		},
+		Entry("for the first retry", 0, baseDelay/2, baseDelay),
+		Entry("for the second retry", 1, baseDelay, 2*baseDelay),
+		Entry("for the third retry", 2, 2*baseDelay, 4*baseDelay),
+	)
+})
@@ -0,0 +1,127 @@
+package cookie
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+// readablePayload is the cookie value used when the session claims are
+// readable. Only the tokens are encrypted, the MAC covers both the claims and
+// the encrypted tokens so that neither can be modified or swapped.
+type readablePayload struct {
+	Claims json.RawMessage `json:"claims"`
+	Tokens []byte          `json:"tokens,omitempty"`
+	MAC    []byte          `json:"mac"`
+}
+
+// readableClaims are the session fields stored in plain text
+type readableClaims struct {
+	Email             string     `json:"email,omitempty"`
+	User              string     `json:"user,omitempty"`
+	Groups            []string   `json:"groups,omitempty"`
+	PreferredUsername string     `json:"preferred_username,omitempty"`
+	CreatedAt         *time.Time `json:"created_at,omitempty"`
+	ExpiresOn         *time.Time `json:"expires_on,omitempty"`
+	AuthenticatedAt   *time.Time `json:"authenticated_at,omitempty"`
+	LastActivityAt    *time.Time `json:"last_activity_at,omitempty"`
+}
+
+// encodeReadableSession serializes the session with the claims as JSON and
+// the remaining fields (tokens, nonce and grant) encrypted with the cipher
+func encodeReadableSession(ss *sessions.SessionState, c encryption.Cipher, secret string) ([]byte, error) {
+	claims, err := json.Marshal(readableClaims{
+		Email:             ss.Email,
+		User:              ss.User,
+		Groups:            ss.Groups,
+		PreferredUsername: ss.PreferredUsername,
+		CreatedAt:         ss.CreatedAt,
+		ExpiresOn:         ss.ExpiresOn,
+		AuthenticatedAt:   ss.AuthenticatedAt,
+		LastActivityAt:    ss.LastActivityAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling session claims: %v", err)
+	}
+
+	sensitive := sessions.SessionState{
+		AccessToken:     ss.AccessToken,
+		IDToken:         ss.IDToken,
+		RefreshToken:    ss.RefreshToken,
+		Nonce:           ss.Nonce,
+		DeviceGrant:     ss.DeviceGrant,
+		ExchangedTokens: ss.ExchangedTokens,
+	}
+	var tokens []byte
+	if sensitive.AccessToken != "" || sensitive.IDToken != "" || sensitive.RefreshToken != "" ||
+		len(sensitive.Nonce) > 0 || sensitive.DeviceGrant || len(sensitive.ExchangedTokens) > 0 {
+		tokens, err = sensitive.EncodeSessionState(c, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(readablePayload{
+		Claims: claims,
+		Tokens: tokens,
+		MAC:    readableMAC(secret, claims, tokens),
+	})
+}
+
+// decodeReadableSession verifies the MAC of a readable payload before
+// decrypting the tokens and merging them with the claims
+func decodeReadableSession(data []byte, c encryption.Cipher, secret string) (*sessions.SessionState, error) {
+	var payload readablePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("error unmarshalling readable session: %v", err)
+	}
+	if !hmac.Equal(payload.MAC, readableMAC(secret, payload.Claims, payload.Tokens)) {
+		return nil, errors.New("readable session MAC not valid")
+	}
+
+	var claims readableClaims
+	if err := json.Unmarshal(payload.Claims, &claims); err != nil {
+		return nil, fmt.Errorf("error unmarshalling session claims: %v", err)
+	}
+
+	ss := &sessions.SessionState{}
+	if len(payload.Tokens) > 0 {
+		var err error
+		ss, err = sessions.DecodeSessionState(payload.Tokens, c, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ss.Email = claims.Email
+	ss.User = claims.User
+	ss.Groups = claims.Groups
+	ss.PreferredUsername = claims.PreferredUsername
+	ss.CreatedAt = claims.CreatedAt
+	ss.ExpiresOn = claims.ExpiresOn
+	ss.AuthenticatedAt = claims.AuthenticatedAt
+	ss.LastActivityAt = claims.LastActivityAt
+	return ss, nil
+}
+
+// isReadablePayload reports whether the cookie value looks like a readable
+// session rather than an encrypted one
+func isReadablePayload(data []byte) bool {
+	var payload readablePayload
+	return json.Unmarshal(data, &payload) == nil && len(payload.Claims) > 0 && len(payload.MAC) > 0
+}
+
+// readableMAC computes the HMAC-SHA256 over the claims and encrypted tokens.
+// The claims are a complete JSON object so the boundary between the two
+// parts is unambiguous.
+func readableMAC(secret string, claims, tokens []byte) []byte {
+	mac := hmac.New(sha256.New, encryption.SecretBytes(secret))
+	mac.Write(claims)
+	mac.Write(tokens)
+	return mac.Sum(nil)
+}
@@ -15,22 +15,27 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
-const (
-	// Cookies are limited to 4kb for all parts
-	// including the cookie name, value, attributes; IE (http.cookie).String()
-	// Most browsers' max is 4096 -- but we give ourselves some leeway
-	maxCookieLength = 4000
-)
-
 // Ensure CookieSessionStore implements the interface
 var _ sessions.SessionStore = &SessionStore{}
 
 // SessionStore is an implementation of the sessions.SessionStore
 // interface that stores sessions in client side cookies
 type SessionStore struct {
-	Cookie       *options.Cookie
-	CookieCipher encryption.Cipher
-	Minimal      bool
+	Cookie         *options.Cookie
+	CookieCipher   encryption.Cipher
+	Minimal        bool
+	ReadableClaims bool
+
+	// ChunkSize is the maximum length of each cookie, including its name
+	// and attributes; IE (http.cookie).String()
+	ChunkSize int
+	// MaxChunks is the maximum number of cookies a session may be split
+	// across, 0 disables the limit
+	MaxChunks int
+
+	// secondaryCiphers decrypt the sessions signed with the secondary
+	// secrets of the Cookie, in the same order
+	secondaryCiphers []encryption.Cipher
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -43,44 +48,69 @@ func (s *SessionStore) Save(rw http.ResponseWriter, req *http.Request, ss *sessi
 	if err != nil {
 		return err
 	}
-	return s.setSessionCookie(rw, req, value, *ss.CreatedAt)
+	if err := s.setSessionCookie(rw, req, value, *ss.CreatedAt); err != nil {
+		return err
+	}
+	ss.SecretRotated = false
+	return nil
 }
 
 // Load reads sessions.SessionState information from Cookies within the
 // HTTP request object
 func (s *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
-	c, err := loadCookie(req, s.Cookie.Name)
+	c, err := loadCookie(req, s.Cookie.PrefixedName())
 	if err != nil {
 		// always http.ErrNoCookie
 		return nil, err
 	}
-	val, _, ok := encryption.Validate(c, s.Cookie.Secret, s.Cookie.Expire)
+	secrets := s.Cookie.Secrets()
+	val, secret, ok := encryption.ValidateWithSecrets(c, secrets, s.Cookie.Expire)
 	if !ok {
 		return nil, errors.New("cookie signature not valid")
 	}
+	cipher := s.CookieCipher
+	if secret > 0 {
+		cipher = s.secondaryCiphers[secret-1]
+	}
 
-	session, err := sessions.DecodeSessionState(val, s.CookieCipher, true)
+	// Readable sessions are decoded regardless of the current setting so
+	// that toggling it does not sign out every user
+	var session *sessions.SessionState
+	if isReadablePayload(val) {
+		session, err = decodeReadableSession(val, cipher, secrets[secret])
+	} else {
+		session, err = sessions.DecodeSessionState(val, cipher, true)
+	}
 	if err != nil {
 		return nil, err
 	}
+	session.SecretRotated = secret > 0
 	return session, nil
 }
 
 // Clear clears any saved session information by writing a cookie to
 // clear the session
 func (s *SessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
+	s.clearSessionCookies(rw, req, nil)
+	return nil
+}
+
+// clearSessionCookies clears the session cookies in the request, except for
+// those named in keep
+func (s *SessionStore) clearSessionCookies(rw http.ResponseWriter, req *http.Request, keep map[string]struct{}) {
 	// matches CookieName, CookieName_<number>
-	var cookieNameRegex = regexp.MustCompile(fmt.Sprintf("^%s(_\\d+)?$", s.Cookie.Name))
+	var cookieNameRegex = regexp.MustCompile(fmt.Sprintf("^%s(_\\d+)?$", regexp.QuoteMeta(s.Cookie.PrefixedName())))
 
 	for _, c := range req.Cookies() {
+		if _, ok := keep[c.Name]; ok {
+			continue
+		}
 		if cookieNameRegex.MatchString(c.Name) {
 			clearCookie := s.makeCookie(req, c.Name, "", time.Hour*-1, time.Now())
 
-			http.SetCookie(rw, clearCookie)
+			pkgcookies.SetCookie(rw, clearCookie, s.Cookie)
 		}
 	}
-
-	return nil
 }
 
 // VerifyConnection always return no-error, as there's no connection
@@ -91,27 +121,37 @@ func (s *SessionStore) VerifyConnection(_ context.Context) error {
 
 // cookieForSession serializes a session state for storage in a cookie
 func (s *SessionStore) cookieForSession(ss *sessions.SessionState) ([]byte, error) {
-	if s.Minimal && (ss.AccessToken != "" || ss.IDToken != "" || ss.RefreshToken != "") {
+	if s.Minimal && (ss.AccessToken != "" || ss.IDToken != "" || ss.RefreshToken != "" || len(ss.ExchangedTokens) > 0) {
 		minimal := *ss
 		minimal.AccessToken = ""
 		minimal.IDToken = ""
 		minimal.RefreshToken = ""
-
-		return minimal.EncodeSessionState(s.CookieCipher, true)
+		minimal.ExchangedTokens = nil
+		ss = &minimal
 	}
 
+	if s.ReadableClaims {
+		return encodeReadableSession(ss, s.CookieCipher, s.Cookie.Secret)
+	}
 	return ss.EncodeSessionState(s.CookieCipher, true)
 }
 
-// setSessionCookie adds the user's session cookie to the response
+// setSessionCookie adds the user's session cookie to the response.
+// Session cookies from the request that are not overwritten are cleared, so
+// that chunks left over from a larger session or a different chunk size are
+// not joined with the new session when it is loaded.
 func (s *SessionStore) setSessionCookie(rw http.ResponseWriter, req *http.Request, val []byte, created time.Time) error {
 	cookies, err := s.makeSessionCookie(req, val, created)
 	if err != nil {
 		return err
 	}
+
+	names := make(map[string]struct{}, len(cookies))
 	for _, c := range cookies {
-		http.SetCookie(rw, c)
+		names[c.Name] = struct{}{}
+		pkgcookies.SetCookie(rw, c, s.Cookie)
 	}
+	s.clearSessionCookies(rw, req, names)
 	return nil
 }
 
@@ -121,16 +161,30 @@ func (s *SessionStore) makeSessionCookie(req *http.Request, value []byte, now ti
 	strValue := string(value)
 	if strValue != "" {
 		var err error
-		strValue, err = encryption.SignedValue(s.Cookie.Secret, s.Cookie.Name, value, now)
+		strValue, err = encryption.SignedValue(s.Cookie.Secret, s.Cookie.PrefixedName(), value, now)
 		if err != nil {
 			return nil, err
 		}
 	}
-	c := s.makeCookie(req, s.Cookie.Name, strValue, s.Cookie.Expire, now)
-	if len(c.String()) > maxCookieLength {
-		return splitCookie(c), nil
+	c := s.makeCookie(req, s.Cookie.PrefixedName(), strValue, s.Cookie.Expire, now)
+	chunkSize := s.chunkSize()
+	if len(c.String()) <= chunkSize {
+		return []*http.Cookie{c}, nil
+	}
+
+	cookies := splitCookie(c, chunkSize)
+	if s.MaxChunks > 0 && len(cookies) > s.MaxChunks {
+		return nil, fmt.Errorf("session requires %d cookies which exceeds the limit of %d, use server side session storage (eg. Redis) instead", len(cookies), s.MaxChunks)
+	}
+	return cookies, nil
+}
+
+// chunkSize returns the maximum length of each session cookie
+func (s *SessionStore) chunkSize() int {
+	if s.ChunkSize <= 0 {
+		return options.DefaultSessionCookieChunkSize
 	}
-	return []*http.Cookie{c}, nil
+	return s.ChunkSize
 }
 
 func (s *SessionStore) makeCookie(req *http.Request, name string, value string, expiration time.Duration, now time.Time) *http.Cookie {
@@ -147,27 +201,44 @@ func (s *SessionStore) makeCookie(req *http.Request, name string, value string,
 // NewCookieSessionStore initialises a new instance of the SessionStore from
 // the configuration given
 func NewCookieSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
-	cipher, err := encryption.NewCFBCipher(encryption.SecretBytes(cookieOpts.Secret))
+	cipher, err := encryption.NewCookieCipher(cookieOpts.EncryptionAlgorithm, encryption.SecretBytes(cookieOpts.Secret))
 	if err != nil {
 		return nil, fmt.Errorf("error initialising cipher: %v", err)
 	}
+	secondaryCiphers := make([]encryption.Cipher, 0, len(cookieOpts.SecondarySecrets))
+	for _, secret := range cookieOpts.SecondarySecrets {
+		c, err := encryption.NewCookieCipher(cookieOpts.EncryptionAlgorithm, encryption.SecretBytes(secret))
+		if err != nil {
+			return nil, fmt.Errorf("error initialising cipher for secondary secret: %v", err)
+		}
+		secondaryCiphers = append(secondaryCiphers, c)
+	}
+
+	if opts.Cookie.ReadableClaims {
+		logger.Print("WARNING: session-cookie-readable-claims is enabled. The user, email and groups of each session are stored unencrypted in the session cookie and can be read by anyone with access to it.")
+	}
 
 	return &SessionStore{
-		CookieCipher: cipher,
-		Cookie:       cookieOpts,
-		Minimal:      opts.Cookie.Minimal,
+		CookieCipher:   cipher,
+		Cookie:         cookieOpts,
+		Minimal:        opts.Cookie.Minimal,
+		ReadableClaims: opts.Cookie.ReadableClaims,
+		ChunkSize:      opts.Cookie.ChunkSize,
+		MaxChunks:      opts.Cookie.MaxChunks,
+
+		secondaryCiphers: secondaryCiphers,
 	}, nil
 }
 
 // splitCookie reads the full cookie generated to store the session and splits
-// it into a slice of cookies which fit within the 4kb cookie limit indexing
-// the cookies from 0
-func splitCookie(c *http.Cookie) []*http.Cookie {
-	if len(c.String()) < maxCookieLength {
+// it into a slice of cookies which fit within the maxLength cookie limit
+// indexing the cookies from 0
+func splitCookie(c *http.Cookie, maxLength int) []*http.Cookie {
+	if len(c.String()) < maxLength {
 		return []*http.Cookie{c}
 	}
 
-	logger.Errorf("WARNING: Multiple cookies are required for this session as it exceeds the 4kb cookie limit. Please use server side session storage (eg. Redis) instead.")
+	logger.Errorf("WARNING: Multiple cookies are required for this session as it exceeds the %d byte cookie limit. Please use server side session storage (eg. Redis) instead.", maxLength)
 
 	cookies := []*http.Cookie{}
 	valueBytes := []byte(c.Value)
@@ -179,10 +250,10 @@ func splitCookie(c *http.Cookie) []*http.Cookie {
 
 		newCookie.Value = string(valueBytes)
 		cookieLength := len(newCookie.String())
-		if cookieLength <= maxCookieLength {
+		if cookieLength <= maxLength {
 			valueBytes = []byte{}
 		} else {
-			overflow := cookieLength - maxCookieLength
+			overflow := cookieLength - maxLength
 			valueSize := len(valueBytes) - overflow
 
 			newValue := valueBytes[:valueSize]
@@ -205,7 +276,9 @@ func splitCookieName(name string, count int) string {
 
 // loadCookie retreieves the sessions state cookie from the http request.
 // If a single cookie is present this will be returned, otherwise it attempts
-// to reconstruct a cookie split up by splitCookie
+// to reconstruct a cookie split up by splitCookie.
+// The chunks are joined in order regardless of their size, so sessions
+// written with a different chunk size can still be loaded.
 func loadCookie(req *http.Request, cookieName string) (*http.Cookie, error) {
 	c, err := req.Cookie(cookieName)
 	if err == nil {
@@ -4,12 +4,15 @@ import (
 	"fmt"
 	mathrand "math/rand"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
@@ -34,6 +37,124 @@ var _ = Describe("Cookie SessionStore Tests", func() {
 		}, nil)
 })
 
+var _ = Describe("Cookie SessionStore with readable claims Tests", func() {
+	tests.RunSessionStoreTests(
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			opts.Type = options.CookieSessionStoreType
+			opts.Cookie.ReadableClaims = true
+			return NewCookieSessionStore(opts, cookieOpts)
+		}, nil)
+})
+
+func Test_readableSession(t *testing.T) {
+	const secret = "0123456789abcdef"
+	cipher, err := encryption.NewCFBCipher([]byte(secret))
+	assert.NoError(t, err)
+
+	created := time.Unix(1700000000, 0)
+	ss := &sessionsapi.SessionState{
+		Email:          "user@example.com",
+		User:           "user",
+		Groups:         []string{"admins"},
+		CreatedAt:      &created,
+		LastActivityAt: &created,
+		AccessToken:    "access-token",
+		RefreshToken:   "refresh-token",
+	}
+
+	encoded, err := encodeReadableSession(ss, cipher, secret)
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `"email":"user@example.com"`)
+	assert.Contains(t, string(encoded), `"groups":["admins"]`)
+	assert.NotContains(t, string(encoded), "access-token")
+	assert.NotContains(t, string(encoded), "refresh-token")
+
+	decoded, err := decodeReadableSession(encoded, cipher, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", decoded.Email)
+	assert.Equal(t, []string{"admins"}, decoded.Groups)
+	assert.Equal(t, "access-token", decoded.AccessToken)
+	assert.Equal(t, "refresh-token", decoded.RefreshToken)
+	assert.True(t, created.Equal(*decoded.CreatedAt))
+	assert.True(t, created.Equal(*decoded.LastActivityAt))
+
+	tampered := []byte(strings.Replace(string(encoded), "admins", "owners", 1))
+	_, err = decodeReadableSession(tampered, cipher, secret)
+	assert.EqualError(t, err, "readable session MAC not valid")
+
+	_, err = decodeReadableSession(encoded, cipher, "fedcba9876543210")
+	assert.EqualError(t, err, "readable session MAC not valid")
+
+	encrypted, err := ss.EncodeSessionState(cipher, true)
+	assert.NoError(t, err)
+	assert.True(t, isReadablePayload(encoded))
+	assert.False(t, isReadablePayload(encrypted))
+}
+
+func Test_readableSessionRoundTripsAllFields(t *testing.T) {
+	const secret = "0123456789abcdef"
+	cipher, err := encryption.NewCFBCipher([]byte(secret))
+	assert.NoError(t, err)
+
+	created := time.Unix(1700000000, 0)
+	expires := created.Add(time.Hour)
+	ss := &sessionsapi.SessionState{
+		CreatedAt:         &created,
+		ExpiresOn:         &expires,
+		AuthenticatedAt:   &created,
+		LastActivityAt:    &created,
+		AccessToken:       "access-token",
+		IDToken:           "id-token",
+		RefreshToken:      "refresh-token",
+		Nonce:             []byte("nonce"),
+		DeviceGrant:       true,
+		Email:             "user@example.com",
+		User:              "user",
+		Groups:            []string{"admins"},
+		PreferredUsername: "preferred",
+		ExchangedTokens: map[string]sessionsapi.ExchangedToken{
+			"api": {AccessToken: "exchanged", ExpiresOn: &expires},
+		},
+	}
+
+	encoded, err := encodeReadableSession(ss, cipher, secret)
+	assert.NoError(t, err)
+	decoded, err := decodeReadableSession(encoded, cipher, secret)
+	assert.NoError(t, err)
+
+	// Every field persisted in the encrypted session must survive the
+	// readable encoding
+	original := reflect.ValueOf(ss).Elem()
+	roundTripped := reflect.ValueOf(decoded).Elem()
+	for i := 0; i < original.NumField(); i++ {
+		field := original.Type().Field(i)
+		if field.Tag.Get("msgpack") == "-" {
+			continue
+		}
+		if !assert.False(t, original.Field(i).IsZero(), "test session must set %s", field.Name) {
+			continue
+		}
+
+		want, got := original.Field(i).Interface(), roundTripped.Field(i).Interface()
+		if wantTime, ok := want.(*time.Time); ok {
+			gotTime, _ := got.(*time.Time)
+			if assert.NotNil(t, gotTime, "%s was not persisted", field.Name) {
+				assert.True(t, wantTime.Equal(*gotTime), "%s was not persisted", field.Name)
+			}
+			continue
+		}
+		if field.Name == "ExchangedTokens" {
+			gotToken := decoded.ExchangedTokens["api"]
+			assert.Equal(t, "exchanged", gotToken.AccessToken)
+			if assert.NotNil(t, gotToken.ExpiresOn) {
+				assert.True(t, expires.Equal(*gotToken.ExpiresOn))
+			}
+			continue
+		}
+		assert.Equal(t, want, got, "%s was not persisted", field.Name)
+	}
+}
+
 func Test_copyCookie(t *testing.T) {
 	expire, _ := time.Parse(time.RFC3339, "2020-03-17T00:00:00Z")
 	c := &http.Cookie{
@@ -98,7 +219,7 @@ func Test_splitCookie(t *testing.T) {
 	}
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
-			splitCookies := splitCookie(tc)
+			splitCookies := splitCookie(tc, options.DefaultSessionCookieChunkSize)
 			for i, cookie := range splitCookies {
 				if i < len(splitCookies)-1 {
 					assert.Equal(t, 4000, len(cookie.String()))
@@ -155,7 +276,7 @@ func Test_splitCookie_joinCookies(t *testing.T) {
 				Name:  strings.Repeat("n", nameSize),
 				Value: value,
 			}
-			splitCookies := splitCookie(cookie)
+			splitCookies := splitCookie(cookie, options.DefaultSessionCookieChunkSize)
 			joinedCookie, err := joinCookies(splitCookies, cookie.Name)
 			assert.NoError(t, err)
 			assert.Equal(t, *cookie, *joinedCookie)
@@ -211,3 +332,237 @@ func Test_joinCookies_withUnderlineSuffix(t *testing.T) {
 		})
 	}
 }
+
+func Test_cookieForSession_minimal(t *testing.T) {
+	store, err := NewCookieSessionStore(
+		&options.SessionOptions{Cookie: options.CookieStoreOptions{Minimal: true}},
+		&options.Cookie{Secret: "0123456789abcdef"},
+	)
+	assert.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	ss := &sessionsapi.SessionState{
+		Email:        "user@example.com",
+		Groups:       []string{"admins"},
+		ExpiresOn:    &expires,
+		AccessToken:  "access",
+		IDToken:      "id",
+		RefreshToken: "refresh",
+		ExchangedTokens: map[string]sessionsapi.ExchangedToken{
+			"api": {AccessToken: "exchanged"},
+		},
+	}
+
+	value, err := store.(*SessionStore).cookieForSession(ss)
+	assert.NoError(t, err)
+
+	decoded, err := sessionsapi.DecodeSessionState(value, store.(*SessionStore).CookieCipher, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", decoded.Email)
+	assert.Equal(t, []string{"admins"}, decoded.Groups)
+	assert.Equal(t, expires.Unix(), decoded.ExpiresOn.Unix())
+	assert.Empty(t, decoded.AccessToken)
+	assert.Empty(t, decoded.IDToken)
+	assert.Empty(t, decoded.RefreshToken)
+	assert.Empty(t, decoded.ExchangedTokens)
+
+	// The original session must keep its tokens
+	assert.Equal(t, "access", ss.AccessToken)
+	assert.Len(t, ss.ExchangedTokens, 1)
+}
+
+func Test_prefixedSessionStores(t *testing.T) {
+	newStore := func(prefix string) *SessionStore {
+		store, err := NewCookieSessionStore(
+			&options.SessionOptions{},
+			&options.Cookie{
+				Name:       "_oauth2_proxy",
+				NamePrefix: prefix,
+				Secret:     "0123456789abcdef",
+				Path:       "/",
+				Expire:     time.Hour,
+			},
+		)
+		assert.NoError(t, err)
+		return store.(*SessionStore)
+	}
+	storeA := newStore("tenant_a")
+	storeB := newStore("tenant_b")
+
+	// A large session is split into multiple cookies, random tokens ensure
+	// the session is not compressed into a single cookie
+	token := make([]byte, 10000)
+	for i := range token {
+		token[i] = byte('a' + mathrand.Intn(26))
+	}
+	ss := &sessionsapi.SessionState{
+		Email:       "user@example.com",
+		AccessToken: string(token),
+	}
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	assert.NoError(t, storeA.Save(rw, req, ss))
+
+	cookies := rw.Result().Cookies()
+	assert.Greater(t, len(cookies), 1)
+	for _, c := range cookies {
+		assert.Regexp(t, "^tenant_a_oauth2_proxy_\\d+$", c.Name)
+		req.AddCookie(c)
+	}
+
+	loaded, err := storeA.Load(req)
+	assert.NoError(t, err)
+	assert.Equal(t, ss.AccessToken, loaded.AccessToken)
+
+	_, err = storeB.Load(req)
+	assert.Equal(t, http.ErrNoCookie, err)
+
+	rw = httptest.NewRecorder()
+	assert.NoError(t, storeB.Clear(rw, req))
+	assert.Empty(t, rw.Result().Cookies())
+
+	rw = httptest.NewRecorder()
+	assert.NoError(t, storeA.Clear(rw, req))
+	assert.Len(t, rw.Result().Cookies(), len(cookies))
+}
+
+func Test_sessionCookieChunks(t *testing.T) {
+	newStore := func(chunkSize, maxChunks int) *SessionStore {
+		store, err := NewCookieSessionStore(
+			&options.SessionOptions{Cookie: options.CookieStoreOptions{ChunkSize: chunkSize, MaxChunks: maxChunks}},
+			&options.Cookie{
+				Name:   "_oauth2_proxy",
+				Secret: "0123456789abcdef",
+				Path:   "/",
+				Expire: time.Hour,
+			},
+		)
+		assert.NoError(t, err)
+		return store.(*SessionStore)
+	}
+
+	token := make([]byte, 10000)
+	for i := range token {
+		token[i] = byte('a' + mathrand.Intn(26))
+	}
+	ss := &sessionsapi.SessionState{
+		Email:       "user@example.com",
+		AccessToken: string(token),
+	}
+
+	save := func(store *SessionStore, req *http.Request) []*http.Cookie {
+		rw := httptest.NewRecorder()
+		assert.NoError(t, store.Save(rw, req, ss))
+		return rw.Result().Cookies()
+	}
+	requestWith := func(cookies []*http.Cookie) *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		for _, c := range cookies {
+			if c.Expires.After(time.Now()) {
+				req.AddCookie(c)
+			}
+		}
+		return req
+	}
+
+	t.Run("chunks are no larger than the chunk size", func(t *testing.T) {
+		cookies := save(newStore(2048, 0), requestWith(nil))
+		assert.Greater(t, len(cookies), 5)
+		for _, c := range cookies {
+			assert.LessOrEqual(t, len(c.String()), 2048)
+		}
+	})
+
+	t.Run("sessions written with another chunk size are loaded", func(t *testing.T) {
+		req := requestWith(save(newStore(options.DefaultSessionCookieChunkSize, 0), requestWith(nil)))
+		loaded, err := newStore(2048, 0).Load(req)
+		assert.NoError(t, err)
+		assert.Equal(t, ss.AccessToken, loaded.AccessToken)
+	})
+
+	t.Run("chunks left over from another chunk size are cleared", func(t *testing.T) {
+		small := save(newStore(2048, 0), requestWith(nil))
+
+		large := save(newStore(options.DefaultSessionCookieChunkSize, 0), requestWith(small))
+		written, cleared := 0, 0
+		for _, c := range large {
+			if c.Expires.Before(time.Now()) {
+				cleared++
+			} else {
+				written++
+			}
+		}
+		assert.Less(t, written, len(small))
+		assert.Equal(t, len(small)-written, cleared)
+
+		loaded, err := newStore(2048, 0).Load(requestWith(large))
+		assert.NoError(t, err)
+		assert.Equal(t, ss.AccessToken, loaded.AccessToken)
+	})
+
+	t.Run("sessions exceeding the maximum chunks fail to save", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		err := newStore(2048, 3).Save(rw, requestWith(nil), ss)
+		assert.Regexp(t, "^session requires \\d+ cookies which exceeds the limit of 3, use server side session storage \\(eg. Redis\\) instead$", err.Error())
+		assert.Empty(t, rw.Result().Cookies())
+	})
+}
+
+func Test_Clear_quotesCookieName(t *testing.T) {
+	store, err := NewCookieSessionStore(
+		&options.SessionOptions{},
+		&options.Cookie{Name: "oauth2.proxy", Secret: "0123456789abcdef"},
+	)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth2.proxy_0", Value: "value"})
+	req.AddCookie(&http.Cookie{Name: "oauth2-proxy", Value: "value"})
+
+	rw := httptest.NewRecorder()
+	assert.NoError(t, store.Clear(rw, req))
+
+	cleared := rw.Result().Cookies()
+	assert.Len(t, cleared, 1)
+	assert.Equal(t, "oauth2.proxy_0", cleared[0].Name)
+}
+
+func Test_sessionStoreEncryptionAlgorithms(t *testing.T) {
+	newStore := func(algorithm string) *SessionStore {
+		store, err := NewCookieSessionStore(
+			&options.SessionOptions{},
+			&options.Cookie{
+				Name:                "_oauth2_proxy",
+				Secret:              "secretthirtytwobytes+abcdefghijk",
+				EncryptionAlgorithm: algorithm,
+				Path:                "/",
+				Expire:              time.Hour,
+			},
+		)
+		assert.NoError(t, err)
+		return store.(*SessionStore)
+	}
+
+	algorithms := []string{encryption.CipherAESCFB, encryption.CipherAES256GCM, encryption.CipherChaCha20Poly1305}
+	for _, saveWith := range algorithms {
+		for _, loadWith := range algorithms {
+			t.Run(fmt.Sprintf("%s to %s", saveWith, loadWith), func(t *testing.T) {
+				ss := &sessionsapi.SessionState{
+					Email:       "user@example.com",
+					AccessToken: "access-token",
+				}
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				assert.NoError(t, newStore(saveWith).Save(rw, req, ss))
+				for _, c := range rw.Result().Cookies() {
+					req.AddCookie(c)
+				}
+
+				loaded, err := newStore(loadWith).Load(req)
+				assert.NoError(t, err)
+				assert.Equal(t, "user@example.com", loaded.Email)
+				assert.Equal(t, "access-token", loaded.AccessToken)
+			})
+		}
+	}
+}
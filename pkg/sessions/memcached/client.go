@@ -0,0 +1,433 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTimeout is the timeout for a single memcached operation when the
+	// context has no earlier deadline
+	defaultTimeout = 500 * time.Millisecond
+
+	// maxIdleConnsPerServer is the number of idle connections kept open to
+	// each memcached server
+	maxIdleConnsPerServer = 2
+
+	// maxKeyLength is the longest key memcached will accept
+	maxKeyLength = 250
+
+	// maxRelativeExpiration is the longest expiration memcached accepts in
+	// seconds, longer expirations must be sent as a unix timestamp
+	maxRelativeExpiration = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrCacheMiss is returned when the key does not exist in memcached
+	ErrCacheMiss = errors.New("memcached: cache miss")
+
+	// ErrNotStored is returned by Add when the key already exists in memcached
+	ErrNotStored = errors.New("memcached: item not stored")
+
+	// ErrCASConflict is returned by CompareAndSwap and CompareAndDelete when
+	// the key was modified since its CAS unique was read
+	ErrCASConflict = errors.New("memcached: compare-and-swap conflict")
+)
+
+// Client is the subset of memcached commands used by the SessionStore
+type Client interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Gets(ctx context.Context, key string) ([]byte, uint64, error)
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Add(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	CompareAndSwap(ctx context.Context, key string, value []byte, expiration time.Duration, cas uint64) error
+	CompareAndDelete(ctx context.Context, key string, cas uint64) error
+	Touch(ctx context.Context, key string, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Ping(ctx context.Context) error
+}
+
+var _ Client = (*client)(nil)
+
+// client speaks the memcached text protocol over TCP.
+// Keys are sharded across the servers by the CRC32 checksum of the key.
+type client struct {
+	servers []string
+	dialer  net.Dialer
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+// conn is a connection to a single memcached server
+type conn struct {
+	addr string
+	nc   net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// NewMemcachedClient makes a Client that shards keys across the given
+// server addresses (eg HOST:PORT)
+func NewMemcachedClient(servers []string) (Client, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("at least one memcached server is required")
+	}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return nil, fmt.Errorf("invalid memcached server address %q: %v", server, err)
+		}
+	}
+
+	return &client{
+		servers: servers,
+		idle:    make(map[string][]*conn),
+	}, nil
+}
+
+// Get returns the value stored at the key, or ErrCacheMiss if it is
+// not found
+func (c *client) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := c.get(ctx, "get", key)
+	return value, err
+}
+
+// Gets returns the value stored at the key with its CAS unique, or
+// ErrCacheMiss if it is not found
+func (c *client) Gets(ctx context.Context, key string) ([]byte, uint64, error) {
+	return c.get(ctx, "gets", key)
+}
+
+// get sends a retrieval command (get or gets) for a single key
+func (c *client) get(ctx context.Context, cmd, key string) ([]byte, uint64, error) {
+	var value []byte
+	var cas uint64
+	err := c.withKeyConn(ctx, key, func(cn *conn) error {
+		if _, err := fmt.Fprintf(cn.rw, "%s %s\r\n", cmd, key); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+
+		var err error
+		value, cas, err = readValue(cn.rw.Reader, key)
+		return err
+	})
+	return value, cas, err
+}
+
+// Set stores the value at the key, replacing any existing value
+func (c *client) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return c.store(ctx, "set", key, value, expiration)
+}
+
+// Add stores the value at the key only if it does not already exist,
+// otherwise ErrNotStored is returned
+func (c *client) Add(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return c.store(ctx, "add", key, value, expiration)
+}
+
+// CompareAndSwap stores the value at the key only if it was not modified
+// since the CAS unique was read with Gets, otherwise ErrCASConflict is
+// returned, or ErrCacheMiss if the key no longer exists
+func (c *client) CompareAndSwap(ctx context.Context, key string, value []byte, expiration time.Duration, cas uint64) error {
+	return c.storeCommand(ctx, key, fmt.Sprintf("cas %s 0 %d %d %d", key, expirationSeconds(expiration), len(value), cas), value)
+}
+
+// CompareAndDelete removes the key only if it was not modified since the CAS
+// unique was read with Gets, by replacing it with an item that has already
+// expired as the text protocol has no conditional delete
+func (c *client) CompareAndDelete(ctx context.Context, key string, cas uint64) error {
+	return c.storeCommand(ctx, key, fmt.Sprintf("cas %s 0 -1 0 %d", key, cas), nil)
+}
+
+// Touch updates the expiration of an existing key, or returns ErrCacheMiss
+// if it is not found
+func (c *client) Touch(ctx context.Context, key string, expiration time.Duration) error {
+	return c.withKeyConn(ctx, key, func(cn *conn) error {
+		return command(cn, fmt.Sprintf("touch %s %d", key, expirationSeconds(expiration)), "TOUCHED")
+	})
+}
+
+// Delete removes the key, or returns ErrCacheMiss if it is not found
+func (c *client) Delete(ctx context.Context, key string) error {
+	return c.withKeyConn(ctx, key, func(cn *conn) error {
+		return command(cn, "delete "+key, "DELETED")
+	})
+}
+
+// Ping checks that every server is responding
+func (c *client) Ping(ctx context.Context) error {
+	for _, addr := range c.servers {
+		err := c.withConn(ctx, addr, func(cn *conn) error {
+			if _, err := io.WriteString(cn.rw, "version\r\n"); err != nil {
+				return err
+			}
+			if err := cn.rw.Flush(); err != nil {
+				return err
+			}
+			line, err := readLine(cn.rw.Reader)
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(line, "VERSION ") {
+				return responseError(line)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error pinging memcached server %s: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// store sends a storage command (set or add) with the value
+func (c *client) store(ctx context.Context, cmd, key string, value []byte, expiration time.Duration) error {
+	return c.storeCommand(ctx, key, fmt.Sprintf("%s %s 0 %d %d", cmd, key, expirationSeconds(expiration), len(value)), value)
+}
+
+// storeCommand sends the storage command line followed by the value
+func (c *client) storeCommand(ctx context.Context, key, line string, value []byte) error {
+	return c.withKeyConn(ctx, key, func(cn *conn) error {
+		if _, err := io.WriteString(cn.rw, line+"\r\n"); err != nil {
+			return err
+		}
+		if _, err := cn.rw.Write(value); err != nil {
+			return err
+		}
+		return command(cn, "", "STORED")
+	})
+}
+
+// withKeyConn validates the key and runs fn with a connection to the server
+// the key is sharded to
+func (c *client) withKeyConn(ctx context.Context, key string, fn func(*conn) error) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return c.withConn(ctx, c.pickServer(key), fn)
+}
+
+// withConn runs fn with a connection to the server.
+// The connection is reused if fn succeeds or the server returned a
+// well-formed response, otherwise it is closed.
+func (c *client) withConn(ctx context.Context, addr string, fn func(*conn) error) error {
+	cn, err := c.getConn(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(defaultTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		cn.nc.Close()
+		return err
+	}
+
+	err = fn(cn)
+	switch {
+	case err == nil, errors.Is(err, ErrCacheMiss), errors.Is(err, ErrNotStored), errors.Is(err, ErrCASConflict):
+		c.putConn(cn)
+	default:
+		cn.nc.Close()
+	}
+	return err
+}
+
+// pickServer returns the server a key is sharded to
+func (c *client) pickServer(key string) string {
+	if len(c.servers) == 1 {
+		return c.servers[0]
+	}
+	return c.servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(c.servers))]
+}
+
+// getConn returns an idle connection to the server, or dials a new one
+func (c *client) getConn(ctx context.Context, addr string) (*conn, error) {
+	c.mu.Lock()
+	if idle := c.idle[addr]; len(idle) > 0 {
+		cn := idle[len(idle)-1]
+		c.idle[addr] = idle[:len(idle)-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	dialCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	nc, err := c.dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{
+		addr: addr,
+		nc:   nc,
+		rw:   bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+	}, nil
+}
+
+// putConn returns a connection to the idle pool, closing it if the pool
+// is full
+func (c *client) putConn(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[cn.addr]) >= maxIdleConnsPerServer {
+		cn.nc.Close()
+		return
+	}
+	c.idle[cn.addr] = append(c.idle[cn.addr], cn)
+}
+
+// Close closes all idle connections
+func (c *client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, idle := range c.idle {
+		for _, cn := range idle {
+			cn.nc.Close()
+		}
+		delete(c.idle, addr)
+	}
+	return nil
+}
+
+// command sends the command line (if any) and checks the server responds
+// with the expected reply
+func command(cn *conn, line, expected string) error {
+	if line != "" {
+		if _, err := io.WriteString(cn.rw, line); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(cn.rw, "\r\n"); err != nil {
+		return err
+	}
+	if err := cn.rw.Flush(); err != nil {
+		return err
+	}
+
+	reply, err := readLine(cn.rw.Reader)
+	if err != nil {
+		return err
+	}
+	if reply == expected {
+		return nil
+	}
+	return responseError(reply)
+}
+
+// readValue reads the response to a get or gets command for a single key,
+// the CAS unique is only returned in response to gets
+func readValue(r *bufio.Reader, key string) ([]byte, uint64, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if line == "END" {
+		return nil, 0, ErrCacheMiss
+	}
+
+	// VALUE <key> <flags> <bytes> [<cas unique>]
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "VALUE" {
+		return nil, 0, responseError(line)
+	}
+	if fields[1] != key {
+		return nil, 0, fmt.Errorf("memcached: unexpected key %q in response", fields[1])
+	}
+	size, err := strconv.Atoi(fields[3])
+	if err != nil || size < 0 {
+		return nil, 0, fmt.Errorf("memcached: invalid value length in response %q", line)
+	}
+	var cas uint64
+	if len(fields) > 4 {
+		if cas, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("memcached: invalid cas unique in response %q", line)
+		}
+	}
+
+	value := make([]byte, size+2)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.HasSuffix(value, []byte("\r\n")) {
+		return nil, 0, errors.New("memcached: corrupt value in response")
+	}
+
+	if line, err = readLine(r); err != nil {
+		return nil, 0, err
+	}
+	if line != "END" {
+		return nil, 0, responseError(line)
+	}
+	return value[:size], cas, nil
+}
+
+// readLine reads a single response line without the trailing CRLF
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// responseError converts an unexpected reply into an error
+func responseError(reply string) error {
+	switch {
+	case reply == "NOT_FOUND":
+		return ErrCacheMiss
+	case reply == "NOT_STORED":
+		return ErrNotStored
+	case reply == "EXISTS":
+		return ErrCASConflict
+	case strings.HasPrefix(reply, "CLIENT_ERROR "), strings.HasPrefix(reply, "SERVER_ERROR "):
+		return fmt.Errorf("memcached: %s", reply)
+	default:
+		return fmt.Errorf("memcached: unexpected response %q", reply)
+	}
+}
+
+// validateKey checks the key can be sent in the text protocol
+func validateKey(key string) error {
+	if key == "" || len(key) > maxKeyLength {
+		return fmt.Errorf("memcached: key must be between 1 and %d bytes", maxKeyLength)
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("memcached: key %q contains whitespace or control characters", key)
+		}
+	}
+	return nil
+}
+
+// expirationSeconds converts the expiration to the memcached exptime.
+// Zero means the item never expires, so positive expirations are rounded up
+// to at least a second. Expirations longer than 30 days are sent as a unix
+// timestamp.
+func expirationSeconds(expiration time.Duration) int64 {
+	if expiration <= 0 {
+		return 0
+	}
+	seconds := int64(math.Ceil(expiration.Seconds()))
+	if expiration > maxRelativeExpiration {
+		return time.Now().Unix() + seconds
+	}
+	return seconds
+}
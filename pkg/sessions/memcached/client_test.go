@@ -0,0 +1,137 @@
+package memcached
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memcached Client Tests", func() {
+	var servers []*fakeServer
+	var c Client
+	var ctx context.Context
+
+	BeforeEach(func() {
+		servers = []*fakeServer{newFakeServer(), newFakeServer()}
+		ctx = context.Background()
+
+		var err error
+		c, err = NewMemcachedClient([]string{servers[0].Addr(), servers[1].Addr()})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(c.(*client).Close()).To(Succeed())
+		for _, server := range servers {
+			server.Close()
+		}
+	})
+
+	It("stores and retrieves values", func() {
+		Expect(c.Set(ctx, "key", []byte("value\r\nwith a line break"), time.Minute)).To(Succeed())
+		Expect(c.Get(ctx, "key")).To(Equal([]byte("value\r\nwith a line break")))
+
+		Expect(c.Delete(ctx, "key")).To(Succeed())
+		_, err := c.Get(ctx, "key")
+		Expect(err).To(Equal(ErrCacheMiss))
+		Expect(c.Delete(ctx, "key")).To(Equal(ErrCacheMiss))
+	})
+
+	It("only adds values that do not exist", func() {
+		Expect(c.Add(ctx, "key", []byte("first"), time.Minute)).To(Succeed())
+		Expect(c.Add(ctx, "key", []byte("second"), time.Minute)).To(Equal(ErrNotStored))
+		Expect(c.Get(ctx, "key")).To(Equal([]byte("first")))
+	})
+
+	It("only swaps and deletes values that were not modified", func() {
+		Expect(c.Set(ctx, "key", []byte("first"), time.Minute)).To(Succeed())
+		_, stale, err := c.Gets(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(c.Set(ctx, "key", []byte("second"), time.Minute)).To(Succeed())
+		Expect(c.CompareAndSwap(ctx, "key", []byte("third"), time.Minute, stale)).To(Equal(ErrCASConflict))
+		Expect(c.CompareAndDelete(ctx, "key", stale)).To(Equal(ErrCASConflict))
+
+		value, cas, err := c.Gets(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+		Expect(c.CompareAndSwap(ctx, "key", []byte("third"), time.Minute, cas)).To(Succeed())
+		Expect(c.Get(ctx, "key")).To(Equal([]byte("third")))
+
+		_, cas, err = c.Gets(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.CompareAndDelete(ctx, "key", cas)).To(Succeed())
+		_, _, err = c.Gets(ctx, "key")
+		Expect(err).To(Equal(ErrCacheMiss))
+		Expect(c.CompareAndDelete(ctx, "key", cas)).To(Equal(ErrCacheMiss))
+	})
+
+	It("expires values", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(c.Touch(ctx, "key", 2*time.Minute)).To(Succeed())
+
+		for _, server := range servers {
+			Expect(server.FastForward(time.Minute)).To(Succeed())
+		}
+		Expect(c.Get(ctx, "key")).To(Equal([]byte("value")))
+
+		for _, server := range servers {
+			Expect(server.FastForward(time.Minute)).To(Succeed())
+		}
+		_, err := c.Get(ctx, "key")
+		Expect(err).To(Equal(ErrCacheMiss))
+		Expect(c.Touch(ctx, "key", time.Minute)).To(Equal(ErrCacheMiss))
+	})
+
+	It("shards keys across the servers", func() {
+		for i := 0; i < 20; i++ {
+			Expect(c.Set(ctx, fmt.Sprintf("key-%d", i), []byte("value"), time.Minute)).To(Succeed())
+		}
+		Expect(servers[0].Keys()).ToNot(BeEmpty())
+		Expect(servers[1].Keys()).ToNot(BeEmpty())
+		Expect(len(servers[0].Keys()) + len(servers[1].Keys())).To(Equal(20))
+
+		// Each key is always sent to the same server
+		for _, key := range servers[0].Keys() {
+			Expect(servers[1].Keys()).ToNot(ContainElement(key))
+		}
+	})
+
+	It("pings every server", func() {
+		Expect(c.Ping(ctx)).To(Succeed())
+
+		unreachable, err := NewMemcachedClient([]string{servers[0].Addr(), "127.0.0.1:65535"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unreachable.Ping(ctx)).To(MatchError(ContainSubstring("error pinging memcached server 127.0.0.1:65535")))
+	})
+
+	DescribeTable("rejects invalid keys",
+		func(key string) {
+			Expect(c.Set(ctx, key, []byte("value"), time.Minute)).ToNot(Succeed())
+			Expect(servers[0].Keys()).To(BeEmpty())
+			Expect(servers[1].Keys()).To(BeEmpty())
+		},
+		Entry("with an empty key", ""),
+		Entry("with a space", "invalid key"),
+		Entry("with a line break", "invalid\r\nkey"),
+		Entry("with a long key", string(make([]byte, maxKeyLength+1))),
+	)
+
+	DescribeTable("expirationSeconds",
+		func(expiration time.Duration, expected int64) {
+			Expect(expirationSeconds(expiration)).To(Equal(expected))
+		},
+		Entry("with no expiration", time.Duration(0), int64(0)),
+		Entry("with less than a second", 100*time.Millisecond, int64(1)),
+		Entry("with a minute", time.Minute, int64(60)),
+		Entry("with 30 days", maxRelativeExpiration, int64(2592000)),
+	)
+
+	It("sends expirations longer than 30 days as a unix timestamp", func() {
+		Expect(expirationSeconds(31 * 24 * time.Hour)).To(BeNumerically("~", time.Now().Add(31*24*time.Hour).Unix(), 1))
+	})
+})
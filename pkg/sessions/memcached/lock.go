@@ -0,0 +1,117 @@
+package memcached
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+const LockSuffix = "lock"
+
+// Lock is a distributed lock stored in memcached.
+// The lock is obtained by adding a random token at the lock key, which
+// only succeeds when the key does not already exist.
+type Lock struct {
+	client Client
+	key    string
+	token  []byte
+}
+
+// NewLock instantiate a new lock instance. This will not yet apply a lock on memcached side.
+// For that you have to call Obtain(ctx context.Context, expiration time.Duration)
+func NewLock(client Client, key string) sessions.Lock {
+	return &Lock{
+		client: client,
+		key:    key,
+	}
+}
+
+// Obtain obtains a distributed lock on memcached for the configured key.
+func (l *Lock) Obtain(ctx context.Context, expiration time.Duration) error {
+	token, err := encryption.Nonce(16)
+	if err != nil {
+		return fmt.Errorf("error generating lock token: %v", err)
+	}
+	err = l.client.Add(ctx, l.lockKey(), token, expiration)
+	if errors.Is(err, ErrNotStored) {
+		return sessions.ErrLockNotObtained
+	}
+	if err != nil {
+		return err
+	}
+	l.token = token
+	return nil
+}
+
+// Refresh refreshes an already existing lock.
+func (l *Lock) Refresh(ctx context.Context, expiration time.Duration) error {
+	cas, err := l.checkHeld(ctx)
+	if err != nil {
+		return err
+	}
+	err = l.client.CompareAndSwap(ctx, l.lockKey(), l.token, expiration, cas)
+	if errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrCASConflict) {
+		return sessions.ErrNotLocked
+	}
+	return err
+}
+
+// Peek returns true, if the lock is still applied.
+func (l *Lock) Peek(ctx context.Context) (bool, error) {
+	_, err := l.client.Get(ctx, l.lockKey())
+	if errors.Is(err, ErrCacheMiss) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release releases the lock on memcached side.
+// The lock key is only deleted if it was not modified since the token was
+// checked, so that a lock that expired and was obtained by another request
+// in the meantime is not released.
+func (l *Lock) Release(ctx context.Context) error {
+	cas, err := l.checkHeld(ctx)
+	if err != nil {
+		return err
+	}
+	err = l.client.CompareAndDelete(ctx, l.lockKey(), cas)
+	if errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrCASConflict) {
+		return sessions.ErrNotLocked
+	}
+	if err != nil {
+		return err
+	}
+	l.token = nil
+	return nil
+}
+
+// checkHeld returns ErrNotLocked unless the lock key still holds the token
+// from when this lock was obtained, otherwise the CAS unique of the lock key
+func (l *Lock) checkHeld(ctx context.Context) (uint64, error) {
+	if l.token == nil {
+		return 0, sessions.ErrNotLocked
+	}
+	value, cas, err := l.client.Gets(ctx, l.lockKey())
+	if errors.Is(err, ErrCacheMiss) {
+		return 0, sessions.ErrNotLocked
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(value, l.token) {
+		return 0, sessions.ErrNotLocked
+	}
+	return cas, nil
+}
+
+func (l *Lock) lockKey() string {
+	return fmt.Sprintf("%s.%s", l.key, LockSuffix)
+}
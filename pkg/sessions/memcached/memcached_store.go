@@ -0,0 +1,75 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+)
+
+// SessionStore is an implementation of the persistence.Store
+// interface that stores sessions in memcached
+type SessionStore struct {
+	Client Client
+}
+
+// NewMemcachedSessionStore initialises a new instance of the SessionStore and wraps
+// it in a persistence.Manager
+func NewMemcachedSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	client, err := NewMemcachedClient(opts.Memcached.Servers)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing memcached client: %v", err)
+	}
+
+	ms := &SessionStore{
+		Client: client,
+	}
+	manager := persistence.NewManager(ms, cookieOpts)
+	manager.Compression = opts.Compression
+	return manager, nil
+}
+
+// Save takes a sessions.SessionState and stores the information from it
+// to memcached, and adds a new persistence cookie on the HTTP response writer
+func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	err := store.Client.Set(ctx, key, value, exp)
+	if err != nil {
+		return fmt.Errorf("error saving memcached session: %v", err)
+	}
+	return nil
+}
+
+// Load reads sessions.SessionState information from a persistence
+// cookie within the HTTP request object
+func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
+	value, err := store.Client.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error loading memcached session: %v", err)
+	}
+	return value, nil
+}
+
+// Clear clears any saved session information for a given persistence cookie
+// from memcached, and then clears the session
+func (store *SessionStore) Clear(ctx context.Context, key string) error {
+	err := store.Client.Delete(ctx, key)
+	if err != nil && !errors.Is(err, ErrCacheMiss) {
+		return fmt.Errorf("error clearing the session from memcached: %v", err)
+	}
+	return nil
+}
+
+// Lock creates a lock object for sessions.SessionState
+func (store *SessionStore) Lock(key string) sessions.Lock {
+	return NewLock(store.Client, key)
+}
+
+// VerifyConnection verifies the connection to each memcached server is
+// valid and the servers are responsive
+func (store *SessionStore) VerifyConnection(ctx context.Context) error {
+	return store.Client.Ping(ctx)
+}
@@ -0,0 +1,135 @@
+package memcached
+
+import (
+	"context"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memcached SessionStore Tests", func() {
+	Context("with a mock client", func() {
+		var mc *mockClient
+
+		BeforeEach(func() {
+			mc = newMockClient()
+		})
+
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				manager := persistence.NewManager(&SessionStore{Client: mc}, cookieOpts)
+				manager.Compression = opts.Compression
+				return manager, nil
+			},
+			func(d time.Duration) error {
+				return mc.FastForward(d)
+			},
+		)
+
+		It("clears sessions that do not exist", func() {
+			store := &SessionStore{Client: mc}
+			Expect(store.Clear(context.Background(), "missing")).To(Succeed())
+		})
+
+		It("does not obtain a lock held by another session", func() {
+			store := &SessionStore{Client: mc}
+			first := store.Lock("session")
+			second := store.Lock("session")
+
+			Expect(first.Obtain(context.Background(), time.Minute)).To(Succeed())
+			Expect(second.Obtain(context.Background(), time.Minute)).To(Equal(sessionsapi.ErrLockNotObtained))
+			Expect(second.Release(context.Background())).To(Equal(sessionsapi.ErrNotLocked))
+
+			Expect(first.Release(context.Background())).To(Succeed())
+			Expect(second.Obtain(context.Background(), time.Minute)).To(Succeed())
+		})
+
+		It("does not release or refresh a lock obtained by another session after checking the token", func() {
+			ctx := context.Background()
+			racing := &racingClient{mockClient: mc}
+			first := (&SessionStore{Client: racing}).Lock("session")
+			second := (&SessionStore{Client: mc}).Lock("session")
+			Expect(first.Obtain(ctx, time.Second)).To(Succeed())
+
+			// The lock expires and is obtained by the second session between
+			// the token check and the update of the lock
+			racing.afterGets = func() {
+				Expect(mc.FastForward(time.Minute)).To(Succeed())
+				Expect(second.Obtain(ctx, time.Minute)).To(Succeed())
+			}
+			Expect(first.Release(ctx)).To(Equal(sessionsapi.ErrNotLocked))
+			Expect(second.Peek(ctx)).To(BeTrue())
+
+			Expect(second.Release(ctx)).To(Succeed())
+			Expect(first.Obtain(ctx, time.Second)).To(Succeed())
+			racing.afterGets = func() {
+				Expect(mc.FastForward(time.Minute)).To(Succeed())
+				Expect(second.Obtain(ctx, time.Minute)).To(Succeed())
+			}
+			Expect(first.Refresh(ctx, time.Hour)).To(Equal(sessionsapi.ErrNotLocked))
+			Expect(second.Refresh(ctx, time.Minute)).To(Succeed())
+		})
+	})
+
+	Context("with memcached servers", func() {
+		var servers []*fakeServer
+		var ss sessionsapi.SessionStore
+
+		BeforeEach(func() {
+			servers = []*fakeServer{newFakeServer(), newFakeServer()}
+		})
+
+		AfterEach(func() {
+			for _, server := range servers {
+				server.Close()
+			}
+		})
+
+		JustAfterEach(func() {
+			// Release any connections immediately after the test ends
+			if manager, ok := ss.(*persistence.Manager); ok {
+				Expect(manager.Store.(*SessionStore).Client.(*client).Close()).To(Succeed())
+			}
+		})
+
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				opts.Type = options.MemcachedSessionStoreType
+				opts.Memcached.Servers = []string{servers[0].Addr(), servers[1].Addr()}
+
+				// Capture the session store so that we can close the client
+				var err error
+				ss, err = NewMemcachedSessionStore(opts, cookieOpts)
+				return ss, err
+			},
+			func(d time.Duration) error {
+				for _, server := range servers {
+					Expect(server.FastForward(d)).To(Succeed())
+				}
+				return nil
+			},
+		)
+	})
+})
+
+// racingClient is a mockClient that calls afterGets once after the next Gets,
+// to modify the key while the caller holds its CAS unique
+type racingClient struct {
+	*mockClient
+	afterGets func()
+}
+
+func (c *racingClient) Gets(ctx context.Context, key string) ([]byte, uint64, error) {
+	value, cas, err := c.mockClient.Gets(ctx, key)
+	if c.afterGets != nil {
+		afterGets := c.afterGets
+		c.afterGets = nil
+		afterGets()
+	}
+	return value, cas, err
+}
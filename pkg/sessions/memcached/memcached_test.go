@@ -0,0 +1,366 @@
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemcached(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+	logger.SetErrOutput(GinkgoWriter)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memcached")
+}
+
+// mockItem is a mockClient value with the elapsed time it expires at and
+// its CAS unique
+type mockItem struct {
+	value     []byte
+	expiresAt time.Duration
+	cas       uint64
+}
+
+// mockClient is an in-memory implementation of Client for tests.
+// Time only passes when FastForward is called.
+type mockClient struct {
+	mu      sync.Mutex
+	items   map[string]mockItem
+	elapsed time.Duration
+	lastCAS uint64
+}
+
+var _ Client = (*mockClient)(nil)
+
+func newMockClient() *mockClient {
+	return &mockClient{
+		items: map[string]mockItem{},
+	}
+}
+
+func (c *mockClient) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.lookup(key)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return item.value, nil
+}
+
+func (c *mockClient) Gets(_ context.Context, key string) ([]byte, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.lookup(key)
+	if !ok {
+		return nil, 0, ErrCacheMiss
+	}
+	return item.value, item.cas, nil
+}
+
+func (c *mockClient) Set(_ context.Context, key string, value []byte, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(key, value, expiration)
+	return nil
+}
+
+func (c *mockClient) Add(_ context.Context, key string, value []byte, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lookup(key); ok {
+		return ErrNotStored
+	}
+	c.store(key, value, expiration)
+	return nil
+}
+
+func (c *mockClient) CompareAndSwap(_ context.Context, key string, value []byte, expiration time.Duration, cas uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkCAS(key, cas); err != nil {
+		return err
+	}
+	c.store(key, value, expiration)
+	return nil
+}
+
+func (c *mockClient) CompareAndDelete(_ context.Context, key string, cas uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkCAS(key, cas); err != nil {
+		return err
+	}
+	delete(c.items, key)
+	return nil
+}
+
+func (c *mockClient) Touch(_ context.Context, key string, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.lookup(key)
+	if !ok {
+		return ErrCacheMiss
+	}
+	item.expiresAt = c.expiresAt(expiration)
+	c.items[key] = item
+	return nil
+}
+
+func (c *mockClient) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lookup(key); !ok {
+		return ErrCacheMiss
+	}
+	delete(c.items, key)
+	return nil
+}
+
+func (c *mockClient) Ping(_ context.Context) error {
+	return nil
+}
+
+// FastForward simulates the flow of time to test expirations
+func (c *mockClient) FastForward(d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.elapsed += d
+	return nil
+}
+
+// lookup returns the item if it exists and has not expired.
+// The lock must be held when calling lookup.
+func (c *mockClient) lookup(key string) (mockItem, bool) {
+	item, ok := c.items[key]
+	if !ok {
+		return mockItem{}, false
+	}
+	if item.expiresAt > 0 && item.expiresAt <= c.elapsed {
+		delete(c.items, key)
+		return mockItem{}, false
+	}
+	return item, true
+}
+
+// store replaces the item with a new CAS unique.
+// The lock must be held when calling store.
+func (c *mockClient) store(key string, value []byte, expiration time.Duration) {
+	c.lastCAS++
+	c.items[key] = mockItem{value: value, expiresAt: c.expiresAt(expiration), cas: c.lastCAS}
+}
+
+// checkCAS returns ErrCASConflict unless the item still has the CAS unique.
+// The lock must be held when calling checkCAS.
+func (c *mockClient) checkCAS(key string, cas uint64) error {
+	item, ok := c.lookup(key)
+	if !ok {
+		return ErrCacheMiss
+	}
+	if item.cas != cas {
+		return ErrCASConflict
+	}
+	return nil
+}
+
+func (c *mockClient) expiresAt(expiration time.Duration) time.Duration {
+	if expiration <= 0 {
+		return 0
+	}
+	return c.elapsed + expiration
+}
+
+// fakeServer serves the memcached text protocol from a mockClient so that
+// the real client can be tested without a memcached server
+type fakeServer struct {
+	*mockClient
+	listener net.Listener
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func newFakeServer() *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	s := &fakeServer{
+		mockClient: newMockClient(),
+		listener:   listener,
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) Close() {
+	Expect(s.listener.Close()).To(Succeed())
+}
+
+// Keys returns the keys of every command the server has received
+func (s *fakeServer) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.keys...)
+}
+
+func (s *fakeServer) serve() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(nc)
+	}
+}
+
+func (s *fakeServer) handle(nc net.Conn) {
+	defer nc.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+
+	for {
+		line, err := readLine(rw.Reader)
+		if err != nil {
+			return
+		}
+		reply, err := s.dispatch(rw.Reader, strings.Fields(line))
+		if err != nil {
+			reply = "CLIENT_ERROR " + err.Error() + "\r\n"
+		}
+		if _, err := io.WriteString(rw, reply); err != nil {
+			return
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) dispatch(r *bufio.Reader, fields []string) (string, error) {
+	ctx := context.Background()
+	if len(fields) == 0 {
+		return "ERROR\r\n", nil
+	}
+	if len(fields) > 1 {
+		s.mu.Lock()
+		s.keys = append(s.keys, fields[1])
+		s.mu.Unlock()
+	}
+
+	switch {
+	case fields[0] == "version":
+		return "VERSION 1.6.0\r\n", nil
+	case fields[0] == "get" && len(fields) == 2:
+		value, err := s.Get(ctx, fields[1])
+		if errors.Is(err, ErrCacheMiss) {
+			return "END\r\n", nil
+		}
+		return fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\nEND\r\n", fields[1], len(value), value), nil
+	case fields[0] == "gets" && len(fields) == 2:
+		value, cas, err := s.Gets(ctx, fields[1])
+		if errors.Is(err, ErrCacheMiss) {
+			return "END\r\n", nil
+		}
+		return fmt.Sprintf("VALUE %s 0 %d %d\r\n%s\r\nEND\r\n", fields[1], len(value), cas, value), nil
+	case fields[0] == "cas" && len(fields) == 6:
+		size, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return "", err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		expiration, err := parseExpiration(fields[3])
+		if err != nil {
+			return "", err
+		}
+		cas, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		// A negative expiration expires the item immediately
+		if expiration < 0 {
+			err = s.CompareAndDelete(ctx, fields[1], cas)
+		} else {
+			err = s.CompareAndSwap(ctx, fields[1], data[:size], expiration, cas)
+		}
+		switch {
+		case errors.Is(err, ErrCacheMiss):
+			return "NOT_FOUND\r\n", nil
+		case errors.Is(err, ErrCASConflict):
+			return "EXISTS\r\n", nil
+		}
+		return "STORED\r\n", nil
+	case (fields[0] == "set" || fields[0] == "add") && len(fields) == 5:
+		size, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return "", err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		expiration, err := parseExpiration(fields[3])
+		if err != nil {
+			return "", err
+		}
+		store := s.Set
+		if fields[0] == "add" {
+			store = s.Add
+		}
+		if errors.Is(store(ctx, fields[1], data[:size], expiration), ErrNotStored) {
+			return "NOT_STORED\r\n", nil
+		}
+		return "STORED\r\n", nil
+	case fields[0] == "touch" && len(fields) == 3:
+		expiration, err := parseExpiration(fields[2])
+		if err != nil {
+			return "", err
+		}
+		if errors.Is(s.Touch(ctx, fields[1], expiration), ErrCacheMiss) {
+			return "NOT_FOUND\r\n", nil
+		}
+		return "TOUCHED\r\n", nil
+	case fields[0] == "delete" && len(fields) == 2:
+		if errors.Is(s.Delete(ctx, fields[1]), ErrCacheMiss) {
+			return "NOT_FOUND\r\n", nil
+		}
+		return "DELETED\r\n", nil
+	default:
+		return "ERROR\r\n", nil
+	}
+}
+
+func parseExpiration(exptime string) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(exptime, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,137 @@
+package sessions
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	operationSave  = "save"
+	operationLoad  = "load"
+	operationClear = "clear"
+)
+
+// instrumentedSessionStore wraps a SessionStore to record the duration and
+// errors of each operation, and a span for each operation.
+type instrumentedSessionStore struct {
+	sessions.SessionStore
+
+	backend  string
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewInstrumentedSessionStore wraps the SessionStore so that the duration and
+// errors of Save, Load and Clear operations are recorded to the provided
+// prometheus.Registerer, labelled by the backend name.
+// Each operation is also recorded as a span of the request's trace.
+func NewInstrumentedSessionStore(store sessions.SessionStore, backend string, registerer prometheus.Registerer) sessions.SessionStore {
+	return &instrumentedSessionStore{
+		SessionStore: store,
+		backend:      backend,
+		duration:     registerOperationDurationHistogram(registerer),
+		errors:       registerOperationErrorsCounter(registerer),
+	}
+}
+
+// Save records metrics around saving the session in the wrapped store.
+func (s *instrumentedSessionStore) Save(rw http.ResponseWriter, req *http.Request, ss *sessions.SessionState) error {
+	span := s.startSpan(req, operationSave)
+	start := time.Now()
+	err := s.SessionStore.Save(rw, req, ss)
+	s.observe(operationSave, start, err, span)
+	return err
+}
+
+// Load records metrics around loading the session from the wrapped store.
+func (s *instrumentedSessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
+	span := s.startSpan(req, operationLoad)
+	start := time.Now()
+	ss, err := s.SessionStore.Load(req)
+	s.observe(operationLoad, start, err, span)
+	return ss, err
+}
+
+// Clear records metrics around clearing the session from the wrapped store.
+func (s *instrumentedSessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
+	span := s.startSpan(req, operationClear)
+	start := time.Now()
+	err := s.SessionStore.Clear(rw, req)
+	s.observe(operationClear, start, err, span)
+	return err
+}
+
+// startSpan starts the span of the operation as a child of the request's span
+func (s *instrumentedSessionStore) startSpan(req *http.Request, operation string) trace.Span {
+	_, span := tracing.Tracer().Start(req.Context(), "session_store."+operation,
+		trace.WithAttributes(attribute.String("session_store.backend", s.backend)),
+	)
+	return span
+}
+
+// observe records the duration of the operation and counts any error, then
+// ends its span.
+// A missing session cookie is expected for unauthenticated requests and is
+// not counted as an error.
+func (s *instrumentedSessionStore) observe(operation string, start time.Time, err error, span trace.Span) {
+	defer span.End()
+	s.duration.WithLabelValues(operation, s.backend).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, http.ErrNoCookie) {
+		s.errors.WithLabelValues(operation, s.backend).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// registerOperationDurationHistogram registers 'oauth2_proxy_session_store_operation_duration_seconds'
+// This keeps tally of session store operations bucketed by the time taken
+func registerOperationDurationHistogram(registerer prometheus.Registerer) *prometheus.HistogramVec {
+	histogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "oauth2_proxy_session_store_operation_duration_seconds",
+			Help:    "A histogram of session store operation latencies.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation", "backend"},
+	)
+
+	if err := registerer.Register(histogram); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			histogram = are.ExistingCollector.(*prometheus.HistogramVec)
+		} else {
+			panic(err)
+		}
+	}
+
+	return histogram
+}
+
+// registerOperationErrorsCounter registers 'oauth2_proxy_session_store_errors_total'
+// This keeps a tally of failed session store operations
+func registerOperationErrorsCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oauth2_proxy_session_store_errors_total",
+			Help: "Total number of failed session store operations.",
+		},
+		[]string{"operation", "backend"},
+	)
+
+	if err := registerer.Register(counter); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counter = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			panic(err)
+		}
+	}
+
+	return counter
+}
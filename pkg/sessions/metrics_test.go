@@ -0,0 +1,108 @@
+package sessions_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSessionStore returns the configured error from every operation
+type fakeSessionStore struct {
+	err error
+}
+
+func (f *fakeSessionStore) Save(_ http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+	return f.err
+}
+
+func (f *fakeSessionStore) Load(_ *http.Request) (*sessionsapi.SessionState, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sessionsapi.SessionState{}, nil
+}
+
+func (f *fakeSessionStore) Clear(_ http.ResponseWriter, _ *http.Request) error {
+	return f.err
+}
+
+func (f *fakeSessionStore) VerifyConnection(_ context.Context) error {
+	return f.err
+}
+
+var _ = Describe("NewInstrumentedSessionStore", func() {
+	var registry *prometheus.Registry
+	var fake *fakeSessionStore
+	var store sessionsapi.SessionStore
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		fake = &fakeSessionStore{}
+		store = sessions.NewInstrumentedSessionStore(fake, "fake", registry)
+	})
+
+	runOperations := func() {
+		req := httptest.NewRequest("", "/", nil)
+		_ = store.Save(httptest.NewRecorder(), req, &sessionsapi.SessionState{})
+		_, _ = store.Load(req)
+		_ = store.Clear(httptest.NewRecorder(), req)
+	}
+
+	It("records the duration of each operation", func() {
+		runOperations()
+
+		count, err := testutil.GatherAndCount(registry, "oauth2_proxy_session_store_operation_duration_seconds")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(3))
+	})
+
+	It("does not count errors when operations succeed", func() {
+		runOperations()
+
+		count, err := testutil.GatherAndCount(registry, "oauth2_proxy_session_store_errors_total")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(0))
+	})
+
+	It("counts errors when operations fail", func() {
+		fake.err = errors.New("connection refused")
+		runOperations()
+		runOperations()
+
+		expected := `
+# HELP oauth2_proxy_session_store_errors_total Total number of failed session store operations.
+# TYPE oauth2_proxy_session_store_errors_total counter
+oauth2_proxy_session_store_errors_total{backend="fake",operation="clear"} 2
+oauth2_proxy_session_store_errors_total{backend="fake",operation="load"} 2
+oauth2_proxy_session_store_errors_total{backend="fake",operation="save"} 2
+`
+		Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected), "oauth2_proxy_session_store_errors_total")).To(Succeed())
+	})
+
+	It("does not count a missing session cookie as an error", func() {
+		fake.err = http.ErrNoCookie
+		_, err := store.Load(httptest.NewRequest("", "/", nil))
+		Expect(err).To(Equal(http.ErrNoCookie))
+
+		count, err := testutil.GatherAndCount(registry, "oauth2_proxy_session_store_errors_total")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(0))
+	})
+
+	It("returns the results of the wrapped store", func() {
+		fake.err = errors.New("connection refused")
+		Expect(store.VerifyConnection(context.Background())).To(MatchError("connection refused"))
+
+		_, err := store.Load(httptest.NewRequest("", "/", nil))
+		Expect(err).To(MatchError("connection refused"))
+	})
+})
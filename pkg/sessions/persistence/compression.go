@@ -0,0 +1,108 @@
+package persistence
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+// compressionMarker prefixes compressed session values, followed by a byte
+// identifying the compression algorithm.
+// 0xc1 is never used in MessagePack so it cannot be the first byte of an
+// uncompressed session, this allows sessions stored before compression was
+// enabled to still be loaded.
+const compressionMarker byte = 0xc1
+
+const (
+	compressionAlgorithmGzip byte = 0x01
+)
+
+// compressingCipher wraps a Cipher and compresses session values before they
+// are encrypted. Decrypted values are only decompressed if they are prefixed
+// with the compressionMarker.
+type compressingCipher struct {
+	encryption.Cipher
+
+	compression string
+}
+
+// newCompressingCipher wraps the cipher to compress values with the given
+// compression algorithm.
+func newCompressingCipher(c encryption.Cipher, compression string) encryption.Cipher {
+	return &compressingCipher{
+		Cipher:      c,
+		compression: compression,
+	}
+}
+
+// Encrypt compresses the value and then encrypts it with the wrapped Cipher.
+func (c *compressingCipher) Encrypt(value []byte) ([]byte, error) {
+	compressed, err := compress(c.compression, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cipher.Encrypt(compressed)
+}
+
+// Decrypt decrypts the value with the wrapped Cipher and then decompresses it
+// if it was compressed.
+func (c *compressingCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	value, err := c.Cipher.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(value)
+}
+
+// compress compresses the value using the compression algorithm.
+// Values are returned unchanged when compression is disabled.
+func compress(compression string, value []byte) ([]byte, error) {
+	switch compression {
+	case "", options.SessionStoreCompressionNone:
+		return value, nil
+	case options.SessionStoreCompressionGzip:
+		buf := bytes.NewBuffer([]byte{compressionMarker, compressionAlgorithmGzip})
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(value); err != nil {
+			return nil, fmt.Errorf("error writing gzip stream: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("error closing gzip writer: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown session compression %q", compression)
+	}
+}
+
+// decompress decompresses a value prefixed with the compressionMarker.
+// Values without the marker are returned unchanged.
+func decompress(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != compressionMarker {
+		return value, nil
+	}
+	if len(value) < 2 {
+		return nil, fmt.Errorf("compressed session is missing the compression algorithm")
+	}
+
+	switch value[1] {
+	case compressionAlgorithmGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(value[2:]))
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip stream: %w", err)
+		}
+		defer zr.Close()
+
+		decompressed, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip stream: %w", err)
+		}
+		return decompressed, nil
+	default:
+		return nil, fmt.Errorf("unknown session compression algorithm %#x", value[1])
+	}
+}
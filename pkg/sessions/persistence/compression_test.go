@@ -0,0 +1,187 @@
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// largeSession creates a session with a large ID token and many groups, as
+// seen with providers that include group claims in their ID tokens.
+func largeSession() *sessions.SessionState {
+	groups := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		groups = append(groups, fmt.Sprintf("engineering-team-%03d", i))
+	}
+	return &sessions.SessionState{
+		User:         "john.doe",
+		Email:        "john.doe@example.com",
+		Groups:       groups,
+		AccessToken:  strings.Repeat("access-token.", 50),
+		IDToken:      "eyJhbGciOiJSUzI1NiJ9." + strings.Repeat(`{"groups":["`+strings.Join(groups, `","`)+`"]}`, 2),
+		RefreshToken: strings.Repeat("refresh-token.", 20),
+	}
+}
+
+var _ = Describe("Session Compression Tests", func() {
+	type roundTripTableInput struct {
+		saveCompression string
+		expectMarker    bool
+	}
+
+	DescribeTable("saveSession & loadSession round trip",
+		func(in roundTripTableInput) {
+			t, err := newTicket(&options.Cookie{Name: "dummy"})
+			Expect(err).ToNot(HaveOccurred())
+
+			ss := largeSession()
+			store := map[string][]byte{}
+			err = t.saveSession(ss, in.saveCompression, func(k string, v []byte, e time.Duration) error {
+				store[k] = v
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			c, err := t.makeCipher()
+			Expect(err).ToNot(HaveOccurred())
+			decrypted, err := c.Decrypt(store[t.id])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decrypted[0] == compressionMarker).To(Equal(in.expectMarker))
+
+			loaded, err := t.loadSession(
+				func(k string) ([]byte, error) {
+					return store[k], nil
+				},
+				func(k string) sessions.Lock {
+					return &sessions.NoOpLock{}
+				})
+			Expect(err).ToNot(HaveOccurred())
+
+			ss.Lock = &sessions.NoOpLock{}
+			Expect(loaded).To(Equal(ss))
+		},
+		Entry("with no compression set", roundTripTableInput{
+			saveCompression: "",
+			expectMarker:    false,
+		}),
+		Entry("with none", roundTripTableInput{
+			saveCompression: options.SessionStoreCompressionNone,
+			expectMarker:    false,
+		}),
+		Entry("with gzip", roundTripTableInput{
+			saveCompression: options.SessionStoreCompressionGzip,
+			expectMarker:    true,
+		}),
+	)
+
+	It("loads sessions stored before compression was enabled", func() {
+		t, err := newTicket(&options.Cookie{Name: "dummy"})
+		Expect(err).ToNot(HaveOccurred())
+
+		c, err := t.makeCipher()
+		Expect(err).ToNot(HaveOccurred())
+
+		ss := largeSession()
+		loaded, err := t.loadSession(
+			func(k string) ([]byte, error) {
+				return ss.EncodeSessionState(c, false)
+			},
+			func(k string) sessions.Lock {
+				return &sessions.NoOpLock{}
+			})
+		Expect(err).ToNot(HaveOccurred())
+
+		ss.Lock = &sessions.NoOpLock{}
+		Expect(loaded).To(Equal(ss))
+	})
+
+	It("reduces the size of large sessions", func() {
+		t, err := newTicket(&options.Cookie{Name: "dummy"})
+		Expect(err).ToNot(HaveOccurred())
+
+		sizes := map[string]int{}
+		for _, compression := range []string{options.SessionStoreCompressionNone, options.SessionStoreCompressionGzip} {
+			err := t.saveSession(largeSession(), compression, func(k string, v []byte, e time.Duration) error {
+				sizes[compression] = len(v)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(sizes[options.SessionStoreCompressionGzip]).To(BeNumerically("<", sizes[options.SessionStoreCompressionNone]/2))
+	})
+
+	It("errors on an unknown compression algorithm", func() {
+		_, err := compress("brotli", []byte("session"))
+		Expect(err).To(MatchError(`unknown session compression "brotli"`))
+
+		_, err = decompress([]byte{compressionMarker, 0xff})
+		Expect(err).To(MatchError("unknown session compression algorithm 0xff"))
+	})
+})
+
+func BenchmarkSaveSession(b *testing.B) {
+	for _, compression := range []string{options.SessionStoreCompressionNone, options.SessionStoreCompressionGzip} {
+		b.Run(compression, func(b *testing.B) {
+			t, err := newTicket(&options.Cookie{Name: "dummy"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			ss := largeSession()
+
+			var size int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := t.saveSession(ss, compression, func(k string, v []byte, e time.Duration) error {
+					size = len(v)
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(size), "stored-bytes")
+		})
+	}
+}
+
+func BenchmarkLoadSession(b *testing.B) {
+	for _, compression := range []string{options.SessionStoreCompressionNone, options.SessionStoreCompressionGzip} {
+		b.Run(compression, func(b *testing.B) {
+			t, err := newTicket(&options.Cookie{Name: "dummy"})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var stored []byte
+			err = t.saveSession(largeSession(), compression, func(k string, v []byte, e time.Duration) error {
+				stored = v
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := t.loadSession(
+					func(k string) ([]byte, error) {
+						return stored, nil
+					},
+					func(k string) sessions.Lock {
+						return &sessions.NoOpLock{}
+					})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(stored)), "stored-bytes")
+		})
+	}
+}
@@ -17,3 +17,11 @@ type Store interface {
 	Lock(key string) sessions.Lock
 	VerifyConnection(context.Context) error
 }
+
+// IndexStore is implemented by persistent stores that can keep an index of
+// keys, so that the Manager can find and clear all the sessions of a user.
+// Indexes are removed with Clear, like any other key.
+type IndexStore interface {
+	AddToIndex(ctx context.Context, index string, key string, exp time.Duration) error
+	LoadIndex(ctx context.Context, index string) ([]string, error)
+}
@@ -2,12 +2,16 @@ package persistence
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
 // Manager wraps a Store and handles the implementation details of the
@@ -15,6 +19,14 @@ import (
 type Manager struct {
 	Store   Store
 	Options *options.Cookie
+
+	// Compression is the algorithm used to compress sessions before they
+	// are persisted. Sessions are not compressed when empty.
+	Compression string
+
+	// IndexUsers enables the indexes of the sessions of each user and email,
+	// which are needed by ClearUserSessions. Sessions are not indexed when false.
+	IndexUsers bool
 }
 
 // NewManager creates a Manager that can wrap a Store and manage the
@@ -42,14 +54,92 @@ func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.Se
 		}
 	}
 
-	err = tckt.saveSession(s, func(key string, val []byte, exp time.Duration) error {
+	err = tckt.saveSession(s, m.Compression, func(key string, val []byte, exp time.Duration) error {
 		return m.Store.Save(req.Context(), key, val, exp)
 	})
 	if err != nil {
 		return err
 	}
 
-	return tckt.setCookie(rw, req, s)
+	// A session that cannot be indexed is still usable, only the admin API
+	// will not be able to clear it
+	if err := m.indexSession(req.Context(), s, tckt.id); err != nil {
+		logger.Errorf("unable to index session: %v", err)
+	}
+
+	if err := tckt.setCookie(rw, req, s); err != nil {
+		return err
+	}
+	s.SecretRotated = false
+	return nil
+}
+
+// userIndexKey returns the key of the index of the session tickets of a user,
+// the user is hashed so that it can be used in any key
+func (m *Manager) userIndexKey(user string) string {
+	hash := sha256.Sum256([]byte(user))
+	return fmt.Sprintf("%s-user-%s", m.Options.PrefixedName(), hex.EncodeToString(hash[:]))
+}
+
+// indexSession adds the session ticket to the indexes of its user and email
+// when indexing is enabled and the Store supports indexes, so that they can
+// be cleared together
+func (m *Manager) indexSession(ctx context.Context, s *sessions.SessionState, ticketID string) error {
+	if !m.IndexUsers {
+		return nil
+	}
+	store, ok := m.Store.(IndexStore)
+	if !ok {
+		return nil
+	}
+
+	for _, user := range sessionUsers(s) {
+		if err := store.AddToIndex(ctx, m.userIndexKey(user), ticketID, m.Options.Expire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionUsers returns the distinct non empty user and email of the session
+func sessionUsers(s *sessions.SessionState) []string {
+	users := []string{}
+	if s.User != "" {
+		users = append(users, s.User)
+	}
+	if s.Email != "" && s.Email != s.User {
+		users = append(users, s.Email)
+	}
+	return users
+}
+
+// ClearUserSessions clears all sessions indexed for the user or email from
+// the Store, and then the index itself. Every indexed ticket is cleared, but
+// only the sessions that still existed are counted, the index may include
+// sessions that have since expired or were cleared through the other index.
+// Returns ErrUserSessionsNotSupported when the Store does not keep indexes.
+func (m *Manager) ClearUserSessions(ctx context.Context, user string) (int, error) {
+	store, ok := m.Store.(IndexStore)
+	if !ok {
+		return 0, sessions.ErrUserSessionsNotSupported
+	}
+
+	index := m.userIndexKey(user)
+	ticketIDs, err := store.LoadIndex(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+
+	cleared := 0
+	for _, ticketID := range ticketIDs {
+		if value, err := m.Store.Load(ctx, ticketID); err == nil && len(value) > 0 {
+			cleared++
+		}
+		if err := m.Store.Clear(ctx, ticketID); err != nil {
+			return cleared, err
+		}
+	}
+	return cleared, m.Store.Clear(ctx, index)
 }
 
 // Load reads sessions.SessionState information from a session store. It will
@@ -92,6 +182,48 @@ func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
 	})
 }
 
+// stateKey returns the key the state of an authentication flow is stored
+// under, these are kept apart from the session tickets
+func (m *Manager) stateKey(id string) string {
+	return fmt.Sprintf("%s-state-%s", m.Options.PrefixedName(), id)
+}
+
+// SaveState stores the state of an authentication flow in the Store until it
+// expires
+func (m *Manager) SaveState(ctx context.Context, id string, value []byte, exp time.Duration) error {
+	return m.Store.Save(ctx, m.stateKey(id), value, exp)
+}
+
+// LoadAndClearState loads the state of an authentication flow and clears it
+// from the Store. The state is locked first so that concurrent requests with
+// the same state cannot both use it. The lock is released afterwards so that
+// it is not left in the Store until it expires.
+func (m *Manager) LoadAndClearState(ctx context.Context, id string) ([]byte, error) {
+	key := m.stateKey(id)
+
+	lock := m.Store.Lock(key)
+	if err := lock.Obtain(ctx, m.Options.CSRFExpire); err != nil {
+		if errors.Is(err, sessions.ErrLockNotObtained) {
+			return nil, errors.New("state is already being used")
+		}
+		return nil, fmt.Errorf("error locking state: %v", err)
+	}
+	defer func() {
+		if err := lock.Release(ctx); err != nil {
+			logger.Errorf("unable to release state lock: %v", err)
+		}
+	}()
+
+	value, err := m.Store.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Store.Clear(ctx, key); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
 // VerifyConnection validates the underlying store is ready and connected
 func (m *Manager) VerifyConnection(ctx context.Context) error {
 	return m.Store.VerifyConnection(ctx)
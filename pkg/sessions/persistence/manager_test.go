@@ -1,12 +1,17 @@
 package persistence
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Persistence Manager Tests", func() {
@@ -23,3 +28,119 @@ var _ = Describe("Persistence Manager Tests", func() {
 			return nil
 		})
 })
+
+var _ = Describe("Persistence Manager State Tests", func() {
+	var ms *tests.MockStore
+	var manager *Manager
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		ms = tests.NewMockStore()
+		manager = NewManager(ms, &options.Cookie{
+			Name:       "_oauth2_proxy",
+			NamePrefix: "tenant_",
+			CSRFExpire: 15 * time.Minute,
+		})
+	})
+
+	It("stores the state apart from the sessions", func() {
+		Expect(manager.SaveState(ctx, "id", []byte("state"), time.Minute)).To(Succeed())
+
+		value, err := ms.Load(ctx, "tenant__oauth2_proxy-state-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("state")))
+	})
+
+	It("loads the state only once", func() {
+		Expect(manager.SaveState(ctx, "id", []byte("state"), time.Minute)).To(Succeed())
+
+		value, err := manager.LoadAndClearState(ctx, "id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("state")))
+
+		_, err = manager.LoadAndClearState(ctx, "id")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not leave the state or its lock in the store", func() {
+		Expect(manager.SaveState(ctx, "id", []byte("state"), time.Minute)).To(Succeed())
+
+		_, err := manager.LoadAndClearState(ctx, "id")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = ms.Load(ctx, "tenant__oauth2_proxy-state-id")
+		Expect(err).To(HaveOccurred())
+		locked, err := ms.Lock("tenant__oauth2_proxy-state-id").Peek(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeFalse())
+	})
+
+	It("does not load expired state", func() {
+		Expect(manager.SaveState(ctx, "id", []byte("state"), time.Minute)).To(Succeed())
+		ms.FastForward(time.Minute)
+
+		_, err := manager.LoadAndClearState(ctx, "id")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// indexStore is a MockStore that keeps indexes, failing to add to them when
+// addErr is set
+type indexStore struct {
+	*tests.MockStore
+	indexes map[string][]string
+	addErr  error
+}
+
+func (s *indexStore) AddToIndex(_ context.Context, index string, key string, _ time.Duration) error {
+	if s.addErr != nil {
+		return s.addErr
+	}
+	s.indexes[index] = append(s.indexes[index], key)
+	return nil
+}
+
+func (s *indexStore) LoadIndex(_ context.Context, index string) ([]string, error) {
+	return s.indexes[index], nil
+}
+
+var _ = Describe("Persistence Manager Index Tests", func() {
+	var store *indexStore
+	var manager *Manager
+
+	BeforeEach(func() {
+		store = &indexStore{MockStore: tests.NewMockStore(), indexes: map[string][]string{}}
+		manager = NewManager(store, &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdefghijklmnopqrstuv",
+			Expire: time.Hour,
+		})
+	})
+
+	save := func() error {
+		session := &sessionsapi.SessionState{User: "john", Email: "john@example.com"}
+		return manager.Save(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), session)
+	}
+
+	It("does not index the sessions unless enabled", func() {
+		Expect(save()).To(Succeed())
+		Expect(store.indexes).To(BeEmpty())
+	})
+
+	It("indexes the sessions by user and email when enabled", func() {
+		manager.IndexUsers = true
+		Expect(save()).To(Succeed())
+		Expect(store.indexes).To(HaveLen(2))
+
+		count, err := manager.ClearUserSessions(context.Background(), "john@example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+	})
+
+	It("saves the session when it cannot be indexed", func() {
+		manager.IndexUsers = true
+		store.addErr = errors.New("index unavailable")
+		Expect(save()).To(Succeed())
+		Expect(store.indexes).To(BeEmpty())
+	})
+})
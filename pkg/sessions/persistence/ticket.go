@@ -41,6 +41,10 @@ type ticket struct {
 	id      string
 	secret  []byte
 	options *options.Cookie
+
+	// secretRotated is set when the ticket cookie was signed with a
+	// secondary cookie secret
+	secretRotated bool
 }
 
 // newTicket creates a new ticket. The ID & secret will be randomly created
@@ -51,7 +55,7 @@ func newTicket(cookieOpts *options.Cookie) (*ticket, error) {
 		return nil, fmt.Errorf("failed to create new ticket ID: %v", err)
 	}
 	// ticketID is hex encoded
-	ticketID := fmt.Sprintf("%s-%s", cookieOpts.Name, hex.EncodeToString(rawID))
+	ticketID := fmt.Sprintf("%s-%s", cookieOpts.PrefixedName(), hex.EncodeToString(rawID))
 
 	secret := make([]byte, aes.BlockSize)
 	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
@@ -93,30 +97,37 @@ func decodeTicket(encTicket string, cookieOpts *options.Cookie) (*ticket, error)
 // decodeTicketFromRequest retrieves a potential ticket cookie from a request
 // and decodes it to a ticket.
 func decodeTicketFromRequest(req *http.Request, cookieOpts *options.Cookie) (*ticket, error) {
-	requestCookie, err := req.Cookie(cookieOpts.Name)
+	requestCookie, err := req.Cookie(cookieOpts.PrefixedName())
 	if err != nil {
 		// Don't wrap this error to allow `err == http.ErrNoCookie` checks
 		return nil, err
 	}
 
 	// An existing cookie exists, try to retrieve the ticket
-	val, _, ok := encryption.Validate(requestCookie, cookieOpts.Secret, cookieOpts.Expire)
+	val, secret, ok := encryption.ValidateWithSecrets(requestCookie, cookieOpts.Secrets(), cookieOpts.Expire)
 	if !ok {
 		return nil, fmt.Errorf("session ticket cookie failed validation: %v", err)
 	}
 
 	// Valid cookie, decode the ticket
-	return decodeTicket(string(val), cookieOpts)
+	tckt, err := decodeTicket(string(val), cookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	tckt.secretRotated = secret > 0
+	return tckt, nil
 }
 
 // saveSession encodes the SessionState with the ticket's secret and persists
 // it to disk via the passed saveFunc.
-func (t *ticket) saveSession(s *sessions.SessionState, saver saveFunc) error {
+// The encoded session is compressed before encryption with the given
+// compression algorithm.
+func (t *ticket) saveSession(s *sessions.SessionState, compression string, saver saveFunc) error {
 	c, err := t.makeCipher()
 	if err != nil {
 		return err
 	}
-	ciphertext, err := s.EncodeSessionState(c, false)
+	ciphertext, err := s.EncodeSessionState(newCompressingCipher(c, compression), false)
 	if err != nil {
 		return fmt.Errorf("failed to encode the session state with the ticket: %v", err)
 	}
@@ -126,6 +137,7 @@ func (t *ticket) saveSession(s *sessions.SessionState, saver saveFunc) error {
 // loadSession loads a session from the disk store via the passed loadFunc
 // using the ticket.id as the key. It then decodes the SessionState using
 // ticket.secret to make the AES-GCM cipher.
+// Compressed sessions are detected and decompressed.
 // finally it appends a lock implementation
 func (t *ticket) loadSession(loader loadFunc, initLock initLockFunc) (*sessions.SessionState, error) {
 	ciphertext, err := loader(t.id)
@@ -137,12 +149,13 @@ func (t *ticket) loadSession(loader loadFunc, initLock initLockFunc) (*sessions.
 		return nil, err
 	}
 
-	sessionState, err := sessions.DecodeSessionState(ciphertext, c, false)
+	sessionState, err := sessions.DecodeSessionState(ciphertext, newCompressingCipher(c, ""), false)
 	if err != nil {
 		return nil, err
 	}
 	lock := initLock(t.id)
 	sessionState.Lock = lock
+	sessionState.SecretRotated = t.secretRotated
 	return sessionState, nil
 }
 
@@ -164,35 +177,35 @@ func (t *ticket) setCookie(rw http.ResponseWriter, req *http.Request, s *session
 		return err
 	}
 
-	http.SetCookie(rw, ticketCookie)
+	cookies.SetCookie(rw, ticketCookie, t.options)
 	return nil
 }
 
 // clearCookie removes any cookies that would be where this ticket
 // would set them
 func (t *ticket) clearCookie(rw http.ResponseWriter, req *http.Request) {
-	http.SetCookie(rw, cookies.MakeCookieFromOptions(
+	cookies.SetCookie(rw, cookies.MakeCookieFromOptions(
 		req,
-		t.options.Name,
+		t.options.PrefixedName(),
 		"",
 		t.options,
 		time.Hour*-1,
 		time.Now(),
-	))
+	), t.options)
 }
 
 // makeCookie makes a cookie, signing the value if present
 func (t *ticket) makeCookie(req *http.Request, value string, expires time.Duration, now time.Time) (*http.Cookie, error) {
 	if value != "" {
 		var err error
-		value, err = encryption.SignedValue(t.options.Secret, t.options.Name, []byte(value), now)
+		value, err = encryption.SignedValue(t.options.Secret, t.options.PrefixedName(), []byte(value), now)
 		if err != nil {
 			return nil, err
 		}
 	}
 	return cookies.MakeCookieFromOptions(
 		req,
-		t.options.Name,
+		t.options.PrefixedName(),
 		value,
 		t.options,
 		expires,
@@ -2,6 +2,8 @@ package redis
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -14,6 +16,9 @@ type Client interface {
 	Lock(key string) sessions.Lock
 	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
 	Del(ctx context.Context, key string) error
+	// SAdd adds the member to the set and sets the expiration of the set
+	SAdd(ctx context.Context, key string, member string, expiration time.Duration) error
+	SMembers(ctx context.Context, key string) ([]string, error)
 	Ping(ctx context.Context) error
 }
 
@@ -41,6 +46,19 @@ func (c *client) Del(ctx context.Context, key string) error {
 	return c.Client.Del(ctx, key).Err()
 }
 
+func (c *client) SAdd(ctx context.Context, key string, member string, expiration time.Duration) error {
+	_, err := c.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, member)
+		pipe.Expire(ctx, key, expiration)
+		return nil
+	})
+	return err
+}
+
+func (c *client) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.Client.SMembers(ctx, key).Result()
+}
+
 func (c *client) Lock(key string) sessions.Lock {
 	return NewLock(c.Client, key)
 }
@@ -73,6 +91,31 @@ func (c *clusterClient) Del(ctx context.Context, key string) error {
 	return c.ClusterClient.Del(ctx, key).Err()
 }
 
+func (c *clusterClient) SAdd(ctx context.Context, key string, member string, expiration time.Duration) error {
+	return c.txPipelined(ctx, []string{key}, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, member)
+		pipe.Expire(ctx, key, expiration)
+		return nil
+	})
+}
+
+// txPipelined runs the commands in a MULTI transaction once it has checked
+// that all their keys are in the same slot. The ClusterClient splits the
+// commands of a transaction by slot, which would break its atomicity.
+func (c *clusterClient) txPipelined(ctx context.Context, keys []string, fn func(redis.Pipeliner) error) error {
+	for _, key := range keys {
+		if keySlot(key) != keySlot(keys[0]) {
+			return fmt.Errorf("the keys %q of the transaction span more than one cluster slot", keys)
+		}
+	}
+	_, err := c.ClusterClient.TxPipelined(ctx, fn)
+	return err
+}
+
+func (c *clusterClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.ClusterClient.SMembers(ctx, key).Result()
+}
+
 func (c *clusterClient) Lock(key string) sessions.Lock {
 	return NewLock(c.ClusterClient, key)
 }
@@ -80,3 +123,29 @@ func (c *clusterClient) Lock(key string) sessions.Lock {
 func (c *clusterClient) Ping(ctx context.Context) error {
 	return c.ClusterClient.Ping(ctx).Err()
 }
+
+// clusterSlots is the number of hash slots of a redis cluster
+const clusterSlots = 16384
+
+// keySlot returns the redis cluster hash slot of the key, the CRC16 of the
+// key, or of its hash tag when the key has one, modulo the number of slots.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % clusterSlots
+}
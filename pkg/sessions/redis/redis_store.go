@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -19,6 +20,10 @@ import (
 // interface that stores sessions in redis
 type SessionStore struct {
 	Client Client
+
+	// KeyPrefix is prepended to every session and lock key so that several
+	// deployments can share one redis without their keys colliding
+	KeyPrefix string
 }
 
 // NewRedisSessionStore initialises a new instance of the SessionStore and wraps
@@ -30,15 +35,19 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 	}
 
 	rs := &SessionStore{
-		Client: client,
+		Client:    client,
+		KeyPrefix: opts.Redis.KeyPrefix,
 	}
-	return persistence.NewManager(rs, cookieOpts), nil
+	manager := persistence.NewManager(rs, cookieOpts)
+	manager.Compression = opts.Compression
+	manager.IndexUsers = opts.IndexUserSessions
+	return manager, nil
 }
 
 // Save takes a sessions.SessionState and stores the information from it
 // to redis, and adds a new persistence cookie on the HTTP response writer
 func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
-	err := store.Client.Set(ctx, key, value, exp)
+	err := store.Client.Set(ctx, store.prefixedKey(key), value, exp)
 	if err != nil {
 		return fmt.Errorf("error saving redis session: %v", err)
 	}
@@ -48,7 +57,7 @@ func (store *SessionStore) Save(ctx context.Context, key string, value []byte, e
 // Load reads sessions.SessionState information from a persistence
 // cookie within the HTTP request object
 func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
-	value, err := store.Client.Get(ctx, key)
+	value, err := store.Client.Get(ctx, store.prefixedKey(key))
 	if err != nil {
 		return nil, fmt.Errorf("error loading redis session: %v", err)
 	}
@@ -58,16 +67,39 @@ func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error)
 // Clear clears any saved session information for a given persistence cookie
 // from redis, and then clears the session
 func (store *SessionStore) Clear(ctx context.Context, key string) error {
-	err := store.Client.Del(ctx, key)
+	err := store.Client.Del(ctx, store.prefixedKey(key))
 	if err != nil {
 		return fmt.Errorf("error clearing the session from redis: %v", err)
 	}
 	return nil
 }
 
+// AddToIndex adds the key to the set of keys of the index, the index expires
+// with the most recently added key
+func (store *SessionStore) AddToIndex(ctx context.Context, index string, key string, exp time.Duration) error {
+	err := store.Client.SAdd(ctx, store.prefixedKey(index), key, exp)
+	if err != nil {
+		return fmt.Errorf("error adding to redis session index: %v", err)
+	}
+	return nil
+}
+
+// LoadIndex returns the keys of the index, without the key prefix
+func (store *SessionStore) LoadIndex(ctx context.Context, index string) ([]string, error) {
+	keys, err := store.Client.SMembers(ctx, store.prefixedKey(index))
+	if err != nil {
+		return nil, fmt.Errorf("error loading redis session index: %v", err)
+	}
+	return keys, nil
+}
+
 // Lock creates a lock object for sessions.SessionState
 func (store *SessionStore) Lock(key string) sessions.Lock {
-	return store.Client.Lock(key)
+	return store.Client.Lock(store.prefixedKey(key))
+}
+
+func (store *SessionStore) prefixedKey(key string) string {
+	return store.KeyPrefix + key
 }
 
 // VerifyConnection verifies the redis connection is valid and the
@@ -76,6 +108,10 @@ func (store *SessionStore) VerifyConnection(ctx context.Context) error {
 	return store.Client.Ping(ctx)
 }
 
+// dialer replaces the dialer of the redis clients when set, so that tests can
+// tell which addresses a client connects to
+var dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
 // NewRedisClient makes a redis.Client (either standalone, sentinel aware, or
 // redis cluster)
 func NewRedisClient(opts options.RedisStoreOptions) (Client, error) {
@@ -83,9 +119,21 @@ func NewRedisClient(opts options.RedisStoreOptions) (Client, error) {
 		return nil, fmt.Errorf("options redis-use-sentinel and redis-use-cluster are mutually exclusive")
 	}
 	if opts.UseSentinel {
+		if len(opts.ClusterConnectionURLs) > 0 {
+			return nil, fmt.Errorf("option redis-cluster-connection-urls cannot be used with redis-use-sentinel")
+		}
+		if len(opts.SentinelConnectionURLs) == 0 {
+			return nil, fmt.Errorf("option redis-use-sentinel requires at least one redis-sentinel-connection-urls")
+		}
 		return buildSentinelClient(opts)
 	}
 	if opts.UseCluster {
+		if len(opts.SentinelConnectionURLs) > 0 {
+			return nil, fmt.Errorf("option redis-sentinel-connection-urls cannot be used with redis-use-cluster")
+		}
+		if len(opts.ClusterConnectionURLs) == 0 {
+			return nil, fmt.Errorf("option redis-use-cluster requires at least one redis-cluster-connection-urls")
+		}
 		return buildClusterClient(opts)
 	}
 
@@ -111,6 +159,7 @@ func buildSentinelClient(opts options.RedisStoreOptions) (Client, error) {
 		Password:         opts.Password,
 		TLSConfig:        opt.TLSConfig,
 		ConnMaxIdleTime:  time.Duration(opts.IdleTimeout) * time.Second,
+		Dialer:           dialer,
 	})
 	return newClient(client), nil
 }
@@ -131,6 +180,7 @@ func buildClusterClient(opts options.RedisStoreOptions) (Client, error) {
 		Password:        opts.Password,
 		TLSConfig:       opt.TLSConfig,
 		ConnMaxIdleTime: time.Duration(opts.IdleTimeout) * time.Second,
+		Dialer:          dialer,
 	})
 	return newClusterClient(client), nil
 }
@@ -152,6 +202,7 @@ func buildStandaloneClient(opts options.RedisStoreOptions) (Client, error) {
 	}
 
 	opt.ConnMaxIdleTime = time.Duration(opts.IdleTimeout) * time.Second
+	opt.Dialer = dialer
 
 	client := redis.NewClient(opt)
 	return newClient(client), nil
@@ -213,3 +264,4 @@ func parseRedisURLs(urls []string) ([]string, *redis.Options, error) {
 }
 
 var _ persistence.Store = (*SessionStore)(nil)
+var _ persistence.IndexStore = (*SessionStore)(nil)
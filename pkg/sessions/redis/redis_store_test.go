@@ -2,20 +2,29 @@ package redis
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Bose/minisentinel"
 	"github.com/alicebob/miniredis/v2"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
 )
 
 const redisPassword = "0123456789abcdefghijklmnopqrstuv"
@@ -95,6 +104,25 @@ var _ = Describe("Redis SessionStore Tests", func() {
 		},
 	)
 
+	Context("with a key prefix", func() {
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				opts.Type = options.RedisSessionStoreType
+				opts.Redis.ConnectionURL = "redis://" + mr.Addr()
+				opts.Redis.KeyPrefix = "oauth2-proxy:"
+
+				// Capture the session store so that we can close the client
+				var err error
+				ss, err = NewRedisSessionStore(opts, cookieOpts)
+				return ss, err
+			},
+			func(d time.Duration) error {
+				mr.FastForward(d)
+				return nil
+			},
+		)
+	})
+
 	Context("with sentinel", func() {
 		var ms *minisentinel.Sentinel
 
@@ -417,3 +445,314 @@ var _ = Describe("Redis SessionStore Tests", func() {
 		)
 	})
 })
+
+var _ = Describe("NewRedisClient", func() {
+	type newRedisClientTableInput struct {
+		opts          options.RedisStoreOptions
+		expectedType  Client
+		expectedAddrs []string
+		expectedErr   string
+	}
+
+	DescribeTable("selects the client for the configured mode",
+		func(in newRedisClientTableInput) {
+			// The mock dialer records the addresses the client connects to
+			var mu sync.Mutex
+			var dialed []string
+			dialer = func(_ context.Context, _, addr string) (net.Conn, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				dialed = append(dialed, addr)
+				return nil, errors.New("mock dialer")
+			}
+			defer func() { dialer = nil }()
+
+			c, err := NewRedisClient(in.opts)
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+				Expect(c).To(BeNil())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(in.expectedType))
+
+			Expect(c.Ping(context.Background())).ToNot(Succeed())
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(dialed).ToNot(BeEmpty())
+			Expect(dialed).To(HaveEach(BeElementOf(in.expectedAddrs)))
+		},
+		Entry("with a standalone connection url", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				ConnectionURL: "redis://127.0.0.1:6379",
+			},
+			expectedType:  &client{},
+			expectedAddrs: []string{"127.0.0.1:6379"},
+		}),
+		Entry("with sentinel", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseSentinel:            true,
+				SentinelMasterName:     "mymaster",
+				SentinelConnectionURLs: []string{"redis://127.0.0.1:26379"},
+			},
+			expectedType:  &client{},
+			expectedAddrs: []string{"127.0.0.1:26379"},
+		}),
+		Entry("with cluster", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseCluster:            true,
+				ClusterConnectionURLs: []string{"redis://127.0.0.1:6379", "redis://127.0.0.1:6380"},
+			},
+			expectedType:  &clusterClient{},
+			expectedAddrs: []string{"127.0.0.1:6379", "127.0.0.1:6380"},
+		}),
+		Entry("with sentinel and cluster enabled", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseSentinel: true,
+				UseCluster:  true,
+			},
+			expectedErr: "options redis-use-sentinel and redis-use-cluster are mutually exclusive",
+		}),
+		Entry("with sentinel and cluster connection urls", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseSentinel:            true,
+				SentinelConnectionURLs: []string{"redis://127.0.0.1:26379"},
+				ClusterConnectionURLs:  []string{"redis://127.0.0.1:6379"},
+			},
+			expectedErr: "option redis-cluster-connection-urls cannot be used with redis-use-sentinel",
+		}),
+		Entry("with cluster and sentinel connection urls", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseCluster:             true,
+				SentinelConnectionURLs: []string{"redis://127.0.0.1:26379"},
+				ClusterConnectionURLs:  []string{"redis://127.0.0.1:6379"},
+			},
+			expectedErr: "option redis-sentinel-connection-urls cannot be used with redis-use-cluster",
+		}),
+		Entry("with cluster and no connection urls", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseCluster:            true,
+				InsecureSkipTLSVerify: true,
+			},
+			expectedErr: "option redis-use-cluster requires at least one redis-cluster-connection-urls",
+		}),
+		Entry("with sentinel and no connection urls", newRedisClientTableInput{
+			opts: options.RedisStoreOptions{
+				UseSentinel: true,
+			},
+			expectedErr: "option redis-use-sentinel requires at least one redis-sentinel-connection-urls",
+		}),
+	)
+})
+
+var _ = Describe("Redis SessionStore Key Prefix Tests", func() {
+	var mr *miniredis.Miniredis
+	var client Client
+
+	BeforeEach(func() {
+		var err error
+		mr, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err = NewRedisClient(options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.(interface{ Close() error }).Close()).To(Succeed())
+		mr.Close()
+	})
+
+	It("prefixes the session keys", func() {
+		store := &SessionStore{Client: client, KeyPrefix: "app1:"}
+		Expect(store.Save(context.Background(), "_oauth2_proxy-ticket", []byte("session"), time.Hour)).To(Succeed())
+		Expect(mr.Keys()).To(ConsistOf("app1:_oauth2_proxy-ticket"))
+
+		value, err := store.Load(context.Background(), "_oauth2_proxy-ticket")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("session")))
+
+		Expect(store.Clear(context.Background(), "_oauth2_proxy-ticket")).To(Succeed())
+		Expect(mr.Keys()).To(BeEmpty())
+	})
+
+	It("prefixes the lock keys", func() {
+		store := &SessionStore{Client: client, KeyPrefix: "app1:"}
+		lock := store.Lock("_oauth2_proxy-ticket")
+		Expect(lock.Obtain(context.Background(), time.Minute)).To(Succeed())
+		Expect(mr.Keys()).To(ConsistOf("app1:_oauth2_proxy-ticket.lock"))
+
+		other := &SessionStore{Client: client, KeyPrefix: "app2:"}
+		locked, err := other.Lock("_oauth2_proxy-ticket").Peek(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeFalse())
+	})
+
+	It("isolates the sessions of stores with different prefixes", func() {
+		app1 := &SessionStore{Client: client, KeyPrefix: "app1:"}
+		app2 := &SessionStore{Client: client, KeyPrefix: "app2:"}
+
+		Expect(app1.Save(context.Background(), "_oauth2_proxy-ticket", []byte("app1 session"), time.Hour)).To(Succeed())
+
+		_, err := app2.Load(context.Background(), "_oauth2_proxy-ticket")
+		Expect(err).To(MatchError(ContainSubstring("error loading redis session")))
+
+		Expect(app2.Save(context.Background(), "_oauth2_proxy-ticket", []byte("app2 session"), time.Hour)).To(Succeed())
+		Expect(app2.Clear(context.Background(), "_oauth2_proxy-ticket")).To(Succeed())
+
+		value, err := app1.Load(context.Background(), "_oauth2_proxy-ticket")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("app1 session")))
+	})
+
+	It("prefixes the index keys", func() {
+		store := &SessionStore{Client: client, KeyPrefix: "app1:"}
+		Expect(store.AddToIndex(context.Background(), "_oauth2_proxy-user", "_oauth2_proxy-ticket1", time.Hour)).To(Succeed())
+		Expect(store.AddToIndex(context.Background(), "_oauth2_proxy-user", "_oauth2_proxy-ticket2", time.Hour)).To(Succeed())
+		Expect(mr.Keys()).To(ConsistOf("app1:_oauth2_proxy-user"))
+		Expect(mr.TTL("app1:_oauth2_proxy-user")).To(Equal(time.Hour))
+
+		keys, err := store.LoadIndex(context.Background(), "_oauth2_proxy-user")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(ConsistOf("_oauth2_proxy-ticket1", "_oauth2_proxy-ticket2"))
+
+		keys, err = (&SessionStore{Client: client, KeyPrefix: "app2:"}).LoadIndex(context.Background(), "_oauth2_proxy-user")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(BeEmpty())
+	})
+})
+
+// slotHook records the commands of each transaction or script whose keys
+// span more than one cluster slot
+type slotHook struct {
+	mu        sync.Mutex
+	scripts   int
+	crossSlot [][]string
+}
+
+func (h *slotHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *slotHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.check([]redis.Cmder{cmd})
+		return next(ctx, cmd)
+	}
+}
+
+func (h *slotHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.check(cmds)
+		return next(ctx, cmds)
+	}
+}
+
+func (h *slotHook) check(cmds []redis.Cmder) {
+	var keys []string
+	for _, cmd := range cmds {
+		args := cmd.Args()
+		switch cmd.Name() {
+		case "multi", "exec", "ping", "cluster":
+		case "eval", "evalsha":
+			h.mu.Lock()
+			h.scripts++
+			h.mu.Unlock()
+			numKeys, _ := strconv.Atoi(fmt.Sprint(args[2]))
+			for _, key := range args[3 : 3+numKeys] {
+				keys = append(keys, fmt.Sprint(key))
+			}
+		default:
+			keys = append(keys, fmt.Sprint(args[1]))
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range keys {
+		if keySlot(key) != keySlot(keys[0]) {
+			h.crossSlot = append(h.crossSlot, keys)
+			return
+		}
+	}
+}
+
+var _ = Describe("Redis Cluster Slot Tests", func() {
+	DescribeTable("keySlot",
+		func(key string, expected int) {
+			Expect(keySlot(key)).To(Equal(expected))
+		},
+		Entry("with a key", "foo", 12182),
+		Entry("with the CRC16 check value", "123456789", 12739),
+		Entry("with a hash tag", "{123456789}.lock", 12739),
+		Entry("with a second hash tag", "{foo}{123456789}", 12182),
+	)
+
+	It("does not hash the whole key of an empty or unterminated hash tag to the tag", func() {
+		Expect(keySlot("{}123456789")).ToNot(Equal(keySlot("")))
+		Expect(keySlot("{123456789")).ToNot(Equal(keySlot("123456789")))
+	})
+
+	Context("with a cluster client", func() {
+		var mr *miniredis.Miniredis
+		var c *clusterClient
+
+		BeforeEach(func() {
+			var err error
+			mr, err = miniredis.Run()
+			Expect(err).ToNot(HaveOccurred())
+
+			client, err := NewRedisClient(options.RedisStoreOptions{
+				UseCluster:            true,
+				ClusterConnectionURLs: []string{"redis://" + mr.Addr()},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			c = client.(*clusterClient)
+		})
+
+		AfterEach(func() {
+			Expect(c.Close()).To(Succeed())
+			mr.Close()
+		})
+
+		It("rejects transactions with keys in different slots", func() {
+			err := c.txPipelined(context.Background(), []string{"foo", "123456789"}, func(redis.Pipeliner) error {
+				return nil
+			})
+			Expect(err).To(MatchError(`the keys ["foo" "123456789"] of the transaction span more than one cluster slot`))
+		})
+
+		It("keeps the keys of the session, index and lock commands in one slot", func() {
+			hook := &slotHook{}
+			c.AddHook(hook)
+
+			ctx := context.Background()
+			store := &SessionStore{Client: c, KeyPrefix: "app1:"}
+			for i := 0; i < 10; i++ {
+				nonce, err := encryption.Nonce(16)
+				Expect(err).ToNot(HaveOccurred())
+				ticket := fmt.Sprintf("_oauth2_proxy-%x", nonce)
+
+				Expect(store.Save(ctx, ticket, []byte("session"), time.Hour)).To(Succeed())
+				_, err = store.Load(ctx, ticket)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(store.AddToIndex(ctx, "_oauth2_proxy-user", ticket, time.Hour)).To(Succeed())
+
+				lock := store.Lock(ticket)
+				Expect(lock.Obtain(ctx, time.Minute)).To(Succeed())
+				Expect(lock.Refresh(ctx, time.Minute)).To(Succeed())
+				locked, err := lock.Peek(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(locked).To(BeTrue())
+				Expect(lock.Release(ctx)).To(Succeed())
+
+				Expect(store.Clear(ctx, ticket)).To(Succeed())
+			}
+
+			hook.mu.Lock()
+			defer hook.mu.Unlock()
+			Expect(hook.scripts).To(BeNumerically(">", 0))
+			Expect(hook.crossSlot).To(BeEmpty())
+		})
+	})
+})
@@ -133,6 +133,72 @@ func RunSessionStoreTests(newSS NewSessionStoreFunc, persistentFastForward Persi
 				PersistentSessionStoreInterfaceTests(&input)
 			}
 		})
+
+		Context("while the cookie secret is rotated", func() {
+			var rotatedSS, newSecretSS sessionsapi.SessionStore
+
+			BeforeEach(func() {
+				var err error
+				ss, err = newSS(opts, input.cookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+
+				newSecret := make([]byte, 32)
+				_, err = rand.Read(newSecret)
+				Expect(err).ToNot(HaveOccurred())
+
+				rotatedOpts := *input.cookieOpts
+				rotatedOpts.Secret = string(newSecret)
+				rotatedOpts.SecondarySecrets = []string{string(cookieSecret)}
+				rotatedSS, err = newSS(opts, &rotatedOpts)
+				Expect(err).ToNot(HaveOccurred())
+
+				newSecretOpts := rotatedOpts
+				newSecretOpts.SecondarySecrets = nil
+				newSecretSS, err = newSS(opts, &newSecretOpts)
+				Expect(err).ToNot(HaveOccurred())
+
+				// The session is saved with the old secret
+				Expect(ss.Save(input.response, input.request, input.session)).To(Succeed())
+			})
+
+			requestWithCookies := func(resp *httptest.ResponseRecorder) *http.Request {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				for _, cookie := range resp.Result().Cookies() {
+					req.AddCookie(cookie)
+				}
+				return req
+			}
+
+			It("loads sessions saved with a secondary secret", func() {
+				loaded, err := rotatedSS.Load(requestWithCookies(input.response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(loaded.AccessToken).To(Equal(input.session.AccessToken))
+				Expect(loaded.Email).To(Equal(input.session.Email))
+				Expect(loaded.SecretRotated).To(BeTrue())
+			})
+
+			It("does not load sessions saved with a removed secret", func() {
+				_, err := newSecretSS.Load(requestWithCookies(input.response))
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("saves sessions loaded with a secondary secret with the current secret", func() {
+				req := requestWithCookies(input.response)
+				loaded, err := rotatedSS.Load(req)
+				Expect(err).ToNot(HaveOccurred())
+
+				resp := httptest.NewRecorder()
+				Expect(rotatedSS.Save(resp, req, loaded)).To(Succeed())
+				Expect(loaded.SecretRotated).To(BeFalse())
+
+				for _, store := range []sessionsapi.SessionStore{rotatedSS, newSecretSS} {
+					saved, err := store.Load(requestWithCookies(resp))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(saved.AccessToken).To(Equal(input.session.AccessToken))
+					Expect(saved.SecretRotated).To(BeFalse())
+				}
+			})
+		})
 	})
 }
 
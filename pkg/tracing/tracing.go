@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// instrumentationName identifies the spans recorded by OAuth2 Proxy
+	instrumentationName = "github.com/oauth2-proxy/oauth2-proxy/v7"
+
+	serviceName = "oauth2-proxy"
+)
+
+// Tracer returns the tracer used to record the spans of OAuth2 Proxy.
+// Until Setup is called, the spans are not recorded.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup exports the spans to the OTLP/HTTP endpoint, eg
+// http://localhost:4318, and propagates the trace context in W3C traceparent
+// headers.
+// The sampleRatio of traces are sampled. Requests that continue the trace of
+// a caller are sampled by their trace ID too, as the sampled flag of the
+// traceparent header comes from an untrusted client.
+// The returned func flushes the remaining spans and stops the exporter.
+func Setup(ctx context.Context, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	exporter, err := newExporter(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceNameKey.String(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("could not create the tracing resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newSampler(sampleRatio)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// newSampler samples the ratio of traces by their trace ID, ignoring the
+// sampling decision of remote parents. Spans with a local parent follow the
+// decision of their parent.
+func newSampler(sampleRatio float64) sdktrace.Sampler {
+	ratio := sdktrace.TraceIDRatioBased(sampleRatio)
+	return sdktrace.ParentBased(ratio,
+		sdktrace.WithRemoteParentSampled(ratio),
+		sdktrace.WithRemoteParentNotSampled(ratio),
+	)
+}
+
+// newExporter creates an OTLP/HTTP exporter for the endpoint URL.
+// The spans are sent to the path of the URL, or /v1/traces when it has none.
+func newExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing endpoint %q: %v", endpoint, err)
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpointURL.Host)}
+	switch endpointURL.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("invalid tracing endpoint %q: expected an http or https URL", endpoint)
+	}
+	if endpointURL.Path != "" && endpointURL.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpointURL.Path))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the tracing exporter: %v", err)
+	}
+	return exporter, nil
+}
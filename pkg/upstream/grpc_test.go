@@ -0,0 +1,161 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// echoStreamDesc describes a bidirectional streaming method that replies to
+// each message as it is received, so that a buffered proxy cannot pass.
+var echoStreamDesc = grpc.StreamDesc{
+	StreamName:    "Echo",
+	ServerStreams: true,
+	ClientStreams: true,
+	Handler:       echoStream,
+}
+
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "oauth2proxy.test.Echo",
+	HandlerType: (*interface{})(nil),
+	Streams:     []grpc.StreamDesc{echoStreamDesc},
+}
+
+// echoStream echoes each message back, returns the request content type in
+// the response headers and the number of messages in the trailers.
+func echoStream(_ interface{}, stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if err := stream.SendHeader(metadata.MD{"request-content-type": md.Get("content-type")}); err != nil {
+		return err
+	}
+
+	count := 0
+	for {
+		msg := &wrapperspb.StringValue{}
+		err := stream.RecvMsg(msg)
+		if err == io.EOF {
+			stream.SetTrailer(metadata.Pairs("echo-count", strconv.Itoa(count)))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		count++
+		if err := stream.SendMsg(msg); err != nil {
+			return err
+		}
+	}
+}
+
+var _ = Describe("gRPC Upstream Suite", func() {
+	var grpcServer *grpc.Server
+	var proxyServer *httptest.Server
+
+	BeforeEach(func() {
+		grpcServer = grpc.NewServer()
+		grpcServer.RegisterService(&echoServiceDesc, struct{}{})
+	})
+
+	AfterEach(func() {
+		if proxyServer != nil {
+			proxyServer.Close()
+		}
+		grpcServer.Stop()
+	})
+
+	// startProxy serves the upstream proxy to h2c clients, as the proxy server
+	// does when an upstream has HTTP/2 enabled.
+	startProxy := func(upstream options.Upstream) string {
+		u, err := url.Parse(upstream.URI)
+		Expect(err).ToNot(HaveOccurred())
+
+		handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, nil, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		proxyServer = httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			handler.ServeHTTP(rw, req)
+		}), &http2.Server{}))
+		return proxyServer.Listener.Addr().String()
+	}
+
+	// expectEcho streams messages through the proxy one by one and checks
+	// that each is answered before the next is sent.
+	expectEcho := func(proxyAddr string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		conn, err := grpc.DialContext(ctx, proxyAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		stream, err := conn.NewStream(ctx, &echoStreamDesc, fmt.Sprintf("/%s/%s", echoServiceDesc.ServiceName, echoStreamDesc.StreamName))
+		Expect(err).ToNot(HaveOccurred())
+
+		messages := []string{"one", "two", "three"}
+		for _, message := range messages {
+			Expect(stream.SendMsg(wrapperspb.String(message))).To(Succeed())
+
+			reply := &wrapperspb.StringValue{}
+			Expect(stream.RecvMsg(reply)).To(Succeed())
+			Expect(reply.GetValue()).To(Equal(message))
+		}
+		Expect(stream.CloseSend()).To(Succeed())
+		Expect(stream.RecvMsg(&wrapperspb.StringValue{})).To(Equal(io.EOF))
+
+		header, err := stream.Header()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.Get("request-content-type")).To(ConsistOf("application/grpc"))
+		Expect(stream.Trailer().Get("echo-count")).To(ConsistOf(strconv.Itoa(len(messages))))
+	}
+
+	It("streams to a cleartext HTTP/2 upstream", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			_ = grpcServer.Serve(listener)
+		}()
+
+		proxyAddr := startProxy(options.Upstream{
+			ID:    "grpc",
+			Path:  "/",
+			URI:   fmt.Sprintf("http://%s", listener.Addr().String()),
+			HTTP2: true,
+		})
+		expectEcho(proxyAddr)
+	})
+
+	It("streams to an HTTP/2 over TLS upstream", func() {
+		upstreamServer := httptest.NewUnstartedServer(grpcServer)
+		upstreamServer.EnableHTTP2 = true
+		upstreamServer.StartTLS()
+		defer upstreamServer.Close()
+
+		proxyAddr := startProxy(options.Upstream{
+			ID:                    "grpc",
+			Path:                  "/",
+			URI:                   upstreamServer.URL,
+			InsecureSkipTLSVerify: true,
+			HTTP2:                 true,
+		})
+		expectEcho(proxyAddr)
+	})
+})
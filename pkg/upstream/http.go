@@ -1,14 +1,24 @@
 package upstream
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"time"
 
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
+	"golang.org/x/net/http2"
 )
 
 const (
@@ -38,17 +48,31 @@ var SignatureHeaders = []string{
 
 // newHTTPUpstreamProxy creates a new httpUpstreamProxy that can serve requests
 // to a single upstream host.
-func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, errorHandler ProxyErrorHandler) http.Handler {
+func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, transportOpts options.UpstreamTransport, sigData *options.SignatureData, errorHandler ProxyErrorHandler, exchangeToken TokenExchangeFunc) (http.Handler, error) {
 	// Set path to empty so that request paths start at the server root
 	u.Path = ""
 
+	tlsConfig, err := newUpstreamTLSConfig(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	// Balance the requests across the URIs of the upstream, if it has several
+	var balancer *loadBalancer
+	if len(upstream.URIs) > 0 {
+		balancer, err = newLoadBalancer(upstream)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Create a ReverseProxy
-	proxy := newReverseProxy(u, upstream, errorHandler)
+	proxy := newReverseProxy(u, upstream, transportOpts, tlsConfig, errorHandler, balancer)
 
 	// Set up a WebSocket proxy if required
 	var wsProxy http.Handler
 	if upstream.ProxyWebSockets == nil || *upstream.ProxyWebSockets {
-		wsProxy = newWebSocketReverseProxy(u, upstream.InsecureSkipTLSVerify)
+		wsProxy = newWebSocketReverseProxy(u, upstream, transportOpts, tlsConfig, balancer)
 	}
 
 	var auth hmacauth.HmacAuth
@@ -56,12 +80,54 @@ func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *option
 		auth = hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), SignatureHeader, SignatureHeaders)
 	}
 
+	var tokenExchange *options.TokenExchange
+	if upstream.TokenExchange != nil && exchangeToken != nil {
+		tokenExchange = upstream.TokenExchange
+	}
+
 	return &httpUpstreamProxy{
-		upstream:  upstream.ID,
-		handler:   proxy,
-		wsHandler: wsProxy,
-		auth:      auth,
+		upstream:      upstream.ID,
+		handler:       proxy,
+		wsHandler:     wsProxy,
+		auth:          auth,
+		tokenExchange: tokenExchange,
+		exchangeToken: exchangeToken,
+		errorHandler:  errorHandler,
+	}, nil
+}
+
+// newUpstreamTLSConfig builds the TLS client configuration used to connect to
+// the upstream server.
+// A nil config is returned when the upstream has no TLS options so that the
+// default transport configuration is used.
+func newUpstreamTLSConfig(upstream options.Upstream) (*tls.Config, error) {
+	if !upstream.InsecureSkipTLSVerify && upstream.TLSClientCertFile == "" && upstream.TLSCAFile == "" {
+		return nil, nil
+	}
+
+	// InsecureSkipVerify is a configurable option we allow
+	/* #nosec G402 */
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: upstream.InsecureSkipTLSVerify,
+	}
+
+	if upstream.TLSClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(upstream.TLSClientCertFile, upstream.TLSClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if upstream.TLSCAFile != "" {
+		pool, err := util.GetCertPool([]string{upstream.TLSCAFile}, false)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA certificate: %v", err)
+		}
+		tlsConfig.RootCAs = pool
 	}
+
+	return tlsConfig, nil
 }
 
 // httpUpstreamProxy represents a single HTTP(S) upstream proxy
@@ -70,6 +136,11 @@ type httpUpstreamProxy struct {
 	handler   http.Handler
 	wsHandler http.Handler
 	auth      hmacauth.HmacAuth
+
+	// tokenExchange is only set when the upstream has token exchange configured.
+	tokenExchange *options.TokenExchange
+	exchangeToken TokenExchangeFunc
+	errorHandler  ProxyErrorHandler
 }
 
 // ServeHTTP proxies requests to the upstream provider while signing the
@@ -80,31 +151,76 @@ func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	// A scope should always be injected before this handler is called.
 	scope.Upstream = h.upstream
 
+	if h.tokenExchange != nil && !h.setExchangedToken(rw, req) {
+		return
+	}
+
 	// TODO (@NickMeves) - Deprecate GAP-Signature & remove GAP-Auth
 	if h.auth != nil {
 		req.Header.Set("GAP-Auth", rw.Header().Get("GAP-Auth"))
 		h.auth.SignRequest(req)
 	}
-	if h.wsHandler != nil && strings.EqualFold(req.Header.Get("Connection"), "upgrade") && req.Header.Get("Upgrade") == "websocket" {
+	if h.wsHandler != nil && isWebSocketUpgrade(req) {
 		h.wsHandler.ServeHTTP(rw, req)
 	} else {
 		h.handler.ServeHTTP(rw, req)
 	}
 }
 
+// setExchangedToken replaces the Authorization header of the request with a
+// token exchanged for the configured audience.
+// Failed exchanges are logged and the request carries on without the exchanged
+// token, unless the exchange is strict, in which case an error page is rendered.
+// Returns false when the request should not be proxied.
+func (h *httpUpstreamProxy) setExchangedToken(rw http.ResponseWriter, req *http.Request) bool {
+	token, err := h.exchangeToken(rw, req, h.tokenExchange)
+	if err != nil {
+		if !h.tokenExchange.Strict {
+			logger.Errorf("Error exchanging token for upstream %q: %v", h.upstream, err)
+			return true
+		}
+		err = fmt.Errorf("could not exchange token for upstream %q: %w", h.upstream, err)
+		if h.errorHandler != nil {
+			h.errorHandler(rw, req, err)
+		} else {
+			logger.Errorf("Error proxying to upstream server: %v", err)
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+		return false
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return true
+}
+
 // newReverseProxy creates a new reverse proxy for proxying requests to upstream
 // servers based on the upstream configuration provided.
 // The proxy should render an error page if there are failures connecting to the
 // upstream server.
-func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler ProxyErrorHandler) http.Handler {
+// Each upstream has a dedicated transport so that connections are only reused
+// for requests to the same upstream with the same TLS configuration.
+// When the upstream has multiple URIs, the balancer replaces the target of
+// each request, including retries.
+func newReverseProxy(target *url.URL, upstream options.Upstream, transportOpts options.UpstreamTransport, tlsConfig *tls.Config, errorHandler ProxyErrorHandler, balancer *loadBalancer) http.Handler {
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
-	// Inherit default transport options from Go's stdlib
-	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var transport http.RoundTripper
+	if upstream.HTTP2 {
+		transport = newUpstreamHTTP2Transport(target, transportOpts, tlsConfig)
+	} else {
+		httpTransport := newUpstreamTransport(transportOpts, tlsConfig)
+
+		// Change default duration for waiting for an upstream response
+		if upstream.Timeout != nil {
+			httpTransport.ResponseHeaderTimeout = upstream.Timeout.Duration()
+		}
+		transport = httpTransport
+	}
 
-	// Change default duration for waiting for an upstream response
-	if upstream.Timeout != nil {
-		transport.ResponseHeaderTimeout = upstream.Timeout.Duration()
+	if balancer != nil {
+		transport = newLoadBalancingRoundTripper(transport, upstream, balancer)
 	}
 
 	// Configure options on the SingleHostReverseProxy
@@ -114,12 +230,6 @@ func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler Pr
 		proxy.FlushInterval = options.DefaultUpstreamFlushInterval
 	}
 
-	// InsecureSkipVerify is a configurable option we allow
-	/* #nosec G402 */
-	if upstream.InsecureSkipTLSVerify {
-		transport.TLSClientConfig.InsecureSkipVerify = true
-	}
-
 	// Ensure we always pass the original request path
 	setProxyDirector(proxy)
 
@@ -133,12 +243,102 @@ func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler Pr
 		proxy.ErrorHandler = errorHandler
 	}
 
+	// Responses with a status that has an action are handed to the error
+	// handler to take the action
+	if len(upstream.StatusActions) > 0 {
+		proxy.ModifyResponse = newStatusActionsModifier(upstream)
+	}
+
+	// Retry idempotent requests when the connection to the upstream fails
+	if upstream.Retries > 0 {
+		backoff := options.DefaultUpstreamRetryBackoff
+		if upstream.RetryBackoff != nil {
+			backoff = upstream.RetryBackoff.Duration()
+		}
+		transport = &retryRoundTripper{
+			next:     transport,
+			upstream: upstream.ID,
+			retries:  upstream.Retries,
+			backoff:  backoff,
+		}
+	}
+
 	// Apply the customized transport to our proxy before returning it
 	proxy.Transport = transport
 
+	// Bound the whole round trip (dial, request write and response headers)
+	// by the upstream timeout so that slow upstreams fail with a timeout error.
+	// The timeout includes any retries.
+	if upstream.Timeout != nil && upstream.Timeout.Duration() > 0 {
+		proxy.Transport = &timeoutRoundTripper{
+			next:    transport,
+			timeout: upstream.Timeout.Duration(),
+		}
+	}
+
+	// Record a span for each upstream request, including its retries
+	proxy.Transport = &tracingRoundTripper{
+		next:     proxy.Transport,
+		upstream: upstream.ID,
+	}
+
 	return proxy
 }
 
+// timeoutRoundTripper cancels the upstream request if the response headers
+// are not received within the timeout.
+// Once the response headers have been received, the response body may be
+// streamed for as long as the client needs.
+type timeoutRoundTripper struct {
+	next    http.RoundTripper
+	timeout time.Duration
+	clock   clock.Clock
+}
+
+// RoundTrip executes the request with a deadline derived from the timeout.
+// When the deadline is exceeded, the returned error wraps
+// context.DeadlineExceeded.
+func (t *timeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := t.clock.AfterFunc(t.timeout, cancel)
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		// The timer has already fired, the request has been cancelled
+		cancel()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("upstream did not respond within %s: %w", t.timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Protocol upgrades need the writable body from the underlying transport.
+	// The context will be released once the parent request completes.
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+
+	// Release the context once the response body has been consumed
+	resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelReadCloser cancels the request context when the body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the underlying body and cancels the request context.
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
 // setProxyUpstreamHostHeader sets the proxy.Director so that upstream requests
 // receive a host header matching the target URL.
 func setProxyUpstreamHostHeader(proxy *httputil.ReverseProxy, target *url.URL) {
@@ -163,19 +363,76 @@ func setProxyDirector(proxy *httputil.ReverseProxy) {
 }
 
 // newWebSocketReverseProxy creates a new reverse proxy for proxying websocket connections.
-func newWebSocketReverseProxy(u *url.URL, skipTLSVerify bool) http.Handler {
+func newWebSocketReverseProxy(u *url.URL, upstream options.Upstream, transportOpts options.UpstreamTransport, tlsConfig *tls.Config, balancer *loadBalancer) http.Handler {
 	wsProxy := httputil.NewSingleHostReverseProxy(u)
 
+	var transport http.RoundTripper = newUpstreamTransport(transportOpts, tlsConfig)
+	if balancer != nil {
+		transport = newLoadBalancingRoundTripper(transport, upstream, balancer)
+	}
+
+	// Apply the customized transport to our proxy before returning it
+	wsProxy.Transport = &tracingRoundTripper{
+		next:     transport,
+		upstream: upstream.ID,
+	}
+
+	return wsProxy
+}
+
+// newUpstreamTransport creates a transport for proxying requests to an
+// upstream server with the configured connection pool and TLS configuration.
+// Options that are not set keep the defaults from Go's stdlib.
+func newUpstreamTransport(transportOpts options.UpstreamTransport, tlsConfig *tls.Config) *http.Transport {
 	// Inherit default transport options from Go's stdlib
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 
-	/* #nosec G402 */
-	if skipTLSVerify {
-		transport.TLSClientConfig.InsecureSkipVerify = true
+	if transportOpts.MaxIdleConns != nil {
+		transport.MaxIdleConns = *transportOpts.MaxIdleConns
+	}
+	if transportOpts.MaxIdleConnsPerHost != nil {
+		transport.MaxIdleConnsPerHost = *transportOpts.MaxIdleConnsPerHost
+	}
+	if transportOpts.MaxConnsPerHost != nil {
+		transport.MaxConnsPerHost = *transportOpts.MaxConnsPerHost
+	}
+	if transportOpts.IdleConnTimeout != nil {
+		transport.IdleConnTimeout = transportOpts.IdleConnTimeout.Duration()
+	}
+	if transportOpts.MaxResponseHeaderBytes != nil {
+		transport.MaxResponseHeaderBytes = *transportOpts.MaxResponseHeaderBytes
 	}
 
-	// Apply the customized transport to our proxy before returning it
-	wsProxy.Transport = transport
+	// The config is cloned as the transport may modify it when configuring HTTP/2
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	return transport
+}
 
-	return wsProxy
+// newUpstreamHTTP2Transport creates a transport that only speaks HTTP/2 to the
+// upstream server.
+// Cleartext upstreams are dialed without TLS and sent HTTP/2 with prior
+// knowledge (h2c), as gRPC servers expect.
+// The response header limit is advertised to the upstream, which must not
+// send larger headers.
+func newUpstreamHTTP2Transport(target *url.URL, transportOpts options.UpstreamTransport, tlsConfig *tls.Config) *http2.Transport {
+	transport := &http2.Transport{}
+	if transportOpts.MaxResponseHeaderBytes != nil && *transportOpts.MaxResponseHeaderBytes <= math.MaxUint32 {
+		transport.MaxHeaderListSize = uint32(*transportOpts.MaxResponseHeaderBytes)
+	}
+
+	if target.Scheme == httpScheme {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return transport
+	}
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	return transport
 }
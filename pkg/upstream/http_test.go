@@ -2,13 +2,27 @@ package upstream
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -75,7 +89,8 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			u, err := url.Parse(*in.serverAddr)
 			Expect(err).ToNot(HaveOccurred())
 
-			handler := newHTTPUpstreamProxy(upstream, u, in.signatureData, in.errorHandler)
+			handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, in.signatureData, in.errorHandler, nil)
+			Expect(err).ToNot(HaveOccurred())
 			handler.ServeHTTP(rw, req)
 
 			Expect(rw.Code).To(Equal(in.expectedResponse.code))
@@ -331,7 +346,8 @@ var _ = Describe("HTTP Upstream Suite", func() {
 		u, err := url.Parse(serverAddr)
 		Expect(err).ToNot(HaveOccurred())
 
-		handler := newHTTPUpstreamProxy(upstream, u, nil, nil)
+		handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, nil, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
 		httpUpstream, ok := handler.(*httpUpstreamProxy)
 		Expect(ok).To(BeTrue())
 
@@ -371,7 +387,8 @@ var _ = Describe("HTTP Upstream Suite", func() {
 				Timeout:               &in.timeout,
 			}
 
-			handler := newHTTPUpstreamProxy(upstream, u, in.sigData, in.errorHandler)
+			handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, in.sigData, in.errorHandler, nil)
+			Expect(err).ToNot(HaveOccurred())
 			upstreamProxy, ok := handler.(*httpUpstreamProxy)
 			Expect(ok).To(BeTrue())
 
@@ -383,7 +400,13 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			proxy, ok := upstreamProxy.handler.(*httputil.ReverseProxy)
 			Expect(ok).To(BeTrue())
 			Expect(proxy.FlushInterval).To(Equal(in.flushInterval.Duration()))
-			transport, ok := proxy.Transport.(*http.Transport)
+			tracingTransport, ok := proxy.Transport.(*tracingRoundTripper)
+			Expect(ok).To(BeTrue())
+			Expect(tracingTransport.upstream).To(Equal(upstream.ID))
+			timeoutTransport, ok := tracingTransport.next.(*timeoutRoundTripper)
+			Expect(ok).To(BeTrue())
+			Expect(timeoutTransport.timeout).To(Equal(in.timeout.Duration()))
+			transport, ok := timeoutTransport.next.(*http.Transport)
 			Expect(ok).To(BeTrue())
 			Expect(transport.ResponseHeaderTimeout).To(Equal(in.timeout.Duration()))
 			Expect(proxy.ErrorHandler != nil).To(Equal(in.errorHandler != nil))
@@ -443,7 +466,262 @@ var _ = Describe("HTTP Upstream Suite", func() {
 		}),
 	)
 
+	maxIdleConns := 500
+	maxIdleConnsPerHost := 50
+	maxConnsPerHost := 200
+	idleConnTimeout := options.Duration(30 * time.Second)
+	maxResponseHeaderBytes := int64(64 << 10)
+
+	DescribeTable("upstream transport connection pooling",
+		func(transportOpts options.UpstreamTransport, maxIdleConns, maxIdleConnsPerHost, maxConnsPerHost int, idleConnTimeout time.Duration, maxResponseHeaderBytes int64) {
+			u, err := url.Parse("http://upstream:1234")
+			Expect(err).ToNot(HaveOccurred())
+
+			upstream := options.Upstream{
+				ID:              "foo123",
+				ProxyWebSockets: &truth,
+			}
+
+			handler, err := newHTTPUpstreamProxy(upstream, u, transportOpts, nil, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			upstreamProxy, ok := handler.(*httpUpstreamProxy)
+			Expect(ok).To(BeTrue())
+
+			proxy, ok := upstreamProxy.handler.(*httputil.ReverseProxy)
+			Expect(ok).To(BeTrue())
+			wsProxy, ok := upstreamProxy.wsHandler.(*httputil.ReverseProxy)
+			Expect(ok).To(BeTrue())
+
+			for _, roundTripper := range []http.RoundTripper{proxy.Transport, wsProxy.Transport} {
+				tracingTransport, ok := roundTripper.(*tracingRoundTripper)
+				Expect(ok).To(BeTrue())
+				transport, ok := tracingTransport.next.(*http.Transport)
+				Expect(ok).To(BeTrue())
+				Expect(transport.MaxIdleConns).To(Equal(maxIdleConns))
+				Expect(transport.MaxIdleConnsPerHost).To(Equal(maxIdleConnsPerHost))
+				Expect(transport.MaxConnsPerHost).To(Equal(maxConnsPerHost))
+				Expect(transport.IdleConnTimeout).To(Equal(idleConnTimeout))
+				Expect(transport.MaxResponseHeaderBytes).To(Equal(maxResponseHeaderBytes))
+			}
+		},
+		Entry("with the default options",
+			options.UpstreamTransport{},
+			options.DefaultUpstreamMaxIdleConns,
+			// A zero MaxIdleConnsPerHost uses http.DefaultMaxIdleConnsPerHost
+			0,
+			0,
+			options.DefaultUpstreamIdleConnTimeout,
+			// A zero MaxResponseHeaderBytes uses the stdlib default of 10MiB
+			int64(0),
+		),
+		Entry("with configured options",
+			options.UpstreamTransport{
+				MaxIdleConns:           &maxIdleConns,
+				MaxIdleConnsPerHost:    &maxIdleConnsPerHost,
+				MaxConnsPerHost:        &maxConnsPerHost,
+				IdleConnTimeout:        &idleConnTimeout,
+				MaxResponseHeaderBytes: &maxResponseHeaderBytes,
+			},
+			500,
+			50,
+			200,
+			30*time.Second,
+			int64(64<<10),
+		),
+	)
+
+	Context("with a slow upstream", func() {
+		var slowServer *httptest.Server
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			slowServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				select {
+				case <-release:
+				case <-req.Context().Done():
+				}
+				rw.WriteHeader(http.StatusOK)
+			}))
+		})
+
+		AfterEach(func() {
+			close(release)
+			slowServer.Close()
+		})
+
+		It("returns a gateway timeout when the upstream exceeds the timeout", func() {
+			timeout := options.Duration(50 * time.Millisecond)
+			upstream := options.Upstream{
+				ID:              "slowUpstream",
+				ProxyWebSockets: &falsum,
+				FlushInterval:   &defaultFlushInterval,
+				Timeout:         &timeout,
+			}
+
+			u, err := url.Parse(slowServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			var proxyErr error
+			errorHandler := func(rw http.ResponseWriter, _ *http.Request, err error) {
+				proxyErr = err
+				rw.WriteHeader(http.StatusGatewayTimeout)
+			}
+
+			handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, nil, errorHandler, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			req := httptest.NewRequest("", "http://example.localhost/slow", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(http.StatusGatewayTimeout))
+			Expect(errors.Is(proxyErr, context.DeadlineExceeded)).To(BeTrue())
+		})
+	})
+
+	Context("with oversized response headers", func() {
+		var headersServer *httptest.Server
+
+		BeforeEach(func() {
+			// Responds with a Set-Cookie header of the size in the "size" query parameter
+			headersServer = httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				size, err := strconv.Atoi(req.URL.Query().Get("size"))
+				Expect(err).ToNot(HaveOccurred())
+				rw.Header().Set("Set-Cookie", "huge="+strings.Repeat("a", size))
+				rw.WriteHeader(http.StatusOK)
+				rw.Write([]byte("upstream"))
+			}))
+			headersServer.EnableHTTP2 = true
+			headersServer.StartTLS()
+		})
+
+		AfterEach(func() {
+			headersServer.Close()
+		})
+
+		DescribeTable("ServeHTTP",
+			func(useHTTP2 bool, size int, expectedCode int) {
+				upstream := options.Upstream{
+					ID:                    "headers",
+					ProxyWebSockets:       &falsum,
+					FlushInterval:         &defaultFlushInterval,
+					Timeout:               &defaultTimeout,
+					InsecureSkipTLSVerify: true,
+					HTTP2:                 useHTTP2,
+				}
+
+				u, err := url.Parse(headersServer.URL)
+				Expect(err).ToNot(HaveOccurred())
+
+				var proxyErr error
+				errorHandler := func(rw http.ResponseWriter, _ *http.Request, err error) {
+					proxyErr = err
+					rw.WriteHeader(http.StatusBadGateway)
+				}
+
+				limit := int64(16 << 10)
+				transportOpts := options.UpstreamTransport{MaxResponseHeaderBytes: &limit}
+				handler, err := newHTTPUpstreamProxy(upstream, u, transportOpts, nil, errorHandler, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				req := httptest.NewRequest("", fmt.Sprintf("/headers?size=%d", size), nil)
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+				rw := httptest.NewRecorder()
+				handler.ServeHTTP(rw, req)
+
+				Expect(rw.Code).To(Equal(expectedCode))
+				if expectedCode == http.StatusOK {
+					Expect(proxyErr).ToNot(HaveOccurred())
+					Expect(rw.Header().Get("Set-Cookie")).To(HaveLen(len("huge=") + size))
+					Expect(rw.Body.String()).To(Equal("upstream"))
+				} else {
+					Expect(proxyErr).To(HaveOccurred())
+					Expect(rw.Header().Get("Set-Cookie")).To(BeEmpty())
+				}
+			},
+			Entry("with headers within the limit", false, 4<<10, http.StatusOK),
+			Entry("with headers over the limit", false, 32<<10, http.StatusBadGateway),
+			Entry("with HTTP/2 headers within the limit", true, 4<<10, http.StatusOK),
+			Entry("with HTTP/2 headers over the limit", true, 32<<10, http.StatusBadGateway),
+		)
+	})
+
+	Context("with token exchange", func() {
+		type tokenExchangeTableInput struct {
+			strict         bool
+			token          string
+			exchangeErr    error
+			expectedCode   int
+			expectedHeader string
+		}
+
+		DescribeTable("ServeHTTP",
+			func(in *tokenExchangeTableInput) {
+				upstream := options.Upstream{
+					ID:              "tokenExchange",
+					ProxyWebSockets: &falsum,
+					FlushInterval:   &defaultFlushInterval,
+					Timeout:         &defaultTimeout,
+					TokenExchange: &options.TokenExchange{
+						Audience: "downstream",
+						Strict:   in.strict,
+					},
+				}
+
+				u, err := url.Parse(serverAddr)
+				Expect(err).ToNot(HaveOccurred())
+
+				var audience string
+				exchangeToken := func(_ http.ResponseWriter, _ *http.Request, exchange *options.TokenExchange) (string, error) {
+					audience = exchange.Audience
+					return in.token, in.exchangeErr
+				}
+
+				handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, nil, nil, exchangeToken)
+				Expect(err).ToNot(HaveOccurred())
+
+				req := httptest.NewRequest("", "http://example.localhost/foo", nil)
+				req.Header.Set("Authorization", "Bearer original")
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+				rw := httptest.NewRecorder()
+				handler.ServeHTTP(rw, req)
+
+				Expect(audience).To(Equal("downstream"))
+				Expect(rw.Code).To(Equal(in.expectedCode))
+				if in.expectedCode != http.StatusOK {
+					return
+				}
+
+				request := testHTTPRequest{}
+				Expect(json.Unmarshal(rw.Body.Bytes(), &request)).To(Succeed())
+				Expect(request.Header.Get("Authorization")).To(Equal(in.expectedHeader))
+			},
+			Entry("replaces the authorization header with the exchanged token", &tokenExchangeTableInput{
+				token:          "exchanged",
+				expectedCode:   http.StatusOK,
+				expectedHeader: "Bearer exchanged",
+			}),
+			Entry("keeps the authorization header when no token is exchanged", &tokenExchangeTableInput{
+				expectedCode:   http.StatusOK,
+				expectedHeader: "Bearer original",
+			}),
+			Entry("proxies the request when the exchange fails", &tokenExchangeTableInput{
+				exchangeErr:    errors.New("exchange failed"),
+				expectedCode:   http.StatusOK,
+				expectedHeader: "Bearer original",
+			}),
+			Entry("rejects the request when a strict exchange fails", &tokenExchangeTableInput{
+				strict:       true,
+				exchangeErr:  errors.New("exchange failed"),
+				expectedCode: http.StatusBadGateway,
+			}),
+		)
+	})
+
 	Context("with a websocket proxy", func() {
+		var handler http.Handler
 		var proxyServer *httptest.Server
 
 		BeforeEach(func() {
@@ -461,7 +739,8 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			u, err := url.Parse(serverAddr)
 			Expect(err).ToNot(HaveOccurred())
 
-			handler := newHTTPUpstreamProxy(upstream, u, nil, nil)
+			handler, err = newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, nil, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
 
 			proxyServer = httptest.NewServer(middleware.NewScope(false, "X-Request-Id")(handler))
 		})
@@ -490,10 +769,237 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			}))
 		})
 
+		It("will proxy websockets with a stripped prefix", func() {
+			origin := "http://example.localhost"
+			message := "Hello, world!"
+
+			stripPrefixServer := httptest.NewServer(middleware.NewScope(false, "X-Request-Id")(newStripPrefix("/ws/", nil)(handler)))
+			defer stripPrefixServer.Close()
+
+			wsAddr := fmt.Sprintf("ws://%s/ws/", stripPrefixServer.Listener.Addr().String())
+			ws, err := websocket.Dial(wsAddr, "", origin)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(websocket.Message.Send(ws, []byte(message))).To(Succeed())
+			var response testWebSocketResponse
+			Expect(websocket.JSON.Receive(ws, &response)).To(Succeed())
+			Expect(response).To(Equal(testWebSocketResponse{
+				Message: message,
+				Origin:  origin,
+			}))
+		})
+
 		It("will proxy HTTP requests", func() {
 			response, err := http.Get(fmt.Sprintf("http://%s", proxyServer.Listener.Addr().String()))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(response.StatusCode).To(Equal(200))
 		})
 	})
+
+	Context("with mutual TLS", func() {
+		var tlsServer *httptest.Server
+		var certs *testUpstreamCerts
+		var certsDir string
+
+		BeforeEach(func() {
+			var err error
+			certsDir, err = os.MkdirTemp("", "upstream-tls")
+			Expect(err).ToNot(HaveOccurred())
+
+			certs, err = newTestUpstreamCerts(certsDir)
+			Expect(err).ToNot(HaveOccurred())
+
+			tlsServer = httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+			}))
+			tlsServer.TLS = &tls.Config{
+				Certificates: []tls.Certificate{certs.server},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    certs.caPool,
+				MinVersion:   tls.VersionTLS12,
+			}
+			tlsServer.StartTLS()
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+			Expect(os.RemoveAll(certsDir)).To(Succeed())
+		})
+
+		newUpstream := func(id, certFile, keyFile, caFile string) http.Handler {
+			upstream := options.Upstream{
+				ID:                id,
+				ProxyWebSockets:   &falsum,
+				FlushInterval:     &defaultFlushInterval,
+				Timeout:           &defaultTimeout,
+				TLSClientCertFile: certFile,
+				TLSClientKeyFile:  keyFile,
+				TLSCAFile:         caFile,
+			}
+
+			u, err := url.Parse(tlsServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			errorHandler := func(rw http.ResponseWriter, _ *http.Request, err error) {
+				rw.WriteHeader(http.StatusBadGateway)
+				rw.Write([]byte(err.Error()))
+			}
+
+			handler, err := newHTTPUpstreamProxy(upstream, u, options.UpstreamTransport{}, nil, errorHandler, nil)
+			Expect(err).ToNot(HaveOccurred())
+			return handler
+		}
+
+		serve := func(handler http.Handler) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("", "http://example.localhost/mtls", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			return rw
+		}
+
+		It("presents the client certificate of each upstream", func() {
+			upstreamA := newUpstream("upstreamA", certs.clientACertFile, certs.clientAKeyFile, certs.caFile)
+			upstreamB := newUpstream("upstreamB", certs.clientBCertFile, certs.clientBKeyFile, certs.caFile)
+
+			// Alternate between the upstreams so that pooled connections would
+			// be reused if the transports were shared
+			for i := 0; i < 3; i++ {
+				rw := serve(upstreamA)
+				Expect(rw.Code).To(Equal(http.StatusOK))
+				Expect(rw.Body.String()).To(Equal("client-a"))
+
+				rw = serve(upstreamB)
+				Expect(rw.Code).To(Equal(http.StatusOK))
+				Expect(rw.Body.String()).To(Equal("client-b"))
+			}
+		})
+
+		It("fails when no client certificate is configured", func() {
+			rw := serve(newUpstream("noClientCert", "", "", certs.caFile))
+			Expect(rw.Code).To(Equal(http.StatusBadGateway))
+		})
+
+		It("fails when the upstream CA is not trusted", func() {
+			rw := serve(newUpstream("untrustedCA", certs.clientACertFile, certs.clientAKeyFile, ""))
+			Expect(rw.Code).To(Equal(http.StatusBadGateway))
+			Expect(rw.Body.String()).To(ContainSubstring("certificate signed by unknown authority"))
+		})
+
+		It("returns an error when the client certificate cannot be loaded", func() {
+			u, err := url.Parse(tlsServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = newHTTPUpstreamProxy(options.Upstream{
+				ID:                "missingCert",
+				TLSClientCertFile: "/does/not/exist.crt",
+				TLSClientKeyFile:  "/does/not/exist.key",
+			}, u, options.UpstreamTransport{}, nil, nil, nil)
+			Expect(err).To(MatchError(HavePrefix("could not load client certificate: ")))
+		})
+	})
 })
+
+// testUpstreamCerts holds a CA, a server certificate and two client
+// certificates signed by the CA for testing mutual TLS.
+type testUpstreamCerts struct {
+	caFile          string
+	caPool          *x509.CertPool
+	server          tls.Certificate
+	clientACertFile string
+	clientAKeyFile  string
+	clientBCertFile string
+	clientBKeyFile  string
+}
+
+func newTestUpstreamCerts(dir string) (*testUpstreamCerts, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := &testUpstreamCerts{
+		caFile: filepath.Join(dir, "ca.crt"),
+		caPool: x509.NewCertPool(),
+	}
+	certs.caPool.AddCert(caCert)
+	if err := os.WriteFile(certs.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600); err != nil {
+		return nil, err
+	}
+
+	issue := func(serial int64, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte, error) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: commonName},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+	}
+
+	serverCert, serverKey, err := issue(2, "127.0.0.1", x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	certs.server, err = tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		return nil, err
+	}
+
+	writeClient := func(serial int64, name string) (string, string, error) {
+		cert, key, err := issue(serial, name, x509.ExtKeyUsageClientAuth)
+		if err != nil {
+			return "", "", err
+		}
+		certFile := filepath.Join(dir, name+".crt")
+		keyFile := filepath.Join(dir, name+".key")
+		if err := os.WriteFile(certFile, cert, 0600); err != nil {
+			return "", "", err
+		}
+		return certFile, keyFile, os.WriteFile(keyFile, key, 0600)
+	}
+
+	certs.clientACertFile, certs.clientAKeyFile, err = writeClient(3, "client-a")
+	if err != nil {
+		return nil, err
+	}
+	certs.clientBCertFile, certs.clientBKeyFile, err = writeClient(4, "client-b")
+	if err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
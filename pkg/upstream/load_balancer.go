@@ -0,0 +1,208 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// loadBalancer selects the URI each request to an upstream with multiple URIs
+// is sent to, and skips URIs that keep failing for a cooldown.
+type loadBalancer struct {
+	upstream    string
+	strategy    string
+	maxFailures int
+	cooldown    time.Duration
+	clock       clock.Clock
+
+	// intn returns a random number in [0, n) for the random strategy
+	intn func(n int) int
+
+	mu      sync.Mutex
+	targets []*loadBalancerTarget
+}
+
+// loadBalancerTarget tracks the state of one of the URIs of the upstream
+type loadBalancerTarget struct {
+	url    *url.URL
+	weight int
+
+	// current is the smooth weighted round robin weight of the target
+	current  int
+	failures int
+	skipped  time.Time
+}
+
+// newLoadBalancer creates a load balancer for the URIs of the upstream.
+// The URIs must all have the scheme of the first URI, which the reverse
+// proxy is created for.
+func newLoadBalancer(upstream options.Upstream) (*loadBalancer, error) {
+	b := &loadBalancer{
+		upstream:    upstream.ID,
+		strategy:    options.UpstreamLoadBalancingRoundRobin,
+		maxFailures: options.DefaultUpstreamMaxFailures,
+		cooldown:    options.DefaultUpstreamFailureCooldown,
+		intn:        rand.Intn,
+	}
+	if lb := upstream.LoadBalancing; lb != nil {
+		if lb.Strategy != "" {
+			b.strategy = lb.Strategy
+		}
+		if lb.MaxFailures != nil {
+			b.maxFailures = *lb.MaxFailures
+		}
+		if lb.FailureCooldown != nil {
+			b.cooldown = lb.FailureCooldown.Duration()
+		}
+	}
+
+	for _, uri := range upstream.URIs {
+		u, err := url.Parse(uri.URI)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing URI %q: %w", uri.URI, err)
+		}
+		if len(b.targets) > 0 && u.Scheme != b.targets[0].url.Scheme {
+			return nil, fmt.Errorf("URI %q does not have the scheme %q of the other URIs", uri.URI, b.targets[0].url.Scheme)
+		}
+		weight := uri.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		b.targets = append(b.targets, &loadBalancerTarget{url: u, weight: weight})
+	}
+	if len(b.targets) == 0 {
+		return nil, errors.New("no URIs to balance requests across")
+	}
+	return b, nil
+}
+
+// next selects the target of the next request.
+// Skipped targets are only selected when all targets are skipped.
+func (b *loadBalancer) next() *loadBalancerTarget {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	available := make([]*loadBalancerTarget, 0, len(b.targets))
+	for _, target := range b.targets {
+		if !now.Before(target.skipped) {
+			available = append(available, target)
+		}
+	}
+	if len(available) == 0 {
+		available = b.targets
+	}
+
+	if b.strategy == options.UpstreamLoadBalancingRandom {
+		return b.random(available)
+	}
+	return b.roundRobin(available)
+}
+
+// roundRobin selects the targets with the smooth weighted round robin of
+// nginx, which spreads the requests to targets with higher weights between
+// the requests to the other targets
+func (b *loadBalancer) roundRobin(targets []*loadBalancerTarget) *loadBalancerTarget {
+	var selected *loadBalancerTarget
+	total := 0
+	for _, target := range targets {
+		target.current += target.weight
+		total += target.weight
+		if selected == nil || target.current > selected.current {
+			selected = target
+		}
+	}
+	selected.current -= total
+	return selected
+}
+
+// random selects a target with a probability proportional to its weight
+func (b *loadBalancer) random(targets []*loadBalancerTarget) *loadBalancerTarget {
+	total := 0
+	for _, target := range targets {
+		total += target.weight
+	}
+	n := b.intn(total)
+	for _, target := range targets {
+		if n < target.weight {
+			return target
+		}
+		n -= target.weight
+	}
+	return targets[len(targets)-1]
+}
+
+// report records the outcome of a request to the target.
+// The target is skipped for the cooldown once it failed MaxFailures times in
+// a row.
+func (b *loadBalancer) report(target *loadBalancerTarget, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		target.failures = 0
+		return
+	}
+
+	target.failures++
+	if b.maxFailures > 0 && target.failures >= b.maxFailures {
+		logger.Errorf("Upstream %q URI %q failed %d times in a row, skipping it for %s", b.upstream, target.url.Host, target.failures, b.cooldown)
+		target.failures = 0
+		target.skipped = b.clock.Now().Add(b.cooldown)
+	}
+}
+
+// loadBalancingRoundTripper sends each request to the URI selected by the
+// load balancer.
+// Requests that are retried pass through it again, so that they are sent to
+// the next URI.
+type loadBalancingRoundTripper struct {
+	next     http.RoundTripper
+	balancer *loadBalancer
+
+	// setHost sets the Host header of requests to the selected URI, for
+	// upstreams that do not pass the host header
+	setHost bool
+}
+
+// newLoadBalancingRoundTripper wraps the transport of the upstream to send
+// its requests to the URIs selected by the balancer
+func newLoadBalancingRoundTripper(next http.RoundTripper, upstream options.Upstream, balancer *loadBalancer) *loadBalancingRoundTripper {
+	return &loadBalancingRoundTripper{
+		next:     next,
+		balancer: balancer,
+		setHost:  upstream.PassHostHeader != nil && !*upstream.PassHostHeader,
+	}
+}
+
+// RoundTrip executes the request against the selected URI and reports
+// whether the URI failed to respond.
+// Requests that are cancelled, eg by the client, are not reported.
+func (t *loadBalancingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := t.balancer.next()
+
+	outreq := new(http.Request)
+	*outreq = *req
+	outreq.URL = new(url.URL)
+	*outreq.URL = *req.URL
+	outreq.URL.Scheme = target.url.Scheme
+	outreq.URL.Host = target.url.Host
+	if t.setHost {
+		outreq.Host = target.url.Host
+	}
+
+	resp, err := t.next.RoundTrip(outreq)
+	if err == nil || (req.Context().Err() == nil && !errors.Is(err, context.Canceled)) {
+		t.balancer.report(target, err != nil)
+	}
+	return resp, err
+}
@@ -0,0 +1,217 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load Balancer Suite", func() {
+	var replicas []*httptest.Server
+	var down string
+
+	BeforeEach(func() {
+		// Each replica responds with its index and the host it was sent
+		replicas = nil
+		for _, name := range []string{"0", "1", "2"} {
+			name := name
+			replicas = append(replicas, httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("X-Replica", name)
+				rw.Header().Set("X-Host", req.Host)
+				rw.WriteHeader(http.StatusOK)
+			})))
+		}
+
+		// A replica that refuses connections
+		closed := httptest.NewServer(http.NotFoundHandler())
+		down = closed.URL
+		closed.Close()
+	})
+
+	AfterEach(func() {
+		for _, replica := range replicas {
+			replica.Close()
+		}
+	})
+
+	newBalancedProxy := func(upstream options.Upstream) (http.Handler, *loadBalancer) {
+		upstream.ID = "balanced"
+		balancer, err := newLoadBalancer(upstream)
+		Expect(err).ToNot(HaveOccurred())
+
+		u, err := url.Parse(upstreamURI(upstream))
+		Expect(err).ToNot(HaveOccurred())
+		errorHandler := func(rw http.ResponseWriter, _ *http.Request, _ error) {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+		return newReverseProxy(u, upstream, options.UpstreamTransport{}, nil, errorHandler, balancer), balancer
+	}
+
+	// get returns the replica that served the request, or the status code
+	// when the request failed
+	get := func(proxy http.Handler) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			return http.StatusText(rw.Code)
+		}
+		return rw.Header().Get("X-Replica")
+	}
+
+	getAll := func(proxy http.Handler, n int) []string {
+		served := []string{}
+		for i := 0; i < n; i++ {
+			served = append(served, get(proxy))
+		}
+		return served
+	}
+
+	Context("with the round robin strategy", func() {
+		It("sends the requests to the URIs in turn", func() {
+			proxy, _ := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: replicas[0].URL}, {URI: replicas[1].URL}, {URI: replicas[2].URL}},
+			})
+			Expect(getAll(proxy, 6)).To(Equal([]string{"0", "1", "2", "0", "1", "2"}))
+		})
+
+		It("sends the requests to the URIs as often as their weight", func() {
+			proxy, _ := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: replicas[0].URL, Weight: 3}, {URI: replicas[1].URL}, {URI: replicas[2].URL}},
+			})
+			Expect(getAll(proxy, 10)).To(Equal([]string{"0", "1", "0", "2", "0", "0", "1", "0", "2", "0"}))
+		})
+	})
+
+	Context("with the random strategy", func() {
+		It("selects the URIs proportional to their weight", func() {
+			proxy, balancer := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: replicas[0].URL, Weight: 2}, {URI: replicas[1].URL}, {URI: replicas[2].URL}},
+				LoadBalancing: &options.UpstreamLoadBalancing{
+					Strategy: options.UpstreamLoadBalancingRandom,
+				},
+			})
+			var totals []int
+			n := 0
+			balancer.intn = func(total int) int {
+				totals = append(totals, total)
+				n++
+				return (n - 1) % total
+			}
+			Expect(getAll(proxy, 4)).To(Equal([]string{"0", "0", "1", "2"}))
+			Expect(totals).To(Equal([]int{4, 4, 4, 4}))
+		})
+	})
+
+	Context("with a failing URI", func() {
+		It("skips the URI for the cooldown after consecutive failures", func() {
+			maxFailures := 2
+			cooldown := options.Duration(time.Minute)
+			proxy, balancer := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: replicas[0].URL}, {URI: down}},
+				LoadBalancing: &options.UpstreamLoadBalancing{
+					MaxFailures:     &maxFailures,
+					FailureCooldown: &cooldown,
+				},
+			})
+			balancer.clock.Set(time.Now())
+			defer balancer.clock.Reset()
+
+			bad := http.StatusText(http.StatusBadGateway)
+			Expect(getAll(proxy, 4)).To(Equal([]string{"0", bad, "0", bad}))
+			Expect(getAll(proxy, 4)).To(Equal([]string{"0", "0", "0", "0"}))
+
+			// The URI is tried again after the cooldown
+			Expect(balancer.clock.Add(time.Minute)).To(Succeed())
+			Expect(getAll(proxy, 2)).To(ContainElement(bad))
+		})
+
+		It("resets the failures when the URI responds", func() {
+			maxFailures := 2
+			proxy, balancer := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: replicas[0].URL}, {URI: replicas[1].URL}},
+				LoadBalancing: &options.UpstreamLoadBalancing{
+					MaxFailures: &maxFailures,
+				},
+			})
+			target := balancer.targets[1]
+			balancer.report(target, true)
+			balancer.report(target, false)
+			balancer.report(target, true)
+			Expect(target.skipped).To(BeZero())
+			Expect(getAll(proxy, 2)).To(Equal([]string{"0", "1"}))
+		})
+
+		It("does not skip URIs when max failures is zero", func() {
+			maxFailures := 0
+			proxy, _ := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: replicas[0].URL}, {URI: down}},
+				LoadBalancing: &options.UpstreamLoadBalancing{
+					MaxFailures: &maxFailures,
+				},
+			})
+			bad := http.StatusText(http.StatusBadGateway)
+			Expect(getAll(proxy, 8)).To(Equal([]string{"0", bad, "0", bad, "0", bad, "0", bad}))
+		})
+
+		It("still sends requests when all URIs are skipped", func() {
+			maxFailures := 1
+			proxy, balancer := newBalancedProxy(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: down}, {URI: down}},
+				LoadBalancing: &options.UpstreamLoadBalancing{
+					MaxFailures: &maxFailures,
+				},
+			})
+			bad := http.StatusText(http.StatusBadGateway)
+			Expect(getAll(proxy, 3)).To(Equal([]string{bad, bad, bad}))
+			Expect(balancer.targets[0].skipped).ToNot(BeZero())
+			Expect(balancer.targets[1].skipped).ToNot(BeZero())
+		})
+
+		It("retries requests with the next URI", func() {
+			backoff := options.Duration(time.Millisecond)
+			proxy, _ := newBalancedProxy(options.Upstream{
+				URIs:         []options.UpstreamURI{{URI: down}, {URI: replicas[1].URL}},
+				Retries:      1,
+				RetryBackoff: &backoff,
+			})
+			Expect(getAll(proxy, 4)).To(Equal([]string{"1", "1", "1", "1"}))
+		})
+	})
+
+	Context("with passHostHeader", func() {
+		It("sets the host of the selected URI", func() {
+			passHostHeader := false
+			proxy, _ := newBalancedProxy(options.Upstream{
+				URIs:           []options.UpstreamURI{{URI: replicas[0].URL}, {URI: replicas[1].URL}},
+				PassHostHeader: &passHostHeader,
+			})
+			for _, replica := range replicas[:2] {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+				rw := httptest.NewRecorder()
+				proxy.ServeHTTP(rw, req)
+
+				u, err := url.Parse(replica.URL)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rw.Header().Get("X-Host")).To(Equal(u.Host))
+			}
+		})
+	})
+
+	Context("newLoadBalancer", func() {
+		It("rejects URIs with different schemes", func() {
+			_, err := newLoadBalancer(options.Upstream{
+				URIs: []options.UpstreamURI{{URI: "http://10.0.0.1:8080"}, {URI: "https://10.0.0.2:8443"}},
+			})
+			Expect(err).To(MatchError("URI \"https://10.0.0.2:8443\" does not have the scheme \"http\" of the other URIs"))
+		})
+	})
+})
@@ -0,0 +1,63 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+)
+
+// newAllowedMethods creates a middleware that rejects requests with a method
+// that is not allowed for the upstream before they are proxied.
+func newAllowedMethods(methods []string, writer pagewriter.Writer) alice.Constructor {
+	allowed := make(map[string]struct{}, len(methods))
+	allow := make([]string, 0, len(methods)+1)
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		if _, ok := allowed[method]; ok {
+			continue
+		}
+		allowed[method] = struct{}{}
+		allow = append(allow, method)
+	}
+	if _, ok := allowed[http.MethodGet]; ok {
+		if _, ok := allowed[http.MethodHead]; !ok {
+			allowed[http.MethodHead] = struct{}{}
+			allow = append(allow, http.MethodHead)
+		}
+	}
+	allowHeader := strings.Join(allow, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			method := req.Method
+			if isPreflightRequest(req) {
+				// Preflights are checked against the method they ask for, so
+				// that CORS works for the allowed methods only
+				method = req.Header.Get("Access-Control-Request-Method")
+			}
+
+			if _, ok := allowed[method]; !ok {
+				rw.Header().Set("Allow", allowHeader)
+				writer.WriteErrorPage(rw, pagewriter.ErrorPageOpts{
+					Status:    http.StatusMethodNotAllowed,
+					RequestID: middleware.GetRequestScope(req).RequestID,
+					AppError:  fmt.Sprintf("Method %s is not allowed", method),
+					Request:   req,
+				})
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// isPreflightRequest returns true for CORS preflight requests
+func isPreflightRequest(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
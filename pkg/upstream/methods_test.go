@@ -0,0 +1,98 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Allowed Methods Suite", func() {
+	type allowedMethodsTableInput struct {
+		allowedMethods []string
+		method         string
+		headers        map[string]string
+		expectedCode   int
+		expectedAllow  string
+	}
+
+	DescribeTable("should only proxy allowed methods",
+		func(in allowedMethodsTableInput) {
+			req := httptest.NewRequest(in.method, "/admin", nil)
+			for name, value := range in.headers {
+				req.Header.Set(name, value)
+			}
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+
+			handler := newAllowedMethods(in.allowedMethods, &pagewriter.WriterFuncs{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			Expect(rw.Header().Get("Allow")).To(Equal(in.expectedAllow))
+		},
+		Entry("with an allowed method", allowedMethodsTableInput{
+			allowedMethods: []string{"GET", "POST"},
+			method:         http.MethodPost,
+			expectedCode:   http.StatusOK,
+		}),
+		Entry("with a disallowed method", allowedMethodsTableInput{
+			allowedMethods: []string{"GET", "POST"},
+			method:         http.MethodDelete,
+			expectedCode:   http.StatusMethodNotAllowed,
+			expectedAllow:  "GET, POST, HEAD",
+		}),
+		Entry("with lower case allowed methods", allowedMethodsTableInput{
+			allowedMethods: []string{"get", "put"},
+			method:         http.MethodPut,
+			expectedCode:   http.StatusOK,
+		}),
+		Entry("with HEAD when GET is allowed", allowedMethodsTableInput{
+			allowedMethods: []string{"GET"},
+			method:         http.MethodHead,
+			expectedCode:   http.StatusOK,
+		}),
+		Entry("with HEAD when GET is not allowed", allowedMethodsTableInput{
+			allowedMethods: []string{"POST"},
+			method:         http.MethodHead,
+			expectedCode:   http.StatusMethodNotAllowed,
+			expectedAllow:  "POST",
+		}),
+		Entry("with a preflight for an allowed method", allowedMethodsTableInput{
+			allowedMethods: []string{"GET", "POST"},
+			method:         http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with a preflight for a disallowed method", allowedMethodsTableInput{
+			allowedMethods: []string{"GET", "POST"},
+			method:         http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			expectedCode:  http.StatusMethodNotAllowed,
+			expectedAllow: "GET, POST, HEAD",
+		}),
+		Entry("with OPTIONS that is not a preflight", allowedMethodsTableInput{
+			allowedMethods: []string{"GET"},
+			method:         http.MethodOptions,
+			expectedCode:   http.StatusMethodNotAllowed,
+			expectedAllow:  "GET, HEAD",
+		}),
+		Entry("with OPTIONS when it is allowed", allowedMethodsTableInput{
+			allowedMethods: []string{"GET", "OPTIONS"},
+			method:         http.MethodOptions,
+			expectedCode:   http.StatusOK,
+		}),
+	)
+})
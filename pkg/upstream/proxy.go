@@ -20,11 +20,21 @@ import (
 // HTTP proxies fail to connect to upstream servers.
 type ProxyErrorHandler func(http.ResponseWriter, *http.Request, error)
 
+// TokenExchangeFunc obtains a token for the upstream's audience, resource and
+// scopes using the request's session.
+// An empty token with no error means no token could be exchanged for the request.
+type TokenExchangeFunc func(rw http.ResponseWriter, req *http.Request, exchange *options.TokenExchange) (string, error)
+
 // NewProxy creates a new multiUpstreamProxy that can serve requests directed to
 // multiple upstreams.
-func NewProxy(upstreams options.UpstreamConfig, sigData *options.SignatureData, writer pagewriter.Writer) (http.Handler, error) {
+// The exchangeToken func is used by upstreams that have token exchange configured.
+// The stepUp func is used by upstreams that have ACRValues or a MaxAge configured.
+// The reauthenticate func is used by upstreams with the reauthenticate status action.
+func NewProxy(upstreams options.UpstreamConfig, sigData *options.SignatureData, writer pagewriter.Writer, exchangeToken TokenExchangeFunc, stepUp StepUpFunc, reauthenticate ReauthenticateFunc) (http.Handler, error) {
 	m := &multiUpstreamProxy{
-		serveMux: mux.NewRouter(),
+		serveMux:       mux.NewRouter(),
+		stepUp:         stepUp,
+		reauthenticate: reauthenticate,
 	}
 
 	if upstreams.ProxyRawPath {
@@ -39,7 +49,7 @@ func NewProxy(upstreams options.UpstreamConfig, sigData *options.SignatureData,
 			continue
 		}
 
-		u, err := url.Parse(upstream.URI)
+		u, err := url.Parse(upstreamURI(upstream))
 		if err != nil {
 			return nil, fmt.Errorf("error parsing URI for upstream %q: %w", upstream.ID, err)
 		}
@@ -49,7 +59,7 @@ func NewProxy(upstreams options.UpstreamConfig, sigData *options.SignatureData,
 				return nil, fmt.Errorf("could not register file upstream %q: %v", upstream.ID, err)
 			}
 		case httpScheme, httpsScheme:
-			if err := m.registerHTTPUpstreamProxy(upstream, u, sigData, writer); err != nil {
+			if err := m.registerHTTPUpstreamProxy(upstream, u, upstreams.Transport, sigData, writer, exchangeToken); err != nil {
 				return nil, fmt.Errorf("could not register HTTP upstream %q: %v", upstream.ID, err)
 			}
 		default:
@@ -61,10 +71,21 @@ func NewProxy(upstreams options.UpstreamConfig, sigData *options.SignatureData,
 	return m, nil
 }
 
+// upstreamURI returns the URI of the upstream, or the first of its URIs when
+// it balances requests across several, which the proxy is created for
+func upstreamURI(upstream options.Upstream) string {
+	if len(upstream.URIs) > 0 {
+		return upstream.URIs[0].URI
+	}
+	return upstream.URI
+}
+
 // multiUpstreamProxy will serve requests directed to multiple upstream servers
 // registered in the serverMux.
 type multiUpstreamProxy struct {
-	serveMux *mux.Router
+	serveMux       *mux.Router
+	stepUp         StepUpFunc
+	reauthenticate ReauthenticateFunc
 }
 
 // ServerHTTP handles HTTP requests.
@@ -75,7 +96,11 @@ func (m *multiUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request
 // registerStaticResponseHandler registers a static response handler with at the given path.
 func (m *multiUpstreamProxy) registerStaticResponseHandler(upstream options.Upstream, writer pagewriter.Writer) error {
 	logger.Printf("mapping path %q => static response %d", upstream.Path, derefStaticCode(upstream.StaticCode))
-	return m.registerHandler(upstream, newStaticResponseHandler(upstream.ID, upstream.StaticCode), writer)
+	handler, err := newStaticResponseHandler(upstream)
+	if err != nil {
+		return err
+	}
+	return m.registerHandler(upstream, handler, writer)
 }
 
 // registerFileServer registers a new fileServer based on the configuration given.
@@ -85,19 +110,55 @@ func (m *multiUpstreamProxy) registerFileServer(upstream options.Upstream, u *ur
 }
 
 // registerHTTPUpstreamProxy registers a new httpUpstreamProxy based on the configuration given.
-func (m *multiUpstreamProxy) registerHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, writer pagewriter.Writer) error {
-	logger.Printf("mapping path %q => upstream %q", upstream.Path, upstream.URI)
-	return m.registerHandler(upstream, newHTTPUpstreamProxy(upstream, u, sigData, writer.ProxyErrorHandler), writer)
+func (m *multiUpstreamProxy) registerHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, transportOpts options.UpstreamTransport, sigData *options.SignatureData, writer pagewriter.Writer, exchangeToken TokenExchangeFunc) error {
+	if len(upstream.URIs) > 0 {
+		uris := make([]string, 0, len(upstream.URIs))
+		for _, uri := range upstream.URIs {
+			uris = append(uris, uri.URI)
+		}
+		logger.Printf("mapping path %q => upstreams %q", upstream.Path, uris)
+	} else {
+		logger.Printf("mapping path %q => upstream %q", upstream.Path, upstream.URI)
+	}
+	errorHandler := writer.ProxyErrorHandler
+	if len(upstream.StatusActions) > 0 {
+		errorHandler = newStatusActionsErrorHandler(writer, m.reauthenticate, errorHandler)
+	}
+	handler, err := newHTTPUpstreamProxy(upstream, u, transportOpts, sigData, errorHandler, exchangeToken)
+	if err != nil {
+		return err
+	}
+	return m.registerHandler(upstream, handler, writer)
 }
 
 // registerHandler ensures the given handler is regiestered with the serveMux.
+// Disallowed methods and WebSocket origins are rejected first so that they do
+// not start a new login.
+// The authentication requirements are checked before the request path is
+// modified so that a new login returns to the original request.
 func (m *multiUpstreamProxy) registerHandler(upstream options.Upstream, handler http.Handler, writer pagewriter.Writer) error {
+	chain := alice.New()
+	if len(upstream.AllowedMethods) > 0 {
+		chain = chain.Append(newAllowedMethods(upstream.AllowedMethods, writer))
+	}
+	if len(upstream.AllowedWebSocketOrigins) > 0 {
+		chain = chain.Append(newWebSocketOrigins(upstream.AllowedWebSocketOrigins, writer))
+	}
+	if m.stepUp != nil && requiresStepUp(upstream) {
+		chain = chain.Append(newStepUp(upstream, m.stepUp))
+	}
+
+	if upstream.StripPrefix {
+		m.registerSimpleHandler(upstream.Path, chain.Append(newStripPrefix(upstream.Path, writer)).Then(handler))
+		return nil
+	}
+
 	if upstream.RewriteTarget == "" {
-		m.registerSimpleHandler(upstream.Path, handler)
+		m.registerSimpleHandler(upstream.Path, chain.Then(handler))
 		return nil
 	}
 
-	return m.registerRewriteHandler(upstream, handler, writer)
+	return m.registerRewriteHandler(upstream, chain, handler, writer)
 }
 
 // registerSimpleHandler maintains the behaviour of the go standard serveMux
@@ -114,14 +175,14 @@ func (m *multiUpstreamProxy) registerSimpleHandler(path string, handler http.Han
 // which match the regex defined in the Path.
 // Requests to the handler will have the request path rewritten before the
 // request is made to the next handler.
-func (m *multiUpstreamProxy) registerRewriteHandler(upstream options.Upstream, handler http.Handler, writer pagewriter.Writer) error {
+func (m *multiUpstreamProxy) registerRewriteHandler(upstream options.Upstream, chain alice.Chain, handler http.Handler, writer pagewriter.Writer) error {
 	rewriteRegExp, err := regexp.Compile(upstream.Path)
 	if err != nil {
 		return fmt.Errorf("invalid path %q for upstream: %v", upstream.Path, err)
 	}
 
 	rewrite := newRewritePath(rewriteRegExp, upstream.RewriteTarget, writer)
-	h := alice.New(rewrite).Then(handler)
+	h := chain.Append(rewrite).Then(handler)
 	m.serveMux.MatcherFunc(func(req *http.Request, match *mux.RouteMatch) bool {
 		return rewriteRegExp.MatchString(req.URL.Path)
 	}).Handler(h)
@@ -47,6 +47,11 @@ var _ = Describe("Proxy Suite", func() {
 							Path: "/http/",
 							URI:  serverAddr,
 						},
+						{
+							ID:   "balanced-backend",
+							Path: "/balanced/",
+							URIs: []options.UpstreamURI{{URI: serverAddr}, {URI: serverAddr, Weight: 2}},
+						},
 						{
 							ID:   "file-backend",
 							Path: "/files/",
@@ -87,6 +92,12 @@ var _ = Describe("Proxy Suite", func() {
 							RewriteTarget: "/different/backend/path/$1",
 							URI:           serverAddr,
 						},
+						{
+							ID:          "backend-with-strip-prefix",
+							Path:        "/strip-prefix/",
+							StripPrefix: true,
+							URI:         serverAddr,
+						},
 						{
 							ID:   "double-match-plain",
 							Path: "/double-match/",
@@ -101,7 +112,7 @@ var _ = Describe("Proxy Suite", func() {
 					}
 				}
 
-				upstreamServer, err := NewProxy(upstreams, sigData, writer)
+				upstreamServer, err := NewProxy(upstreams, sigData, writer, nil, nil, nil)
 				Expect(err).ToNot(HaveOccurred())
 
 				req := middlewareapi.AddRequestScope(
@@ -159,6 +170,27 @@ var _ = Describe("Proxy Suite", func() {
 				},
 				upstream: "http-backend",
 			}),
+			Entry("with a request to the load balanced HTTP service", &proxyTableInput{
+				target: "http://example.localhost/balanced/1234",
+				response: testHTTPResponse{
+					code: 200,
+					header: map[string][]string{
+						contentType: {applicationJSON},
+					},
+					request: testHTTPRequest{
+						Method: "GET",
+						URL:    "http://example.localhost/balanced/1234",
+						Header: map[string][]string{
+							"Gap-Auth":      {""},
+							"Gap-Signature": {"sha256 mYfvFu7LE1T+zqT77uDFJ+WPrmrGuAPP5G28isKfM8I="},
+						},
+						Body:       []byte{},
+						Host:       "example.localhost",
+						RequestURI: "http://example.localhost/balanced/1234",
+					},
+				},
+				upstream: "balanced-backend",
+			}),
 			Entry("with a request to the File backend", &proxyTableInput{
 				target: "http://example.localhost/files/foo",
 				response: testHTTPResponse{
@@ -231,8 +263,9 @@ var _ = Describe("Proxy Suite", func() {
 						Method: "GET",
 						URL:    "http://example.localhost/different/backend/path/1234",
 						Header: map[string][]string{
-							"Gap-Auth":      {""},
-							"Gap-Signature": {"sha256 jeAeM7wHSj2ab/l9YPvtTJ9l/8q1tpY2V/iwXF48bgw="},
+							"Gap-Auth":        {""},
+							"Gap-Signature":   {"sha256 jeAeM7wHSj2ab/l9YPvtTJ9l/8q1tpY2V/iwXF48bgw="},
+							"X-Forwarded-Uri": {"http://example.localhost/rewrite-prefix/1234"},
 						},
 						Body:       []byte{},
 						Host:       "example.localhost",
@@ -252,8 +285,9 @@ var _ = Describe("Proxy Suite", func() {
 						Method: "GET",
 						URL:    "http://example.localhost/different/backend/path/1234/abc",
 						Header: map[string][]string{
-							"Gap-Auth":      {""},
-							"Gap-Signature": {"sha256 rAkAc9gp7EndoOppJuvbuPnYuBcqrTkBnQx6iPS8xTA="},
+							"Gap-Auth":        {""},
+							"Gap-Signature":   {"sha256 rAkAc9gp7EndoOppJuvbuPnYuBcqrTkBnQx6iPS8xTA="},
+							"X-Forwarded-Uri": {"http://example.localhost/rewrite-prefix/1234/abc"},
 						},
 						Body:       []byte{},
 						Host:       "example.localhost",
@@ -262,6 +296,28 @@ var _ = Describe("Proxy Suite", func() {
 				},
 				upstream: "backend-with-rewrite-prefix",
 			}),
+			Entry("with a request to the strip prefix server", &proxyTableInput{
+				target: "http://example.localhost/strip-prefix/users/1234",
+				response: testHTTPResponse{
+					code: 200,
+					header: map[string][]string{
+						contentType: {applicationJSON},
+					},
+					request: testHTTPRequest{
+						Method: "GET",
+						URL:    "http://example.localhost/users/1234",
+						Header: map[string][]string{
+							"Gap-Auth":        {""},
+							"Gap-Signature":   {"sha256 Lube0Kl2lSKbKo1X+pigiy3v0dXTnMKzWgXXHE22+O0="},
+							"X-Forwarded-Uri": {"http://example.localhost/strip-prefix/users/1234"},
+						},
+						Body:       []byte{},
+						Host:       "example.localhost",
+						RequestURI: "http://example.localhost/users/1234",
+					},
+				},
+				upstream: "backend-with-strip-prefix",
+			}),
 			Entry("with a request to a path, missing the trailing slash", &proxyTableInput{
 				target: "http://example.localhost/http",
 				response: testHTTPResponse{
@@ -302,8 +358,9 @@ var _ = Describe("Proxy Suite", func() {
 						Method: "GET",
 						URL:    "http://example.localhost/double-match/rewrite/foo",
 						Header: map[string][]string{
-							"Gap-Auth":      {""},
-							"Gap-Signature": {"sha256 eYyUNdsrTmnvFpavpP8AdHGUGzqJ39QEjqn0/3fQPHA="},
+							"Gap-Auth":        {""},
+							"Gap-Signature":   {"sha256 eYyUNdsrTmnvFpavpP8AdHGUGzqJ39QEjqn0/3fQPHA="},
+							"X-Forwarded-Uri": {"http://example.localhost/double-match/foo"},
 						},
 						Body:       []byte{},
 						Host:       "example.localhost",
@@ -0,0 +1,79 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// retryRoundTripper sends idempotent requests to the upstream again when the
+// connection to it fails.
+// The round trip fails before the reverse proxy writes any part of the
+// response, so a retried request is never answered twice.
+type retryRoundTripper struct {
+	next     http.RoundTripper
+	upstream string
+	retries  int
+	backoff  time.Duration
+	clock    clock.Clock
+}
+
+// RoundTrip executes the request and retries it on connection errors, waiting
+// twice as long before each further retry.
+// Requests that are not retryable are executed once.
+func (t *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isRetryableRequest(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || attempt == t.retries || !isRetryableError(req.Context(), err) {
+			return resp, err
+		}
+
+		logger.Errorf("Error proxying to upstream %q, retrying in %s: %v", t.upstream, backoff, err)
+		if _, ok := <-t.clock.AfterContext(req.Context(), backoff); !ok {
+			// The request was cancelled, eg by the upstream timeout
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryableRequest checks the request is idempotent and has no body, so
+// that sending it again does not repeat its effects or need the body again
+func isRetryableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// isRetryableError checks the error is a failed connection to the upstream:
+// either the upstream could not be dialed, or it reset or closed the
+// connection before responding. Other errors, such as failed TLS handshakes,
+// would fail the same way again, and timeouts and cancelled requests are not
+// retried.
+func isRetryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,268 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// roundTripperFunc adapts a function to an http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var _ = Describe("Retry Suite", func() {
+	var flapping *httptest.Server
+	var failures, attempts int32
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&attempts, 0)
+
+		// Resets the connection of the first failures requests
+		flapping = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&attempts, 1) <= atomic.LoadInt32(&failures) {
+				conn, _, err := rw.(http.Hijacker).Hijack()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.Close()).To(Succeed())
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		flapping.Close()
+	})
+
+	type retryTableInput struct {
+		method           string
+		body             string
+		retries          int
+		failures         int32
+		expectedCode     int
+		expectedAttempts int32
+	}
+
+	DescribeTable("should retry idempotent requests on connection errors",
+		func(in retryTableInput) {
+			atomic.StoreInt32(&failures, in.failures)
+			backoff := options.Duration(time.Millisecond)
+			upstream := options.Upstream{
+				ID:           "flapping",
+				Retries:      in.retries,
+				RetryBackoff: &backoff,
+			}
+			u, err := url.Parse(flapping.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			errorHandler := func(rw http.ResponseWriter, _ *http.Request, _ error) {
+				rw.WriteHeader(http.StatusBadGateway)
+			}
+			proxy := newReverseProxy(u, upstream, options.UpstreamTransport{}, nil, errorHandler, nil)
+
+			req := httptest.NewRequest(in.method, "/", strings.NewReader(in.body))
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			Expect(atomic.LoadInt32(&attempts)).To(Equal(in.expectedAttempts))
+		},
+		Entry("succeeds a GET request after retrying", retryTableInput{
+			method:           http.MethodGet,
+			retries:          2,
+			failures:         2,
+			expectedCode:     http.StatusOK,
+			expectedAttempts: 3,
+		}),
+		Entry("succeeds a HEAD request after retrying", retryTableInput{
+			method:           http.MethodHead,
+			retries:          1,
+			failures:         1,
+			expectedCode:     http.StatusOK,
+			expectedAttempts: 2,
+		}),
+		Entry("fails a GET request once the retries are used up", retryTableInput{
+			method:           http.MethodGet,
+			retries:          2,
+			failures:         3,
+			expectedCode:     http.StatusBadGateway,
+			expectedAttempts: 3,
+		}),
+		Entry("does not retry POST requests", retryTableInput{
+			method:           http.MethodPost,
+			body:             "payload",
+			retries:          2,
+			failures:         1,
+			expectedCode:     http.StatusBadGateway,
+			expectedAttempts: 1,
+		}),
+		Entry("does not retry GET requests with a body", retryTableInput{
+			method:           http.MethodGet,
+			body:             "payload",
+			retries:          2,
+			failures:         1,
+			expectedCode:     http.StatusBadGateway,
+			expectedAttempts: 1,
+		}),
+		Entry("does not retry without retries", retryTableInput{
+			method:           http.MethodGet,
+			failures:         1,
+			expectedCode:     http.StatusBadGateway,
+			expectedAttempts: 1,
+		}),
+	)
+
+	DescribeTable("should only retry errors connecting to the upstream",
+		func(err error, expected bool) {
+			Expect(isRetryableError(context.Background(), err)).To(Equal(expected))
+		},
+		Entry("a refused connection",
+			&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true),
+		Entry("a reset connection",
+			&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true),
+		Entry("a connection closed before the response", io.EOF, true),
+		Entry("a connection closed during the response", io.ErrUnexpectedEOF, true),
+		Entry("a TLS handshake with a plain HTTP server", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, false),
+		Entry("an untrusted upstream certificate", x509.UnknownAuthorityError{}, false),
+		Entry("a cancelled request", context.Canceled, false),
+		Entry("a timed out request", context.DeadlineExceeded, false),
+		Entry("any other error", errors.New("malformed HTTP response"), false),
+	)
+
+	It("does not retry a failed TLS handshake", func() {
+		var handshakes int32
+		upstreamServer := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.WriteHeader(http.StatusOK)
+		}))
+		upstreamServer.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&handshakes, 1)
+			}
+		}
+		upstreamServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+		upstreamServer.StartTLS()
+		defer upstreamServer.Close()
+
+		backoff := options.Duration(time.Millisecond)
+		upstream := options.Upstream{
+			ID:           "untrusted",
+			Retries:      2,
+			RetryBackoff: &backoff,
+		}
+		u, err := url.Parse(upstreamServer.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		errorHandler := func(rw http.ResponseWriter, _ *http.Request, _ error) {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+		proxy := newReverseProxy(u, upstream, options.UpstreamTransport{}, nil, errorHandler, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+
+		Expect(rw.Code).To(Equal(http.StatusBadGateway))
+		Expect(atomic.LoadInt32(&handshakes)).To(Equal(int32(1)))
+	})
+
+	Context("retryRoundTripper", func() {
+		connectionReset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+
+		// failingRoundTripper fails the first failures requests, recording
+		// the time of each attempt
+		type failingRoundTripper struct {
+			mu       sync.Mutex
+			failures int
+			attempts []time.Time
+			now      func() time.Time
+		}
+		roundTrip := func(f *failingRoundTripper) roundTripperFunc {
+			return func(*http.Request) (*http.Response, error) {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.attempts = append(f.attempts, f.now())
+				if len(f.attempts) <= f.failures {
+					return nil, connectionReset
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}
+		}
+
+		It("waits twice as long before each further retry", func() {
+			rt := &retryRoundTripper{retries: 2, backoff: 100 * time.Millisecond}
+			start := time.Unix(1234567890, 0)
+			rt.clock.Set(start)
+			defer rt.clock.Reset()
+
+			failing := &failingRoundTripper{failures: 2, now: rt.clock.Now}
+			rt.next = roundTrip(failing)
+
+			done := make(chan error)
+			go func() {
+				resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+				if err == nil {
+					resp.Body.Close()
+				}
+				done <- err
+			}()
+
+			var err error
+			Eventually(func() bool {
+				select {
+				case err = <-done:
+					return true
+				default:
+					Expect(rt.clock.Add(time.Millisecond)).To(Succeed())
+					return false
+				}
+			}, 10*time.Second, time.Millisecond).Should(BeTrue())
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(failing.attempts).To(HaveLen(3))
+			Expect(failing.attempts[1].Sub(failing.attempts[0])).To(BeNumerically(">=", 100*time.Millisecond))
+			Expect(failing.attempts[2].Sub(failing.attempts[1])).To(BeNumerically(">=", 200*time.Millisecond))
+		})
+
+		It("stops retrying once the request is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			failing := &failingRoundTripper{failures: 1, now: time.Now}
+			rt := &retryRoundTripper{retries: 2, backoff: time.Hour, next: roundTrip(failing)}
+
+			go func() {
+				Eventually(func() int {
+					failing.mu.Lock()
+					defer failing.mu.Unlock()
+					return len(failing.attempts)
+				}).Should(Equal(1))
+				cancel()
+			}()
+
+			_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+			Expect(err).To(MatchError(connectionReset))
+			Expect(failing.attempts).To(HaveLen(1))
+		})
+	})
+})
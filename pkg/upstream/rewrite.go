@@ -11,6 +11,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 )
 
 // newRewritePath creates a new middleware that will rewrite the request URI
@@ -32,6 +33,7 @@ func rewritePath(rewriteRegExp *regexp.Regexp, rewriteTarget string, writer page
 				Status:    http.StatusInternalServerError,
 				RequestID: middleware.GetRequestScope(req).RequestID,
 				AppError:  fmt.Sprintf("Could not parse request URI: %v", err),
+				Request:   req,
 			})
 			return
 		}
@@ -45,15 +47,137 @@ func rewritePath(rewriteRegExp *regexp.Regexp, rewriteTarget string, writer page
 				Status:    http.StatusInternalServerError,
 				RequestID: middleware.GetRequestScope(req).RequestID,
 				AppError:  fmt.Sprintf("Could not parse rewrite URI: %v", err),
+				Request:   req,
 			})
 			return
 		}
 
+		setForwardedURI(req)
 		req.RequestURI = reqURL.String()
 		next.ServeHTTP(rw, req)
 	})
 }
 
+// newStripPrefix creates a new middleware that will remove the prefix from
+// the request URI path before handing the request to the next server.
+func newStripPrefix(prefix string, writer pagewriter.Writer) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return stripPrefix(prefix, writer, next)
+	}
+}
+
+// stripPrefix removes the prefix from the request URI path and adds it back
+// to any path-absolute Location header in the response.
+func stripPrefix(prefix string, writer pagewriter.Writer, next http.Handler) http.Handler {
+	// The path sent upstream should always start with a slash, so the
+	// trailing slash of the prefix is kept.
+	trimPrefix := strings.TrimSuffix(prefix, "/")
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		reqURL, err := url.ParseRequestURI(req.RequestURI)
+		if err != nil {
+			logger.Errorf("could not parse request URI: %v", err)
+			writer.WriteErrorPage(rw, pagewriter.ErrorPageOpts{
+				Status:    http.StatusInternalServerError,
+				RequestID: middleware.GetRequestScope(req).RequestID,
+				AppError:  fmt.Sprintf("Could not parse request URI: %v", err),
+				Request:   req,
+			})
+			return
+		}
+
+		reqURL.Path = ensureLeadingSlash(strings.TrimPrefix(reqURL.Path, trimPrefix))
+		if reqURL.RawPath != "" {
+			reqURL.RawPath = ensureLeadingSlash(strings.TrimPrefix(reqURL.RawPath, trimPrefix))
+		}
+
+		setForwardedURI(req)
+		req.RequestURI = reqURL.String()
+		next.ServeHTTP(&prefixLocationResponseWriter{ResponseWriter: rw, prefix: trimPrefix, host: req.Host}, req)
+	})
+}
+
+// setForwardedURI passes the original request URI to the upstream so that it
+// can build URLs relative to the path the client requested.
+func setForwardedURI(req *http.Request) {
+	req.Header.Set(requestutil.XForwardedURI, req.RequestURI)
+}
+
+func ensureLeadingSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
+
+// prefixLocationResponseWriter adds the stripped prefix back to Location
+// headers that point at the proxy, so that redirects from the upstream
+// reflect the path the client requested.
+type prefixLocationResponseWriter struct {
+	http.ResponseWriter
+	prefix      string
+	host        string
+	wroteHeader bool
+}
+
+// WriteHeader rewrites the Location header before writing the status code.
+func (w *prefixLocationResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if location := w.Header().Get("Location"); location != "" {
+			w.Header().Set("Location", w.rewriteLocation(location))
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write ensures the Location header is rewritten when the status code is
+// written implicitly.
+func (w *prefixLocationResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush allows streaming responses to be flushed through the writer.
+func (w *prefixLocationResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer so that http.ResponseController can
+// hijack the connection, eg to proxy websockets.
+func (w *prefixLocationResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// rewriteLocation adds the prefix to path-absolute locations and to absolute
+// locations on the host the client requested.
+// Relative locations and locations on other hosts are left unchanged.
+func (w *prefixLocationResponseWriter) rewriteLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	switch {
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/"):
+		// Path-absolute location, eg /users
+	case u.Host != "" && u.Host == w.host:
+		// Absolute location on the proxy host
+	default:
+		return location
+	}
+
+	u.Path = w.prefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = w.prefix + u.RawPath
+	}
+	return u.String()
+}
+
 // splitPathAndQuery splits the rewritten path into the URL Path and the URL
 // raw query. Any rewritten query values are appended to the original query
 // values.
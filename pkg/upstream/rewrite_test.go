@@ -57,4 +57,77 @@ var _ = Describe("Rewrite", func() {
 			expectedRequestURI: "http://example.com/article?id=blog-2021-01-01",
 		}),
 	)
+
+	type stripPrefixTableInput struct {
+		prefix             string
+		requestTarget      string
+		responseLocation   string
+		expectedRequestURI string
+		expectedLocation   string
+	}
+
+	DescribeTable("should strip the prefix from the request path",
+		func(in stripPrefixTableInput) {
+			req := httptest.NewRequest("", in.requestTarget, nil)
+			rw := httptest.NewRecorder()
+
+			var gotRequestURI, gotForwardedURI string
+			handler := newStripPrefix(in.prefix, &pagewriter.WriterFuncs{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRequestURI = r.RequestURI
+				gotForwardedURI = r.Header.Get("X-Forwarded-Uri")
+				if in.responseLocation != "" {
+					w.Header().Set("Location", in.responseLocation)
+					w.WriteHeader(http.StatusFound)
+				}
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(gotRequestURI).To(Equal(in.expectedRequestURI))
+			Expect(gotForwardedURI).To(Equal(in.requestTarget))
+			Expect(rw.Header().Get("Location")).To(Equal(in.expectedLocation))
+		},
+		Entry("when the prefix has a trailing slash", stripPrefixTableInput{
+			prefix:             "/api/",
+			requestTarget:      "/api/users?id=1",
+			expectedRequestURI: "/users?id=1",
+		}),
+		Entry("when the request matches the prefix exactly", stripPrefixTableInput{
+			prefix:             "/api",
+			requestTarget:      "/api",
+			expectedRequestURI: "/",
+		}),
+		Entry("when the path contains escaped slashes", stripPrefixTableInput{
+			prefix:             "/api/",
+			requestTarget:      "/api/users/a%2Fb",
+			expectedRequestURI: "/users/a%2Fb",
+		}),
+		Entry("when the upstream redirects to a path-absolute location", stripPrefixTableInput{
+			prefix:             "/api/",
+			requestTarget:      "/api/users",
+			responseLocation:   "/login?next=%2Fusers",
+			expectedRequestURI: "/users",
+			expectedLocation:   "/api/login?next=%2Fusers",
+		}),
+		Entry("when the upstream redirects to an absolute location on the proxy host", stripPrefixTableInput{
+			prefix:             "/api/",
+			requestTarget:      "http://example.com/api/users",
+			responseLocation:   "http://example.com/users/",
+			expectedRequestURI: "http://example.com/users",
+			expectedLocation:   "http://example.com/api/users/",
+		}),
+		Entry("when the upstream redirects to another host", stripPrefixTableInput{
+			prefix:             "/api/",
+			requestTarget:      "http://example.com/api/users",
+			responseLocation:   "https://idp.example.com/authorize",
+			expectedRequestURI: "http://example.com/users",
+			expectedLocation:   "https://idp.example.com/authorize",
+		}),
+		Entry("when the upstream redirects to a relative location", stripPrefixTableInput{
+			prefix:             "/api/",
+			requestTarget:      "/api/users",
+			responseLocation:   "users/",
+			expectedRequestURI: "/users",
+			expectedLocation:   "users/",
+		}),
+	)
 })
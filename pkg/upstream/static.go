@@ -1,28 +1,99 @@
 package upstream
 
 import (
+	"bytes"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
 	"net/http"
+	"os"
+	"text/template"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
-const defaultStaticResponseCode = 200
+const (
+	defaultStaticResponseCode = 200
+
+	defaultStaticContentType = "text/plain; charset=utf-8"
+)
+
+// staticTemplate is satisfied by both text and HTML templates.
+type staticTemplate interface {
+	Execute(io.Writer, interface{}) error
+}
+
+// staticTemplateData is the data available to static response templates.
+type staticTemplateData struct {
+	User              string
+	Email             string
+	PreferredUsername string
+	Groups            []string
+	Path              string
+}
 
 // newStaticResponseHandler creates a new staticResponseHandler that serves a
 // a static response code.
-func newStaticResponseHandler(upstream string, code *int) http.Handler {
-	return &staticResponseHandler{
-		code:     derefStaticCode(code),
-		upstream: upstream,
+// When the upstream has a StaticBody or StaticBodyFile, the template is parsed
+// and executed once so that errors are returned before any request is served.
+func newStaticResponseHandler(upstream options.Upstream) (http.Handler, error) {
+	handler := &staticResponseHandler{
+		code:     derefStaticCode(upstream.StaticCode),
+		upstream: upstream.ID,
+	}
+
+	body := upstream.StaticBody
+	if upstream.StaticBodyFile != "" {
+		data, err := os.ReadFile(upstream.StaticBodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read static body file: %v", err)
+		}
+		body = string(data)
+	}
+	if body == "" {
+		return handler, nil
+	}
+
+	handler.contentType = upstream.StaticContentType
+	if handler.contentType == "" {
+		handler.contentType = defaultStaticContentType
+	}
+
+	tmpl, err := parseStaticTemplate(upstream.ID, body, handler.contentType)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse static body template: %v", err)
+	}
+	if err := tmpl.Execute(io.Discard, staticTemplateData{}); err != nil {
+		return nil, fmt.Errorf("could not render static body template: %v", err)
+	}
+	handler.template = tmpl
+
+	return handler, nil
+}
+
+// parseStaticTemplate parses the body as an HTML template when the content
+// type is HTML so that user values are escaped, and as a text template otherwise.
+func parseStaticTemplate(name, body, contentType string) (staticTemplate, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %v", contentType, err)
+	}
+
+	if mediaType == "text/html" {
+		return htmltemplate.New(name).Parse(body)
 	}
+	return template.New(name).Parse(body)
 }
 
 // staticResponseHandler responds with a static response with the given response code.
 type staticResponseHandler struct {
-	code     int
-	upstream string
+	code        int
+	upstream    string
+	contentType string
+	template    staticTemplate
 }
 
 // ServeHTTP serves a static response.
@@ -32,6 +103,11 @@ func (s *staticResponseHandler) ServeHTTP(rw http.ResponseWriter, req *http.Requ
 	// A scope should always be injected before this handler is called.
 	scope.Upstream = s.upstream
 
+	if s.template != nil {
+		s.serveTemplate(rw, req, scope)
+		return
+	}
+
 	rw.WriteHeader(s.code)
 	_, err := fmt.Fprintf(rw, "Authenticated")
 	if err != nil {
@@ -39,6 +115,34 @@ func (s *staticResponseHandler) ServeHTTP(rw http.ResponseWriter, req *http.Requ
 	}
 }
 
+// serveTemplate renders the body template with the session from the request scope.
+// The body is rendered before the response code is written so that a failed
+// render can still return an error.
+func (s *staticResponseHandler) serveTemplate(rw http.ResponseWriter, req *http.Request, scope *middleware.RequestScope) {
+	data := staticTemplateData{
+		Path: req.URL.Path,
+	}
+	if scope.Session != nil {
+		data.User = scope.Session.User
+		data.Email = scope.Session.Email
+		data.PreferredUsername = scope.Session.PreferredUsername
+		data.Groups = scope.Session.Groups
+	}
+
+	var buf bytes.Buffer
+	if err := s.template.Execute(&buf, data); err != nil {
+		logger.Errorf("Error rendering static response for upstream %q: %v", s.upstream, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", s.contentType)
+	rw.WriteHeader(s.code)
+	if _, err := buf.WriteTo(rw); err != nil {
+		logger.Errorf("Error writing static response: %v", err)
+	}
+}
+
 // derefStaticCode returns the derefenced value, or the default if the value is nil
 func derefStaticCode(code *int) int {
 	if code != nil {
@@ -5,8 +5,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -38,7 +42,8 @@ var _ = Describe("Static Response Suite", func() {
 			if in.staticCode != 0 {
 				code = &in.staticCode
 			}
-			handler := newStaticResponseHandler(id, code)
+			handler, err := newStaticResponseHandler(options.Upstream{ID: id, StaticCode: code})
+			Expect(err).ToNot(HaveOccurred())
 
 			req := httptest.NewRequest("", in.requestPath, nil)
 			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
@@ -83,4 +88,130 @@ var _ = Describe("Static Response Suite", func() {
 			expectedCode: http.StatusTeapot,
 		}),
 	)
+
+	serviceUnavailable := http.StatusServiceUnavailable
+
+	type templateTableInput struct {
+		upstream            options.Upstream
+		session             *sessionsapi.SessionState
+		expectedBody        string
+		expectedCode        int
+		expectedContentType string
+	}
+
+	DescribeTable("staticResponse ServeHTTP with a body template",
+		func(in *templateTableInput) {
+			in.upstream.ID = id
+			handler, err := newStaticResponseHandler(in.upstream)
+			Expect(err).ToNot(HaveOccurred())
+
+			req := httptest.NewRequest("", "/version", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: in.session})
+
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			Expect(rw.Header().Get("Content-Type")).To(Equal(in.expectedContentType))
+			Expect(rw.Body.String()).To(Equal(in.expectedBody))
+		},
+		Entry("with a plain body", &templateTableInput{
+			upstream: options.Upstream{
+				StaticBody: "v1.2.3",
+			},
+			expectedBody:        "v1.2.3",
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/plain; charset=utf-8",
+		}),
+		Entry("with session values", &templateTableInput{
+			upstream: options.Upstream{
+				StaticBody: `{{.User}} {{.Email}} {{.PreferredUsername}} {{range .Groups}}[{{.}}]{{end}} {{.Path}}`,
+			},
+			session: &sessionsapi.SessionState{
+				User:              "user",
+				Email:             "user@example.com",
+				PreferredUsername: "User",
+				Groups:            []string{"a", "b"},
+			},
+			expectedBody:        "user user@example.com User [a][b] /version",
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/plain; charset=utf-8",
+		}),
+		Entry("without a session", &templateTableInput{
+			upstream: options.Upstream{
+				StaticBody: `{{if .Email}}{{.Email}}{{else}}anonymous{{end}}`,
+			},
+			expectedBody:        "anonymous",
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/plain; charset=utf-8",
+		}),
+		Entry("with a content type and code", &templateTableInput{
+			upstream: options.Upstream{
+				StaticCode:        &serviceUnavailable,
+				StaticBody:        `{"message":"maintenance"}`,
+				StaticContentType: "application/json",
+			},
+			expectedBody:        `{"message":"maintenance"}`,
+			expectedCode:        http.StatusServiceUnavailable,
+			expectedContentType: "application/json",
+		}),
+		Entry("with an HTML content type escapes the session values", &templateTableInput{
+			upstream: options.Upstream{
+				StaticBody:        `<p>{{.User}}</p>`,
+				StaticContentType: "text/html; charset=utf-8",
+			},
+			session: &sessionsapi.SessionState{
+				User: "<script>",
+			},
+			expectedBody:        "<p>&lt;script&gt;</p>",
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+		}),
+	)
+
+	It("renders the body from a file", func() {
+		dir, err := os.MkdirTemp("", "static-body")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		bodyFile := filepath.Join(dir, "maintenance.html")
+		Expect(os.WriteFile(bodyFile, []byte("<p>Hello {{.Email}}</p>"), 0600)).To(Succeed())
+
+		handler, err := newStaticResponseHandler(options.Upstream{
+			ID:                id,
+			StaticBodyFile:    bodyFile,
+			StaticContentType: "text/html",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest("", "/", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+			Session: &sessionsapi.SessionState{Email: "user@example.com"},
+		})
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(Equal("<p>Hello user@example.com</p>"))
+	})
+
+	DescribeTable("newStaticResponseHandler with an invalid body template",
+		func(upstream options.Upstream, expectedErr string) {
+			_, err := newStaticResponseHandler(upstream)
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("with a syntax error", options.Upstream{
+			StaticBody: "{{.User",
+		}, "could not parse static body template"),
+		Entry("with an unknown field", options.Upstream{
+			StaticBody: "{{.Unknown}}",
+		}, "could not render static body template"),
+		Entry("with an invalid content type", options.Upstream{
+			StaticBody:        "body",
+			StaticContentType: ";",
+		}, "invalid content type"),
+		Entry("with a missing file", options.Upstream{
+			StaticBodyFile: "/does/not/exist",
+		}, "could not read static body file"),
+	)
 })
@@ -0,0 +1,80 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// ReauthenticateFunc clears the session of a request the upstream rejected
+// and sends the user to login again.
+// When it does not handle the request, eg because the session is too new to
+// start another login without risking a redirect loop, false is returned.
+type ReauthenticateFunc func(rw http.ResponseWriter, req *http.Request) bool
+
+// upstreamStatusError is returned for upstream responses with a status that
+// has an action other than passing the response through.
+type upstreamStatusError struct {
+	upstream string
+	status   int
+	action   string
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream %q responded with status %d", e.upstream, e.status)
+}
+
+// newStatusActionsModifier creates a reverse proxy ModifyResponse func that
+// fails the responses with a status that has an action, so that the action is
+// taken by the error handler instead of proxying the response.
+func newStatusActionsModifier(upstream options.Upstream) func(*http.Response) error {
+	actions := make(map[int]string, len(upstream.StatusActions))
+	for _, statusAction := range upstream.StatusActions {
+		if statusAction.Action != options.UpstreamStatusActionPassThrough {
+			actions[statusAction.Status] = statusAction.Action
+		}
+	}
+
+	return func(resp *http.Response) error {
+		action, ok := actions[resp.StatusCode]
+		if !ok {
+			return nil
+		}
+		return &upstreamStatusError{
+			upstream: upstream.ID,
+			status:   resp.StatusCode,
+			action:   action,
+		}
+	}
+}
+
+// newStatusActionsErrorHandler creates an error handler that takes the action
+// for upstream status errors and passes any other error on to the next handler.
+func newStatusActionsErrorHandler(writer pagewriter.Writer, reauthenticate ReauthenticateFunc, next ProxyErrorHandler) ProxyErrorHandler {
+	return func(rw http.ResponseWriter, req *http.Request, err error) {
+		var statusErr *upstreamStatusError
+		if !errors.As(err, &statusErr) {
+			next(rw, req, err)
+			return
+		}
+
+		if statusErr.action == options.UpstreamStatusActionReauthenticate && reauthenticate != nil {
+			if reauthenticate(rw, req) {
+				return
+			}
+			logger.Printf("Returning status %d from upstream %q instead of starting another login", statusErr.status, statusErr.upstream)
+		}
+
+		writer.WriteErrorPage(rw, pagewriter.ErrorPageOpts{
+			Status:    statusErr.status,
+			RequestID: middleware.GetRequestScope(req).RequestID,
+			AppError:  statusErr.Error(),
+			Request:   req,
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Status Actions Suite", func() {
+	var statusServer *httptest.Server
+
+	BeforeEach(func() {
+		// Responds with the status code in the request path, eg /401
+		statusServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			status, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/"))
+			Expect(err).ToNot(HaveOccurred())
+			rw.WriteHeader(status)
+			_, _ = rw.Write([]byte("upstream body"))
+		}))
+	})
+
+	AfterEach(func() {
+		statusServer.Close()
+	})
+
+	type statusActionsTableInput struct {
+		status         int
+		reauthenticate ReauthenticateFunc
+		expectedCode   int
+		expectedBody   string
+	}
+
+	reauthenticated := func(rw http.ResponseWriter, _ *http.Request) bool {
+		rw.Header().Set("Location", "/oauth2/start")
+		rw.WriteHeader(http.StatusFound)
+		return true
+	}
+	sessionTooNew := func(http.ResponseWriter, *http.Request) bool {
+		return false
+	}
+
+	DescribeTable("should take the action for the upstream status",
+		func(in statusActionsTableInput) {
+			upstream := options.Upstream{
+				ID:   "statusActions",
+				Path: "/",
+				URI:  statusServer.URL,
+				StatusActions: []options.UpstreamStatusAction{
+					{Status: http.StatusUnauthorized, Action: options.UpstreamStatusActionReauthenticate},
+					{Status: http.StatusForbidden, Action: options.UpstreamStatusActionErrorPage},
+					{Status: http.StatusNotFound, Action: options.UpstreamStatusActionPassThrough},
+				},
+			}
+
+			proxy, err := NewProxy(options.UpstreamConfig{Upstreams: []options.Upstream{upstream}}, nil, &pagewriter.WriterFuncs{}, nil, nil, in.reauthenticate)
+			Expect(err).ToNot(HaveOccurred())
+
+			req := httptest.NewRequest(http.MethodGet, "/"+strconv.Itoa(in.status), nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			body, err := io.ReadAll(rw.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal(in.expectedBody))
+		},
+		Entry("passes through statuses without an action", statusActionsTableInput{
+			status:         http.StatusOK,
+			reauthenticate: reauthenticated,
+			expectedCode:   http.StatusOK,
+			expectedBody:   "upstream body",
+		}),
+		Entry("passes through statuses with the passThrough action", statusActionsTableInput{
+			status:         http.StatusNotFound,
+			reauthenticate: reauthenticated,
+			expectedCode:   http.StatusNotFound,
+			expectedBody:   "upstream body",
+		}),
+		Entry("reauthenticates with the reauthenticate action", statusActionsTableInput{
+			status:         http.StatusUnauthorized,
+			reauthenticate: reauthenticated,
+			expectedCode:   http.StatusFound,
+			expectedBody:   "",
+		}),
+		Entry("renders the error page when the session is too new to reauthenticate", statusActionsTableInput{
+			status:         http.StatusUnauthorized,
+			reauthenticate: sessionTooNew,
+			expectedCode:   http.StatusUnauthorized,
+			expectedBody:   "401 - upstream \"statusActions\" responded with status 401",
+		}),
+		Entry("renders the error page when reauthentication is not available", statusActionsTableInput{
+			status:       http.StatusUnauthorized,
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "401 - upstream \"statusActions\" responded with status 401",
+		}),
+		Entry("renders the error page with the errorPage action", statusActionsTableInput{
+			status:         http.StatusForbidden,
+			reauthenticate: reauthenticated,
+			expectedCode:   http.StatusForbidden,
+			expectedBody:   "403 - upstream \"statusActions\" responded with status 403",
+		}),
+	)
+
+	It("passes other proxy errors to the proxy error handler", func() {
+		upstream := options.Upstream{
+			ID:            "statusActions",
+			Path:          "/",
+			URI:           invalidServer,
+			StatusActions: []options.UpstreamStatusAction{{Status: http.StatusForbidden, Action: options.UpstreamStatusActionErrorPage}},
+		}
+
+		proxy, err := NewProxy(options.UpstreamConfig{Upstreams: []options.Upstream{upstream}}, nil, &pagewriter.WriterFuncs{}, nil, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/403", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+
+		Expect(rw.Code).To(Equal(http.StatusBadGateway))
+	})
+})
@@ -0,0 +1,36 @@
+package upstream
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// StepUpFunc checks the session of the request was authenticated with one of
+// the acrValues within the maxAge.
+// When it was not, the request is handled (eg by starting a new login that
+// requests a stronger authentication) and false is returned.
+type StepUpFunc func(rw http.ResponseWriter, req *http.Request, acrValues []string, maxAge time.Duration) bool
+
+// requiresStepUp returns true when the upstream has authentication
+// requirements beyond a valid session
+func requiresStepUp(upstream options.Upstream) bool {
+	return len(upstream.ACRValues) > 0 || upstream.MaxAge.Duration() > 0
+}
+
+// newStepUp creates a middleware that only passes requests on to the upstream
+// when the session meets the upstream's authentication requirements
+func newStepUp(upstream options.Upstream, stepUp StepUpFunc) alice.Constructor {
+	acrValues := upstream.ACRValues
+	maxAge := upstream.MaxAge.Duration()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if !stepUp(rw, req, acrValues, maxAge) {
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
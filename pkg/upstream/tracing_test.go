@@ -0,0 +1,70 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/tracing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("Tracing Suite", func() {
+	var recorder *tracetest.SpanRecorder
+	var upstreamServer *httptest.Server
+	var gotTraceparent string
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+
+		gotTraceparent = ""
+		upstreamServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			gotTraceparent = req.Header.Get("traceparent")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	})
+
+	AfterEach(func() {
+		upstreamServer.Close()
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	It("records the upstream request as a child of the request span", func() {
+		u, err := url.Parse(upstreamServer.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		handler, err := newHTTPUpstreamProxy(options.Upstream{ID: "traced", URI: upstreamServer.URL}, u, options.UpstreamTransport{}, nil, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := middlewareapi.AddRequestScope(httptest.NewRequest(http.MethodGet, "/", nil), &middlewareapi.RequestScope{})
+		ctx, span := tracing.Tracer().Start(req.Context(), "HTTP GET")
+		req = req.WithContext(ctx)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		span.End()
+		Expect(rw.Code).To(Equal(http.StatusServiceUnavailable))
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		client, server := spans[0], spans[1]
+		Expect(client.SpanKind()).To(Equal(trace.SpanKindClient))
+		Expect(client.Parent().SpanID()).To(Equal(server.SpanContext().SpanID()))
+		Expect(client.Status().Code).To(Equal(codes.Error))
+		Expect(client.Attributes()).To(ContainElement(HaveField("Key", BeEquivalentTo("upstream.id"))))
+
+		// The upstream continues the trace from the client span
+		Expect(gotTraceparent).To(Equal("00-" + server.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"))
+	})
+})
@@ -0,0 +1,57 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"golang.org/x/net/http/httpguts"
+)
+
+// newWebSocketOrigins creates a middleware that rejects WebSocket upgrade
+// requests from an origin that is not allowed for the upstream before they are
+// proxied, to protect upstreams from cross-site WebSocket hijacking.
+// Upgrade requests without an Origin header are rejected too.
+// Other requests are passed through unchanged.
+func newWebSocketOrigins(origins []string, writer pagewriter.Writer) alice.Constructor {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(origin)] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if !isWebSocketUpgrade(req) {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			origin := req.Header.Get("Origin")
+			if _, ok := allowed[strings.ToLower(origin)]; !ok {
+				appError := fmt.Sprintf("WebSocket origin %q is not allowed", origin)
+				if origin == "" {
+					appError = "WebSocket upgrade requests must have an Origin header"
+				}
+				writer.WriteErrorPage(rw, pagewriter.ErrorPageOpts{
+					Status:    http.StatusForbidden,
+					RequestID: middleware.GetRequestScope(req).RequestID,
+					AppError:  appError,
+					Request:   req,
+				})
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// isWebSocketUpgrade returns true for requests to upgrade the connection to a
+// WebSocket. The Connection header may list other options, eg
+// `keep-alive, Upgrade`.
+func isWebSocketUpgrade(req *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(req.Header["Connection"], "upgrade") &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
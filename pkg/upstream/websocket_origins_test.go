@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+)
+
+var _ = Describe("WebSocket Origins Suite", func() {
+	type webSocketOriginsTableInput struct {
+		headers      map[string]string
+		expectedCode int
+	}
+
+	upgrade := func(origin string) map[string]string {
+		headers := map[string]string{
+			"Connection": "Upgrade",
+			"Upgrade":    "websocket",
+		}
+		if origin != "" {
+			headers["Origin"] = origin
+		}
+		return headers
+	}
+
+	DescribeTable("should only upgrade connections from allowed origins",
+		func(in webSocketOriginsTableInput) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			for name, value := range in.headers {
+				req.Header.Set(name, value)
+			}
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+
+			origins := []string{"https://app.example.com", "http://localhost:3000"}
+			handler := newWebSocketOrigins(origins, &pagewriter.WriterFuncs{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+		},
+		Entry("with an allowed origin", webSocketOriginsTableInput{
+			headers:      upgrade("https://app.example.com"),
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with an allowed origin in a different case", webSocketOriginsTableInput{
+			headers:      upgrade("HTTPS://App.Example.com"),
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with an allowed origin and other connection options", webSocketOriginsTableInput{
+			headers: map[string]string{
+				"Connection": "keep-alive, Upgrade",
+				"Upgrade":    "websocket",
+				"Origin":     "http://localhost:3000",
+			},
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with a disallowed origin", webSocketOriginsTableInput{
+			headers:      upgrade("https://evil.example.com"),
+			expectedCode: http.StatusForbidden,
+		}),
+		Entry("with an allowed origin on another port", webSocketOriginsTableInput{
+			headers:      upgrade("http://localhost:8080"),
+			expectedCode: http.StatusForbidden,
+		}),
+		Entry("without an origin", webSocketOriginsTableInput{
+			headers:      upgrade(""),
+			expectedCode: http.StatusForbidden,
+		}),
+		Entry("with a disallowed disguised upgrade", webSocketOriginsTableInput{
+			headers: map[string]string{
+				"Connection": "upgrade",
+				"Upgrade":    "WebSocket",
+				"Origin":     "https://evil.example.com",
+			},
+			expectedCode: http.StatusForbidden,
+		}),
+		Entry("with a non WebSocket request from a disallowed origin", webSocketOriginsTableInput{
+			headers:      map[string]string{"Origin": "https://evil.example.com"},
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with a non WebSocket request without an origin", webSocketOriginsTableInput{
+			expectedCode: http.StatusOK,
+		}),
+	)
+
+	Context("with a websocket upstream", func() {
+		var proxyServer *httptest.Server
+
+		BeforeEach(func() {
+			upstream := options.Upstream{
+				ID:                      "websocketOrigins",
+				Path:                    "/",
+				URI:                     serverAddr,
+				AllowedWebSocketOrigins: []string{"http://app.example.localhost"},
+			}
+
+			proxy, err := NewProxy(options.UpstreamConfig{Upstreams: []options.Upstream{upstream}}, nil, &pagewriter.WriterFuncs{}, nil, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			proxyServer = httptest.NewServer(middleware.NewScope(false, "X-Request-Id")(proxy))
+		})
+
+		AfterEach(func() {
+			proxyServer.Close()
+		})
+
+		wsAddr := func() string {
+			proxyURL, err := url.Parse(proxyServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			return fmt.Sprintf("ws://%s/", proxyURL.Host)
+		}
+
+		It("proxies websockets from an allowed origin", func() {
+			ws, err := websocket.Dial(wsAddr(), "", "http://app.example.localhost")
+			Expect(err).ToNot(HaveOccurred())
+			defer ws.Close()
+
+			Expect(websocket.Message.Send(ws, []byte("Hello"))).To(Succeed())
+			var response testWebSocketResponse
+			Expect(websocket.JSON.Receive(ws, &response)).To(Succeed())
+			Expect(response.Origin).To(Equal("http://app.example.localhost"))
+		})
+
+		It("rejects websockets from a disallowed origin", func() {
+			_, err := websocket.Dial(wsAddr(), "", "http://evil.example.localhost")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("bad status"))
+		})
+
+		It("proxies HTTP requests", func() {
+			response, err := http.Get(proxyServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -14,11 +14,21 @@ import (
 	"time"
 )
 
-func GetCertPool(paths []string) (*x509.CertPool, error) {
+// GetCertPool loads the CA certificates from the given paths into a pool.
+// When useSystemPool is set the certificates are added to a copy of the
+// system trust store instead of an empty pool.
+func GetCertPool(paths []string, useSystemPool bool) (*x509.CertPool, error) {
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("invalid empty list of Root CAs file paths")
 	}
 	pool := x509.NewCertPool()
+	if useSystemPool {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the system certificate pool: %v", err)
+		}
+		pool = systemPool
+	}
 	for _, path := range paths {
 		// Cert paths are a configurable option
 		data, err := os.ReadFile(path) // #nosec G304
@@ -190,7 +190,7 @@ func makeTestCertFile(t *testing.T, pem, dir string) *os.File {
 }
 
 func TestGetCertPool_NoRoots(t *testing.T) {
-	_, err := GetCertPool([]string(nil))
+	_, err := GetCertPool([]string(nil), false)
 	assert.Error(t, err, "invalid empty list of Root CAs file paths")
 }
 
@@ -207,7 +207,7 @@ func TestGetCertPool(t *testing.T) {
 	certFile1 := makeTestCertFile(t, root1Cert, tempDir)
 	certFile2 := makeTestCertFile(t, root2Cert, tempDir)
 
-	certPool, err := GetCertPool([]string{certFile1.Name(), certFile2.Name()})
+	certPool, err := GetCertPool([]string{certFile1.Name(), certFile2.Name()}, false)
 	assert.NoError(t, err)
 
 	cert1Block, _ := pem.Decode([]byte(cert1Cert))
@@ -235,3 +235,24 @@ func TestGetCertPool(t *testing.T) {
 	_, err3 := cert3.Verify(opts)
 	assert.Error(t, err3)
 }
+
+func TestGetCertPoolWithSystemPool(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "certtest")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	certFile1 := makeTestCertFile(t, root1Cert, tempDir)
+
+	certPool, err := GetCertPool([]string{certFile1.Name()}, true)
+	assert.NoError(t, err)
+
+	systemPool, err := x509.SystemCertPool()
+	assert.NoError(t, err)
+	assert.False(t, certPool.Equal(systemPool))
+
+	// "cert1" should be valid because "root1" was added to the system pool
+	cert1Block, _ := pem.Decode([]byte(cert1Cert))
+	cert1, _ := x509.ParseCertificate(cert1Block.Bytes)
+	_, err = cert1.Verify(x509.VerifyOptions{Roots: certPool})
+	assert.NoError(t, err)
+}
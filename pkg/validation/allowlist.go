@@ -17,8 +17,12 @@ func validateAllowlists(o *options.Options) []string {
 	msgs = append(msgs, validateAuthRegexes(o)...)
 	msgs = append(msgs, validateTrustedIPs(o)...)
 
+	if o.TrustedIPUser != "" && len(o.TrustedIPs) == 0 {
+		msgs = append(msgs, "trusted_ip_user requires trusted_ips to be set")
+	}
+
 	if len(o.TrustedIPs) > 0 && o.ReverseProxy {
-		_, err := fmt.Fprintln(os.Stderr, "WARNING: mixing --trusted-ip with --reverse-proxy is a potential security vulnerability. An attacker can inject a trusted IP into an X-Real-IP or X-Forwarded-For header if they aren't properly protected outside of oauth2-proxy")
+		_, err := fmt.Fprintln(os.Stderr, "WARNING: mixing --trusted-ip with --reverse-proxy is a potential security vulnerability. An attacker can inject a trusted IP into an X-Real-IP or X-Forwarded-For header if they aren't properly protected outside of oauth2-proxy. Use --trusted-proxy-cidr to only trust the addresses added by your proxies")
 		if err != nil {
 			panic(err)
 		}
@@ -37,6 +41,7 @@ func validateAuthRoutes(o *options.Options) []string {
 			regex = parts[0]
 		} else {
 			regex = parts[1]
+			msgs = append(msgs, validateAuthRouteMethods(route, strings.TrimSuffix(parts[0], "!"))...)
 		}
 		_, err := regexp.Compile(regex)
 		if err != nil {
@@ -46,6 +51,19 @@ func validateAuthRoutes(o *options.Options) []string {
 	return msgs
 }
 
+// validateAuthRouteMethods validates the GET|HEAD style method sets of routes
+func validateAuthRouteMethods(route, methods string) []string {
+	if methods == "" {
+		return []string{}
+	}
+	for _, method := range strings.Split(methods, "|") {
+		if method == "" {
+			return []string{fmt.Sprintf("invalid skip auth route %q: methods must not be empty", route)}
+		}
+	}
+	return []string{}
+}
+
 // validateRegex validates regex paths passed with options.SkipAuthRegex
 func validateAuthRegexes(o *options.Options) []string {
 	return validateRegexes(o.SkipAuthRegex)
@@ -78,3 +96,17 @@ func validateRegexes(regexes []string) []string {
 	}
 	return msgs
 }
+
+// validateTrustedProxyCIDRs validates the IP/CIDRs of the trusted proxies
+func validateTrustedProxyCIDRs(o *options.Options) []string {
+	msgs := []string{}
+	if len(o.TrustedProxyCIDRs) > 0 && !o.ReverseProxy {
+		msgs = append(msgs, "trusted_proxy_cidrs requires reverse_proxy to be enabled")
+	}
+	for i, ipStr := range o.TrustedProxyCIDRs {
+		if nil == ip.ParseIPNet(ipStr) {
+			msgs = append(msgs, fmt.Sprintf("trusted_proxy_cidrs[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+	return msgs
+}
@@ -37,9 +37,24 @@ var _ = Describe("Allowlist", func() {
 				"POST=/foo/bar",
 				"PUT=^/foo/bar$",
 				"DELETE=/crazy/(?:regex)?/[^/]+/stuff$",
+				"GET|HEAD=^/healthz$",
+				"GET|POST!=^/api",
+				"!=^/api",
 			},
 			errStrings: []string{},
 		}),
+		Entry("Method sets with empty methods", &validateRoutesTableInput{
+			routes: []string{
+				"GET||HEAD=^/healthz$",
+				"|POST!=^/api",
+				"GET|=/foo",
+			},
+			errStrings: []string{
+				"invalid skip auth route \"GET||HEAD=^/healthz$\": methods must not be empty",
+				"invalid skip auth route \"|POST!=^/api\": methods must not be empty",
+				"invalid skip auth route \"GET|=/foo\": methods must not be empty",
+			},
+		}),
 		Entry("Bad regexes do not compile", &validateRoutesTableInput{
 			routes: []string{
 				"POST=/(foo",
@@ -122,4 +137,14 @@ var _ = Describe("Allowlist", func() {
 			},
 		}),
 	)
+
+	It("requires trusted IPs for the trusted IP user", func() {
+		opts := &options.Options{
+			TrustedIPUser: "monitoring",
+		}
+		Expect(validateAllowlists(opts)).To(ConsistOf("trusted_ip_user requires trusted_ips to be set"))
+
+		opts.TrustedIPs = []string{"10.0.0.0/8"}
+		Expect(validateAllowlists(opts)).To(BeEmpty())
+	})
 })
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
@@ -11,6 +12,8 @@ import (
 
 func validateCookie(o options.Cookie) []string {
 	msgs := validateCookieSecret(o.Secret)
+	msgs = append(msgs, validateCookieSecondarySecrets(o.SecondarySecrets)...)
+	msgs = append(msgs, validateCookieEncryptionAlgorithm(o)...)
 
 	if o.Refresh >= o.Expire {
 		msgs = append(msgs, fmt.Sprintf(
@@ -19,10 +22,20 @@ func validateCookie(o options.Cookie) []string {
 			o.Expire.String()))
 	}
 
-	switch o.SameSite {
-	case "", "none", "lax", "strict":
-	default:
-		msgs = append(msgs, fmt.Sprintf("cookie_samesite (%q) must be one of ['', 'lax', 'strict', 'none']", o.SameSite))
+	if o.RefreshGracePeriod < 0 {
+		msgs = append(msgs, fmt.Sprintf("cookie_refresh_grace_period (%q) must not be negative", o.RefreshGracePeriod.String()))
+	}
+	if o.RefreshGracePeriod > 0 && o.Refresh == 0 {
+		msgs = append(msgs, "cookie_refresh_grace_period requires cookie_refresh to be set")
+	}
+
+	msgs = append(msgs, validateCookieSameSite("cookie_samesite", o.SameSite, o.Secure)...)
+	msgs = append(msgs, validateCookieSameSite("cookie_session_samesite", o.SessionSameSite, o.Secure)...)
+	msgs = append(msgs, validateCookieSameSite("cookie_csrf_samesite", o.CSRFSameSite, o.Secure)...)
+
+	// Browsers reject partitioned cookies that are not secure
+	if o.Partitioned && !o.Secure {
+		msgs = append(msgs, "cookie_partitioned requires cookie_secure to be set")
 	}
 
 	// Sort cookie domains by length, so that we try longer (and more specific) domains first
@@ -30,10 +43,52 @@ func validateCookie(o options.Cookie) []string {
 		return len(o.Domains[i]) > len(o.Domains[j])
 	})
 
-	msgs = append(msgs, validateCookieName(o.Name)...)
+	msgs = append(msgs, validateCookieName(o.PrefixedName())...)
+	msgs = append(msgs, validateCookieNameBrowserPrefix(o)...)
+	return msgs
+}
+
+// validateCookieNameBrowserPrefix checks the requirements browsers enforce
+// for cookies with the `__Secure-` and `__Host-` name prefixes, otherwise the
+// cookies are silently rejected
+func validateCookieNameBrowserPrefix(o options.Cookie) []string {
+	name := o.PrefixedName()
+	msgs := []string{}
+	switch {
+	case strings.HasPrefix(name, "__Host-"):
+		if !o.Secure {
+			msgs = append(msgs, fmt.Sprintf("cookie name %q starts with __Host- which requires cookie_secure to be set", name))
+		}
+		if len(o.Domains) > 0 {
+			msgs = append(msgs, fmt.Sprintf("cookie name %q starts with __Host- which does not allow cookie_domains to be set", name))
+		}
+		if o.Path != "/" {
+			msgs = append(msgs, fmt.Sprintf("cookie name %q starts with __Host- which requires cookie_path to be \"/\"", name))
+		}
+	case strings.HasPrefix(name, "__Secure-"):
+		if !o.Secure {
+			msgs = append(msgs, fmt.Sprintf("cookie name %q starts with __Secure- which requires cookie_secure to be set", name))
+		}
+	}
 	return msgs
 }
 
+// validateCookieSameSite checks the SameSite value of a cookie type, browsers
+// reject cookies with SameSite=None that are not secure
+func validateCookieSameSite(setting, sameSite string, secure bool) []string {
+	switch sameSite {
+	case "", "lax", "strict":
+		return []string{}
+	case "none":
+		if !secure {
+			return []string{fmt.Sprintf("%s \"none\" requires cookie_secure to be set", setting)}
+		}
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("%s (%q) must be one of ['', 'lax', 'strict', 'none']", setting, sameSite)}
+	}
+}
+
 func validateCookieName(name string) []string {
 	msgs := []string{}
 
@@ -48,6 +103,46 @@ func validateCookieName(name string) []string {
 	return msgs
 }
 
+// validateCookieSecondarySecrets checks the secondary secrets can create the
+// same AES ciphers as the cookie secret
+func validateCookieSecondarySecrets(secrets []string) []string {
+	msgs := []string{}
+	for i, secret := range secrets {
+		switch n := len(encryption.SecretBytes(secret)); n {
+		case 16, 24, 32:
+		default:
+			msgs = append(msgs, fmt.Sprintf(
+				"cookie_secondary_secrets[%d] must be 16, 24, or 32 bytes to create an AES cipher, but is %d bytes",
+				i, n))
+		}
+	}
+	return msgs
+}
+
+// validateCookieEncryptionAlgorithm checks the cipher is known and that the
+// secrets have the size the AEAD ciphers require
+func validateCookieEncryptionAlgorithm(o options.Cookie) []string {
+	switch o.EncryptionAlgorithm {
+	case "", encryption.CipherAESCFB:
+		return []string{}
+	case encryption.CipherAES256GCM, encryption.CipherChaCha20Poly1305:
+	default:
+		return []string{fmt.Sprintf("cookie_encryption_algorithm (%q) must be one of ['%s', '%s', '%s']",
+			o.EncryptionAlgorithm, encryption.CipherAESCFB, encryption.CipherAES256GCM, encryption.CipherChaCha20Poly1305)}
+	}
+
+	msgs := []string{}
+	if n := len(encryption.SecretBytes(o.Secret)); o.Secret != "" && n != 32 {
+		msgs = append(msgs, fmt.Sprintf("cookie_secret must be 32 bytes for cookie_encryption_algorithm %q, but is %d bytes", o.EncryptionAlgorithm, n))
+	}
+	for i, secret := range o.SecondarySecrets {
+		if n := len(encryption.SecretBytes(secret)); n != 32 {
+			msgs = append(msgs, fmt.Sprintf("cookie_secondary_secrets[%d] must be 32 bytes for cookie_encryption_algorithm %q, but is %d bytes", i, o.EncryptionAlgorithm, n))
+		}
+	}
+	return msgs
+}
+
 func validateCookieSecret(secret string) []string {
 	if secret == "" {
 		return []string{"missing setting: cookie-secret"}
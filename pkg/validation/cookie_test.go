@@ -20,6 +20,7 @@ func TestValidateCookie(t *testing.T) {
 	invalidSecret := "abcdef"                                          // 6 bytes is not a valid size
 	validBase64Secret := "c2VjcmV0dGhpcnR5dHdvYnl0ZXMrYWJjZGVmZ2hpams" // Base64 encoding of "secretthirtytwobytes+abcdefghijk"
 	invalidBase64Secret := "YWJjZGVmCg"                                // Base64 encoding of "abcdef"
+	aes128Secret := "sixteenbytes+abc"                                 // 16 bytes is only valid for AES CFB
 	emptyDomains := []string{}
 	domains := []string{
 		"a.localhost",
@@ -34,8 +35,23 @@ func TestValidateCookie(t *testing.T) {
 	missingSecretMsg := "missing setting: cookie-secret"
 	invalidSecretMsg := "cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is 6 bytes"
 	invalidBase64SecretMsg := "cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is 10 bytes"
+	invalidSecondarySecretMsg := "cookie_secondary_secrets[1] must be 16, 24, or 32 bytes to create an AES cipher, but is 6 bytes"
+	invalidEncryptionAlgorithmMsg := "cookie_encryption_algorithm (\"aes-128-cbc\") must be one of ['aes-cfb', 'aes-256-gcm', 'chacha20-poly1305']"
+	aeadSecretMsg := "cookie_secret must be 32 bytes for cookie_encryption_algorithm \"chacha20-poly1305\", but is 16 bytes"
+	aeadSecondarySecretMsg := "cookie_secondary_secrets[1] must be 32 bytes for cookie_encryption_algorithm \"chacha20-poly1305\", but is 16 bytes"
 	refreshLongerThanExpireMsg := "cookie_refresh (\"1h0m0s\") must be less than cookie_expire (\"15m0s\")"
+	negativeGracePeriodMsg := "cookie_refresh_grace_period (\"-1m0s\") must not be negative"
+	gracePeriodWithoutRefreshMsg := "cookie_refresh_grace_period requires cookie_refresh to be set"
 	invalidSameSiteMsg := "cookie_samesite (\"invalid\") must be one of ['', 'lax', 'strict', 'none']"
+	partitionedNotSecureMsg := "cookie_partitioned requires cookie_secure to be set"
+	sameSiteNoneNotSecureMsg := "cookie_samesite \"none\" requires cookie_secure to be set"
+	invalidSessionSameSiteMsg := "cookie_session_samesite (\"invalid\") must be one of ['', 'lax', 'strict', 'none']"
+	csrfSameSiteNoneNotSecureMsg := "cookie_csrf_samesite \"none\" requires cookie_secure to be set"
+	invalidPrefixedNameMsg := "invalid cookie name: \"tenant;a_oauth2_proxy\""
+	hostPrefixNotSecureMsg := "cookie name \"__Host-_oauth2_proxy\" starts with __Host- which requires cookie_secure to be set"
+	hostPrefixDomainsMsg := "cookie name \"__Host-_oauth2_proxy\" starts with __Host- which does not allow cookie_domains to be set"
+	hostPrefixPathMsg := "cookie name \"__Host-_oauth2_proxy\" starts with __Host- which requires cookie_path to be \"/\""
+	securePrefixNotSecureMsg := "cookie name \"__Secure-_oauth2_proxy\" starts with __Secure- which requires cookie_secure to be set"
 
 	testCases := []struct {
 		name       string
@@ -123,6 +139,80 @@ func TestValidateCookie(t *testing.T) {
 				invalidBase64SecretMsg,
 			},
 		},
+		{
+			name: "with secondary secrets",
+			cookie: options.Cookie{
+				Name:             validName,
+				Secret:           validSecret,
+				SecondarySecrets: []string{validBase64Secret, validSecret},
+				Domains:          emptyDomains,
+				Path:             "",
+				Expire:           time.Hour,
+				Refresh:          15 * time.Minute,
+				Secure:           true,
+				HTTPOnly:         false,
+				SameSite:         "",
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with an invalid secondary secret",
+			cookie: options.Cookie{
+				Name:             validName,
+				Secret:           validSecret,
+				SecondarySecrets: []string{validBase64Secret, invalidSecret},
+				Domains:          emptyDomains,
+				Path:             "",
+				Expire:           time.Hour,
+				Refresh:          15 * time.Minute,
+				Secure:           true,
+				HTTPOnly:         false,
+				SameSite:         "",
+			},
+			errStrings: []string{
+				invalidSecondarySecretMsg,
+			},
+		},
+		{
+			name: "with an AEAD encryption algorithm",
+			cookie: options.Cookie{
+				Name:                validName,
+				Secret:              validSecret,
+				SecondarySecrets:    []string{validBase64Secret},
+				EncryptionAlgorithm: "aes-256-gcm",
+				Expire:              time.Hour,
+				Secure:              true,
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with an invalid encryption algorithm",
+			cookie: options.Cookie{
+				Name:                validName,
+				Secret:              validSecret,
+				EncryptionAlgorithm: "aes-128-cbc",
+				Expire:              time.Hour,
+				Secure:              true,
+			},
+			errStrings: []string{
+				invalidEncryptionAlgorithmMsg,
+			},
+		},
+		{
+			name: "with an AEAD encryption algorithm and 16 byte secrets",
+			cookie: options.Cookie{
+				Name:                validName,
+				Secret:              aes128Secret,
+				SecondarySecrets:    []string{validSecret, aes128Secret},
+				EncryptionAlgorithm: "chacha20-poly1305",
+				Expire:              time.Hour,
+				Secure:              true,
+			},
+			errStrings: []string{
+				aeadSecretMsg,
+				aeadSecondarySecretMsg,
+			},
+		},
 		{
 			name: "with an invalid name",
 			cookie: options.Cookie{
@@ -174,6 +264,48 @@ func TestValidateCookie(t *testing.T) {
 				refreshLongerThanExpireMsg,
 			},
 		},
+		{
+			name: "with a refresh grace period",
+			cookie: options.Cookie{
+				Name:               validName,
+				Secret:             validSecret,
+				Domains:            emptyDomains,
+				Expire:             time.Hour,
+				Refresh:            15 * time.Minute,
+				RefreshGracePeriod: 10 * time.Minute,
+				Secure:             true,
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with a negative refresh grace period",
+			cookie: options.Cookie{
+				Name:               validName,
+				Secret:             validSecret,
+				Domains:            emptyDomains,
+				Expire:             time.Hour,
+				Refresh:            15 * time.Minute,
+				RefreshGracePeriod: -time.Minute,
+				Secure:             true,
+			},
+			errStrings: []string{
+				negativeGracePeriodMsg,
+			},
+		},
+		{
+			name: "with a refresh grace period without refresh",
+			cookie: options.Cookie{
+				Name:               validName,
+				Secret:             validSecret,
+				Domains:            emptyDomains,
+				Expire:             time.Hour,
+				RefreshGracePeriod: 10 * time.Minute,
+				Secure:             true,
+			},
+			errStrings: []string{
+				gracePeriodWithoutRefreshMsg,
+			},
+		},
 		{
 			name: "with samesite \"none\"",
 			cookie: options.Cookie{
@@ -236,6 +368,76 @@ func TestValidateCookie(t *testing.T) {
 				invalidSameSiteMsg,
 			},
 		},
+		{
+			name: "with samesite per cookie type",
+			cookie: options.Cookie{
+				Name:            validName,
+				Secret:          validSecret,
+				Domains:         emptyDomains,
+				Path:            "",
+				Expire:          time.Hour,
+				Refresh:         15 * time.Minute,
+				Secure:          true,
+				HTTPOnly:        false,
+				SessionSameSite: "lax",
+				CSRFSameSite:    "none",
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with invalid samesite per cookie type",
+			cookie: options.Cookie{
+				Name:            validName,
+				Secret:          validSecret,
+				Domains:         emptyDomains,
+				Path:            "",
+				Expire:          time.Hour,
+				Refresh:         15 * time.Minute,
+				Secure:          false,
+				HTTPOnly:        false,
+				SessionSameSite: "invalid",
+				CSRFSameSite:    "none",
+			},
+			errStrings: []string{
+				invalidSessionSameSiteMsg,
+				csrfSameSiteNoneNotSecureMsg,
+			},
+		},
+		{
+			name: "with a partitioned cookie",
+			cookie: options.Cookie{
+				Name:        validName,
+				Secret:      validSecret,
+				Domains:     emptyDomains,
+				Path:        "",
+				Expire:      time.Hour,
+				Refresh:     15 * time.Minute,
+				Secure:      true,
+				HTTPOnly:    false,
+				SameSite:    "none",
+				Partitioned: true,
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with a partitioned cookie that is not secure",
+			cookie: options.Cookie{
+				Name:        validName,
+				Secret:      validSecret,
+				Domains:     emptyDomains,
+				Path:        "",
+				Expire:      time.Hour,
+				Refresh:     15 * time.Minute,
+				Secure:      false,
+				HTTPOnly:    false,
+				SameSite:    "none",
+				Partitioned: true,
+			},
+			errStrings: []string{
+				sameSiteNoneNotSecureMsg,
+				partitionedNotSecureMsg,
+			},
+		},
 		{
 			name: "with a combination of configuration errors",
 			cookie: options.Cookie{
@@ -256,6 +458,84 @@ func TestValidateCookie(t *testing.T) {
 				invalidSameSiteMsg,
 			},
 		},
+		{
+			name: "with a valid name prefix",
+			cookie: options.Cookie{
+				Name:       validName,
+				NamePrefix: "tenant_a",
+				Secret:     validSecret,
+				Domains:    domains,
+				Path:       "",
+				Expire:     time.Hour,
+				Refresh:    15 * time.Minute,
+				Secure:     true,
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with an invalid name prefix",
+			cookie: options.Cookie{
+				Name:       validName,
+				NamePrefix: "tenant;a",
+				Secret:     validSecret,
+				Domains:    domains,
+				Path:       "",
+				Expire:     time.Hour,
+				Refresh:    15 * time.Minute,
+				Secure:     true,
+			},
+			errStrings: []string{
+				invalidPrefixedNameMsg,
+			},
+		},
+		{
+			name: "with a valid __Host- name prefix",
+			cookie: options.Cookie{
+				Name:       validName,
+				NamePrefix: "__Host-",
+				Secret:     validSecret,
+				Domains:    emptyDomains,
+				Path:       "/",
+				Expire:     time.Hour,
+				Refresh:    15 * time.Minute,
+				Secure:     true,
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with an invalid __Host- name prefix",
+			cookie: options.Cookie{
+				Name:       validName,
+				NamePrefix: "__Host-",
+				Secret:     validSecret,
+				Domains:    domains,
+				Path:       "",
+				Expire:     time.Hour,
+				Refresh:    15 * time.Minute,
+				Secure:     false,
+			},
+			errStrings: []string{
+				hostPrefixNotSecureMsg,
+				hostPrefixDomainsMsg,
+				hostPrefixPathMsg,
+			},
+		},
+		{
+			name: "with an insecure __Secure- name prefix",
+			cookie: options.Cookie{
+				Name:       validName,
+				NamePrefix: "__Secure-",
+				Secret:     validSecret,
+				Domains:    domains,
+				Path:       "/",
+				Expire:     time.Hour,
+				Refresh:    15 * time.Minute,
+				Secure:     false,
+			},
+			errStrings: []string{
+				securePrefixNotSecureMsg,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -2,10 +2,16 @@ package validation
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 )
 
+const minIdentityHeaderSignatureKeyLength = 32
+
 func validateHeaders(headers []options.Header) []string {
 	msgs := []string{}
 	names := make(map[string]struct{})
@@ -16,6 +22,78 @@ func validateHeaders(headers []options.Header) []string {
 	return msgs
 }
 
+// validateAuthorizationRequestHeader checks that the Authorization header of
+// upstream requests is only set from one source. Upstreams with token exchange
+// replace the Authorization header with the exchanged token, which would
+// silently discard an injected header such as the ID token bearer set by
+// pass-authorization-header.
+func validateAuthorizationRequestHeader(headers []options.Header, upstreams options.UpstreamConfig) []string {
+	injected := false
+	for _, header := range headers {
+		if http.CanonicalHeaderKey(header.Name) == "Authorization" {
+			injected = true
+			break
+		}
+	}
+	if !injected {
+		return []string{}
+	}
+
+	msgs := []string{}
+	for _, upstream := range upstreams.Upstreams {
+		if upstream.TokenExchange != nil {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has tokenExchange, which sets the Authorization header, but the Authorization header is also injected into requests (pass-authorization-header or pass-basic-auth): only one may be used", upstream.ID))
+		}
+	}
+	return msgs
+}
+
+// validateHeaderWebhook checks the header webhook URL is an absolute HTTP(S)
+// URL and that the durations are not negative
+func validateHeaderWebhook(o *options.Options) []string {
+	if o.HeaderWebhookURL == "" {
+		return []string{}
+	}
+
+	msgs := []string{}
+	u, err := url.Parse(o.HeaderWebhookURL)
+	switch {
+	case err != nil:
+		msgs = append(msgs, fmt.Sprintf("error parsing header-webhook-url=%q %s", o.HeaderWebhookURL, err))
+	case u.Scheme != "http" && u.Scheme != "https", u.Host == "":
+		msgs = append(msgs, fmt.Sprintf("invalid header-webhook-url %q: must be an http or https URL", o.HeaderWebhookURL))
+	}
+
+	if o.HeaderWebhookTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: header-webhook-timeout %s must not be negative", o.HeaderWebhookTimeout))
+	}
+	if o.HeaderWebhookCacheTTL < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: header-webhook-cache-ttl %s must not be negative", o.HeaderWebhookCacheTTL))
+	}
+	return msgs
+}
+
+// validateStripRequestHeaderPrefixes checks that no prefix is empty, as an
+// empty prefix would remove every request header
+func validateStripRequestHeaderPrefixes(prefixes []string) []string {
+	msgs := []string{}
+	for _, prefix := range prefixes {
+		if strings.TrimSpace(prefix) == "" {
+			msgs = append(msgs, "invalid setting: strip-request-header-prefix must not be empty")
+		}
+	}
+	return msgs
+}
+
+// validateIdentityHeaderSignatureKey checks that the key is long enough to
+// sign the injected request headers securely
+func validateIdentityHeaderSignatureKey(key string) []string {
+	if key != "" && len(key) < minIdentityHeaderSignatureKeyLength {
+		return []string{fmt.Sprintf("invalid setting: identity-header-signature-key must be at least %d bytes", minIdentityHeaderSignatureKeyLength)}
+	}
+	return []string{}
+}
+
 func validateHeader(header options.Header, names map[string]struct{}) []string {
 	msgs := []string{}
 
@@ -52,12 +130,39 @@ func validateHeaderValue(name string, value options.HeaderValue) []string {
 func validateHeaderValueClaimSource(claim options.ClaimSource) []string {
 	msgs := []string{}
 
-	if claim.Claim == "" {
+	if claim.Claim == "" && claim.Template == "" {
 		msgs = append(msgs, "claim should not be empty")
 	}
 
+	if claim.Template != "" {
+		if _, err := template.New("claim").Parse(claim.Template); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid template: %v", err))
+		}
+	}
+
+	if claim.TimeFormat != "" {
+		msgs = append(msgs, validateHeaderValueTimeFormat(claim)...)
+	}
+
 	if claim.BasicAuthPassword != nil {
 		msgs = append(msgs, prefixValues("invalid basicAuthPassword: ", validateSecretSource(*claim.BasicAuthPassword))...)
 	}
 	return msgs
 }
+
+func validateHeaderValueTimeFormat(claim options.ClaimSource) []string {
+	msgs := []string{}
+
+	switch claim.TimeFormat {
+	case options.ClaimTimeFormatRFC3339, options.ClaimTimeFormatUnix:
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid timeFormat %q: expected %q or %q", claim.TimeFormat, options.ClaimTimeFormatRFC3339, options.ClaimTimeFormatUnix))
+	}
+
+	if claim.Template != "" {
+		msgs = append(msgs, "timeFormat cannot be used with a template")
+	} else if claim.Claim != "created_at" && claim.Claim != "expires_on" {
+		msgs = append(msgs, fmt.Sprintf("timeFormat can only be used with the created_at and expires_on claims, not %q", claim.Claim))
+	}
+	return msgs
+}
@@ -2,6 +2,8 @@ package validation
 
 import (
 	"encoding/base64"
+	"strings"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	. "github.com/onsi/ginkgo"
@@ -160,5 +162,263 @@ var _ = Describe("Headers", func() {
 				"invalid header \"With-Invalid-Basic-Auth\": invalid values: invalid basicAuthPassword: error loading secret from environent: no value for for key \"UNKNOWN_ENV\"",
 			},
 		}),
+		Entry("with a header which has a template without a claim", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Template",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Template: "{{.given_name}} {{.family_name}}",
+							},
+						},
+					},
+				},
+				validHeader1,
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with a header which has an invalid template", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Invalid-Template",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Template: "{{.given_name",
+							},
+						},
+					},
+				},
+				validHeader1,
+			},
+			expectedMsgs: []string{
+				"invalid header \"With-Invalid-Template\": invalid values: invalid template: template: claim:1: unclosed action",
+			},
+		}),
+		Entry("with a header which has a time format", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Time-Format",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim:      "expires_on",
+								TimeFormat: options.ClaimTimeFormatUnix,
+							},
+						},
+					},
+				},
+				validHeader1,
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with a header which has an invalid time format", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Invalid-Time-Format",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim:      "expires_on",
+								TimeFormat: "iso",
+							},
+						},
+					},
+				},
+				validHeader1,
+			},
+			expectedMsgs: []string{
+				"invalid header \"With-Invalid-Time-Format\": invalid values: invalid timeFormat \"iso\": expected \"rfc3339\" or \"unix\"",
+			},
+		}),
+		Entry("with a header which has a time format for a claim that is not a time", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Time-Format",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim:      "email",
+								TimeFormat: options.ClaimTimeFormatRFC3339,
+							},
+						},
+					},
+				},
+				validHeader1,
+			},
+			expectedMsgs: []string{
+				"invalid header \"With-Time-Format\": invalid values: timeFormat can only be used with the created_at and expires_on claims, not \"email\"",
+			},
+		}),
+		Entry("with a header which has a time format and a template", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Time-Format",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Template:   "{{.exp}}",
+								TimeFormat: options.ClaimTimeFormatRFC3339,
+							},
+						},
+					},
+				},
+				validHeader1,
+			},
+			expectedMsgs: []string{
+				"invalid header \"With-Time-Format\": invalid values: timeFormat cannot be used with a template",
+			},
+		}),
+	)
+
+	type validateAuthorizationRequestHeaderTableInput struct {
+		headers      []options.Header
+		upstreams    options.UpstreamConfig
+		expectedMsgs []string
+	}
+
+	idTokenBearerHeader := options.Header{
+		Name: "Authorization",
+		Values: []options.HeaderValue{
+			{
+				ClaimSource: &options.ClaimSource{
+					Claim:  "id_token",
+					Prefix: "Bearer ",
+				},
+			},
+		},
+	}
+
+	exchangeUpstreams := options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:            "exchange",
+				Path:          "/exchange",
+				URI:           "http://localhost:8080",
+				TokenExchange: &options.TokenExchange{Audience: "upstream"},
+			},
+			{
+				ID:   "plain",
+				Path: "/plain",
+				URI:  "http://localhost:8081",
+			},
+		},
+	}
+
+	plainUpstreams := options.UpstreamConfig{
+		Upstreams: []options.Upstream{
+			{
+				ID:   "plain",
+				Path: "/plain",
+				URI:  "http://localhost:8081",
+			},
+		},
+	}
+
+	DescribeTable("validateAuthorizationRequestHeader",
+		func(in validateAuthorizationRequestHeaderTableInput) {
+			Expect(validateAuthorizationRequestHeader(in.headers, in.upstreams)).To(ConsistOf(in.expectedMsgs))
+		},
+		Entry("with an Authorization header and no token exchange", validateAuthorizationRequestHeaderTableInput{
+			headers:      []options.Header{idTokenBearerHeader, validHeader1},
+			upstreams:    plainUpstreams,
+			expectedMsgs: []string{},
+		}),
+		Entry("with token exchange and no Authorization header", validateAuthorizationRequestHeaderTableInput{
+			headers:      []options.Header{validHeader1},
+			upstreams:    exchangeUpstreams,
+			expectedMsgs: []string{},
+		}),
+		Entry("with an Authorization header and token exchange", validateAuthorizationRequestHeaderTableInput{
+			headers:   []options.Header{idTokenBearerHeader, validHeader1},
+			upstreams: exchangeUpstreams,
+			expectedMsgs: []string{
+				"upstream \"exchange\" has tokenExchange, which sets the Authorization header, but the Authorization header is also injected into requests (pass-authorization-header or pass-basic-auth): only one may be used",
+			},
+		}),
+		Entry("with a lower case authorization header and token exchange", validateAuthorizationRequestHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name:   "authorization",
+					Values: idTokenBearerHeader.Values,
+				},
+			},
+			upstreams: exchangeUpstreams,
+			expectedMsgs: []string{
+				"upstream \"exchange\" has tokenExchange, which sets the Authorization header, but the Authorization header is also injected into requests (pass-authorization-header or pass-basic-auth): only one may be used",
+			},
+		}),
+	)
+
+	type validateHeaderWebhookTableInput struct {
+		url          string
+		timeout      time.Duration
+		cacheTTL     time.Duration
+		expectedMsgs []string
+	}
+
+	DescribeTable("validateHeaderWebhook",
+		func(in validateHeaderWebhookTableInput) {
+			o := &options.Options{
+				HeaderWebhookURL:      in.url,
+				HeaderWebhookTimeout:  in.timeout,
+				HeaderWebhookCacheTTL: in.cacheTTL,
+			}
+			Expect(validateHeaderWebhook(o)).To(ConsistOf(in.expectedMsgs))
+		},
+		Entry("with no webhook", validateHeaderWebhookTableInput{
+			timeout:      -time.Second,
+			expectedMsgs: []string{},
+		}),
+		Entry("with a valid webhook", validateHeaderWebhookTableInput{
+			url:          "https://entitlements.example.com/headers",
+			timeout:      time.Second,
+			cacheTTL:     time.Minute,
+			expectedMsgs: []string{},
+		}),
+		Entry("with a relative URL", validateHeaderWebhookTableInput{
+			url: "/headers",
+			expectedMsgs: []string{
+				"invalid header-webhook-url \"/headers\": must be an http or https URL",
+			},
+		}),
+		Entry("with a non HTTP URL", validateHeaderWebhookTableInput{
+			url: "ftp://entitlements.example.com/headers",
+			expectedMsgs: []string{
+				"invalid header-webhook-url \"ftp://entitlements.example.com/headers\": must be an http or https URL",
+			},
+		}),
+		Entry("with negative durations", validateHeaderWebhookTableInput{
+			url:      "http://entitlements.example.com/headers",
+			timeout:  -time.Second,
+			cacheTTL: -time.Minute,
+			expectedMsgs: []string{
+				"invalid setting: header-webhook-timeout -1s must not be negative",
+				"invalid setting: header-webhook-cache-ttl -1m0s must not be negative",
+			},
+		}),
+	)
+
+	DescribeTable("validateStripRequestHeaderPrefixes",
+		func(prefixes []string, expectedMsgs []string) {
+			Expect(validateStripRequestHeaderPrefixes(prefixes)).To(ConsistOf(expectedMsgs))
+		},
+		Entry("with no prefixes", nil, []string{}),
+		Entry("with valid prefixes", []string{"X-Auth-Request-", "X-Forwarded-"}, []string{}),
+		Entry("with an empty prefix", []string{"X-Auth-Request-", " "}, []string{
+			"invalid setting: strip-request-header-prefix must not be empty",
+		}),
+	)
+
+	DescribeTable("validateIdentityHeaderSignatureKey",
+		func(key string, expectedMsgs []string) {
+			Expect(validateIdentityHeaderSignatureKey(key)).To(ConsistOf(expectedMsgs))
+		},
+		Entry("with no key", "", []string{}),
+		Entry("with a long enough key", strings.Repeat("k", 32), []string{}),
+		Entry("with a short key", "secret", []string{
+			"invalid setting: identity-header-signature-key must be at least 32 bytes",
+		}),
 	)
 })
@@ -1,6 +1,7 @@
 package validation
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
@@ -38,6 +39,13 @@ func configureLogger(o options.Logging, msgs []string) []string {
 		logger.SetOutput(logWriter)
 	}
 
+	switch o.RequestFormatType {
+	case "", logger.RequestLoggingFormatTypeText, logger.RequestLoggingFormatTypeJSON:
+		// Valid, do nothing
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid setting: request-logging-format-type %q must be one of %q or %q", o.RequestFormatType, logger.RequestLoggingFormatTypeText, logger.RequestLoggingFormatTypeJSON))
+	}
+
 	// Supply a sanity warning to the logger if all logging is disabled
 	if !o.StandardEnabled && !o.AuthEnabled && !o.RequestEnabled {
 		logger.Error("Warning: Logging disabled. No further logs will be shown.")
@@ -51,8 +59,10 @@ func configureLogger(o options.Logging, msgs []string) []string {
 	logger.SetStandardTemplate(o.StandardFormat)
 	logger.SetAuthTemplate(o.AuthFormat)
 	logger.SetReqTemplate(o.RequestFormat)
+	logger.SetReqFormatType(o.RequestFormatType)
 
 	logger.SetExcludePaths(o.ExcludePaths)
+	logger.SetRedactQueryParams(o.RedactQueryParams)
 
 	if !o.LocalTime {
 		logger.SetFlags(logger.Flags() | logger.LUTC)
@@ -14,6 +14,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
+	"golang.org/x/net/http/httpguts"
 )
 
 // Validate checks that required options are set and validates those that they
@@ -21,13 +22,26 @@ import (
 func Validate(o *options.Options) error {
 	msgs := validateCookie(o.Cookie)
 	msgs = append(msgs, validateSessionCookieMinimal(o)...)
+	msgs = append(msgs, validateSessionStoreCompression(o)...)
+	msgs = append(msgs, validateSessionMaxLifetime(o)...)
+	msgs = append(msgs, validateSessionIdleTimeout(o)...)
+	msgs = append(msgs, validateValidateEachRequest(o)...)
+	msgs = append(msgs, validateSessionCookieChunks(o)...)
+	msgs = append(msgs, validateCSRFServerSide(o)...)
 	msgs = append(msgs, validateRedisSessionStore(o)...)
+	msgs = append(msgs, validateMemcachedSessionStore(o)...)
 	msgs = append(msgs, prefixValues("injectRequestHeaders: ", validateHeaders(o.InjectRequestHeaders)...)...)
 	msgs = append(msgs, prefixValues("injectResponseHeaders: ", validateHeaders(o.InjectResponseHeaders)...)...)
+	msgs = append(msgs, validateJWKSCache(o)...)
 	msgs = append(msgs, validateProviders(o)...)
 	msgs = append(msgs, validateAPIRoutes(o)...)
 	msgs = configureLogger(o.Logging, msgs)
 	msgs = parseSignatureKey(o, msgs)
+	msgs = parsePageResponseHeaders(o, msgs)
+	msgs = parseUpstreamRequestHeaders(o, msgs)
+	msgs = append(msgs, validateAccessDeniedPage(o)...)
+	msgs = append(msgs, validateAjaxRequests(o)...)
+	msgs = append(msgs, validateServers(o)...)
 
 	if o.SSLInsecureSkipVerify {
 		// InsecureSkipVerify is a configurable option we allow
@@ -37,7 +51,7 @@ func Validate(o *options.Options) error {
 		}
 		http.DefaultClient = &http.Client{Transport: insecureTransport}
 	} else if len(o.Providers[0].CAFiles) > 0 {
-		pool, err := util.GetCertPool(o.Providers[0].CAFiles)
+		pool, err := util.GetCertPool(o.Providers[0].CAFiles, !o.Providers[0].CAFilesOnly)
 		if err == nil {
 			transport := http.DefaultTransport.(*http.Transport).Clone()
 			transport.TLSClientConfig = &tls.Config{
@@ -56,6 +70,19 @@ func Validate(o *options.Options) error {
 			"\n      use email-domain=* to authorize all email addresses")
 	}
 
+	if o.APIKeysFile != "" && !httpguts.ValidHeaderFieldName(o.APIKeyHeader) {
+		msgs = append(msgs, fmt.Sprintf("invalid api_key_header %q: must be a valid header name", o.APIKeyHeader))
+	}
+
+	if len(o.JwtBearerHeaders) > 0 && !o.SkipJwtBearerTokens {
+		msgs = append(msgs, "jwt_bearer_headers requires skip_jwt_bearer_tokens to be set")
+	}
+
+	// Expired sessions are only detected when they are due to be refreshed
+	if o.SilentReauth && o.Cookie.Refresh == 0 {
+		msgs = append(msgs, "silent_reauth requires cookie_refresh to be set")
+	}
+
 	if o.SkipJwtBearerTokens {
 		// Configure extra issuers
 		if len(o.ExtraJwtIssuers) > 0 {
@@ -83,9 +110,13 @@ func Validate(o *options.Options) error {
 	}
 
 	msgs = append(msgs, validateUpstreams(o.UpstreamServers)...)
+	msgs = append(msgs, validateAuthorizationRequestHeader(o.InjectRequestHeaders, o.UpstreamServers)...)
+
+	trustedProxyMsgs := validateTrustedProxyCIDRs(o)
+	msgs = append(msgs, trustedProxyMsgs...)
 
-	if o.ReverseProxy {
-		parser, err := ip.GetRealClientIPParser(o.RealClientIPHeader)
+	if o.ReverseProxy && len(trustedProxyMsgs) == 0 {
+		parser, err := ip.GetRealClientIPParser(o.RealClientIPHeader, o.TrustedProxyCIDRs)
 		if err != nil {
 			msgs = append(msgs, fmt.Sprintf("real_client_ip_header (%s) not accepted parameter value: %v", o.RealClientIPHeader, err))
 		}
@@ -100,6 +131,29 @@ func Validate(o *options.Options) error {
 	// Do this after ReverseProxy validation for TrustedIP coordinated checks
 	msgs = append(msgs, validateAllowlists(o)...)
 
+	if o.RateLimitRequestsPerSecond < 0 {
+		msgs = append(msgs, "rate_limit_requests_per_second must not be negative")
+	}
+	if o.RateLimitBurst < 0 {
+		msgs = append(msgs, "rate_limit_burst must not be negative")
+	}
+	if o.LoginFailureBackoff < 0 {
+		msgs = append(msgs, "login_failure_backoff must not be negative")
+	}
+	if o.LoginFailureBackoff > 0 && o.LoginFailureMaxBackoff < o.LoginFailureBackoff {
+		msgs = append(msgs, "login_failure_max_backoff must not be less than login_failure_backoff")
+	}
+	msgs = append(msgs, validateDefaultRootAction(o)...)
+	msgs = append(msgs, validateTracing(o)...)
+	msgs = append(msgs, validateAdminAPI(o)...)
+	if o.CallbackMaxBodySize <= 0 {
+		msgs = append(msgs, "callback_max_body_size must be greater than 0")
+	}
+
+	msgs = append(msgs, validateStripRequestHeaderPrefixes(o.StripRequestHeaderPrefixes)...)
+	msgs = append(msgs, validateIdentityHeaderSignatureKey(o.IdentityHeaderSignatureKey)...)
+	msgs = append(msgs, validateHeaderWebhook(o)...)
+
 	if len(msgs) != 0 {
 		return fmt.Errorf("invalid configuration:\n  %s",
 			strings.Join(msgs, "\n  "))
@@ -129,6 +183,147 @@ func parseSignatureKey(o *options.Options, msgs []string) []string {
 	return msgs
 }
 
+// parsePageResponseHeaders parses the "Name: value" headers set on the pages
+// rendered by the proxy
+func parsePageResponseHeaders(o *options.Options, msgs []string) []string {
+	if len(o.Templates.ResponseHeaders) == 0 {
+		return msgs
+	}
+
+	headers := http.Header{}
+	for _, header := range o.Templates.ResponseHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			msgs = append(msgs, fmt.Sprintf("invalid page response header %q: expected \"Name: value\"", header))
+			continue
+		}
+		headers.Add(name, value)
+	}
+	o.SetPageResponseHeaders(headers)
+	return msgs
+}
+
+// parseUpstreamRequestHeaders parses the "Name: value" headers set on the
+// requests proxied to the upstreams and checks the names of the headers to
+// strip from them
+func parseUpstreamRequestHeaders(o *options.Options, msgs []string) []string {
+	for _, name := range o.StripUpstreamHeaders {
+		if !httpguts.ValidHeaderFieldName(name) {
+			msgs = append(msgs, fmt.Sprintf("invalid strip upstream header %q: must be a header name", name))
+		}
+	}
+
+	if len(o.SetUpstreamHeaders) == 0 {
+		return msgs
+	}
+
+	headers := http.Header{}
+	for _, header := range o.SetUpstreamHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			msgs = append(msgs, fmt.Sprintf("invalid set upstream header %q: expected \"Name: value\"", header))
+			continue
+		}
+		headers.Add(name, value)
+	}
+	o.SetUpstreamRequestHeaders(headers)
+	return msgs
+}
+
+// validateAccessDeniedPage checks the status of the access denied page can
+// not be mistaken for an unauthenticated response or a redirect, and that
+// the contact link is a URL users can follow
+func validateAccessDeniedPage(o *options.Options) []string {
+	msgs := []string{}
+	status := o.Templates.AccessDeniedStatusCode
+	if status < 400 || status > 499 || status == http.StatusUnauthorized {
+		msgs = append(msgs, fmt.Sprintf("access_denied_status_code %d must be a 4xx status other than 401", status))
+	}
+
+	if o.Templates.AccessDeniedContactURL != "" {
+		contactURL, err := url.Parse(o.Templates.AccessDeniedContactURL)
+		if err != nil || (contactURL.Scheme != "http" && contactURL.Scheme != "https" && contactURL.Scheme != "mailto") {
+			msgs = append(msgs, fmt.Sprintf("invalid access_denied_contact_url %q: must be an http, https or mailto URL", o.Templates.AccessDeniedContactURL))
+		}
+	}
+	return msgs
+}
+
+// validateAjaxRequests checks the headers that mark AJAX requests are given
+// as "Name" or "Name: value" and that the status returned to unauthenticated
+// AJAX requests is a client error
+func validateAjaxRequests(o *options.Options) []string {
+	msgs := []string{}
+	for _, header := range o.AjaxRequestHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			msgs = append(msgs, fmt.Sprintf("invalid ajax request header %q: expected \"Name\" or \"Name: value\"", header))
+		}
+	}
+
+	if status := o.AjaxUnauthorizedCode; status < 400 || status > 499 {
+		msgs = append(msgs, fmt.Sprintf("ajax_unauthorized_status_code %d must be a 4xx status", status))
+	}
+	return msgs
+}
+
+// validateDefaultRootAction checks the action for unauthenticated requests
+// for the root path is known and that redirects have an absolute URL
+func validateDefaultRootAction(o *options.Options) []string {
+	switch o.DefaultRootAction {
+	case options.DefaultRootActionSignIn, options.DefaultRootActionWelcome, options.DefaultRootActionLogin:
+		return []string{}
+	case options.DefaultRootActionRedirect:
+		redirectURL, err := url.Parse(o.DefaultRootRedirectURL)
+		if err != nil || (redirectURL.Scheme != "http" && redirectURL.Scheme != "https") || redirectURL.Host == "" {
+			return []string{fmt.Sprintf("invalid default_root_redirect_url %q: must be an http or https URL when default_root_action is %q", o.DefaultRootRedirectURL, o.DefaultRootAction)}
+		}
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("unknown default_root_action %q: must be one of %q, %q, %q or %q", o.DefaultRootAction,
+			options.DefaultRootActionSignIn, options.DefaultRootActionWelcome, options.DefaultRootActionRedirect, options.DefaultRootActionLogin)}
+	}
+}
+
+// validateTracing checks the traces are exported to an http or https URL
+// and that the sample ratio is a valid probability
+func validateTracing(o *options.Options) []string {
+	msgs := []string{}
+	if o.TracingOTLPEndpoint != "" {
+		endpoint, err := url.Parse(o.TracingOTLPEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			msgs = append(msgs, fmt.Sprintf("invalid tracing_otlp_endpoint %q: must be an http or https URL", o.TracingOTLPEndpoint))
+		}
+	}
+
+	if o.TracingSampleRatio < 0 || o.TracingSampleRatio > 1 {
+		msgs = append(msgs, fmt.Sprintf("tracing_sample_ratio %v must be between 0 and 1", o.TracingSampleRatio))
+	}
+	return msgs
+}
+
+// validateJWKSCache checks the size and refresh intervals of the JWKS cache
+// used by the OIDC verifiers
+func validateJWKSCache(o *options.Options) []string {
+	msgs := []string{}
+	if o.JWKSCacheSize < 1 {
+		msgs = append(msgs, "jwks_cache_size must be at least 1")
+	}
+	if o.JWKSRefreshInterval < 0 {
+		msgs = append(msgs, "jwks_refresh_interval must not be negative")
+	}
+	if o.JWKSRefreshJitter < 0 || (o.JWKSRefreshInterval > 0 && o.JWKSRefreshJitter >= o.JWKSRefreshInterval) {
+		msgs = append(msgs, "jwks_refresh_jitter must not be negative and must be less than jwks_refresh_interval")
+	}
+	if o.JWKSRefreshCooldown < 0 {
+		msgs = append(msgs, "jwks_refresh_cooldown must not be negative")
+	}
+	return msgs
+}
+
 // parseJwtIssuers takes in an array of strings in the form of issuer=audience
 // and parses to an array of jwtIssuer structs.
 func parseJwtIssuers(issuers []string, msgs []string) ([]jwtIssuer, []string) {
@@ -184,3 +379,21 @@ func parseURL(toParse string, urltype string, msgs []string) (*url.URL, []string
 	}
 	return parsed, msgs
 }
+
+// validateAdminAPI checks the admin API can clear the sessions of users,
+// which needs the index of the redis session store
+func validateAdminAPI(o *options.Options) []string {
+	msgs := []string{}
+	if o.AdminAPIToken != "" && o.Session.Type != options.RedisSessionStoreType {
+		msgs = append(msgs, "admin_api_token requires a redis session store")
+	}
+	if len(o.AdminAPIAllowedIPs) > 0 && o.AdminAPIToken == "" {
+		msgs = append(msgs, "admin_api_allowed_ips requires admin_api_token to be set")
+	}
+	for i, ipStr := range o.AdminAPIAllowedIPs {
+		if nil == ip.ParseIPNet(ipStr) {
+			msgs = append(msgs, fmt.Sprintf("admin_api_allowed_ips[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+	return msgs
+}
@@ -2,6 +2,10 @@ package validation
 
 import (
 	"crypto"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
@@ -9,6 +13,7 @@ import (
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -109,6 +114,30 @@ func TestCookieRefreshMustBeLessThanCookieExpire(t *testing.T) {
 	assert.Equal(t, nil, Validate(o))
 }
 
+func TestSilentReauthRequiresCookieRefresh(t *testing.T) {
+	o := testOptions()
+	o.SilentReauth = true
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{"silent_reauth requires cookie_refresh to be set"}), err.Error())
+
+	o = testOptions()
+	o.SilentReauth = true
+	o.Cookie.Refresh = time.Minute
+	assert.NoError(t, Validate(o))
+}
+
+func TestJwtBearerHeadersRequireSkipJwtBearerTokens(t *testing.T) {
+	o := testOptions()
+	o.JwtBearerHeaders = []string{"X-Auth-Token"}
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{"jwt_bearer_headers requires skip_jwt_bearer_tokens to be set"}), err.Error())
+
+	o = testOptions()
+	o.JwtBearerHeaders = []string{"X-Auth-Token"}
+	o.SkipJwtBearerTokens = true
+	assert.Equal(t, nil, Validate(o))
+}
+
 func TestBase64CookieSecret(t *testing.T) {
 	o := testOptions()
 	assert.Equal(t, nil, Validate(o))
@@ -203,6 +232,81 @@ func TestRealClientIPHeader(t *testing.T) {
 	assert.Nil(t, o.GetRealClientIPParser())
 }
 
+func TestTrustedProxyCIDRs(t *testing.T) {
+	o := testOptions()
+	o.ReverseProxy = true
+	o.RealClientIPHeader = "X-Forwarded-For"
+	o.TrustedProxyCIDRs = []string{"10.0.0.0/8", "192.168.0.1"}
+	assert.Equal(t, nil, Validate(o))
+
+	req := &http.Request{RemoteAddr: "10.0.0.2:43670", Header: http.Header{}}
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4, 10.0.0.1")
+	clientIP, err := o.GetRealClientIPParser().GetRealClientIP(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", clientIP.String())
+
+	o = testOptions()
+	o.ReverseProxy = true
+	o.TrustedProxyCIDRs = []string{"10.0.0.0/8", "10.0.0.1/8"}
+	err = Validate(o)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, errorMsg([]string{
+		"trusted_proxy_cidrs[1] (10.0.0.1/8) could not be recognized",
+	}), err.Error())
+
+	o = testOptions()
+	o.TrustedProxyCIDRs = []string{"10.0.0.0/8"}
+	err = Validate(o)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, errorMsg([]string{
+		"trusted_proxy_cidrs requires reverse_proxy to be enabled",
+	}), err.Error())
+}
+
+func TestProviderCAFiles(t *testing.T) {
+	certBytes, keyBytes, err := util.GenerateCert("127.0.0.1")
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	server.StartTLS()
+	defer server.Close()
+
+	file, err := os.CreateTemp("", "provider.*.crt")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write(certPEM)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	defaultClient := http.DefaultClient
+	defer func() { http.DefaultClient = defaultClient }()
+
+	for _, caFilesOnly := range []bool{false, true} {
+		o := testOptions()
+		o.Providers[0].CAFiles = []string{file.Name()}
+		o.Providers[0].CAFilesOnly = caFilesOnly
+		assert.NoError(t, Validate(o))
+
+		expected, err := util.GetCertPool([]string{file.Name()}, !caFilesOnly)
+		assert.NoError(t, err)
+		transport := http.DefaultClient.Transport.(*http.Transport)
+		assert.True(t, transport.TLSClientConfig.RootCAs.Equal(expected))
+
+		// The provider client trusts the CA from the file
+		resp, err := http.DefaultClient.Get(server.URL)
+		assert.NoError(t, err)
+		assert.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
 func TestProviderCAFilesError(t *testing.T) {
 	file, err := os.CreateTemp("", "absent.*.crt")
 	assert.NoError(t, err)
@@ -215,3 +319,206 @@ func TestProviderCAFilesError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unable to load provider CA file(s)")
 }
+
+func TestJWKSCacheOptionsInvalid(t *testing.T) {
+	o := testOptions()
+	o.JWKSCacheSize = 0
+	o.JWKSRefreshJitter = 2 * time.Hour
+	o.JWKSRefreshCooldown = -time.Minute
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  jwks_cache_size must be at least 1\n"+
+		"  jwks_refresh_jitter must not be negative and must be less than jwks_refresh_interval\n"+
+		"  jwks_refresh_cooldown must not be negative", err.Error())
+}
+
+func TestLoginFailureBackoffInvalid(t *testing.T) {
+	o := testOptions()
+	o.LoginFailureBackoff = -time.Second
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  login_failure_backoff must not be negative", err.Error())
+
+	o.LoginFailureBackoff = 2 * time.Minute
+	err = Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  login_failure_max_backoff must not be less than login_failure_backoff", err.Error())
+}
+
+func TestAPIKeyHeaderInvalid(t *testing.T) {
+	o := testOptions()
+	o.APIKeyHeader = "X API Key"
+	assert.Equal(t, nil, Validate(o))
+
+	o.APIKeysFile = "api-keys.yaml"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid api_key_header \"X API Key\": must be a valid header name", err.Error())
+}
+
+func TestDefaultRootActionOptions(t *testing.T) {
+	o := testOptions()
+	o.DefaultRootAction = "redirect"
+	o.DefaultRootRedirectURL = "https://www.example.com/welcome"
+	assert.Equal(t, nil, Validate(o))
+
+	o.DefaultRootRedirectURL = "/welcome"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid default_root_redirect_url \"/welcome\": must be an http or https URL when default_root_action is \"redirect\"", err.Error())
+
+	o.DefaultRootAction = "static"
+	err = Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  unknown default_root_action \"static\": must be one of \"sign_in\", \"welcome\", \"redirect\" or \"login\"", err.Error())
+}
+
+func TestTracingOptions(t *testing.T) {
+	o := testOptions()
+	o.TracingOTLPEndpoint = "https://collector.example.com:4318/v1/traces"
+	o.TracingSampleRatio = 0.25
+	assert.Equal(t, nil, Validate(o))
+
+	o.TracingOTLPEndpoint = "collector.example.com:4318"
+	o.TracingSampleRatio = 1.5
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid tracing_otlp_endpoint \"collector.example.com:4318\": must be an http or https URL\n"+
+		"  tracing_sample_ratio 1.5 must be between 0 and 1", err.Error())
+}
+
+func TestCallbackMaxBodySizeInvalid(t *testing.T) {
+	o := testOptions()
+	o.CallbackMaxBodySize = 0
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  callback_max_body_size must be greater than 0", err.Error())
+}
+
+func TestPageResponseHeaders(t *testing.T) {
+	o := testOptions()
+	o.Templates.ResponseHeaders = []string{
+		"Content-Security-Policy: default-src 'self'; frame-ancestors 'none'",
+		"cache-control: no-store",
+		"Cache-Control:max-age=0",
+	}
+	assert.NoError(t, Validate(o))
+	assert.Equal(t, http.Header{
+		"Content-Security-Policy": []string{"default-src 'self'; frame-ancestors 'none'"},
+		"Cache-Control":           []string{"no-store", "max-age=0"},
+	}, o.GetPageResponseHeaders())
+}
+
+func TestPageResponseHeadersInvalid(t *testing.T) {
+	o := testOptions()
+	o.Templates.ResponseHeaders = []string{"no-separator", "Bad Name: value", ": value"}
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid page response header \"no-separator\": expected \"Name: value\"\n"+
+		"  invalid page response header \"Bad Name: value\": expected \"Name: value\"\n"+
+		"  invalid page response header \": value\": expected \"Name: value\"", err.Error())
+}
+
+func TestUpstreamRequestHeaders(t *testing.T) {
+	o := testOptions()
+	o.SetUpstreamHeaders = []string{
+		"X-Env: prod",
+		"x-source:oauth2-proxy",
+		"X-Env: eu",
+	}
+	o.StripUpstreamHeaders = []string{"Cookie"}
+	assert.NoError(t, Validate(o))
+	assert.Equal(t, http.Header{
+		"X-Env":    []string{"prod", "eu"},
+		"X-Source": []string{"oauth2-proxy"},
+	}, o.GetUpstreamRequestHeaders())
+}
+
+func TestUpstreamRequestHeadersInvalid(t *testing.T) {
+	o := testOptions()
+	o.SetUpstreamHeaders = []string{"no-separator", "Bad Name: value"}
+	o.StripUpstreamHeaders = []string{"Bad Name"}
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid strip upstream header \"Bad Name\": must be a header name\n"+
+		"  invalid set upstream header \"no-separator\": expected \"Name: value\"\n"+
+		"  invalid set upstream header \"Bad Name: value\": expected \"Name: value\"", err.Error())
+}
+
+func TestAjaxRequestOptions(t *testing.T) {
+	o := testOptions()
+	o.AjaxRequestHeaders = []string{"X-Requested-With: XMLHttpRequest", "Sec-Fetch-Mode:cors", "X-Fetch"}
+	o.AjaxUnauthorizedCode = http.StatusForbidden
+	assert.NoError(t, Validate(o))
+
+	o = testOptions()
+	o.AjaxRequestHeaders = []string{"Bad Name: value", ": value"}
+	o.AjaxUnauthorizedCode = http.StatusFound
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid ajax request header \"Bad Name: value\": expected \"Name\" or \"Name: value\"\n"+
+		"  invalid ajax request header \": value\": expected \"Name\" or \"Name: value\"\n"+
+		"  ajax_unauthorized_status_code 302 must be a 4xx status", err.Error())
+}
+
+func TestAccessDeniedPageOptions(t *testing.T) {
+	testCases := map[string]struct {
+		status     int
+		contactURL string
+		errMsg     string
+	}{
+		"default status": {
+			status: http.StatusForbidden,
+		},
+		"custom status and contact URL": {
+			status:     http.StatusNotFound,
+			contactURL: "mailto:access@example.com",
+		},
+		"unauthorized status": {
+			status: http.StatusUnauthorized,
+			errMsg: "access_denied_status_code 401 must be a 4xx status other than 401",
+		},
+		"redirect status": {
+			status: http.StatusFound,
+			errMsg: "access_denied_status_code 302 must be a 4xx status other than 401",
+		},
+		"relative contact URL": {
+			status:     http.StatusForbidden,
+			contactURL: "/help",
+			errMsg:     "invalid access_denied_contact_url \"/help\": must be an http, https or mailto URL",
+		},
+		"javascript contact URL": {
+			status:     http.StatusForbidden,
+			contactURL: "javascript:alert(1)",
+			errMsg:     "invalid access_denied_contact_url \"javascript:alert(1)\": must be an http, https or mailto URL",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			o := testOptions()
+			o.Templates.AccessDeniedStatusCode = tc.status
+			o.Templates.AccessDeniedContactURL = tc.contactURL
+			err := Validate(o)
+			if tc.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, "invalid configuration:\n  "+tc.errMsg)
+		})
+	}
+}
+
+func TestAdminAPIOptions(t *testing.T) {
+	o := testOptions()
+	o.AdminAPIToken = "admin-token"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  admin_api_token requires a redis session store", err.Error())
+
+	o = testOptions()
+	o.AdminAPIAllowedIPs = []string{"10.0.0.0/8", "10.0.0.0/33"}
+	err = Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  admin_api_allowed_ips requires admin_api_token to be set\n"+
+		"  admin_api_allowed_ips[1] (10.0.0.0/33) could not be recognized", err.Error())
+}
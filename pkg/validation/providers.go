@@ -3,8 +3,12 @@ package validation
 import (
 	"fmt"
 	"os"
+	"strings"
+	"text/template"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 )
 
 // validateProviders is the initial validation migration for multiple providrers
@@ -46,8 +50,8 @@ func validateProvider(provider options.Provider, providerIDs map[string]struct{}
 		msgs = append(msgs, "provider missing setting: client-id")
 	}
 
-	// login.gov uses a signed JWT to authenticate, not a client-secret
-	if provider.Type != "login.gov" {
+	// login.gov and client assertions use a signed JWT to authenticate, not a client-secret
+	if provider.Type != "login.gov" && provider.ClientAssertion == nil {
 		if provider.ClientSecret == "" && provider.ClientSecretFile == "" {
 			msgs = append(msgs, "missing setting: client-secret or client-secret-file")
 		}
@@ -59,11 +63,157 @@ func validateProvider(provider options.Provider, providerIDs map[string]struct{}
 		}
 	}
 
+	msgs = append(msgs, validateCodeChallengeMethod(provider)...)
+	msgs = append(msgs, validateRedeemRetries(provider)...)
+	msgs = append(msgs, validateOIDCDiscoveryRetry(provider)...)
+	msgs = append(msgs, validateOIDCJWTLeeway(provider)...)
+	msgs = append(msgs, validateAuthorizationRules(provider)...)
 	msgs = append(msgs, validateGoogleConfig(provider)...)
+	msgs = append(msgs, validateBitbucketConfig(provider)...)
+	msgs = append(msgs, validateGitHubConfig(provider)...)
+	msgs = append(msgs, validateUsernameClaims(provider)...)
+	msgs = append(msgs, validateGroupsTransforms(provider)...)
+	msgs = append(msgs, validateGroupsAPI(provider)...)
 
 	return msgs
 }
 
+// validateGroupsAPI checks that the groups API has a valid URL template and
+// auth method
+func validateGroupsAPI(provider options.Provider) []string {
+	if provider.GroupsAPI == nil {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if provider.GroupsAPI.URL == "" {
+		msgs = append(msgs, fmt.Sprintf("provider %s: groups API url is required", provider.ID))
+	} else if _, err := template.New("groupsAPI").Parse(provider.GroupsAPI.URL); err != nil {
+		msgs = append(msgs, fmt.Sprintf("provider %s: invalid groups API url: %v", provider.ID, err))
+	}
+
+	switch provider.GroupsAPI.AuthMethod {
+	case "", options.GroupsAPIAuthBearer, options.GroupsAPIAuthBasic, options.GroupsAPIAuthNone:
+	default:
+		msgs = append(msgs, fmt.Sprintf("provider %s: groups API auth method %q must be one of %q, %q or %q", provider.ID,
+			provider.GroupsAPI.AuthMethod, options.GroupsAPIAuthBearer, options.GroupsAPIAuthBasic, options.GroupsAPIAuthNone))
+	}
+	return msgs
+}
+
+// validateGroupsTransforms checks that each groups transform has exactly one
+// of its fields set
+func validateGroupsTransforms(provider options.Provider) []string {
+	msgs := []string{}
+	for i, transform := range provider.GroupsTransforms {
+		set := 0
+		if transform.StripPrefix != "" {
+			set++
+		}
+		if transform.Lowercase {
+			set++
+		}
+		if len(transform.Mapping) > 0 {
+			set++
+		}
+		if set != 1 {
+			msgs = append(msgs, fmt.Sprintf("provider %s: groups transform %d must have exactly one of stripPrefix, lowercase or mapping set", provider.ID, i))
+		}
+	}
+	return msgs
+}
+
+func validateUsernameClaims(provider options.Provider) []string {
+	for _, claim := range provider.OIDCConfig.UsernameClaims {
+		if claim == "" {
+			return []string{fmt.Sprintf("provider %s: oidc-username-claim must not be empty", provider.ID)}
+		}
+	}
+	return []string{}
+}
+
+func validateCodeChallengeMethod(provider options.Provider) []string {
+	switch provider.CodeChallengeMethod {
+	case "", encryption.CodeChallengeMethodPlain, encryption.CodeChallengeMethodS256:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("invalid setting: code-challenge-method %q must be one of %q or %q",
+			provider.CodeChallengeMethod, encryption.CodeChallengeMethodS256, encryption.CodeChallengeMethodPlain)}
+	}
+}
+
+func validateRedeemRetries(provider options.Provider) []string {
+	msgs := []string{}
+	if provider.RedeemRetries < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: redeem-retries %d must not be negative", provider.RedeemRetries))
+	}
+	if provider.RedeemRetryDelay < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: redeem-retry-delay %s must not be negative", provider.RedeemRetryDelay.Duration()))
+	}
+	return msgs
+}
+
+func validateOIDCDiscoveryRetry(provider options.Provider) []string {
+	msgs := []string{}
+	if provider.OIDCConfig.DiscoveryTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-discovery-timeout %s must not be negative", provider.OIDCConfig.DiscoveryTimeout.Duration()))
+	}
+	if provider.OIDCConfig.DiscoveryRetryBackoff < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-discovery-retry-backoff %s must not be negative", provider.OIDCConfig.DiscoveryRetryBackoff.Duration()))
+	}
+	return msgs
+}
+
+func validateOIDCJWTLeeway(provider options.Provider) []string {
+	msgs := []string{}
+	if leeway := provider.OIDCConfig.JWTLeeway; leeway != nil && *leeway < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-jwt-leeway %s must not be negative", leeway.Duration()))
+	}
+	return msgs
+}
+
+// validateAuthorizationRules checks the authorization rule actions and that
+// the expressions can be parsed
+func validateAuthorizationRules(provider options.Provider) []string {
+	if _, err := authorization.NewRules(provider.AuthorizationRules); err != nil {
+		return []string{fmt.Sprintf("provider %s: %v", provider.ID, err)}
+	}
+	return []string{}
+}
+
+func validateBitbucketConfig(provider options.Provider) []string {
+	msgs := []string{}
+	for _, repositoryPermission := range provider.BitbucketConfig.RepositoryPermissions {
+		i := strings.LastIndex(repositoryPermission, ":")
+		if i < 0 || !strings.Contains(repositoryPermission[:i], "/") {
+			msgs = append(msgs, fmt.Sprintf("provider %s: invalid bitbucket-repository-permission %q: expected workspace/repository:permission", provider.ID, repositoryPermission))
+			continue
+		}
+		switch repositoryPermission[i+1:] {
+		case "read", "write", "admin":
+		default:
+			msgs = append(msgs, fmt.Sprintf("provider %s: invalid bitbucket-repository-permission %q: expected the permission to be read, write or admin", provider.ID, repositoryPermission))
+		}
+	}
+	return msgs
+}
+
+// validateGitHubConfig checks that teams given as a bare slug have an org to
+// belong to, without it they would match a team with that slug in any org
+func validateGitHubConfig(provider options.Provider) []string {
+	msgs := []string{}
+	if provider.GitHubConfig.Org != "" {
+		return msgs
+	}
+	for _, team := range strings.Split(provider.GitHubConfig.Team, ",") {
+		team = strings.TrimSpace(team)
+		if team != "" && !strings.Contains(team, ":") {
+			msgs = append(msgs, fmt.Sprintf("provider %s: invalid github-team %q: expected org:slug when github-org is not set", provider.ID, team))
+		}
+	}
+	return msgs
+}
+
 func validateGoogleConfig(provider options.Provider) []string {
 	msgs := []string{}
 	if len(provider.GoogleConfig.Groups) > 0 ||
@@ -1,6 +1,8 @@
 package validation
 
 import (
+	"time"
+
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -26,6 +28,64 @@ var _ = Describe("Providers", func() {
 		ClientSecret: "ClientSecret",
 	}
 
+	validPKCEProvider := options.Provider{
+		ID:                  "ProviderIDPKCE",
+		ClientID:            "ClientID",
+		ClientSecret:        "ClientSecret",
+		CodeChallengeMethod: "S256",
+	}
+
+	invalidPKCEProvider := options.Provider{
+		ID:                  "ProviderIDInvalidPKCE",
+		ClientID:            "ClientID",
+		ClientSecret:        "ClientSecret",
+		CodeChallengeMethod: "S512",
+	}
+
+	invalidRetriesProvider := options.Provider{
+		ID:               "ProviderIDInvalidRetries",
+		ClientID:         "ClientID",
+		ClientSecret:     "ClientSecret",
+		RedeemRetries:    -1,
+		RedeemRetryDelay: options.Duration(-time.Second),
+	}
+
+	invalidDiscoveryRetryProvider := options.Provider{
+		ID:           "ProviderIDInvalidDiscoveryRetry",
+		ClientID:     "ClientID",
+		ClientSecret: "ClientSecret",
+		OIDCConfig: options.OIDCOptions{
+			DiscoveryTimeout:      options.Duration(-time.Minute),
+			DiscoveryRetryBackoff: options.Duration(-time.Second),
+		},
+	}
+
+	invalidJWTLeeway := options.Duration(-10 * time.Second)
+	invalidJWTLeewayProvider := options.Provider{
+		ID:           "ProviderIDInvalidJWTLeeway",
+		ClientID:     "ClientID",
+		ClientSecret: "ClientSecret",
+		OIDCConfig: options.OIDCOptions{
+			JWTLeeway: &invalidJWTLeeway,
+		},
+	}
+
+	invalidAuthorizationRulesProvider := options.Provider{
+		ID:           "ProviderIDInvalidRules",
+		ClientID:     "ClientID",
+		ClientSecret: "ClientSecret",
+		AuthorizationRules: []options.AuthorizationRule{
+			{
+				Action:     options.AuthorizationRuleAllow,
+				Expression: "department == engineering",
+			},
+			{
+				Action:     options.AuthorizationRuleDeny,
+				Expression: "department ==",
+			},
+		},
+	}
+
 	missingIDProvider := options.Provider{
 		ClientID:     "ClientID",
 		ClientSecret: "ClientSecret",
@@ -35,6 +95,13 @@ var _ = Describe("Providers", func() {
 	emptyIDMsg := "provider has empty id: ids are required for all providers"
 	duplicateProviderIDMsg := "multiple providers found with id ProviderID: provider ids must be unique"
 	skipButtonAndMultipleProvidersMsg := "SkipProviderButton and multiple providers are mutually exclusive"
+	invalidCodeChallengeMethodMsg := "invalid setting: code-challenge-method \"S512\" must be one of \"S256\" or \"plain\""
+	invalidRedeemRetriesMsg := "invalid setting: redeem-retries -1 must not be negative"
+	invalidRedeemRetryDelayMsg := "invalid setting: redeem-retry-delay -1s must not be negative"
+	invalidDiscoveryTimeoutMsg := "invalid setting: oidc-discovery-timeout -1m0s must not be negative"
+	invalidDiscoveryRetryBackoffMsg := "invalid setting: oidc-discovery-retry-backoff -1s must not be negative"
+	invalidJWTLeewayMsg := "invalid setting: oidc-jwt-leeway -10s must not be negative"
+	invalidAuthorizationRulesMsg := "provider ProviderIDInvalidRules: authorization rule 1 has invalid expression \"department ==\": expected a value after \"==\" at position 13, got end of expression"
 
 	DescribeTable("validateProviders",
 		func(o *validateProvidersTableInput) {
@@ -80,5 +147,216 @@ var _ = Describe("Providers", func() {
 			},
 			errStrings: []string{skipButtonAndMultipleProvidersMsg},
 		}),
+		Entry("with a valid code challenge method", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					validPKCEProvider,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an invalid code challenge method", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					invalidPKCEProvider,
+				},
+			},
+			errStrings: []string{invalidCodeChallengeMethodMsg},
+		}),
+		Entry("with invalid redeem retries", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					invalidRetriesProvider,
+				},
+			},
+			errStrings: []string{invalidRedeemRetriesMsg, invalidRedeemRetryDelayMsg},
+		}),
+		Entry("with invalid OIDC discovery retry", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					invalidDiscoveryRetryProvider,
+				},
+			},
+			errStrings: []string{invalidDiscoveryTimeoutMsg, invalidDiscoveryRetryBackoffMsg},
+		}),
+		Entry("with invalid OIDC JWT leeway", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					invalidJWTLeewayProvider,
+				},
+			},
+			errStrings: []string{invalidJWTLeewayMsg},
+		}),
+		Entry("with invalid authorization rules", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					invalidAuthorizationRulesProvider,
+				},
+			},
+			errStrings: []string{invalidAuthorizationRulesMsg},
+		}),
+		Entry("with an empty username claim", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						OIDCConfig: options.OIDCOptions{
+							UsernameClaims: []string{"preferred_username", ""},
+						},
+					},
+				},
+			},
+			errStrings: []string{"provider ProviderID: oidc-username-claim must not be empty"},
+		}),
+		Entry("with invalid bitbucket repository permissions", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						BitbucketConfig: options.BitbucketOptions{
+							RepositoryPermissions: []string{"workspace/repo:write", "workspace/repo", "repo:read", "workspace/repo:owner"},
+						},
+					},
+				},
+			},
+			errStrings: []string{
+				"provider ProviderID: invalid bitbucket-repository-permission \"workspace/repo\": expected workspace/repository:permission",
+				"provider ProviderID: invalid bitbucket-repository-permission \"repo:read\": expected workspace/repository:permission",
+				"provider ProviderID: invalid bitbucket-repository-permission \"workspace/repo:owner\": expected the permission to be read, write or admin",
+			},
+		}),
+		Entry("with github teams without an org", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						GitHubConfig: options.GitHubOptions{
+							Team: "org:admins, developers",
+						},
+					},
+				},
+			},
+			errStrings: []string{
+				"provider ProviderID: invalid github-team \"developers\": expected org:slug when github-org is not set",
+			},
+		}),
+		Entry("with github teams within an org", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						GitHubConfig: options.GitHubOptions{
+							Org:  "org",
+							Team: "other:admins,developers",
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid groups transforms", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						GroupsTransforms: []options.GroupsTransform{
+							{StripPrefix: "/"},
+							{},
+							{Lowercase: true, Mapping: map[string]string{"a": "b"}},
+						},
+					},
+				},
+			},
+			errStrings: []string{
+				"provider ProviderID: groups transform 1 must have exactly one of stripPrefix, lowercase or mapping set",
+				"provider ProviderID: groups transform 2 must have exactly one of stripPrefix, lowercase or mapping set",
+			},
+		}),
+		Entry("with a valid groups API", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						GroupsAPI: &options.GroupsAPI{
+							URL:        "https://cloud.example.com/ocs/v2.php/cloud/users/{{ urlquery .User }}/groups?format=json",
+							AuthMethod: "basic",
+							GroupsPath: "ocs.data.groups",
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an invalid groups API", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						GroupsAPI: &options.GroupsAPI{
+							URL:        "https://cloud.example.com/users/{{ .User }/groups",
+							AuthMethod: "token",
+						},
+					},
+				},
+			},
+			errStrings: []string{
+				"provider ProviderID: invalid groups API url: template: groupsAPI:1: unexpected \"}\" in operand",
+				"provider ProviderID: groups API auth method \"token\" must be one of \"bearer\", \"basic\" or \"none\"",
+			},
+		}),
+		Entry("with a groups API without url", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						GroupsAPI:    &options.GroupsAPI{},
+					},
+				},
+			},
+			errStrings: []string{
+				"provider ProviderID: groups API url is required",
+			},
+		}),
+		Entry("with a client assertion and no client secret", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:       "ProviderID",
+						ClientID: "ClientID",
+						ClientAssertion: &options.ClientAssertionOptions{
+							SigningKeyFile: "/etc/oauth2-proxy/client-assertion.pem",
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("without a client secret", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:       "ProviderID",
+						ClientID: "ClientID",
+					},
+				},
+			},
+			errStrings: []string{"missing setting: client-secret or client-secret-file"},
+		}),
 	)
 })
@@ -0,0 +1,101 @@
+package validation
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	tlsVersion12 = "TLS1.2"
+	tlsVersion13 = "TLS1.3"
+)
+
+// validateServers checks the socket, TLS and shutdown configuration of the
+// proxy and metrics servers, so that invalid file modes, unknown TLS versions
+// and cipher suites are reported at startup rather than when the listeners
+// are set up.
+func validateServers(o *options.Options) []string {
+	msgs := prefixValues("server: ", validateServer(o.Server)...)
+	msgs = append(msgs, prefixValues("metricsServer: ", validateServer(o.MetricsServer)...)...)
+	return msgs
+}
+
+func validateServer(server options.Server) []string {
+	msgs := validateServerSocketFileMode(server)
+	msgs = append(msgs, validateServerTLS(server.TLS)...)
+
+	if server.ShutdownTimeout < 0 {
+		msgs = append(msgs, "shutdownTimeout must not be negative")
+	}
+	if server.StreamShutdownTimeout < 0 {
+		msgs = append(msgs, "streamShutdownTimeout must not be negative")
+	}
+	return msgs
+}
+
+func validateServerSocketFileMode(server options.Server) []string {
+	if server.SocketFileMode == "" {
+		return []string{}
+	}
+	msgs := []string{}
+
+	if mode, err := strconv.ParseUint(server.SocketFileMode, 8, 32); err != nil || mode > 0o777 {
+		msgs = append(msgs, fmt.Sprintf("invalid socketFileMode %q: expected octal permissions, e.g. \"0660\"", server.SocketFileMode))
+	}
+	if !strings.HasPrefix(server.BindAddress, "unix://") {
+		msgs = append(msgs, "socketFileMode is set, but the bindAddress is not a unix socket, this will have no effect.")
+	}
+	return msgs
+}
+
+func validateServerTLS(tlsOpts *options.TLS) []string {
+	if tlsOpts == nil {
+		return []string{}
+	}
+	msgs := []string{}
+
+	switch tlsOpts.MinVersion {
+	case "", tlsVersion12, tlsVersion13:
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid TLS minVersion %q: expected %q or %q", tlsOpts.MinVersion, tlsVersion12, tlsVersion13))
+	}
+
+	if len(tlsOpts.CipherSuites) == 0 {
+		return msgs
+	}
+
+	// Go does not allow the TLS 1.3 cipher suites to be configured
+	if tlsOpts.MinVersion == tlsVersion13 {
+		msgs = append(msgs, "TLS cipherSuites are set, but the minVersion is TLS1.3, this will have no effect.")
+	}
+
+	secure := map[string]struct{}{}
+	for _, cipherSuite := range tls.CipherSuites() {
+		secure[cipherSuite.Name] = struct{}{}
+	}
+	insecure := map[string]struct{}{}
+	for _, cipherSuite := range tls.InsecureCipherSuites() {
+		insecure[cipherSuite.Name] = struct{}{}
+	}
+
+	unknown := []string{}
+	for _, name := range tlsOpts.CipherSuites {
+		if _, ok := secure[name]; ok {
+			continue
+		}
+		if _, ok := insecure[name]; ok {
+			logger.Printf("WARNING: TLS cipher suite %q is insecure", name)
+			continue
+		}
+		unknown = append(unknown, fmt.Sprintf("%q", name))
+	}
+	if len(unknown) > 0 {
+		msgs = append(msgs, fmt.Sprintf("unknown TLS cipherSuites %s: see https://pkg.go.dev/crypto/tls#pkg-constants for the valid names", strings.Join(unknown, ", ")))
+	}
+	return msgs
+}
@@ -0,0 +1,152 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Servers", func() {
+	type validateServersTableInput struct {
+		server        options.Server
+		metricsServer options.Server
+		expectedMsgs  []string
+	}
+
+	DescribeTable("validateServers",
+		func(in validateServersTableInput) {
+			o := &options.Options{
+				Server:        in.server,
+				MetricsServer: in.metricsServer,
+			}
+			Expect(validateServers(o)).To(ConsistOf(in.expectedMsgs))
+		},
+		Entry("without TLS", validateServersTableInput{
+			expectedMsgs: []string{},
+		}),
+		Entry("with the default TLS configuration", validateServersTableInput{
+			server: options.Server{
+				TLS: &options.TLS{},
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with a minimum version and cipher suites", validateServersTableInput{
+			server: options.Server{
+				TLS: &options.TLS{
+					MinVersion:   "TLS1.2",
+					CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				},
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with an insecure cipher suite", validateServersTableInput{
+			server: options.Server{
+				TLS: &options.TLS{
+					CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+				},
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with an unknown minimum version", validateServersTableInput{
+			server: options.Server{
+				TLS: &options.TLS{
+					MinVersion: "TLS1.1",
+				},
+			},
+			expectedMsgs: []string{
+				"server: invalid TLS minVersion \"TLS1.1\": expected \"TLS1.2\" or \"TLS1.3\"",
+			},
+		}),
+		Entry("with unknown cipher suites", validateServersTableInput{
+			server: options.Server{
+				TLS: &options.TLS{
+					CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_UNKNOWN", "tls_rsa_with_aes_128_gcm_sha256"},
+				},
+			},
+			expectedMsgs: []string{
+				"server: unknown TLS cipherSuites \"TLS_UNKNOWN\", \"tls_rsa_with_aes_128_gcm_sha256\": see https://pkg.go.dev/crypto/tls#pkg-constants for the valid names",
+			},
+		}),
+		Entry("with cipher suites and a minimum version of TLS1.3", validateServersTableInput{
+			server: options.Server{
+				TLS: &options.TLS{
+					MinVersion:   "TLS1.3",
+					CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+				},
+			},
+			expectedMsgs: []string{
+				"server: TLS cipherSuites are set, but the minVersion is TLS1.3, this will have no effect.",
+			},
+		}),
+		Entry("with unix sockets and file modes", validateServersTableInput{
+			server: options.Server{
+				BindAddress:    "unix:///var/run/oauth2-proxy/proxy.sock",
+				SocketFileMode: "0660",
+			},
+			metricsServer: options.Server{
+				BindAddress:    "unix:///var/run/oauth2-proxy/metrics.sock",
+				SocketFileMode: "600",
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with an invalid socket file mode", validateServersTableInput{
+			server: options.Server{
+				BindAddress:    "unix:///var/run/oauth2-proxy/proxy.sock",
+				SocketFileMode: "rw-rw----",
+			},
+			expectedMsgs: []string{
+				"server: invalid socketFileMode \"rw-rw----\": expected octal permissions, e.g. \"0660\"",
+			},
+		}),
+		Entry("with a socket file mode that is too large", validateServersTableInput{
+			server: options.Server{
+				BindAddress:    "unix:///var/run/oauth2-proxy/proxy.sock",
+				SocketFileMode: "4770",
+			},
+			expectedMsgs: []string{
+				"server: invalid socketFileMode \"4770\": expected octal permissions, e.g. \"0660\"",
+			},
+		}),
+		Entry("with a socket file mode for a TCP address", validateServersTableInput{
+			metricsServer: options.Server{
+				BindAddress:    "127.0.0.1:9100",
+				SocketFileMode: "0660",
+			},
+			expectedMsgs: []string{
+				"metricsServer: socketFileMode is set, but the bindAddress is not a unix socket, this will have no effect.",
+			},
+		}),
+		Entry("with shutdown timeouts", validateServersTableInput{
+			server: options.Server{
+				ShutdownTimeout:       options.Duration(30 * time.Second),
+				StreamShutdownTimeout: options.Duration(5 * time.Second),
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with negative shutdown timeouts", validateServersTableInput{
+			server: options.Server{
+				ShutdownTimeout:       options.Duration(-time.Second),
+				StreamShutdownTimeout: options.Duration(-time.Second),
+			},
+			expectedMsgs: []string{
+				"server: shutdownTimeout must not be negative",
+				"server: streamShutdownTimeout must not be negative",
+			},
+		}),
+		Entry("with an invalid metrics server configuration", validateServersTableInput{
+			metricsServer: options.Server{
+				TLS: &options.TLS{
+					MinVersion:   "TLS1.0",
+					CipherSuites: []string{"TLS_UNKNOWN"},
+				},
+			},
+			expectedMsgs: []string{
+				"metricsServer: invalid TLS minVersion \"TLS1.0\": expected \"TLS1.2\" or \"TLS1.3\"",
+				"metricsServer: unknown TLS cipherSuites \"TLS_UNKNOWN\": see https://pkg.go.dev/crypto/tls#pkg-constants for the valid names",
+			},
+		}),
+	)
+})
@@ -4,10 +4,14 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/memcached"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/redis"
 )
 
@@ -19,19 +23,53 @@ func validateSessionCookieMinimal(o *options.Options) []string {
 	msgs := []string{}
 	for _, header := range append(o.InjectRequestHeaders, o.InjectResponseHeaders...) {
 		for _, value := range header.Values {
-			if value.ClaimSource != nil {
-				if value.ClaimSource.Claim == "access_token" {
-					msgs = append(msgs,
-						fmt.Sprintf("access_token claim for header %q requires oauth tokens in sessions. session_cookie_minimal cannot be set", header.Name))
-				}
-				if value.ClaimSource.Claim == "id_token" {
-					msgs = append(msgs,
-						fmt.Sprintf("id_token claim for header %q requires oauth tokens in sessions. session_cookie_minimal cannot be set", header.Name))
-				}
+			if value.ClaimSource == nil {
+				continue
+			}
+			if value.ClaimSource.Template != "" {
+				msgs = append(msgs, validateMinimalSessionTemplate(header.Name, value.ClaimSource.Template)...)
+				continue
+			}
+			switch claim := value.ClaimSource.Claim; {
+			case isSessionTokenClaim(claim):
+				msgs = append(msgs,
+					fmt.Sprintf("%s claim for header %q requires oauth tokens in sessions. session_cookie_minimal cannot be set", claim, header.Name))
+			case !isMinimalSessionClaim(claim):
+				msgs = append(msgs,
+					fmt.Sprintf("%s claim for header %q is read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set", claim, header.Name))
+			}
+		}
+	}
+
+	for _, provider := range o.Providers {
+		// Invalid rules are reported by the provider validation
+		rules, err := authorization.NewRules(provider.AuthorizationRules)
+		if err != nil {
+			continue
+		}
+		for _, claim := range rules.Claims() {
+			switch {
+			case isSessionTokenClaim(claim):
+				msgs = append(msgs,
+					fmt.Sprintf("%s claim for authorization rules of provider %q requires oauth tokens in sessions. session_cookie_minimal cannot be set", claim, provider.ID))
+			case !isMinimalSessionClaim(claim):
+				msgs = append(msgs,
+					fmt.Sprintf("%s claim for authorization rules of provider %q is read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set", claim, provider.ID))
 			}
 		}
 	}
 
+	for _, upstream := range o.UpstreamServers.Upstreams {
+		if upstream.TokenExchange != nil {
+			msgs = append(msgs,
+				fmt.Sprintf("token exchange for upstream %q requires oauth tokens in sessions. session_cookie_minimal cannot be set", upstream.ID))
+		}
+		if len(upstream.ACRValues) > 0 || upstream.MaxAge != nil {
+			msgs = append(msgs,
+				fmt.Sprintf("acrValues and maxAge for upstream %q are read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set", upstream.ID))
+		}
+	}
+
 	if o.Cookie.Refresh != time.Duration(0) {
 		msgs = append(msgs,
 			"cookie_refresh > 0 requires oauth tokens in sessions. session_cookie_minimal cannot be set")
@@ -39,6 +77,211 @@ func validateSessionCookieMinimal(o *options.Options) []string {
 	return msgs
 }
 
+// isSessionTokenClaim returns whether the claim is one of the oauth tokens,
+// which are not stored in minimal sessions
+func isSessionTokenClaim(claim string) bool {
+	switch claim {
+	case "access_token", "id_token", "refresh_token":
+		return true
+	}
+	return false
+}
+
+// isMinimalSessionClaim returns whether the claim is stored in minimal
+// sessions, all other claims are read from the id_token
+func isMinimalSessionClaim(claim string) bool {
+	switch claim {
+	case "", "user", "email", "groups", "preferred_username", "created_at", "expires_on":
+		return true
+	}
+	return false
+}
+
+// validateMinimalSessionTemplate checks a header template only refers to the
+// claims of the session that templates can read without the id_token
+func validateMinimalSessionTemplate(name, text string) []string {
+	claims, allClaims, err := templateClaims(text)
+	if err != nil {
+		// Invalid templates are reported by the header validation
+		return []string{}
+	}
+	if allClaims {
+		return []string{fmt.Sprintf("template for header %q reads all claims from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set", name)}
+	}
+
+	msgs := []string{}
+	for _, claim := range claims {
+		switch claim {
+		case "user", "email", "groups", "preferred_username":
+			// Stored in minimal sessions
+		default:
+			msgs = append(msgs,
+				fmt.Sprintf("%s claim in template for header %q is read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set", claim, name))
+		}
+	}
+	return msgs
+}
+
+// templateClaims returns the claims a header template refers to, in the order
+// they first appear. allClaims is set when the template uses the claims
+// other than by name, eg `{{ . }}` or `{{ index . "name" }}`.
+// Fields within `with` and `range` blocks are relative to the claim of the
+// block and are not claims themselves.
+func templateClaims(text string) (claims []string, allClaims bool, err error) {
+	tmpl, err := template.New("claim").Parse(text)
+	if err != nil {
+		return nil, false, err
+	}
+
+	seen := map[string]struct{}{}
+	addClaim := func(claim string) {
+		if _, ok := seen[claim]; !ok {
+			seen[claim] = struct{}{}
+			claims = append(claims, claim)
+		}
+	}
+
+	var walk func(node parse.Node, nested bool)
+	walk = func(node parse.Node, nested bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, nested)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, nested)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, nested)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, nested)
+			}
+		case *parse.ChainNode:
+			walk(n.Node, nested)
+		case *parse.TemplateNode:
+			// Fields of the invoked template are relative to its pipeline
+			walk(n.Pipe, nested)
+		case *parse.IfNode:
+			walk(n.Pipe, nested)
+			walk(n.List, nested)
+			walk(n.ElseList, nested)
+		case *parse.WithNode:
+			walk(n.Pipe, nested)
+			walk(n.List, true)
+			walk(n.ElseList, nested)
+		case *parse.RangeNode:
+			walk(n.Pipe, nested)
+			walk(n.List, true)
+			walk(n.ElseList, nested)
+		case *parse.FieldNode:
+			if !nested {
+				addClaim(n.Ident[0])
+			}
+		case *parse.DotNode:
+			if !nested {
+				allClaims = true
+			}
+		case *parse.VariableNode:
+			// $ is the claims in every block
+			if n.Ident[0] == "$" {
+				if len(n.Ident) > 1 {
+					addClaim(n.Ident[1])
+				} else {
+					allClaims = true
+				}
+			}
+		}
+	}
+	walk(tmpl.Tree.Root, false)
+	return claims, allClaims, nil
+}
+
+// validateSessionStoreCompression checks the session compression algorithm
+// is supported
+func validateSessionStoreCompression(o *options.Options) []string {
+	switch o.Session.Compression {
+	case "", options.SessionStoreCompressionNone, options.SessionStoreCompressionGzip:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("invalid setting: session-store-compression %q must be one of %q or %q",
+			o.Session.Compression, options.SessionStoreCompressionNone, options.SessionStoreCompressionGzip)}
+	}
+}
+
+// validateSessionMaxLifetime checks the maximum session lifetime is not
+// negative
+func validateSessionMaxLifetime(o *options.Options) []string {
+	if o.Session.MaxLifetime < 0 {
+		return []string{fmt.Sprintf("invalid setting: session-max-lifetime %s must not be negative", o.Session.MaxLifetime)}
+	}
+	return []string{}
+}
+
+// validateSessionIdleTimeout checks the session idle timeout is not negative
+func validateSessionIdleTimeout(o *options.Options) []string {
+	if o.Session.IdleTimeout < 0 {
+		return []string{fmt.Sprintf("invalid setting: session-idle-timeout %s must not be negative", o.Session.IdleTimeout)}
+	}
+	return []string{}
+}
+
+// validateValidateEachRequest checks the sessions keep the access token that
+// is checked on each request and that the cache TTL is not negative
+func validateValidateEachRequest(o *options.Options) []string {
+	msgs := []string{}
+	if !o.Session.ValidateEachRequest {
+		return msgs
+	}
+
+	if o.Session.Cookie.Minimal {
+		msgs = append(msgs, "invalid setting: validate-each-request requires the access token, which is not stored with session-cookie-minimal")
+	}
+	if o.Session.ValidateEachRequestCacheTTL < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: validate-each-request-cache-ttl %s must not be negative", o.Session.ValidateEachRequestCacheTTL))
+	}
+	return msgs
+}
+
+const (
+	// minSessionCookieChunkSize leaves room for the value of each chunk
+	// next to the longest cookie names and their attributes
+	minSessionCookieChunkSize = 1024
+	// maxSessionCookieChunkSize is the cookie size limit of most browsers
+	maxSessionCookieChunkSize = 4096
+)
+
+// validateSessionCookieChunks checks the session cookie chunk size is
+// within the browser limits and the maximum number of chunks is not negative
+func validateSessionCookieChunks(o *options.Options) []string {
+	msgs := []string{}
+	chunkSize := o.Session.Cookie.ChunkSize
+	if chunkSize != 0 && (chunkSize < minSessionCookieChunkSize || chunkSize > maxSessionCookieChunkSize) {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: session-cookie-chunk-size %d must be between %d and %d",
+			chunkSize, minSessionCookieChunkSize, maxSessionCookieChunkSize))
+	}
+	if o.Session.Cookie.MaxChunks < 0 {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: session-cookie-max-chunks %d must not be negative", o.Session.Cookie.MaxChunks))
+	}
+	return msgs
+}
+
+// validateCSRFServerSide checks the session store can hold the CSRF state
+// when it is stored server-side
+func validateCSRFServerSide(o *options.Options) []string {
+	if o.Cookie.CSRFServerSide && o.Session.Type == options.CookieSessionStoreType {
+		return []string{"cookie_csrf_server_side requires a redis or memcached session store"}
+	}
+	return []string{}
+}
+
 // validateRedisSessionStore builds a Redis Client from the options and
 // attempts to connect, Set, Get and Del a random health check key
 func validateRedisSessionStore(o *options.Options) []string {
@@ -57,7 +300,7 @@ func validateRedisSessionStore(o *options.Options) []string {
 	}
 	nonce := base64.RawURLEncoding.EncodeToString(n)
 
-	key := fmt.Sprintf("%s-healthcheck-%s", o.Cookie.Name, nonce)
+	key := fmt.Sprintf("%s-healthcheck-%s", o.Cookie.PrefixedName(), nonce)
 	return sendRedisConnectionTest(client, key, nonce)
 }
 
@@ -86,3 +329,21 @@ func sendRedisConnectionTest(client redis.Client, key string, val string) []stri
 	}
 	return msgs
 }
+
+// validateMemcachedSessionStore builds a memcached Client from the options
+// and checks that every server is responding
+func validateMemcachedSessionStore(o *options.Options) []string {
+	if o.Session.Type != options.MemcachedSessionStoreType {
+		return []string{}
+	}
+
+	client, err := memcached.NewMemcachedClient(o.Session.Memcached.Servers)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to initialize a memcached client: %v", err)}
+	}
+
+	if err := client.Ping(context.Background()); err != nil {
+		return []string{fmt.Sprintf("unable to connect to memcached: %v", err)}
+	}
+	return []string{}
+}
@@ -13,9 +13,17 @@ import (
 
 var _ = Describe("Sessions", func() {
 	const (
-		idTokenConflictMsg     = "id_token claim for header \"X-ID-Token\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
-		accessTokenConflictMsg = "access_token claim for header \"X-Access-Token\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
-		cookieRefreshMsg       = "cookie_refresh > 0 requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		idTokenConflictMsg       = "id_token claim for header \"X-ID-Token\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		accessTokenConflictMsg   = "access_token claim for header \"X-Access-Token\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		cookieRefreshMsg         = "cookie_refresh > 0 requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		refreshTokenConflictMsg  = "refresh_token claim for header \"X-Refresh-Token\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		idTokenClaimConflictMsg  = "department claim for header \"X-Department\" is read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		tokenExchangeConflictMsg = "token exchange for upstream \"api\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		stepUpConflictMsg        = "acrValues and maxAge for upstream \"admin\" are read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		templateClaimConflictMsg = "given_name claim in template for header \"X-Name\" is read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		templateAllClaimsMsg     = "template for header \"X-Claims\" reads all claims from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		ruleClaimConflictMsg     = "department claim for authorization rules of provider \"oidc\" is read from the id_token which requires oauth tokens in sessions. session_cookie_minimal cannot be set"
+		ruleTokenConflictMsg     = "access_token claim for authorization rules of provider \"oidc\" requires oauth tokens in sessions. session_cookie_minimal cannot be set"
 	)
 
 	type cookieMinimalTableInput struct {
@@ -135,6 +143,229 @@ var _ = Describe("Sessions", func() {
 			},
 			errStrings: []string{accessTokenConflictMsg},
 		}),
+		Entry("Request Header refresh_token conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				InjectRequestHeaders: []options.Header{
+					{
+						Name: "X-Refresh-Token",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "refresh_token",
+								},
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{refreshTokenConflictMsg},
+		}),
+		Entry("Request Header id_token claim conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				InjectRequestHeaders: []options.Header{
+					{
+						Name: "X-Department",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "department",
+								},
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{idTokenClaimConflictMsg},
+		}),
+		Entry("Request Header with minimal session claims", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				InjectRequestHeaders: []options.Header{
+					{
+						Name: "X-Forwarded-Email",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "email",
+								},
+							},
+						},
+					},
+					{
+						Name: "X-Forwarded-Groups",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "groups",
+								},
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("Template with minimal session claims", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				InjectRequestHeaders: []options.Header{
+					{
+						Name: "X-Identity",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template: "{{.user}} <{{.email}}>{{range .groups}} {{.}}{{end}}{{with $.preferred_username}} {{.}}{{end}}",
+								},
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("Template with id_token claims conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				InjectRequestHeaders: []options.Header{
+					{
+						Name: "X-Name",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template: "{{if .email}}{{.given_name}}{{end}} {{.user}}",
+								},
+							},
+						},
+					},
+					{
+						Name: "X-Claims",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Template: "{{index . \"department\"}}",
+								},
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{templateClaimConflictMsg, templateAllClaimsMsg},
+		}),
+		Entry("Authorization rules with minimal session claims", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				Providers: options.Providers{
+					{
+						ID: "oidc",
+						AuthorizationRules: []options.AuthorizationRule{
+							{
+								Action:     options.AuthorizationRuleAllow,
+								Expression: "groups contains admins || email == \"alice@example.com\"",
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("Authorization rules with id_token claims conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				Providers: options.Providers{
+					{
+						ID: "oidc",
+						AuthorizationRules: []options.AuthorizationRule{
+							{
+								Action:     options.AuthorizationRuleDeny,
+								Expression: "access_token == \"\"",
+							},
+							{
+								Action:     options.AuthorizationRuleAllow,
+								Expression: "groups contains admins && department == engineering",
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{ruleTokenConflictMsg, ruleClaimConflictMsg},
+		}),
+		Entry("Upstream token exchange conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				UpstreamServers: options.UpstreamConfig{
+					Upstreams: []options.Upstream{
+						{
+							ID:   "api",
+							Path: "/api",
+							URI:  "http://api.internal",
+							TokenExchange: &options.TokenExchange{
+								Audience: "api",
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{tokenExchangeConflictMsg},
+		}),
+		Entry("Upstream step-up authentication conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				UpstreamServers: options.UpstreamConfig{
+					Upstreams: []options.Upstream{
+						{
+							ID:   "app",
+							Path: "/",
+							URI:  "http://app.internal",
+						},
+						{
+							ID:        "admin",
+							Path:      "/admin/",
+							URI:       "http://admin.internal",
+							ACRValues: []string{"urn:example:mfa"},
+						},
+					},
+				},
+			},
+			errStrings: []string{stepUpConflictMsg},
+		}),
 		Entry("CookieRefresh conflict", &cookieMinimalTableInput{
 			opts: &options.Options{
 				Cookie: options.Cookie{
@@ -184,16 +415,142 @@ var _ = Describe("Sessions", func() {
 		}),
 	)
 
+	DescribeTable("validateSessionStoreCompression",
+		func(compression string, errStrings []string) {
+			o := &options.Options{
+				Session: options.SessionOptions{
+					Compression: compression,
+				},
+			}
+			Expect(validateSessionStoreCompression(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with no compression set", "", []string{}),
+		Entry("with none", "none", []string{}),
+		Entry("with gzip", "gzip", []string{}),
+		Entry("with an unknown algorithm", "brotli", []string{
+			"invalid setting: session-store-compression \"brotli\" must be one of \"none\" or \"gzip\"",
+		}),
+	)
+
+	DescribeTable("validateSessionMaxLifetime",
+		func(maxLifetime time.Duration, errStrings []string) {
+			o := &options.Options{
+				Session: options.SessionOptions{
+					MaxLifetime: maxLifetime,
+				},
+			}
+			Expect(validateSessionMaxLifetime(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with no maximum lifetime", time.Duration(0), []string{}),
+		Entry("with a maximum lifetime", 12*time.Hour, []string{}),
+		Entry("with a negative maximum lifetime", -time.Hour, []string{
+			"invalid setting: session-max-lifetime -1h0m0s must not be negative",
+		}),
+	)
+
+	DescribeTable("validateSessionIdleTimeout",
+		func(idleTimeout time.Duration, errStrings []string) {
+			o := &options.Options{
+				Session: options.SessionOptions{
+					IdleTimeout: idleTimeout,
+				},
+			}
+			Expect(validateSessionIdleTimeout(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with no idle timeout", time.Duration(0), []string{}),
+		Entry("with an idle timeout", 30*time.Minute, []string{}),
+		Entry("with a negative idle timeout", -time.Minute, []string{
+			"invalid setting: session-idle-timeout -1m0s must not be negative",
+		}),
+	)
+
+	DescribeTable("validateValidateEachRequest",
+		func(session options.SessionOptions, errStrings []string) {
+			o := &options.Options{
+				Session: session,
+			}
+			Expect(validateValidateEachRequest(o)).To(ConsistOf(errStrings))
+		},
+		Entry("when disabled", options.SessionOptions{
+			Cookie: options.CookieStoreOptions{Minimal: true},
+		}, []string{}),
+		Entry("with a cache TTL", options.SessionOptions{
+			ValidateEachRequest:         true,
+			ValidateEachRequestCacheTTL: 10 * time.Second,
+		}, []string{}),
+		Entry("with minimal session cookies", options.SessionOptions{
+			ValidateEachRequest: true,
+			Cookie:              options.CookieStoreOptions{Minimal: true},
+		}, []string{
+			"invalid setting: validate-each-request requires the access token, which is not stored with session-cookie-minimal",
+		}),
+		Entry("with a negative cache TTL", options.SessionOptions{
+			ValidateEachRequest:         true,
+			ValidateEachRequestCacheTTL: -time.Second,
+		}, []string{
+			"invalid setting: validate-each-request-cache-ttl -1s must not be negative",
+		}),
+	)
+
+	DescribeTable("validateSessionCookieChunks",
+		func(chunkSize, maxChunks int, errStrings []string) {
+			o := &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						ChunkSize: chunkSize,
+						MaxChunks: maxChunks,
+					},
+				},
+			}
+			Expect(validateSessionCookieChunks(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with the defaults", options.DefaultSessionCookieChunkSize, 0, []string{}),
+		Entry("with no chunk size set", 0, 0, []string{}),
+		Entry("with a smaller chunk size and a chunk limit", 2048, 2, []string{}),
+		Entry("with a chunk size below the minimum", 512, 0, []string{
+			"invalid setting: session-cookie-chunk-size 512 must be between 1024 and 4096",
+		}),
+		Entry("with a chunk size above the browser limit", 8192, 0, []string{
+			"invalid setting: session-cookie-chunk-size 8192 must be between 1024 and 4096",
+		}),
+		Entry("with a negative chunk limit", 2048, -1, []string{
+			"invalid setting: session-cookie-max-chunks -1 must not be negative",
+		}),
+	)
+
+	DescribeTable("validateCSRFServerSide",
+		func(sessionType string, csrfServerSide bool, errStrings []string) {
+			o := &options.Options{
+				Cookie: options.Cookie{
+					CSRFServerSide: csrfServerSide,
+				},
+				Session: options.SessionOptions{
+					Type: sessionType,
+				},
+			}
+			Expect(validateCSRFServerSide(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with the CSRF in a cookie", options.CookieSessionStoreType, false, []string{}),
+		Entry("with the CSRF server-side in redis", options.RedisSessionStoreType, true, []string{}),
+		Entry("with the CSRF server-side in memcached", options.MemcachedSessionStoreType, true, []string{}),
+		Entry("with the CSRF server-side in a cookie session store", options.CookieSessionStoreType, true, []string{
+			"cookie_csrf_server_side requires a redis or memcached session store",
+		}),
+	)
+
 	const (
-		clusterAndSentinelMsg     = "unable to initialize a redis client: options redis-use-sentinel and redis-use-cluster are mutually exclusive"
-		parseWrongSchemeMsg       = "unable to initialize a redis client: unable to parse redis url: redis: invalid URL scheme: https"
-		parseWrongFormatMsg       = "unable to initialize a redis client: unable to parse redis url: redis: invalid database number: \"wrong\""
-		invalidPasswordSetMsg     = "unable to set a redis initialization key: WRONGPASS invalid username-password pair"
-		invalidPasswordDelMsg     = "unable to delete the redis initialization key: WRONGPASS invalid username-password pair"
-		unreachableRedisSetMsg    = "unable to set a redis initialization key: dial tcp 127.0.0.1:65535: connect: connection refused"
-		unreachableRedisDelMsg    = "unable to delete the redis initialization key: dial tcp 127.0.0.1:65535: connect: connection refused"
-		unreachableSentinelSetMsg = "unable to set a redis initialization key: redis: all sentinels specified in configuration are unreachable"
-		unrechableSentinelDelMsg  = "unable to delete the redis initialization key: redis: all sentinels specified in configuration are unreachable"
+		clusterAndSentinelMsg      = "unable to initialize a redis client: options redis-use-sentinel and redis-use-cluster are mutually exclusive"
+		sentinelWithClusterURLsMsg = "unable to initialize a redis client: option redis-cluster-connection-urls cannot be used with redis-use-sentinel"
+		clusterWithSentinelURLsMsg = "unable to initialize a redis client: option redis-sentinel-connection-urls cannot be used with redis-use-cluster"
+		clusterWithoutURLsMsg      = "unable to initialize a redis client: option redis-use-cluster requires at least one redis-cluster-connection-urls"
+		parseWrongSchemeMsg        = "unable to initialize a redis client: unable to parse redis url: redis: invalid URL scheme: https"
+		parseWrongFormatMsg        = "unable to initialize a redis client: unable to parse redis url: redis: invalid database number: \"wrong\""
+		invalidPasswordSetMsg      = "unable to set a redis initialization key: WRONGPASS invalid username-password pair"
+		invalidPasswordDelMsg      = "unable to delete the redis initialization key: WRONGPASS invalid username-password pair"
+		unreachableRedisSetMsg     = "unable to set a redis initialization key: dial tcp 127.0.0.1:65535: connect: connection refused"
+		unreachableRedisDelMsg     = "unable to delete the redis initialization key: dial tcp 127.0.0.1:65535: connect: connection refused"
+		unreachableSentinelSetMsg  = "unable to set a redis initialization key: redis: all sentinels specified in configuration are unreachable"
+		unrechableSentinelDelMsg   = "unable to delete the redis initialization key: redis: all sentinels specified in configuration are unreachable"
 	)
 
 	type redisStoreTableInput struct {
@@ -404,5 +761,74 @@ var _ = Describe("Sessions", func() {
 			},
 			errStrings: []string{clusterAndSentinelMsg},
 		}),
+		Entry("sentinel with cluster connection urls fails", &redisStoreTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+					Redis: options.RedisStoreOptions{
+						UseSentinel:            true,
+						SentinelConnectionURLs: []string{"redis://127.0.0.1:26379"},
+						ClusterConnectionURLs:  []string{"redis://127.0.0.1:6379"},
+					},
+				},
+			},
+			errStrings: []string{sentinelWithClusterURLsMsg},
+		}),
+		Entry("cluster with sentinel connection urls fails", &redisStoreTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+					Redis: options.RedisStoreOptions{
+						UseCluster:             true,
+						SentinelConnectionURLs: []string{"redis://127.0.0.1:26379"},
+						ClusterConnectionURLs:  []string{"redis://127.0.0.1:6379"},
+					},
+				},
+			},
+			errStrings: []string{clusterWithSentinelURLsMsg},
+		}),
+		Entry("cluster without connection urls fails", &redisStoreTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+					Redis: options.RedisStoreOptions{
+						UseCluster: true,
+					},
+				},
+			},
+			errStrings: []string{clusterWithoutURLsMsg},
+		}),
 	)
+
+	DescribeTable("validateMemcachedSessionStore",
+		func(servers []string, errStrings []string) {
+			o := &options.Options{
+				Session: options.SessionOptions{
+					Type: options.MemcachedSessionStoreType,
+					Memcached: options.MemcachedStoreOptions{
+						Servers: servers,
+					},
+				},
+			}
+			Expect(validateMemcachedSessionStore(o)).To(ConsistOf(errStrings))
+		},
+		Entry("without any servers", []string{}, []string{
+			"unable to initialize a memcached client: at least one memcached server is required",
+		}),
+		Entry("with an invalid server address", []string{"memcached.example.com"}, []string{
+			"unable to initialize a memcached client: invalid memcached server address \"memcached.example.com\": address memcached.example.com: missing port in address",
+		}),
+		Entry("with an unreachable server", []string{"127.0.0.1:65535"}, []string{
+			"unable to connect to memcached: error pinging memcached server 127.0.0.1:65535: dial tcp 127.0.0.1:65535: connect: connection refused",
+		}),
+	)
+
+	It("skips validateMemcachedSessionStore for cookie sessions", func() {
+		o := &options.Options{
+			Session: options.SessionOptions{
+				Type: options.CookieSessionStoreType,
+			},
+		}
+		Expect(validateMemcachedSessionStore(o)).To(BeEmpty())
+	})
 })
@@ -3,6 +3,7 @@ package validation
 import (
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 )
@@ -15,6 +16,35 @@ func validateUpstreams(upstreams options.UpstreamConfig) []string {
 	for _, upstream := range upstreams.Upstreams {
 		msgs = append(msgs, validateUpstream(upstream, ids, paths)...)
 	}
+	msgs = append(msgs, validateUpstreamTransport(upstreams.Transport)...)
+
+	return msgs
+}
+
+// validateUpstreamTransport checks that the connection pool limits are not
+// negative and that the response header limit is positive
+func validateUpstreamTransport(transport options.UpstreamTransport) []string {
+	msgs := []string{}
+
+	limits := []struct {
+		name  string
+		value *int
+	}{
+		{"maxIdleConns", transport.MaxIdleConns},
+		{"maxIdleConnsPerHost", transport.MaxIdleConnsPerHost},
+		{"maxConnsPerHost", transport.MaxConnsPerHost},
+	}
+	for _, limit := range limits {
+		if limit.value != nil && *limit.value < 0 {
+			msgs = append(msgs, fmt.Sprintf("upstream transport %s %d must not be negative", limit.name, *limit.value))
+		}
+	}
+	if transport.IdleConnTimeout != nil && *transport.IdleConnTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream transport idleConnTimeout %s must not be negative", transport.IdleConnTimeout.Duration()))
+	}
+	if transport.MaxResponseHeaderBytes != nil && *transport.MaxResponseHeaderBytes <= 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream transport maxResponseHeaderBytes %d must be greater than 0", *transport.MaxResponseHeaderBytes))
+	}
 
 	return msgs
 }
@@ -44,7 +74,261 @@ func validateUpstream(upstream options.Upstream, ids, paths map[string]struct{})
 	paths[upstream.Path] = struct{}{}
 
 	msgs = append(msgs, validateUpstreamURI(upstream)...)
+	msgs = append(msgs, validateUpstreamLoadBalancing(upstream)...)
 	msgs = append(msgs, validateStaticUpstream(upstream)...)
+	msgs = append(msgs, validateUpstreamTokenExchange(upstream)...)
+	msgs = append(msgs, validateUpstreamStepUp(upstream)...)
+	msgs = append(msgs, validateUpstreamAllowedMethods(upstream)...)
+	msgs = append(msgs, validateUpstreamAllowedWebSocketOrigins(upstream)...)
+	msgs = append(msgs, validateUpstreamStatusActions(upstream)...)
+	msgs = append(msgs, validateUpstreamStripPrefix(upstream)...)
+	msgs = append(msgs, validateUpstreamTLS(upstream)...)
+	msgs = append(msgs, validateUpstreamHTTP2(upstream)...)
+	msgs = append(msgs, validateUpstreamRetries(upstream)...)
+	return msgs
+}
+
+// validateUpstreamTLS checks that a client certificate is configured with
+// its key and that TLS options are only set for upstreams that proxy HTTP requests.
+func validateUpstreamTLS(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if upstream.TLSClientCertFile == "" && upstream.TLSClientKeyFile == "" && upstream.TLSCAFile == "" {
+		return msgs
+	}
+
+	if upstream.TLSClientCertFile != "" && upstream.TLSClientKeyFile == "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has tlsClientCertFile without tlsClientKeyFile: both are required for client certificates", upstream.ID))
+	}
+	if upstream.TLSClientCertFile == "" && upstream.TLSClientKeyFile != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has tlsClientKeyFile without tlsClientCertFile: both are required for client certificates", upstream.ID))
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has TLS client options, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has TLS client options, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateUpstreamStripPrefix checks that stripPrefix is not combined with
+// a rewriteTarget and is only configured for upstreams that proxy HTTP requests.
+func validateUpstreamStripPrefix(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if !upstream.StripPrefix {
+		return msgs
+	}
+
+	if upstream.RewriteTarget != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has stripPrefix and rewriteTarget: only one of these may be set", upstream.ID))
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has stripPrefix, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has stripPrefix, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateUpstreamTokenExchange checks that token exchange has an audience
+// or a valid resource and a known grant, and is only configured for upstreams
+// that proxy HTTP requests.
+func validateUpstreamTokenExchange(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	exchange := upstream.TokenExchange
+	if exchange == nil {
+		return msgs
+	}
+
+	if exchange.Audience == "" && exchange.Resource == "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has tokenExchange with empty audience and resource: an audience or resource is required for token exchange", upstream.ID))
+	}
+	if exchange.Resource != "" {
+		if u, err := url.Parse(exchange.Resource); err != nil || !u.IsAbs() || u.Fragment != "" {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has tokenExchange with invalid resource %q: the resource must be an absolute URI without a fragment", upstream.ID, exchange.Resource))
+		}
+	}
+	switch exchange.Grant {
+	case "", options.TokenExchangeGrantTokenExchange, options.TokenExchangeGrantRefreshToken:
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstream %q has tokenExchange with unknown grant %q: must be one of %q or %q",
+			upstream.ID, exchange.Grant, options.TokenExchangeGrantTokenExchange, options.TokenExchangeGrantRefreshToken))
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has tokenExchange, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has tokenExchange, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateUpstreamHTTP2 checks that HTTP/2 is only configured for upstreams
+// that proxy HTTP requests.
+func validateUpstreamHTTP2(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if !upstream.HTTP2 {
+		return msgs
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has http2, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has http2, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateUpstreamRetries checks the retries and their backoff are not
+// negative and that they are only set for upstreams that proxy HTTP requests
+func validateUpstreamRetries(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if upstream.Retries < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has retries %d: retries must not be negative", upstream.ID, upstream.Retries))
+	}
+	if upstream.RetryBackoff != nil {
+		if upstream.RetryBackoff.Duration() < 0 {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has retryBackoff %s: retryBackoff must not be negative", upstream.ID, upstream.RetryBackoff.Duration()))
+		}
+		if upstream.Retries == 0 {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has retryBackoff, but no retries, this will have no effect.", upstream.ID))
+		}
+	}
+
+	if upstream.Retries == 0 {
+		return msgs
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has retries, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has retries, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateUpstreamStepUp checks the acr values can be sent as the space
+// separated acr_values and that the max age is not negative
+func validateUpstreamStepUp(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	for _, acr := range upstream.ACRValues {
+		if acr == "" || strings.ContainsAny(acr, " \t\r\n") {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has invalid acr value %q: acr values must not be empty or contain whitespace", upstream.ID, acr))
+		}
+	}
+
+	if upstream.MaxAge != nil && *upstream.MaxAge < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has maxAge %s: maxAge must not be negative", upstream.ID, upstream.MaxAge.Duration()))
+	}
+
+	return msgs
+}
+
+// validateUpstreamAllowedMethods checks the allowed methods are single
+// method names
+func validateUpstreamAllowedMethods(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	for _, method := range upstream.AllowedMethods {
+		if method == "" || strings.ContainsAny(method, " \t\r\n,") {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has invalid allowed method %q: methods must not be empty or contain whitespace or commas", upstream.ID, method))
+		}
+	}
+
+	return msgs
+}
+
+// validateUpstreamAllowedWebSocketOrigins checks that each allowed origin is
+// a scheme and host and that the origins are only configured for upstreams
+// that proxy HTTP requests.
+func validateUpstreamAllowedWebSocketOrigins(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if len(upstream.AllowedWebSocketOrigins) == 0 {
+		return msgs
+	}
+
+	for _, origin := range upstream.AllowedWebSocketOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has invalid allowed WebSocket origin %q: origins must be a scheme and host, eg https://app.example.com", upstream.ID, origin))
+		}
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has allowedWebSocketOrigins, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has allowedWebSocketOrigins, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateUpstreamStatusActions checks each status has a single known action
+// and that the actions are only configured for upstreams that proxy HTTP requests.
+func validateUpstreamStatusActions(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if len(upstream.StatusActions) == 0 {
+		return msgs
+	}
+
+	statuses := make(map[int]struct{}, len(upstream.StatusActions))
+	for _, statusAction := range upstream.StatusActions {
+		if statusAction.Status < 100 || statusAction.Status > 599 {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has status action with invalid status %d: status must be between 100 and 599", upstream.ID, statusAction.Status))
+		}
+		if _, ok := statuses[statusAction.Status]; ok {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has multiple status actions for status %d", upstream.ID, statusAction.Status))
+		}
+		statuses[statusAction.Status] = struct{}{}
+
+		switch statusAction.Action {
+		case options.UpstreamStatusActionPassThrough, options.UpstreamStatusActionReauthenticate, options.UpstreamStatusActionErrorPage:
+		default:
+			msgs = append(msgs, fmt.Sprintf("upstream %q has invalid action %q for status %d: action must be one of %q, %q or %q", upstream.ID, statusAction.Action, statusAction.Status,
+				options.UpstreamStatusActionPassThrough, options.UpstreamStatusActionReauthenticate, options.UpstreamStatusActionErrorPage))
+		}
+	}
+
+	if upstream.Static {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has statusActions, but is a static upstream, this will have no effect.", upstream.ID))
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); err == nil && u.Scheme == "file" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has statusActions, but is a file upstream, this will have no effect.", upstream.ID))
+	}
+
 	return msgs
 }
 
@@ -57,6 +341,15 @@ func validateStaticUpstream(upstream options.Upstream) []string {
 	if !upstream.Static && upstream.StaticCode != nil {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has staticCode (%d), but is not a static upstream, set 'static' for a static response", upstream.ID, *upstream.StaticCode))
 	}
+	if !upstream.Static && (upstream.StaticBody != "" || upstream.StaticBodyFile != "") {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has staticBody or staticBodyFile, but is not a static upstream, set 'static' for a static response", upstream.ID))
+	}
+	if upstream.StaticBody != "" && upstream.StaticBodyFile != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has staticBody and staticBodyFile: only one of these may be set", upstream.ID))
+	}
+	if upstream.StaticContentType != "" && upstream.StaticBody == "" && upstream.StaticBodyFile == "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has staticContentType, but no staticBody or staticBodyFile, this will have no effect.", upstream.ID))
+	}
 
 	// Checks after this only make sense when the upstream is static
 	if !upstream.Static {
@@ -66,6 +359,9 @@ func validateStaticUpstream(upstream options.Upstream) []string {
 	if upstream.URI != "" {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has uri, but is a static upstream, this will have no effect.", upstream.ID))
 	}
+	if len(upstream.URIs) > 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has uris, but is a static upstream, this will have no effect.", upstream.ID))
+	}
 	if upstream.InsecureSkipTLSVerify {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has insecureSkipTLSVerify, but is a static upstream, this will have no effect.", upstream.ID))
 	}
@@ -85,6 +381,14 @@ func validateStaticUpstream(upstream options.Upstream) []string {
 func validateUpstreamURI(upstream options.Upstream) []string {
 	msgs := []string{}
 
+	if upstream.URI != "" && len(upstream.URIs) > 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has uri and uris: only one of these may be set", upstream.ID))
+		return msgs
+	}
+	if len(upstream.URIs) > 0 {
+		return msgs
+	}
+
 	if !upstream.Static && upstream.URI == "" {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has empty uri: uris are required for all non-static upstreams", upstream.ID))
 		return msgs
@@ -110,3 +414,60 @@ func validateUpstreamURI(upstream options.Upstream) []string {
 
 	return msgs
 }
+
+// validateUpstreamLoadBalancing checks that the URIs to balance requests
+// across are HTTP(S) URIs of the same scheme with weights that are not
+// negative, and that the load balancing options are valid and only set for
+// upstreams with URIs
+func validateUpstreamLoadBalancing(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	if len(upstream.URIs) == 0 {
+		if upstream.LoadBalancing != nil {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has loadBalancing, but no uris, this will have no effect.", upstream.ID))
+		}
+		return msgs
+	}
+
+	// Checks after this only make sense the upstream is not static
+	if upstream.Static {
+		return msgs
+	}
+
+	var scheme string
+	for _, uri := range upstream.URIs {
+		u, err := url.Parse(uri.URI)
+		switch {
+		case err != nil:
+			msgs = append(msgs, fmt.Sprintf("upstream %q has invalid uri %q: %v", upstream.ID, uri.URI, err))
+		case u.Scheme != "http" && u.Scheme != "https":
+			msgs = append(msgs, fmt.Sprintf("upstream %q has uri %q with invalid scheme %q: uris must be http or https", upstream.ID, uri.URI, u.Scheme))
+		case scheme != "" && u.Scheme != scheme:
+			msgs = append(msgs, fmt.Sprintf("upstream %q has uri %q with scheme %q: all uris must have the same scheme", upstream.ID, uri.URI, u.Scheme))
+		case scheme == "":
+			scheme = u.Scheme
+		}
+		if uri.Weight < 0 {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has uri %q with weight %d: weights must not be negative", upstream.ID, uri.URI, uri.Weight))
+		}
+	}
+
+	lb := upstream.LoadBalancing
+	if lb == nil {
+		return msgs
+	}
+	switch lb.Strategy {
+	case "", options.UpstreamLoadBalancingRoundRobin, options.UpstreamLoadBalancingRandom:
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstream %q has loadBalancing with unknown strategy %q: must be one of %q or %q",
+			upstream.ID, lb.Strategy, options.UpstreamLoadBalancingRoundRobin, options.UpstreamLoadBalancingRandom))
+	}
+	if lb.MaxFailures != nil && *lb.MaxFailures < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has loadBalancing maxFailures %d: maxFailures must not be negative", upstream.ID, *lb.MaxFailures))
+	}
+	if lb.FailureCooldown != nil && lb.FailureCooldown.Duration() < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has loadBalancing failureCooldown %s: failureCooldown must not be negative", upstream.ID, lb.FailureCooldown.Duration()))
+	}
+
+	return msgs
+}
@@ -18,6 +18,9 @@ var _ = Describe("Upstreams", func() {
 	flushInterval := options.Duration(5 * time.Second)
 	staticCode200 := 200
 	truth := true
+	zero := 0
+	negative := -1
+	negativeDuration := options.Duration(-time.Second)
 
 	validHTTPUpstream := options.Upstream{
 		ID:   "validHTTPUpstream",
@@ -48,6 +51,61 @@ var _ = Describe("Upstreams", func() {
 	multipleIDsMsg := "multiple upstreams found with id \"foo\": upstream ids must be unique"
 	multiplePathsMsg := "multiple upstreams found with path \"/foo\": upstream paths must be unique"
 	staticCodeMsg := "upstream \"foo\" has staticCode (200), but is not a static upstream, set 'static' for a static response"
+	staticBodyMsg := "upstream \"foo\" has staticBody or staticBodyFile, but is not a static upstream, set 'static' for a static response"
+	staticBodyAndFileMsg := "upstream \"foo\" has staticBody and staticBodyFile: only one of these may be set"
+	staticContentTypeMsg := "upstream \"foo\" has staticContentType, but no staticBody or staticBodyFile, this will have no effect."
+	staticWithHTTP2Msg := "upstream \"foo\" has http2, but is a static upstream, this will have no effect."
+	fileWithHTTP2Msg := "upstream \"foo\" has http2, but is a file upstream, this will have no effect."
+	negativeRetriesMsg := "upstream \"foo\" has retries -1: retries must not be negative"
+	negativeRetryBackoffMsg := "upstream \"foo\" has retryBackoff -1s: retryBackoff must not be negative"
+	retryBackoffWithoutRetriesMsg := "upstream \"foo\" has retryBackoff, but no retries, this will have no effect."
+	staticWithRetriesMsg := "upstream \"foo\" has retries, but is a static upstream, this will have no effect."
+	fileWithRetriesMsg := "upstream \"foo\" has retries, but is a file upstream, this will have no effect."
+	tokenExchangeAudienceMsg := "upstream \"foo\" has tokenExchange with empty audience and resource: an audience or resource is required for token exchange"
+	tokenExchangeResourceMsg := "upstream \"foo\" has tokenExchange with invalid resource \"/api\": the resource must be an absolute URI without a fragment"
+	tokenExchangeGrantMsg := "upstream \"foo\" has tokenExchange with unknown grant \"password\": must be one of \"tokenExchange\" or \"refreshToken\""
+	staticWithTokenExchangeMsg := "upstream \"foo\" has tokenExchange, but is a static upstream, this will have no effect."
+	fileWithTokenExchangeMsg := "upstream \"foo\" has tokenExchange, but is a file upstream, this will have no effect."
+	stripPrefixWithRewriteMsg := "upstream \"foo\" has stripPrefix and rewriteTarget: only one of these may be set"
+	staticWithStripPrefixMsg := "upstream \"foo\" has stripPrefix, but is a static upstream, this will have no effect."
+	fileWithStripPrefixMsg := "upstream \"foo\" has stripPrefix, but is a file upstream, this will have no effect."
+	certWithoutKeyMsg := "upstream \"foo\" has tlsClientCertFile without tlsClientKeyFile: both are required for client certificates"
+	keyWithoutCertMsg := "upstream \"foo\" has tlsClientKeyFile without tlsClientCertFile: both are required for client certificates"
+	staticWithTLSMsg := "upstream \"foo\" has TLS client options, but is a static upstream, this will have no effect."
+	fileWithTLSMsg := "upstream \"foo\" has TLS client options, but is a file upstream, this will have no effect."
+	negativeMaxIdleConnsMsg := "upstream transport maxIdleConns -1 must not be negative"
+	negativeMaxIdleConnsPerHostMsg := "upstream transport maxIdleConnsPerHost -1 must not be negative"
+	negativeMaxConnsPerHostMsg := "upstream transport maxConnsPerHost -1 must not be negative"
+	emptyACRValueMsg := "upstream \"foo\" has invalid acr value \"\": acr values must not be empty or contain whitespace"
+	spaceACRValueMsg := "upstream \"foo\" has invalid acr value \"mfa otp\": acr values must not be empty or contain whitespace"
+	negativeMaxAgeMsg := "upstream \"foo\" has maxAge -1m0s: maxAge must not be negative"
+	emptyAllowedMethodMsg := "upstream \"foo\" has invalid allowed method \"\": methods must not be empty or contain whitespace or commas"
+	pathWebSocketOriginMsg := "upstream \"foo\" has invalid allowed WebSocket origin \"https://app.example.com/\": origins must be a scheme and host, eg https://app.example.com"
+	hostWebSocketOriginMsg := "upstream \"foo\" has invalid allowed WebSocket origin \"app.example.com\": origins must be a scheme and host, eg https://app.example.com"
+	staticWithWebSocketOriginsMsg := "upstream \"foo\" has allowedWebSocketOrigins, but is a static upstream, this will have no effect."
+	listAllowedMethodMsg := "upstream \"foo\" has invalid allowed method \"GET,POST\": methods must not be empty or contain whitespace or commas"
+	invalidStatusActionStatusMsg := "upstream \"foo\" has status action with invalid status 0: status must be between 100 and 599"
+	duplicateStatusActionMsg := "upstream \"foo\" has multiple status actions for status 401"
+	invalidStatusActionMsg := "upstream \"foo\" has invalid action \"redirect\" for status 401: action must be one of \"passThrough\", \"reauthenticate\" or \"errorPage\""
+	staticWithStatusActionsMsg := "upstream \"foo\" has statusActions, but is a static upstream, this will have no effect."
+	negativeIdleConnTimeoutMsg := "upstream transport idleConnTimeout -1s must not be negative"
+	zeroMaxResponseHeaderBytesMsg := "upstream transport maxResponseHeaderBytes 0 must be greater than 0"
+	uriAndURIsMsg := "upstream \"foo\" has uri and uris: only one of these may be set"
+	staticWithURIsMsg := "upstream \"foo\" has uris, but is a static upstream, this will have no effect."
+	loadBalancingWithoutURIsMsg := "upstream \"foo\" has loadBalancing, but no uris, this will have no effect."
+	fileURIsMsg := "upstream \"foo\" has uri \"file:///var/www\" with invalid scheme \"file\": uris must be http or https"
+	mixedSchemeURIsMsg := "upstream \"foo\" has uri \"https://10.0.0.2:8443\" with scheme \"https\": all uris must have the same scheme"
+	negativeWeightMsg := "upstream \"foo\" has uri \"http://10.0.0.2:8080\" with weight -1: weights must not be negative"
+	unknownStrategyMsg := "upstream \"foo\" has loadBalancing with unknown strategy \"leastConn\": must be one of \"roundRobin\" or \"random\""
+	negativeMaxFailuresMsg := "upstream \"foo\" has loadBalancing maxFailures -1: maxFailures must not be negative"
+	negativeFailureCooldownMsg := "upstream \"foo\" has loadBalancing failureCooldown -1s: failureCooldown must not be negative"
+	maxResponseHeaderBytes := int64(32 << 10)
+	zeroMaxResponseHeaderBytes := int64(0)
+
+	maxAge := options.Duration(5 * time.Minute)
+	negativeMaxAge := options.Duration(-time.Minute)
+	retryBackoff := options.Duration(50 * time.Millisecond)
+	failureCooldown := options.Duration(time.Minute)
 
 	DescribeTable("validateUpstreams",
 		func(o *validateUpstreamTableInput) {
@@ -208,5 +266,648 @@ var _ = Describe("Upstreams", func() {
 			},
 			errStrings: []string{emptyURIMsg, staticCodeMsg},
 		}),
+		Entry("with a static body", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                "foo",
+						Path:              "/foo",
+						Static:            true,
+						StaticBody:        "{{.Email}}",
+						StaticContentType: "text/html",
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("when a static body is supplied without static", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:         "foo",
+						Path:       "/foo",
+						URI:        "http://localhost:8080",
+						StaticBody: "body",
+					},
+				},
+			},
+			errStrings: []string{staticBodyMsg},
+		}),
+		Entry("with a static body and a static body file", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:             "foo",
+						Path:           "/foo",
+						Static:         true,
+						StaticBody:     "body",
+						StaticBodyFile: "/path/to/body",
+					},
+				},
+			},
+			errStrings: []string{staticBodyAndFileMsg},
+		}),
+		Entry("with a static content type without a static body", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                "foo",
+						Path:              "/foo",
+						Static:            true,
+						StaticContentType: "application/json",
+					},
+				},
+			},
+			errStrings: []string{staticContentTypeMsg},
+		}),
+		Entry("with http2 on an http upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:    "foo",
+						Path:  "/foo",
+						URI:   "http://localhost:50051",
+						HTTP2: true,
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with http2 on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:     "foo",
+						Path:   "/foo",
+						Static: true,
+						HTTP2:  true,
+					},
+				},
+			},
+			errStrings: []string{staticWithHTTP2Msg},
+		}),
+		Entry("with http2 on a file upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:    "foo",
+						Path:  "/foo",
+						URI:   "file:///var/www",
+						HTTP2: true,
+					},
+				},
+			},
+			errStrings: []string{fileWithHTTP2Msg},
+		}),
+		Entry("with retries", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:           "foo",
+						Path:         "/foo",
+						URI:          "http://localhost:8080",
+						Retries:      2,
+						RetryBackoff: &retryBackoff,
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with negative retries and retry backoff", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:           "foo",
+						Path:         "/foo",
+						URI:          "http://localhost:8080",
+						Retries:      -1,
+						RetryBackoff: &negativeDuration,
+					},
+				},
+			},
+			errStrings: []string{negativeRetriesMsg, negativeRetryBackoffMsg},
+		}),
+		Entry("with a retry backoff without retries", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:           "foo",
+						Path:         "/foo",
+						URI:          "http://localhost:8080",
+						RetryBackoff: &retryBackoff,
+					},
+				},
+			},
+			errStrings: []string{retryBackoffWithoutRetriesMsg},
+		}),
+		Entry("with retries on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:      "foo",
+						Path:    "/foo",
+						Static:  true,
+						Retries: 1,
+					},
+				},
+			},
+			errStrings: []string{staticWithRetriesMsg},
+		}),
+		Entry("with retries on a file upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:      "foo",
+						Path:    "/foo",
+						URI:     "file:///var/www",
+						Retries: 1,
+					},
+				},
+			},
+			errStrings: []string{fileWithRetriesMsg},
+		}),
+		Entry("with load balanced uris", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URIs: []options.UpstreamURI{
+							{URI: "http://10.0.0.1:8080", Weight: 2},
+							{URI: "http://10.0.0.2:8080"},
+						},
+						LoadBalancing: &options.UpstreamLoadBalancing{
+							Strategy:        options.UpstreamLoadBalancingRandom,
+							MaxFailures:     &zero,
+							FailureCooldown: &failureCooldown,
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a uri and uris", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						URIs: []options.UpstreamURI{{URI: "http://10.0.0.1:8080"}},
+					},
+				},
+			},
+			errStrings: []string{uriAndURIsMsg},
+		}),
+		Entry("with invalid uris and load balancing", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URIs: []options.UpstreamURI{
+							{URI: "http://10.0.0.1:8080"},
+							{URI: "https://10.0.0.2:8443"},
+							{URI: "http://10.0.0.2:8080", Weight: -1},
+							{URI: "file:///var/www"},
+						},
+						LoadBalancing: &options.UpstreamLoadBalancing{
+							Strategy:        "leastConn",
+							MaxFailures:     &negative,
+							FailureCooldown: &negativeDuration,
+						},
+					},
+				},
+			},
+			errStrings: []string{mixedSchemeURIsMsg, negativeWeightMsg, fileURIsMsg, unknownStrategyMsg, negativeMaxFailuresMsg, negativeFailureCooldownMsg},
+		}),
+		Entry("with load balancing without uris", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:            "foo",
+						Path:          "/foo",
+						URI:           "http://localhost:8080",
+						LoadBalancing: &options.UpstreamLoadBalancing{},
+					},
+				},
+			},
+			errStrings: []string{loadBalancingWithoutURIsMsg},
+		}),
+		Entry("with uris on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:     "foo",
+						Path:   "/foo",
+						Static: true,
+						URIs:   []options.UpstreamURI{{URI: "http://10.0.0.1:8080"}},
+					},
+				},
+			},
+			errStrings: []string{staticWithURIsMsg},
+		}),
+		Entry("with a valid token exchange", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						TokenExchange: &options.TokenExchange{
+							Audience: "downstream",
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a token exchange without an audience", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:            "foo",
+						Path:          "/foo",
+						URI:           "http://localhost:8080",
+						TokenExchange: &options.TokenExchange{},
+					},
+				},
+			},
+			errStrings: []string{tokenExchangeAudienceMsg},
+		}),
+		Entry("with a token exchange for a resource and scopes", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						TokenExchange: &options.TokenExchange{
+							Resource: "https://api.example.com",
+							Scopes:   []string{"read"},
+							Grant:    options.TokenExchangeGrantRefreshToken,
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a token exchange for a relative resource", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						TokenExchange: &options.TokenExchange{
+							Resource: "/api",
+						},
+					},
+				},
+			},
+			errStrings: []string{tokenExchangeResourceMsg},
+		}),
+		Entry("with a token exchange with an unknown grant", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						TokenExchange: &options.TokenExchange{
+							Audience: "downstream",
+							Grant:    "password",
+						},
+					},
+				},
+			},
+			errStrings: []string{tokenExchangeGrantMsg},
+		}),
+		Entry("with step-up authentication", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:        "foo",
+						Path:      "/foo",
+						URI:       "http://localhost:8080",
+						ACRValues: []string{"urn:example:mfa", "phr"},
+						MaxAge:    &maxAge,
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid step-up authentication", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:        "foo",
+						Path:      "/foo",
+						URI:       "http://localhost:8080",
+						ACRValues: []string{"", "mfa otp"},
+						MaxAge:    &negativeMaxAge,
+					},
+				},
+			},
+			errStrings: []string{emptyACRValueMsg, spaceACRValueMsg, negativeMaxAgeMsg},
+		}),
+		Entry("with allowed methods", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:             "foo",
+						Path:           "/foo",
+						URI:            "http://localhost:8080",
+						AllowedMethods: []string{"GET", "POST"},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid allowed methods", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:             "foo",
+						Path:           "/foo",
+						URI:            "http://localhost:8080",
+						AllowedMethods: []string{"", "GET,POST"},
+					},
+				},
+			},
+			errStrings: []string{emptyAllowedMethodMsg, listAllowedMethodMsg},
+		}),
+		Entry("with allowed WebSocket origins", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                      "foo",
+						Path:                    "/foo",
+						URI:                     "http://localhost:8080",
+						AllowedWebSocketOrigins: []string{"https://app.example.com", "http://localhost:3000"},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid allowed WebSocket origins", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                      "foo",
+						Path:                    "/foo",
+						URI:                     "http://localhost:8080",
+						AllowedWebSocketOrigins: []string{"https://app.example.com/", "app.example.com"},
+					},
+				},
+			},
+			errStrings: []string{pathWebSocketOriginMsg, hostWebSocketOriginMsg},
+		}),
+		Entry("with allowed WebSocket origins for a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                      "foo",
+						Path:                    "/foo",
+						Static:                  true,
+						AllowedWebSocketOrigins: []string{"https://app.example.com"},
+					},
+				},
+			},
+			errStrings: []string{staticWithWebSocketOriginsMsg},
+		}),
+		Entry("with status actions", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						StatusActions: []options.UpstreamStatusAction{
+							{Status: 401, Action: options.UpstreamStatusActionReauthenticate},
+							{Status: 403, Action: options.UpstreamStatusActionErrorPage},
+							{Status: 404, Action: options.UpstreamStatusActionPassThrough},
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid status actions", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "http://localhost:8080",
+						StatusActions: []options.UpstreamStatusAction{
+							{Action: options.UpstreamStatusActionErrorPage},
+							{Status: 401, Action: options.UpstreamStatusActionReauthenticate},
+							{Status: 401, Action: "redirect"},
+						},
+					},
+				},
+			},
+			errStrings: []string{invalidStatusActionStatusMsg, duplicateStatusActionMsg, invalidStatusActionMsg},
+		}),
+		Entry("with status actions on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:     "foo",
+						Path:   "/foo",
+						Static: true,
+						StatusActions: []options.UpstreamStatusAction{
+							{Status: 401, Action: options.UpstreamStatusActionReauthenticate},
+						},
+					},
+				},
+			},
+			errStrings: []string{staticWithStatusActionsMsg},
+		}),
+		Entry("with a token exchange on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:     "foo",
+						Path:   "/foo",
+						Static: true,
+						TokenExchange: &options.TokenExchange{
+							Audience: "downstream",
+						},
+					},
+				},
+			},
+			errStrings: []string{staticWithTokenExchangeMsg},
+		}),
+		Entry("with a token exchange on a file upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:   "foo",
+						Path: "/foo",
+						URI:  "file:///tmp",
+						TokenExchange: &options.TokenExchange{
+							Audience: "downstream",
+						},
+					},
+				},
+			},
+			errStrings: []string{fileWithTokenExchangeMsg},
+		}),
+		Entry("with a valid strip prefix", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:          "foo",
+						Path:        "/foo/",
+						URI:         "http://localhost:8080",
+						StripPrefix: true,
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a strip prefix and a rewrite target", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:            "foo",
+						Path:          "^/foo/(.*)",
+						RewriteTarget: "/$1",
+						URI:           "http://localhost:8080",
+						StripPrefix:   true,
+					},
+				},
+			},
+			errStrings: []string{stripPrefixWithRewriteMsg},
+		}),
+		Entry("with a strip prefix on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:          "foo",
+						Path:        "/foo/",
+						Static:      true,
+						StripPrefix: true,
+					},
+				},
+			},
+			errStrings: []string{staticWithStripPrefixMsg},
+		}),
+		Entry("with a strip prefix on a file upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:          "foo",
+						Path:        "/foo/",
+						URI:         "file:///tmp",
+						StripPrefix: true,
+					},
+				},
+			},
+			errStrings: []string{fileWithStripPrefixMsg},
+		}),
+		Entry("with valid TLS client options", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                "foo",
+						Path:              "/foo",
+						URI:               "https://localhost:8443",
+						TLSClientCertFile: "client.crt",
+						TLSClientKeyFile:  "client.key",
+						TLSCAFile:         "ca.crt",
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a TLS client certificate without a key", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:                "foo",
+						Path:              "/foo",
+						URI:               "https://localhost:8443",
+						TLSClientCertFile: "client.crt",
+					},
+				},
+			},
+			errStrings: []string{certWithoutKeyMsg},
+		}),
+		Entry("with a TLS client key without a certificate", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:               "foo",
+						Path:             "/foo",
+						URI:              "https://localhost:8443",
+						TLSClientKeyFile: "client.key",
+					},
+				},
+			},
+			errStrings: []string{keyWithoutCertMsg},
+		}),
+		Entry("with TLS client options on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:        "foo",
+						Path:      "/foo",
+						Static:    true,
+						TLSCAFile: "ca.crt",
+					},
+				},
+			},
+			errStrings: []string{staticWithTLSMsg},
+		}),
+		Entry("with TLS client options on a file upstream", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{
+					{
+						ID:        "foo",
+						Path:      "/foo",
+						URI:       "file:///tmp",
+						TLSCAFile: "ca.crt",
+					},
+				},
+			},
+			errStrings: []string{fileWithTLSMsg},
+		}),
+		Entry("with transport options", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{validHTTPUpstream},
+				Transport: options.UpstreamTransport{
+					MaxIdleConns:        &zero,
+					MaxIdleConnsPerHost: &zero,
+					MaxConnsPerHost:     &zero,
+					IdleConnTimeout:     &flushInterval,
+
+					MaxResponseHeaderBytes: &maxResponseHeaderBytes,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with negative transport options", &validateUpstreamTableInput{
+			upstreams: options.UpstreamConfig{
+				Upstreams: []options.Upstream{validHTTPUpstream},
+				Transport: options.UpstreamTransport{
+					MaxIdleConns:        &negative,
+					MaxIdleConnsPerHost: &negative,
+					MaxConnsPerHost:     &negative,
+					IdleConnTimeout:     &negativeDuration,
+
+					MaxResponseHeaderBytes: &zeroMaxResponseHeaderBytes,
+				},
+			},
+			errStrings: []string{
+				negativeMaxIdleConnsMsg,
+				negativeMaxIdleConnsPerHostMsg,
+				negativeMaxConnsPerHostMsg,
+				negativeIdleConnTimeoutMsg,
+				zeroMaxResponseHeaderBytesMsg,
+			},
+		}),
 	)
 })
@@ -61,17 +61,20 @@ func NewADFSProvider(p *ProviderData, opts options.ADFSOptions) *ADFSProvider {
 
 // GetLoginURL Override to double encode the state parameter. If not query params are lost
 // More info here: https://docs.microsoft.com/en-us/powerapps/maker/portals/configure/configure-saml2-settings
-func (p *ADFSProvider) GetLoginURL(redirectURI, state, nonce string, extraParams url.Values) string {
+func (p *ADFSProvider) GetLoginURL(redirectURI, state, nonce string, extraParams url.Values) (string, error) {
 	if !p.SkipNonce {
 		extraParams.Add("nonce", nonce)
 	}
-	loginURL := makeLoginURL(p.Data(), redirectURI, url.QueryEscape(state), extraParams)
+	loginURL, err := makeLoginURL(p.Data(), redirectURI, url.QueryEscape(state), extraParams)
+	if err != nil {
+		return "", err
+	}
 	if p.skipScope {
 		q := loginURL.Query()
 		q.Del("scope")
 		loginURL.RawQuery = q.Encode()
 	}
-	return loginURL.String()
+	return loginURL.String(), nil
 }
 
 // EnrichSession calls the OIDC ProfileURL to backfill any fields missing
@@ -168,7 +168,8 @@ var _ = Describe("ADFS Provider Tests", func() {
 				Scope:             "",
 			}, options.ADFSOptions{SkipScope: true})
 
-			result := p.GetLoginURL("https://example.com/adfs/oauth2/", "", "", url.Values{})
+			result, err := p.GetLoginURL("https://example.com/adfs/oauth2/", "", "", url.Values{})
+			Expect(err).ToNot(HaveOccurred())
 			Expect(result).NotTo(ContainSubstring("scope="))
 		})
 	})
@@ -189,7 +190,8 @@ var _ = Describe("ADFS Provider Tests", func() {
 				}, options.ADFSOptions{})
 
 				Expect(p.Data().Scope).To(Equal(in.expectedScope))
-				result := p.GetLoginURL("https://example.com/adfs/oauth2/", "", "", url.Values{})
+				result, err := p.GetLoginURL("https://example.com/adfs/oauth2/", "", "", url.Values{})
+				Expect(err).ToNot(HaveOccurred())
 				Expect(result).To(ContainSubstring("scope=" + url.QueryEscape(in.expectedScope)))
 			},
 			Entry("should add slash", scopeTableInput{
@@ -24,6 +24,7 @@ type AzureProvider struct {
 	*ProviderData
 	Tenant          string
 	GraphGroupField string
+	GraphURL        *url.URL
 	isV2Endpoint    bool
 }
 
@@ -33,7 +34,11 @@ const (
 	azureProviderName           = "Azure"
 	azureDefaultScope           = "openid"
 	azureDefaultGraphGroupField = "id"
-	azureV2Scope                = "https://graph.microsoft.com/.default"
+	azureV2ScopeSuffix          = "/.default"
+
+	// azureGroupsOverageClaim is set instead of the groups claim when the
+	// user is a member of too many groups to include them in the token
+	azureGroupsOverageClaim = "_claim_names.groups"
 )
 
 var (
@@ -57,15 +62,31 @@ var (
 		Host:   "graph.microsoft.com",
 		Path:   "/v1.0/me",
 	}
+
+	// Default Microsoft Graph URL for Azure. Pre-parsed URL of https://graph.microsoft.com.
+	azureDefaultGraphURL = &url.URL{
+		Scheme: "https",
+		Host:   "graph.microsoft.com",
+	}
 )
 
 // NewAzureProvider initiates a new AzureProvider
 func NewAzureProvider(p *ProviderData, opts options.AzureOptions) *AzureProvider {
+	graphURL := azureDefaultGraphURL
+	if opts.GraphURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(opts.GraphURL, "/"))
+		if err != nil {
+			logger.Errorf("WARNING: invalid Azure Graph URL %q, using the default: %v", opts.GraphURL, err)
+		} else {
+			graphURL = u
+		}
+	}
+
 	p.setProviderDefaults(providerDefaults{
 		name:        azureProviderName,
 		loginURL:    azureDefaultLoginURL,
 		redeemURL:   azureDefaultRedeemURL,
-		profileURL:  azureDefaultProfileURL,
+		profileURL:  graphURL.ResolveReference(&url.URL{Path: azureDefaultProfileURL.Path}),
 		validateURL: nil,
 		scope:       azureDefaultScope,
 	})
@@ -96,9 +117,9 @@ func NewAzureProvider(p *ProviderData, opts options.AzureOptions) *AzureProvider
 			p.Scope = strings.ReplaceAll(p.Scope, " groups", "")
 		}
 
-		if !strings.Contains(p.Scope, " "+azureV2Scope) {
+		if v2Scope := graphURL.String() + azureV2ScopeSuffix; !strings.Contains(p.Scope, " "+v2Scope) {
 			// In order to be able to query MS Graph we must pass the ms graph default endpoint
-			p.Scope += " " + azureV2Scope
+			p.Scope += " " + v2Scope
 		}
 
 		if p.ProtectedResource != nil && p.ProtectedResource.String() != "" {
@@ -110,6 +131,7 @@ func NewAzureProvider(p *ProviderData, opts options.AzureOptions) *AzureProvider
 		ProviderData:    p,
 		Tenant:          tenant,
 		GraphGroupField: graphGroupField,
+		GraphURL:        graphURL,
 		isV2Endpoint:    isV2Endpoint,
 	}
 }
@@ -123,7 +145,7 @@ func overrideTenantURL(current, defaultURL *url.URL, tenant, path string) {
 	}
 }
 
-func getMicrosoftGraphGroupsURL(graphGroupField string) *url.URL {
+func getMicrosoftGraphGroupsURL(graphURL *url.URL, graphGroupField string) *url.URL {
 
 	selectStatement := "$select=displayName,id"
 	if !slices.Contains([]string{"displayName", "id"}, graphGroupField) {
@@ -131,22 +153,23 @@ func getMicrosoftGraphGroupsURL(graphGroupField string) *url.URL {
 	}
 
 	// Select only security groups. Due to the filter option, count param is mandatory even if unused otherwise
-	return &url.URL{
-		Scheme:   "https",
-		Host:     "graph.microsoft.com",
+	return graphURL.ResolveReference(&url.URL{
 		Path:     "/v1.0/me/transitiveMemberOf",
 		RawQuery: "$count=true&$filter=securityEnabled+eq+true&" + selectStatement,
-	}
+	})
 }
 
-func (p *AzureProvider) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) string {
+func (p *AzureProvider) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) (string, error) {
 	// In azure oauth v2 there is no resource param so add it only if V1 endpoint
 	// https://docs.microsoft.com/en-us/azure/active-directory/azuread-dev/azure-ad-endpoint-comparison#scopes-not-resources
 	if p.ProtectedResource != nil && p.ProtectedResource.String() != "" && !p.isV2Endpoint {
 		extraParams.Add("resource", p.ProtectedResource.String())
 	}
-	a := makeLoginURL(p.ProviderData, redirectURI, state, extraParams)
-	return a.String()
+	a, err := makeLoginURL(p.ProviderData, redirectURI, state, extraParams)
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
@@ -166,6 +189,7 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code, codeVerif
 
 	err = requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -208,8 +232,11 @@ func (p *AzureProvider) EnrichSession(ctx context.Context, session *sessions.Ses
 		session.Email = email
 	}
 
-	// If using the v2.0 oidc endpoint we're also querying Microsoft Graph
-	if p.isV2Endpoint {
+	// If using the v2.0 oidc endpoint we're also querying Microsoft Graph.
+	// Otherwise Graph is only queried when the token omitted the groups
+	// because the user is a member of too many groups. The groups are then
+	// kept in the session, as refreshed tokens will also omit them.
+	if p.isV2Endpoint || (len(session.Groups) == 0 && p.hasGroupsOverage(session)) {
 		groups, err := p.getGroupsFromProfileAPI(ctx, session)
 		if err != nil {
 			return fmt.Errorf("unable to get groups from Microsoft Graph: %v", err)
@@ -279,6 +306,21 @@ func (p *AzureProvider) extractClaimsIntoSession(ctx context.Context, session *s
 	return nil
 }
 
+// hasGroupsOverage checks whether the session tokens contain the groups
+// overage indicator (`_claim_names`) instead of the groups claim
+func (p *AzureProvider) hasGroupsOverage(session *sessions.SessionState) bool {
+	for _, token := range []string{session.IDToken, session.AccessToken} {
+		extractor, err := p.getClaimExtractor(token, "")
+		if err != nil {
+			continue
+		}
+		if _, exists, err := extractor.GetClaim(azureGroupsOverageClaim); err == nil && exists {
+			return true
+		}
+	}
+	return false
+}
+
 // verifySessionToken tries to validate id_token if present or access token when oidc verifier is configured
 func (p *AzureProvider) verifySessionToken(ctx context.Context, session *sessions.SessionState) error {
 	// Without a verifier there's no way to verify
@@ -307,7 +349,7 @@ func (p *AzureProvider) RefreshSession(ctx context.Context, s *sessions.SessionS
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	return true, nil
@@ -334,6 +376,7 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 
 	err = requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -368,7 +411,7 @@ func (p *AzureProvider) getGroupsFromProfileAPI(ctx context.Context, s *sessions
 		return nil, fmt.Errorf("missing access token")
 	}
 
-	groupsURL := getMicrosoftGraphGroupsURL(p.GraphGroupField).String()
+	groupsURL := getMicrosoftGraphGroupsURL(p.GraphURL, p.GraphGroupField).String()
 
 	// Need and extra header while talking with MS Graph. For more context see
 	// https://docs.microsoft.com/en-us/graph/api/group-list-transitivememberof?view=graph-rest-1.0&tabs=http#request-headers
@@ -2,6 +2,8 @@ package providers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -141,6 +143,89 @@ func TestAzureSetTenant(t *testing.T) {
 	assert.Equal(t, "openid", p.Data().Scope)
 }
 
+func TestAzureProviderGraphURL(t *testing.T) {
+	g := NewWithT(t)
+
+	p := NewAzureProvider(&ProviderData{
+		LoginURL: &url.URL{
+			Scheme: "https",
+			Host:   "login.microsoftonline.us",
+			Path:   "/example/v2.0/oauth2/authorize"},
+	}, options.AzureOptions{GraphURL: "https://graph.microsoft.us/"})
+	g.Expect(p.Data().ProfileURL.String()).To(Equal("https://graph.microsoft.us/v1.0/me"))
+	g.Expect(p.Data().ValidateURL.String()).To(Equal("https://graph.microsoft.us/v1.0/me"))
+	g.Expect(p.Data().Scope).To(Equal("openid https://graph.microsoft.us/.default"))
+	g.Expect(getMicrosoftGraphGroupsURL(p.GraphURL, p.GraphGroupField).String()).To(
+		Equal("https://graph.microsoft.us/v1.0/me/transitiveMemberOf?$count=true&$filter=securityEnabled+eq+true&$select=displayName,id"))
+}
+
+func newAzureOverageToken(t *testing.T, email string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud":          "cd6d4fae-f6a6-4a34-8454-2c6b598e9532",
+		"email":        email,
+		"_claim_names": map[string]string{"groups": "src1"},
+		"_claim_sources": map[string]interface{}{
+			"src1": map[string]string{"endpoint": "https://graph.windows.net/tenant/users/user/getMemberObjects"},
+		},
+	}).SignedString(key)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestAzureProviderGroupsOverage(t *testing.T) {
+	var graphCalls int
+	b := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1.0/me/transitiveMemberOf" && IsAuthorizedInHeaderWithToken(r.Header, "access_token"):
+			graphCalls++
+			_, _ = rw.Write([]byte(`{"value":[{"displayName":"aa","id":"group-1"},{"displayName":"bb","id":"group-2"}]}`))
+		case r.URL.Path == "/v1.0/me":
+			_, _ = rw.Write([]byte(`{"mail":"foo@example.com"}`))
+		case r.Method == http.MethodPost:
+			payload, _ := json.Marshal(azureOAuthPayload{
+				AccessToken:  "new_access_token",
+				RefreshToken: "new_refresh_token",
+				IDToken:      newAzureOverageToken(t, "foo@example.com"),
+				ExpiresOn:    time.Now().Add(time.Hour).Unix(),
+			})
+			_, _ = rw.Write(payload)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer b.Close()
+	bURL, _ := url.Parse(b.URL)
+
+	p := testAzureProvider(bURL.Host, options.AzureOptions{GraphURL: b.URL})
+
+	withoutOverage, err := newSignedTestIDToken(idTokenClaims{
+		StandardClaims: jwt.StandardClaims{Audience: "cd6d4fae-f6a6-4a34-8454-2c6b598e9532"},
+		Email:          "foo@example.com",
+		Groups:         []string{"claim-group"},
+	})
+	assert.NoError(t, err)
+	session := &sessions.SessionState{AccessToken: "access_token", IDToken: withoutOverage}
+	assert.NoError(t, p.EnrichSession(context.Background(), session))
+	assert.Equal(t, []string{"claim-group"}, session.Groups)
+	assert.Equal(t, 0, graphCalls)
+
+	session = &sessions.SessionState{AccessToken: "access_token", IDToken: newAzureOverageToken(t, "foo@example.com"), RefreshToken: "refresh_token"}
+	assert.NoError(t, p.EnrichSession(context.Background(), session))
+	assert.Equal(t, "foo@example.com", session.Email)
+	assert.Equal(t, []string{"group-1", "group-2"}, session.Groups)
+	assert.Equal(t, 1, graphCalls)
+
+	// Refreshed tokens also omit the groups, the groups from Graph are kept
+	refreshed, err := p.RefreshSession(context.Background(), session)
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+	assert.Equal(t, "new_access_token", session.AccessToken)
+	assert.Equal(t, []string{"group-1", "group-2"}, session.Groups)
+	assert.Equal(t, 1, graphCalls)
+}
+
 func testAzureBackend(payload string, accessToken, refreshToken string) *httptest.Server {
 	return testAzureBackendWithError(payload, accessToken, refreshToken, false)
 }
@@ -370,7 +455,8 @@ func TestAzureProviderRedeem(t *testing.T) {
 func TestAzureProviderProtectedResourceConfiguredOAuthV1(t *testing.T) {
 	p := testAzureProvider("", options.AzureOptions{})
 	p.ProtectedResource, _ = url.Parse("http://my.resource.test")
-	result := p.GetLoginURL("https://my.test.app/oauth", "", "", url.Values{})
+	result, err := p.GetLoginURL("https://my.test.app/oauth", "", "", url.Values{})
+	assert.NoError(t, err)
 	assert.Contains(t, result, "resource="+url.QueryEscape("http://my.resource.test"))
 }
 
@@ -379,7 +465,9 @@ func TestAzureProviderProtectedResourceConfiguredOAuthV2(t *testing.T) {
 	testURL := "http://my.resource.test"
 	p.ProtectedResource, _ = url.Parse(testURL)
 	p.isV2Endpoint = true
-	result, _ := url.Parse(p.GetLoginURL("https://my.test.app/oauth", "", "", url.Values{}))
+	loginURL, err := p.GetLoginURL("https://my.test.app/oauth", "", "", url.Values{})
+	assert.NoError(t, err)
+	result, _ := url.Parse(loginURL)
 	parsedQuery, _ := url.ParseQuery(result.RawQuery)
 	assert.NotContains(t, parsedQuery["scope"], " "+testURL)
 	assert.NotContains(t, result.RawQuery, "resource="+url.QueryEscape(testURL))
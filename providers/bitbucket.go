@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strings"
 
@@ -14,8 +15,16 @@ import (
 // BitbucketProvider represents an Bitbucket based Identity Provider
 type BitbucketProvider struct {
 	*ProviderData
-	Team       string
-	Repository string
+	Team                  string
+	Repository            string
+	RepositoryPermissions []bitbucketRepositoryPermission
+}
+
+// bitbucketRepositoryPermission is the minimum permission a user must have
+// on a repository
+type bitbucketRepositoryPermission struct {
+	repository string
+	permission string
 }
 
 var _ Provider = (*BitbucketProvider)(nil)
@@ -25,6 +34,14 @@ const (
 	bitbucketDefaultScope = "email"
 )
 
+// bitbucketPermissionLevels orders the repository permissions of Bitbucket,
+// each permission includes the ones below it
+var bitbucketPermissionLevels = map[string]int{
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
 var (
 	// Default Login URL for Bitbucket.
 	// Pre-parsed URL of https://bitbucket.org/site/oauth2/authorize.
@@ -72,6 +89,9 @@ func NewBitbucketProvider(p *ProviderData, opts options.BitbucketOptions) *Bitbu
 	if opts.Repository != "" {
 		provider.setRepository(opts.Repository)
 	}
+	if len(opts.RepositoryPermissions) > 0 {
+		provider.setRepositoryPermissions(opts.RepositoryPermissions)
+	}
 	return provider
 }
 
@@ -91,6 +111,24 @@ func (p *BitbucketProvider) setRepository(repository string) {
 	}
 }
 
+// setRepositoryPermissions defines the minimum permissions the user must have
+// on repositories, given as `workspace/repository:permission`
+func (p *BitbucketProvider) setRepositoryPermissions(repositoryPermissions []string) {
+	for _, repositoryPermission := range repositoryPermissions {
+		i := strings.LastIndex(repositoryPermission, ":")
+		if i < 0 {
+			continue
+		}
+		p.RepositoryPermissions = append(p.RepositoryPermissions, bitbucketRepositoryPermission{
+			repository: repositoryPermission[:i],
+			permission: repositoryPermission[i+1:],
+		})
+	}
+	if !strings.Contains(p.Scope, "repository") {
+		p.Scope += " repository"
+	}
+}
+
 // GetEmailAddress returns the email of the authenticated user
 func (p *BitbucketProvider) GetEmailAddress(ctx context.Context, s *sessions.SessionState) (string, error) {
 
@@ -180,6 +218,23 @@ func (p *BitbucketProvider) GetEmailAddress(ctx context.Context, s *sessions.Ses
 		}
 	}
 
+	if len(p.RepositoryPermissions) > 0 {
+		permissions, err := p.getRepositoryPermissions(ctx, s.AccessToken)
+		if err != nil {
+			logger.Errorf("failed checking repository permissions: %v", err)
+			return "", err
+		}
+		if !p.hasRepositoryPermissions(permissions) {
+			logger.Error("repository permissions test failed, access denied")
+			return "", nil
+		}
+		// The permissions are kept in the session, so that they are not
+		// requested again when the session is loaded
+		for _, required := range p.RepositoryPermissions {
+			s.Groups = append(s.Groups, required.repository+":"+permissions[required.repository])
+		}
+	}
+
 	for _, email := range emails.Values {
 		if email.Primary {
 			return email.Email, nil
@@ -188,3 +243,92 @@ func (p *BitbucketProvider) GetEmailAddress(ctx context.Context, s *sessions.Ses
 
 	return "", nil
 }
+
+// getRepositoryPermissions returns the permission of the user on each of the
+// required repositories they have access to, following the pages of the
+// permissions API
+func (p *BitbucketProvider) getRepositoryPermissions(ctx context.Context, accessToken string) (map[string]string, error) {
+	type permissionsPage struct {
+		Values []struct {
+			Permission string `json:"permission"`
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		} `json:"values"`
+		Next string `json:"next"`
+	}
+
+	filters := make([]string, 0, len(p.RepositoryPermissions))
+	for _, required := range p.RepositoryPermissions {
+		filters = append(filters, "repository.full_name=\""+required.repository+"\"")
+	}
+
+	permissionsURL := &url.URL{}
+	*permissionsURL = *p.ValidateURL
+	permissionsURL.Path = "/2.0/user/permissions/repositories"
+	permissionsURL.RawQuery = url.Values{
+		"q":            {strings.Join(filters, " OR ")},
+		"pagelen":      {"100"},
+		"access_token": {accessToken},
+	}.Encode()
+
+	permissions := map[string]string{}
+	requestURL := permissionsURL.String()
+	for requestURL != "" {
+		var page permissionsPage
+		err := requests.New(requestURL).
+			WithContext(ctx).
+			Do().
+			UnmarshalInto(&page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range page.Values {
+			// Bitbucket matches the full names case-insensitively
+			for _, required := range p.RepositoryPermissions {
+				if strings.EqualFold(value.Repository.FullName, required.repository) {
+					permissions[required.repository] = value.Permission
+				}
+			}
+		}
+
+		requestURL, err = bitbucketNextPageURL(page.Next, p.ValidateURL, accessToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return permissions, nil
+}
+
+// bitbucketNextPageURL adds the access token to the next page URL of a
+// response, which is empty on the last page
+func bitbucketNextPageURL(next string, apiURL *url.URL, accessToken string) (string, error) {
+	if next == "" {
+		return "", nil
+	}
+	nextURL, err := url.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("invalid next page URL %q: %v", next, err)
+	}
+	// The access token must only be sent to the Bitbucket API
+	if nextURL.Scheme != apiURL.Scheme || !strings.EqualFold(nextURL.Host, apiURL.Host) {
+		return "", fmt.Errorf("next page URL %q is not on the Bitbucket API host %q", next, apiURL.Host)
+	}
+	query := nextURL.Query()
+	query.Set("access_token", accessToken)
+	nextURL.RawQuery = query.Encode()
+	return nextURL.String(), nil
+}
+
+// hasRepositoryPermissions returns whether the user has at least the required
+// permission on each of the repositories
+func (p *BitbucketProvider) hasRepositoryPermissions(permissions map[string]string) bool {
+	for _, required := range p.RepositoryPermissions {
+		if bitbucketPermissionLevels[permissions[required.repository]] < bitbucketPermissionLevels[required.permission] {
+			logger.Printf("Missing %s permission on Bitbucket repository %s", required.permission, required.repository)
+			return false
+		}
+	}
+	return true
+}
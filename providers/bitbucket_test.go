@@ -2,10 +2,14 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sort"
+	"strconv"
 	"testing"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
@@ -168,3 +172,140 @@ func TestBitbucketProviderGetEmailAddressEmailNotPresentInPayload(t *testing.T)
 	assert.Equal(t, "", email)
 	assert.Equal(t, nil, err)
 }
+
+// testBitbucketPermissionsBackend serves the user's emails and repository
+// permissions, with each repository permission on its own page
+func testBitbucketPermissionsBackend(t *testing.T, permissions map[string]string) *httptest.Server {
+	repositories := make([]string, 0, len(permissions))
+	for repository := range permissions {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if !IsAuthorizedInURL(r.URL) {
+				w.WriteHeader(403)
+				return
+			}
+			switch r.URL.Path {
+			case "/2.0/user/emails":
+				w.Write([]byte(`{"values": [{"email": "michael.bland@gsa.gov", "is_primary": true}]}`))
+			case "/2.0/user/permissions/repositories":
+				assert.Equal(t, `repository.full_name="workspace/repo1" OR repository.full_name="workspace/repo2"`, r.URL.Query().Get("q"))
+
+				pageNumber, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				page := map[string]interface{}{"values": []interface{}{}}
+				if pageNumber < len(repositories) {
+					repository := repositories[pageNumber]
+					page["values"] = []interface{}{map[string]interface{}{
+						"permission": permissions[repository],
+						"repository": map[string]interface{}{"full_name": repository},
+					}}
+				}
+				if pageNumber+1 < len(repositories) {
+					// Bitbucket does not include the access token in the next page URL
+					page["next"] = fmt.Sprintf("%s/2.0/user/permissions/repositories?q=%s&page=%d",
+						server.URL, url.QueryEscape(r.URL.Query().Get("q")), pageNumber+1)
+				}
+				body, err := json.Marshal(page)
+				assert.NoError(t, err)
+				w.Write(body)
+			default:
+				w.WriteHeader(404)
+			}
+		}))
+	return server
+}
+
+func TestBitbucketProviderGetEmailAddressWithRepositoryPermissions(t *testing.T) {
+	testCases := map[string]struct {
+		permissions    map[string]string
+		expectedEmail  string
+		expectedGroups []string
+	}{
+		"with sufficient permissions": {
+			permissions:    map[string]string{"workspace/repo1": "write", "workspace/repo2": "read"},
+			expectedEmail:  "michael.bland@gsa.gov",
+			expectedGroups: []string{"workspace/repo1:write", "workspace/repo2:read"},
+		},
+		"with higher permissions": {
+			permissions:    map[string]string{"workspace/repo1": "admin", "workspace/repo2": "write"},
+			expectedEmail:  "michael.bland@gsa.gov",
+			expectedGroups: []string{"workspace/repo1:admin", "workspace/repo2:write"},
+		},
+		"with insufficient permissions": {
+			permissions:   map[string]string{"workspace/repo1": "read", "workspace/repo2": "admin"},
+			expectedEmail: "",
+		},
+		"without access to a repository": {
+			permissions:   map[string]string{"workspace/repo1": "admin"},
+			expectedEmail: "",
+		},
+		"with repository names in a different case": {
+			permissions:    map[string]string{"Workspace/Repo1": "write", "workspace/REPO2": "read"},
+			expectedEmail:  "michael.bland@gsa.gov",
+			expectedGroups: []string{"workspace/repo1:write", "workspace/repo2:read"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			b := testBitbucketPermissionsBackend(t, tc.permissions)
+			defer b.Close()
+
+			bURL, _ := url.Parse(b.URL)
+			p := NewBitbucketProvider(
+				&ProviderData{ValidateURL: &url.URL{}},
+				options.BitbucketOptions{
+					RepositoryPermissions: []string{"workspace/repo1:write", "workspace/repo2:read"},
+				},
+			)
+			updateURL(p.Data().ValidateURL, bURL.Host)
+			assert.Equal(t, "email repository", p.Data().Scope)
+
+			session := CreateAuthorizedSession()
+			email, err := p.GetEmailAddress(context.Background(), session)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedEmail, email)
+			assert.Equal(t, tc.expectedGroups, session.Groups)
+		})
+	}
+}
+
+func TestBitbucketNextPageURL(t *testing.T) {
+	apiURL, _ := url.Parse("https://api.bitbucket.org/2.0/user")
+	testCases := map[string]struct {
+		next        string
+		expectedURL string
+		expectedErr string
+	}{
+		"without a next page": {
+			next:        "",
+			expectedURL: "",
+		},
+		"with a next page on the API host": {
+			next:        "https://api.bitbucket.org/2.0/user/permissions/repositories?page=2",
+			expectedURL: "https://api.bitbucket.org/2.0/user/permissions/repositories?access_token=imaginary_access_token&page=2",
+		},
+		"with a next page on another host": {
+			next:        "https://evil.example.com/2.0/user/permissions/repositories?page=2",
+			expectedErr: `next page URL "https://evil.example.com/2.0/user/permissions/repositories?page=2" is not on the Bitbucket API host "api.bitbucket.org"`,
+		},
+		"with a next page over another scheme": {
+			next:        "http://api.bitbucket.org/2.0/user/permissions/repositories?page=2",
+			expectedErr: `next page URL "http://api.bitbucket.org/2.0/user/permissions/repositories?page=2" is not on the Bitbucket API host "api.bitbucket.org"`,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			nextURL, err := bitbucketNextPageURL(tc.next, apiURL, "imaginary_access_token")
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedURL, nextURL)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package providers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+const (
+	// clientAssertionLifetime is how long a client assertion is valid for, a
+	// fresh assertion is signed for every call to the token endpoint
+	clientAssertionLifetime = time.Minute
+
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// clientAssertionSigner signs the JWT client assertions that authenticate the
+// client at the token endpoint (private_key_jwt, RFC 7523)
+type clientAssertionSigner struct {
+	method   jwt.SigningMethod
+	key      interface{}
+	keyID    string
+	clientID string
+
+	// Clock for the assertion times, the zero value uses the global clock
+	clock clock.Clock
+}
+
+// newClientAssertionSigner loads the signing key for the client assertions.
+// The issuer and subject of the assertions are the client ID.
+func newClientAssertionSigner(opts *options.ClientAssertionOptions, clientID string) (*clientAssertionSigner, error) {
+	method, key, err := loadSigningKey("client assertion", opts.SigningAlgorithm, opts.SigningKey, opts.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientAssertionSigner{
+		method:   method,
+		key:      key,
+		keyID:    opts.KeyID,
+		clientID: clientID,
+	}, nil
+}
+
+// assertion signs a client assertion for the token endpoint
+func (s *clientAssertionSigner) assertion(tokenURL string) (string, error) {
+	now := s.clock.Now()
+	jti, err := encryption.Nonce(16)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(s.method, jwt.StandardClaims{
+		Issuer:    s.clientID,
+		Subject:   s.clientID,
+		Audience:  tokenURL,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(clientAssertionLifetime).Unix(),
+		Id:        fmt.Sprintf("%x", jti),
+	})
+	if s.keyID != "" {
+		token.Header["kid"] = s.keyID
+	}
+
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("error signing client assertion: %v", err)
+	}
+	return signed, nil
+}
+
+// client wraps the token client so that the calls to the token endpoint are
+// authenticated with a client assertion
+func (s *clientAssertionSigner) client(tokenClient *http.Client) *http.Client {
+	client := *tokenClient
+	client.Transport = &clientAssertionTransport{
+		signer:    s,
+		Transport: tokenClient.Transport,
+	}
+	return &client
+}
+
+// clientAssertionTransport replaces the client secret in the form body of
+// token endpoint requests with a client assertion. This covers the requests
+// made by the providers directly and by the oauth2 library alike.
+// Requests that already carry a client assertion are sent unchanged.
+type clientAssertionTransport struct {
+	signer *clientAssertionSigner
+
+	// Transport performs the requests.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// RoundTrip adds a fresh client assertion to the request and performs it
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil {
+		return t.transport().RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil || params.Get("client_assertion") != "" {
+		return t.transport().RoundTrip(withBody(req, string(body)))
+	}
+
+	tokenURL := *req.URL
+	tokenURL.RawQuery = ""
+	tokenURL.Fragment = ""
+	assertion, err := t.signer.assertion(tokenURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	params.Del("client_secret")
+	params.Set("client_id", t.signer.clientID)
+	params.Set("client_assertion_type", clientAssertionTypeJWTBearer)
+	params.Set("client_assertion", assertion)
+
+	authenticated := withBody(req, params.Encode())
+	// The oauth2 library may send the client secret as basic auth instead
+	authenticated.Header.Del("Authorization")
+	return t.transport().RoundTrip(authenticated)
+}
+
+func (t *clientAssertionTransport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// withBody returns a copy of the request with the body replaced
+func withBody(req *http.Request, body string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Body = io.NopCloser(strings.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(body)), nil
+	}
+	return r
+}
@@ -0,0 +1,233 @@
+package providers
+
+import (
+	"context"
+	"crypto/elliptic"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// clientAssertionServer is a token endpoint that only accepts clients
+// authenticated with a client assertion and records the assertions it saw
+type clientAssertionServer struct {
+	*httptest.Server
+
+	t         *testing.T
+	publicKey interface{}
+	failFirst bool
+
+	mu     sync.Mutex
+	claims []jwt.MapClaims
+	tokens []*jwt.Token
+}
+
+func newClientAssertionServer(t *testing.T, publicKey interface{}) *clientAssertionServer {
+	s := &clientAssertionServer{t: t, publicKey: publicKey}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.token))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *clientAssertionServer) tokenURL() *url.URL {
+	tokenURL, err := url.Parse(s.URL + "/token")
+	require.NoError(s.t, err)
+	return tokenURL
+}
+
+func (s *clientAssertionServer) token(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assert.NoError(s.t, req.ParseForm())
+	assert.Empty(s.t, req.Header.Get("Authorization"))
+	assert.NotContains(s.t, req.PostForm, "client_secret")
+	assert.Equal(s.t, clientID, req.PostForm.Get("client_id"))
+	assert.Equal(s.t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", req.PostForm.Get("client_assertion_type"))
+
+	token, err := jwt.Parse(req.PostForm.Get("client_assertion"), func(*jwt.Token) (interface{}, error) {
+		return s.publicKey, nil
+	})
+	if err != nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(rw, `{"error": "invalid_client", "error_description": %q}`, err.Error())
+		return
+	}
+	s.tokens = append(s.tokens, token)
+	s.claims = append(s.claims, token.Claims.(jwt.MapClaims))
+
+	if s.failFirst && len(s.claims) == 1 {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(rw, `{"access_token": "access-%d", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`, len(s.claims))
+}
+
+func TestClientAssertionRedeem(t *testing.T) {
+	rsaKey, rsaKeyPEM := newRSAKeyPEM(t)
+	ecKey, ecKeyPEM := newECKeyPEM(t, elliptic.P256())
+
+	testCases := map[string]struct {
+		opts      options.ClientAssertionOptions
+		publicKey interface{}
+		method    jwt.SigningMethod
+	}{
+		"RS256 by default": {
+			opts: options.ClientAssertionOptions{
+				SigningKey: rsaKeyPEM,
+			},
+			publicKey: &rsaKey.PublicKey,
+			method:    jwt.SigningMethodRS256,
+		},
+		"ES256 with a key ID": {
+			opts: options.ClientAssertionOptions{
+				SigningAlgorithm: options.RequestObjectSigningES256,
+				SigningKey:       ecKeyPEM,
+				KeyID:            "key-1",
+			},
+			publicKey: &ecKey.PublicKey,
+			method:    jwt.SigningMethodES256,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := newClientAssertionServer(t, tc.publicKey)
+
+			signer, err := newClientAssertionSigner(&tc.opts, clientID)
+			require.NoError(t, err)
+			// The server checks the assertion against the real time
+			now := time.Now().Truncate(time.Second)
+			signer.clock.Set(now)
+
+			p := &ProviderData{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				RedeemURL:    server.tokenURL(),
+				tokenClient:  signer.client(http.DefaultClient),
+			}
+
+			session, err := p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code", "")
+			require.NoError(t, err)
+			assert.Equal(t, "access-1", session.AccessToken)
+
+			require.Len(t, server.tokens, 1)
+			token := server.tokens[0]
+			assert.Equal(t, tc.method, token.Method)
+			if tc.opts.KeyID != "" {
+				assert.Equal(t, tc.opts.KeyID, token.Header["kid"])
+			} else {
+				assert.NotContains(t, token.Header, "kid")
+			}
+
+			claims := server.claims[0]
+			assert.Equal(t, clientID, claims["iss"])
+			assert.Equal(t, clientID, claims["sub"])
+			assert.Equal(t, server.URL+"/token", claims["aud"])
+			assert.Equal(t, float64(now.Unix()), claims["iat"])
+			assert.Equal(t, float64(now.Add(clientAssertionLifetime).Unix()), claims["exp"])
+			assert.NotEmpty(t, claims["jti"])
+		})
+	}
+}
+
+func TestClientAssertionRefresh(t *testing.T) {
+	rsaKey, rsaKeyPEM := newRSAKeyPEM(t)
+	server := newClientAssertionServer(t, &rsaKey.PublicKey)
+
+	signer, err := newClientAssertionSigner(&options.ClientAssertionOptions{SigningKey: rsaKeyPEM}, clientID)
+	require.NoError(t, err)
+	p := &ProviderData{tokenClient: signer.client(http.DefaultClient)}
+
+	// The oauth2 library sends the (empty) client secret as basic auth
+	c := oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{TokenURL: server.tokenURL().String()},
+	}
+	for i := 1; i <= 2; i++ {
+		expired := &oauth2.Token{RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+		token, err := c.TokenSource(p.tokenContext(context.Background()), expired).Token()
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("access-%d", i), token.AccessToken)
+	}
+
+	require.Len(t, server.claims, 2)
+	assert.NotEqual(t, server.claims[0]["jti"], server.claims[1]["jti"])
+}
+
+func TestNewProviderWithClientAssertion(t *testing.T) {
+	rsaKey, rsaKeyPEM := newRSAKeyPEM(t)
+	server := newClientAssertionServer(t, &rsaKey.PublicKey)
+	server.failFirst = true
+
+	provider, err := NewProvider(options.Provider{
+		ID:               providerID,
+		Type:             "github",
+		ClientID:         clientID,
+		RedeemRetries:    1,
+		RedeemRetryDelay: options.Duration(time.Millisecond),
+		ClientAssertion:  &options.ClientAssertionOptions{SigningKey: rsaKeyPEM},
+		LoginURL:         server.URL + "/authorize",
+		RedeemURL:        server.tokenURL().String(),
+	})
+	require.NoError(t, err)
+
+	c := oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{TokenURL: server.tokenURL().String()},
+	}
+	expired := &oauth2.Token{RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	_, err = c.TokenSource(provider.Data().tokenContext(context.Background()), expired).Token()
+	require.NoError(t, err)
+
+	// The retry is sent with a fresh assertion
+	require.Len(t, server.claims, 2)
+	assert.NotEqual(t, server.claims[0]["jti"], server.claims[1]["jti"])
+}
+
+func TestClientAssertionRevokeToken(t *testing.T) {
+	rsaKey, rsaKeyPEM := newRSAKeyPEM(t)
+	server := newClientAssertionServer(t, &rsaKey.PublicKey)
+
+	provider, err := NewProvider(options.Provider{
+		ID:              providerID,
+		Type:            "github",
+		ClientID:        clientID,
+		ClientAssertion: &options.ClientAssertionOptions{SigningKey: rsaKeyPEM},
+		LoginURL:        server.URL + "/authorize",
+		RedeemURL:       server.tokenURL().String(),
+		RevocationURL:   server.URL + "/revoke",
+	})
+	require.NoError(t, err)
+
+	err = provider.RevokeToken(context.Background(), &sessions.SessionState{AccessToken: "access", RefreshToken: "refresh"})
+	require.NoError(t, err)
+
+	// Each revocation is authenticated with an assertion for the endpoint
+	require.Len(t, server.claims, 2)
+	for _, claims := range server.claims {
+		assert.Equal(t, server.URL+"/revoke", claims["aud"])
+	}
+}
+
+func TestNewClientAssertionSigner(t *testing.T) {
+	_, err := newClientAssertionSigner(&options.ClientAssertionOptions{}, clientID)
+	assert.EqualError(t, err, "client assertions require a signingKey or signingKeyFile")
+
+	_, rsaKeyPEM := newRSAKeyPEM(t)
+	_, err = newClientAssertionSigner(&options.ClientAssertionOptions{SigningAlgorithm: "HS256", SigningKey: rsaKeyPEM}, clientID)
+	assert.EqualError(t, err, `unsupported client assertion signing algorithm "HS256": must be one of "RS256" or "ES256"`)
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -25,6 +26,20 @@ type GitHubProvider struct {
 	Repo  string
 	Token string
 	Users []string
+
+	// RequireAllOrgsAndTeams requires the user to satisfy every org and team
+	// restriction instead of any one of them
+	RequireAllOrgsAndTeams bool
+}
+
+// githubTeam is a team slug qualified by the organization it belongs to
+type githubTeam struct {
+	Org  string
+	Slug string
+}
+
+func (t githubTeam) String() string {
+	return fmt.Sprintf("%s:%s", t.Org, t.Slug)
 }
 
 var _ Provider = (*GitHubProvider)(nil)
@@ -76,6 +91,7 @@ func NewGitHubProvider(p *ProviderData, opts options.GitHubOptions) *GitHubProvi
 	provider := &GitHubProvider{ProviderData: p}
 
 	provider.setOrgTeam(opts.Org, opts.Team)
+	provider.RequireAllOrgsAndTeams = opts.RequireAllOrgsAndTeams
 	provider.setRepo(opts.Repo, opts.Token)
 	provider.setUsers(opts.Users)
 	return provider
@@ -123,7 +139,8 @@ func (p *GitHubProvider) ValidateSession(ctx context.Context, s *sessions.Sessio
 	return validateToken(ctx, p, s.AccessToken, makeGitHubHeader(s.AccessToken))
 }
 
-func (p *GitHubProvider) hasOrg(ctx context.Context, accessToken string) (bool, error) {
+// getOrgs returns the logins of all organizations the user is a member of
+func (p *GitHubProvider) getOrgs(ctx context.Context, accessToken string) ([]string, error) {
 	// https://developer.github.com/v3/orgs/#list-your-organizations
 
 	var orgs []struct {
@@ -155,7 +172,7 @@ func (p *GitHubProvider) hasOrg(ctx context.Context, accessToken string) (bool,
 			Do().
 			UnmarshalInto(&op)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 
 		if len(op) == 0 {
@@ -168,18 +185,13 @@ func (p *GitHubProvider) hasOrg(ctx context.Context, accessToken string) (bool,
 
 	presentOrgs := make([]string, 0, len(orgs))
 	for _, org := range orgs {
-		if p.Org == org.Login {
-			logger.Printf("Found Github Organization: %q", org.Login)
-			return true, nil
-		}
 		presentOrgs = append(presentOrgs, org.Login)
 	}
-
-	logger.Printf("Missing Organization:%q in %v", p.Org, presentOrgs)
-	return false, nil
+	return presentOrgs, nil
 }
 
-func (p *GitHubProvider) hasOrgAndTeam(ctx context.Context, accessToken string) (bool, error) {
+// getTeams returns all teams the user is a member of
+func (p *GitHubProvider) getTeams(ctx context.Context, accessToken string) ([]githubTeam, error) {
 	// https://developer.github.com/v3/orgs/teams/#list-user-teams
 
 	var teams []struct {
@@ -221,7 +233,7 @@ func (p *GitHubProvider) hasOrgAndTeam(ctx context.Context, accessToken string)
 			WithHeaders(makeGitHubHeader(accessToken)).
 			Do()
 		if result.Error() != nil {
-			return false, result.Error()
+			return nil, result.Error()
 		}
 
 		if last == 0 {
@@ -249,7 +261,7 @@ func (p *GitHubProvider) hasOrgAndTeam(ctx context.Context, accessToken string)
 
 		var tp teamsPage
 		if err := result.UnmarshalInto(&tp); err != nil {
-			return false, err
+			return nil, err
 		}
 		if len(tp) == 0 {
 			break
@@ -267,33 +279,131 @@ func (p *GitHubProvider) hasOrgAndTeam(ctx context.Context, accessToken string)
 		pn++
 	}
 
-	var hasOrg bool
-	presentOrgs := make(map[string]bool)
-	var presentTeams []string
+	presentTeams := make([]githubTeam, 0, len(teams))
 	for _, team := range teams {
-		presentOrgs[team.Org.Login] = true
-		if p.Org == team.Org.Login {
-			hasOrg = true
-			ts := strings.Split(p.Team, ",")
-			for _, t := range ts {
-				if t == team.Slug {
-					logger.Printf("Found Github Organization:%q Team:%q (Name:%q)", team.Org.Login, team.Slug, team.Name)
-					return true, nil
-				}
-			}
-			presentTeams = append(presentTeams, team.Slug)
+		presentTeams = append(presentTeams, githubTeam{Org: team.Org.Login, Slug: team.Slug})
+	}
+	return presentTeams, nil
+}
+
+// parseTeams parses the configured teams.
+// Teams may be given as `slug`, belonging to the configured org, or as
+// `org:slug` to reference a team in any organization.
+func (p *GitHubProvider) parseTeams() []githubTeam {
+	var teams []githubTeam
+	for _, team := range strings.Split(p.Team, ",") {
+		team = strings.TrimSpace(team)
+		if team == "" {
+			continue
+		}
+		if parts := strings.SplitN(team, ":", 2); len(parts) == 2 {
+			teams = append(teams, githubTeam{Org: parts[0], Slug: parts[1]})
+		} else {
+			teams = append(teams, githubTeam{Org: p.Org, Slug: team})
+		}
+	}
+	return teams
+}
+
+// hasOrgsAndTeams checks the user against the configured org and teams.
+// A team restriction in the configured org implies membership of the org,
+// so the org is only checked on its own when none of the teams belong to it.
+// By default any one restriction is enough, with RequireAllOrgsAndTeams set
+// the user must satisfy all of them.
+func (p *GitHubProvider) hasOrgsAndTeams(ctx context.Context, accessToken string) (bool, error) {
+	teams := p.parseTeams()
+
+	checkOrg := p.Org != ""
+	for _, team := range teams {
+		if team.Org == p.Org {
+			checkOrg = false
+		}
+	}
+
+	// restrictions maps each configured restriction to whether the user meets it
+	restrictions := map[string]bool{}
+	if checkOrg {
+		orgs, err := p.getOrgs(ctx, accessToken)
+		if err != nil {
+			return false, err
+		}
+		restrictions[fmt.Sprintf("org %q", p.Org)] = p.checkOrg(orgs)
+	}
+
+	if len(teams) > 0 {
+		presentTeams, err := p.getTeams(ctx, accessToken)
+		if err != nil {
+			return false, err
+		}
+		for _, team := range teams {
+			restrictions[fmt.Sprintf("team %q", team)] = checkTeam(team, presentTeams)
+		}
+	}
+
+	var failed []string
+	for restriction, ok := range restrictions {
+		if !ok {
+			failed = append(failed, restriction)
+		}
+	}
+	sort.Strings(failed)
+
+	if p.RequireAllOrgsAndTeams {
+		if len(failed) > 0 {
+			logger.Printf("GitHub user does not meet all required restrictions, failed: %s", strings.Join(failed, ", "))
+			return false, nil
 		}
+		return len(restrictions) > 0, nil
+	}
+
+	if len(failed) == len(restrictions) {
+		logger.Printf("GitHub user does not meet any of the restrictions, failed: %s", strings.Join(failed, ", "))
+		return false, nil
 	}
+	return true, nil
+}
+
+// checkOrg checks whether the configured org is one of the present orgs
+func (p *GitHubProvider) checkOrg(presentOrgs []string) bool {
+	for _, org := range presentOrgs {
+		if p.Org == org {
+			logger.Printf("Found Github Organization: %q", org)
+			return true
+		}
+	}
+
+	logger.Printf("Missing Organization:%q in %v", p.Org, presentOrgs)
+	return false
+}
+
+// checkTeam checks whether the team is one of the present teams
+func checkTeam(team githubTeam, presentTeams []githubTeam) bool {
+	var orgTeams []string
+	hasOrg := false
+	presentOrgs := make(map[string]bool)
+	for _, t := range presentTeams {
+		presentOrgs[t.Org] = true
+		if t.Org != team.Org {
+			continue
+		}
+		hasOrg = true
+		if t.Slug == team.Slug {
+			logger.Printf("Found Github Organization:%q Team:%q", t.Org, t.Slug)
+			return true
+		}
+		orgTeams = append(orgTeams, t.Slug)
+	}
+
 	if hasOrg {
-		logger.Printf("Missing Team:%q from Org:%q in teams: %v", p.Team, p.Org, presentTeams)
+		logger.Printf("Missing Team:%q from Org:%q in teams: %v", team.Slug, team.Org, orgTeams)
 	} else {
 		var allOrgs []string
 		for org := range presentOrgs {
 			allOrgs = append(allOrgs, org)
 		}
-		logger.Printf("Missing Organization:%q in %#v", p.Org, allOrgs)
+		logger.Printf("Missing Organization:%q in %#v", team.Org, allOrgs)
 	}
-	return false, nil
+	return false
 }
 
 func (p *GitHubProvider) hasRepo(ctx context.Context, accessToken string) (bool, error) {
@@ -403,21 +513,15 @@ func (p *GitHubProvider) getEmail(ctx context.Context, s *sessions.SessionState)
 			return err
 		}
 		// org and repository options are not configured
-		if !verifiedUser && p.Org == "" && p.Repo == "" {
+		if !verifiedUser && p.Org == "" && p.Team == "" && p.Repo == "" {
 			return errors.New("missing github user")
 		}
 	}
 	// If a user is verified by username options, skip the following restrictions
 	if !verifiedUser {
-		if p.Org != "" {
-			if p.Team != "" {
-				if ok, err := p.hasOrgAndTeam(ctx, s.AccessToken); err != nil || !ok {
-					return err
-				}
-			} else {
-				if ok, err := p.hasOrg(ctx, s.AccessToken); err != nil || !ok {
-					return err
-				}
+		if p.Org != "" || p.Team != "" {
+			if ok, err := p.hasOrgsAndTeams(ctx, s.AccessToken); err != nil || !ok {
+				return err
 			}
 		} else if p.Repo != "" && p.Token == "" { // If we have a token we'll do the collaborator check in GetUserName
 			if ok, err := p.hasRepo(ctx, s.AccessToken); err != nil || !ok {
@@ -475,7 +579,7 @@ func (p *GitHubProvider) getUser(ctx context.Context, s *sessions.SessionState)
 	}
 
 	// Now that we have the username we can check collaborator status
-	if !p.isVerifiedUser(user.Login) && p.Org == "" && p.Repo != "" && p.Token != "" {
+	if !p.isVerifiedUser(user.Login) && p.Org == "" && p.Team == "" && p.Repo != "" && p.Token != "" {
 		if ok, err := p.isCollaborator(ctx, user.Login, p.Token); err != nil || !ok {
 			return err
 		}
@@ -39,6 +39,7 @@ func testGitHubBackend(payloads map[string][]string) *httptest.Server {
 		"/user":        {""},
 		"/user/emails": {""},
 		"/user/orgs":   {"page=1&per_page=100", "page=2&per_page=100", "page=3&per_page=100"},
+		"/user/teams":  {"page=1&per_page=100"},
 	}
 
 	return httptest.NewServer(http.HandlerFunc(
@@ -164,6 +165,110 @@ func TestGitHubProvider_getEmailWithOrg(t *testing.T) {
 	assert.Equal(t, "michael.bland@gsa.gov", session.Email)
 }
 
+func TestGitHubProvider_getEmailWithOrgsAndTeams(t *testing.T) {
+	testCases := []struct {
+		name          string
+		org           string
+		team          string
+		requireAll    bool
+		expectedEmail string
+	}{
+		{
+			name:          "team in org",
+			org:           "acme",
+			team:          "platform",
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+		{
+			name:          "missing team in org",
+			org:           "acme",
+			team:          "sre",
+			expectedEmail: "",
+		},
+		{
+			name:          "qualified team in another org",
+			team:          "other:sre",
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+		{
+			name:          "org or team in another org",
+			org:           "acme",
+			team:          "other:platform",
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+		{
+			name:          "org and team in another org",
+			org:           "acme",
+			team:          "other:platform",
+			requireAll:    true,
+			expectedEmail: "",
+		},
+		{
+			name:          "org and qualified team in org",
+			org:           "acme",
+			team:          "acme:platform",
+			requireAll:    true,
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+		{
+			name:          "any of multiple teams",
+			org:           "acme",
+			team:          "platform,other:platform",
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+		{
+			name:          "all of multiple teams",
+			org:           "acme",
+			team:          "platform,other:platform",
+			requireAll:    true,
+			expectedEmail: "",
+		},
+		{
+			name:          "all of multiple teams satisfied",
+			org:           "acme",
+			team:          "platform, other:sre",
+			requireAll:    true,
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+		{
+			name:          "missing org",
+			org:           "missing",
+			expectedEmail: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := testGitHubBackend(map[string][]string{
+				"/user/emails": {`[ {"email": "michael.bland@gsa.gov", "verified": true, "primary": true} ]`},
+				"/user/orgs": {
+					`[ {"login":"acme"} ]`,
+					`[ {"login":"other"} ]`,
+					`[ ]`,
+				},
+				"/user/teams": {
+					`[ {"name":"Platform", "slug":"platform", "organization":{"login":"acme"}}, {"name":"SRE", "slug":"sre", "organization":{"login":"other"}} ]`,
+				},
+			})
+			defer b.Close()
+
+			bURL, _ := url.Parse(b.URL)
+			p := testGitHubProvider(bURL.Host,
+				options.GitHubOptions{
+					Org:                    tc.org,
+					Team:                   tc.team,
+					RequireAllOrgsAndTeams: tc.requireAll,
+				},
+			)
+
+			session := CreateAuthorizedSession()
+			err := p.getEmail(context.Background(), session)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedEmail, session.Email)
+		})
+	}
+}
+
 func TestGitHubProvider_getEmailWithWriteAccessToPublicRepo(t *testing.T) {
 	b := testGitHubBackend(map[string][]string{
 		"/repo/oauth2-proxy/oauth2-proxy": {`{"permissions": {"pull": true, "push": true}, "private": false}`},
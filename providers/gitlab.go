@@ -82,28 +82,28 @@ type gitlabProject struct {
 }
 
 // newGitlabProject Creates a new GitlabProject struct from project string
-// formatted as `namespace/project=accesslevel`
+// formatted as `namespace/project=accesslevel` or `namespace/project:accesslevel`
 // if no accesslevel provided, use the default one
 func newGitlabProject(project string) (*gitlabProject, error) {
 	const defaultAccessLevel = 20
 	// see https://docs.gitlab.com/ee/api/members.html#valid-access-levels
-	validAccessLevel := [4]int{10, 20, 30, 40}
+	validAccessLevel := [6]int{5, 10, 20, 30, 40, 50}
 
-	parts := strings.SplitN(project, "=", 2)
-	if len(parts) == 2 {
-		lvl, err := strconv.Atoi(parts[1])
+	if i := strings.LastIndexAny(project, "=:"); i >= 0 {
+		name, level := project[:i], project[i+1:]
+		lvl, err := strconv.Atoi(level)
 		if err != nil {
 			return nil, err
 		}
 		for _, valid := range validAccessLevel {
 			if lvl == valid {
 				return &gitlabProject{
-					Name:        parts[0],
+					Name:        name,
 					AccessLevel: lvl,
 				}, nil
 			}
 		}
-		return nil, fmt.Errorf("invalid gitlab project access level specified (%s)", parts[0])
+		return nil, fmt.Errorf("invalid gitlab project access level specified for %s (%s): must be one of %v", name, level, validAccessLevel)
 	}
 
 	return &gitlabProject{
@@ -147,12 +147,13 @@ func (p *GitLabProvider) EnrichSession(ctx context.Context, s *sessions.SessionS
 	}
 
 	// Add projects as `project:blah` to s.Groups
-	p.addProjectsToSession(ctx, s)
+	p.addProjectsToSession(ctx, s, userinfo.Subject)
 
 	return nil
 }
 
 type gitlabUserinfo struct {
+	Subject       string   `json:"sub"`
 	Nickname      string   `json:"nickname"`
 	Email         string   `json:"email"`
 	EmailVerified bool     `json:"email_verified"`
@@ -183,7 +184,17 @@ func (p *GitLabProvider) getUserinfo(ctx context.Context, s *sessions.SessionSta
 // addProjectsToSession adds projects matching user access requirements into
 // the session state groups list.
 // This method prefixes projects names with `project:` to specify group kind.
-func (p *GitLabProvider) addProjectsToSession(ctx context.Context, s *sessions.SessionState) {
+// The projects are stored in the session so that the membership is only
+// looked up when the session is created.
+func (p *GitLabProvider) addProjectsToSession(ctx context.Context, s *sessions.SessionState, userID string) {
+	if len(p.allowedProjects) == 0 {
+		return
+	}
+	if userID == "" {
+		logger.Errorf("Warning: user %q has no user ID, unable to check project membership", s.Email)
+		return
+	}
+
 	// Iterate over projects, check if oauth2-proxy can get project information on behalf of the user
 	for _, project := range p.allowedProjects {
 		projectInfo, err := p.getProjectInfo(ctx, s, project.Name)
@@ -197,19 +208,16 @@ func (p *GitLabProvider) addProjectsToSession(ctx context.Context, s *sessions.S
 			continue
 		}
 
-		perms := projectInfo.Permissions.ProjectAccess
-		if perms == nil {
-			// use group project access as fallback
-			perms = projectInfo.Permissions.GroupAccess
-			// group project access is not set for this user then we give up
-			if perms == nil {
-				logger.Errorf("Warning: user %q has no project level access to %s",
-					s.Email, project.Name)
-				continue
-			}
+		// The members/all API includes memberships inherited from parent
+		// groups and returns the highest access level of the user
+		member, err := p.getProjectMember(ctx, s, projectInfo.ID, userID)
+		if err != nil {
+			logger.Errorf("Warning: user %q is not a member of project %s: %v",
+				s.Email, project.Name, err)
+			continue
 		}
 
-		if perms.AccessLevel < project.AccessLevel {
+		if member.AccessLevel < project.AccessLevel {
 			logger.Errorf(
 				"Warning: user %q does not have the minimum required access level for project %q",
 				s.Email,
@@ -222,20 +230,11 @@ func (p *GitLabProvider) addProjectsToSession(ctx context.Context, s *sessions.S
 	}
 }
 
-type gitlabPermissionAccess struct {
-	AccessLevel int `json:"access_level"`
-}
-
-type gitlabProjectPermission struct {
-	ProjectAccess *gitlabPermissionAccess `json:"project_access"`
-	GroupAccess   *gitlabPermissionAccess `json:"group_access"`
-}
-
 type gitlabProjectInfo struct {
-	Name              string                  `json:"name"`
-	Archived          bool                    `json:"archived"`
-	PathWithNamespace string                  `json:"path_with_namespace"`
-	Permissions       gitlabProjectPermission `json:"permissions"`
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	Archived          bool   `json:"archived"`
+	PathWithNamespace string `json:"path_with_namespace"`
 }
 
 func (p *GitLabProvider) getProjectInfo(ctx context.Context, s *sessions.SessionState, project string) (*gitlabProjectInfo, error) {
@@ -259,6 +258,36 @@ func (p *GitLabProvider) getProjectInfo(ctx context.Context, s *sessions.Session
 	return &projectInfo, nil
 }
 
+type gitlabProjectMember struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	AccessLevel int    `json:"access_level"`
+}
+
+// getProjectMember looks up the user in the direct and inherited members of
+// the project.
+// See https://docs.gitlab.com/ee/api/members.html#get-a-member-of-a-group-or-project-including-inherited-and-invited-members
+func (p *GitLabProvider) getProjectMember(ctx context.Context, s *sessions.SessionState, projectID int, userID string) (*gitlabProjectMember, error) {
+	var member gitlabProjectMember
+
+	endpointURL := &url.URL{
+		Scheme: p.LoginURL.Scheme,
+		Host:   p.LoginURL.Host,
+		Path:   fmt.Sprintf("/api/v4/projects/%d/members/all/%s", projectID, userID),
+	}
+
+	err := requests.New(endpointURL.String()).
+		WithContext(ctx).
+		SetHeader("Authorization", "Bearer "+s.AccessToken).
+		Do().
+		UnmarshalInto(&member)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project member: %v", err)
+	}
+
+	return &member, nil
+}
+
 func formatProject(project *gitlabProject) string {
 	return gitlabProjectPrefix + project.Name
 }
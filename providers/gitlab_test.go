@@ -40,6 +40,7 @@ func testGitLabProvider(hostname, scope string, opts options.GitLabOptions) (*Gi
 func testGitLabBackend() *httptest.Server {
 	userInfo := `
 		{
+			"sub": "1234",
 			"nickname": "FooBar",
 			"email": "foo@bar.com",
 			"email_verified": false,
@@ -49,106 +50,89 @@ func testGitLabBackend() *httptest.Server {
 
 	projectInfo := `
 		{
+			"id": 1,
 			"name": "MyProject",
 			"archived": false,
-			"path_with_namespace": "my_group/my_project",
-			"permissions": {
-				"project_access": null,
-				"group_access": {
-					"access_level": 30,
-					"notification_level": 3
-				}
-			}
+			"path_with_namespace": "my_group/my_project"
 		}
 	`
 
 	noAccessProjectInfo := `
 		{
+			"id": 2,
 			"name": "NoAccessProject",
 			"archived": false,
-			"path_with_namespace": "no_access_group/no_access_project",
-			"permissions": {
-				"project_access": null,
-				"group_access": null,
-			}
+			"path_with_namespace": "no_access_group/no_access_project"
 		}
 	`
 
 	personalProjectInfo := `
 		{
+			"id": 3,
 			"name": "MyPersonalProject",
 			"archived": false,
-			"path_with_namespace": "my_profile/my_personal_project",
-			"permissions": {
-				"project_access": {
-					"access_level": 30,
-					"notification_level": 3
-				},
-				"group_access": null
-			}
+			"path_with_namespace": "my_profile/my_personal_project"
 		}
 	`
 
 	archivedProjectInfo := `
 		{
+			"id": 4,
 			"name": "MyArchivedProject",
 			"archived": true,
-			"path_with_namespace": "my_group/my_archived_project",
-			"permissions": {
-				"project_access": {
-					"access_level": 30,
-					"notification_level": 3
-				},
-				"group_access": null
-			}
+			"path_with_namespace": "my_group/my_archived_project"
+		}
+	`
+
+	inheritedProjectInfo := `
+		{
+			"id": 5,
+			"name": "MyInheritedProject",
+			"archived": false,
+			"path_with_namespace": "my_group/my_inherited_project"
 		}
 	`
 
+	// Developer access on the group project
+	projectMember := `{"id": 1234, "username": "FooBar", "access_level": 30}`
+	// Developer access on the personal project
+	personalProjectMember := `{"id": 1234, "username": "FooBar", "access_level": 30}`
+	// Maintainer access inherited from the parent group, which is higher
+	// than the Reporter access granted on the project itself
+	inheritedProjectMember := `{"id": 1234, "username": "FooBar", "access_level": 40}`
+
 	authHeader := "Bearer gitlab_access_token"
 
+	responses := map[string]string{
+		"/oauth/userinfo":                                    userInfo,
+		"/api/v4/projects/my_group/my_project":               projectInfo,
+		"/api/v4/projects/no_access_group/no_access_project": noAccessProjectInfo,
+		"/api/v4/projects/my_group/my_archived_project":      archivedProjectInfo,
+		"/api/v4/projects/my_profile/my_personal_project":    personalProjectInfo,
+		"/api/v4/projects/my_group/my_inherited_project":     inheritedProjectInfo,
+		"/api/v4/projects/1/members/all/1234":                projectMember,
+		"/api/v4/projects/3/members/all/1234":                personalProjectMember,
+		"/api/v4/projects/5/members/all/1234":                inheritedProjectMember,
+	}
+
 	return httptest.NewServer(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			switch r.URL.Path {
-			case "/oauth/userinfo":
-				if r.Header["Authorization"][0] == authHeader {
-					w.WriteHeader(200)
-					w.Write([]byte(userInfo))
-				} else {
-					w.WriteHeader(401)
-				}
-			case "/api/v4/projects/my_group/my_project":
-				if r.Header["Authorization"][0] == authHeader {
-					w.WriteHeader(200)
-					w.Write([]byte(projectInfo))
-				} else {
-					w.WriteHeader(401)
-				}
-			case "/api/v4/projects/no_access_group/no_access_project":
-				if r.Header["Authorization"][0] == authHeader {
-					w.WriteHeader(200)
-					w.Write([]byte(noAccessProjectInfo))
-				} else {
-					w.WriteHeader(401)
-				}
-			case "/api/v4/projects/my_group/my_archived_project":
-				if r.Header["Authorization"][0] == authHeader {
-					w.WriteHeader(200)
-					w.Write([]byte(archivedProjectInfo))
-				} else {
-					w.WriteHeader(401)
-				}
-			case "/api/v4/projects/my_profile/my_personal_project":
-				if r.Header["Authorization"][0] == authHeader {
-					w.WriteHeader(200)
-					w.Write([]byte(personalProjectInfo))
-				} else {
-					w.WriteHeader(401)
-				}
-			case "/api/v4/projects/my_group/my_bad_project":
+			if r.URL.Path == "/api/v4/projects/my_group/my_bad_project" {
 				w.WriteHeader(403)
-			default:
+				return
+			}
+
+			response, ok := responses[r.URL.Path]
+			if !ok {
 				w.WriteHeader(404)
+				return
 			}
+			if r.Header.Get("Authorization") != authHeader {
+				w.WriteHeader(401)
+				return
+			}
+			w.WriteHeader(200)
+			w.Write([]byte(response))
 		}))
 }
 
@@ -277,6 +261,24 @@ var _ = Describe("Gitlab Provider Tests", func() {
 				expectedGroups:  []string{"foo", "bar", "project:my_profile/my_personal_project"},
 				expectedScope:   "openid email read_api profile",
 			}),
+			Entry("project membership valid on group project with a colon separated access level", entitiesTableInput{
+				allowedProjects: []string{"my_group/my_project:30"},
+				expectedAuthz:   true,
+				expectedGroups:  []string{"foo", "bar", "project:my_group/my_project"},
+				expectedScope:   "openid email read_api",
+			}),
+			Entry("project membership valid on inherited group membership", entitiesTableInput{
+				allowedProjects: []string{"my_group/my_inherited_project=40"},
+				expectedAuthz:   true,
+				expectedGroups:  []string{"foo", "bar", "project:my_group/my_inherited_project"},
+				expectedScope:   "openid email read_api",
+			}),
+			Entry("project membership invalid on inherited group membership, insufficient access level", entitiesTableInput{
+				allowedProjects: []string{"my_group/my_inherited_project:50"},
+				expectedAuthz:   false,
+				expectedGroups:  []string{"foo", "bar"},
+				expectedScope:   "openid email read_api",
+			}),
 			Entry("project membership invalid on personnal project, insufficient access level", entitiesTableInput{
 				allowedProjects: []string{"my_profile/my_personal_project=40"},
 				expectedAuthz:   false,
@@ -310,7 +312,12 @@ var _ = Describe("Gitlab Provider Tests", func() {
 			}),
 			Entry("invalid project format", entitiesTableInput{
 				allowedProjects: []string{"my_group/my_invalid_project=123"},
-				expectedError:   errors.New("could not configure allowed projects: invalid gitlab project access level specified (my_group/my_invalid_project)"),
+				expectedError:   errors.New("could not configure allowed projects: invalid gitlab project access level specified for my_group/my_invalid_project (123): must be one of [5 10 20 30 40 50]"),
+				expectedScope:   "openid email read_api",
+			}),
+			Entry("invalid project format with a colon separated access level", entitiesTableInput{
+				allowedProjects: []string{"my_group/my_invalid_project:60"},
+				expectedError:   errors.New("could not configure allowed projects: invalid gitlab project access level specified for my_group/my_invalid_project (60): must be one of [5 10 20 30 40 50]"),
 				expectedScope:   "openid email read_api",
 			}),
 		)
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/url"
 	"os"
 	"strings"
@@ -99,16 +98,20 @@ func NewGoogleProvider(p *ProviderData, opts options.GoogleOptions) (*GoogleProv
 	}
 
 	if opts.ServiceAccountJSON != "" {
-		file, err := os.Open(opts.ServiceAccountJSON)
+		credentials, err := os.ReadFile(opts.ServiceAccountJSON)
 		if err != nil {
 			return nil, fmt.Errorf("invalid Google credentials file: %s", opts.ServiceAccountJSON)
 		}
+		adminService, err := newAdminService(context.Background(), opts.AdminEmail, credentials)
+		if err != nil {
+			return nil, err
+		}
 
 		// Backwards compatibility with `--google-group` option
 		if len(opts.Groups) > 0 {
 			provider.setAllowedGroups(opts.Groups)
 		}
-		provider.setGroupRestriction(opts.Groups, opts.AdminEmail, file)
+		provider.setGroupRestriction(opts.Groups, adminService)
 	}
 
 	return provider, nil
@@ -168,6 +171,7 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code, codeVeri
 
 	err = requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -208,14 +212,9 @@ func (p *GoogleProvider) EnrichSession(_ context.Context, s *sessions.SessionSta
 	return nil
 }
 
-// SetGroupRestriction configures the GoogleProvider to restrict access to the
-// specified group(s). AdminEmail has to be an administrative email on the domain that is
-// checked. CredentialsFile is the path to a json file containing a Google service
-// account credentials.
-//
-// TODO (@NickMeves) - Unit Test this OR refactor away from groupValidator func
-func (p *GoogleProvider) setGroupRestriction(groups []string, adminEmail string, credentialsReader io.Reader) {
-	adminService := getAdminService(adminEmail, credentialsReader)
+// setGroupRestriction configures the GoogleProvider to restrict access to the
+// specified group(s), looking up the memberships with the adminService.
+func (p *GoogleProvider) setGroupRestriction(groups []string, adminService *admin.Service) {
 	p.groupValidator = func(s *sessions.SessionState) bool {
 		// Reset our saved Groups in case membership changed
 		// This is used by `Authorize` on every request
@@ -229,24 +228,25 @@ func (p *GoogleProvider) setGroupRestriction(groups []string, adminEmail string,
 	}
 }
 
-func getAdminService(adminEmail string, credentialsReader io.Reader) *admin.Service {
-	data, err := io.ReadAll(credentialsReader)
-	if err != nil {
-		logger.Fatal("can't read Google credentials file:", err)
-	}
-	conf, err := google.JWTConfigFromJSON(data, admin.AdminDirectoryUserReadonlyScope, admin.AdminDirectoryGroupReadonlyScope)
+// newAdminService creates the Directory API client used for the group
+// lookups from the service account JSON key.
+// The service account must have domain-wide delegation, as it impersonates
+// the adminEmail, which has to be an administrative email on the domain that
+// is checked. The client is created once per provider and reuses its access
+// token until it expires.
+func newAdminService(ctx context.Context, adminEmail string, credentialsJSON []byte, opts ...option.ClientOption) (*admin.Service, error) {
+	conf, err := google.JWTConfigFromJSON(credentialsJSON, admin.AdminDirectoryUserReadonlyScope, admin.AdminDirectoryGroupReadonlyScope)
 	if err != nil {
-		logger.Fatal("can't load Google credentials file:", err)
+		return nil, fmt.Errorf("can't load Google credentials file: %v", err)
 	}
 	conf.Subject = adminEmail
 
-	ctx := context.Background()
-	client := conf.Client(ctx)
-	adminService, err := admin.NewService(ctx, option.WithHTTPClient(client))
+	opts = append([]option.ClientOption{option.WithHTTPClient(conf.Client(ctx))}, opts...)
+	adminService, err := admin.NewService(ctx, opts...)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, fmt.Errorf("can't create Google admin service: %v", err)
 	}
-	return adminService
+	return adminService, nil
 }
 
 func userInGroup(service *admin.Service, group string, email string) bool {
@@ -327,6 +327,7 @@ func (p *GoogleProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 
 	err = requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -2,12 +2,19 @@ package providers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
@@ -288,3 +295,87 @@ func TestGoogleProvider_userInGroup(t *testing.T) {
 	result = userInGroup(service, "group@example.com", "non-member-out-of-domain@otherexample.com")
 	assert.False(t, result)
 }
+
+func newTestServiceAccountJSON(t *testing.T, tokenURL string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "oauth2-proxy@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"token_uri":    tokenURL,
+	})
+	assert.NoError(t, err)
+	return credentials
+}
+
+func TestGoogleProviderAdminServiceImpersonation(t *testing.T) {
+	tokenRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests++
+			assert.NoError(t, r.ParseForm())
+			parts := strings.Split(r.PostForm.Get("assertion"), ".")
+			assert.Len(t, parts, 3)
+			payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+			assert.NoError(t, err)
+
+			var claims map[string]interface{}
+			assert.NoError(t, json.Unmarshal(payload, &claims))
+			assert.Equal(t, "admin@example.com", claims["sub"])
+			assert.Equal(t, "oauth2-proxy@project.iam.gserviceaccount.com", claims["iss"])
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintln(w, `{"access_token": "admin-token", "token_type": "Bearer", "expires_in": 3600}`)
+		case "/admin/directory/v1/groups/group@example.com/hasMember/member@example.com":
+			assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+			fmt.Fprintln(w, `{"isMember": true}`)
+		case "/admin/directory/v1/groups/other@example.com/hasMember/member@example.com":
+			assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+			fmt.Fprintln(w, `{"isMember": false}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	credentials := newTestServiceAccountJSON(t, ts.URL+"/token")
+	service, err := newAdminService(context.Background(), "admin@example.com", credentials, option.WithEndpoint(ts.URL+"/"))
+	assert.NoError(t, err)
+
+	p := newGoogleProvider(t)
+	p.setGroupRestriction([]string{"group@example.com", "other@example.com"}, service)
+
+	session := &sessions.SessionState{Email: "member@example.com"}
+	assert.True(t, p.groupValidator(session))
+	assert.Equal(t, []string{"group@example.com"}, session.Groups)
+
+	// The access token of the impersonated admin is reused
+	assert.True(t, p.groupValidator(session))
+	assert.Equal(t, 1, tokenRequests)
+}
+
+func TestGoogleProviderAdminServiceInvalidCredentials(t *testing.T) {
+	_, err := newAdminService(context.Background(), "admin@example.com", []byte(`{"type": "authorized_user"}`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "can't load Google credentials file")
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	assert.NoError(t, os.WriteFile(credentialsFile, []byte("not json"), 0600))
+
+	_, err = NewGoogleProvider(&ProviderData{}, options.GoogleOptions{
+		AdminEmail:         "admin@example.com",
+		ServiceAccountJSON: credentialsFile,
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "can't load Google credentials file")
+
+	missingFile := filepath.Join(t.TempDir(), "missing.json")
+	_, err = NewGoogleProvider(&ProviderData{}, options.GoogleOptions{
+		AdminEmail:         "admin@example.com",
+		ServiceAccountJSON: missingFile,
+	})
+	assert.EqualError(t, err, "invalid Google credentials file: "+missingFile)
+}
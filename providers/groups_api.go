@@ -0,0 +1,171 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+const groupsAPIDefaultGroupsPath = "groups"
+
+// groupsAPIURLData is the data available to the groups API URL template
+type groupsAPIURLData struct {
+	User              string
+	Email             string
+	PreferredUsername string
+}
+
+// groupsAPIProvider adds the groups fetched from the groups API to the
+// sessions of the wrapped provider, so that they are available to all
+// providers alike. The groups are stored in the session, the API is only
+// called again when a refresh changes the groups of the session.
+type groupsAPIProvider struct {
+	Provider
+	url        *template.Template
+	authMethod string
+	groupsPath []string
+}
+
+// newGroupsAPIProvider wraps the provider to enrich its sessions with the
+// groups returned by the configured endpoint
+func newGroupsAPIProvider(provider Provider, config options.GroupsAPI) (*groupsAPIProvider, error) {
+	if config.URL == "" {
+		return nil, errors.New("invalid groups API: url is required")
+	}
+	url, err := template.New("groupsAPI").Option("missingkey=error").Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid groups API url: %v", err)
+	}
+
+	authMethod := config.AuthMethod
+	switch authMethod {
+	case "":
+		authMethod = options.GroupsAPIAuthBearer
+	case options.GroupsAPIAuthBearer, options.GroupsAPIAuthBasic, options.GroupsAPIAuthNone:
+	default:
+		return nil, fmt.Errorf("invalid groups API auth method %q", authMethod)
+	}
+
+	groupsPath := config.GroupsPath
+	if groupsPath == "" {
+		groupsPath = groupsAPIDefaultGroupsPath
+	}
+
+	return &groupsAPIProvider{
+		Provider:   provider,
+		url:        url,
+		authMethod: authMethod,
+		groupsPath: strings.Split(groupsPath, "."),
+	}, nil
+}
+
+// EnrichSession enriches the session and then adds the groups from the API
+func (p *groupsAPIProvider) EnrichSession(ctx context.Context, s *sessions.SessionState) error {
+	if err := p.Provider.EnrichSession(ctx, s); err != nil {
+		return err
+	}
+	return p.addGroups(ctx, s)
+}
+
+// CreateSessionFromToken creates the session and then adds the groups from the API
+func (p *groupsAPIProvider) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
+	s, err := p.Provider.CreateSessionFromToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.addGroups(ctx, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RefreshSession refreshes the session and fetches the groups again when
+// they were updated by the refresh. Groups that were kept from the existing
+// session already include the groups from the API.
+func (p *groupsAPIProvider) RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	groups := append([]string(nil), s.Groups...)
+
+	refreshed, err := p.Provider.RefreshSession(ctx, s)
+	if err != nil || !refreshed || reflect.DeepEqual(groups, s.Groups) {
+		return refreshed, err
+	}
+	if err := p.addGroups(ctx, s); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// addGroups fetches the groups of the session user and adds those that the
+// session does not have yet
+func (p *groupsAPIProvider) addGroups(ctx context.Context, s *sessions.SessionState) error {
+	groups, err := p.fetchGroups(ctx, s)
+	if err != nil {
+		return fmt.Errorf("could not fetch groups from groups API: %v", err)
+	}
+
+	existing := make(map[string]struct{}, len(s.Groups))
+	for _, group := range s.Groups {
+		existing[group] = struct{}{}
+	}
+	for _, group := range groups {
+		if _, ok := existing[group]; ok || group == "" {
+			continue
+		}
+		existing[group] = struct{}{}
+		s.Groups = append(s.Groups, group)
+	}
+	return nil
+}
+
+func (p *groupsAPIProvider) fetchGroups(ctx context.Context, s *sessions.SessionState) ([]string, error) {
+	var endpoint bytes.Buffer
+	if err := p.url.Execute(&endpoint, groupsAPIURLData{
+		User:              s.User,
+		Email:             s.Email,
+		PreferredUsername: s.PreferredUsername,
+	}); err != nil {
+		return nil, fmt.Errorf("could not render url: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set(acceptHeader, acceptApplicationJSON)
+	switch p.authMethod {
+	case options.GroupsAPIAuthBearer:
+		if s.AccessToken == "" {
+			return nil, errors.New("missing access token")
+		}
+		header = makeOIDCHeader(s.AccessToken)
+	case options.GroupsAPIAuthBasic:
+		clientSecret, err := p.Data().GetClientSecret()
+		if err != nil {
+			return nil, err
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.Data().ClientID + ":" + clientSecret))
+		header = makeAuthorizationHeader("Basic", credentials, map[string]string{acceptHeader: acceptApplicationJSON})
+	}
+
+	json, err := requests.New(endpoint.String()).
+		WithContext(ctx).
+		WithHeaders(header).
+		Do().
+		UnmarshalSimpleJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := json.GetPath(p.groupsPath...).StringArray()
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract groups from %q: %v", strings.Join(p.groupsPath, "."), err)
+	}
+	return groups, nil
+}
@@ -0,0 +1,181 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/gomega"
+)
+
+// newGroupsAPITestServer serves the Nextcloud OCS groups of the users and
+// counts the requests
+func newGroupsAPITestServer(requests *int, authorization *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		*requests++
+		*authorization = req.Header.Get("Authorization")
+
+		switch req.URL.Path {
+		case "/ocs/v2.php/cloud/users/jane/groups":
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Write([]byte(`{"ocs":{"meta":{"status":"ok"},"data":{"groups":["Admins","devs"]}}}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGroupsAPIProvider(t *testing.T) {
+	var requests int
+	var authorization string
+	server := newGroupsAPITestServer(&requests, &authorization)
+	defer server.Close()
+
+	newProviderFor := func(provider Provider, authMethod string) Provider {
+		p, err := newGroupsAPIProvider(provider, options.GroupsAPI{
+			URL:        server.URL + "/ocs/v2.php/cloud/users/{{ urlquery .User }}/groups?format=json",
+			AuthMethod: authMethod,
+			GroupsPath: "ocs.data.groups",
+		})
+		NewWithT(t).Expect(err).ToNot(HaveOccurred())
+		return p
+	}
+	newProvider := func(groups []string, authMethod string) Provider {
+		return newProviderFor(&groupsTestProvider{ProviderData: &ProviderData{ClientID: clientID, ClientSecret: clientSecret}, groups: groups}, authMethod)
+	}
+
+	t.Run("adds the groups from the API after EnrichSession", func(t *testing.T) {
+		g := NewWithT(t)
+		requests = 0
+
+		s := &sessions.SessionState{User: "jane", AccessToken: "access-token"}
+		g.Expect(newProvider([]string{"devs", "users"}, "").EnrichSession(context.Background(), s)).To(Succeed())
+		g.Expect(s.Groups).To(Equal([]string{"devs", "users", "Admins"}))
+		g.Expect(requests).To(Equal(1))
+		g.Expect(authorization).To(Equal("Bearer access-token"))
+	})
+
+	t.Run("authenticates with the client credentials", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s := &sessions.SessionState{User: "jane"}
+		g.Expect(newProvider(nil, options.GroupsAPIAuthBasic).EnrichSession(context.Background(), s)).To(Succeed())
+		g.Expect(s.Groups).To(Equal([]string{"Admins", "devs"}))
+		g.Expect(authorization).To(Equal("Basic YmF6cXV1eDp4eXp6eXBsdWdo"))
+	})
+
+	t.Run("sends no credentials", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s := &sessions.SessionState{User: "jane", AccessToken: "access-token"}
+		g.Expect(newProvider(nil, options.GroupsAPIAuthNone).EnrichSession(context.Background(), s)).To(Succeed())
+		g.Expect(s.Groups).To(Equal([]string{"Admins", "devs"}))
+		g.Expect(authorization).To(BeEmpty())
+	})
+
+	t.Run("fails when the groups cannot be fetched", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s := &sessions.SessionState{User: "john", AccessToken: "access-token"}
+		err := newProvider(nil, "").EnrichSession(context.Background(), s)
+		g.Expect(err).To(MatchError(ContainSubstring("could not fetch groups from groups API: unexpected status \"404\"")))
+		g.Expect(s.Groups).To(BeEmpty())
+	})
+
+	t.Run("adds the groups to sessions created from tokens", func(t *testing.T) {
+		g := NewWithT(t)
+
+		p := newProviderFor(&groupsTokenTestProvider{
+			groupsTestProvider: &groupsTestProvider{ProviderData: &ProviderData{}, groups: []string{"users"}},
+			user:               "jane",
+		}, "")
+
+		s, err := p.CreateSessionFromToken(context.Background(), "access-token")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(s.Groups).To(Equal([]string{"users", "Admins", "devs"}))
+	})
+
+	t.Run("keeps the groups of the session when the refresh does not change them", func(t *testing.T) {
+		g := NewWithT(t)
+		requests = 0
+
+		s := &sessions.SessionState{User: "jane", AccessToken: "access-token", Groups: []string{"users", "Admins"}}
+		refreshed, err := newProvider(nil, "").RefreshSession(context.Background(), s)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refreshed).To(BeTrue())
+		g.Expect(s.Groups).To(Equal([]string{"users", "Admins"}))
+		g.Expect(requests).To(BeZero())
+	})
+
+	t.Run("fetches the groups again when the refresh changes them", func(t *testing.T) {
+		g := NewWithT(t)
+		requests = 0
+
+		s := &sessions.SessionState{User: "jane", AccessToken: "access-token", Groups: []string{"users", "Admins"}}
+		refreshed, err := newProvider([]string{"users"}, "").RefreshSession(context.Background(), s)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refreshed).To(BeTrue())
+		g.Expect(s.Groups).To(Equal([]string{"users", "Admins", "devs"}))
+		g.Expect(requests).To(Equal(1))
+	})
+
+	t.Run("applies to the groups used for authorization", func(t *testing.T) {
+		g := NewWithT(t)
+
+		p := newProvider([]string{"users"}, "")
+		p.Data().setAllowedGroups([]string{"Admins"})
+
+		s := &sessions.SessionState{User: "jane", AccessToken: "access-token"}
+		g.Expect(p.Authorize(context.Background(), s)).To(BeFalse())
+		g.Expect(p.EnrichSession(context.Background(), s)).To(Succeed())
+		g.Expect(p.Authorize(context.Background(), s)).To(BeTrue())
+	})
+}
+
+// groupsTokenTestProvider creates sessions for the user from tokens
+type groupsTokenTestProvider struct {
+	*groupsTestProvider
+	user string
+}
+
+func (p *groupsTokenTestProvider) CreateSessionFromToken(_ context.Context, token string) (*sessions.SessionState, error) {
+	return &sessions.SessionState{User: p.user, AccessToken: token, Groups: p.groups}, nil
+}
+
+func TestNewGroupsAPIProviderInvalid(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := newGroupsAPIProvider(nil, options.GroupsAPI{})
+	g.Expect(err).To(MatchError("invalid groups API: url is required"))
+
+	_, err = newGroupsAPIProvider(nil, options.GroupsAPI{URL: "https://example.com/{{ .User }"})
+	g.Expect(err).To(MatchError(ContainSubstring("invalid groups API url")))
+
+	_, err = newGroupsAPIProvider(nil, options.GroupsAPI{URL: "https://example.com/groups", AuthMethod: "token"})
+	g.Expect(err).To(MatchError("invalid groups API auth method \"token\""))
+}
+
+func TestNewProviderWithGroupsAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	providerConfig := options.Provider{
+		ID:           providerID,
+		Type:         "nextcloud",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		GroupsAPI: &options.GroupsAPI{
+			URL:        "https://cloud.example.com/ocs/v2.php/cloud/users/{{ urlquery .User }}/groups?format=json",
+			GroupsPath: "ocs.data.groups",
+		},
+		GroupsTransforms: []options.GroupsTransform{{Lowercase: true}},
+	}
+
+	p, err := NewProvider(providerConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(p).To(BeAssignableToTypeOf(&groupsTransformProvider{}))
+	g.Expect(p.(*groupsTransformProvider).Provider).To(BeAssignableToTypeOf(&groupsAPIProvider{}))
+	g.Expect(p.Data().ProviderName).To(Equal("Nextcloud"))
+}
@@ -0,0 +1,130 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// groupsTransform changes a single group, an empty result removes the group
+type groupsTransform func(group string) string
+
+// newGroupsTransforms builds the transforms from the configuration in order.
+// Each transform must have exactly one of its fields set.
+func newGroupsTransforms(config []options.GroupsTransform) ([]groupsTransform, error) {
+	transforms := make([]groupsTransform, 0, len(config))
+	for i, c := range config {
+		transform, err := newGroupsTransform(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid groups transform %d: %v", i, err)
+		}
+		transforms = append(transforms, transform)
+	}
+	return transforms, nil
+}
+
+func newGroupsTransform(c options.GroupsTransform) (groupsTransform, error) {
+	set := 0
+	var transform groupsTransform
+	if c.StripPrefix != "" {
+		set++
+		prefix := c.StripPrefix
+		transform = func(group string) string {
+			return strings.TrimPrefix(group, prefix)
+		}
+	}
+	if c.Lowercase {
+		set++
+		transform = strings.ToLower
+	}
+	if len(c.Mapping) > 0 {
+		set++
+		mapping := c.Mapping
+		transform = func(group string) string {
+			if mapped, ok := mapping[group]; ok {
+				return mapped
+			}
+			return group
+		}
+	}
+
+	switch set {
+	case 0:
+		return nil, errors.New("one of stripPrefix, lowercase or mapping must be set")
+	case 1:
+		return transform, nil
+	default:
+		return nil, errors.New("only one of stripPrefix, lowercase or mapping may be set, use multiple transforms to combine them")
+	}
+}
+
+// transformGroups applies the transforms in order to each group. Groups that
+// become empty are removed, as are duplicate groups.
+func transformGroups(groups []string, transforms []groupsTransform) []string {
+	if groups == nil {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(groups))
+	transformed := make([]string, 0, len(groups))
+	for _, group := range groups {
+		for _, transform := range transforms {
+			group = transform(group)
+		}
+		if group == "" {
+			continue
+		}
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		seen[group] = struct{}{}
+		transformed = append(transformed, group)
+	}
+	return transformed
+}
+
+// groupsTransformProvider applies the groups transforms to the sessions of
+// the wrapped provider, so that they apply to all providers alike.
+// Groups are transformed once the session is complete, after EnrichSession,
+// rather than after Redeem so that they are not transformed twice.
+type groupsTransformProvider struct {
+	Provider
+	transforms []groupsTransform
+}
+
+// EnrichSession enriches the session and then transforms its groups
+func (p *groupsTransformProvider) EnrichSession(ctx context.Context, s *sessions.SessionState) error {
+	if err := p.Provider.EnrichSession(ctx, s); err != nil {
+		return err
+	}
+	s.Groups = transformGroups(s.Groups, p.transforms)
+	return nil
+}
+
+// CreateSessionFromToken creates the session and then transforms its groups
+func (p *groupsTransformProvider) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
+	s, err := p.Provider.CreateSessionFromToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	s.Groups = transformGroups(s.Groups, p.transforms)
+	return s, nil
+}
+
+// RefreshSession refreshes the session and transforms its groups when they
+// were updated by the refresh. Groups that were kept from the existing
+// session have already been transformed.
+func (p *groupsTransformProvider) RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	groups := append([]string(nil), s.Groups...)
+
+	refreshed, err := p.Provider.RefreshSession(ctx, s)
+	if refreshed && !reflect.DeepEqual(groups, s.Groups) {
+		s.Groups = transformGroups(s.Groups, p.transforms)
+	}
+	return refreshed, err
+}
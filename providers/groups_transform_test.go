@@ -0,0 +1,176 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/gomega"
+)
+
+func TestTransformGroups(t *testing.T) {
+	testCases := map[string]struct {
+		transforms     []options.GroupsTransform
+		groups         []string
+		expectedGroups []string
+	}{
+		"strip prefix": {
+			transforms:     []options.GroupsTransform{{StripPrefix: "/"}},
+			groups:         []string{"/parent/child", "/admins", "users"},
+			expectedGroups: []string{"parent/child", "admins", "users"},
+		},
+		"lowercase": {
+			transforms:     []options.GroupsTransform{{Lowercase: true}},
+			groups:         []string{"Admins", "DEVS"},
+			expectedGroups: []string{"admins", "devs"},
+		},
+		"mapping": {
+			transforms: []options.GroupsTransform{{Mapping: map[string]string{
+				"6f4a3c1e-8b2d-4e5f-9a7b-1c2d3e4f5a6b": "admins",
+			}}},
+			groups:         []string{"6f4a3c1e-8b2d-4e5f-9a7b-1c2d3e4f5a6b", "0a1b2c3d-unmapped"},
+			expectedGroups: []string{"admins", "0a1b2c3d-unmapped"},
+		},
+		"composed in order": {
+			transforms: []options.GroupsTransform{
+				{StripPrefix: "/Engineering/"},
+				{Lowercase: true},
+				{Mapping: map[string]string{"platform": "platform-team"}},
+			},
+			groups:         []string{"/Engineering/Platform", "/Sales"},
+			expectedGroups: []string{"platform-team", "/sales"},
+		},
+		"removes empty and duplicate groups": {
+			transforms:     []options.GroupsTransform{{StripPrefix: "role:"}, {Lowercase: true}},
+			groups:         []string{"role:", "role:Admin", "admin", ""},
+			expectedGroups: []string{"admin"},
+		},
+		"without groups": {
+			transforms:     []options.GroupsTransform{{Lowercase: true}},
+			groups:         nil,
+			expectedGroups: nil,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			transforms, err := newGroupsTransforms(tc.transforms)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(transformGroups(tc.groups, transforms)).To(Equal(tc.expectedGroups))
+		})
+	}
+}
+
+func TestNewGroupsTransformsInvalid(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := newGroupsTransforms([]options.GroupsTransform{{Lowercase: true}, {}})
+	g.Expect(err).To(MatchError("invalid groups transform 1: one of stripPrefix, lowercase or mapping must be set"))
+
+	_, err = newGroupsTransforms([]options.GroupsTransform{{StripPrefix: "/", Lowercase: true}})
+	g.Expect(err).To(MatchError(ContainSubstring("only one of stripPrefix, lowercase or mapping may be set")))
+}
+
+// groupsTestProvider sets the groups it is given on sessions
+type groupsTestProvider struct {
+	*ProviderData
+	groups []string
+}
+
+func (p *groupsTestProvider) EnrichSession(_ context.Context, s *sessions.SessionState) error {
+	s.Groups = append(s.Groups, p.groups...)
+	return nil
+}
+
+func (p *groupsTestProvider) CreateSessionFromToken(_ context.Context, _ string) (*sessions.SessionState, error) {
+	return &sessions.SessionState{Groups: p.groups}, nil
+}
+
+func (p *groupsTestProvider) RefreshSession(_ context.Context, s *sessions.SessionState) (bool, error) {
+	if p.groups != nil {
+		s.Groups = p.groups
+	}
+	return true, nil
+}
+
+func TestGroupsTransformProvider(t *testing.T) {
+	transforms, err := newGroupsTransforms([]options.GroupsTransform{
+		{StripPrefix: "/"},
+		{Mapping: map[string]string{"admins": "/admins"}},
+	})
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	newProvider := func(groups []string) Provider {
+		return &groupsTransformProvider{
+			Provider:   &groupsTestProvider{ProviderData: &ProviderData{}, groups: groups},
+			transforms: transforms,
+		}
+	}
+
+	t.Run("transforms the groups after EnrichSession", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s := &sessions.SessionState{Groups: []string{"/devs"}}
+		g.Expect(newProvider([]string{"/admins"}).EnrichSession(context.Background(), s)).To(Succeed())
+		g.Expect(s.Groups).To(Equal([]string{"devs", "/admins"}))
+	})
+
+	t.Run("transforms the groups of sessions created from tokens", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s, err := newProvider([]string{"/admins", "/devs"}).CreateSessionFromToken(context.Background(), "token")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(s.Groups).To(Equal([]string{"/admins", "devs"}))
+	})
+
+	t.Run("transforms refreshed groups", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s := &sessions.SessionState{Groups: []string{"/admins"}}
+		refreshed, err := newProvider([]string{"/devs"}).RefreshSession(context.Background(), s)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refreshed).To(BeTrue())
+		g.Expect(s.Groups).To(Equal([]string{"devs"}))
+	})
+
+	t.Run("does not transform groups kept by the refresh twice", func(t *testing.T) {
+		g := NewWithT(t)
+
+		s := &sessions.SessionState{Groups: []string{"/admins"}}
+		refreshed, err := newProvider(nil).RefreshSession(context.Background(), s)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refreshed).To(BeTrue())
+		g.Expect(s.Groups).To(Equal([]string{"/admins"}))
+	})
+
+	t.Run("applies to the groups used for authorization", func(t *testing.T) {
+		g := NewWithT(t)
+
+		p := newProvider([]string{"/Admins"})
+		p.Data().setAllowedGroups([]string{"Admins"})
+
+		s := &sessions.SessionState{}
+		g.Expect(p.EnrichSession(context.Background(), s)).To(Succeed())
+		g.Expect(p.Authorize(context.Background(), s)).To(BeTrue())
+	})
+}
+
+func TestNewProviderWithGroupsTransforms(t *testing.T) {
+	g := NewWithT(t)
+
+	providerConfig := options.Provider{
+		ID:               providerID,
+		Type:             "google",
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		GroupsTransforms: []options.GroupsTransform{{Lowercase: true}},
+	}
+
+	p, err := NewProvider(providerConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(p).To(BeAssignableToTypeOf(&groupsTransformProvider{}))
+	g.Expect(p.Data().ProviderName).To(Equal("Google"))
+}
@@ -13,6 +13,9 @@ const keycloakOIDCProviderName = "Keycloak OIDC"
 // KeycloakOIDCProvider creates a Keycloak provider based on OIDCProvider
 type KeycloakOIDCProvider struct {
 	*OIDCProvider
+
+	// requiredRoles are the `role:` prefixed roles a session must all have
+	requiredRoles []string
 }
 
 // NewKeycloakOIDCProvider makes a KeycloakOIDCProvider using the ProviderData
@@ -28,6 +31,9 @@ func NewKeycloakOIDCProvider(p *ProviderData, opts options.KeycloakOptions) *Key
 	}
 
 	provider.addAllowedRoles(opts.Roles)
+	for _, role := range opts.RequiredRoles {
+		provider.requiredRoles = append(provider.requiredRoles, formatRole(role))
+	}
 	return provider
 }
 
@@ -45,6 +51,26 @@ func (p *KeycloakOIDCProvider) addAllowedRoles(roles []string) {
 	}
 }
 
+// Authorize checks the session is allowed by the groups and roles of the
+// provider and has all of the required roles
+func (p *KeycloakOIDCProvider) Authorize(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	authorized, err := p.OIDCProvider.Authorize(ctx, s)
+	if err != nil || !authorized {
+		return authorized, err
+	}
+
+	roles := make(map[string]struct{}, len(s.Groups))
+	for _, group := range s.Groups {
+		roles[group] = struct{}{}
+	}
+	for _, role := range p.requiredRoles {
+		if _, ok := roles[role]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // CreateSessionFromToken converts Bearer IDTokens into sessions
 func (p *KeycloakOIDCProvider) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
 	ss, err := p.OIDCProvider.CreateSessionFromToken(ctx, token)
@@ -149,11 +175,11 @@ func getClientRoles(claims *accessClaims) []string {
 			continue
 		}
 
-		var roles interface{}
-		if roles, ok = accessMap["roles"]; !ok {
+		roles, ok := accessMap["roles"].([]interface{})
+		if !ok {
 			continue
 		}
-		for _, role := range roles.([]interface{}) {
+		for _, role := range roles {
 			clientRoles = append(clientRoles, fmt.Sprintf("%s:%s", clientName, role))
 		}
 	}
@@ -3,6 +3,7 @@ package providers
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http/httptest"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
@@ -217,4 +219,95 @@ var _ = Describe("Keycloak OIDC Provider Tests", func() {
 		})
 	})
 
+	Context("Client Roles", func() {
+		It("should extract the roles of each client in resource_access", func() {
+			var claims *accessClaims
+			Expect(json.Unmarshal([]byte(`{
+				"realm_access": {"roles": ["offline_access", "uma_authorization"]},
+				"resource_access": {
+					"myclient": {"roles": ["editor", "viewer"]},
+					"account": {"roles": ["manage-account", "view-profile"]},
+					"no-roles": {},
+					"invalid-roles": {"roles": "editor"},
+					"invalid-client": ["editor"]
+				}
+			}`), &claims)).To(Succeed())
+
+			Expect(getClientRoles(claims)).To(ConsistOf(
+				"myclient:editor",
+				"myclient:viewer",
+				"account:manage-account",
+				"account:view-profile",
+			))
+		})
+	})
+
+	Context("Required Roles", func() {
+		type requiredRolesTableInput struct {
+			opts       options.KeycloakOptions
+			groups     []string
+			authorized bool
+		}
+
+		DescribeTable("Authorize",
+			func(in requiredRolesTableInput) {
+				p := newKeycloakOIDCProvider(nil, in.opts)
+				authorized, err := p.Authorize(context.Background(), &sessions.SessionState{Groups: in.groups})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(authorized).To(Equal(in.authorized))
+			},
+			Entry("with no required roles", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{},
+				groups:     []string{"role:write"},
+				authorized: true,
+			}),
+			Entry("with the required realm and client roles", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{RequiredRoles: []string{"write", "myclient:editor"}},
+				groups:     []string{"role:write", "role:myclient:editor", "role:myclient:viewer"},
+				authorized: true,
+			}),
+			Entry("without the required client role", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{RequiredRoles: []string{"write", "myclient:editor"}},
+				groups:     []string{"role:write", "role:myclient:viewer"},
+				authorized: false,
+			}),
+			Entry("without the required realm role", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{RequiredRoles: []string{"write", "myclient:editor"}},
+				groups:     []string{"role:myclient:editor"},
+				authorized: false,
+			}),
+			Entry("with a realm role of the same name as the client role", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{RequiredRoles: []string{"myclient:editor"}},
+				groups:     []string{"role:editor", "role:otherclient:editor"},
+				authorized: false,
+			}),
+			Entry("with the required roles but not an allowed role", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{Roles: []string{"admin"}, RequiredRoles: []string{"myclient:editor"}},
+				groups:     []string{"role:myclient:editor"},
+				authorized: false,
+			}),
+			Entry("with the required roles and an allowed role", requiredRolesTableInput{
+				opts:       options.KeycloakOptions{Roles: []string{"admin"}, RequiredRoles: []string{"myclient:editor"}},
+				groups:     []string{"role:admin", "role:myclient:editor"},
+				authorized: true,
+			}),
+		)
+
+		It("should authorize a session created from the token", func() {
+			server, provider := newTestKeycloakOIDCSetup()
+			defer server.Close()
+			provider.requiredRoles = []string{"role:write", "role:default:read"}
+
+			session, err := provider.CreateSessionFromToken(context.Background(), getAccessToken())
+			Expect(err).ToNot(HaveOccurred())
+			authorized, err := provider.Authorize(context.Background(), session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(authorized).To(BeTrue())
+
+			provider.requiredRoles = append(provider.requiredRoles, "role:default:write")
+			authorized, err = provider.Authorize(context.Background(), session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(authorized).To(BeFalse())
+		})
+	})
 })
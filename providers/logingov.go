@@ -238,6 +238,7 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, _, code, codeVerifier str
 	}
 	err = requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -273,14 +274,17 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, _, code, codeVerifier str
 }
 
 // GetLoginURL overrides GetLoginURL to add login.gov parameters
-func (p *LoginGovProvider) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) string {
+func (p *LoginGovProvider) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) (string, error) {
 	if len(extraParams["acr_values"]) == 0 {
 		acr := "http://idmanagement.gov/ns/assurance/loa/1"
 		extraParams.Add("acr_values", acr)
 	}
 	extraParams.Add("nonce", p.Nonce)
-	a := makeLoginURL(p.ProviderData, redirectURI, state, extraParams)
-	return a.String()
+	a, err := makeLoginURL(p.ProviderData, redirectURI, state, extraParams)
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
 }
 
 // ValidateSession validates the AccessToken
@@ -41,12 +41,15 @@ func NewOIDCProvider(p *ProviderData, opts options.OIDCOptions) *OIDCProvider {
 var _ Provider = (*OIDCProvider)(nil)
 
 // GetLoginURL makes the LoginURL with optional nonce support
-func (p *OIDCProvider) GetLoginURL(redirectURI, state, nonce string, extraParams url.Values) string {
+func (p *OIDCProvider) GetLoginURL(redirectURI, state, nonce string, extraParams url.Values) (string, error) {
 	if !p.SkipNonce {
 		extraParams.Add("nonce", nonce)
 	}
-	loginURL := makeLoginURL(p.Data(), redirectURI, state, extraParams)
-	return loginURL.String()
+	loginURL, err := makeLoginURL(p.Data(), redirectURI, state, extraParams)
+	if err != nil {
+		return "", err
+	}
+	return loginURL.String(), nil
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
@@ -69,7 +72,7 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifi
 		},
 		RedirectURL: redirectURL,
 	}
-	token, err := c.Exchange(ctx, code, opts...)
+	token, err := c.Exchange(p.tokenContext(ctx), code, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange failed: %v", err)
 	}
@@ -77,6 +80,21 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifi
 	return p.createSession(ctx, token, false)
 }
 
+// RedeemDeviceCode exchanges an authorized device code for an ID token
+func (p *OIDCProvider) RedeemDeviceCode(ctx context.Context, deviceCode string) (*sessions.SessionState, error) {
+	token, err := p.redeemDeviceCode(ctx, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := p.createSession(ctx, token, false)
+	if err != nil {
+		return nil, err
+	}
+	ss.DeviceGrant = true
+	return ss, nil
+}
+
 // EnrichSession is called after Redeem to allow providers to enrich session fields
 // such as User, Email, Groups with provider specific API calls.
 func (p *OIDCProvider) EnrichSession(ctx context.Context, s *sessions.SessionState) error {
@@ -95,7 +113,9 @@ func (p *OIDCProvider) ValidateSession(ctx context.Context, s *sessions.SessionS
 		return false
 	}
 
-	if p.SkipNonce {
+	// Sessions created by the device authorization grant have no nonce to
+	// compare against, as the ID token was not requested by the browser
+	if p.SkipNonce || s.DeviceGrant {
 		return true
 	}
 	err = p.checkNonce(s)
@@ -115,7 +135,7 @@ func (p *OIDCProvider) RefreshSession(ctx context.Context, s *sessions.SessionSt
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	return true, nil
@@ -140,9 +160,9 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 		RefreshToken: s.RefreshToken,
 		Expiry:       time.Now().Add(-time.Hour),
 	}
-	token, err := c.TokenSource(ctx, t).Token()
+	token, err := c.TokenSource(p.tokenContext(ctx), t).Token()
 	if err != nil {
-		return fmt.Errorf("failed to get token: %v", err)
+		return fmt.Errorf("failed to get token: %w", err)
 	}
 
 	newSession, err := p.createSession(ctx, token, true)
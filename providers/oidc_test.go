@@ -96,11 +96,13 @@ func TestOIDCProviderGetLoginURL(t *testing.T) {
 	nonce := base64.RawURLEncoding.EncodeToString(n)
 
 	// SkipNonce defaults to true
-	skipNonce := provider.GetLoginURL("http://redirect/", "", nonce, url.Values{})
+	skipNonce, err := provider.GetLoginURL("http://redirect/", "", nonce, url.Values{})
+	assert.NoError(t, err)
 	assert.NotContains(t, skipNonce, "nonce")
 
 	provider.SkipNonce = false
-	withNonce := provider.GetLoginURL("http://redirect/", "", nonce, url.Values{})
+	withNonce, err := provider.GetLoginURL("http://redirect/", "", nonce, url.Values{})
+	assert.NoError(t, err)
 	assert.Contains(t, withNonce, fmt.Sprintf("nonce=%s", nonce))
 	assert.NotContains(t, withNonce, "code_challenge")
 	assert.NotContains(t, withNonce, "code_challenge_method")
@@ -147,6 +149,41 @@ func TestOIDCProviderRedeem_custom_userid(t *testing.T) {
 	assert.Equal(t, defaultIDToken.Phone, session.Email)
 }
 
+func TestOIDCProviderValidateSessionNonce(t *testing.T) {
+	idToken, err := newSignedTestIDToken(defaultIDToken)
+	assert.NoError(t, err)
+
+	server, provider := newTestOIDCSetup([]byte(`{}`))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		session  *sessions.SessionState
+		expected bool
+	}{
+		"matching nonce": {
+			session:  &sessions.SessionState{IDToken: idToken, Nonce: []byte(oidcNonce)},
+			expected: true,
+		},
+		"mismatched nonce": {
+			session:  &sessions.SessionState{IDToken: idToken, Nonce: []byte("WrongWrongWrong")},
+			expected: false,
+		},
+		"missing nonce": {
+			session:  &sessions.SessionState{IDToken: idToken},
+			expected: false,
+		},
+		"device grant without nonce": {
+			session:  &sessions.SessionState{IDToken: idToken, DeviceGrant: true},
+			expected: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, provider.ValidateSession(context.Background(), tc.session))
+		})
+	}
+}
+
 func TestOIDCProviderRefreshSessionIfNeededWithoutIdToken(t *testing.T) {
 
 	idToken, _ := newSignedTestIDToken(defaultIDToken)
@@ -1,7 +1,9 @@
 package providers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,13 +11,20 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	clockapi "github.com/benbjohnson/clock"
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/util"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/watcher"
 	"golang.org/x/oauth2"
 )
 
@@ -33,10 +42,16 @@ type ProviderData struct {
 	ProfileURL        *url.URL
 	ProtectedResource *url.URL
 	ValidateURL       *url.URL
-	ClientID          string
-	ClientSecret      string
-	ClientSecretFile  string
-	Scope             string
+	// DeviceAuthorizationURL is the RFC 8628 device authorization endpoint
+	DeviceAuthorizationURL *url.URL
+	// RevocationURL is the RFC 7009 token revocation endpoint
+	RevocationURL *url.URL
+	// EndSessionURL is the OIDC end_session_endpoint for RP-initiated logout
+	EndSessionURL    *url.URL
+	ClientID         string
+	ClientSecret     string
+	ClientSecretFile string
+	Scope            string
 	// The picked CodeChallenge Method or empty if none.
 	CodeChallengeMethod string
 	// Code challenge methods supported by the Provider
@@ -45,14 +60,47 @@ type ProviderData struct {
 	// Common OIDC options for any OIDC-based providers to consume
 	AllowUnverifiedEmail bool
 	UserClaim            string
-	EmailClaim           string
-	GroupsClaim          string
-	Verifier             internaloidc.IDTokenVerifier
+	// UserClaims, when set, are tried in order for the user instead of
+	// UserClaim, and the first non-empty string value is used
+	UserClaims  []string
+	EmailClaim  string
+	GroupsClaim string
+	Verifier    internaloidc.IDTokenVerifier
 
 	// Universal Group authorization data structure
 	// any provider can set to consume
 	AllowedGroups map[string]struct{}
 
+	// Groups read from the allowed groups file, kept up to date while the
+	// file is watched. When nil, no allowed groups file is configured.
+	fileAllowedGroups *groupSet
+
+	// Ordered allow and deny rules over the session claims, when set the
+	// session must also be allowed by these rules
+	AuthorizationRules authorization.Rules
+
+	// Client used for calls to the token endpoint
+	tokenClient *http.Client
+
+	// Set when the token client authenticates with a client assertion
+	// instead of the client secret
+	clientAssertion bool
+
+	// Client secret read from ClientSecretFile, kept up to date while the
+	// file is watched. When nil the file is read on every use.
+	fileClientSecret atomic.Pointer[string]
+
+	// Signs the login URL parameters as a request object when set
+	requestObjectSigner *requestObjectSigner
+
+	// Clock used for token expiries and file polling, the zero value uses the
+	// global clock
+	clock clock.Clock
+
+	// Closed to stop watching the client secret and allowed groups files
+	watchDone        chan bool
+	stopWatchingOnce sync.Once
+
 	getAuthorizationHeaderFunc func(string) http.Header
 	loginURLParameterDefaults  url.Values
 	loginURLParameterOverrides map[string]*regexp.Regexp
@@ -66,6 +114,24 @@ func (p *ProviderData) GetClientSecret() (clientSecret string, err error) {
 		return p.ClientSecret, nil
 	}
 
+	if secret := p.fileClientSecret.Load(); secret != nil {
+		return *secret, nil
+	}
+	return p.readClientSecretFile()
+}
+
+// StopWatching stops watching and polling the client secret and allowed
+// groups files. The values last read from the files continue to be used.
+func (p *ProviderData) StopWatching() {
+	p.stopWatchingOnce.Do(func() {
+		if p.watchDone != nil {
+			close(p.watchDone)
+		}
+	})
+}
+
+// readClientSecretFile reads the client secret from the ClientSecretFile
+func (p *ProviderData) readClientSecretFile() (string, error) {
 	// Getting ClientSecret can fail in runtime so we need to report it without returning the file name to the user
 	fileClientSecret, err := os.ReadFile(p.ClientSecretFile)
 	if err != nil {
@@ -75,6 +141,86 @@ func (p *ProviderData) GetClientSecret() (clientSecret string, err error) {
 	return string(fileClientSecret), nil
 }
 
+// watchClientSecretFile keeps the client secret up to date as the
+// ClientSecretFile is rotated. Changes are picked up by watching the file and,
+// for filesystems where watching is not supported, by polling the file every
+// pollInterval if it is greater than zero.
+// If the file can be neither watched nor polled, it is read on every use.
+// Watching and polling stop once StopWatching is called.
+func (p *ProviderData) watchClientSecretFile(pollInterval time.Duration) {
+	secret, err := p.readClientSecretFile()
+	if err != nil {
+		return
+	}
+	p.fileClientSecret.Store(&secret)
+
+	if err := watcher.WatchFileForUpdates(p.ClientSecretFile, p.watchDone, p.reloadClientSecretFile); err != nil {
+		if pollInterval <= 0 {
+			logger.Errorf("unable to watch client secret file, it will be read on every use: %v", err)
+			p.fileClientSecret.Store(nil)
+			return
+		}
+		logger.Errorf("unable to watch client secret file, falling back to polling every %s: %v", pollInterval, err)
+	}
+
+	if pollInterval > 0 {
+		go p.pollClientSecretFile(p.clock.Ticker(pollInterval))
+	}
+}
+
+// pollClientSecretFile reloads the ClientSecretFile on every tick until
+// StopWatching is called.
+func (p *ProviderData) pollClientSecretFile(ticker *clockapi.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.watchDone:
+			return
+		case <-ticker.C:
+			p.reloadClientSecretFile()
+		}
+	}
+}
+
+// reloadClientSecretFile reads the ClientSecretFile and atomically replaces
+// the client secret if it has changed.
+func (p *ProviderData) reloadClientSecretFile() {
+	secret, err := p.readClientSecretFile()
+	if err != nil {
+		logger.Errorf("%v: the current client secret will continue to be used", err)
+		return
+	}
+	if secret == "" {
+		// The file may be truncated while it is being rewritten
+		logger.Errorf("client secret file %s is empty: the current client secret will continue to be used", p.ClientSecretFile)
+		return
+	}
+
+	if current := p.fileClientSecret.Load(); current != nil && *current == secret {
+		return
+	}
+	p.fileClientSecret.Store(&secret)
+	logger.Printf("client secret rotation detected, reloaded client secret from %s", p.ClientSecretFile)
+}
+
+// getTokenClient returns the client used for calls to the token endpoint.
+// This retries transient failures when token retries are configured.
+func (p *ProviderData) getTokenClient() *http.Client {
+	if p.tokenClient == nil {
+		return http.DefaultClient
+	}
+	return p.tokenClient
+}
+
+// tokenContext returns a context that makes the oauth2 library use the token
+// client for calls to the token endpoint.
+func (p *ProviderData) tokenContext(ctx context.Context) context.Context {
+	if p.tokenClient == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, p.tokenClient)
+}
+
 // LoginURLParams returns the parameter values that should be passed to the IdP
 // login URL.  This is the default set of parameters configured for this provider,
 // optionally overridden by the given overrides (typically from the URL of the
@@ -105,6 +251,42 @@ func (p *ProviderData) LoginURLParams(overrides url.Values) url.Values {
 	return params
 }
 
+// GetEndSessionURL returns the URL to redirect the user to so that they are
+// also logged out at the provider (OIDC RP-initiated logout).
+// An empty string is returned when the provider has no end session endpoint.
+func (p *ProviderData) GetEndSessionURL(idToken, postLogoutRedirectURI string) string {
+	if p.EndSessionURL == nil || p.EndSessionURL.String() == "" {
+		return ""
+	}
+
+	a := *p.EndSessionURL
+	params, _ := url.ParseQuery(a.RawQuery)
+	if idToken != "" {
+		params.Set("id_token_hint", idToken)
+	}
+	params.Set("client_id", p.ClientID)
+	if postLogoutRedirectURI != "" {
+		params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
+	a.RawQuery = params.Encode()
+	return a.String()
+}
+
+// reservedLoginURLParameters are set on the IdP login URL by the proxy itself,
+// they cannot be passed from the start URL as they would override the values
+// used to complete the login.
+var reservedLoginURLParameters = map[string]struct{}{
+	"client_id":             {},
+	"code_challenge":        {},
+	"code_challenge_method": {},
+	"nonce":                 {},
+	"redirect_uri":          {},
+	"request":               {},
+	"response_type":         {},
+	"scope":                 {},
+	"state":                 {},
+}
+
 // Compile the given set of LoginURLParameter options into the internal defaults
 // and regular expressions used to validate any overrides.
 func (p *ProviderData) compileLoginParams(paramConfig []options.LoginURLParameter) []error {
@@ -122,6 +304,10 @@ func (p *ProviderData) compileLoginParams(paramConfig []options.LoginURLParamete
 			}
 			// record allow rules if any
 			if len(param.Allow) > 0 {
+				if _, reserved := reservedLoginURLParameters[param.Name]; reserved {
+					errs = append(errs, fmt.Errorf("parameter %s is set by the proxy and cannot be allowed in loginURLParameters", param.Name))
+					continue
+				}
 				errs = p.convertAllowRules(errs, param)
 			}
 		}
@@ -178,6 +364,93 @@ func (p *ProviderData) setAllowedGroups(groups []string) {
 	}
 }
 
+// watchAllowedGroupsFile loads the allowed groups from the file and reloads
+// them whenever the file changes.
+// If a reload fails, the groups that were last loaded remain allowed.
+func (p *ProviderData) watchAllowedGroupsFile(filename string) error {
+	groups, err := readAllowedGroupsFile(filename)
+	if err != nil {
+		return err
+	}
+	p.fileAllowedGroups = &groupSet{}
+	p.fileAllowedGroups.set(groups)
+	logger.Printf("loaded %d allowed groups from %s", len(groups), filename)
+
+	reload := func() {
+		groups, err := readAllowedGroupsFile(filename)
+		if err != nil {
+			logger.Errorf("error reloading allowed groups, keeping the previous groups: %v", err)
+			return
+		}
+		p.fileAllowedGroups.set(groups)
+		logger.Printf("reloaded %d allowed groups from %s", len(groups), filename)
+	}
+	if err := watcher.WatchFileForUpdates(filename, p.watchDone, reload); err != nil {
+		logger.Errorf("unable to watch allowed groups file, changes will not be reloaded: %v", err)
+	}
+	return nil
+}
+
+// readAllowedGroupsFile reads the groups from the file, either as a JSON array
+// of groups or with one group per line.
+// Blank lines and lines starting with `#` are ignored in the line format.
+func readAllowedGroupsFile(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read allowed groups file: %v", err)
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var groups []string
+		if err := json.Unmarshal(data, &groups); err != nil {
+			return nil, fmt.Errorf("could not parse allowed groups file %s as a JSON array: %v", filename, err)
+		}
+		return groups, nil
+	}
+
+	var groups []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		groups = append(groups, line)
+	}
+	return groups, nil
+}
+
+// groupSet is a set of groups that can be replaced while it is being read.
+type groupSet struct {
+	mu     sync.RWMutex
+	groups map[string]struct{}
+}
+
+// set replaces the groups in the set.
+func (g *groupSet) set(groups []string) {
+	m := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		m[group] = struct{}{}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.groups = m
+}
+
+// has checks whether the group is in the set.
+// A nil set has no groups.
+func (g *groupSet) has(group string) bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.groups[group]
+	return ok
+}
+
 type providerDefaults struct {
 	name        string
 	loginURL    *url.URL
@@ -263,6 +536,13 @@ func (p *ProviderData) buildSessionFromClaims(rawIDToken, accessToken string) (*
 		}
 	}
 
+	if len(p.UserClaims) > 0 {
+		ss.User, err = getUserFromClaims(extractor, p.UserClaims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// `email_verified` must be present and explicitly set to `false` to be
 	// considered unverified.
 	verifyEmail := (p.EmailClaim == options.OIDCEmailClaim) && !p.AllowUnverifiedEmail
@@ -282,6 +562,29 @@ func (p *ProviderData) buildSessionFromClaims(rawIDToken, accessToken string) (*
 	return ss, nil
 }
 
+// getUserFromClaims returns the value of the first of the claims that is
+// present with a non-empty value. The claims must be strings.
+func getUserFromClaims(extractor util.ClaimExtractor, claims []string) (string, error) {
+	for _, claim := range claims {
+		value, exists, err := extractor.GetClaim(claim)
+		if err != nil {
+			return "", err
+		}
+		if !exists || value == nil {
+			continue
+		}
+
+		user, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("username claim %q must be a string, got %T", claim, value)
+		}
+		if user != "" {
+			return user, nil
+		}
+	}
+	return "", fmt.Errorf("none of the username claims %q are present in the token", claims)
+}
+
 func (p *ProviderData) getClaimExtractor(rawIDToken, accessToken string) (util.ClaimExtractor, error) {
 	extractor, err := util.NewClaimExtractor(context.TODO(), rawIDToken, p.ProfileURL, p.getAuthorizationHeader(accessToken))
 	if err != nil {
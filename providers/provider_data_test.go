@@ -94,6 +94,22 @@ var (
 		StandardClaims: standardClaims,
 	}
 
+	nestedClaimsIDToken = idTokenClaims{
+		Name: "Nested Claims",
+		Namespaced: map[string]interface{}{
+			"identity": map[string]interface{}{
+				"username": "nested-user",
+				"emails":   []string{"nested@claims.com", "other@claims.com"},
+			},
+			"orgs": []interface{}{
+				map[string]interface{}{"name": "org1", "groups": []string{"admins"}},
+				map[string]interface{}{"name": "org2", "groups": []string{"developers", "testers"}},
+			},
+		},
+		Verified:       &verified,
+		StandardClaims: standardClaims,
+	}
+
 	unverifiedIDToken = idTokenClaims{
 		Name:           "Mystery Man",
 		Email:          "unverified@email.com",
@@ -119,6 +135,8 @@ type idTokenClaims struct {
 	Roles    interface{} `json:"roles,omitempty"`
 	Verified *bool       `json:"email_verified,omitempty"`
 	Nonce    string      `json:"nonce,omitempty"`
+	// Namespaced nests claims under a namespaced key, as some IdPs do
+	Namespaced interface{} `json:"https://myapp.example.com/claims,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -236,6 +254,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 		IDToken         idTokenClaims
 		AllowUnverified bool
 		UserClaim       string
+		UserClaims      []string
 		EmailClaim      string
 		GroupsClaim     string
 		ExpectedError   error
@@ -317,6 +336,52 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Jane Dobbs",
 			},
 		},
+		"Username Claims": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: true,
+			UserClaim:       "sub",
+			UserClaims:      []string{"preferred_username", "email", "sub"},
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:              "Jane Dobbs",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Username Claims fall back to the next claim": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: true,
+			UserClaim:       "sub",
+			UserClaims:      []string{"upn", "email", "sub"},
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:              "janed@me.com",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Username Claims with a non string claim": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: true,
+			UserClaim:       "sub",
+			UserClaims:      []string{"roles", "sub"},
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedError:   errors.New("username claim \"roles\" must be a string, got []interface {}"),
+		},
+		"Username Claims not present": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: true,
+			UserClaim:       "sub",
+			UserClaims:      []string{"upn", "unique_name"},
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedError:   errors.New("none of the username claims [\"upn\" \"unique_name\"] are present in the token"),
+		},
 		"Email Claim Switched": {
 			IDToken:         unverifiedIDToken,
 			AllowUnverified: true,
@@ -395,6 +460,43 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Jane Dobbs",
 			},
 		},
+		"Nested Claims Dotted Paths": {
+			IDToken:         nestedClaimsIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "https://myapp.example.com/claims.identity.emails.0",
+			GroupsClaim:     "https://myapp.example.com/claims.orgs.groups",
+			UserClaims:      []string{"https://myapp.example.com/claims.identity.username"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "nested-user",
+				Email:             "nested@claims.com",
+				Groups:            []string{"admins", "developers", "testers"},
+				PreferredUsername: "Nested Claims",
+			},
+		},
+		"Nested Claims JSON Pointers": {
+			IDToken:         nestedClaimsIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "/https:~1~1myapp.example.com~1claims/identity/emails/1",
+			GroupsClaim:     "/https:~1~1myapp.example.com~1claims/orgs/1/groups",
+			UserClaims:      []string{"/https:~1~1myapp.example.com~1claims/identity/username"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "nested-user",
+				Email:             "other@claims.com",
+				Groups:            []string{"developers", "testers"},
+				PreferredUsername: "Nested Claims",
+			},
+		},
+		"Nested Claims Missing Keys": {
+			IDToken:         nestedClaimsIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "https://myapp.example.com/claims.profile.email",
+			GroupsClaim:     "/https:~1~1myapp.example.com~1claims/teams/groups",
+			UserClaims:      []string{"https://myapp.example.com/claims.profile.username", "sub"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				PreferredUsername: "Nested Claims",
+			},
+		},
 		"Groups Claim string values": {
 			IDToken:         defaultIDToken,
 			AllowUnverified: false,
@@ -426,6 +528,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 			}
 			provider.AllowUnverifiedEmail = tc.AllowUnverified
 			provider.UserClaim = tc.UserClaim
+			provider.UserClaims = tc.UserClaims
 			provider.EmailClaim = tc.EmailClaim
 			provider.GroupsClaim = tc.GroupsClaim
 
@@ -433,7 +536,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 			g.Expect(err).ToNot(HaveOccurred())
 
 			ss, err := provider.buildSessionFromClaims(rawIDToken, "")
-			if err != nil {
+			if err != nil || tc.ExpectedError != nil {
 				g.Expect(err).To(Equal(tc.ExpectedError))
 			}
 			if ss != nil {
@@ -503,6 +606,51 @@ func TestProviderData_checkNonce(t *testing.T) {
 	}
 }
 
+func TestProviderData_GetEndSessionURL(t *testing.T) {
+	testCases := map[string]struct {
+		EndSessionURL         string
+		IDToken               string
+		PostLogoutRedirectURI string
+		Expected              string
+	}{
+		"No end session endpoint": {
+			IDToken:               idToken,
+			PostLogoutRedirectURI: "https://example.com/",
+			Expected:              "",
+		},
+		"With an ID token hint": {
+			EndSessionURL:         "https://provider.example.com/logout",
+			IDToken:               idToken,
+			PostLogoutRedirectURI: "https://example.com/",
+			Expected:              "https://provider.example.com/logout?client_id=client&id_token_hint=" + idToken + "&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F",
+		},
+		"Without an ID token": {
+			EndSessionURL:         "https://provider.example.com/logout",
+			PostLogoutRedirectURI: "https://example.com/",
+			Expected:              "https://provider.example.com/logout?client_id=client&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F",
+		},
+		"Keeps existing query parameters": {
+			EndSessionURL:         "https://provider.example.com/logout?tenant=example",
+			PostLogoutRedirectURI: "https://example.com/",
+			Expected:              "https://provider.example.com/logout?client_id=client&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F&tenant=example",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			endSessionURL, err := url.Parse(tc.EndSessionURL)
+			g.Expect(err).ToNot(HaveOccurred())
+			provider := &ProviderData{
+				ClientID:      "client",
+				EndSessionURL: endSessionURL,
+			}
+
+			g.Expect(provider.GetEndSessionURL(tc.IDToken, tc.PostLogoutRedirectURI)).To(Equal(tc.Expected))
+		})
+	}
+}
+
 func TestProviderData_loginURLParameters(t *testing.T) {
 
 	testCases := []struct {
@@ -612,3 +760,24 @@ func TestProviderData_loginURLParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestProviderData_compileLoginParams_reserved(t *testing.T) {
+	anything := "^.*$"
+	data := ProviderData{}
+	errs := data.compileLoginParams([]options.LoginURLParameter{
+		{Name: "redirect_uri", Allow: []options.URLParameterRule{{Pattern: &anything}}},
+		{Name: "state", Allow: []options.URLParameterRule{{Pattern: &anything}}},
+		{Name: "login_hint", Allow: []options.URLParameterRule{{Pattern: &anything}}},
+	})
+	assert.Equal(t, []error{
+		errors.New("parameter redirect_uri is set by the proxy and cannot be allowed in loginURLParameters"),
+		errors.New("parameter state is set by the proxy and cannot be allowed in loginURLParameters"),
+	}, errs)
+
+	redirectParams := data.LoginURLParams(url.Values{
+		"redirect_uri": {"https://evil.example.com/"},
+		"state":        {"forged"},
+		"login_hint":   {"user@example.com"},
+	})
+	assert.Equal(t, url.Values{"login_hint": {"user@example.com"}}, redirectParams)
+}
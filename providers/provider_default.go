@@ -3,15 +3,69 @@ package providers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"golang.org/x/oauth2"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
+const (
+	// tokenExchangeGrantType is the RFC 8693 grant type for token exchange
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// accessTokenType is the RFC 8693 token type identifier for access tokens
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+	// deviceCodeGrantType is the RFC 8628 grant type for redeeming device codes
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Error codes returned by the token endpoint while a device code is waiting
+// to be authorized, as described in RFC 8628 section 3.5.
+const (
+	DeviceAuthorizationPending = "authorization_pending"
+	DeviceSlowDown             = "slow_down"
+	DeviceAccessDenied         = "access_denied"
+	DeviceExpiredToken         = "expired_token"
+)
+
+// DeviceAuthorization is the response from the device authorization endpoint
+// described in RFC 8628 section 3.2.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+// DeviceTokenError is returned when the token endpoint rejects a device code.
+// Code holds the OAuth error code, eg authorization_pending.
+type DeviceTokenError struct {
+	Code        string
+	Description string
+}
+
+// Error returns the error code and description of the token endpoint error.
+func (e *DeviceTokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("device code redemption failed: %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("device code redemption failed: %s", e.Code)
+}
+
 var (
 	// ErrNotImplemented is returned when a provider did not override a default
 	// implementation method that doesn't have sensible defaults
@@ -25,19 +79,104 @@ var (
 	// extra `id_token` field for an IDToken.
 	ErrMissingIDToken = errors.New("missing id_token")
 
+	// ErrMissingDeviceCode is returned when a RedeemDeviceCode method is
+	// called with an empty device code
+	ErrMissingDeviceCode = errors.New("missing device code")
+
 	// ErrMissingOIDCVerifier is returned when a provider didn't set `Verifier`
 	// but an attempt to call `Verifier.Verify` was about to be made.
 	ErrMissingOIDCVerifier = errors.New("oidc verifier is not configured")
 
+	// ErrSessionRevoked is returned by CheckSessionActive when the provider
+	// rejects the access token of the session, eg because it was revoked.
+	ErrSessionRevoked = errors.New("access token was rejected by the provider")
+
+	// ErrTokenRequestUnsupported is returned by ExchangeToken when the
+	// provider does not support the grant or the resource indicator requested
+	// for an upstream.
+	ErrTokenRequestUnsupported = errors.New("token request is not supported by the provider")
+
 	_ Provider = (*ProviderData)(nil)
 )
 
+// IsTransientRefreshError determines whether a session refresh failed because
+// the provider could not be reached or was temporarily unavailable, rather
+// than because the refresh was rejected, eg with an invalid_grant error for a
+// revoked or expired refresh token.
+func IsTransientRefreshError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && requests.IsTransientStatus(retrieveErr.Response.StatusCode)
+	}
+
+	var statusErr *requests.UnexpectedStatusError
+	if errors.As(err, &statusErr) {
+		return requests.IsTransientStatus(statusErr.StatusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// CheckSessionActive asks the provider whether the access token of the
+// session is still active by calling the profile (userinfo) endpoint with it,
+// or the validate endpoint when the provider has no profile URL.
+// A 401 or 403 response returns ErrSessionRevoked. Other failures do not tell
+// whether the token is still active and return a different error.
+// Providers without either endpoint are checked with ValidateSession.
+func CheckSessionActive(ctx context.Context, p Provider, s *sessions.SessionState) error {
+	var endpoint string
+	var header http.Header
+	switch {
+	case p.Data().ProfileURL != nil && p.Data().ProfileURL.String() != "":
+		endpoint = p.Data().ProfileURL.String()
+		header = makeOIDCHeader(s.AccessToken)
+	case p.Data().ValidateURL != nil && p.Data().ValidateURL.String() != "":
+		// Validate endpoints take the token as a parameter, as in validateToken
+		endpoint = p.Data().ValidateURL.String()
+		params := url.Values{"access_token": {s.AccessToken}}
+		if hasQueryParams(endpoint) {
+			endpoint = endpoint + "&" + params.Encode()
+		} else {
+			endpoint = endpoint + "?" + params.Encode()
+		}
+	default:
+		if !p.ValidateSession(ctx, s) {
+			return ErrSessionRevoked
+		}
+		return nil
+	}
+	if s.AccessToken == "" {
+		return ErrSessionRevoked
+	}
+
+	result := requests.New(endpoint).
+		WithContext(ctx).
+		WithHeaders(header).
+		Do()
+	if result.Error() != nil {
+		return fmt.Errorf("error checking the access token: %w", result.Error())
+	}
+
+	switch result.StatusCode() {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: status %d", ErrSessionRevoked, result.StatusCode())
+	default:
+		return &requests.UnexpectedStatusError{StatusCode: result.StatusCode(), Body: result.Body()}
+	}
+}
+
 // GetLoginURL with typical oauth parameters
 // codeChallenge and codeChallengeMethod are the PKCE challenge and method to append to the URL params.
 // they will be empty strings if no code challenge should be presented
-func (p *ProviderData) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) string {
-	loginURL := makeLoginURL(p, redirectURI, state, extraParams)
-	return loginURL.String()
+func (p *ProviderData) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) (string, error) {
+	loginURL, err := makeLoginURL(p, redirectURI, state, extraParams)
+	if err != nil {
+		return "", err
+	}
+	return loginURL.String(), nil
 }
 
 // Redeem provides a default implementation of the OAuth2 token redemption process
@@ -66,6 +205,7 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code, codeVerifi
 
 	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -116,7 +256,12 @@ func (p *ProviderData) EnrichSession(_ context.Context, _ *sessions.SessionState
 // Authorize performs global authorization on an authenticated session.
 // This is not used for fine-grained per route authorization rules.
 func (p *ProviderData) Authorize(_ context.Context, s *sessions.SessionState) (bool, error) {
-	if len(p.AllowedGroups) == 0 {
+	if len(p.AuthorizationRules) > 0 && !p.AuthorizationRules.Authorize(s) {
+		return false, nil
+	}
+
+	// An allowed groups file restricts access even when it lists no groups
+	if len(p.AllowedGroups) == 0 && p.fileAllowedGroups == nil {
 		return true, nil
 	}
 
@@ -124,6 +269,9 @@ func (p *ProviderData) Authorize(_ context.Context, s *sessions.SessionState) (b
 		if _, ok := p.AllowedGroups[group]; ok {
 			return true, nil
 		}
+		if p.fileAllowedGroups.has(group) {
+			return true, nil
+		}
 	}
 
 	return false, nil
@@ -139,6 +287,291 @@ func (p *ProviderData) RefreshSession(_ context.Context, _ *sessions.SessionStat
 	return false, ErrNotImplemented
 }
 
+// ExchangeToken provides a default implementation of requesting a token for
+// an upstream, either with an RFC 8693 token exchange of the session's access
+// token or with the session's refresh token, for the audience, resource
+// (RFC 8707) and scopes of the upstream.
+// A refresh token rotated by the provider is updated in the session, callers
+// must hold the session lock for refresh token grants.
+// ErrTokenRequestUnsupported is returned when the provider does not support
+// the grant or the resource.
+func (p *ProviderData) ExchangeToken(ctx context.Context, s *sessions.SessionState, exchange *options.TokenExchange) (*sessions.ExchangedToken, error) {
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", p.ClientID)
+	params.Add("client_secret", clientSecret)
+	switch exchange.Grant {
+	case "", options.TokenExchangeGrantTokenExchange:
+		if s.AccessToken == "" {
+			return nil, errors.New("missing subject token")
+		}
+		params.Add("grant_type", tokenExchangeGrantType)
+		params.Add("subject_token", s.AccessToken)
+		params.Add("subject_token_type", accessTokenType)
+		params.Add("requested_token_type", accessTokenType)
+	case options.TokenExchangeGrantRefreshToken:
+		if s.RefreshToken == "" {
+			return nil, errors.New("missing refresh token")
+		}
+		params.Add("grant_type", "refresh_token")
+		params.Add("refresh_token", s.RefreshToken)
+	default:
+		return nil, fmt.Errorf("unknown token exchange grant %q", exchange.Grant)
+	}
+	if exchange.Audience != "" {
+		params.Add("audience", exchange.Audience)
+	}
+	if exchange.Resource != "" {
+		params.Add("resource", exchange.Resource)
+	}
+	if len(exchange.Scopes) > 0 {
+		params.Add("scope", strings.Join(exchange.Scopes, " "))
+	}
+
+	target := exchange.Audience
+	if target == "" {
+		target = exchange.Resource
+	}
+
+	result := requests.New(p.RedeemURL.String()).
+		WithContext(ctx).
+		WithClient(p.getTokenClient()).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		Do()
+	if result.Error() != nil {
+		return nil, fmt.Errorf("token exchange for %q failed: %v", target, result.Error())
+	}
+
+	var jsonResponse struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		Error        string `json:"error"`
+	}
+	if err := json.Unmarshal(result.Body(), &jsonResponse); err != nil || result.StatusCode() != http.StatusOK {
+		switch jsonResponse.Error {
+		case "invalid_target", "unsupported_grant_type":
+			return nil, fmt.Errorf("token exchange for %q failed: %w: %s", target, ErrTokenRequestUnsupported, jsonResponse.Error)
+		}
+		return nil, fmt.Errorf("token exchange for %q failed: unexpected status \"%d\": %s", target, result.StatusCode(), result.Body())
+	}
+	if jsonResponse.AccessToken == "" {
+		return nil, fmt.Errorf("token exchange for %q returned no access token", target)
+	}
+	if jsonResponse.RefreshToken != "" && exchange.Grant == options.TokenExchangeGrantRefreshToken {
+		s.RefreshToken = jsonResponse.RefreshToken
+	}
+
+	token := &sessions.ExchangedToken{
+		AccessToken: jsonResponse.AccessToken,
+	}
+	switch {
+	case jsonResponse.ExpiresIn > 0:
+		expiresOn := s.Clock.Now().Add(time.Duration(jsonResponse.ExpiresIn) * time.Second).Truncate(time.Second)
+		token.ExpiresOn = &expiresOn
+	case s.ExpiresOn != nil:
+		// Tokens without an expiry are not cached beyond the session tokens
+		expiresOn := *s.ExpiresOn
+		token.ExpiresOn = &expiresOn
+	}
+	return token, nil
+}
+
+// StartDeviceAuthorization requests a device and user code from the device
+// authorization endpoint so that a user can authorize a headless client
+// from another device (RFC 8628).
+func (p *ProviderData) StartDeviceAuthorization(ctx context.Context) (*DeviceAuthorization, error) {
+	if p.DeviceAuthorizationURL == nil || p.DeviceAuthorizationURL.String() == "" {
+		return nil, ErrNotImplemented
+	}
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", p.ClientID)
+	if clientSecret != "" {
+		params.Add("client_secret", clientSecret)
+	}
+	if p.Scope != "" {
+		params.Add("scope", p.Scope)
+	}
+
+	var authorization DeviceAuthorization
+	err = requests.New(p.DeviceAuthorizationURL.String()).
+		WithContext(ctx).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		Do().
+		UnmarshalInto(&authorization)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %v", err)
+	}
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		return nil, errors.New("device authorization response is missing the device_code, user_code or verification_uri")
+	}
+
+	return &authorization, nil
+}
+
+// RedeemDeviceCode provides a default implementation of redeeming a device
+// code for tokens once the user has authorized the device.
+// A *DeviceTokenError is returned while the authorization is pending or
+// when it has been denied or has expired.
+func (p *ProviderData) RedeemDeviceCode(ctx context.Context, deviceCode string) (*sessions.SessionState, error) {
+	token, err := p.redeemDeviceCode(ctx, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := &sessions.SessionState{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		DeviceGrant:  true,
+		Clock:        p.clock,
+	}
+	ss.CreatedAtNow()
+	ss.SetExpiresOn(token.Expiry)
+	return ss, nil
+}
+
+// redeemDeviceCode polls the token endpoint once with the device code, as
+// described in RFC 8628 section 3.4.
+func (p *ProviderData) redeemDeviceCode(ctx context.Context, deviceCode string) (*oauth2.Token, error) {
+	if deviceCode == "" {
+		return nil, ErrMissingDeviceCode
+	}
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", p.ClientID)
+	if clientSecret != "" {
+		params.Add("client_secret", clientSecret)
+	}
+	params.Add("grant_type", deviceCodeGrantType)
+	params.Add("device_code", deviceCode)
+
+	result := requests.New(p.RedeemURL.String()).
+		WithContext(ctx).
+		WithClient(p.getTokenClient()).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		Do()
+	if result.Error() != nil {
+		return nil, result.Error()
+	}
+
+	var jsonResponse struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(result.Body(), &jsonResponse); err != nil {
+		return nil, fmt.Errorf("unexpected status \"%d\": %s", result.StatusCode(), result.Body())
+	}
+	if jsonResponse.Error != "" {
+		return nil, &DeviceTokenError{
+			Code:        jsonResponse.Error,
+			Description: jsonResponse.ErrorDescription,
+		}
+	}
+	if result.StatusCode() != http.StatusOK || jsonResponse.AccessToken == "" {
+		return nil, fmt.Errorf("no access token found %s", result.Body())
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  jsonResponse.AccessToken,
+		RefreshToken: jsonResponse.RefreshToken,
+		TokenType:    jsonResponse.TokenType,
+	}
+	if jsonResponse.ExpiresIn > 0 {
+		token.Expiry = p.clock.Now().Add(time.Duration(jsonResponse.ExpiresIn) * time.Second).Truncate(time.Second)
+	}
+	if jsonResponse.IDToken != "" {
+		token = token.WithExtra(map[string]interface{}{"id_token": jsonResponse.IDToken})
+	}
+	return token, nil
+}
+
+// RevokeToken provides a default implementation of revoking the refresh and
+// access tokens of a session at the token revocation endpoint (RFC 7009).
+// The refresh token is revoked first as many providers also revoke the
+// access tokens issued from it. Both tokens are revoked even if revoking the
+// refresh token fails, and the failures are returned together.
+func (p *ProviderData) RevokeToken(ctx context.Context, s *sessions.SessionState) error {
+	if p.RevocationURL == nil || p.RevocationURL.String() == "" {
+		return ErrNotImplemented
+	}
+
+	var errs []error
+	for _, token := range []struct {
+		value string
+		hint  string
+	}{
+		{value: s.RefreshToken, hint: "refresh_token"},
+		{value: s.AccessToken, hint: "access_token"},
+	} {
+		if token.value == "" {
+			continue
+		}
+		if err := p.revokeToken(ctx, token.value, token.hint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+// revokeToken posts a single token to the revocation endpoint.
+// The request is sent with the token client so that it is authenticated with
+// a client assertion when one is configured instead of the client secret.
+// The endpoint responds with a 200 whether or not the token was valid.
+func (p *ProviderData) revokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	params := url.Values{}
+	params.Add("token", token)
+	params.Add("token_type_hint", tokenTypeHint)
+	params.Add("client_id", p.ClientID)
+	if !p.clientAssertion {
+		clientSecret, err := p.GetClientSecret()
+		if err != nil {
+			return err
+		}
+		if clientSecret != "" {
+			params.Add("client_secret", clientSecret)
+		}
+	}
+
+	result := requests.New(p.RevocationURL.String()).
+		WithContext(ctx).
+		WithClient(p.getTokenClient()).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		Do()
+	if result.Error() != nil {
+		return fmt.Errorf("%s revocation request failed: %v", tokenTypeHint, result.Error())
+	}
+	if result.StatusCode() != http.StatusOK {
+		return fmt.Errorf("%s revocation request failed: got %d from %q %s", tokenTypeHint, result.StatusCode(), p.RevocationURL.String(), result.Body())
+	}
+	return nil
+}
+
 // CreateSessionFromToken converts Bearer IDTokens into sessions
 func (p *ProviderData) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
 	if p.Verifier != nil {
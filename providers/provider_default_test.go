@@ -2,13 +2,23 @@ package providers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
 )
 
 func TestRefresh(t *testing.T) {
@@ -42,7 +52,8 @@ func TestCodeChallengeConfigured(t *testing.T) {
 	extraValues := url.Values{}
 	extraValues["code_challenge"] = []string{"challenge"}
 	extraValues["code_challenge_method"] = []string{"method"}
-	result := p.GetLoginURL("https://my.test.app/oauth", "", "", extraValues)
+	result, err := p.GetLoginURL("https://my.test.app/oauth", "", "", extraValues)
+	assert.NoError(t, err)
 	assert.Contains(t, result, "code_challenge=challenge")
 	assert.Contains(t, result, "code_challenge_method=method")
 }
@@ -56,7 +67,8 @@ func TestCodeChallengeNotConfigured(t *testing.T) {
 		},
 	}
 
-	result := p.GetLoginURL("https://my.test.app/oauth", "", "", url.Values{})
+	result, err := p.GetLoginURL("https://my.test.app/oauth", "", "", url.Values{})
+	assert.NoError(t, err)
 	assert.NotContains(t, result, "code_challenge")
 	assert.NotContains(t, result, "code_challenge_method")
 }
@@ -70,12 +82,481 @@ func TestProviderDataEnrichSession(t *testing.T) {
 	g.Expect(err).ToNot(HaveOccurred())
 }
 
+func TestProviderDataExchangeToken(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = req.PostForm
+		if form.Get("audience") == "unknown" {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"invalid_target"}`))
+			return
+		}
+		if form.Get("audience") == "broken" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if form.Get("audience") == "noexpiry" {
+			_, _ = rw.Write([]byte(`{"access_token":"exchanged","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer"}`))
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token":"exchanged","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":300}`))
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedeemURL:    redeemURL,
+	}
+	now := time.Unix(1700000000, 0)
+	sessionExpiresOn := now.Add(time.Hour)
+	session := &sessions.SessionState{AccessToken: "subject", ExpiresOn: &sessionExpiresOn}
+	session.Clock.Set(now)
+
+	token, err := p.ExchangeToken(context.Background(), session, &options.TokenExchange{Audience: "downstream"})
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged", token.AccessToken)
+	assert.Equal(t, now.Add(300*time.Second), *token.ExpiresOn)
+
+	assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", form.Get("grant_type"))
+	assert.Equal(t, "subject", form.Get("subject_token"))
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", form.Get("subject_token_type"))
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", form.Get("requested_token_type"))
+	assert.Equal(t, "downstream", form.Get("audience"))
+	assert.Equal(t, "client", form.Get("client_id"))
+	assert.Equal(t, "secret", form.Get("client_secret"))
+	assert.NotContains(t, form, "resource")
+	assert.NotContains(t, form, "scope")
+
+	_, err = p.ExchangeToken(context.Background(), session, &options.TokenExchange{
+		Audience: "downstream",
+		Resource: "https://api.example.com",
+		Scopes:   []string{"read", "write"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.example.com", form.Get("resource"))
+	assert.Equal(t, "read write", form.Get("scope"))
+
+	// Tokens without an expiry expire with the session
+	token, err = p.ExchangeToken(context.Background(), session, &options.TokenExchange{Audience: "noexpiry"})
+	assert.NoError(t, err)
+	assert.Equal(t, sessionExpiresOn, *token.ExpiresOn)
+	assert.NotSame(t, session.ExpiresOn, token.ExpiresOn)
+
+	_, err = p.ExchangeToken(context.Background(), session, &options.TokenExchange{Audience: "unknown"})
+	assert.ErrorIs(t, err, ErrTokenRequestUnsupported)
+
+	_, err = p.ExchangeToken(context.Background(), session, &options.TokenExchange{Audience: "broken"})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrTokenRequestUnsupported)
+
+	_, err = p.ExchangeToken(context.Background(), &sessions.SessionState{}, &options.TokenExchange{Audience: "downstream"})
+	assert.Error(t, err)
+}
+
+func TestProviderDataExchangeTokenWithRefreshToken(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = req.PostForm
+		if form.Get("resource") == "https://unsupported.example.com" {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"invalid_target"}`))
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token":"api_token","refresh_token":"rotated","token_type":"Bearer","expires_in":300}`))
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedeemURL:    redeemURL,
+	}
+	session := &sessions.SessionState{AccessToken: "access", RefreshToken: "refresh"}
+
+	token, err := p.ExchangeToken(context.Background(), session, &options.TokenExchange{
+		Resource: "https://api.example.com",
+		Scopes:   []string{"read"},
+		Grant:    options.TokenExchangeGrantRefreshToken,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "api_token", token.AccessToken)
+
+	assert.Equal(t, "refresh_token", form.Get("grant_type"))
+	assert.Equal(t, "refresh", form.Get("refresh_token"))
+	assert.Equal(t, "https://api.example.com", form.Get("resource"))
+	assert.Equal(t, "read", form.Get("scope"))
+	assert.NotContains(t, form, "subject_token")
+
+	// The rotated refresh token is kept, the session access token is not replaced
+	assert.Equal(t, "rotated", session.RefreshToken)
+	assert.Equal(t, "access", session.AccessToken)
+
+	_, err = p.ExchangeToken(context.Background(), session, &options.TokenExchange{
+		Resource: "https://unsupported.example.com",
+		Grant:    options.TokenExchangeGrantRefreshToken,
+	})
+	assert.ErrorIs(t, err, ErrTokenRequestUnsupported)
+
+	_, err = p.ExchangeToken(context.Background(), &sessions.SessionState{AccessToken: "access"}, &options.TokenExchange{
+		Resource: "https://api.example.com",
+		Grant:    options.TokenExchangeGrantRefreshToken,
+	})
+	assert.EqualError(t, err, "missing refresh token")
+}
+
+func TestProviderDataStartDeviceAuthorization(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = req.PostForm
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"device_code":"device","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":600,"interval":5}`))
+	}))
+	defer server.Close()
+
+	deviceURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scope:        "openid email",
+	}
+
+	_, err = p.StartDeviceAuthorization(context.Background())
+	assert.Equal(t, ErrNotImplemented, err)
+
+	p.DeviceAuthorizationURL = deviceURL
+	authorization, err := p.StartDeviceAuthorization(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &DeviceAuthorization{
+		DeviceCode:      "device",
+		UserCode:        "ABCD-EFGH",
+		VerificationURI: "https://example.com/device",
+		ExpiresIn:       600,
+		Interval:        5,
+	}, authorization)
+
+	assert.Equal(t, "client", form.Get("client_id"))
+	assert.Equal(t, "secret", form.Get("client_secret"))
+	assert.Equal(t, "openid email", form.Get("scope"))
+}
+
+func TestProviderDataRedeemDeviceCode(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = req.PostForm
+		rw.Header().Set("Content-Type", "application/json")
+		switch form.Get("device_code") {
+		case "pending":
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"authorization_pending"}`))
+		case "slow":
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"slow_down","error_description":"polling too fast"}`))
+		case "expired":
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"expired_token"}`))
+		default:
+			_, _ = rw.Write([]byte(`{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":300}`))
+		}
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedeemURL:    redeemURL,
+	}
+	now := time.Unix(1700000000, 0)
+	p.clock.Set(now)
+
+	session, err := p.RedeemDeviceCode(context.Background(), "authorized")
+	assert.NoError(t, err)
+	assert.Equal(t, "access", session.AccessToken)
+	assert.Equal(t, "refresh", session.RefreshToken)
+	assert.True(t, session.DeviceGrant)
+	assert.Equal(t, now, *session.CreatedAt)
+	assert.Equal(t, now.Add(300*time.Second), *session.ExpiresOn)
+
+	assert.Equal(t, "urn:ietf:params:oauth:grant-type:device_code", form.Get("grant_type"))
+	assert.Equal(t, "authorized", form.Get("device_code"))
+	assert.Equal(t, "client", form.Get("client_id"))
+	assert.Equal(t, "secret", form.Get("client_secret"))
+
+	for code, expected := range map[string]*DeviceTokenError{
+		"pending": {Code: DeviceAuthorizationPending},
+		"slow":    {Code: DeviceSlowDown, Description: "polling too fast"},
+		"expired": {Code: DeviceExpiredToken},
+	} {
+		_, err = p.RedeemDeviceCode(context.Background(), code)
+		var tokenErr *DeviceTokenError
+		assert.True(t, errors.As(err, &tokenErr), code)
+		assert.Equal(t, expected, tokenErr, code)
+	}
+
+	_, err = p.RedeemDeviceCode(context.Background(), "")
+	assert.Equal(t, ErrMissingDeviceCode, err)
+}
+
+func TestProviderDataRevokeToken(t *testing.T) {
+	var revoked []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		revoked = append(revoked, req.PostForm)
+		if strings.HasPrefix(req.PostForm.Get("token"), "unsupported") {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	revocationURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}
+	session := &sessions.SessionState{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+	}
+
+	assert.Equal(t, ErrNotImplemented, p.RevokeToken(context.Background(), session))
+
+	p.RevocationURL = revocationURL
+	assert.NoError(t, p.RevokeToken(context.Background(), session))
+	assert.Len(t, revoked, 2)
+	assert.Equal(t, "refresh", revoked[0].Get("token"))
+	assert.Equal(t, "refresh_token", revoked[0].Get("token_type_hint"))
+	assert.Equal(t, "access", revoked[1].Get("token"))
+	assert.Equal(t, "access_token", revoked[1].Get("token_type_hint"))
+	for _, form := range revoked {
+		assert.Equal(t, "client", form.Get("client_id"))
+		assert.Equal(t, "secret", form.Get("client_secret"))
+	}
+
+	// Tokens that are not set are not revoked
+	revoked = nil
+	assert.NoError(t, p.RevokeToken(context.Background(), &sessions.SessionState{AccessToken: "access"}))
+	assert.Len(t, revoked, 1)
+	assert.Equal(t, "access", revoked[0].Get("token"))
+
+	err = p.RevokeToken(context.Background(), &sessions.SessionState{RefreshToken: "unsupported"})
+	assert.Error(t, err)
+
+	// The access token is revoked when revoking the refresh token fails
+	revoked = nil
+	err = p.RevokeToken(context.Background(), &sessions.SessionState{AccessToken: "access", RefreshToken: "unsupported"})
+	assert.EqualError(t, err, fmt.Sprintf("refresh_token revocation request failed: got 503 from %q ", server.URL))
+	assert.Len(t, revoked, 2)
+	assert.Equal(t, "access", revoked[1].Get("token"))
+
+	// Both failures are returned
+	revoked = nil
+	err = p.RevokeToken(context.Background(), &sessions.SessionState{AccessToken: "unsupported-access", RefreshToken: "unsupported"})
+	assert.ErrorContains(t, err, "refresh_token revocation request failed")
+	assert.ErrorContains(t, err, "access_token revocation request failed")
+	assert.Len(t, revoked, 2)
+}
+
+func TestIsTransientRefreshError(t *testing.T) {
+	// A closed server gives a real connection error
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	connErr := requests.New(server.URL).Do().UnmarshalInto(&struct{}{})
+
+	testCases := map[string]struct {
+		err       error
+		transient bool
+	}{
+		"connection error": {
+			err:       fmt.Errorf("unable to redeem refresh token: %w", connErr),
+			transient: true,
+		},
+		"timeout": {
+			err:       fmt.Errorf("unable to redeem refresh token: %w", context.DeadlineExceeded),
+			transient: true,
+		},
+		"token endpoint unavailable": {
+			err: fmt.Errorf("failed to get token: %w", &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: http.StatusServiceUnavailable},
+			}),
+			transient: true,
+		},
+		"invalid grant": {
+			err: fmt.Errorf("failed to get token: %w", &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: http.StatusBadRequest},
+				Body:     []byte(`{"error":"invalid_grant"}`),
+			}),
+			transient: false,
+		},
+		"unexpected gateway status": {
+			err:       &requests.UnexpectedStatusError{StatusCode: http.StatusBadGateway},
+			transient: true,
+		},
+		"unexpected client status": {
+			err:       &requests.UnexpectedStatusError{StatusCode: http.StatusUnauthorized},
+			transient: false,
+		},
+		"other error": {
+			err:       errors.New("user is no longer in the group(s)"),
+			transient: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.transient, IsTransientRefreshError(tc.err))
+		})
+	}
+}
+
+func TestCheckSessionActive(t *testing.T) {
+	var status int
+	var authorization, accessTokenParam string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		accessTokenParam = req.URL.Query().Get("access_token")
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	serverURL, _ := url.Parse(server.URL)
+
+	testCases := map[string]struct {
+		status      int
+		expectedErr error
+	}{
+		"active token": {
+			status: http.StatusOK,
+		},
+		"revoked token": {
+			status:      http.StatusUnauthorized,
+			expectedErr: ErrSessionRevoked,
+		},
+		"forbidden token": {
+			status:      http.StatusForbidden,
+			expectedErr: ErrSessionRevoked,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			status = tc.status
+
+			p := &ProviderData{ProfileURL: serverURL}
+			err := CheckSessionActive(context.Background(), p, &sessions.SessionState{AccessToken: "access_token"})
+			if tc.expectedErr != nil {
+				g.Expect(errors.Is(err, tc.expectedErr)).To(BeTrue())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(authorization).To(Equal("Bearer access_token"))
+		})
+	}
+
+	t.Run("does not treat an unavailable provider as revoked", func(t *testing.T) {
+		g := NewWithT(t)
+		status = http.StatusServiceUnavailable
+
+		err := CheckSessionActive(context.Background(), &ProviderData{ProfileURL: serverURL}, &sessions.SessionState{AccessToken: "access_token"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(errors.Is(err, ErrSessionRevoked)).To(BeFalse())
+		g.Expect(IsTransientRefreshError(err)).To(BeTrue())
+	})
+
+	t.Run("uses the validate URL without a profile URL", func(t *testing.T) {
+		g := NewWithT(t)
+		status = http.StatusUnauthorized
+
+		err := CheckSessionActive(context.Background(), &ProviderData{ValidateURL: serverURL}, &sessions.SessionState{AccessToken: "access_token"})
+		g.Expect(errors.Is(err, ErrSessionRevoked)).To(BeTrue())
+		g.Expect(accessTokenParam).To(Equal("access_token"))
+	})
+
+	t.Run("falls back to ValidateSession without an endpoint", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := CheckSessionActive(context.Background(), &ProviderData{}, &sessions.SessionState{AccessToken: "access_token"})
+		g.Expect(err).To(Equal(ErrSessionRevoked))
+	})
+}
+
+func TestProviderDataRedeemRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token":"access","token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	p := &ProviderData{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedeemURL:    redeemURL,
+	}
+	_, err = p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code", "")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	retryClient := requests.NewRetryClient(2, time.Millisecond)
+	retryClient.Transport.(*requests.RetryTransport).Retryable = isRetryableTokenRequest
+	p.tokenClient = retryClient
+
+	// Authorization codes are single use and are not retried once the
+	// request reached the server
+	atomic.StoreInt32(&attempts, 0)
+	_, err = p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code", "")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	atomic.StoreInt32(&attempts, 0)
+	exchanged, err := p.ExchangeToken(context.Background(), &sessions.SessionState{RefreshToken: "refresh"}, &options.TokenExchange{Grant: options.TokenExchangeGrantRefreshToken})
+	assert.NoError(t, err)
+	assert.Equal(t, "access", exchanged.AccessToken)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
 func TestProviderDataAuthorize(t *testing.T) {
 	testCases := []struct {
-		name          string
-		allowedGroups []string
-		groups        []string
-		expectedAuthZ bool
+		name               string
+		allowedGroups      []string
+		authorizationRules []options.AuthorizationRule
+		groups             []string
+		expectedAuthZ      bool
 	}{
 		{
 			name:          "NoAllowedGroups",
@@ -101,6 +582,32 @@ func TestProviderDataAuthorize(t *testing.T) {
 			groups:        []string{"baz", "foo"},
 			expectedAuthZ: false,
 		},
+		{
+			name: "UserAllowedByRule",
+			authorizationRules: []options.AuthorizationRule{
+				{Action: options.AuthorizationRuleAllow, Expression: "groups contains foo"},
+			},
+			groups:        []string{"foo", "bar"},
+			expectedAuthZ: true,
+		},
+		{
+			name: "UserDeniedByRule",
+			authorizationRules: []options.AuthorizationRule{
+				{Action: options.AuthorizationRuleDeny, Expression: "groups contains bar"},
+				{Action: options.AuthorizationRuleAllow, Expression: "groups contains foo"},
+			},
+			groups:        []string{"foo", "bar"},
+			expectedAuthZ: false,
+		},
+		{
+			name: "UserAllowedByRuleNotInAllowedGroup",
+			authorizationRules: []options.AuthorizationRule{
+				{Action: options.AuthorizationRuleAllow, Expression: "groups contains foo"},
+			},
+			allowedGroups: []string{"baz"},
+			groups:        []string{"foo", "bar"},
+			expectedAuthZ: false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -113,6 +620,10 @@ func TestProviderDataAuthorize(t *testing.T) {
 			p := &ProviderData{}
 			p.setAllowedGroups(tc.allowedGroups)
 
+			rules, err := authorization.NewRules(tc.authorizationRules)
+			g.Expect(err).ToNot(HaveOccurred())
+			p.AuthorizationRules = rules
+
 			authorized, err := p.Authorize(context.Background(), session)
 			g.Expect(err).ToNot(HaveOccurred())
 			g.Expect(authorized).To(Equal(tc.expectedAuthZ))
@@ -3,12 +3,16 @@ package providers
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
 	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
@@ -20,7 +24,7 @@ const (
 // Provider represents an upstream identity provider implementation
 type Provider interface {
 	Data() *ProviderData
-	GetLoginURL(redirectURI, finalRedirect, nonce string, extraParams url.Values) string
+	GetLoginURL(redirectURI, finalRedirect, nonce string, extraParams url.Values) (string, error)
 	Redeem(ctx context.Context, redirectURI, code, codeVerifier string) (*sessions.SessionState, error)
 	// Deprecated: Migrate to EnrichSession
 	GetEmailAddress(ctx context.Context, s *sessions.SessionState) (string, error)
@@ -29,6 +33,10 @@ type Provider interface {
 	ValidateSession(ctx context.Context, s *sessions.SessionState) bool
 	RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error)
 	CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error)
+	ExchangeToken(ctx context.Context, s *sessions.SessionState, exchange *options.TokenExchange) (*sessions.ExchangedToken, error)
+	StartDeviceAuthorization(ctx context.Context) (*DeviceAuthorization, error)
+	RedeemDeviceCode(ctx context.Context, deviceCode string) (*sessions.SessionState, error)
+	RevokeToken(ctx context.Context, s *sessions.SessionState) error
 }
 
 func NewProvider(providerConfig options.Provider) (Provider, error) {
@@ -36,6 +44,35 @@ func NewProvider(providerConfig options.Provider) (Provider, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not create provider data: %v", err)
 	}
+
+	provider, err := newProviderForType(providerData, providerConfig)
+	if err != nil {
+		providerData.StopWatching()
+		return nil, err
+	}
+
+	// The groups from the groups API are added before the groups transforms
+	// so that they are transformed alike
+	if providerConfig.GroupsAPI != nil {
+		provider, err = newGroupsAPIProvider(provider, *providerConfig.GroupsAPI)
+		if err != nil {
+			providerData.StopWatching()
+			return nil, err
+		}
+	}
+	if len(providerConfig.GroupsTransforms) == 0 {
+		return provider, nil
+	}
+
+	transforms, err := newGroupsTransforms(providerConfig.GroupsTransforms)
+	if err != nil {
+		providerData.StopWatching()
+		return nil, err
+	}
+	return &groupsTransformProvider{Provider: provider, transforms: transforms}, nil
+}
+
+func newProviderForType(providerData *ProviderData, providerConfig options.Provider) (Provider, error) {
 	switch providerConfig.Type {
 	case options.ADFSProvider:
 		return NewADFSProvider(providerData, providerConfig.ADFSConfig), nil
@@ -76,6 +113,7 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 		ClientID:         providerConfig.ClientID,
 		ClientSecret:     providerConfig.ClientSecret,
 		ClientSecretFile: providerConfig.ClientSecretFile,
+		watchDone:        make(chan bool),
 	}
 
 	needsVerifier, err := providerRequiresOIDCProviderVerifier(providerConfig.Type)
@@ -83,15 +121,27 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 		return nil, err
 	}
 
+	var endSessionURL string
 	if needsVerifier {
+		jwtLeeway := options.DefaultOIDCJWTLeeway
+		if providerConfig.OIDCConfig.JWTLeeway != nil {
+			jwtLeeway = providerConfig.OIDCConfig.JWTLeeway.Duration()
+		}
+
 		pv, err := internaloidc.NewProviderVerifier(context.TODO(), internaloidc.ProviderVerifierOptions{
-			AudienceClaims:         providerConfig.OIDCConfig.AudienceClaims,
-			ClientID:               providerConfig.ClientID,
-			ExtraAudiences:         providerConfig.OIDCConfig.ExtraAudiences,
-			IssuerURL:              providerConfig.OIDCConfig.IssuerURL,
-			JWKsURL:                providerConfig.OIDCConfig.JwksURL,
-			SkipDiscovery:          providerConfig.OIDCConfig.SkipDiscovery,
+			AudienceClaims:  providerConfig.OIDCConfig.AudienceClaims,
+			ClientID:        providerConfig.ClientID,
+			ExtraAudiences:  providerConfig.OIDCConfig.ExtraAudiences,
+			ExtraIssuerURLs: providerConfig.OIDCConfig.ExtraIssuerURLs,
+			IssuerURL:       providerConfig.OIDCConfig.IssuerURL,
+			JWKsURL:         providerConfig.OIDCConfig.JwksURL,
+			SkipDiscovery:   providerConfig.OIDCConfig.SkipDiscovery,
+			DiscoveryRetry: internaloidc.DiscoveryRetry{
+				Timeout: providerConfig.OIDCConfig.DiscoveryTimeout.Duration(),
+				Backoff: providerConfig.OIDCConfig.DiscoveryRetryBackoff.Duration(),
+			},
 			SkipIssuerVerification: providerConfig.OIDCConfig.InsecureSkipIssuerVerification,
+			JWTLeeway:              jwtLeeway,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("error building OIDC ProviderVerifier: %v", err)
@@ -105,6 +155,15 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 			providerConfig.LoginURL = endpoints.AuthURL
 			providerConfig.RedeemURL = endpoints.TokenURL
 			providerConfig.ProfileURL = endpoints.UserInfoURL
+			if endpoints.DeviceAuthURL != "" {
+				providerConfig.DeviceAuthorizationURL = endpoints.DeviceAuthURL
+			}
+			if endpoints.RevocationURL != "" {
+				providerConfig.RevocationURL = endpoints.RevocationURL
+			}
+			if !providerConfig.OIDCConfig.SkipEndSessionOnLogout {
+				endSessionURL = endpoints.EndSessionURL
+			}
 			providerConfig.OIDCConfig.JwksURL = endpoints.JWKsURL
 			p.SupportedCodeChallengeMethods = pkce.CodeChallengeAlgs
 		}
@@ -115,11 +174,14 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 		dst **url.URL
 		raw string
 	}{
-		"login":    {dst: &p.LoginURL, raw: providerConfig.LoginURL},
-		"redeem":   {dst: &p.RedeemURL, raw: providerConfig.RedeemURL},
-		"profile":  {dst: &p.ProfileURL, raw: providerConfig.ProfileURL},
-		"validate": {dst: &p.ValidateURL, raw: providerConfig.ValidateURL},
-		"resource": {dst: &p.ProtectedResource, raw: providerConfig.ProtectedResource},
+		"login":                {dst: &p.LoginURL, raw: providerConfig.LoginURL},
+		"redeem":               {dst: &p.RedeemURL, raw: providerConfig.RedeemURL},
+		"profile":              {dst: &p.ProfileURL, raw: providerConfig.ProfileURL},
+		"validate":             {dst: &p.ValidateURL, raw: providerConfig.ValidateURL},
+		"resource":             {dst: &p.ProtectedResource, raw: providerConfig.ProtectedResource},
+		"device authorization": {dst: &p.DeviceAuthorizationURL, raw: providerConfig.DeviceAuthorizationURL},
+		"revocation":           {dst: &p.RevocationURL, raw: providerConfig.RevocationURL},
+		"end session":          {dst: &p.EndSessionURL, raw: endSessionURL},
 	} {
 		var err error
 		*u.dst, err = url.Parse(u.raw)
@@ -130,7 +192,58 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 	// handle LoginURLParameters
 	errs = append(errs, p.compileLoginParams(providerConfig.LoginURLParameters)...)
 
+	rules, err := authorization.NewRules(providerConfig.AuthorizationRules)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	p.AuthorizationRules = rules
+
+	if providerConfig.RequestObject != nil {
+		audience := providerConfig.RequestObject.Audience
+		if audience == "" {
+			audience = providerConfig.OIDCConfig.IssuerURL
+		}
+		if audience == "" {
+			audience = providerConfig.LoginURL
+		}
+		p.requestObjectSigner, err = newRequestObjectSigner(providerConfig.RequestObject, providerConfig.ClientID, audience)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if p.ClientSecret == "" && p.ClientSecretFile != "" {
+		p.watchClientSecretFile(providerConfig.ClientSecretFilePollInterval.Duration())
+	}
+
+	if providerConfig.AllowedGroupsFile != "" {
+		if err := p.watchAllowedGroupsFile(providerConfig.AllowedGroupsFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if providerConfig.ClientAssertion != nil {
+		signer, err := newClientAssertionSigner(providerConfig.ClientAssertion, providerConfig.ClientID)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			p.tokenClient = signer.client(p.getTokenClient())
+			p.clientAssertion = true
+		}
+	}
+
+	if providerConfig.RedeemRetries > 0 {
+		retryClient := requests.NewRetryClient(providerConfig.RedeemRetries, providerConfig.RedeemRetryDelay.Duration())
+		// Retries are made around the client assertion so that each attempt
+		// is sent with a fresh assertion
+		retryTransport := retryClient.Transport.(*requests.RetryTransport)
+		retryTransport.Transport = p.getTokenClient().Transport
+		retryTransport.Retryable = isRetryableTokenRequest
+		p.tokenClient = retryClient
+	}
+
 	if len(errs) > 0 {
+		p.StopWatching()
 		return nil, k8serrors.NewAggregate(errs)
 	}
 
@@ -138,6 +251,7 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 	p.AllowUnverifiedEmail = providerConfig.OIDCConfig.InsecureAllowUnverifiedEmail
 	p.EmailClaim = providerConfig.OIDCConfig.EmailClaim
 	p.GroupsClaim = providerConfig.OIDCConfig.GroupsClaim
+	p.UserClaims = providerConfig.OIDCConfig.UsernameClaims
 
 	// Set PKCE enabled or disabled based on discovery and force options
 	p.CodeChallengeMethod = parseCodeChallengeMethod(providerConfig)
@@ -159,7 +273,7 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 	if providerConfig.Type == "oidc" && p.Scope == "" {
 		p.Scope = "openid email profile"
 
-		if len(providerConfig.AllowedGroups) > 0 {
+		if len(providerConfig.AllowedGroups) > 0 || providerConfig.AllowedGroupsFile != "" {
 			p.Scope += " groups"
 		}
 	}
@@ -169,6 +283,44 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 	return p, nil
 }
 
+// isRetryableTokenRequest determines whether a call to the token redemption
+// endpoint should be retried. Refresh grants are retried after any transient
+// failure. Other grants, such as the redemption of single use authorization
+// and device codes, are only retried when the request never reached the
+// server, as a failed redemption may have already consumed the code.
+func isRetryableTokenRequest(req *http.Request, resp *http.Response, err error) bool {
+	if requests.IsConnectionError(err) {
+		return true
+	}
+	if tokenRequestGrantType(req) == "refresh_token" {
+		return requests.IsRetryable(resp, err)
+	}
+	return false
+}
+
+// tokenRequestGrantType reads the grant_type from the form body of a token
+// request without consuming the body.
+func tokenRequestGrantType(req *http.Request) string {
+	if req.GetBody == nil {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return ""
+	}
+	return values.Get("grant_type")
+}
+
 // Pick the most appropriate code challenge method for PKCE
 // At this time we do not consider what the server supports to be safe and
 // only enable PKCE if the user opts-in
@@ -1,10 +1,18 @@
 package providers
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/gomega"
 )
 
@@ -72,6 +80,200 @@ func TestClientSecretFileOption(t *testing.T) {
 	g.Expect(s).To(Equal("testcase"))
 }
 
+func TestClientSecretFileRotation(t *testing.T) {
+	testCases := map[string]time.Duration{
+		"with a file watch":             0,
+		"with a file watch and polling": 10 * time.Millisecond,
+	}
+
+	for name, pollInterval := range testCases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			clientSecretFileName := filepath.Join(t.TempDir(), "client-secret")
+			g.Expect(os.WriteFile(clientSecretFileName, []byte("initial"), 0600)).To(Succeed())
+
+			providerConfig := options.Provider{
+				ID:                           providerID,
+				Type:                         "google",
+				ClientID:                     clientID,
+				ClientSecretFile:             clientSecretFileName,
+				ClientSecretFilePollInterval: options.Duration(pollInterval),
+			}
+
+			p, err := newProviderDataFromConfig(providerConfig)
+			g.Expect(err).ToNot(HaveOccurred())
+			t.Cleanup(p.StopWatching)
+			g.Expect(p.GetClientSecret()).To(Equal("initial"))
+
+			g.Expect(os.WriteFile(clientSecretFileName, []byte("rotated"), 0600)).To(Succeed())
+			g.Eventually(p.GetClientSecret).Should(Equal("rotated"))
+		})
+	}
+}
+
+func TestClientSecretFilePolling(t *testing.T) {
+	g := NewWithT(t)
+
+	clientSecretFileName := filepath.Join(t.TempDir(), "client-secret")
+	g.Expect(os.WriteFile(clientSecretFileName, []byte("initial"), 0600)).To(Succeed())
+
+	p := &ProviderData{ClientSecretFile: clientSecretFileName, watchDone: make(chan bool)}
+	p.clock.Set(time.Unix(1234567890, 0))
+	secret := "initial"
+	p.fileClientSecret.Store(&secret)
+
+	stopped := make(chan struct{})
+	ticker := p.clock.Ticker(time.Minute)
+	go func() {
+		p.pollClientSecretFile(ticker)
+		close(stopped)
+	}()
+
+	g.Expect(os.WriteFile(clientSecretFileName, []byte("rotated"), 0600)).To(Succeed())
+	g.Consistently(p.GetClientSecret, 50*time.Millisecond).Should(Equal("initial"))
+	g.Expect(p.clock.Add(time.Minute)).To(Succeed())
+	g.Eventually(p.GetClientSecret).Should(Equal("rotated"))
+
+	p.StopWatching()
+	g.Eventually(stopped).Should(BeClosed())
+
+	// Stopping again is a no-op
+	p.StopWatching()
+}
+
+func TestClientSecretFileReloadKeepsSecretOnError(t *testing.T) {
+	g := NewWithT(t)
+
+	clientSecretFileName := filepath.Join(t.TempDir(), "client-secret")
+	g.Expect(os.WriteFile(clientSecretFileName, []byte("initial"), 0600)).To(Succeed())
+
+	p := &ProviderData{ClientSecretFile: clientSecretFileName}
+	secret := "initial"
+	p.fileClientSecret.Store(&secret)
+
+	g.Expect(os.Remove(clientSecretFileName)).To(Succeed())
+	p.reloadClientSecretFile()
+	g.Expect(p.GetClientSecret()).To(Equal("initial"))
+
+	g.Expect(os.WriteFile(clientSecretFileName, []byte("rotated"), 0600)).To(Succeed())
+	p.reloadClientSecretFile()
+	g.Expect(p.GetClientSecret()).To(Equal("rotated"))
+}
+
+func TestAllowedGroupsFileReload(t *testing.T) {
+	g := NewWithT(t)
+
+	allowedGroupsFileName := filepath.Join(t.TempDir(), "allowed-groups")
+	g.Expect(os.WriteFile(allowedGroupsFileName, []byte("# admins\nadmins\n\ncn=devs,ou=groups,dc=example,dc=com\n"), 0600)).To(Succeed())
+
+	providerConfig := options.Provider{
+		ID:                providerID,
+		Type:              "google",
+		ClientID:          clientID,
+		ClientSecret:      clientSecret,
+		AllowedGroups:     []string{"static"},
+		AllowedGroupsFile: allowedGroupsFileName,
+	}
+
+	p, err := newProviderDataFromConfig(providerConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	t.Cleanup(p.StopWatching)
+
+	authorized := func(group string) func() bool {
+		return func() bool {
+			ok, err := p.Authorize(context.Background(), &sessions.SessionState{Groups: []string{group}})
+			g.Expect(err).ToNot(HaveOccurred())
+			return ok
+		}
+	}
+
+	g.Expect(authorized("admins")()).To(BeTrue())
+	g.Expect(authorized("cn=devs,ou=groups,dc=example,dc=com")()).To(BeTrue())
+	g.Expect(authorized("static")()).To(BeTrue())
+	g.Expect(authorized("users")()).To(BeFalse())
+
+	// Replace the file the way a ConfigMap update does, so that the watcher
+	// never reads a partially written file
+	replaceFile := func(data string) {
+		tmpFileName := allowedGroupsFileName + ".tmp"
+		g.Expect(os.WriteFile(tmpFileName, []byte(data), 0600)).To(Succeed())
+		g.Expect(os.Rename(tmpFileName, allowedGroupsFileName)).To(Succeed())
+	}
+
+	replaceFile(`["users"]`)
+	g.Eventually(authorized("users")).Should(BeTrue())
+	g.Expect(authorized("admins")()).To(BeFalse())
+	g.Expect(authorized("static")()).To(BeTrue())
+
+	// An invalid file keeps the groups that were last loaded
+	replaceFile(`["admins"`)
+	g.Consistently(authorized("users"), 200*time.Millisecond).Should(BeTrue())
+
+	// An empty file still restricts logins
+	replaceFile("")
+	g.Eventually(authorized("users")).Should(BeFalse())
+	g.Expect(authorized("static")()).To(BeTrue())
+	g.Expect(p.Authorize(context.Background(), &sessions.SessionState{})).To(BeFalse())
+}
+
+func TestAllowedGroupsFileMissing(t *testing.T) {
+	g := NewWithT(t)
+
+	providerConfig := options.Provider{
+		ID:                providerID,
+		Type:              "google",
+		ClientID:          clientID,
+		ClientSecret:      clientSecret,
+		AllowedGroupsFile: filepath.Join(t.TempDir(), "missing"),
+	}
+
+	_, err := newProviderDataFromConfig(providerConfig)
+	g.Expect(err).To(MatchError(ContainSubstring("could not read allowed groups file")))
+}
+
+func TestReadAllowedGroupsFile(t *testing.T) {
+	testCases := map[string]struct {
+		data           string
+		expectedGroups []string
+		expectedErr    string
+	}{
+		"with one group per line": {
+			data:           "admins\n  devs  \r\n# comment\n\n",
+			expectedGroups: []string{"admins", "devs"},
+		},
+		"with a JSON array": {
+			data:           "\n[\"admins\", \"devs\"]\n",
+			expectedGroups: []string{"admins", "devs"},
+		},
+		"with an invalid JSON array": {
+			data:        `["admins",`,
+			expectedErr: "as a JSON array",
+		},
+		"with an empty file": {
+			data:           "",
+			expectedGroups: nil,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			filename := filepath.Join(t.TempDir(), "allowed-groups")
+			g.Expect(os.WriteFile(filename, []byte(tc.data), 0600)).To(Succeed())
+
+			groups, err := readAllowedGroupsFile(filename)
+			if tc.expectedErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(groups).To(Equal(tc.expectedGroups))
+		})
+	}
+}
+
 func TestSkipOIDCDiscovery(t *testing.T) {
 	g := NewWithT(t)
 	providerConfig := options.Provider{
@@ -270,3 +472,37 @@ func TestEmailClaimCorrectlySet(t *testing.T) {
 		})
 	}
 }
+
+func TestIsRetryableTokenRequest(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	resetErr := errors.New("connection reset by peer")
+	unavailable := &http.Response{StatusCode: http.StatusServiceUnavailable}
+
+	testCases := map[string]struct {
+		grantType string
+		resp      *http.Response
+		err       error
+		expected  bool
+	}{
+		"refresh grant with a 503":          {grantType: "refresh_token", resp: unavailable, expected: true},
+		"refresh grant with a reset":        {grantType: "refresh_token", err: resetErr, expected: true},
+		"refresh grant with a 200":          {grantType: "refresh_token", resp: &http.Response{StatusCode: http.StatusOK}},
+		"code grant with a 503":             {grantType: "authorization_code", resp: unavailable},
+		"code grant with a reset":           {grantType: "authorization_code", err: resetErr},
+		"code grant with a dial error":      {grantType: "authorization_code", err: dialErr, expected: true},
+		"device code grant with a 503":      {grantType: deviceCodeGrantType, resp: unavailable},
+		"token exchange grant with a 503":   {grantType: tokenExchangeGrantType, resp: unavailable},
+		"without a grant with a dial error": {err: dialErr, expected: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			req, err := http.NewRequest("POST", "https://idp.example.com/token", strings.NewReader("grant_type="+tc.grantType))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(isRetryableTokenRequest(req, tc.resp, tc.err)).To(Equal(tc.expected))
+		})
+	}
+}
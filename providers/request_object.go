@@ -0,0 +1,143 @@
+package providers
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+// requestObjectLifetime is how long a signed authorization request is valid
+// for, it only needs to last until the user is redirected to the provider
+const requestObjectLifetime = 5 * time.Minute
+
+// requestObjectSigner signs the authorization request parameters as a JWT
+// request object (RFC 9101)
+type requestObjectSigner struct {
+	method   jwt.SigningMethod
+	key      interface{}
+	keyID    string
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// newRequestObjectSigner loads the signing key for the request objects.
+// The issuer is the client ID and the audience is the provider's issuer.
+func newRequestObjectSigner(opts *options.RequestObjectOptions, clientID, audience string) (*requestObjectSigner, error) {
+	method, key, err := loadSigningKey("request object", opts.SigningAlgorithm, opts.SigningKey, opts.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &requestObjectSigner{
+		method:   method,
+		key:      key,
+		keyID:    opts.KeyID,
+		issuer:   clientID,
+		audience: audience,
+		now:      time.Now,
+	}, nil
+}
+
+// loadSigningKey loads the private key in PEM format from the key or the key
+// file for the JWS algorithm, one of RS256 (the default) or ES256.
+// The usage names what the key signs in the errors.
+func loadSigningKey(usage, algorithm, key, keyFile string) (jwt.SigningMethod, interface{}, error) {
+	var keyData []byte
+	switch {
+	case key != "" && keyFile != "":
+		return nil, nil, fmt.Errorf("cannot set both signingKey and signingKeyFile for %ss", usage)
+	case key != "":
+		keyData = []byte(key)
+	case keyFile != "":
+		var err error
+		keyData, err = os.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read %s signing key file: %v", usage, err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("%ss require a signingKey or signingKeyFile", usage)
+	}
+
+	var method jwt.SigningMethod
+	var signingKey interface{}
+	var err error
+	switch algorithm {
+	case "", options.RequestObjectSigningRS256:
+		method = jwt.SigningMethodRS256
+		signingKey, err = jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	case options.RequestObjectSigningES256:
+		method = jwt.SigningMethodES256
+		signingKey, err = parseES256PrivateKey(keyData)
+	default:
+		return nil, nil, fmt.Errorf("unsupported %s signing algorithm %q: must be one of %q or %q",
+			usage, algorithm, options.RequestObjectSigningRS256, options.RequestObjectSigningES256)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse %s signing key: %v", usage, err)
+	}
+	return method, signingKey, nil
+}
+
+func parseES256PrivateKey(keyData []byte) (*ecdsa.PrivateKey, error) {
+	key, err := jwt.ParseECPrivateKeyFromPEM(keyData)
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve.Params().Name != "P-256" {
+		return nil, fmt.Errorf("ES256 requires a P-256 key, got %s", key.Curve.Params().Name)
+	}
+	return key, nil
+}
+
+// requestParams signs the authorization request parameters and returns the
+// parameters for the login URL. Only the parameters that OpenID Connect
+// requires to be in the query are sent alongside the request object.
+func (s *requestObjectSigner) requestParams(params url.Values) (url.Values, error) {
+	now := s.now()
+	jti, err := encryption.Nonce(16)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	for name, values := range params {
+		if len(values) == 1 {
+			claims[name] = values[0]
+		} else {
+			claims[name] = values
+		}
+	}
+	claims["iss"] = s.issuer
+	claims["aud"] = s.audience
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(requestObjectLifetime).Unix()
+	claims["jti"] = fmt.Sprintf("%x", jti)
+
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.keyID != "" {
+		token.Header["kid"] = s.keyID
+	}
+	token.Header["typ"] = "oauth-authz-req+jwt"
+
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing request object: %v", err)
+	}
+
+	requestParams := url.Values{}
+	for _, name := range []string{"client_id", "response_type", "scope"} {
+		if values, ok := params[name]; ok {
+			requestParams[name] = values
+		}
+	}
+	requestParams.Set("request", signed)
+	return requestParams, nil
+}
@@ -0,0 +1,195 @@
+package providers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRSAKeyPEM(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, string(keyPEM)
+}
+
+func newECKeyPEM(t *testing.T, curve elliptic.Curve) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return key, string(keyPEM)
+}
+
+func TestRequestObjectLoginURL(t *testing.T) {
+	rsaKey, rsaKeyPEM := newRSAKeyPEM(t)
+	ecKey, ecKeyPEM := newECKeyPEM(t, elliptic.P256())
+
+	testCases := map[string]struct {
+		opts      options.RequestObjectOptions
+		publicKey interface{}
+		method    jwt.SigningMethod
+	}{
+		"RS256 by default": {
+			opts: options.RequestObjectOptions{
+				SigningKey: rsaKeyPEM,
+			},
+			publicKey: &rsaKey.PublicKey,
+			method:    jwt.SigningMethodRS256,
+		},
+		"ES256 with a key ID": {
+			opts: options.RequestObjectOptions{
+				SigningAlgorithm: options.RequestObjectSigningES256,
+				SigningKey:       ecKeyPEM,
+				KeyID:            "key-1",
+			},
+			publicKey: &ecKey.PublicKey,
+			method:    jwt.SigningMethodES256,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"}, false)
+			signer, err := newRequestObjectSigner(&tc.opts, provider.ClientID, "https://issuer.example.com")
+			require.NoError(t, err)
+			now := time.Now()
+			signer.now = func() time.Time { return now }
+			provider.requestObjectSigner = signer
+
+			rawLoginURL, err := provider.GetLoginURL("https://my.test.app/oauth2/callback", "state", "nonce", url.Values{})
+			require.NoError(t, err)
+			loginURL, err := url.Parse(rawLoginURL)
+			require.NoError(t, err)
+
+			query := loginURL.Query()
+			assert.Equal(t, []string{"client_id", "request", "response_type", "scope"}, sortedKeys(query))
+			assert.Equal(t, provider.ClientID, query.Get("client_id"))
+			assert.Equal(t, "code", query.Get("response_type"))
+			assert.Equal(t, provider.Scope, query.Get("scope"))
+
+			token, err := jwt.Parse(query.Get("request"), func(token *jwt.Token) (interface{}, error) {
+				return tc.publicKey, nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.method, token.Method)
+			assert.Equal(t, "oauth-authz-req+jwt", token.Header["typ"])
+			if tc.opts.KeyID != "" {
+				assert.Equal(t, tc.opts.KeyID, token.Header["kid"])
+			} else {
+				assert.NotContains(t, token.Header, "kid")
+			}
+
+			claims := token.Claims.(jwt.MapClaims)
+			assert.Equal(t, provider.ClientID, claims["client_id"])
+			assert.Equal(t, "code", claims["response_type"])
+			assert.Equal(t, "https://my.test.app/oauth2/callback", claims["redirect_uri"])
+			assert.Equal(t, provider.Scope, claims["scope"])
+			assert.Equal(t, "state", claims["state"])
+			assert.Equal(t, "nonce", claims["nonce"])
+			assert.Equal(t, provider.ClientID, claims["iss"])
+			assert.Equal(t, "https://issuer.example.com", claims["aud"])
+			assert.Equal(t, float64(now.Unix()), claims["iat"])
+			assert.Equal(t, float64(now.Add(requestObjectLifetime).Unix()), claims["exp"])
+			assert.NotEmpty(t, claims["jti"])
+		})
+	}
+}
+
+func TestRequestObjectLoginURLSigningError(t *testing.T) {
+	rsaKey, _ := newRSAKeyPEM(t)
+
+	provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"}, false)
+	provider.requestObjectSigner = &requestObjectSigner{
+		method:   jwt.SigningMethodES256,
+		key:      rsaKey,
+		issuer:   provider.ClientID,
+		audience: "https://issuer.example.com",
+		now:      time.Now,
+	}
+
+	loginURL, err := provider.GetLoginURL("https://my.test.app/oauth2/callback", "state", "nonce", url.Values{})
+	assert.ErrorContains(t, err, "error creating the authorization request object")
+	assert.Empty(t, loginURL)
+}
+
+func sortedKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestNewRequestObjectSigner(t *testing.T) {
+	_, rsaKeyPEM := newRSAKeyPEM(t)
+	_, p384KeyPEM := newECKeyPEM(t, elliptic.P384())
+
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte(rsaKeyPEM), 0600))
+
+	testCases := map[string]struct {
+		opts          options.RequestObjectOptions
+		expectedError string
+	}{
+		"with a signing key": {
+			opts: options.RequestObjectOptions{SigningKey: rsaKeyPEM},
+		},
+		"with a signing key file": {
+			opts: options.RequestObjectOptions{SigningKeyFile: keyFile},
+		},
+		"with both a signing key and signing key file": {
+			opts:          options.RequestObjectOptions{SigningKey: rsaKeyPEM, SigningKeyFile: keyFile},
+			expectedError: "cannot set both signingKey and signingKeyFile for request objects",
+		},
+		"without a signing key": {
+			opts:          options.RequestObjectOptions{},
+			expectedError: "request objects require a signingKey or signingKeyFile",
+		},
+		"with a missing signing key file": {
+			opts:          options.RequestObjectOptions{SigningKeyFile: filepath.Join(t.TempDir(), "missing.pem")},
+			expectedError: "could not read request object signing key file",
+		},
+		"with an unsupported algorithm": {
+			opts:          options.RequestObjectOptions{SigningAlgorithm: "HS256", SigningKey: rsaKeyPEM},
+			expectedError: `unsupported request object signing algorithm "HS256": must be one of "RS256" or "ES256"`,
+		},
+		"with an EC key for RS256": {
+			opts:          options.RequestObjectOptions{SigningKey: p384KeyPEM},
+			expectedError: "could not parse request object signing key",
+		},
+		"with a P-384 key for ES256": {
+			opts:          options.RequestObjectOptions{SigningAlgorithm: options.RequestObjectSigningES256, SigningKey: p384KeyPEM},
+			expectedError: "could not parse request object signing key: ES256 requires a P-256 key, got P-384",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			signer, err := newRequestObjectSigner(&tc.opts, "client", "https://issuer.example.com")
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				assert.Nil(t, signer)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, signer)
+		})
+	}
+}
@@ -34,7 +34,7 @@ func makeOIDCHeader(accessToken string) http.Header {
 	return makeAuthorizationHeader(tokenTypeBearer, accessToken, extraHeaders)
 }
 
-func makeLoginURL(p *ProviderData, redirectURI, state string, extraParams url.Values) url.URL {
+func makeLoginURL(p *ProviderData, redirectURI, state string, extraParams url.Values) (url.URL, error) {
 	a := *p.LoginURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
@@ -47,8 +47,15 @@ func makeLoginURL(p *ProviderData, redirectURI, state string, extraParams url.Va
 			params.Add(n, v)
 		}
 	}
+	if p.requestObjectSigner != nil {
+		requestParams, err := p.requestObjectSigner.requestParams(params)
+		if err != nil {
+			return url.URL{}, fmt.Errorf("error creating the authorization request object: %v", err)
+		}
+		params = requestParams
+	}
 	a.RawQuery = params.Encode()
-	return a
+	return a, nil
 }
 
 // getIDToken extracts an IDToken stored in the `Extra` fields of an
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
-	"io"
+	"encoding/json"
+	"fmt"
 	"os"
 	"strings"
 	"sync/atomic"
@@ -44,33 +46,52 @@ func (um *UserMap) IsValid(email string) (result bool) {
 }
 
 // LoadAuthenticatedEmailsFile loads the authenticated emails file from disk
-// and parses the contents as CSV
+// and parses the contents as a JSON array of emails, or as CSV with the email
+// in the first column
 func (um *UserMap) LoadAuthenticatedEmailsFile() {
-	r, err := os.Open(um.usersFile)
+	data, err := os.ReadFile(um.usersFile)
 	if err != nil {
 		logger.Fatalf("failed opening authenticated-emails-file=%q, %s", um.usersFile, err)
 	}
-	defer func(c io.Closer) {
-		cerr := c.Close()
-		if cerr != nil {
-			logger.Fatalf("Error closing authenticated emails file: %s", cerr)
+
+	emails, err := parseAuthenticatedEmails(data)
+	if err != nil {
+		logger.Errorf("error reading authenticated-emails-file=%q, %s", um.usersFile, err)
+		return
+	}
+	updated := make(map[string]bool)
+	for _, email := range emails {
+		address := strings.ToLower(strings.TrimSpace(email))
+		updated[address] = true
+	}
+	atomic.StorePointer(&um.m, unsafe.Pointer(&updated)) // #nosec G103
+	logger.Printf("loaded %d emails from authenticated-emails-file=%q", len(updated), um.usersFile)
+}
+
+// parseAuthenticatedEmails parses a JSON array of emails when the data starts
+// with `[`, otherwise the first column of each CSV record is the email.
+func parseAuthenticatedEmails(data []byte) ([]string, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var emails []string
+		if err := json.Unmarshal(trimmed, &emails); err != nil {
+			return nil, fmt.Errorf("could not parse JSON array: %v", err)
 		}
-	}(r)
-	csvReader := csv.NewReader(r)
+		return emails, nil
+	}
+
+	csvReader := csv.NewReader(bytes.NewReader(data))
 	csvReader.Comma = ','
 	csvReader.Comment = '#'
 	csvReader.TrimLeadingSpace = true
 	records, err := csvReader.ReadAll()
 	if err != nil {
-		logger.Errorf("error reading authenticated-emails-file=%q, %s", um.usersFile, err)
-		return
+		return nil, err
 	}
-	updated := make(map[string]bool)
+	emails := make([]string, 0, len(records))
 	for _, r := range records {
-		address := strings.ToLower(strings.TrimSpace(r[0]))
-		updated[address] = true
+		emails = append(emails, r[0])
 	}
-	atomic.StorePointer(&um.m, unsafe.Pointer(&updated)) // #nosec G103
+	return emails, nil
 }
 
 func newValidatorImpl(domains []string, usersFile string,
@@ -109,22 +130,49 @@ func NewValidator(domains []string, usersFile string) func(string) bool {
 }
 
 // isEmailValidWithDomains checks if the authenticated email is validated against the provided domain
+// Domains prefixed with `.` or `*.` allow any subdomain of the given domain,
+// matched at a label boundary so `*.example.com` allows `us.example.com` but
+// not `evil-example.com`.
 func isEmailValidWithDomains(email string, allowedDomains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return false
+	}
+	emailDomain := email[at+1:]
+
 	for _, domain := range allowedDomains {
 		// allow if the domain is perfect suffix match with the email
-		if strings.HasSuffix(email, "@"+domain) {
+		if emailDomain == domain {
 			return true
 		}
 
 		// allow if the domain is prefixed with . or *. and
-		// the last element (split on @) has the suffix as the domain
-		atoms := strings.Split(email, "@")
-
-		if (strings.HasPrefix(domain, ".") && strings.HasSuffix(atoms[len(atoms)-1], domain)) ||
-			(strings.HasPrefix(domain, "*.") && strings.HasSuffix(atoms[len(atoms)-1], domain[1:])) {
+		// the email domain is a subdomain of it
+		suffix := ""
+		switch {
+		case strings.HasPrefix(domain, "*."):
+			suffix = domain[1:]
+		case strings.HasPrefix(domain, "."):
+			suffix = domain
+		}
+		if suffix != "" && isSubdomain(emailDomain, suffix) {
 			return true
 		}
 	}
 
 	return false
 }
+
+// isSubdomain checks the domain ends with the suffix (which starts with `.`)
+// and that the remaining subdomain labels are not empty.
+func isSubdomain(domain, suffix string) bool {
+	if len(suffix) < 2 || !strings.HasSuffix(domain, suffix) {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(domain, suffix), ".") {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -137,6 +137,75 @@ func TestValidatorOverwriteEmailListDirectly(t *testing.T) {
 	}
 }
 
+func TestValidatorOverwriteEmailListJSON(t *testing.T) {
+	vt := NewValidatorTest(t)
+	defer vt.TearDown()
+
+	vt.WriteEmails(t, []string{`["xyzzy@example.com", "Plugh@example.com"]`})
+	updated := make(chan bool)
+	validator := vt.NewValidator([]string(nil), updated)
+
+	g := NewWithT(t)
+	g.Expect(validator("xyzzy@example.com")).To(BeTrue())
+	g.Expect(validator("plugh@example.com")).To(BeTrue())
+	g.Expect(validator("xyzzy.plugh@example.com")).To(BeFalse())
+
+	vt.WriteEmails(t, []string{`[`, `  "xyzzy.plugh@example.com",`, `  "plugh@example.com"`, `]`})
+	<-updated
+
+	g.Expect(validator("xyzzy@example.com")).To(BeFalse())
+	g.Expect(validator("plugh@example.com")).To(BeTrue())
+	g.Expect(validator("xyzzy.plugh@example.com")).To(BeTrue())
+}
+
+func TestParseAuthenticatedEmails(t *testing.T) {
+	testCases := []struct {
+		name           string
+		data           string
+		expectedEmails []string
+		expectedErr    bool
+	}{
+		{
+			name:           "newline delimited",
+			data:           "xyzzy@example.com\n# comment\nplugh@example.com\n",
+			expectedEmails: []string{"xyzzy@example.com", "plugh@example.com"},
+		},
+		{
+			name:           "csv with extra columns",
+			data:           "xyzzy@example.com,Xyzzy\nplugh@example.com,Plugh\n",
+			expectedEmails: []string{"xyzzy@example.com", "plugh@example.com"},
+		},
+		{
+			name:           "json array",
+			data:           "\n[\"xyzzy@example.com\", \"plugh@example.com\"]\n",
+			expectedEmails: []string{"xyzzy@example.com", "plugh@example.com"},
+		},
+		{
+			name:        "invalid json array",
+			data:        "[\"xyzzy@example.com\",",
+			expectedErr: true,
+		},
+		{
+			name:           "empty",
+			data:           "",
+			expectedEmails: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			emails, err := parseAuthenticatedEmails([]byte(tc.data))
+			if tc.expectedErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(emails).To(Equal(tc.expectedEmails))
+		})
+	}
+}
+
 func TestValidatorCases(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -419,3 +488,126 @@ func TestValidatorCases(t *testing.T) {
 		})
 	}
 }
+
+func TestIsEmailValidWithDomains(t *testing.T) {
+	testCases := []struct {
+		name           string
+		email          string
+		allowedDomains []string
+		expected       bool
+	}{
+		{
+			name:           "ExactDomain",
+			email:          "foo@example.com",
+			allowedDomains: []string{"example.com"},
+			expected:       true,
+		},
+		{
+			name:           "ExactDomainDoesNotAllowSubdomains",
+			email:          "foo@us.example.com",
+			allowedDomains: []string{"example.com"},
+			expected:       false,
+		},
+		{
+			name:           "WildcardSubdomain",
+			email:          "foo@us.example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       true,
+		},
+		{
+			name:           "WildcardNestedSubdomain",
+			email:          "foo@eu.west.example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       true,
+		},
+		{
+			name:           "WildcardDoesNotMatchApex",
+			email:          "foo@example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       false,
+		},
+		{
+			name:           "WildcardAnchoredAtLabelBoundary",
+			email:          "foo@evil-example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       false,
+		},
+		{
+			name:           "WildcardLabelSuffix",
+			email:          "foo@fooevil.com",
+			allowedDomains: []string{"*.evil.com"},
+			expected:       false,
+		},
+		{
+			name:           "WildcardLabelPrefix",
+			email:          "foo@foo.evil.com",
+			allowedDomains: []string{"*.evil.com"},
+			expected:       true,
+		},
+		{
+			name:           "WildcardEmptyLabel",
+			email:          "foo@.example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       false,
+		},
+		{
+			name:           "WildcardEmptyNestedLabel",
+			email:          "foo@us..example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       false,
+		},
+		{
+			name:           "DotPrefixSubdomain",
+			email:          "foo@us.example.com",
+			allowedDomains: []string{".example.com"},
+			expected:       true,
+		},
+		{
+			name:           "DotPrefixAnchoredAtLabelBoundary",
+			email:          "foo@evil-example.com",
+			allowedDomains: []string{".example.com"},
+			expected:       false,
+		},
+		{
+			name:           "MultipleDomains",
+			email:          "foo@eu.example.org",
+			allowedDomains: []string{"*.example.com", "*.example.org"},
+			expected:       true,
+		},
+		{
+			name:           "BareWildcardIsNotADomain",
+			email:          "foo@example.com",
+			allowedDomains: []string{"*."},
+			expected:       false,
+		},
+		{
+			name:           "MissingLocalPart",
+			email:          "@us.example.com",
+			allowedDomains: []string{"*.example.com"},
+			expected:       false,
+		},
+		{
+			name:           "MissingDomain",
+			email:          "foo@",
+			allowedDomains: []string{"*.example.com"},
+			expected:       false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(isEmailValidWithDomains(tc.email, tc.allowedDomains)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestValidatorAllowAll(t *testing.T) {
+	vt := NewValidatorTest(t)
+	defer vt.TearDown()
+
+	g := NewWithT(t)
+	validator := vt.NewValidator([]string{"*.example.com", "*"}, nil)
+	g.Expect(validator("foo@evil-example.com")).To(BeTrue())
+	g.Expect(validator("")).To(BeFalse())
+}